@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yarlson/ftl/pkg/app"
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/console"
+	"github.com/yarlson/ftl/pkg/deployment"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Manage dependency backups",
+	Long: `Manage the backups of dependencies. ftl deploy backs up a dependency
+before replacing its container; PostgreSQL and MySQL are backed up by
+default and other dependencies with the backup section of ftl.yaml.`,
+}
+
+var backupRunCmd = &cobra.Command{
+	Use:   "run DEPENDENCY",
+	Short: "Back up a dependency now",
+	Long: `Run the backup command of a dependency in its container and write the
+backup to ~/projects/<project>/backups on the server. The oldest backups
+beyond the retention of the dependency are removed.`,
+	Args:        cobra.ExactArgs(1),
+	Run:         runBackupRun,
+	Annotations: map[string]string{annotationCancellable: "true"},
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	backupCmd.AddCommand(backupRunCmd)
+}
+
+func runBackupRun(cmd *cobra.Command, args []string) {
+	if err := backupDependency(cmd.Context(), args[0]); err != nil {
+		console.Error("Backup failed:", err)
+		return
+	}
+	console.Success(fmt.Sprintf("Dependency %s backed up", args[0]))
+}
+
+// backupDependency connects to the server and backs up the dependency named name.
+func backupDependency(ctx context.Context, name string) error {
+	cfg, err := parseConfig("ftl.yaml")
+	if err != nil {
+		return err
+	}
+	dependency := findDependency(cfg, name)
+	if dependency == nil {
+		return fmt.Errorf("dependency %s is not defined in ftl.yaml", name)
+	}
+
+	renderer := newEventRenderer(cfg.Server.Host, false)
+	defer renderer.close()
+
+	step := deployment.StartLocalStep(renderer.render, "connect", "Connecting to server")
+	runner, err := app.Connect(cfg.Server)
+	if err != nil {
+		step.Fail(fmt.Sprintf("Failed to connect to server %s", cfg.Server.Host), err)
+		return fmt.Errorf("failed to connect to server %s: %w", cfg.Server.Host, err)
+	}
+	defer runner.Close()
+	step.Complete()
+
+	return renderEvents(renderer, deployment.NewDeployment(runner, nil).Backup(ctx, cfg.Project.Name, dependency))
+}
+
+func findDependency(cfg *config.Config, name string) *config.Dependency {
+	for i := range cfg.Dependencies {
+		if cfg.Dependencies[i].Name == name {
+			return &cfg.Dependencies[i]
+		}
+	}
+	return nil
+}
@@ -1,16 +1,14 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
-	"sync"
+	"sort"
 
 	"github.com/spf13/cobra"
 
+	"github.com/yarlson/ftl/pkg/app"
 	"github.com/yarlson/ftl/pkg/build"
-	"github.com/yarlson/ftl/pkg/config"
 	"github.com/yarlson/ftl/pkg/console"
-	"github.com/yarlson/ftl/pkg/runner/local"
 )
 
 var buildCmd = &cobra.Command{
@@ -40,77 +38,44 @@ func runBuild(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	runner := local.NewRunner()
-	builder := build.NewBuild(runner)
-	sm := console.NewSpinnerManager()
-
-	ctx := context.Background()
-
-	if err := buildAndPushServices(ctx, cfg.Project.Name, cfg.Services, builder, skipPush, sm); err != nil {
+	a := app.New(cfg)
+	renderer := newEventRenderer("", false)
+	err = renderEvents(renderer, a.Build(cmd.Context(), app.BuildOptions{SkipPush: skipPush}))
+	renderer.close()
+	printImageReports(a.ImageReports())
+	if err != nil {
 		console.Error("Build process failed:", err)
 		return
 	}
 }
 
-// buildAndPushServices builds and pushes all services concurrently.
-func buildAndPushServices(ctx context.Context, project string, services []config.Service, builder *build.Build, skipPush bool, sm *console.SpinnerManager) error {
-	var wg sync.WaitGroup
-	errChan := make(chan error, len(services))
-
-	// Start the spinner manager
-	sm.Start()
-	defer sm.Stop()
-
-	for _, svc := range services {
-		wg.Add(1)
-		go func(svc config.Service) {
-			defer wg.Done()
-			serviceName := svc.Name
-			image := svc.Image
-			if image == "" {
-				image = fmt.Sprintf("%s-%s", project, serviceName)
+// printImageReports prints the size, the largest layers and the size delta of every built image.
+func printImageReports(reports []app.ImageReport) {
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Current.Image < reports[j].Current.Image
+	})
+
+	for _, r := range reports {
+		delta := "first build"
+		if r.Previous != nil {
+			diff := r.Current.Size - r.Previous.Size
+			sign := "+"
+			if diff < 0 {
+				sign = ""
 			}
+			delta = fmt.Sprintf("%s%s since last build", sign, build.FormatBytes(diff))
+		}
 
-			// Create build spinner
-			spinner := sm.AddSpinner(fmt.Sprintf("build-%s", serviceName), fmt.Sprintf("Building service %s", serviceName))
-
-			// Build service
-			if err := builder.Build(ctx, image, svc.Path); err != nil {
-				spinner.ErrorWithMessagef("Failed to build service %s: %v", serviceName, err)
-				errChan <- fmt.Errorf("failed to build service %s: %w", serviceName, err)
-				return
-			}
-			spinner.Complete()
-
-			// Skip push if requested or if using local image
-			if skipPush || svc.Image == "" {
-				return
-			}
-
-			// Create push spinner
-			spinner = sm.AddSpinner(fmt.Sprintf("push-%s", serviceName), fmt.Sprintf("Pushing service %s", serviceName))
-
-			// Push service
-			if err := builder.Push(ctx, svc.Image); err != nil {
-				spinner.ErrorWithMessagef("Failed to push service %s: %v", serviceName, err)
-				errChan <- fmt.Errorf("failed to push service %s: %w", serviceName, err)
-				return
-			}
-			spinner.Complete()
-		}(svc)
-	}
-
-	wg.Wait()
-	close(errChan)
-
-	var errs []error
-	for err := range errChan {
-		errs = append(errs, err)
+		console.Info(fmt.Sprintf("Image %s: %s (%s)", r.Current.Image, build.FormatBytes(r.Current.Size), delta))
+		for _, layer := range r.Current.TopLayers(5) {
+			console.Info(fmt.Sprintf("  %10s  %s", build.FormatBytes(layer.Size), truncate(layer.CreatedBy, 80)))
+		}
 	}
+}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("errors occurred during build/push: %v", errs)
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
 	}
-
-	return nil
+	return s[:length-3] + "..."
 }
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yarlson/ftl/pkg/app"
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/console"
+	"github.com/yarlson/ftl/pkg/deployment"
+)
+
+var promoteCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Finish a canary deployment by sending all requests to the new containers",
+	Long: `Finish the canary deployment started with ftl deploy --canary: all
+requests go to the new containers and the containers they replace
+are removed.`,
+	Args:        cobra.NoArgs,
+	Run:         runPromote,
+	Annotations: map[string]string{annotationCancellable: "true"},
+}
+
+var abortCmd = &cobra.Command{
+	Use:   "abort",
+	Short: "Cancel a canary deployment and remove its new containers",
+	Long: `Cancel the canary deployment started with ftl deploy --canary: all
+requests go to the running containers again and the new containers
+are removed.`,
+	Args:        cobra.NoArgs,
+	Run:         runAbort,
+	Annotations: map[string]string{annotationCancellable: "true"},
+}
+
+func init() {
+	rootCmd.AddCommand(promoteCmd)
+	rootCmd.AddCommand(abortCmd)
+}
+
+func runPromote(cmd *cobra.Command, args []string) {
+	if err := finishCanary(cmd.Context(), (*deployment.Deployment).Promote); err != nil {
+		console.Error("Promotion failed:", err)
+		return
+	}
+	console.Success("Canary deployment promoted")
+}
+
+func runAbort(cmd *cobra.Command, args []string) {
+	if err := finishCanary(cmd.Context(), (*deployment.Deployment).Abort); err != nil {
+		console.Error("Abort failed:", err)
+		return
+	}
+	console.Success("Canary deployment aborted")
+}
+
+// finishCanary connects to the server and promotes or aborts the canary deployment in progress with finish.
+func finishCanary(ctx context.Context, finish func(*deployment.Deployment, context.Context, string, *config.Config) <-chan deployment.Event) error {
+	cfg, err := parseConfig("ftl.yaml")
+	if err != nil {
+		return err
+	}
+
+	renderer := newEventRenderer(cfg.Server.Host, false)
+	defer renderer.close()
+
+	step := deployment.StartLocalStep(renderer.render, "connect", "Connecting to server")
+	runner, err := app.Connect(cfg.Server)
+	if err != nil {
+		step.Fail(fmt.Sprintf("Failed to connect to server %s", cfg.Server.Host), err)
+		return fmt.Errorf("failed to connect to server %s: %w", cfg.Server.Host, err)
+	}
+	defer runner.Close()
+	step.Complete()
+
+	return renderEvents(renderer, finish(deployment.NewDeployment(runner, nil), ctx, cfg.Project.Name, cfg))
+}
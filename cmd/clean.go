@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yarlson/ftl/pkg/build"
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/console"
+	"github.com/yarlson/ftl/pkg/imagesync"
+)
+
+var cleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Remove old images extracted for syncing",
+	Long: `Remove images extracted for image sync from the local store that weren't
+synced recently, and the local stores left behind by interrupted deployments.
+Only directories that look like ftl image stores are touched.`,
+	Run: runClean,
+}
+
+func init() {
+	rootCmd.AddCommand(cleanCmd)
+	cleanCmd.Flags().Duration("max-age", 7*24*time.Hour, "Remove images that weren't synced for longer than this")
+	cleanCmd.Flags().String("max-size", "10G", "Remove the least recently synced images until the store fits in this size")
+	cleanCmd.Flags().String("store", imagesync.DefaultLocalStore(), "Local image store to clean")
+	cleanCmd.Flags().Bool("dry-run", false, "Show what would be removed without removing it")
+}
+
+func runClean(cmd *cobra.Command, args []string) {
+	maxAge, err := cmd.Flags().GetDuration("max-age")
+	if err != nil {
+		console.Error("Failed to get max-age flag:", err)
+		return
+	}
+	maxSizeFlag, err := cmd.Flags().GetString("max-size")
+	if err != nil {
+		console.Error("Failed to get max-size flag:", err)
+		return
+	}
+	maxSize, err := config.ParseSize(maxSizeFlag)
+	if err != nil {
+		console.Error("Invalid max-size flag:", err)
+		return
+	}
+	store, err := cmd.Flags().GetString("store")
+	if err != nil {
+		console.Error("Failed to get store flag:", err)
+		return
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		console.Error("Failed to get dry-run flag:", err)
+		return
+	}
+
+	verb := "Removed"
+	if dryRun {
+		verb = "Would remove"
+	}
+
+	var freed int64
+	var removed int
+
+	ok, err := imagesync.IsStore(store)
+	switch {
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		console.Error(fmt.Sprintf("Failed to read image store %s:", store), err)
+		return
+	case err == nil && !ok:
+		console.Warning(fmt.Sprintf("Skipping %s: not an ftl image store", store))
+	case ok:
+		pruned, err := imagesync.Prune(store, imagesync.PrunePolicy{MaxAge: maxAge, MaxSize: maxSize.Bytes(), DryRun: dryRun}, time.Now())
+		for _, image := range pruned {
+			console.Info(fmt.Sprintf("%s %s (%s)", verb, image.Path, build.FormatBytes(image.Size)))
+			freed += image.Size
+			removed++
+		}
+		if err != nil {
+			console.Error("Failed to clean image store:", err)
+			return
+		}
+	}
+
+	// Stores of deployments that were killed before they could remove them.
+	stores, err := imagesync.TempStores()
+	if err != nil {
+		console.Error("Failed to list temporary image stores:", err)
+		return
+	}
+	for _, tmp := range stores {
+		if time.Since(tmp.Modified) < maxAge {
+			continue
+		}
+		if !dryRun {
+			if err := imagesync.RemoveStore(tmp.Path); err != nil {
+				console.Warning(fmt.Sprintf("Skipping %s: %v", tmp.Path, err))
+				continue
+			}
+		}
+		console.Info(fmt.Sprintf("%s %s (%s)", verb, tmp.Path, build.FormatBytes(tmp.Size)))
+		freed += tmp.Size
+		removed++
+	}
+
+	if removed == 0 {
+		console.Success("Nothing to clean")
+		return
+	}
+	if dryRun {
+		console.Success(fmt.Sprintf("Would free %s", build.FormatBytes(freed)))
+		return
+	}
+	console.Success(fmt.Sprintf("Freed %s", build.FormatBytes(freed)))
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/console"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with the ftl.yaml configuration",
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of ftl.yaml",
+	Long: `Print the JSON Schema of ftl.yaml, for autocompletion and validation
+in editors:
+
+  ftl config schema > ftl.schema.json`,
+	Args: cobra.NoArgs,
+	Run:  runConfigSchema,
+}
+
+var configRenderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Print the configuration as ftl parses it",
+	Long: `Print ftl.yaml the way deploy sees it: environment variables expanded,
+short notation dependencies filled in with their defaults, the volumes
+derived from the mounts and the overrides of --env applied. Empty fields
+are left out, and the values of environment variables whose name contains
+PASSWORD, SECRET, TOKEN or KEY, and registry passwords, are shown as ***.`,
+	Args: cobra.NoArgs,
+	Run:  runConfigRender,
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configSchemaCmd)
+	configCmd.AddCommand(configRenderCmd)
+	configRenderCmd.Flags().String("service", "", "Print only the service or dependency with this name")
+	configRenderCmd.Flags().Bool("json", false, "Print JSON instead of YAML")
+	configRenderCmd.Flags().BoolVar(&lenientConfig, "lenient", false, "Ignore unknown fields in ftl.yaml")
+}
+
+func runConfigSchema(cmd *cobra.Command, args []string) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(config.Schema()); err != nil {
+		console.Error("Failed to write the schema:", err)
+		os.Exit(1)
+	}
+}
+
+func runConfigRender(cmd *cobra.Command, args []string) {
+	name, err := cmd.Flags().GetString("service")
+	if err != nil {
+		console.Error("Failed to get service flag:", err)
+		os.Exit(1)
+	}
+	asJSON, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		console.Error("Failed to get json flag:", err)
+		os.Exit(1)
+	}
+
+	cfg, err := parseConfig("ftl.yaml")
+	if err != nil {
+		console.Error("Failed to parse config file:", err)
+		os.Exit(1)
+	}
+
+	node, err := config.Render(cfg, name)
+	if err != nil {
+		console.Error("Failed to render the configuration:", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		var value any
+		if err = node.Decode(&value); err == nil {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(value)
+		}
+	} else {
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		if err = encoder.Encode(node); err == nil {
+			err = encoder.Close()
+		}
+	}
+	if err != nil {
+		console.Error("Failed to write the configuration:", err)
+		os.Exit(1)
+	}
+}
@@ -2,18 +2,21 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/yarlson/ftl/pkg/app"
 	"github.com/yarlson/ftl/pkg/config"
 	"github.com/yarlson/ftl/pkg/console"
 	"github.com/yarlson/ftl/pkg/deployment"
-	"github.com/yarlson/ftl/pkg/imagesync"
-	"github.com/yarlson/ftl/pkg/runner/remote"
-	"github.com/yarlson/ftl/pkg/ssh"
+	"github.com/yarlson/ftl/pkg/metrics"
+	"github.com/yarlson/ftl/pkg/notify"
 )
 
 var deployCmd = &cobra.Command{
@@ -22,11 +25,28 @@ var deployCmd = &cobra.Command{
 	Long: `Deploy your application to the server defined in ftl.yaml.
 This command handles the entire deployment process, ensuring
 zero-downtime updates of your services.`,
-	Run: runDeploy,
+	Run:         runDeploy,
+	Annotations: map[string]string{annotationCancellable: "true"},
 }
 
 func init() {
 	rootCmd.AddCommand(deployCmd)
+	deployCmd.Flags().Bool("force-unlock", false, "Remove an existing deployment lock before deploying")
+	deployCmd.Flags().Bool("allow-dependency-restart", false, "Stop dependencies with data volumes when they have to be updated, without asking")
+	deployCmd.Flags().Bool("recreate-network", false, "Recreate the project network when its subnet differs from ftl.yaml, reconnecting its containers")
+	deployCmd.Flags().Bool("json", false, "Print deployment events as JSON lines instead of spinners")
+	deployCmd.Flags().Bool("keep-artifacts", false, "Keep the local image store of a failed deployment for inspection")
+	deployCmd.Flags().BoolVar(&lenientConfig, "lenient", false, "Ignore unknown fields in ftl.yaml instead of failing")
+	deployCmd.Flags().Int("canary", 0, "Send this percentage of requests to the new containers until ftl promote or ftl abort")
+	deployCmd.Flags().Bool("no-notify", false, "Don't send the notifications configured in ftl.yaml")
+	deployCmd.Flags().Bool("skip-preflight", false, "Skip the disk space checks of the server before syncing images")
+}
+
+// deployOptions holds the deploy command flags.
+type deployOptions struct {
+	app.DeployOptions
+	json     bool
+	noNotify bool
 }
 
 func runDeploy(cmd *cobra.Command, args []string) {
@@ -36,79 +56,274 @@ func runDeploy(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	sm := console.NewSpinnerManager()
-	sm.Start()
-
-	if err := deployToServer(cfg.Project.Name, cfg, cfg.Server, sm); err != nil {
-		sm.Stop()
-		console.Error("Deployment failed:", err)
+	var opts deployOptions
+	opts.ForceUnlock, err = cmd.Flags().GetBool("force-unlock")
+	if err != nil {
+		console.Error("Failed to get force-unlock flag:", err)
+		return
+	}
+	opts.AllowDependencyRestart, err = cmd.Flags().GetBool("allow-dependency-restart")
+	if err != nil {
+		console.Error("Failed to get allow-dependency-restart flag:", err)
+		return
+	}
+	opts.RecreateNetwork, err = cmd.Flags().GetBool("recreate-network")
+	if err != nil {
+		console.Error("Failed to get recreate-network flag:", err)
+		return
+	}
+	opts.json, err = cmd.Flags().GetBool("json")
+	if err != nil {
+		console.Error("Failed to get json flag:", err)
+		return
+	}
+	opts.KeepArtifacts, err = cmd.Flags().GetBool("keep-artifacts")
+	if err != nil {
+		console.Error("Failed to get keep-artifacts flag:", err)
+		return
+	}
+	opts.Canary, err = cmd.Flags().GetInt("canary")
+	if err != nil {
+		console.Error("Failed to get canary flag:", err)
+		return
+	}
+	if opts.Canary < 0 || opts.Canary > 99 {
+		console.Error(fmt.Sprintf("Invalid --canary %d: the percentage must be between 1 and 99", opts.Canary))
+		return
+	}
+	opts.noNotify, err = cmd.Flags().GetBool("no-notify")
+	if err != nil {
+		console.Error("Failed to get no-notify flag:", err)
+		return
+	}
+	opts.SkipPreflight, err = cmd.Flags().GetBool("skip-preflight")
+	if err != nil {
+		console.Error("Failed to get skip-preflight flag:", err)
 		return
 	}
 
-	sm.Stop()
-	console.Success("Deployment completed successfully")
+	report := newDeployReport(cmd.Context(), cfg, opts.noNotify)
+	report.start()
+
+	for {
+		renderer := newEventRenderer(cfg.Server.Host, opts.json)
+		err := deployToServer(cmd.Context(), cfg, opts.DeployOptions, reportingRenderer{renderer, report}, report.metrics)
+		renderer.close()
+
+		var restartErr *deployment.DependencyRestartError
+		if errors.As(err, &restartErr) && !opts.AllowDependencyRestart && !opts.json {
+			allow, promptErr := confirmDependencyRestart(restartErr.Dependencies)
+			if promptErr != nil {
+				report.finish(err, opts.json)
+				console.Error("Failed to read answer:", promptErr)
+				return
+			}
+			if allow {
+				opts.AllowDependencyRestart = true
+				continue
+			}
+		}
+
+		report.finish(err, opts.json)
+
+		if err != nil && opts.json {
+			// The error was printed with the finished event; fail the CI step.
+			os.Exit(1)
+		}
+		if err != nil && cmd.Context().Err() != nil {
+			printDeployError("Deployment cancelled:", err)
+			return
+		}
+		if err != nil {
+			printDeployError("Deployment failed:", err)
+			return
+		}
+		break
+	}
+
+	if !opts.json {
+		console.Success("Deployment completed successfully")
+		if opts.Canary > 0 {
+			console.Info("Run ftl promote to send all requests to the new containers, or ftl abort to remove them.")
+		}
+	}
 }
 
-func parseConfig(filename string) (*config.Config, error) {
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+// deployReport sends the notifications and exports the metrics of a deployment.
+type deployReport struct {
+	notifier *notify.Notifier
+	metrics  *metrics.Recorder
+	export   config.Metrics
+}
+
+// newDeployReport returns the report of a deployment of cfg. With noNotify, no notifications
+// are sent.
+func newDeployReport(ctx context.Context, cfg *config.Config, noNotify bool) *deployReport {
+	webhooks := cfg.Notifications
+	if noNotify {
+		webhooks = nil
+	}
+	return &deployReport{
+		notifier: notify.New(webhooks, notify.Summary{
+			Project:     cfg.Project.Name,
+			Environment: cfg.Environment,
+			Host:        cfg.Server.Host,
+			GitSHA:      localGitSHA(ctx),
+		}),
+		metrics: metrics.New(cfg.Project.Name, cfg.Environment),
+		export:  cfg.Deploy.Metrics,
 	}
+}
 
-	cfg, err := config.ParseConfig(data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+func (r *deployReport) start() {
+	r.notifier.Start()
+	r.metrics.Start()
+}
+
+// finish sends the outcome of the deployment and exports its metrics. Webhooks that couldn't
+// be notified and metrics that couldn't be exported are warned about, without changing the
+// outcome. With JSON output, the warnings go to stderr so the events stay the only output.
+func (r *deployReport) finish(err error, asJSON bool) {
+	r.metrics.Finish(err)
+
+	warn := console.Warning
+	if asJSON {
+		warn = func(a ...interface{}) { fmt.Fprintln(os.Stderr, a...) }
+	}
+	if notifyErr := r.notifier.Finish(err); notifyErr != nil {
+		warn("Failed to send notifications:", notifyErr)
+	}
+	if exportErr := r.metrics.Export(r.export); exportErr != nil {
+		warn("Failed to export metrics:", exportErr)
 	}
+}
 
-	return cfg, nil
+// reportingRenderer passes the events of a deployment to its report before showing them.
+type reportingRenderer struct {
+	eventRenderer
+	report *deployReport
 }
 
-func deployToServer(project string, cfg *config.Config, server config.Server, sm *console.SpinnerManager) error {
-	hostname := server.Host
+func (r reportingRenderer) render(event deployment.Event) {
+	r.report.notifier.Observe(event)
+	r.report.metrics.Observe(event)
+	r.eventRenderer.render(event)
+}
 
-	// Connect to server
-	spinner := sm.AddSpinner(fmt.Sprintf("connect-%s", hostname), fmt.Sprintf("[%s] Connecting to server", hostname))
-	runner, err := connectToServer(server)
-	if err != nil {
-		spinner.ErrorWithMessagef("Failed to connect to server %s: %v", hostname, err)
-		return fmt.Errorf("failed to connect to server %s: %w", hostname, err)
+// localGitSHA returns the commit checked out in the working directory, or "" outside a git
+// repository.
+func localGitSHA(ctx context.Context) string {
+	output, err := exec.CommandContext(ctx, "git", "rev-parse", "HEAD").Output()
+	sha := strings.TrimSpace(string(output))
+	if err != nil || len(sha) != 40 {
+		return ""
 	}
-	defer runner.Close()
-	spinner.Complete()
+	return sha
+}
 
-	// Create temp directory for docker sync
-	spinner = sm.AddSpinner(fmt.Sprintf("setup-%s", hostname), fmt.Sprintf("[%s] Setting up deployment", hostname))
-	localStore, err := os.MkdirTemp("", "dockersync-local")
+// printDeployError prints the error of a deployment. When services failed, it lists each
+// failure with the last lines logged by containers that didn't become healthy, followed by
+// the services that were skipped or succeeded.
+func printDeployError(message string, err error) {
+	var deployErr *deployment.DeployError
+	if !errors.As(err, &deployErr) {
+		console.Error(message, err)
+		return
+	}
+
+	console.Error(message)
+	for _, failure := range deployErr.Failed {
+		console.Info(fmt.Sprintf("%s failed (%s): %v", failure.Service, failure.Phase, failure.Err))
+
+		var healthErr *deployment.HealthCheckError
+		if errors.As(failure.Err, &healthErr) && healthErr.Logs != "" {
+			console.Info(fmt.Sprintf("  Last lines logged by %s:", healthErr.Container))
+			console.Muted("      " + strings.ReplaceAll(healthErr.Logs, "\n", "\n      "))
+		}
+	}
+	if len(deployErr.Skipped) > 0 {
+		console.Info("Skipped: " + strings.Join(deployErr.Skipped, ", "))
+	}
+	if len(deployErr.Succeeded) > 0 {
+		console.Info("Succeeded: " + strings.Join(deployErr.Succeeded, ", "))
+	}
+}
+
+// confirmDependencyRestart asks whether the dependencies may be stopped to update them.
+func confirmDependencyRestart(dependencies []string) (bool, error) {
+	console.Warning(fmt.Sprintf("Updating %s requires stopping it; it is unavailable until the new container has started.", strings.Join(dependencies, ", ")))
+	console.Input("Restart and continue the deployment? [y/N]:")
+	answer, err := console.ReadLine()
 	if err != nil {
-		spinner.ErrorWithMessagef("Failed to create local store: %v", err)
-		return fmt.Errorf("failed to create local store: %w", err)
+		return false, err
 	}
 
-	// Initialize image syncer and deployment
-	syncer := imagesync.NewImageSync(imagesync.Config{
-		LocalStore:  localStore,
-		MaxParallel: 1,
-	}, runner)
-	deploy := deployment.NewDeployment(runner, syncer, sm)
-	spinner.Complete()
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// lenientConfig makes parseConfig ignore unknown fields. It is set by the --lenient flag.
+var lenientConfig bool
+
+// parseOptions returns the options the configuration is parsed with, set by the flags.
+func parseOptions() config.ParseOptions {
+	return config.ParseOptions{Lenient: lenientConfig, Environment: environment}
+}
+
+func parseConfig(filename string) (*config.Config, error) {
+	return parseEnvironmentConfig(filename, environment)
+}
 
-	// Start deployment
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// parseEnvironmentConfig parses the configuration file with the overrides of the named
+// environment instead of the one selected by --env.
+func parseEnvironmentConfig(filename, env string) (*config.Config, error) {
+	path, err := findConfig(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
 
-	if err := deploy.Deploy(ctx, project, cfg); err != nil {
-		return err
+	opts := parseOptions()
+	opts.Dir = filepath.Dir(path)
+	opts.Environment = env
+	cfg, err := config.ParseConfigWithOptions(data, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
-	return nil
+	return cfg, nil
 }
 
-func connectToServer(server config.Server) (*remote.Runner, error) {
-	sshKeyPath := filepath.Join(os.Getenv("HOME"), ".ssh", filepath.Base(server.SSHKey))
-	sshClient, _, err := ssh.FindKeyAndConnectWithUser(server.Host, server.Port, server.User, sshKeyPath)
+// findConfig looks for the configuration file in the working directory and its parents and
+// changes into the directory holding it, so the relative paths of the configuration, such as
+// service paths and volume sources, are resolved against that directory.
+func findConfig(filename string) (string, error) {
+	path, err := config.FindFile(".", filename)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	return remote.NewRunner(sshClient), nil
+	dir := filepath.Dir(path)
+	if err := os.Chdir(dir); err != nil {
+		return "", fmt.Errorf("failed to change to directory %s: %w", dir, err)
+	}
+	return path, nil
+}
+
+// deployToServer deploys cfg to its server. The bytes of image layers synced to the server are
+// added to recorder, if set.
+func deployToServer(ctx context.Context, cfg *config.Config, opts app.DeployOptions, renderer eventRenderer, recorder *metrics.Recorder) error {
+	a := app.New(cfg)
+	err := renderEvents(renderer, a.Deploy(ctx, opts))
+	if recorder != nil {
+		recorder.AddSynced(a.Transferred())
+	}
+	return err
 }
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/chelnak/ysmrr"
+
+	"github.com/yarlson/ftl/pkg/console"
+	"github.com/yarlson/ftl/pkg/deployment"
+)
+
+// eventRenderer shows the events of a deployment.
+type eventRenderer interface {
+	render(event deployment.Event)
+	close()
+}
+
+// newEventRenderer returns a renderer that prints JSON lines when asJSON is set and shows
+// spinners otherwise. The messages of the spinners are prefixed with host, unless it is empty.
+func newEventRenderer(host string, asJSON bool) eventRenderer {
+	if asJSON {
+		return &jsonRenderer{host: host, encoder: json.NewEncoder(os.Stdout)}
+	}
+
+	sm := console.NewSpinnerManager()
+	sm.Start()
+	return &spinnerRenderer{host: host, sm: sm, spinners: make(map[string]*ysmrr.Spinner)}
+}
+
+// spinnerRenderer shows every deployment step as a spinner.
+type spinnerRenderer struct {
+	host     string
+	sm       *console.SpinnerManager
+	spinners map[string]*ysmrr.Spinner
+}
+
+func (r *spinnerRenderer) render(event deployment.Event) {
+	message := event.Message
+	if r.host != "" {
+		message = fmt.Sprintf("[%s] %s", r.host, event.Message)
+	}
+	if event.Err != nil && (event.Type == deployment.EventFailed || event.Type == deployment.EventWarning) {
+		message = fmt.Sprintf("%s: %v", message, event.Err)
+	}
+
+	switch event.Type {
+	case deployment.EventStarted:
+		r.spinners[event.Step] = r.sm.AddSpinner(event.Step, message)
+	case deployment.EventCompleted:
+		r.spinner(event.Step, message).CompleteWithMessage(message)
+	case deployment.EventFailed:
+		r.spinner(event.Step, message).ErrorWithMessage(message)
+	case deployment.EventWarning:
+		r.sm.AddSpinner(event.Step, message).ErrorWithMessage(message)
+	}
+}
+
+// spinner returns the spinner of step, adding one for steps whose start wasn't shown.
+func (r *spinnerRenderer) spinner(step, message string) *ysmrr.Spinner {
+	if s, ok := r.spinners[step]; ok {
+		return s
+	}
+	s := r.sm.AddSpinner(step, message)
+	r.spinners[step] = s
+	return s
+}
+
+func (r *spinnerRenderer) close() {
+	r.sm.Stop()
+}
+
+// jsonRenderer prints every deployment event as a line of JSON.
+type jsonRenderer struct {
+	host    string
+	encoder *json.Encoder
+}
+
+// jsonEvent is the JSON form of a deployment event.
+type jsonEvent struct {
+	Type    deployment.EventType `json:"type"`
+	Host    string               `json:"host"`
+	Step    string               `json:"step,omitempty"`
+	Service string               `json:"service,omitempty"`
+	Message string               `json:"message,omitempty"`
+	Error   string               `json:"error,omitempty"`
+	Changed bool                 `json:"changed,omitempty"`
+	// Phases holds the seconds the phases of a service or dependency step took.
+	Phases  map[deployment.Phase]float64 `json:"phases,omitempty"`
+	Started time.Time                    `json:"started"`
+	Time    time.Time                    `json:"time"`
+}
+
+func (r *jsonRenderer) render(event deployment.Event) {
+	out := jsonEvent{
+		Type:    event.Type,
+		Host:    r.host,
+		Step:    event.Step,
+		Service: event.Service,
+		Message: event.Message,
+		Changed: event.Changed,
+		Started: event.Started,
+		Time:    event.Time,
+	}
+	if event.Err != nil {
+		out.Error = event.Err.Error()
+	}
+	for phase, duration := range event.Phases {
+		if out.Phases == nil {
+			out.Phases = make(map[deployment.Phase]float64)
+		}
+		out.Phases[phase] = duration.Seconds()
+	}
+	_ = r.encoder.Encode(out)
+}
+
+func (r *jsonRenderer) close() {}
+
+// renderEvents shows events until the channel is closed and returns the error carried by the
+// EventFinished event.
+func renderEvents(renderer eventRenderer, events <-chan deployment.Event) error {
+	var err error
+	for event := range events {
+		renderer.render(event)
+		if event.Type == deployment.EventFinished {
+			err = event.Err
+		}
+	}
+	return err
+}
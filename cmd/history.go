@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yarlson/ftl/pkg/app"
+	"github.com/yarlson/ftl/pkg/console"
+	"github.com/yarlson/ftl/pkg/deployment"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the recorded deployments of the project",
+	Long: `List the deployments recorded on the server, newest first. Every
+successful deployment records the images it deployed, which
+ftl rollback --to ID deploys again.`,
+	Args: cobra.NoArgs,
+	Run:  runHistory,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+}
+
+func runHistory(cmd *cobra.Command, args []string) {
+	cfg, err := parseConfig("ftl.yaml")
+	if err != nil {
+		console.Error("Failed to parse config file:", err)
+		return
+	}
+
+	runner, err := app.Connect(cfg.Server)
+	if err != nil {
+		console.Error(fmt.Sprintf("Failed to connect to server %s:", cfg.Server.Host), err)
+		return
+	}
+	defer runner.Close()
+
+	manifests, err := deployment.Manifests(context.Background(), runner, cfg.Project.Name)
+	if err != nil {
+		console.Error("Failed to read deployment history:", err)
+		return
+	}
+	if len(manifests) == 0 {
+		console.Info(fmt.Sprintf("No deployments of %s are recorded on server %s", cfg.Project.Name, cfg.Server.Host))
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tDEPLOYED\tBY\tGIT\tIMAGES")
+	for _, manifest := range manifests {
+		gitSHA := "-"
+		if manifest.GitSHA != "" {
+			gitSHA = shortID(manifest.GitSHA, 7)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", manifest.ID, manifest.Time.Local().Format(time.DateTime),
+			manifest.DeployedBy, gitSHA, manifestImages(manifest))
+	}
+	_ = w.Flush()
+}
+
+// manifestImages lists the short image IDs of the services of a manifest by service name.
+func manifestImages(manifest deployment.Manifest) string {
+	var images []string
+	for name, image := range manifest.Services {
+		images = append(images, fmt.Sprintf("%s=%s", name, shortID(strings.TrimPrefix(image.ID, "sha256:"), 12)))
+	}
+	sort.Strings(images)
+	return strings.Join(images, " ")
+}
+
+func shortID(id string, length int) string {
+	if len(id) > length {
+		return id[:length]
+	}
+	return id
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yarlson/ftl/pkg/app"
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/console"
+	"github.com/yarlson/ftl/pkg/jobs"
+)
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Manage scheduled jobs",
+	Long: `Manage the scheduled jobs defined in the jobs section of ftl.yaml.
+Jobs are installed on the server by ftl deploy.`,
+}
+
+var jobsRunCmd = &cobra.Command{
+	Use:   "run NAME",
+	Short: "Run a scheduled job now",
+	Long: `Run a scheduled job on the server right away and stream its output.
+The run is recorded as the job's last run, like a scheduled one.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runJobsRun,
+}
+
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List scheduled jobs and their last runs",
+	Run:   runJobsList,
+}
+
+func init() {
+	rootCmd.AddCommand(jobsCmd)
+	jobsCmd.AddCommand(jobsRunCmd)
+	jobsCmd.AddCommand(jobsListCmd)
+}
+
+func runJobsRun(cmd *cobra.Command, args []string) {
+	cfg, err := parseConfig("ftl.yaml")
+	if err != nil {
+		console.Error("Failed to parse config file:", err)
+		return
+	}
+
+	name := args[0]
+	if findJob(cfg, name) == nil {
+		console.Error(fmt.Sprintf("Job %s is not defined in ftl.yaml", name))
+		return
+	}
+
+	runner, err := app.Connect(cfg.Server)
+	if err != nil {
+		console.Error(fmt.Sprintf("Failed to connect to server %s:", cfg.Server.Host), err)
+		return
+	}
+	defer runner.Close()
+
+	console.Info(fmt.Sprintf("Running job %s on server %s...", name, cfg.Server.Host))
+	status, err := jobs.Run(context.Background(), runner, cfg.Project.Name, name, os.Stdout)
+	if err != nil {
+		console.Error("Failed to run job:", err)
+		return
+	}
+
+	if status.ExitCode != 0 {
+		console.Error(fmt.Sprintf("Job %s failed with exit status %d", name, status.ExitCode))
+		return
+	}
+	console.Success(fmt.Sprintf("Job %s finished in %s", name, status.Finished.Sub(status.Started)))
+}
+
+func runJobsList(cmd *cobra.Command, args []string) {
+	cfg, err := parseConfig("ftl.yaml")
+	if err != nil {
+		console.Error("Failed to parse config file:", err)
+		return
+	}
+
+	runner, err := app.Connect(cfg.Server)
+	if err != nil {
+		console.Error(fmt.Sprintf("Failed to connect to server %s:", cfg.Server.Host), err)
+		return
+	}
+	defer runner.Close()
+
+	statuses, err := jobs.Statuses(context.Background(), runner, cfg.Project.Name)
+	if err != nil {
+		console.Warning(fmt.Sprintf("Failed to read job statuses: %v", err))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tSCHEDULE\tLAST RUN\tSTATUS")
+	for _, job := range cfg.Jobs {
+		lastRun, result := "-", "never run"
+		if status, ok := statuses[job.Name]; ok {
+			lastRun = status.Started.Local().Format(time.DateTime)
+			result = fmt.Sprintf("exit %d", status.ExitCode)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", job.Name, job.Schedule, lastRun, result)
+	}
+	_ = w.Flush()
+}
+
+func findJob(cfg *config.Config, name string) *config.Job {
+	for i := range cfg.Jobs {
+		if cfg.Jobs[i].Name == name {
+			return &cfg.Jobs[i]
+		}
+	}
+	return nil
+}
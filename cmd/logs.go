@@ -6,14 +6,20 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/yarlson/ftl/pkg/app"
 	"github.com/yarlson/ftl/pkg/config"
 	"github.com/yarlson/ftl/pkg/console"
 	"github.com/yarlson/ftl/pkg/logs"
 )
 
 var (
-	follow bool
-	tail   int
+	follow    bool
+	tail      int
+	since     string
+	until     string
+	output    string
+	grep      string
+	accessLog bool
 )
 
 // logsCmd represents the logs command
@@ -21,8 +27,10 @@ var logsCmd = &cobra.Command{
 	Use:   "logs [service]",
 	Short: "Fetch logs from remote deployment",
 	Long: `Fetch logs from the specified service running on remote server.
-If no service is specified, logs from all services will be fetched.
-Use the -f flag to stream logs in real-time.`,
+If no service is specified, logs from all services, dependencies,
+the proxy and the certificate manager will be fetched.
+Use the -f flag to stream logs in real-time, and --access-log to show
+the requests served by the proxy with their status and duration.`,
 	Args: cobra.MaximumNArgs(1),
 	Run:  runLogs,
 }
@@ -31,6 +39,11 @@ func init() {
 	rootCmd.AddCommand(logsCmd)
 	logsCmd.Flags().BoolVarP(&follow, "follow", "f", false, "Stream logs in real-time")
 	logsCmd.Flags().IntVarP(&tail, "tail", "n", -1, "Number of lines to show from the end of the logs")
+	logsCmd.Flags().StringVar(&since, "since", "", "Show logs since a relative duration (e.g. 1h) or timestamp (e.g. 2024-05-01T10:00:00Z)")
+	logsCmd.Flags().StringVar(&until, "until", "", "Show logs before a relative duration (e.g. 30m) or timestamp")
+	logsCmd.Flags().StringVarP(&output, "output", "o", logs.OutputText, "Output format: text or json")
+	logsCmd.Flags().StringVar(&grep, "grep", "", "Only show lines whose message matches the regular expression")
+	logsCmd.Flags().BoolVar(&accessLog, "access-log", false, "Only show the access log of the proxy, as method, path, status and duration")
 }
 
 func runLogs(cmd *cobra.Command, args []string) {
@@ -39,23 +52,45 @@ func runLogs(cmd *cobra.Command, args []string) {
 		serviceName = args[0]
 	}
 
+	if accessLog && serviceName != "" && serviceName != logs.ProxyService {
+		console.Error(fmt.Sprintf("--access-log shows the access log of the proxy; drop %s or use ftl logs %s", serviceName, logs.ProxyService))
+		return
+	}
+	if accessLog {
+		serviceName = logs.ProxyService
+	}
+
 	if follow && !cmd.Flags().Lookup("tail").Changed {
 		tail = 100
 	}
 
+	opts := logs.FetchOptions{
+		Follow:    follow,
+		Tail:      tail,
+		Since:     since,
+		Until:     until,
+		Output:    output,
+		Grep:      grep,
+		AccessLog: accessLog,
+	}
+	if err := opts.Validate(); err != nil {
+		console.Error("Invalid log filter:", err)
+		return
+	}
+
 	cfg, err := parseConfig("ftl.yaml")
 	if err != nil {
 		console.Error("Failed to parse config file:", err)
 		return
 	}
 
-	if err := getLogs(cfg, serviceName, follow, tail); err != nil {
+	if err := getLogs(cfg, serviceName, opts); err != nil {
 		console.Error("Failed to fetch logs:", err)
 		return
 	}
 }
 
-func getLogs(cfg *config.Config, serviceName string, follow bool, tail int) error {
+func getLogs(cfg *config.Config, serviceName string, opts logs.FetchOptions) error {
 	services := []string{}
 
 	if serviceName != "" {
@@ -64,11 +99,17 @@ func getLogs(cfg *config.Config, serviceName string, follow bool, tail int) erro
 		for _, service := range cfg.Services {
 			services = append(services, service.Name)
 		}
+		for _, dependency := range cfg.Dependencies {
+			services = append(services, dependency.Name)
+		}
+		services = append(services, logs.ProxyService, "zero")
 	}
 
-	console.Info(fmt.Sprintf("Fetching logs from server %s...", cfg.Server.Host))
+	if opts.Output != logs.OutputJSON {
+		console.Info(fmt.Sprintf("Fetching logs from server %s...", cfg.Server.Host))
+	}
 
-	runner, err := connectToServer(cfg.Server)
+	runner, err := app.Connect(cfg.Server)
 	if err != nil {
 		return fmt.Errorf("failed to connect to server %s: %v", cfg.Server.Host, err)
 	}
@@ -77,7 +118,7 @@ func getLogs(cfg *config.Config, serviceName string, follow bool, tail int) erro
 	logger := logs.NewLogger(runner)
 	ctx := context.Background()
 
-	if err := logger.FetchLogs(ctx, cfg.Project.Name, services, follow, tail); err != nil {
+	if err := logger.FetchLogs(ctx, cfg.Project.Name, services, opts); err != nil {
 		return fmt.Errorf("failed to fetch logs from server %s: %v", cfg.Server.Host, err)
 	}
 
@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yarlson/ftl/pkg/app"
+	"github.com/yarlson/ftl/pkg/build"
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/console"
+	"github.com/yarlson/ftl/pkg/deployment"
+	"github.com/yarlson/ftl/pkg/runner/remote"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Move the project to another server",
+	Long: `Move the project from the server in ftl.yaml to another server: check
+that the new server is set up, copy the named volumes, deploy the
+project there and print what is left to move the traffic.
+
+Volumes already copied by an interrupted migration to the same server
+are skipped. The containers on the current server keep running unless
+--stop-source is given.`,
+	Run:         runMigrate,
+	Annotations: map[string]string{annotationCancellable: "true"},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.Flags().String("to", "", "Host of the server to move the project to")
+	migrateCmd.Flags().String("to-env", "", "Move the project to the server of this environment from the environments section of ftl.yaml")
+	migrateCmd.Flags().Int("to-port", 0, "SSH port of the new server (default: server.port)")
+	migrateCmd.Flags().String("to-user", "", "User on the new server (default: server.user)")
+	migrateCmd.Flags().String("to-ssh-key", "", "SSH key for the new server (default: server.ssh_key)")
+	migrateCmd.Flags().Bool("stop-source", false, "Stop the containers using a volume on the current server before copying it, and leave them stopped")
+	migrateCmd.Flags().Bool("fresh", false, "Copy every volume again, replacing the volumes on the new server")
+	migrateCmd.MarkFlagsMutuallyExclusive("to", "to-env")
+	migrateCmd.MarkFlagsOneRequired("to", "to-env")
+}
+
+// migrateOptions holds the migrate command flags.
+type migrateOptions struct {
+	stopSource bool
+	fresh      bool
+}
+
+func runMigrate(cmd *cobra.Command, args []string) {
+	cfg, err := parseConfig("ftl.yaml")
+	if err != nil {
+		console.Error("Failed to parse config file:", err)
+		return
+	}
+	target, err := migrationTarget(cmd, cfg)
+	if err != nil {
+		console.Error(err)
+		return
+	}
+
+	var opts migrateOptions
+	opts.stopSource, err = cmd.Flags().GetBool("stop-source")
+	if err != nil {
+		console.Error("Failed to get stop-source flag:", err)
+		return
+	}
+	opts.fresh, err = cmd.Flags().GetBool("fresh")
+	if err != nil {
+		console.Error("Failed to get fresh flag:", err)
+		return
+	}
+
+	if err := migrate(cmd.Context(), cfg, target, opts); err != nil {
+		message := "Migration failed:"
+		if cmd.Context().Err() != nil {
+			message = "Migration cancelled:"
+		}
+		printDeployError(message, err)
+		console.Info("Run ftl migrate again to continue; volumes already copied are skipped.")
+	}
+}
+
+// migrationTarget returns the server to move the project to, given by --to-env or by --to and
+// the flags overriding the SSH settings of the current server.
+func migrationTarget(cmd *cobra.Command, cfg *config.Config) (config.Server, error) {
+	var target config.Server
+	if env, _ := cmd.Flags().GetString("to-env"); env != "" {
+		envCfg, err := parseEnvironmentConfig("ftl.yaml", env)
+		if err != nil {
+			return target, fmt.Errorf("failed to parse config file for environment %s: %w", env, err)
+		}
+		target = envCfg.Server
+	} else {
+		target = cfg.Server
+		target.Host, _ = cmd.Flags().GetString("to")
+	}
+
+	if port, _ := cmd.Flags().GetInt("to-port"); port != 0 {
+		target.Port = port
+	}
+	if user, _ := cmd.Flags().GetString("to-user"); user != "" {
+		target.User = user
+	}
+	if key, _ := cmd.Flags().GetString("to-ssh-key"); key != "" {
+		target.SSHKey = key
+	}
+
+	if target.Host == cfg.Server.Host && target.Port == cfg.Server.Port {
+		return target, fmt.Errorf("the project already runs on %s; pass the host of the new server", target.Host)
+	}
+	return target, nil
+}
+
+// migrate moves the project of cfg from its server to target.
+func migrate(ctx context.Context, cfg *config.Config, target config.Server, opts migrateOptions) error {
+	source := cfg.Server
+
+	console.Info(fmt.Sprintf("Connecting to %s and %s...", source.Host, target.Host))
+	sourceRunner, err := app.Connect(source)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server %s: %w", source.Host, err)
+	}
+	defer sourceRunner.Close()
+	targetRunner, err := app.Connect(target)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server %s: %w", target.Host, err)
+	}
+	defer targetRunner.Close()
+
+	console.Info(fmt.Sprintf("Checking server %s:", target.Host))
+	if !checkMigrationTarget(ctx, targetRunner, target, cfg.Project.ProxyPorts()) {
+		return fmt.Errorf("server %s is not ready; run ftl setup for it, e.g. with an environment overriding server.host", target.Host)
+	}
+
+	state, err := deployment.LoadMigrationState(cfg.Project.Name, target.Host)
+	if err != nil {
+		return err
+	}
+	if opts.fresh {
+		if err := state.Reset(); err != nil {
+			return err
+		}
+	}
+
+	stopped, err := copyVolumes(ctx, cfg, sourceRunner, targetRunner, state, opts)
+	if err != nil {
+		return err
+	}
+
+	// The new server doesn't take traffic yet, so dependencies may be restarted without asking.
+	targetCfg := *cfg
+	targetCfg.Server = target
+	renderer := newEventRenderer(target.Host, false)
+	err = deployToServer(ctx, &targetCfg, app.DeployOptions{AllowDependencyRestart: true}, renderer, nil)
+	renderer.close()
+	if err != nil {
+		return fmt.Errorf("failed to deploy to %s: %w", target.Host, err)
+	}
+
+	printCutoverChecklist(ctx, cfg, target, stopped, opts.stopSource)
+	return nil
+}
+
+// checkMigrationTarget checks that the project can be deployed to the new server and prints
+// a checklist. It reports whether every check passed.
+func checkMigrationTarget(ctx context.Context, runner *remote.Runner, server config.Server, ports []int) bool {
+	checks := []check{
+		checkDocker(ctx, runner),
+		checkDockerGroup(ctx, runner, server.User),
+	}
+	for _, port := range ports {
+		checks = append(checks, checkPortReachable(server.Host, port))
+	}
+
+	passed := true
+	for _, c := range checks {
+		c.print()
+		passed = passed && c.ok
+	}
+	return passed
+}
+
+// copyVolumes copies the volumes of the project that the state doesn't record as copied and
+// returns the containers stopped on the current server.
+func copyVolumes(ctx context.Context, cfg *config.Config, source, target *remote.Runner, state *deployment.MigrationState, opts migrateOptions) ([]string, error) {
+	var stopped []string
+	for _, name := range cfg.Volumes {
+		volume := cfg.Project.Name + "-" + name
+		if state.Copied(volume) {
+			console.Info(fmt.Sprintf("Volume %s was copied before, skipping it", volume))
+			continue
+		}
+
+		exists, err := deployment.VolumeExists(ctx, source, volume)
+		if err != nil {
+			return stopped, err
+		}
+		if !exists {
+			console.Info(fmt.Sprintf("Volume %s doesn't exist on %s, skipping it", volume, cfg.Server.Host))
+			continue
+		}
+		exists, err = deployment.VolumeExists(ctx, target, volume)
+		if err != nil {
+			return stopped, err
+		}
+		// A volume whose copy was interrupted is replaced by the new copy.
+		if exists && !opts.fresh && state.Copying != volume {
+			return stopped, fmt.Errorf("volume %s already exists on the new server; run with --fresh to replace it", volume)
+		}
+
+		containers, err := deployment.VolumeContainers(ctx, source, volume)
+		if err != nil {
+			return stopped, err
+		}
+		if len(containers) > 0 && opts.stopSource {
+			console.Info(fmt.Sprintf("Stopping %s on %s...", strings.Join(containers, ", "), cfg.Server.Host))
+			if err := deployment.StopContainers(ctx, source, containers); err != nil {
+				return stopped, fmt.Errorf("failed to stop the containers of volume %s: %w", volume, err)
+			}
+			stopped = append(stopped, containers...)
+		} else if len(containers) > 0 {
+			console.Warning(fmt.Sprintf("%s keep using volume %s during the copy; changes made after it aren't copied, pass --stop-source to stop them first",
+				strings.Join(containers, ", "), volume))
+		}
+
+		console.Info(fmt.Sprintf("Copying volume %s...", volume))
+		if err := state.MarkCopying(volume); err != nil {
+			return stopped, err
+		}
+		size, err := deployment.CopyVolume(ctx, source, target, volume, "")
+		if err != nil {
+			return stopped, err
+		}
+		if err := state.MarkCopied(volume); err != nil {
+			return stopped, err
+		}
+		console.Success(fmt.Sprintf("Volume %s copied (%s)", volume, build.FormatBytes(size)))
+	}
+	return stopped, nil
+}
+
+// printCutoverChecklist prints what is left to move the traffic to the new server.
+func printCutoverChecklist(ctx context.Context, cfg *config.Config, target config.Server, stopped []string, stopSource bool) {
+	address := target.Host
+	if addrs, err := net.DefaultResolver.LookupHost(ctx, target.Host); err == nil {
+		address = strings.Join(addrs, ", ")
+	}
+
+	var steps []string
+	for _, domain := range cfg.Domains() {
+		if checkDomainResolves(ctx, domain, target.Host).ok {
+			continue
+		}
+		steps = append(steps, fmt.Sprintf("Point the DNS records of %s to %s", domain, address))
+	}
+	if len(steps) > 0 && cfg.Project.TLS == nil {
+		steps = append(steps, "Wait until the old records expire; the new server gets its certificates once the domains point to it")
+	} else if len(steps) > 0 {
+		steps = append(steps, "Wait until the old records expire")
+	}
+	steps = append(steps, fmt.Sprintf("Set server.host in ftl.yaml to %s, so that ftl deploys to the new server", target.Host))
+	switch {
+	case len(stopped) > 0:
+		steps = append(steps, fmt.Sprintf("Remove the stopped containers %s from %s once the new server takes the traffic", strings.Join(stopped, ", "), cfg.Server.Host))
+	case !stopSource:
+		steps = append(steps, fmt.Sprintf("Stop the project on %s once it gets no more traffic; changes made there since the copy aren't on the new server", cfg.Server.Host))
+	}
+
+	console.Success(fmt.Sprintf("Project %s is deployed to %s", cfg.Project.Name, target.Host))
+	console.Info("To move the traffic:")
+	for i, step := range steps {
+		console.Info(fmt.Sprintf("  %d. %s", i+1, step))
+	}
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yarlson/ftl/pkg/app"
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/console"
+	"github.com/yarlson/ftl/pkg/tunnel"
+)
+
+var psCmd = &cobra.Command{
+	Use:   "ps",
+	Short: "List services, published ports and tunnels",
+	Long: `List every service and dependency defined in ftl.yaml together with
+its container ports, host forwards, local tunnel ports and whether
+each tunnel is currently listening on this machine.`,
+	Run: runPs,
+}
+
+func init() {
+	rootCmd.AddCommand(psCmd)
+}
+
+func runPs(cmd *cobra.Command, args []string) {
+	cfg, err := parseConfig("ftl.yaml")
+	if err != nil {
+		console.Error("Failed to parse config file:", err)
+		return
+	}
+
+	states, err := fetchContainerStates(cfg)
+	if err != nil {
+		console.Warning(fmt.Sprintf("Failed to fetch container states: %v", err))
+	}
+
+	state, err := tunnel.LoadState(cfg.Project.Name)
+	if err != nil {
+		console.Warning(fmt.Sprintf("Failed to read tunnel state: %v", err))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tKIND\tSTATE\tPORTS\tFORWARDS\tTUNNELS")
+
+	for _, svc := range cfg.Services {
+		_, _ = fmt.Fprintf(w, "%s\tservice\t%s\t%d\t%s\t-\n",
+			svc.Name, containerState(states, cfg.Project.Name, svc.Name), svc.Port, joinOrDash(svc.Forwards))
+	}
+
+	tunnels := tunnel.CollectDependencyTunnels(cfg)
+	if state != nil && len(state.Tunnels) > 0 {
+		// Prefer the ports actually bound by `ftl tunnels`, which may differ after overrides.
+		tunnels = state.Tunnels
+	}
+	for _, dep := range cfg.Dependencies {
+		ports := make([]string, 0, len(dep.Ports))
+		for _, port := range dep.Ports {
+			ports = append(ports, strconv.Itoa(port))
+		}
+
+		var depTunnels []string
+		for _, tun := range tunnels {
+			if tun.Dependency != dep.Name {
+				continue
+			}
+			depTunnels = append(depTunnels, fmt.Sprintf("%s->%s (%s)", tun.LocalPort, tun.RemoteAddr, tunnelStatus(state, tun)))
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\tdependency\t%s\t%s\t-\t%s\n",
+			dep.Name, containerState(states, cfg.Project.Name, dep.Name), joinOrDash(ports), joinOrDash(depTunnels))
+	}
+
+	_ = w.Flush()
+}
+
+// fetchContainerStates returns the state of every container on the project network keyed by name.
+func fetchContainerStates(cfg *config.Config) (map[string]string, error) {
+	runner, err := app.Connect(cfg.Server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server %s: %w", cfg.Server.Host, err)
+	}
+	defer runner.Close()
+
+	output, err := runner.RunCommand(context.Background(), "docker", "ps", "-a",
+		"--filter", fmt.Sprintf("network=%s", cfg.Project.Name),
+		"--format", "{{.Names}}\t{{.State}}")
+	if err != nil {
+		return nil, err
+	}
+	defer output.Close()
+
+	data, err := io.ReadAll(output)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		name, state, ok := strings.Cut(line, "\t")
+		if ok {
+			states[name] = state
+		}
+	}
+
+	return states, nil
+}
+
+func containerState(states map[string]string, project, name string) string {
+	if states == nil {
+		return "unknown"
+	}
+	if state, ok := states[fmt.Sprintf("%s-%s", project, name)]; ok {
+		return state
+	}
+	return "not deployed"
+}
+
+// tunnelStatus reports whether the tunnel is served by a running `ftl tunnels` process.
+func tunnelStatus(state *tunnel.State, tun tunnel.Config) string {
+	recorded := false
+	if state != nil {
+		for _, t := range state.Tunnels {
+			if t.LocalPort == tun.LocalPort {
+				recorded = true
+				break
+			}
+		}
+	}
+
+	switch {
+	case recorded && tunnel.IsListening(tun.LocalPort):
+		return "listening"
+	case tunnel.IsListening(tun.LocalPort):
+		return "port in use"
+	default:
+		return "closed"
+	}
+}
+
+func joinOrDash(values []string) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yarlson/ftl/pkg/app"
+	"github.com/yarlson/ftl/pkg/console"
+	"github.com/yarlson/ftl/pkg/deployment"
+)
+
+// rollbackTo is the ID of the deployment to roll back to, set by the --to flag.
+var rollbackTo string
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback --to ID",
+	Short: "Deploy the images of a previous deployment again",
+	Long: `Deploy the services with exactly the images of a deployment recorded
+on the server, listed by ftl history. The rest of the configuration is
+taken from the current ftl.yaml.`,
+	Args:        cobra.NoArgs,
+	Run:         runRollback,
+	Annotations: map[string]string{annotationCancellable: "true"},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+	rollbackCmd.Flags().StringVar(&rollbackTo, "to", "", "ID of the deployment to roll back to, as listed by ftl history")
+	rollbackCmd.Flags().BoolVar(&lenientConfig, "lenient", false, "Ignore unknown fields in ftl.yaml instead of failing")
+	_ = rollbackCmd.MarkFlagRequired("to")
+}
+
+func runRollback(cmd *cobra.Command, args []string) {
+	cfg, err := parseConfig("ftl.yaml")
+	if err != nil {
+		console.Error("Failed to parse config file:", err)
+		return
+	}
+
+	runner, err := app.Connect(cfg.Server)
+	if err != nil {
+		console.Error(fmt.Sprintf("Failed to connect to server %s:", cfg.Server.Host), err)
+		return
+	}
+	manifest, err := deployment.FindManifest(cmd.Context(), runner, cfg.Project.Name, rollbackTo)
+	runner.Close()
+	if err != nil {
+		console.Error("Failed to find the deployment:", err)
+		return
+	}
+
+	if missing := manifest.Pin(cfg); len(missing) > 0 {
+		console.Warning(fmt.Sprintf("%s of deployment %s are no longer defined in ftl.yaml and won't be rolled back.", strings.Join(missing, ", "), manifest.ID))
+	}
+	console.Info(fmt.Sprintf("Rolling back to deployment %s of %s", manifest.ID, manifest.Time.Local().Format(time.DateTime)))
+
+	renderer := newEventRenderer(cfg.Server.Host, false)
+	err = deployToServer(cmd.Context(), cfg, app.DeployOptions{}, renderer, nil)
+	renderer.close()
+
+	if err != nil && cmd.Context().Err() != nil {
+		printDeployError("Rollback cancelled:", err)
+		return
+	}
+	if err != nil {
+		printDeployError("Rollback failed:", err)
+		return
+	}
+
+	console.Success(fmt.Sprintf("Rolled back to deployment %s", manifest.ID))
+}
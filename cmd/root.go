@@ -1,9 +1,24 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+
 	"github.com/spf13/cobra"
+
+	"github.com/yarlson/ftl/pkg/console"
+	"github.com/yarlson/ftl/pkg/runner/cmdlog"
 )
 
+// annotationCancellable marks commands that clean up and return when their context is
+// cancelled, instead of being killed by an interrupt.
+const annotationCancellable = "cancellable"
+
+// cancellable is set while a command marked with annotationCancellable runs.
+var cancellable atomic.Bool
+
 var rootCmd = &cobra.Command{
 	Use:   "ftl",
 	Short: "FTL - Faster Than Light deployment tool",
@@ -13,9 +28,74 @@ to make deployment easy and reliable, even for developers who aren't experts
 in server management or advanced deployment techniques.
 
 Use 'ftl [command] --help' for more information about a command.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		cancellable.Store(cmd.Annotations[annotationCancellable] == "true")
+
+		if workDir != "" {
+			if err := os.Chdir(workDir); err != nil {
+				console.Error("Failed to change directory:", err)
+				os.Exit(1)
+			}
+		}
+
+		if err := enableCommandLog(); err != nil {
+			console.Error("Failed to open the log file:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// environment selects the overrides of ftl.yaml to apply. It is set by the --env flag.
+var environment string
+
+// workDir is the directory ftl runs in instead of the current one. It is set by the --chdir flag.
+var workDir string
+
+// verbose logs every command ftl runs. It is set by the --verbose flag or the FTL_DEBUG variable.
+var verbose bool
+
+// logFile is the file commands are logged to instead of stderr. It is set by the --log-file flag.
+var logFile string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&environment, "env", "", "Apply the overrides of this environment from the environments section of ftl.yaml")
+	rootCmd.PersistentFlags().StringVarP(&workDir, "chdir", "C", "", "Run as if ftl was started in this directory")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Log every command run locally and on the server, with its duration and exit status")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Log the commands to this file instead of stderr, implies --verbose")
+}
+
+// enableCommandLog logs the commands ftl runs when --verbose, --log-file or FTL_DEBUG is set.
+// The log file is opened relative to the working directory set by --chdir.
+func enableCommandLog() error {
+	if logFile == "" && !verbose && os.Getenv("FTL_DEBUG") == "" {
+		return nil
+	}
+
+	if logFile == "" {
+		cmdlog.Enable(func(line string) {
+			console.PrintAbove(os.Stderr, line)
+		})
+		return nil
+	}
+
+	file, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	cmdlog.Enable(func(line string) {
+		_, _ = fmt.Fprintln(file, line)
+	})
+	return nil
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
-func Execute() error {
-	return rootCmd.Execute()
+// Commands that honor cancellation get ctx as their context.
+func Execute(ctx context.Context) error {
+	return rootCmd.ExecuteContext(ctx)
+}
+
+// Cancellable reports whether the running command stops cleanly when its context is
+// cancelled, so that an interrupt should cancel it rather than exit.
+func Cancellable() bool {
+	return cancellable.Load()
 }
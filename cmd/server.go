@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yarlson/ftl/pkg/app"
+	"github.com/yarlson/ftl/pkg/console"
+	"github.com/yarlson/ftl/pkg/server"
+)
+
+var serverCmd = &cobra.Command{
+	Use:   "server",
+	Short: "Inspect the server",
+}
+
+var serverInfoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Show facts about the server",
+	Long: `Show the distribution, kernel, Docker version and storage driver, free disk
+space and memory of the server defined in ftl.yaml, the containers deployed
+by ftl and whether the ports of the proxy are taken by something else.`,
+	Run: runServerInfo,
+}
+
+func init() {
+	rootCmd.AddCommand(serverCmd)
+	serverCmd.AddCommand(serverInfoCmd)
+}
+
+func runServerInfo(cmd *cobra.Command, args []string) {
+	cfg, err := parseConfig("ftl.yaml")
+	if err != nil {
+		console.Error("Failed to parse config file:", err)
+		return
+	}
+
+	runner, err := app.Connect(cfg.Server)
+	if err != nil {
+		console.Error(fmt.Sprintf("Failed to connect to server %s:", cfg.Server.Host), err)
+		return
+	}
+	defer runner.Close()
+
+	facts := server.Info(cmd.Context(), runner, cfg.Project.Name, cfg.Project.ProxyPorts())
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintf(w, "Host\t%s\n", cfg.Server.Host)
+	for _, fact := range facts {
+		value := fact.Value
+		if fact.Err != nil {
+			value = fmt.Sprintf("unknown (%v)", fact.Err)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", fact.Name, value)
+	}
+	_ = w.Flush()
+
+	for _, fact := range facts {
+		if fact.Warning != "" {
+			console.Warning(fmt.Sprintf("%s: %s", fact.Name, fact.Warning))
+		}
+	}
+}
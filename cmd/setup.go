@@ -3,11 +3,14 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/yarlson/ftl/pkg/app"
 	"github.com/yarlson/ftl/pkg/config"
 	"github.com/yarlson/ftl/pkg/console"
 	"github.com/yarlson/ftl/pkg/server"
@@ -23,6 +26,26 @@ Run this once for each new server before deploying your application.`,
 
 func init() {
 	rootCmd.AddCommand(setupCmd)
+	setupCmd.Flags().String("step", "", fmt.Sprintf("Run a single setup step (%s)", strings.Join(server.StepNames(), ", ")))
+	setupCmd.Flags().Bool("harden-ssh", false, "Disable SSH password login and install fail2ban")
+	setupCmd.Flags().Bool("open-forward-ports", false, "Open host ports published by service forwards in the firewall without asking")
+	setupCmd.Flags().String("docker-username", "", "Docker Hub username (default: $FTL_DOCKER_USERNAME)")
+	setupCmd.Flags().Bool("docker-password-stdin", false, "Read the Docker Hub password from standard input (default: $FTL_DOCKER_PASSWORD)")
+	setupCmd.Flags().Bool("user-password-stdin", false, "Read the password of the new user from standard input (default: $FTL_USER_PASSWORD)")
+}
+
+const (
+	envDockerUsername = "FTL_DOCKER_USERNAME"
+	envDockerPassword = "FTL_DOCKER_PASSWORD"
+	envUserPassword   = "FTL_USER_PASSWORD"
+)
+
+// setupInputs holds the secrets setup passes to the server, taken from flags and environment
+// variables before falling back to prompts.
+type setupInputs struct {
+	dockerUsername string
+	dockerPassword string
+	userPassword   string
 }
 
 func runSetup(cmd *cobra.Command, args []string) {
@@ -30,77 +53,257 @@ func runSetup(cmd *cobra.Command, args []string) {
 	sm.Start()
 	defer sm.Stop()
 
-	spinner := sm.AddSpinner("config", "Parsing configuration")
+	step, err := cmd.Flags().GetString("step")
+	if err != nil {
+		console.Error("Failed to get step flag:", err)
+		return
+	}
+	if step != "" {
+		if err := server.ValidateStep(step); err != nil {
+			console.Error(err)
+			return
+		}
+	}
 
-	cfg, err := parseConfig("ftl.yaml")
+	openForwardPorts, err := cmd.Flags().GetBool("open-forward-ports")
 	if err != nil {
-		spinner.ErrorWithMessagef("Failed to parse config file: %v", err)
+		console.Error("Failed to get open-forward-ports flag:", err)
 		return
 	}
-	spinner.Complete()
 
-	// Get Docker credentials if needed
-	spinner = sm.AddSpinner("docker", "Checking Docker credentials")
-	dockerCreds, err := getDockerCredentials(cfg.Services)
+	hardenSSH, err := cmd.Flags().GetBool("harden-ssh")
 	if err != nil {
-		spinner.ErrorWithMessagef("Failed to get Docker credentials: %v", err)
+		console.Error("Failed to get harden-ssh flag:", err)
+		return
+	}
+
+	spinner := sm.AddSpinner("config", "Parsing configuration")
+
+	cfg, err := parseConfig("ftl.yaml")
+	if err != nil {
+		spinner.ErrorWithMessagef("Failed to parse config file: %v", err)
 		return
 	}
 	spinner.Complete()
+
 	sm.Stop()
 
-	// Get user password
-	newUserPassword, err := getUserPassword()
+	inputs, err := readSetupInputs(cmd, os.Getenv)
 	if err != nil {
-		console.Error("Failed to read password:", err)
+		console.Error(err)
+		return
+	}
+	interactive := console.IsInteractive()
+	if missing := missingSetupInputs(inputs, cfg.Services, step, interactive); len(missing) > 0 {
+		console.Error(fmt.Sprintf("Standard input is not a terminal, so setup can't prompt for:\n  - %s", strings.Join(missing, "\n  - ")))
 		return
 	}
-	console.ClearPreviousLine()
-	console.Success("Password set successfully")
 
-	sm = console.NewSpinnerManager()
-	sm.Start()
-	defer sm.Stop()
+	// Get Docker credentials if needed
+	var dockerCreds server.DockerCredentials
+	if step == "" || step == "docker-login" {
+		dockerCreds, err = getDockerCredentials(cfg.Services, inputs, interactive)
+		if err != nil {
+			console.Error("Failed to get Docker credentials:", err)
+			return
+		}
+	}
+
+	var firewallAllow []string
+	if step == "" || step == "firewall" {
+		firewallAllow, err = forwardPortsToOpen(cfg.Services, openForwardPorts, interactive)
+		if err != nil {
+			console.Error("Failed to read answer:", err)
+			return
+		}
+	}
+
+	// Get user password; it is only used if the user doesn't exist yet
+	newUserPassword := inputs.userPassword
+	if (step == "" || step == "user") && newUserPassword == "" {
+		newUserPassword, err = getUserPassword()
+		if err != nil {
+			console.Error("Failed to read password:", err)
+			return
+		}
+		console.ClearPreviousLine()
+		console.Success("Password set successfully")
+	}
 
 	// Start server setup
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	ctx, cancel := context.WithTimeout(cmd.Context(), 10*time.Minute)
 	defer cancel()
 
-	if err := server.Setup(ctx, cfg, server.DockerCredentials{
-		Username: dockerCreds.Username,
-		Password: dockerCreds.Password,
-	}, newUserPassword, sm); err != nil {
-		sm.Stop()
+	a := app.New(cfg)
+	renderer := newEventRenderer(cfg.Server.Host, false)
+	err = renderEvents(renderer, a.Setup(ctx, server.Options{
+		DockerCredentials: dockerCreds,
+		NewUserPassword:   newUserPassword,
+		FirewallAllow:     firewallAllow,
+		HardenSSH:         hardenSSH,
+		Step:              step,
+	}))
+	renderer.close()
+	printSetupSummary(a.SetupResults())
+	if err != nil {
 		console.Error("Setup failed:", err)
 		return
 	}
 
-	sm.Stop()
-
 	console.Success("Server setup completed successfully.")
 }
 
-func getDockerCredentials(services []config.Service) (server.DockerCredentials, error) {
-	var creds server.DockerCredentials
+func printSetupSummary(results []server.StepResult) {
+	for _, result := range results {
+		if result.Status == server.StepSkipped {
+			console.Info(fmt.Sprintf("%-12s skipped (%s)", result.Name, result.Reason))
+			continue
+		}
+		if len(result.Changes) > 0 {
+			console.Info(fmt.Sprintf("%-12s applied (%s)", result.Name, strings.Join(result.Changes, ", ")))
+			continue
+		}
+		console.Info(fmt.Sprintf("%-12s applied", result.Name))
+	}
+}
 
-	if !needDockerHubLogin(services) {
-		return creds, nil
+// readSetupInputs reads the setup secrets from flags, standard input and environment variables.
+// Only one secret can be read from standard input.
+func readSetupInputs(cmd *cobra.Command, getenv func(string) string) (setupInputs, error) {
+	inputs := setupInputs{
+		dockerUsername: getenv(envDockerUsername),
+		dockerPassword: getenv(envDockerPassword),
+		userPassword:   getenv(envUserPassword),
 	}
 
-	console.Input("Enter Docker Hub username:")
-	username, err := console.ReadLine()
+	username, err := cmd.Flags().GetString("docker-username")
 	if err != nil {
-		return creds, fmt.Errorf("failed to read Docker Hub username: %w", err)
+		return inputs, fmt.Errorf("failed to get docker-username flag: %w", err)
+	}
+	if username != "" {
+		inputs.dockerUsername = username
 	}
 
-	console.Input("Enter Docker Hub password:")
-	password, err := console.ReadPassword()
+	dockerPasswordStdin, err := cmd.Flags().GetBool("docker-password-stdin")
 	if err != nil {
-		return creds, fmt.Errorf("failed to read Docker Hub password: %w", err)
+		return inputs, fmt.Errorf("failed to get docker-password-stdin flag: %w", err)
+	}
+	userPasswordStdin, err := cmd.Flags().GetBool("user-password-stdin")
+	if err != nil {
+		return inputs, fmt.Errorf("failed to get user-password-stdin flag: %w", err)
+	}
+	if dockerPasswordStdin && userPasswordStdin {
+		return inputs, fmt.Errorf("--docker-password-stdin and --user-password-stdin can't both read standard input; pass one of the passwords in %s or %s", envDockerPassword, envUserPassword)
+	}
+
+	if dockerPasswordStdin {
+		if inputs.dockerPassword, err = readStdinSecret(); err != nil {
+			return inputs, fmt.Errorf("failed to read Docker Hub password from standard input: %w", err)
+		}
+	}
+	if userPasswordStdin {
+		if inputs.userPassword, err = readStdinSecret(); err != nil {
+			return inputs, fmt.Errorf("failed to read user password from standard input: %w", err)
+		}
+	}
+
+	return inputs, nil
+}
+
+// readStdinSecret reads a secret from standard input, without its trailing newline.
+func readStdinSecret() (string, error) {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("standard input is empty")
+	}
+	return secret, nil
+}
+
+// missingSetupInputs lists the inputs that would have to be prompted for when there is no terminal
+// to prompt on. Docker Hub credentials are optional: without them, setup skips docker login.
+func missingSetupInputs(inputs setupInputs, services []config.Service, step string, interactive bool) []string {
+	if interactive {
+		return nil
+	}
+
+	var missing []string
+	if (step == "" || step == "docker-login") && needDockerHubLogin(services) {
+		if inputs.dockerUsername != "" && inputs.dockerPassword == "" {
+			missing = append(missing, fmt.Sprintf("Docker Hub password: --docker-password-stdin or %s", envDockerPassword))
+		}
+		if inputs.dockerUsername == "" && inputs.dockerPassword != "" {
+			missing = append(missing, fmt.Sprintf("Docker Hub username: --docker-username or %s", envDockerUsername))
+		}
+	}
+	if (step == "" || step == "user") && inputs.userPassword == "" {
+		missing = append(missing, fmt.Sprintf("new user password: --user-password-stdin or %s", envUserPassword))
+	}
+	return missing
+}
+
+// forwardPortsToOpen returns the service forward ports to open in the firewall, asking first
+// unless open is set. Without a terminal to ask on, the ports stay closed.
+func forwardPortsToOpen(services []config.Service, open, interactive bool) ([]string, error) {
+	ports := server.ForwardPorts(services)
+	if len(ports) == 0 || open {
+		return ports, nil
+	}
+	if !interactive {
+		console.Warning(fmt.Sprintf("Not opening forwarded ports %s in the firewall; pass --open-forward-ports to open them", strings.Join(ports, ", ")))
+		return nil, nil
+	}
+
+	console.Input(fmt.Sprintf("Open forwarded ports %s in the firewall? [y/N]:", strings.Join(ports, ", ")))
+	answer, err := console.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return ports, nil
+	default:
+		return nil, nil
+	}
+}
+
+// getDockerCredentials returns the Docker Hub credentials from inputs, prompting for the missing
+// ones on a terminal when services use Docker Hub images.
+func getDockerCredentials(services []config.Service, inputs setupInputs, interactive bool) (server.DockerCredentials, error) {
+	creds := server.DockerCredentials{Username: inputs.dockerUsername, Password: inputs.dockerPassword}
+
+	if (creds.Username != "" && creds.Password != "") || !needDockerHubLogin(services) {
+		return creds, nil
+	}
+	if !interactive {
+		console.Warning("No Docker Hub credentials given; skipping docker login")
+		return creds, nil
+	}
+
+	if creds.Username == "" {
+		console.Input("Enter Docker Hub username:")
+		username, err := console.ReadLine()
+		if err != nil {
+			return creds, fmt.Errorf("failed to read Docker Hub username: %w", err)
+		}
+		creds.Username = username
+	}
+
+	if creds.Password == "" {
+		console.Input("Enter Docker Hub password:")
+		password, err := console.ReadPassword()
+		if err != nil {
+			return creds, fmt.Errorf("failed to read Docker Hub password: %w", err)
+		}
+		fmt.Println()
+		creds.Password = password
 	}
-	fmt.Println()
 
-	return server.DockerCredentials{Username: username, Password: password}, nil
+	return creds, nil
 }
 
 func getUserPassword() (string, error) {
@@ -2,13 +2,19 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/yarlson/ftl/pkg/app"
+	"github.com/yarlson/ftl/pkg/config"
 	"github.com/yarlson/ftl/pkg/console"
 	"github.com/yarlson/ftl/pkg/tunnel"
 )
@@ -23,6 +29,10 @@ forwarding local ports to remote ports.`,
 
 func init() {
 	rootCmd.AddCommand(tunnelsCmd)
+	tunnelsCmd.Flags().StringArray("port", nil, "Bind a dependency port to a custom local port (dependency=local:remote)")
+	tunnelsCmd.Flags().StringArray("reverse", nil, "Forward a remote port to a local port (local=3000,remote=8081)")
+	tunnelsCmd.Flags().Int("socks", 0, "Start a local SOCKS5 proxy on the given port that routes traffic through the server")
+	tunnelsCmd.Flags().Bool("socks-network-only", false, "Only allow SOCKS5 connections to the project's Docker network")
 }
 
 func runTunnels(cmd *cobra.Command, args []string) {
@@ -38,31 +48,157 @@ func runTunnels(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	reverseSpecs, err := cmd.Flags().GetStringArray("reverse")
+	if err != nil {
+		spinner.ErrorWithMessagef("Failed to get reverse flag: %v", err)
+		return
+	}
+
+	reverseTunnels := tunnel.CollectReverseTunnels(cfg)
+	for _, spec := range reverseSpecs {
+		rt, err := tunnel.ParseReverseSpec(spec)
+		if err != nil {
+			spinner.ErrorWithMessagef("Failed to parse reverse tunnel: %v", err)
+			return
+		}
+		reverseTunnels = append(reverseTunnels, rt)
+	}
+
+	socksPort, err := cmd.Flags().GetInt("socks")
+	if err != nil {
+		spinner.ErrorWithMessagef("Failed to get socks flag: %v", err)
+		return
+	}
+
+	socksNetworkOnly, err := cmd.Flags().GetBool("socks-network-only")
+	if err != nil {
+		spinner.ErrorWithMessagef("Failed to get socks-network-only flag: %v", err)
+		return
+	}
+
+	var unexposed []string
+	for _, dep := range cfg.Dependencies {
+		if dep.ExposeMode() == config.ExposeNone && len(dep.Ports) > 0 {
+			unexposed = append(unexposed, dep.Name)
+		}
+	}
+
 	tunnels := tunnel.CollectDependencyTunnels(cfg)
-	if len(tunnels) == 0 {
+	if len(tunnels) == 0 && len(reverseTunnels) == 0 && socksPort == 0 {
+		if len(unexposed) > 0 {
+			spinner.ErrorWithMessagef("No tunnels to establish: dependencies %s use expose: none", strings.Join(unexposed, ", "))
+			return
+		}
 		spinner.ErrorWithMessage("No dependencies with ports found in the configuration.")
 		return
 	}
 
+	overrides, err := cmd.Flags().GetStringArray("port")
+	if err != nil {
+		spinner.ErrorWithMessagef("Failed to get port flag: %v", err)
+		return
+	}
+
+	tunnels, err = tunnel.ApplyPortOverrides(tunnels, overrides)
+	if err != nil {
+		spinner.ErrorWithMessagef("Failed to apply port overrides: %v", err)
+		return
+	}
+
+	tunnels, moved, err := tunnel.AssignFreePorts(tunnels)
+	if err != nil {
+		spinner.ErrorWithMessagef("Failed to assign local ports: %v", err)
+		return
+	}
+
 	// Use a cancelable context so we can shut down tunnels on Ctrl+C
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	err = tunnel.StartTunnels(
-		ctx,
-		cfg.Server.Host, cfg.Server.Port,
-		cfg.Server.User, cfg.Server.SSHKey,
-		tunnels,
-	)
-	if err != nil {
-		spinner.ErrorWithMessagef("Failed to establish tunnels: %v", err)
-		return
+	if len(tunnels) > 0 {
+		err = tunnel.StartTunnels(
+			ctx,
+			cfg.Server.Host, cfg.Server.Port,
+			cfg.Server.User, cfg.Server.SSHKey,
+			tunnels,
+		)
+		if err != nil {
+			spinner.ErrorWithMessagef("Failed to establish tunnels: %v", err)
+			return
+		}
+	}
+
+	var reverseDone <-chan struct{}
+	if len(reverseTunnels) > 0 {
+		reverseDone, err = tunnel.StartReverseTunnels(
+			ctx,
+			cfg.Server.Host, cfg.Server.Port,
+			cfg.Server.User, cfg.Server.SSHKey,
+			reverseTunnels,
+		)
+		if err != nil {
+			spinner.ErrorWithMessagef("Failed to establish reverse tunnels: %v", err)
+			return
+		}
+	}
+
+	var socksAllowed []*net.IPNet
+	if socksPort != 0 {
+		if socksNetworkOnly {
+			socksAllowed, err = dockerNetworkSubnets(ctx, cfg.Server, cfg.Project.Name)
+			if err != nil {
+				spinner.ErrorWithMessagef("Failed to get Docker network subnets: %v", err)
+				return
+			}
+		}
+
+		err = tunnel.StartSOCKSProxy(
+			ctx,
+			cfg.Server.Host, cfg.Server.Port,
+			cfg.Server.User, cfg.Server.SSHKey,
+			socksPort, socksAllowed,
+		)
+		if err != nil {
+			spinner.ErrorWithMessagef("Failed to start SOCKS5 proxy: %v", err)
+			return
+		}
 	}
 
 	// If no error arrived in 2 seconds, we assume success (like the original):
 	spinner.Complete()
 	sm.Stop()
 
+	if err := tunnel.SaveState(cfg.Project.Name, tunnel.State{
+		PID:     os.Getpid(),
+		Host:    cfg.Server.Host,
+		Started: time.Now(),
+		Tunnels: tunnel.Active(),
+	}); err != nil {
+		console.Warning(fmt.Sprintf("Failed to record tunnel state: %v", err))
+	}
+	defer func() { _ = tunnel.RemoveState(cfg.Project.Name) }()
+
+	for _, name := range unexposed {
+		console.Warning(fmt.Sprintf("Skipping %s: its ports are not published on the server (expose: none)", name))
+	}
+	for _, msg := range moved {
+		console.Warning(msg)
+	}
+	for _, tun := range tunnels {
+		console.Info(fmt.Sprintf("%s: localhost:%s -> %s", tun.Dependency, tun.LocalPort, tun.RemoteAddr))
+	}
+	for _, rt := range reverseTunnels {
+		console.Info(fmt.Sprintf("reverse: %s:%d -> localhost:%d", cfg.Server.Host, rt.RemotePort, rt.LocalPort))
+	}
+
+	if socksPort != 0 {
+		if len(socksAllowed) > 0 {
+			console.Info(fmt.Sprintf("socks5: localhost:%d -> %s (limited to %s)", socksPort, cfg.Server.Host, formatNetworks(socksAllowed)))
+		} else {
+			console.Info(fmt.Sprintf("socks5: localhost:%d -> %s", socksPort, cfg.Server.Host))
+		}
+	}
+
 	console.Success("SSH tunnels established. Press Ctrl+C to exit.")
 
 	// Same old signal handling
@@ -72,5 +208,55 @@ func runTunnels(cmd *cobra.Command, args []string) {
 
 	console.Info("Shutting down tunnels...")
 	cancel()
+
+	// Wait for reverse tunnels to release their remote ports before exiting.
+	if reverseDone != nil {
+		select {
+		case <-reverseDone:
+		case <-time.After(5 * time.Second):
+		}
+	}
 	time.Sleep(1 * time.Second)
 }
+
+// dockerNetworkSubnets returns the subnets of the project's Docker network on the server.
+func dockerNetworkSubnets(ctx context.Context, server config.Server, project string) ([]*net.IPNet, error) {
+	runner, err := app.Connect(server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server: %w", err)
+	}
+	defer runner.Close()
+
+	output, err := runner.RunCommand(ctx, "docker", "network", "inspect", project, "--format", "{{range .IPAM.Config}}{{.Subnet}} {{end}}")
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect network %s: %w", project, err)
+	}
+	defer output.Close()
+
+	data, err := io.ReadAll(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read network subnets: %w", err)
+	}
+
+	var subnets []*net.IPNet
+	for _, field := range strings.Fields(string(data)) {
+		_, subnet, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, fmt.Errorf("network %s: %s", project, strings.TrimSpace(string(data)))
+		}
+		subnets = append(subnets, subnet)
+	}
+	if len(subnets) == 0 {
+		return nil, fmt.Errorf("network %s has no subnets", project)
+	}
+
+	return subnets, nil
+}
+
+func formatNetworks(networks []*net.IPNet) string {
+	names := make([]string, len(networks))
+	for i, network := range networks {
+		names[i] = network.String()
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/console"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check ftl.yaml for configuration problems",
+	Long: `Check ftl.yaml without connecting to the server. Every problem is
+listed at once: unknown and invalid fields, duplicate service and dependency names,
+routes with the same path on the same domain, host ports published more
+than once and dependency data volumes mounted by other containers.
+
+With --remote it also checks that the server can be deployed to: the
+SSH key, the connection, docker and the docker group of the user,
+whether ports 80 and 443, or only 80 with tls: disabled, are reachable
+and whether the domains resolve to the server.`,
+	Run: runValidate,
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().Bool("remote", false, "Also check the SSH key, the server, its open ports and the domains")
+	validateCmd.Flags().BoolVar(&lenientConfig, "lenient", false, "Ignore unknown fields in ftl.yaml")
+}
+
+func runValidate(cmd *cobra.Command, args []string) {
+	checkServer, err := cmd.Flags().GetBool("remote")
+	if err != nil {
+		console.Error("Failed to get remote flag:", err)
+		os.Exit(1)
+	}
+
+	path, err := findConfig("ftl.yaml")
+	if err != nil {
+		console.Error("Failed to read config file:", err)
+		os.Exit(1)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		console.Error("Failed to read config file:", err)
+		os.Exit(1)
+	}
+
+	opts := parseOptions()
+	opts.Dir = filepath.Dir(path)
+	cfg, err := config.ParseConfigWithOptions(data, opts)
+	if err != nil {
+		var validationErr *config.ValidationError
+		if !errors.As(err, &validationErr) {
+			console.Error("Failed to parse config file:", err)
+			os.Exit(1)
+		}
+
+		problems := "problems"
+		if len(validationErr.Problems) == 1 {
+			problems = "problem"
+		}
+		console.Error(fmt.Sprintf("Found %d %s in ftl.yaml:", len(validationErr.Problems), problems))
+		for _, problem := range validationErr.Problems {
+			console.Print("  - " + problem)
+		}
+		os.Exit(1)
+	}
+
+	if cfg.Environment != "" {
+		console.Success(fmt.Sprintf("ftl.yaml is valid for environment %s", cfg.Environment))
+	} else {
+		console.Success("ftl.yaml is valid")
+	}
+	if warning := cfg.PlainHTTPWarning(); warning != "" {
+		console.Warning(warning)
+	}
+	for _, service := range cfg.Services {
+		for _, forward := range service.PublicRouteForwards() {
+			console.Warning(fmt.Sprintf("Forward %s of service %s publishes the port of its routes on all interfaces, where requests bypass the proxy; bind it to 127.0.0.1 to keep it private", forward, service.Name))
+		}
+	}
+
+	if checkServer && !checkRemote(cmd.Context(), cfg) {
+		os.Exit(1)
+	}
+}
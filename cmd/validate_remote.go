@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/yarlson/ftl/pkg/app"
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/console"
+	"github.com/yarlson/ftl/pkg/runner/remote"
+	"github.com/yarlson/ftl/pkg/ssh"
+)
+
+// checkTimeout bounds each network check of validate --remote.
+const checkTimeout = 5 * time.Second
+
+// check is an item of the validate --remote checklist.
+type check struct {
+	name   string
+	ok     bool
+	detail string
+}
+
+func (c check) print() {
+	message := c.name
+	if c.detail != "" {
+		message += ": " + c.detail
+	}
+	if c.ok {
+		console.Success(message)
+		return
+	}
+	console.Error(message)
+}
+
+// checkRemote checks that the server can be deployed to and prints a checklist. It reports
+// whether every check passed.
+func checkRemote(ctx context.Context, cfg *config.Config) bool {
+	passed := true
+	report := func(c check) {
+		c.print()
+		passed = passed && c.ok
+	}
+
+	keyCheck := checkSSHKey(cfg.Server)
+	report(keyCheck)
+
+	runner, connectCheck := checkConnection(cfg.Server, keyCheck.ok)
+	report(connectCheck)
+	if runner != nil {
+		defer runner.Close()
+		report(checkDocker(ctx, runner))
+		report(checkDockerGroup(ctx, runner, cfg.Server.User))
+	}
+
+	for _, port := range cfg.Project.ProxyPorts() {
+		report(checkPortReachable(cfg.Server.Host, port))
+	}
+	for _, domain := range cfg.Domains() {
+		report(checkDomainResolves(ctx, domain, cfg.Server.Host))
+	}
+
+	return passed
+}
+
+// checkSSHKey checks that the SSH key used by deploy exists and can be parsed.
+func checkSSHKey(server config.Server) check {
+	c := check{name: "SSH key"}
+	keyPath, err := ssh.KeyPath(server.SSHKey)
+	if err != nil {
+		c.detail = err.Error()
+		return c
+	}
+
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		c.detail = fmt.Sprintf("can't read %s: %v", keyPath, err)
+		return c
+	}
+	if _, err := gossh.ParsePrivateKey(key); err != nil {
+		var passphraseErr *gossh.PassphraseMissingError
+		if errors.As(err, &passphraseErr) {
+			c.detail = fmt.Sprintf("%s is protected by a passphrase, which ftl doesn't support", keyPath)
+		} else {
+			c.detail = fmt.Sprintf("can't parse %s: %v", keyPath, err)
+		}
+		return c
+	}
+
+	c.ok = true
+	c.detail = keyPath
+	return c
+}
+
+// checkConnection connects to the server. The runner is nil when the connection failed.
+func checkConnection(server config.Server, keyOK bool) (*remote.Runner, check) {
+	c := check{name: fmt.Sprintf("SSH connection to %s@%s:%d", server.User, server.Host, server.Port)}
+	if !keyOK {
+		c.detail = "skipped, the SSH key is not usable"
+		return nil, c
+	}
+
+	runner, err := app.Connect(server)
+	if err != nil {
+		c.detail = err.Error()
+		return nil, c
+	}
+
+	c.ok = true
+	return runner, c
+}
+
+// checkDocker checks that docker is installed and its daemon is running.
+func checkDocker(ctx context.Context, runner *remote.Runner) check {
+	c := check{name: "Docker"}
+	version, err := remoteOutput(ctx, runner, "docker version --format '{{.Server.Version}}' 2>&1")
+	if err != nil {
+		c.detail = err.Error()
+		return c
+	}
+	if version == "" || strings.ContainsAny(version, " \n") {
+		c.detail = "not installed or not running, run ftl setup"
+		if version != "" {
+			c.detail += ": " + version
+		}
+		return c
+	}
+
+	c.ok = true
+	c.detail = "version " + version
+	return c
+}
+
+// checkDockerGroup checks that the deploy user can use docker without sudo.
+func checkDockerGroup(ctx context.Context, runner *remote.Runner, user string) check {
+	c := check{name: fmt.Sprintf("User %s in the docker group", user)}
+	output, err := remoteOutput(ctx, runner, "id -un; id -Gn")
+	if err != nil {
+		c.detail = err.Error()
+		return c
+	}
+
+	lines := strings.SplitN(output, "\n", 2)
+	if lines[0] == "root" {
+		c.ok = true
+		c.detail = "root can use docker"
+		return c
+	}
+	if len(lines) == 2 && slices.Contains(strings.Fields(lines[1]), "docker") {
+		c.ok = true
+		return c
+	}
+
+	c.detail = "run ftl setup to add the user to the docker group"
+	return c
+}
+
+// checkPortReachable checks that a port of the server can be reached from this machine. A
+// refused connection still reaches the server, so it passes: the proxy listens on the port only
+// after the first deploy. A timeout usually means a firewall drops the traffic.
+func checkPortReachable(host string, port int) check {
+	c := check{name: fmt.Sprintf("Port %d reachable", port)}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, fmt.Sprint(port)), checkTimeout)
+	if err == nil {
+		_ = conn.Close()
+		c.ok = true
+		return c
+	}
+
+	if isTimeout(err) {
+		c.detail = fmt.Sprintf("no answer within %s, check the firewalls in front of the server", checkTimeout)
+		return c
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		c.ok = true
+		c.detail = "nothing is listening yet"
+		return c
+	}
+
+	c.detail = err.Error()
+	return c
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// checkDomainResolves checks that a domain resolves to an address of the server.
+func checkDomainResolves(ctx context.Context, domain, host string) check {
+	c := check{name: fmt.Sprintf("Domain %s resolves to the server", domain)}
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	serverAddrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		c.detail = fmt.Sprintf("can't resolve the server %s: %v", host, err)
+		return c
+	}
+	domainAddrs, err := net.DefaultResolver.LookupHost(ctx, domain)
+	if err != nil {
+		c.detail = err.Error()
+		return c
+	}
+
+	for _, addr := range domainAddrs {
+		if slices.Contains(serverAddrs, addr) {
+			c.ok = true
+			return c
+		}
+	}
+
+	c.detail = fmt.Sprintf("resolves to %s, the server is %s", strings.Join(domainAddrs, ", "), strings.Join(serverAddrs, ", "))
+	return c
+}
+
+// remoteOutput runs a shell command on the server and returns its trimmed output.
+func remoteOutput(ctx context.Context, runner *remote.Runner, command string) (string, error) {
+	output, err := runner.RunCommand(ctx, "sh", "-c", command)
+	if err != nil {
+		return "", err
+	}
+	defer output.Close()
+
+	data, err := io.ReadAll(output)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yarlson/ftl/pkg/app"
+	"github.com/yarlson/ftl/pkg/console"
+	"github.com/yarlson/ftl/pkg/deployment"
+)
+
+var volumesCmd = &cobra.Command{
+	Use:   "volumes",
+	Short: "Back up and restore volumes",
+	Long: `Copy the named volumes of the project between the server and local
+archives. Volumes are named as in ftl.yaml, like uploads, or with the
+project prefix of their Docker name, like shop-uploads.`,
+}
+
+var volumesBackupCmd = &cobra.Command{
+	Use:   "backup VOLUME",
+	Short: "Download a volume as a gzipped tar archive",
+	Long: `Archive the files of a volume in a temporary container on the server
+and download the archive. The archive is written to
+<volume>-<time>.tar.gz in the current directory unless --output is given.`,
+	Args:        cobra.ExactArgs(1),
+	Run:         runVolumesBackup,
+	Annotations: map[string]string{annotationCancellable: "true"},
+}
+
+var volumesRestoreCmd = &cobra.Command{
+	Use:   "restore VOLUME FILE",
+	Short: "Replace the files of a volume with those of an archive",
+	Long: `Replace all files of a volume on the server with the files of a gzipped
+tar archive made by ftl volumes backup. The running containers using
+the volume are stopped during the restore and started again afterwards.
+The volume is created when it doesn't exist yet, e.g. on a new server.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runVolumesRestore,
+}
+
+func init() {
+	rootCmd.AddCommand(volumesCmd)
+	volumesCmd.AddCommand(volumesBackupCmd)
+	volumesCmd.AddCommand(volumesRestoreCmd)
+	volumesBackupCmd.Flags().StringP("output", "o", "", "File to write the archive to")
+	volumesRestoreCmd.Flags().BoolP("yes", "y", false, "Restore without asking for confirmation")
+}
+
+func runVolumesBackup(cmd *cobra.Command, args []string) {
+	cfg, err := parseConfig("ftl.yaml")
+	if err != nil {
+		console.Error("Failed to parse config file:", err)
+		return
+	}
+	volume, err := deployment.ProjectVolume(cfg.Project.Name, cfg.Volumes, args[0])
+	if err != nil {
+		console.Error(err)
+		return
+	}
+
+	path, _ := cmd.Flags().GetString("output")
+	if path == "" {
+		path = fmt.Sprintf("%s-%s.tar.gz", volume, time.Now().UTC().Format("20060102T150405Z"))
+	}
+
+	runner, err := app.Connect(cfg.Server)
+	if err != nil {
+		console.Error(fmt.Sprintf("Failed to connect to server %s:", cfg.Server.Host), err)
+		return
+	}
+	defer runner.Close()
+
+	// The archive is downloaded into a temporary file, so a failed download never leaves an
+	// incomplete archive under the requested name.
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		console.Error("Failed to create the archive:", err)
+		return
+	}
+	defer os.Remove(file.Name())
+
+	console.Info(fmt.Sprintf("Backing up volume %s from server %s...", volume, cfg.Server.Host))
+	err = deployment.BackupVolume(cmd.Context(), runner, volume, file)
+	err = errors.Join(err, file.Close())
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		console.Error("Backup failed:", err)
+		return
+	}
+	console.Success(fmt.Sprintf("Volume %s backed up to %s", volume, path))
+}
+
+func runVolumesRestore(cmd *cobra.Command, args []string) {
+	cfg, err := parseConfig("ftl.yaml")
+	if err != nil {
+		console.Error("Failed to parse config file:", err)
+		return
+	}
+	volume, err := deployment.ProjectVolume(cfg.Project.Name, cfg.Volumes, args[0])
+	if err != nil {
+		console.Error(err)
+		return
+	}
+
+	file, err := os.Open(args[1])
+	if err != nil {
+		console.Error("Failed to open the archive:", err)
+		return
+	}
+	defer file.Close()
+
+	runner, err := app.Connect(cfg.Server)
+	if err != nil {
+		console.Error(fmt.Sprintf("Failed to connect to server %s:", cfg.Server.Host), err)
+		return
+	}
+	defer runner.Close()
+
+	if yes, _ := cmd.Flags().GetBool("yes"); !yes {
+		containers, err := deployment.VolumeContainers(cmd.Context(), runner, volume)
+		if err != nil {
+			console.Error(err)
+			return
+		}
+		confirmed, err := confirmVolumeRestore(volume, cfg.Server.Host, containers)
+		if err != nil {
+			console.Error("Failed to read answer:", err)
+			return
+		}
+		if !confirmed {
+			console.Info("Restore cancelled")
+			return
+		}
+	}
+
+	console.Info(fmt.Sprintf("Restoring volume %s on server %s from %s...", volume, cfg.Server.Host, args[1]))
+	if err := deployment.RestoreVolume(cmd.Context(), runner, volume, file); err != nil {
+		console.Error("Restore failed:", err)
+		return
+	}
+	console.Success(fmt.Sprintf("Volume %s restored from %s", volume, args[1]))
+}
+
+// confirmVolumeRestore asks whether the files of the volume may be replaced.
+func confirmVolumeRestore(volume, host string, containers []string) (bool, error) {
+	if !console.IsInteractive() {
+		return false, errors.New("restoring overwrites the volume; run with --yes to confirm")
+	}
+
+	console.Warning(fmt.Sprintf("Restoring removes all files of volume %s on server %s.", volume, host))
+	if len(containers) > 0 {
+		console.Warning(fmt.Sprintf("Containers %s use the volume and are stopped until the restore is done.", strings.Join(containers, ", ")))
+	}
+	console.Input("Restore the volume? [y/N]:")
+	answer, err := console.ReadLine()
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
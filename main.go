@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
@@ -11,17 +12,25 @@ import (
 )
 
 func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
+		if cmd.Cancellable() {
+			// Let the command clean up; a second signal exits right away.
+			cancel()
+			<-c
+		}
 		console.Reset()
 		os.Exit(1)
 	}()
 
 	defer console.Reset()
 
-	if err := cmd.Execute(); err != nil {
+	if err := cmd.Execute(ctx); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
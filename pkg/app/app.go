@@ -0,0 +1,100 @@
+// Package app runs the setup, build and deployment of a project defined by an ftl.yaml
+// configuration. It reports their progress as a stream of deployment events instead of
+// printing it, so ftl can be embedded in other tools; the ftl commands are built on it.
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/yarlson/ftl/pkg/build"
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/deployment"
+	"github.com/yarlson/ftl/pkg/runner/local"
+	"github.com/yarlson/ftl/pkg/runner/remote"
+	"github.com/yarlson/ftl/pkg/server"
+	"github.com/yarlson/ftl/pkg/ssh"
+)
+
+// eventBuffer is the number of events an operation may run ahead of its consumer.
+const eventBuffer = 64
+
+// App sets up, builds and deploys the project of a configuration. It runs one operation at a
+// time.
+type App struct {
+	cfg     *config.Config
+	builder *build.Build
+	// connect opens the SSH connection to the server for a deployment.
+	connect func(server config.Server) (*remote.Runner, error)
+
+	setupResults []server.StepResult
+	imageReports []ImageReport
+	transferred  int64
+}
+
+// New returns the App of cfg. Images are built with the local docker CLI. Deploy connects to
+// the server with SSH and wires the image syncer and the deployment for every deployment,
+// since each one needs its own local image store.
+func New(cfg *config.Config) *App {
+	return &App{
+		cfg:     cfg,
+		builder: build.NewBuild(local.NewRunner()),
+		connect: Connect,
+	}
+}
+
+// Connect opens an SSH connection to server as its user, which is re-established when it
+// drops.
+func Connect(server config.Server) (*remote.Runner, error) {
+	sshClient, _, err := ssh.ConnectWithKeyOrPassword(server.Host, server.Port, server.User, server.SSHKey, server.Passwd)
+	if err != nil {
+		if server.PasswordEnv != "" && server.Passwd == "" {
+			return nil, fmt.Errorf("%w; set %s to log in with the password", err, server.PasswordEnv)
+		}
+		return nil, err
+	}
+
+	runner := remote.NewRunner(sshClient)
+	runner.SetMaxSessions(server.MaxSessions)
+	runner.SetReconnect(func() (*gossh.Client, error) {
+		client, _, err := ssh.ConnectWithKeyOrPassword(server.Host, server.Port, server.User, server.SSHKey, server.Passwd)
+		return client, err
+	})
+	runner.SetKeepAlive(server.KeepAliveInterval.Duration(), server.KeepAliveCountMax)
+	return runner, nil
+}
+
+// Setup prepares the server for deployments in the background and returns the events of its
+// steps like Deploy. SetupResults returns what each step did once the channel is closed.
+func (a *App) Setup(ctx context.Context, opts server.Options) <-chan deployment.Event {
+	a.setupResults = nil
+	return run(func(report deployment.Reporter) error {
+		results, err := server.Setup(ctx, a.cfg, opts, report)
+		a.setupResults = results
+		return err
+	})
+}
+
+// SetupResults returns the outcome of the steps run by the last Setup.
+func (a *App) SetupResults() []server.StepResult {
+	return a.setupResults
+}
+
+// run runs operation in the background and returns the events it reports, possibly from
+// several goroutines. The channel is closed after the EventFinished event, which carries the
+// error of operation. The caller must drain the channel.
+func run(operation func(report deployment.Reporter) error) <-chan deployment.Event {
+	events := make(chan deployment.Event, eventBuffer)
+
+	go func() {
+		defer close(events)
+		started := time.Now()
+		err := operation(func(event deployment.Event) { events <- event })
+		events <- deployment.Event{Type: deployment.EventFinished, Err: err, Started: started, Time: time.Now()}
+	}()
+
+	return events
+}
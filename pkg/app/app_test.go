@@ -0,0 +1,161 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/build"
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/deployment"
+	"github.com/yarlson/ftl/pkg/runner/remote"
+)
+
+// fakeDocker answers the docker commands of a build and records them.
+type fakeDocker struct {
+	mu       sync.Mutex
+	commands []string
+	failPush bool
+}
+
+func (d *fakeDocker) RunCommand(ctx context.Context, command string, args ...string) (io.ReadCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	joined := strings.Join(args, " ")
+	d.commands = append(d.commands, command+" "+joined)
+
+	switch {
+	case strings.HasPrefix(joined, "image inspect"):
+		return io.NopCloser(strings.NewReader("2048")), nil
+	case strings.HasPrefix(joined, "push") && d.failPush:
+		return nil, errors.New("denied: requested access to the resource is denied")
+	}
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (d *fakeDocker) RunCommandWithEnv(ctx context.Context, env []string, command string, args ...string) (io.ReadCloser, error) {
+	return d.RunCommand(ctx, command, args...)
+}
+
+func (d *fakeDocker) RunCommands(ctx context.Context, commands []string) error {
+	return nil
+}
+
+func newTestApp(t *testing.T, cfg *config.Config, docker *fakeDocker) *App {
+	// Build reports are kept in the user cache directory.
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	a := New(cfg)
+	a.builder = build.NewBuild(docker)
+	return a
+}
+
+func collect(events <-chan deployment.Event) []deployment.Event {
+	var collected []deployment.Event
+	for event := range events {
+		collected = append(collected, event)
+	}
+	return collected
+}
+
+// outcomes returns the type of the last event of every step.
+func outcomes(events []deployment.Event) map[string]deployment.EventType {
+	steps := make(map[string]deployment.EventType)
+	for _, event := range events {
+		if event.Step != "" {
+			steps[event.Step] = event.Type
+		}
+	}
+	return steps
+}
+
+func buildTestConfig() *config.Config {
+	return &config.Config{
+		Project: config.Project{Name: "shop"},
+		Services: []config.Service{
+			{Name: "web", Path: "./web"},
+			{Name: "api", Image: "registry.example.com/api:1", Path: "./api"},
+		},
+	}
+}
+
+func TestBuild(t *testing.T) {
+	docker := &fakeDocker{}
+	a := newTestApp(t, buildTestConfig(), docker)
+
+	events := collect(a.Build(context.Background(), BuildOptions{}))
+
+	last := events[len(events)-1]
+	assert.Equal(t, deployment.EventFinished, last.Type)
+	assert.NoError(t, last.Err)
+	assert.Equal(t, map[string]deployment.EventType{
+		"build/web": deployment.EventCompleted,
+		"build/api": deployment.EventCompleted,
+		"push/api":  deployment.EventCompleted,
+	}, outcomes(events))
+	assert.Contains(t, docker.commands, "docker push registry.example.com/api:1")
+
+	reports := a.ImageReports()
+	require.Len(t, reports, 2)
+	for _, report := range reports {
+		assert.Equal(t, int64(2048), report.Current.Size)
+		assert.Nil(t, report.Previous)
+	}
+
+	// The reports of the first build are the previous reports of the next one.
+	collect(a.Build(context.Background(), BuildOptions{SkipPush: true}))
+	require.Len(t, a.ImageReports(), 2)
+	assert.NotNil(t, a.ImageReports()[0].Previous)
+}
+
+func TestBuild_Failures(t *testing.T) {
+	cfg := buildTestConfig()
+	cfg.Services = append(cfg.Services, config.Service{Name: "worker", Image: "registry.example.com/worker:1", Platform: "linux/amd64,linux/arm64"})
+	a := newTestApp(t, cfg, &fakeDocker{failPush: true})
+
+	events := collect(a.Build(context.Background(), BuildOptions{}))
+
+	last := events[len(events)-1]
+	assert.Equal(t, deployment.EventFinished, last.Type)
+	assert.ErrorContains(t, last.Err, "failed to push service api: failed to push image: denied")
+	assert.Equal(t, deployment.EventFailed, outcomes(events)["push/api"])
+	assert.Equal(t, deployment.EventCompleted, outcomes(events)["build/worker"])
+	assert.NotContains(t, outcomes(events), "push/worker")
+
+	// An image for several platforms can't be kept in the local image store.
+	events = collect(a.Build(context.Background(), BuildOptions{SkipPush: true}))
+	assert.ErrorContains(t, events[len(events)-1].Err, "service worker is built for several platforms")
+	assert.Equal(t, deployment.EventFailed, outcomes(events)["build/worker"])
+	assert.Equal(t, deployment.EventCompleted, outcomes(events)["build/api"])
+}
+
+func TestDeploy_ConnectFailure(t *testing.T) {
+	a := New(&config.Config{Project: config.Project{Name: "shop"}, Server: config.Server{Host: "shop.example.com"}})
+	a.connect = func(server config.Server) (*remote.Runner, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	events := collect(a.Deploy(context.Background(), DeployOptions{}))
+
+	require.Len(t, events, 3)
+	assert.Equal(t, deployment.EventStarted, events[0].Type)
+	assert.Equal(t, "Connecting to server", events[0].Message)
+	assert.Equal(t, deployment.EventFailed, events[1].Type)
+	assert.Equal(t, "Failed to connect to server shop.example.com", events[1].Message)
+	assert.Equal(t, deployment.EventFinished, events[2].Type)
+	assert.EqualError(t, events[2].Err, "failed to connect to server shop.example.com: connection refused")
+}
+
+func TestHasArchitecture(t *testing.T) {
+	assert.True(t, hasArchitecture([]string{"linux/amd64", "linux/arm64"}, "arm64"))
+	assert.True(t, hasArchitecture([]string{"linux/arm/v7"}, "arm"))
+	assert.False(t, hasArchitecture([]string{"linux/amd64"}, "arm64"))
+}
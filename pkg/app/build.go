@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/yarlson/ftl/pkg/build"
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/deployment"
+)
+
+// BuildOptions controls Build.
+type BuildOptions struct {
+	// SkipPush keeps the built images local instead of pushing the images of services with an
+	// image to their registry.
+	SkipPush bool
+}
+
+// ImageReport pairs the report of a freshly built image with the report of its previous build,
+// which is nil for the first build.
+type ImageReport struct {
+	Current  *build.Report
+	Previous *build.Report
+}
+
+// Build builds and pushes the images of all services concurrently in the background and
+// returns the events of their build and push steps like Deploy. ImageReports returns the size
+// reports of the built images once the channel is closed.
+func (a *App) Build(ctx context.Context, opts BuildOptions) <-chan deployment.Event {
+	a.imageReports = nil
+	return run(func(report deployment.Reporter) error {
+		return a.build(ctx, opts, report)
+	})
+}
+
+// ImageReports returns the size reports of the images built by the last Build.
+func (a *App) ImageReports() []ImageReport {
+	return a.imageReports
+}
+
+func (a *App) build(ctx context.Context, opts BuildOptions, report deployment.Reporter) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+
+	for _, svc := range a.cfg.Services {
+		wg.Add(1)
+		go func(svc config.Service) {
+			defer wg.Done()
+
+			imageReport, err := a.buildService(ctx, &svc, opts, report)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if imageReport != nil {
+				a.imageReports = append(a.imageReports, *imageReport)
+			}
+		}(svc)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("errors occurred during build/push: %w", err)
+	}
+	return nil
+}
+
+// buildService builds and pushes the image of svc and returns its size report, if the built
+// image could be inspected.
+func (a *App) buildService(ctx context.Context, svc *config.Service, opts BuildOptions, report deployment.Reporter) (*ImageReport, error) {
+	image := svc.Image
+	if image == "" {
+		image = fmt.Sprintf("%s-%s", a.cfg.Project.Name, svc.Name)
+	}
+
+	step := deployment.StartLocalStep(report, "build/"+svc.Name, fmt.Sprintf("Building service %s", svc.Name))
+	platforms := a.cfg.ServicePlatforms(svc)
+	if len(platforms) > 1 && opts.SkipPush {
+		step.Fail(fmt.Sprintf("Service %s is built for several platforms, which have to be pushed", svc.Name), nil)
+		return nil, fmt.Errorf("service %s is built for several platforms, which can't be kept without pushing them", svc.Name)
+	}
+	if err := a.builder.Build(ctx, image, svc.Path, platforms, svc.Build); err != nil {
+		step.Fail(fmt.Sprintf("Failed to build service %s", svc.Name), err)
+		return nil, fmt.Errorf("failed to build service %s: %w", svc.Name, err)
+	}
+	step.Complete()
+
+	var imageReport *ImageReport
+	if current, err := a.builder.Report(ctx, image); err == nil {
+		previous, _ := build.LoadLastReport(image)
+		_ = build.SaveReport(current)
+		imageReport = &ImageReport{Current: current, Previous: previous}
+	}
+
+	// Local images are synced on deploy, and buildx already pushed the images for several
+	// platforms.
+	if opts.SkipPush || svc.Image == "" || len(platforms) > 1 {
+		return imageReport, nil
+	}
+
+	step = deployment.StartLocalStep(report, "push/"+svc.Name, fmt.Sprintf("Pushing service %s", svc.Name))
+	if err := a.builder.Push(ctx, svc.Image); err != nil {
+		step.Fail(fmt.Sprintf("Failed to push service %s", svc.Name), err)
+		return imageReport, fmt.Errorf("failed to push service %s: %w", svc.Name, err)
+	}
+	step.Complete()
+	return imageReport, nil
+}
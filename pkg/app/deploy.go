@@ -0,0 +1,278 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/yarlson/ftl/pkg/build"
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/deployment"
+	"github.com/yarlson/ftl/pkg/imagesync"
+	"github.com/yarlson/ftl/pkg/runner/remote"
+	"github.com/yarlson/ftl/pkg/server"
+)
+
+// DeployOptions controls Deploy. The zero value deploys like ftl deploy without flags.
+type DeployOptions struct {
+	// ForceUnlock removes the deployment lock of the project before deploying.
+	ForceUnlock bool
+	// AllowDependencyRestart stops dependencies with data volumes that have to be updated.
+	// Without it, such a deployment fails with a deployment.DependencyRestartError.
+	AllowDependencyRestart bool
+	// RecreateNetwork recreates the project network when its subnet differs from the
+	// configuration.
+	RecreateNetwork bool
+	// KeepArtifacts keeps the local image store of a failed deployment for inspection.
+	KeepArtifacts bool
+	// Canary sends this percentage of requests to the new containers until they are promoted
+	// or aborted. Zero replaces the containers right away.
+	Canary int
+	// SkipPreflight skips the disk space checks of the server before syncing images.
+	SkipPreflight bool
+}
+
+// Deploy deploys the project to its server in the background and returns its events. The
+// steps of connecting to the server and syncing its images are reported along with those of
+// the deployment. The channel is closed after the EventFinished event, which carries the
+// error of a failed deployment; the caller must drain it. An interrupt cancels ctx, which
+// stops running hooks, removes new containers that haven't taken traffic yet and releases
+// the lock.
+func (a *App) Deploy(ctx context.Context, opts DeployOptions) <-chan deployment.Event {
+	a.transferred = 0
+	return run(func(report deployment.Reporter) error {
+		return a.deploy(ctx, opts, report)
+	})
+}
+
+// Transferred returns the bytes of image layers the last Deploy synced to the server.
+func (a *App) Transferred() int64 {
+	return a.transferred
+}
+
+func (a *App) deploy(ctx context.Context, opts DeployOptions, report deployment.Reporter) (err error) {
+	cfg := a.cfg
+	project := cfg.Project.Name
+	hostname := cfg.Server.Host
+
+	step := deployment.StartLocalStep(report, "connect", "Connecting to server")
+	runner, err := a.connect(cfg.Server)
+	if err != nil {
+		step.Fail(fmt.Sprintf("Failed to connect to server %s", hostname), err)
+		return fmt.Errorf("failed to connect to server %s: %w", hostname, err)
+	}
+	defer runner.Close()
+	step.Complete()
+
+	if !opts.SkipPreflight {
+		warnServerProblems(ctx, runner, report)
+	}
+	arch := serverArchitecture(ctx, runner, report)
+
+	step = deployment.StartLocalStep(report, "setup", "Setting up deployment")
+	localStore, err := os.MkdirTemp("", imagesync.TempStorePrefix)
+	if err != nil {
+		step.Fail("Failed to create local store", err)
+		return fmt.Errorf("failed to create local store: %w", err)
+	}
+	defer func() {
+		if err != nil && opts.KeepArtifacts {
+			deployment.StartLocalStep(report, "artifacts", fmt.Sprintf("Kept local image store %s", localStore)).Complete()
+			return
+		}
+		if rmErr := imagesync.RemoveStore(localStore); rmErr != nil {
+			deployment.StartLocalStep(report, "artifacts", "Removing local image store").Fail("Failed to remove local image store", rmErr)
+		}
+	}()
+
+	syncer := imagesync.NewImageSync(imagesync.Config{
+		LocalStore:   localStore,
+		MaxParallel:  1,
+		Architecture: arch,
+	}, runner)
+	defer func() { a.transferred = syncer.Transferred() }()
+	deploy := deployment.NewDeployment(runner, syncer)
+	deploy.AllowDependencyRestarts(opts.AllowDependencyRestart)
+	deploy.RecreateNetwork(opts.RecreateNetwork)
+	deploy.Canary(opts.Canary)
+	step.Complete()
+
+	if cfg.Deploy.DockerAPI {
+		docker, err := deployment.ConnectDockerAPI(ctx, runner.DialDocker)
+		if err != nil {
+			deployment.Warn(report, "docker-api", "Docker API unavailable, using the docker CLI", err)
+		} else {
+			defer docker.Close()
+			deploy.UseDockerAPI(docker)
+			deployment.StartLocalStep(report, "docker-api", "Connected to Docker API").Complete()
+		}
+	}
+
+	reportExpectedTransfer(project, cfg.Services, report)
+
+	if err := checkPlatforms(cfg, project, syncer, arch, report); err != nil {
+		return err
+	}
+
+	if !opts.SkipPreflight {
+		if err := checkImageSpace(ctx, runner, syncer, project, cfg.Services, report); err != nil {
+			return err
+		}
+	}
+
+	if opts.ForceUnlock {
+		if err := deploy.ForceUnlock(ctx, project); err != nil {
+			return err
+		}
+	}
+
+	// The finished event of the deployment is replaced by the one of the App, which follows
+	// the removal of the local image store.
+	for event := range deploy.Deploy(ctx, project, cfg) {
+		if event.Type == deployment.EventFinished {
+			err = event.Err
+			continue
+		}
+		report(event)
+	}
+
+	return err
+}
+
+// reportExpectedTransfer reports the size of locally built images recorded by the last build,
+// which is the upper bound of what image sync has to transfer.
+func reportExpectedTransfer(project string, services []config.Service, report deployment.Reporter) {
+	var total int64
+	var images []string
+
+	for _, svc := range services {
+		if svc.Image != "" {
+			continue
+		}
+
+		imageReport, err := build.LoadLastReport(fmt.Sprintf("%s-%s", project, svc.Name))
+		if err != nil || imageReport == nil {
+			continue
+		}
+
+		total += imageReport.Size
+		images = append(images, fmt.Sprintf("%s %s", svc.Name, build.FormatBytes(imageReport.Size)))
+	}
+
+	if len(images) == 0 {
+		return
+	}
+
+	deployment.StartLocalStep(report, "transfer",
+		fmt.Sprintf("Expected image transfer up to %s (%s)", build.FormatBytes(total), strings.Join(images, ", "))).Complete()
+}
+
+// warnServerProblems warns about the problems of the server found by a quick check, like too
+// little free disk space for the images, before a deployment.
+func warnServerProblems(ctx context.Context, runner *remote.Runner, report deployment.Reporter) {
+	for _, warning := range server.Preflight(ctx, runner) {
+		deployment.Warn(report, "preflight", warning, nil)
+	}
+}
+
+// checkImageSpace fails when the remote store or the data directory of Docker lacks the space
+// for the locally built images that changed, before syncing them fails midway with an error of
+// the Docker daemon. Problems with the check itself are only warned about.
+func checkImageSpace(ctx context.Context, runner *remote.Runner, syncer *imagesync.ImageSync, project string, services []config.Service, report deployment.Reporter) error {
+	var images []string
+	for _, svc := range services {
+		if svc.Image == "" {
+			images = append(images, fmt.Sprintf("%s-%s", project, svc.Name))
+		}
+	}
+	if len(images) == 0 {
+		return nil
+	}
+
+	step := deployment.StartLocalStep(report, "preflight", "Checking disk space on the server")
+	size, err := syncer.PendingSize(ctx, images)
+	if err != nil {
+		step.Complete()
+		deployment.Warn(report, "preflight", "Skipped the disk space check", err)
+		return nil
+	}
+	if size == 0 {
+		step.Complete()
+		return nil
+	}
+
+	disks, err := server.Disks(ctx, runner, syncer.RemoteStoreDir(), server.DockerRoot(ctx, runner))
+	if err != nil {
+		step.Complete()
+		deployment.Warn(report, "preflight", "Skipped the disk space check", err)
+		return nil
+	}
+	if err := server.CheckSpace(disks, size); err != nil {
+		message := fmt.Sprintf("Not enough disk space on the server to sync %s of images; free space, for example with docker image prune on the server, or deploy with --skip-preflight", build.FormatBytes(size))
+		step.Fail(message, err)
+		return fmt.Errorf("not enough disk space on the server to sync %s of images: %w", build.FormatBytes(size), err)
+	}
+	step.Complete()
+	return nil
+}
+
+// serverArchitecture returns the architecture of the server, or an empty string, after warning,
+// when it can't be detected.
+func serverArchitecture(ctx context.Context, runner *remote.Runner, report deployment.Reporter) string {
+	arch, err := server.Architecture(ctx, runner)
+	if err != nil {
+		deployment.Warn(report, "architecture", "Couldn't detect the architecture of the server, images aren't checked against it", err)
+		return ""
+	}
+	return arch
+}
+
+// checkPlatforms fails when a service is built for another architecture than the server's: the
+// local images synced to the server, and the platforms set in ftl.yaml for images pulled from
+// a registry. Registry images without a platform set may well be multi-platform, so they are
+// left to docker pull.
+func checkPlatforms(cfg *config.Config, project string, syncer *imagesync.ImageSync, arch string, report deployment.Reporter) error {
+	if arch == "" {
+		return nil
+	}
+
+	step := deployment.StartLocalStep(report, "platform", fmt.Sprintf("Checking images against the %s server", arch))
+	var errs []error
+	for _, svc := range cfg.Services {
+		if svc.Image == "" {
+			if err := syncer.CheckArchitecture(fmt.Sprintf("%s-%s", project, svc.Name)); err != nil {
+				errs = append(errs, fmt.Errorf("service %s: %w", svc.Name, err))
+			}
+			continue
+		}
+		if svc.Platform == "" && cfg.Project.Platform == "" {
+			continue
+		}
+
+		platforms := cfg.ServicePlatforms(&svc)
+		if !hasArchitecture(platforms, arch) {
+			errs = append(errs, fmt.Errorf("service %s: image %s is built for %s but the server runs %s; add linux/%s to its platform in ftl.yaml and run ftl build again",
+				svc.Name, svc.Image, strings.Join(platforms, ","), arch, arch))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		step.Fail("Images don't match the architecture of the server", err)
+		return err
+	}
+	step.Complete()
+	return nil
+}
+
+// hasArchitecture reports whether one of the os/arch[/variant] platforms is for arch.
+func hasArchitecture(platforms []string, arch string) bool {
+	for _, platform := range platforms {
+		parts := strings.Split(platform, "/")
+		if len(parts) > 1 && parts[1] == arch {
+			return true
+		}
+	}
+	return false
+}
@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"io"
 	"strings"
+
+	"github.com/yarlson/ftl/pkg/config"
 )
 
 type Runner interface {
 	RunCommand(ctx context.Context, command string, args ...string) (io.ReadCloser, error)
+	RunCommandWithEnv(ctx context.Context, env []string, command string, args ...string) (io.ReadCloser, error)
 	RunCommands(ctx context.Context, commands []string) error
 }
 
@@ -20,17 +23,13 @@ func NewBuild(runner Runner) *Build {
 	return &Build{runner: runner}
 }
 
-func (b *Build) Build(ctx context.Context, image, path string) error {
-	labelKey := "org.opencontainers.image.vendor"
-	labelValue := "ftl"
+// imageLabel marks the images built by ftl, so the dangling ones can be removed after a build.
+const imageLabel = "org.opencontainers.image.vendor=ftl"
 
-	_, err := b.runner.RunCommand(ctx,
-		"docker", "build",
-		"-t", image,
-		"--platform", "linux/amd64",
-		"--label", fmt.Sprintf("%s=%s", labelKey, labelValue),
-		path,
-	)
+// Build builds image from path for platforms. An image for several platforms can't be kept in
+// the local image store, so it is built with buildx and pushed to its registry right away.
+func (b *Build) Build(ctx context.Context, image, path string, platforms []string, opts *config.Build) error {
+	_, err := b.runner.RunCommandWithEnv(ctx, []string{"DOCKER_BUILDKIT=1"}, "docker", buildArgs(image, path, platforms, opts)...)
 	if err != nil {
 		return fmt.Errorf("failed to build image: %w", err)
 	}
@@ -38,7 +37,7 @@ func (b *Build) Build(ctx context.Context, image, path string) error {
 	outputReader, err := b.runner.RunCommand(ctx,
 		"docker", "images",
 		"--filter", "dangling=true",
-		"--filter", fmt.Sprintf("label=%s=%s", labelKey, labelValue),
+		"--filter", "label="+imageLabel,
 		"--format", "{{.ID}}",
 	)
 	if err != nil {
@@ -56,8 +55,8 @@ func (b *Build) Build(ctx context.Context, image, path string) error {
 		return nil
 	}
 
-	args := append([]string{"rmi", "--force"}, imageIDs...)
-	_, err = b.runner.RunCommand(ctx, "docker", args...)
+	rmiArgs := append([]string{"rmi", "--force"}, imageIDs...)
+	_, err = b.runner.RunCommand(ctx, "docker", rmiArgs...)
 	if err != nil {
 		return fmt.Errorf("failed to remove images: %w", err)
 	}
@@ -65,6 +64,49 @@ func (b *Build) Build(ctx context.Context, image, path string) error {
 	return nil
 }
 
+// buildArgs returns the arguments of docker building image from path for platforms. The
+// docker build of the default builder only exports inline caches, so a build exporting its
+// cache runs with buildx and loads the image into the local image store.
+func buildArgs(image, path string, platforms []string, opts *config.Build) []string {
+	args := []string{"build"}
+	switch {
+	case len(platforms) > 1:
+		args = []string{"buildx", "build", "--push"}
+	case opts != nil && len(opts.CacheTo) > 0:
+		args = []string{"buildx", "build", "--load"}
+	}
+	args = append(args,
+		"-t", image,
+		"--platform", strings.Join(platforms, ","),
+		"--label", imageLabel,
+	)
+	args = append(args, buildKitArgs(opts)...)
+	return append(args, path)
+}
+
+// buildKitArgs translates the BuildKit options into docker build flags.
+func buildKitArgs(opts *config.Build) []string {
+	if opts == nil {
+		return nil
+	}
+
+	var args []string
+	for _, ssh := range opts.SSH {
+		args = append(args, "--ssh", ssh)
+	}
+	for _, secret := range opts.Secrets {
+		args = append(args, "--secret", fmt.Sprintf("id=%s,src=%s", secret.ID, secret.Src))
+	}
+	for _, cacheFrom := range opts.CacheFrom {
+		args = append(args, "--cache-from", cacheFrom)
+	}
+	for _, cacheTo := range opts.CacheTo {
+		args = append(args, "--cache-to", cacheTo)
+	}
+
+	return args
+}
+
 func (b *Build) Push(ctx context.Context, image string) error {
 	_, err := b.runner.RunCommand(ctx, "docker", "push", image)
 	if err != nil {
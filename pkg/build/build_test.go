@@ -0,0 +1,26 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestBuildArgs(t *testing.T) {
+	assert.Equal(t, []string{
+		"build", "-t", "shop-web", "--platform", "linux/arm64", "--label", "org.opencontainers.image.vendor=ftl",
+		"--ssh", "default", ".",
+	}, buildArgs("shop-web", ".", []string{"linux/arm64"}, &config.Build{SSH: []string{"default"}}))
+
+	assert.Equal(t, []string{
+		"buildx", "build", "--push", "-t", "registry.example.com/web", "--platform", "linux/amd64,linux/arm64",
+		"--label", "org.opencontainers.image.vendor=ftl", "./web",
+	}, buildArgs("registry.example.com/web", "./web", []string{"linux/amd64", "linux/arm64"}, nil))
+
+	assert.Equal(t, []string{
+		"buildx", "build", "--load", "-t", "shop-web", "--platform", "linux/amd64", "--label", "org.opencontainers.image.vendor=ftl",
+		"--cache-to", "type=registry,ref=registry.example.com/web:cache", ".",
+	}, buildArgs("shop-web", ".", []string{"linux/amd64"}, &config.Build{CacheTo: []string{"type=registry,ref=registry.example.com/web:cache"}}))
+}
@@ -0,0 +1,203 @@
+package build
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layer describes a single image layer and the instruction that created it.
+type Layer struct {
+	ID        string `json:"id"`
+	CreatedBy string `json:"created_by"`
+	Size      int64  `json:"size"`
+}
+
+// Report summarizes the size of a built image.
+type Report struct {
+	Image   string    `json:"image"`
+	Size    int64     `json:"size"`
+	Layers  []Layer   `json:"layers"`
+	Created time.Time `json:"created"`
+}
+
+// TopLayers returns up to n layers ordered from largest to smallest.
+func (r *Report) TopLayers(n int) []Layer {
+	layers := make([]Layer, len(r.Layers))
+	copy(layers, r.Layers)
+	sort.SliceStable(layers, func(i, j int) bool {
+		return layers[i].Size > layers[j].Size
+	})
+	if len(layers) > n {
+		layers = layers[:n]
+	}
+	return layers
+}
+
+// Report inspects a local image and returns its size and layer breakdown.
+func (b *Build) Report(ctx context.Context, image string) (*Report, error) {
+	sizeOutput, err := b.runner.RunCommand(ctx, "docker", "image", "inspect", "--format", "{{.Size}}", image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image: %w", err)
+	}
+	defer sizeOutput.Close()
+
+	sizeBytes, err := io.ReadAll(sizeOutput)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image size: %w", err)
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(string(sizeBytes)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image size: %w", err)
+	}
+
+	historyOutput, err := b.runner.RunCommand(ctx, "docker", "history", "--no-trunc", "--human=false", "--format", "{{json .}}", image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image history: %w", err)
+	}
+	defer historyOutput.Close()
+
+	layers, err := parseHistory(historyOutput)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		Image:   image,
+		Size:    size,
+		Layers:  layers,
+		Created: time.Now(),
+	}, nil
+}
+
+// parseHistory parses `docker history --format '{{json .}}'` output, one JSON object per line.
+func parseHistory(r io.Reader) ([]Layer, error) {
+	var layers []Layer
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var entry struct {
+			ID        string `json:"ID"`
+			CreatedBy string `json:"CreatedBy"`
+			Size      string `json:"Size"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse image history: %w", err)
+		}
+
+		size, err := strconv.ParseInt(entry.Size, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse layer size %q: %w", entry.Size, err)
+		}
+
+		layers = append(layers, Layer{
+			ID:        entry.ID,
+			CreatedBy: cleanInstruction(entry.CreatedBy),
+			Size:      size,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read image history: %w", err)
+	}
+
+	return layers, nil
+}
+
+// cleanInstruction strips the shell wrapper docker adds to RUN instructions.
+func cleanInstruction(createdBy string) string {
+	createdBy = strings.TrimPrefix(createdBy, "/bin/sh -c #(nop) ")
+	createdBy = strings.TrimPrefix(createdBy, "/bin/sh -c ")
+	return strings.Join(strings.Fields(createdBy), " ")
+}
+
+// reportPath returns the cache location of the last report for the image.
+func reportPath(image string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache directory: %w", err)
+	}
+
+	name := strings.NewReplacer(":", "_", "/", "_").Replace(image)
+	return filepath.Join(cacheDir, "ftl", "image-reports", name+".json"), nil
+}
+
+// LoadLastReport returns the report saved for the image by the previous build, or nil if there is none.
+func LoadLastReport(image string) (*Report, error) {
+	path, err := reportPath(image)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image report: %w", err)
+	}
+
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse image report: %w", err)
+	}
+
+	return &report, nil
+}
+
+// SaveReport stores the report so the next build can compare against it.
+func SaveReport(report *Report) error {
+	path, err := reportPath(report.Image)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create image report directory: %w", err)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal image report: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write image report: %w", err)
+	}
+
+	return nil
+}
+
+// FormatBytes renders a byte count using binary units.
+func FormatBytes(size int64) string {
+	const unit = 1024
+	if size < 0 {
+		return "-" + FormatBytes(-size)
+	}
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
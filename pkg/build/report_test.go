@@ -0,0 +1,58 @@
+package build
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRunner struct {
+	outputs map[string]string
+}
+
+func (r *fakeRunner) RunCommand(ctx context.Context, command string, args ...string) (io.ReadCloser, error) {
+	return r.RunCommandWithEnv(ctx, nil, command, args...)
+}
+
+func (r *fakeRunner) RunCommandWithEnv(ctx context.Context, env []string, command string, args ...string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(r.outputs[args[0]])), nil
+}
+
+func (r *fakeRunner) RunCommands(ctx context.Context, commands []string) error {
+	return nil
+}
+
+func TestReport(t *testing.T) {
+	runner := &fakeRunner{outputs: map[string]string{
+		"image": "1500\n",
+		"history": strings.Join([]string{
+			`{"ID":"sha256:c","CreatedBy":"/bin/sh -c #(nop)  CMD [\"app\"]","Size":"0"}`,
+			`{"ID":"sha256:b","CreatedBy":"/bin/sh -c npm install","Size":"1000"}`,
+			`{"ID":"sha256:a","CreatedBy":"/bin/sh -c #(nop) ADD file:1 in / ","Size":"500"}`,
+		}, "\n"),
+	}}
+
+	report, err := NewBuild(runner).Report(context.Background(), "my-app")
+	require.NoError(t, err)
+
+	assert.Equal(t, "my-app", report.Image)
+	assert.Equal(t, int64(1500), report.Size)
+	require.Len(t, report.Layers, 3)
+	assert.Equal(t, `CMD ["app"]`, report.Layers[0].CreatedBy)
+
+	top := report.TopLayers(2)
+	require.Len(t, top, 2)
+	assert.Equal(t, "npm install", top[0].CreatedBy)
+	assert.Equal(t, "ADD file:1 in /", top[1].CreatedBy)
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 B", FormatBytes(512))
+	assert.Equal(t, "1.5 KiB", FormatBytes(1536))
+	assert.Equal(t, "2.0 MiB", FormatBytes(2*1024*1024))
+	assert.Equal(t, "-1.0 KiB", FormatBytes(-1024))
+}
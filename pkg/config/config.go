@@ -4,13 +4,18 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
-	"time"
+	"unicode"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/joho/godotenv"
@@ -18,17 +23,565 @@ import (
 )
 
 type Config struct {
+	// Include lists files or glob patterns, relative to the configuration file, whose services,
+	// dependencies and volumes are added to the configuration.
+	Include      []string     `yaml:"include"`
 	Project      Project      `yaml:"project" validate:"required"`
 	Server       Server       `yaml:"server" validate:"required"`
 	Services     []Service    `yaml:"services" validate:"required,dive"`
 	Dependencies []Dependency `yaml:"dependencies" validate:"dive"`
 	Volumes      []string     `yaml:"volumes" validate:"dive"`
+	Deploy       Deploy       `yaml:"deploy"`
+	Dev          Dev          `yaml:"dev"`
+	Registries   []Registry   `yaml:"registries" validate:"dive"`
+	Proxy        Proxy        `yaml:"proxy"`
+	Jobs         []Job        `yaml:"jobs" validate:"dive"`
+	Hooks        *Hooks       `yaml:"hooks"`
+	// Notifications are webhooks told about the start and outcome of every deployment.
+	Notifications []Notification `yaml:"notifications" validate:"dive"`
+	// Environment is the environment whose overrides were applied, if any.
+	Environment string `yaml:"-"`
+}
+
+// Job is a command run on a schedule in a one-off container on the project network.
+type Job struct {
+	Name string `yaml:"name" validate:"required,job_name"`
+	// Image runs the job in this image. Either Image or Service is set.
+	Image string `yaml:"image" validate:"required_without=Service,excluded_with=Service"`
+	// Service runs the job in the image of a service, with the service environment and volumes.
+	Service string `yaml:"service"`
+	// Command is split into arguments like a shell would, without running one. See SplitCommand.
+	Command  string `yaml:"command" validate:"required,job_command"`
+	Schedule string `yaml:"schedule" validate:"required,cron_schedule"`
+	Env      Env    `yaml:"env"`
+}
+
+// cronMacros are the schedule shorthands accepted in place of the five cron fields.
+var cronMacros = map[string]bool{
+	"@yearly": true, "@annually": true, "@monthly": true, "@weekly": true,
+	"@daily": true, "@midnight": true, "@hourly": true,
+}
+
+// validCronSchedule reports whether schedule is a cron macro or has the five cron fields,
+// each made of numbers, names, and the `*`, `,`, `-` and `/` operators.
+func validCronSchedule(schedule string) bool {
+	if cronMacros[schedule] {
+		return true
+	}
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 {
+		return false
+	}
+	for _, field := range fields {
+		for _, r := range field {
+			if !strings.ContainsRune("*,-/", r) && !('0' <= r && r <= '9') && !('a' <= r && r <= 'z') && !('A' <= r && r <= 'Z') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validCookieName reports whether nginx can read the cookie with a $cookie_ variable, which
+// only takes letters, digits and underscores.
+func validCookieName(name string) bool {
+	for _, r := range name {
+		if !('0' <= r && r <= '9') && !('a' <= r && r <= 'z') && !('A' <= r && r <= 'Z') && r != '_' {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// validJobName reports whether name can be used in file and container names.
+func validJobName(name string) bool {
+	for i, r := range name {
+		if !('0' <= r && r <= '9') && !('a' <= r && r <= 'z') && (i == 0 || (r != '-' && r != '_')) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// SplitCommand splits command into arguments the way a shell would, honouring single quotes,
+// double quotes and backslash escapes. Shell operators and expansions are not interpreted.
+func SplitCommand(command string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range command {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case unicode.IsSpace(r):
+			if inWord {
+				args = append(args, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape in command %q", command)
+	}
+	if inWord {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// validateJobs checks that job names are unique and that jobs refer to existing services.
+func validateJobs(cfg *Config) []string {
+	var problems []string
+	names := make(map[string]bool)
+	for _, job := range cfg.Jobs {
+		if names[job.Name] {
+			problems = append(problems, fmt.Sprintf("job %q is defined more than once", job.Name))
+			continue
+		}
+		names[job.Name] = true
+
+		if job.Service == "" {
+			continue
+		}
+		found := false
+		for _, svc := range cfg.Services {
+			if svc.Name == job.Service {
+				found = true
+				break
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("job %q refers to unknown service %q", job.Name, job.Service))
+		}
+	}
+	return problems
+}
+
+// Proxy holds settings of the Nginx reverse proxy.
+type Proxy struct {
+	// MaintenancePage is shown while a service can't be reached, e.g. during a deploy. It is
+	// either inline HTML or the path of an HTML file; a built-in page is used when empty.
+	MaintenancePage string `yaml:"maintenance_page"`
+	// ExtraHTTP is inserted verbatim into the http context of the generated nginx configuration.
+	ExtraHTTP string `yaml:"extra_http"`
+	// ExtraServer is inserted verbatim into the server block of every project domain.
+	ExtraServer string `yaml:"extra_server"`
+	// LogFormat is the format of the access log, LogFormatJSON (default) or LogFormatCombined.
+	LogFormat string `yaml:"log_format" validate:"omitempty,oneof=json combined"`
+	// HSTS configures the Strict-Transport-Security header, which is sent by default.
+	HSTS *HSTS `yaml:"hsts"`
+	// SSLCiphers replaces the built-in list of modern TLS 1.2 ciphers.
+	SSLCiphers string `yaml:"ssl_ciphers"`
+	// OCSPStapling staples the OCSP responses of the certificates to the handshake. Defaults to true.
+	OCSPStapling *bool `yaml:"ocsp_stapling"`
+}
+
+// DefaultHSTSMaxAge is the max-age of the Strict-Transport-Security header in seconds, one year.
+const DefaultHSTSMaxAge = 31536000
+
+// HSTS configures the Strict-Transport-Security header, which makes browsers use HTTPS only.
+type HSTS struct {
+	// Enabled sends the header. Defaults to true.
+	Enabled *bool `yaml:"enabled"`
+	// MaxAge is how long browsers remember to use HTTPS only, in seconds. Zero uses DefaultHSTSMaxAge.
+	MaxAge            int  `yaml:"max_age" validate:"min=0"`
+	IncludeSubdomains bool `yaml:"include_subdomains"`
+	Preload           bool `yaml:"preload"`
+}
+
+// HSTSHeader returns the value of the Strict-Transport-Security header, or an empty string
+// when it is disabled.
+func (p Proxy) HSTSHeader() string {
+	hsts := HSTS{}
+	if p.HSTS != nil {
+		hsts = *p.HSTS
+	}
+	if hsts.Enabled != nil && !*hsts.Enabled {
+		return ""
+	}
+
+	maxAge := hsts.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultHSTSMaxAge
+	}
+	header := fmt.Sprintf("max-age=%d", maxAge)
+	if hsts.IncludeSubdomains {
+		header += "; includeSubDomains"
+	}
+	if hsts.Preload {
+		header += "; preload"
+	}
+	return header
+}
+
+// Stapling reports whether the proxy staples OCSP responses.
+func (p Proxy) Stapling() bool {
+	return p.OCSPStapling == nil || *p.OCSPStapling
+}
+
+// Access log formats of the proxy.
+const (
+	LogFormatJSON     = "json"
+	LogFormatCombined = "combined"
+)
+
+// AccessLogFormat returns the format of the access log of the proxy.
+func (p Proxy) AccessLogFormat() string {
+	if p.LogFormat == "" {
+		return LogFormatJSON
+	}
+	return p.LogFormat
+}
+
+// Registry holds the credentials deployments use to log into a container registry before
+// pulling images. Use environment variables for the password, e.g. "${GHCR_TOKEN}".
+type Registry struct {
+	// Server is the registry host, e.g. "ghcr.io". Docker Hub is used when empty.
+	Server   string `yaml:"server"`
+	Username string `yaml:"username" validate:"required"`
+	Password string `yaml:"password" validate:"required"`
+}
+
+// Deploy holds settings that control the deployment process itself.
+type Deploy struct {
+	LockTimeout Duration `yaml:"lock_timeout"`
+	// DockerAPI makes deployments manage service containers, networks and volumes and inspect
+	// images through the Docker Engine API of the server. Images are still pulled, and hooks and
+	// health checks run, with the docker CLI.
+	DockerAPI bool `yaml:"docker_api"`
+	// HistoryLimit is the number of deployment manifests kept on the server for ftl history and
+	// ftl rollback. Zero keeps DefaultHistoryLimit.
+	HistoryLimit int `yaml:"history_limit" validate:"min=0"`
+	// MaxParallel is the number of services deployed at the same time. Zero uses DefaultMaxParallel.
+	MaxParallel int `yaml:"max_parallel" validate:"min=0"`
+	// MaxPulls is the number of images pulled at the same time. Zero uses DefaultMaxPulls.
+	MaxPulls int     `yaml:"max_pulls" validate:"min=0"`
+	Metrics  Metrics `yaml:"metrics"`
+}
+
+// Metrics sets where ftl deploy exports the metrics of each deployment, in the Prometheus
+// exposition format.
+type Metrics struct {
+	// Textfile is a local file the metrics are written to, like one read by the textfile
+	// collector of node_exporter. Relative paths are relative to the configuration file.
+	Textfile string `yaml:"textfile"`
+	// Pushgateway is the URL of a Prometheus Pushgateway the metrics are pushed to.
+	Pushgateway string `yaml:"pushgateway" validate:"omitempty,http_url"`
+}
+
+const (
+	// DefaultHistoryLimit is the number of deployment manifests kept when Deploy.HistoryLimit isn't set.
+	DefaultHistoryLimit = 10
+	// DefaultMaxParallel is the number of services deployed at the same time when Deploy.MaxParallel isn't set.
+	DefaultMaxParallel = 4
+	// DefaultMaxPulls is the number of images pulled at the same time when Deploy.MaxPulls isn't set.
+	DefaultMaxPulls = 2
+)
+
+// Dev holds settings used only by local development commands.
+type Dev struct {
+	ReverseTunnels []ReverseTunnel `yaml:"reverse_tunnels" validate:"dive"`
+}
+
+// ReverseTunnel forwards a port on the server back to a port on the local machine.
+type ReverseTunnel struct {
+	Local  int `yaml:"local" validate:"required,min=1,max=65535"`
+	Remote int `yaml:"remote" validate:"required,min=1,max=65535"`
 }
 
 type Project struct {
-	Name   string `yaml:"name" validate:"required"`
-	Domain string `yaml:"domain" validate:"required,fqdn"`
-	Email  string `yaml:"email" validate:"required,email"`
+	Name string `yaml:"name" validate:"required"`
+	// Domain is the primary domain, the first entry of Domains.
+	Domain string `yaml:"-" validate:"required,fqdn"`
+	// Domains holds every domain given in `domain`, which may be a string or a list.
+	Domains []string `yaml:"-" validate:"dive,fqdn"`
+	Email   string   `yaml:"email" validate:"required,email"`
+	// RedirectWWW serves www.<domain> for every project domain and redirects it to the domain.
+	RedirectWWW bool `yaml:"redirect_www"`
+	// Compression enables gzip compression of text responses in the proxy.
+	Compression bool `yaml:"compression"`
+	// TLS replaces the certificates obtained from Let's Encrypt with provided or self-signed
+	// ones, or turns HTTPS off.
+	TLS *TLS `yaml:"tls"`
+	// Network holds the options the project network is created with.
+	Network *Network `yaml:"network"`
+	// Platform is the platform the images of services are built for unless they set their own,
+	// like linux/arm64. Defaults to DefaultPlatform.
+	Platform string `yaml:"platform" validate:"omitempty,platform"`
+}
+
+// Network holds the options of the project network, applied when it is created.
+type Network struct {
+	// Subnet is the IPv4 or IPv6 range of the network in CIDR notation, like 172.28.0.0/16.
+	Subnet string `yaml:"subnet" validate:"omitempty,cidr"`
+	// Gateway is the address of the gateway within Subnet.
+	Gateway string `yaml:"gateway" validate:"omitempty,ip"`
+	// Attachable lets containers started by hand, like debug containers, join the network.
+	Attachable bool `yaml:"attachable"`
+	// DriverOpts are passed to docker network create as --opt values.
+	DriverOpts map[string]string `yaml:"driver_opts"`
+}
+
+// TLSSelfSigned is the `tls` value that makes the proxy use self-signed certificates.
+const TLSSelfSigned = "self_signed"
+
+// TLSDisabled is the `tls` value that makes the proxy serve plain HTTP only.
+const TLSDisabled = "disabled"
+
+// tlsError is the error of a `tls` value that is neither of its forms.
+const tlsError = "line %d: tls must be %q, %q or a mapping with cert_file and key_file"
+
+// TLS holds the certificates the proxy uses instead of obtaining them from Let's Encrypt.
+// It is either a certificate and key file, used for every domain, self-signed certificates
+// or no certificates at all, with HTTPS disabled.
+type TLS struct {
+	CertFile   string
+	KeyFile    string
+	SelfSigned bool
+	Disabled   bool
+}
+
+// tlsFiles is the mapping form of TLS.
+type tlsFiles struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// UnmarshalYAML accepts `tls` as "self_signed", as "disabled" or as a mapping with cert_file
+// and key_file.
+func (t *TLS) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		switch node.Value {
+		case TLSSelfSigned:
+			t.SelfSigned = true
+		case TLSDisabled:
+			t.Disabled = true
+		default:
+			return fmt.Errorf(tlsError, node.Line, TLSSelfSigned, TLSDisabled)
+		}
+		return nil
+	case yaml.MappingNode:
+		var files tlsFiles
+		if err := node.Decode(&files); err != nil {
+			return err
+		}
+		if files.CertFile == "" || files.KeyFile == "" {
+			return fmt.Errorf("line %d: tls requires both cert_file and key_file", node.Line)
+		}
+		t.CertFile, t.KeyFile = files.CertFile, files.KeyFile
+		return nil
+	default:
+		return fmt.Errorf(tlsError, node.Line, TLSSelfSigned, TLSDisabled)
+	}
+}
+
+// PlainHTTP reports whether the proxy serves plain HTTP only, set with `tls: disabled`.
+func (p *Project) PlainHTTP() bool {
+	return p.TLS != nil && p.TLS.Disabled
+}
+
+// ProxyPorts returns the host ports published by the proxy: 80 for HTTP and 443 for HTTPS.
+func (p *Project) ProxyPorts() []int {
+	if p.PlainHTTP() {
+		return []int{80}
+	}
+	return []int{80, 443}
+}
+
+type projectAlias Project
+
+// projectFields is the mapping form of Project, with the domain either a string or a list.
+type projectFields struct {
+	projectAlias `yaml:",inline"`
+	Domain       yaml.Node `yaml:"domain"`
+}
+
+// UnmarshalYAML accepts `domain` either as a single string or as a list of domains.
+func (p *Project) UnmarshalYAML(node *yaml.Node) error {
+	var raw projectFields
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+	*p = Project(raw.projectAlias)
+
+	switch raw.Domain.Kind {
+	case 0:
+	case yaml.ScalarNode:
+		p.Domains = []string{raw.Domain.Value}
+	case yaml.SequenceNode:
+		if err := raw.Domain.Decode(&p.Domains); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("line %d: domain must be a string or a list of strings", raw.Domain.Line)
+	}
+
+	if len(p.Domains) > 0 {
+		p.Domain = p.Domains[0]
+	}
+	return nil
+}
+
+// AllDomains returns the project domains, falling back to Domain when Domains isn't set.
+func (p *Project) AllDomains() []string {
+	if len(p.Domains) > 0 {
+		return p.Domains
+	}
+	if p.Domain != "" {
+		return []string{p.Domain}
+	}
+	return nil
+}
+
+// validateDomainRoutes checks that every domain served by the proxy has at least one route.
+func validateDomainRoutes(cfg *Config) []string {
+	routed := make(map[string]bool)
+	for i := range cfg.Services {
+		for j := range cfg.Services[i].Routes {
+			for _, domain := range cfg.RouteDomains(&cfg.Services[i], &cfg.Services[i].Routes[j]) {
+				routed[domain] = true
+			}
+		}
+	}
+
+	var problems []string
+	for _, domain := range cfg.Domains() {
+		if !routed[domain] {
+			problems = append(problems, fmt.Sprintf("domain %q is not routed to any service", domain))
+		}
+	}
+	return problems
+}
+
+// Domains returns every domain served by the proxy: the project domains followed by the other
+// domains services and routes are served on, without duplicates.
+func (c *Config) Domains() []string {
+	var domains []string
+	seen := make(map[string]bool)
+	add := func(list []string) {
+		for _, domain := range list {
+			if !seen[domain] {
+				seen[domain] = true
+				domains = append(domains, domain)
+			}
+		}
+	}
+
+	add(c.Project.AllDomains())
+	for i := range c.Services {
+		for j := range c.Services[i].Routes {
+			add(c.RouteDomains(&c.Services[i], &c.Services[i].Routes[j]))
+		}
+	}
+	return domains
+}
+
+// Redirect sends every request for the From domain to the To domain.
+type Redirect struct {
+	From string
+	To   string
+}
+
+// WWWRedirects returns a www.<domain> to <domain> redirect for every project domain when
+// RedirectWWW is set. Domains starting with "www." and www domains served by a route are left out.
+func (c *Config) WWWRedirects() []Redirect {
+	if !c.Project.RedirectWWW {
+		return nil
+	}
+
+	served := make(map[string]bool)
+	for _, domain := range c.Domains() {
+		served[domain] = true
+	}
+
+	var redirects []Redirect
+	for _, domain := range c.Project.AllDomains() {
+		if www := "www." + domain; !strings.HasPrefix(domain, "www.") && !served[www] {
+			redirects = append(redirects, Redirect{From: www, To: domain})
+		}
+	}
+	return redirects
+}
+
+// CertificateDomains returns every domain the proxy needs a certificate for: the served
+// domains followed by the www redirect domains.
+func (c *Config) CertificateDomains() []string {
+	domains := c.Domains()
+	for _, redirect := range c.WWWRedirects() {
+		domains = append(domains, redirect.From)
+	}
+	return domains
+}
+
+// privateTLDs are the top-level domains reserved or commonly used for private networks.
+var privateTLDs = []string{"localhost", "local", "lan", "internal", "intranet", "home.arpa", "test", "corp", "home"}
+
+// PublicDomains returns the domains the proxy serves that aren't under a top-level domain of
+// private networks, like .internal or .lan, and so may be reached from the internet.
+func (c *Config) PublicDomains() []string {
+	var public []string
+	for _, domain := range c.CertificateDomains() {
+		private := false
+		for _, tld := range privateTLDs {
+			if domain == tld || strings.HasSuffix(domain, "."+tld) {
+				private = true
+				break
+			}
+		}
+		if !private {
+			public = append(public, domain)
+		}
+	}
+	return public
+}
+
+// PlainHTTPWarning returns the warning about the public domains served over plain HTTP with
+// `tls: disabled`, or "" when there are none.
+func (c *Config) PlainHTTPWarning() string {
+	public := c.PublicDomains()
+	if !c.Project.PlainHTTP() || len(public) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("TLS is disabled: requests to %s, with their passwords and cookies, travel unencrypted and can be read or changed by anyone on the way; use tls: disabled only on private networks",
+		strings.Join(public, ", "))
+}
+
+// RouteDomains returns the domains a route is served on: its host, otherwise the service
+// domains, otherwise all project domains.
+func (c *Config) RouteDomains(service *Service, route *Route) []string {
+	if route.Host != "" {
+		return []string{route.Host}
+	}
+	if len(service.Domains) > 0 {
+		return service.Domains
+	}
+	return c.Project.AllDomains()
 }
 
 type Server struct {
@@ -38,33 +591,381 @@ type Server struct {
 	Passwd     string `yaml:"-"`
 	SSHKey     string `yaml:"ssh_key" validate:"required,filepath"`
 	RootSSHKey string `yaml:"-"`
+	// PasswordEnv names the environment variable holding the SSH password, used when the server
+	// accepts no key, like a fresh server before setup. Passwd is read from it.
+	PasswordEnv string `yaml:"password_env"`
+	// FirewallAllow lists extra "port/protocol" rules opened by setup, e.g. "27015/udp".
+	FirewallAllow []string `yaml:"firewall_allow" validate:"dive,firewall_rule"`
+	// HardenSSH disables password and root login over SSH and installs fail2ban during setup.
+	HardenSSH bool `yaml:"harden_ssh"`
+	// Swap is the size of the swapfile created by setup, e.g. "2G". No swapfile is created when empty.
+	Swap Size `yaml:"swap"`
+	// MaxSessions limits the SSH sessions ftl opens at once on the server. Defaults to 8.
+	MaxSessions int `yaml:"max_sessions" validate:"omitempty,min=1"`
+	// KeepAliveInterval is the time between keepalive requests on the SSH connection, like
+	// ServerAliveInterval of OpenSSH. Defaults to 30s.
+	KeepAliveInterval Duration `yaml:"keepalive_interval"`
+	// KeepAliveCountMax is the number of unanswered keepalive requests after which the connection
+	// is considered lost and replaced, like ServerAliveCountMax of OpenSSH. Defaults to 3.
+	KeepAliveCountMax int `yaml:"keepalive_count_max" validate:"min=0"`
+	// GPU makes setup install the NVIDIA container toolkit, which services with gpus need.
+	GPU bool `yaml:"gpu"`
+}
+
+// ParseFirewallRule parses a "port/protocol" firewall rule. The protocol is tcp or udp
+// and defaults to tcp when omitted.
+func ParseFirewallRule(rule string) (int, string, error) {
+	portStr, protocol, ok := strings.Cut(rule, "/")
+	if !ok {
+		protocol = "tcp"
+	}
+	protocol = strings.ToLower(protocol)
+	if protocol != "tcp" && protocol != "udp" {
+		return 0, "", fmt.Errorf("invalid protocol in firewall rule %q: expected tcp or udp", rule)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, "", fmt.Errorf("invalid port in firewall rule %q", rule)
+	}
+
+	return port, protocol, nil
+}
+
+// hostGateway is the extra host address Docker replaces with the address of the host.
+const hostGateway = "host-gateway"
+
+// ParseExtraHost parses a "host:ip" entry of extra_hosts. The address is an IP address or
+// host-gateway, the address of the server as seen from the container.
+func ParseExtraHost(entry string) (string, string, error) {
+	host, ip, ok := strings.Cut(entry, ":")
+	if !ok || host == "" || strings.ContainsAny(host, " \t") {
+		return "", "", fmt.Errorf("invalid extra host %q: expected host:ip", entry)
+	}
+	if ip != hostGateway && net.ParseIP(ip) == nil {
+		return "", "", fmt.Errorf("invalid address in extra host %q: expected an IP address or %s", entry, hostGateway)
+	}
+	return host, ip, nil
+}
+
+// ValidLabelKey reports whether key can be the key of a container label. Keys starting with
+// "ftl." are reserved for the labels ftl sets itself.
+func ValidLabelKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, "= \t\n") && !strings.HasPrefix(key, "ftl.")
+}
+
+// capabilities are the Linux capabilities accepted by cap_add and cap_drop, besides ALL.
+var capabilities = map[string]bool{
+	"AUDIT_CONTROL": true, "AUDIT_READ": true, "AUDIT_WRITE": true, "BLOCK_SUSPEND": true,
+	"BPF": true, "CHECKPOINT_RESTORE": true, "CHOWN": true, "DAC_OVERRIDE": true,
+	"DAC_READ_SEARCH": true, "FOWNER": true, "FSETID": true, "IPC_LOCK": true,
+	"IPC_OWNER": true, "KILL": true, "LEASE": true, "LINUX_IMMUTABLE": true,
+	"MAC_ADMIN": true, "MAC_OVERRIDE": true, "MKNOD": true, "NET_ADMIN": true,
+	"NET_BIND_SERVICE": true, "NET_BROADCAST": true, "NET_RAW": true, "PERFMON": true,
+	"SETFCAP": true, "SETGID": true, "SETPCAP": true, "SETUID": true,
+	"SYSLOG": true, "SYS_ADMIN": true, "SYS_BOOT": true, "SYS_CHROOT": true,
+	"SYS_MODULE": true, "SYS_NICE": true, "SYS_PACCT": true, "SYS_PTRACE": true,
+	"SYS_RAWIO": true, "SYS_RESOURCE": true, "SYS_TIME": true, "SYS_TTY_CONFIG": true,
+	"WAKE_ALARM": true,
+}
+
+// ValidCapability reports whether name is ALL or a Linux capability, like NET_ADMIN. The
+// name is case-insensitive and may carry the CAP_ prefix, as with docker run.
+func ValidCapability(name string) bool {
+	name = strings.TrimPrefix(strings.ToUpper(name), "CAP_")
+	return name == "ALL" || capabilities[name]
+}
+
+// ValidGPUs reports whether gpus is a --gpus value ftl accepts: all, a number of GPUs, or
+// device= followed by comma-separated GPU indexes or UUIDs, like device=0,1.
+func ValidGPUs(gpus string) bool {
+	if gpus == "all" {
+		return true
+	}
+	if n, err := strconv.Atoi(gpus); err == nil {
+		return n > 0
+	}
+
+	devices, ok := strings.CutPrefix(gpus, "device=")
+	if !ok || devices == "" {
+		return false
+	}
+	for _, device := range strings.Split(devices, ",") {
+		if device == "" || strings.ContainsAny(device, " \t\"'") {
+			return false
+		}
+	}
+	return true
+}
+
+// DefaultPlatform is the platform images are built for when neither the service nor the
+// project sets one.
+const DefaultPlatform = "linux/amd64"
+
+// ServicePlatforms returns the platforms the image of svc is built for.
+func (c *Config) ServicePlatforms(svc *Service) []string {
+	platform := svc.Platform
+	if platform == "" {
+		platform = c.Project.Platform
+	}
+	if platform == "" {
+		platform = DefaultPlatform
+	}
+	return strings.Split(platform, ",")
+}
+
+// ValidPlatform reports whether platform is an os/arch[/variant] platform of Docker, like
+// linux/arm64 or linux/arm/v7, or several of them separated by commas.
+func ValidPlatform(platform string) bool {
+	for _, p := range strings.Split(platform, ",") {
+		parts := strings.Split(p, "/")
+		if len(parts) < 2 || len(parts) > 3 {
+			return false
+		}
+		for _, part := range parts {
+			if part == "" || strings.Trim(part, "abcdefghijklmnopqrstuvwxyz0123456789_") != "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// DefaultRestartPolicy is the restart policy of containers that don't set one.
+const DefaultRestartPolicy = "unless-stopped"
+
+// ValidRestartPolicy reports whether policy is a Docker restart policy: no, always,
+// unless-stopped, or on-failure with an optional maximum retry count, like on-failure:5.
+func ValidRestartPolicy(policy string) bool {
+	switch policy {
+	case "no", "always", "unless-stopped", "on-failure":
+		return true
+	}
+
+	retries, ok := strings.CutPrefix(policy, "on-failure:")
+	if !ok {
+		return false
+	}
+	n, err := strconv.Atoi(retries)
+	return err == nil && n > 0
+}
+
+// imageDigest matches the digest of an image pinned like repo@sha256:<digest>.
+var imageDigest = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// validImageDigest reports whether an image pinned to a digest, like
+// nginx@sha256:<64 hex digits>, has a well-formed digest. Images without a digest are valid.
+func validImageDigest(image string) bool {
+	_, digest, pinned := strings.Cut(image, "@")
+	return !pinned || imageDigest.MatchString(digest)
 }
 
 type Service struct {
-	Name         string `yaml:"name" validate:"required"`
-	Image        string `yaml:"image"`
-	ImageUpdated bool
+	Name         string              `yaml:"name" validate:"required"`
+	Image        string              `yaml:"image" validate:"omitempty,image_digest"`
+	ImageUpdated bool                `yaml:"-"`
 	Port         int                 `yaml:"port" validate:"required,min=1,max=65535"`
 	Path         string              `yaml:"path"`
 	HealthCheck  *ServiceHealthCheck `yaml:"health_check"`
 	Routes       []Route             `yaml:"routes" validate:"required,dive"`
-	Volumes      []string            `yaml:"volumes" validate:"dive,volume_reference"`
-	Command      string              `yaml:"command"`
-	CommandSlice []string            `yaml:"_"`
-	Entrypoint   []string            `yaml:"entrypoint"`
-	Env          []string            `yaml:"env"`
-	Forwards     []string            `yaml:"forwards"`
-	Recreate     bool                `yaml:"recreate"`
-	Hooks        *Hooks              `yaml:"hooks"`
-	Container    *Container          `yaml:"container"`
-	LocalPorts   []int               `yaml:"-"`
+	// ImageDigest is set during the deployment to the image the service was deployed with: its
+	// repository digest, or its ID for images without one.
+	ImageDigest string `yaml:"-"`
+	// Domains limits the service routes to these domains instead of all project domains.
+	Domains      []string `yaml:"domains" validate:"dive,fqdn"`
+	Volumes      []string `yaml:"volumes" validate:"dive,volume_reference"`
+	Command      string   `yaml:"command"`
+	CommandSlice []string `yaml:"-"`
+	Entrypoint   []string `yaml:"entrypoint"`
+	Env          Env      `yaml:"env"`
+	Forwards     []string `yaml:"forwards"`
+	Recreate     bool     `yaml:"recreate"`
+	Restart      string   `yaml:"restart" validate:"omitempty,restart_policy"`
+	// Networks are existing Docker networks the container joins besides the project network.
+	Networks []string `yaml:"networks" validate:"dive,required"`
+	// Aliases are extra host names of the container on the project network and on Networks.
+	Aliases []string `yaml:"aliases" validate:"dive,hostname_rfc1123"`
+	// DeployTimeout cancels the deployment of the service when it takes longer. Zero waits indefinitely.
+	DeployTimeout Duration `yaml:"deploy_timeout"`
+	// Sticky keeps the requests of a client on the same container while several serve the
+	// service: StickyByCookie by a session cookie, StickyByIP by the client address.
+	Sticky string `yaml:"sticky" validate:"omitempty,oneof=cookie ip"`
+	// StickyCookie is the cookie identifying the session with `sticky: cookie`. Defaults to
+	// DefaultStickyCookie.
+	StickyCookie string `yaml:"sticky_cookie" validate:"omitempty,cookie_name"`
+	// Labels, ExtraHosts and DNS are passed to docker run as --label, --add-host and --dns.
+	Labels          map[string]string `yaml:"labels" validate:"dive,keys,label_key,endkeys"`
+	ExtraHosts      []string          `yaml:"extra_hosts" validate:"dive,extra_host"`
+	DNS             []string          `yaml:"dns" validate:"dive,ip"`
+	SecurityOptions `yaml:",inline"`
+	// GPUs is passed to docker run as --gpus, like "all" or "device=0,1".
+	GPUs      string     `yaml:"gpus" validate:"omitempty,gpus"`
+	Hooks     *Hooks     `yaml:"hooks"`
+	Container *Container `yaml:"container"`
+	Build     *Build     `yaml:"build"`
+	// Platform is the platform the image is built for, like linux/arm64, overriding the one of
+	// the project. Images pushed to a registry may list several, separated by commas.
+	Platform     string `yaml:"platform" validate:"omitempty,platform"`
+	ProxyOptions `yaml:",inline"`
+	LocalPorts   []int  `yaml:"-"`
+	Expose       string `yaml:"-"`
+}
+
+// Session affinity strategies of a service.
+const (
+	StickyByCookie = "cookie"
+	StickyByIP     = "ip"
+)
+
+// DefaultStickyCookie is the session cookie of `sticky: cookie` when sticky_cookie is empty.
+const DefaultStickyCookie = "session_id"
+
+// SessionCookie returns the cookie identifying the session with `sticky: cookie`.
+func (s *Service) SessionCookie() string {
+	if s.StickyCookie == "" {
+		return DefaultStickyCookie
+	}
+	return s.StickyCookie
+}
+
+// Forward is a port mapping of a service in the docker run -p syntax,
+// [ip:]host_port:container_port[/protocol].
+type Forward struct {
+	// IP is the server address the port is published on, empty for all interfaces.
+	IP            string
+	HostPort      int
+	ContainerPort int
+	// Protocol is tcp, udp or sctp.
+	Protocol string
+}
+
+// ParseForward parses a port mapping like "8080:80", "127.0.0.1:9000:9000",
+// "[::1]:9000:9000" or "0.0.0.0:514:514/udp".
+func ParseForward(spec string) (Forward, error) {
+	invalid := fmt.Errorf("invalid port mapping %q, expected [ip:]host_port:container_port[/tcp|udp|sctp]", spec)
+
+	mapping, protocol, found := strings.Cut(spec, "/")
+	protocol = strings.ToLower(protocol)
+	if !found {
+		protocol = "tcp"
+	}
+	if protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+		return Forward{}, invalid
+	}
+
+	var forward Forward
+	if rest, ok := strings.CutPrefix(mapping, "["); ok {
+		ip, ports, ok := strings.Cut(rest, "]:")
+		if !ok || net.ParseIP(ip) == nil {
+			return Forward{}, invalid
+		}
+		forward.IP, mapping = ip, ports
+	}
+
+	parts := strings.Split(mapping, ":")
+	if len(parts) == 3 && forward.IP == "" {
+		if ip := net.ParseIP(parts[0]); ip == nil || ip.To4() == nil {
+			return Forward{}, invalid
+		}
+		forward.IP, parts = parts[0], parts[1:]
+	}
+	if len(parts) != 2 {
+		return Forward{}, invalid
+	}
+
+	for i, port := range []*int{&forward.HostPort, &forward.ContainerPort} {
+		number, err := strconv.Atoi(parts[i])
+		if err != nil || number < 1 || number > 65535 {
+			return Forward{}, invalid
+		}
+		*port = number
+	}
+	forward.Protocol = protocol
+	return forward, nil
+}
+
+// Public reports whether the port is published on all interfaces of the server.
+func (f Forward) Public() bool {
+	return f.IP == "" || net.ParseIP(f.IP).IsUnspecified()
+}
+
+// PublicRouteForwards returns the forwards of the service that publish the port its routes
+// proxy to on all interfaces of the server, where requests bypass the proxy.
+func (s *Service) PublicRouteForwards() []string {
+	if len(s.Routes) == 0 {
+		return nil
+	}
+
+	var public []string
+	for _, spec := range s.Forwards {
+		forward, err := ParseForward(spec)
+		if err == nil && forward.Public() && forward.ContainerPort == s.Port && forward.Protocol == "tcp" {
+			public = append(public, spec)
+		}
+	}
+	return public
+}
+
+// SecurityOptions restrict the container of a service or dependency.
+type SecurityOptions struct {
+	// User runs the container as this user, like "1000" or "1000:1000".
+	User     string   `yaml:"user"`
+	ReadOnly bool     `yaml:"read_only"`
+	CapAdd   []string `yaml:"cap_add" validate:"dive,capability"`
+	CapDrop  []string `yaml:"cap_drop" validate:"dive,capability"`
+	// SecurityOpt holds --security-opt values, like "no-new-privileges".
+	SecurityOpt []string `yaml:"security_opt" validate:"dive,required"`
+	// Tmpfs mounts a tmpfs at each path, with optional mount options, like "/tmp:size=64m".
+	Tmpfs []string `yaml:"tmpfs" validate:"dive,tmpfs_mount"`
+}
+
+// Build holds BuildKit specific options used when building the service image.
+type Build struct {
+	SSH       []string      `yaml:"ssh"`
+	Secrets   []BuildSecret `yaml:"secrets" validate:"dive"`
+	CacheFrom []string      `yaml:"cache_from"`
+	CacheTo   []string      `yaml:"cache_to"`
+}
+
+// BuildSecret exposes a local file to the build as a BuildKit secret mount.
+type BuildSecret struct {
+	ID  string `yaml:"id" validate:"required"`
+	Src string `yaml:"src" validate:"required"`
 }
 
 type ServiceHealthCheck struct {
-	Path     string        `yaml:"path"`
-	Interval time.Duration `yaml:"interval"`
-	Timeout  time.Duration `yaml:"timeout"`
-	Retries  int           `yaml:"retries"`
+	// Type is the kind of probe, defaulting to HealthCheckHTTP.
+	Type string `yaml:"type" validate:"omitempty,oneof=http cmd tcp external"`
+	// Port is the port probed by http, tcp and external checks, defaulting to the service port.
+	Port int `yaml:"port" validate:"omitempty,min=1,max=65535"`
+	// Path is the path requested by http and external checks.
+	Path string `yaml:"path"`
+	// Cmd is run in the container by cmd checks and exits with 0 when the service is healthy.
+	Cmd      string   `yaml:"cmd" validate:"required_if=Type cmd,excluded_unless=Type cmd"`
+	Interval Duration `yaml:"interval"`
+	Timeout  Duration `yaml:"timeout"`
+	Retries  int      `yaml:"retries"`
+}
+
+// Service health check types.
+const (
+	// HealthCheckHTTP requests the health check path with curl and expects a success status.
+	HealthCheckHTTP = "http"
+	// HealthCheckCmd runs the health check command in the container.
+	HealthCheckCmd = "cmd"
+	// HealthCheckTCP opens a connection to the port with bash, for images without curl.
+	HealthCheckTCP = "tcp"
+	// HealthCheckExternal requests the health check path from a temporary curl container on
+	// the project network, for images without curl or a shell.
+	HealthCheckExternal = "external"
+)
+
+// CheckType returns the type of the health check, defaulting to HealthCheckHTTP.
+func (h *ServiceHealthCheck) CheckType() string {
+	if h.Type == "" {
+		return HealthCheckHTTP
+	}
+	return h.Type
 }
 
 type Container struct {
@@ -80,42 +981,299 @@ type ULimit struct {
 }
 
 type ContainerHealthCheck struct {
-	Cmd          string `yaml:"cmd"`
-	Interval     string `yaml:"interval"`
-	Retries      int    `yaml:"retries"`
-	Timeout      string `yaml:"timeout"`
-	StartPeriod  string `yaml:"start_period"`
-	StartTimeout string `yaml:"start_timeout"`
+	Cmd          string   `yaml:"cmd"`
+	Interval     Duration `yaml:"interval"`
+	Retries      int      `yaml:"retries"`
+	Timeout      Duration `yaml:"timeout"`
+	StartPeriod  Duration `yaml:"start_period"`
+	StartTimeout Duration `yaml:"start_timeout"`
 }
 
 type Route struct {
-	PathPrefix  string `yaml:"path" validate:"required"`
+	PathPrefix  string `yaml:"path" validate:"required,nginx_path"`
 	StripPrefix bool   `yaml:"strip_prefix"`
+	// Host limits the route to a single domain, overriding the service domains.
+	Host string `yaml:"host" validate:"omitempty,fqdn"`
+	// CacheControl is sent as the Cache-Control header of responses, replacing the one set by the service.
+	CacheControl string `yaml:"cache_control" validate:"omitempty,cache_control"`
+	// Auth protects the route with HTTP basic authentication.
+	Auth *RouteAuth `yaml:"auth"`
+	// AllowIPs limits access to the route to these addresses and CIDR ranges.
+	AllowIPs []string `yaml:"allow_ips" validate:"dive,ip|cidr"`
+	// ExtraLocation is inserted verbatim into the nginx location block of the route.
+	ExtraLocation string `yaml:"extra_location"`
+	ProxyOptions  `yaml:",inline"`
+}
+
+// RouteAuth is the basic authentication user of a route. The password is read from the
+// PasswordEnv environment variable at deploy time and only its bcrypt hash reaches the server.
+type RouteAuth struct {
+	Username    string `yaml:"username" validate:"required,excludes=:"`
+	PasswordEnv string `yaml:"password_env" validate:"required"`
+}
+
+// ProxyOptions tunes how the proxy forwards requests. Options set on a route override the
+// options of its service.
+type ProxyOptions struct {
+	// MaxBodySize limits the request body size; 0 disables the limit.
+	MaxBodySize *Size `yaml:"max_body_size"`
+	// ProxyReadTimeout and ProxySendTimeout bound the time between two reads or writes;
+	// 0 effectively disables them.
+	ProxyReadTimeout *Duration `yaml:"proxy_read_timeout"`
+	ProxySendTimeout *Duration `yaml:"proxy_send_timeout"`
+	// Buffering turns response buffering on or off, e.g. off for server-sent events.
+	Buffering    *bool             `yaml:"buffering"`
+	ExtraHeaders map[string]string `yaml:"extra_headers" validate:"dive,keys,header_name,endkeys,nginx_value"`
+}
+
+// Merge returns the options with the fields set in override replacing their values.
+// Extra headers are combined, with override winning for the same header.
+func (o ProxyOptions) Merge(override ProxyOptions) ProxyOptions {
+	merged := o
+	if override.MaxBodySize != nil {
+		merged.MaxBodySize = override.MaxBodySize
+	}
+	if override.ProxyReadTimeout != nil {
+		merged.ProxyReadTimeout = override.ProxyReadTimeout
+	}
+	if override.ProxySendTimeout != nil {
+		merged.ProxySendTimeout = override.ProxySendTimeout
+	}
+	if override.Buffering != nil {
+		merged.Buffering = override.Buffering
+	}
+	if len(override.ExtraHeaders) > 0 {
+		merged.ExtraHeaders = make(map[string]string, len(o.ExtraHeaders)+len(override.ExtraHeaders))
+		for name, value := range o.ExtraHeaders {
+			merged.ExtraHeaders[name] = value
+		}
+		for name, value := range override.ExtraHeaders {
+			merged.ExtraHeaders[name] = value
+		}
+	}
+	return merged
+}
+
+// validHeaderName reports whether name is an HTTP header field name token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", r) && !('0' <= r && r <= '9') && !('a' <= r && r <= 'z') && !('A' <= r && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// validCacheControl reports whether value is a list of Cache-Control directives such as
+// "public, max-age=3600". Directive values must be tokens; quoted strings are not supported.
+func validCacheControl(value string) bool {
+	for _, directive := range strings.Split(value, ",") {
+		name, arg, hasArg := strings.Cut(strings.TrimSpace(directive), "=")
+		if !validHeaderName(name) || (hasArg && !validHeaderName(arg)) {
+			return false
+		}
+	}
+	return true
+}
+
+// validNginxValue reports whether value can be written into an nginx directive without ending
+// the directive or block it is part of.
+func validNginxValue(value string) bool {
+	return !strings.ContainsAny(value, ";{}\r\n")
+}
+
+// validNginxPath reports whether path can be used as an unquoted nginx location.
+func validNginxPath(path string) bool {
+	return validNginxValue(path) && !strings.ContainsAny(path, " \t\"'\\")
 }
 
 type Dependency struct {
-	Name      string     `yaml:"name" validate:"required"`
-	Image     string     `yaml:"image" validate:"required"`
-	Volumes   []string   `yaml:"volumes" validate:"dive,volume_reference"`
-	Env       []string   `yaml:"env" validate:"dive"`
-	Ports     []int      `yaml:"ports" validate:"dive,min=1,max=65535"`
-	Container *Container `yaml:"container"`
+	Name            string            `yaml:"name" validate:"required"`
+	Image           string            `yaml:"image" validate:"required,image_digest"`
+	Volumes         []string          `yaml:"volumes" validate:"dive,volume_reference"`
+	Env             Env               `yaml:"env" validate:"dive"`
+	Ports           []int             `yaml:"ports" validate:"dive,min=1,max=65535"`
+	TunnelPorts     []string          `yaml:"tunnel_ports" validate:"dive,port_mapping"`
+	Container       *Container        `yaml:"container"`
+	Restart         string            `yaml:"restart" validate:"omitempty,restart_policy"`
+	Labels          map[string]string `yaml:"labels" validate:"dive,keys,label_key,endkeys"`
+	ExtraHosts      []string          `yaml:"extra_hosts" validate:"dive,extra_host"`
+	DNS             []string          `yaml:"dns" validate:"dive,ip"`
+	SecurityOptions `yaml:",inline"`
+	// Expose controls where the dependency ports are published on the server.
+	Expose            string `yaml:"expose" validate:"omitempty,oneof=tunnel host none"`
+	IKnowThisIsPublic bool   `yaml:"i_know_this_is_public"`
+	// PreUpdate is run in the running container before it is stopped for an update, e.g. to
+	// back up the data with pg_dump.
+	PreUpdate string `yaml:"pre_update"`
+	// Backup configures the backups taken before the container is replaced.
+	Backup *Backup `yaml:"backup"`
+	// CommandSlice is the command of default configurations whose image needs one, like MinIO.
+	CommandSlice []string `yaml:"-"`
+}
+
+// Dependency port exposure modes.
+const (
+	// ExposeTunnel publishes ports on the server loopback interface, reachable through `ftl tunnels`.
+	ExposeTunnel = "tunnel"
+	// ExposeHost publishes ports on all server interfaces.
+	ExposeHost = "host"
+	// ExposeNone doesn't publish any ports; the dependency is only reachable on the project network.
+	ExposeNone = "none"
+)
+
+// Backup configures the backups of a dependency, which are taken before its container is
+// replaced and with ftl backup run. PostgreSQL and MySQL images are backed up by default.
+type Backup struct {
+	// Command writes the backup to its standard output. It runs in the dependency container
+	// with sh -c, so it can read the variables of the container environment.
+	Command string `yaml:"command"`
+	// Retention is the number of backups of the dependency kept on the server. Zero keeps
+	// DefaultBackupRetention.
+	Retention int `yaml:"retention" validate:"min=0"`
+	// Disabled turns off the backups of an image backed up by default.
+	Disabled bool `yaml:"disabled"`
+}
+
+// DefaultBackupRetention is the number of backups of a dependency kept when Backup.Retention
+// isn't set.
+const DefaultBackupRetention = 7
+
+// defaultBackupCommands holds the backup command of the images backed up by default, by
+// repository name.
+var defaultBackupCommands = map[string]string{
+	"postgres": `pg_dump -U "${POSTGRES_USER:-postgres}" "${POSTGRES_DB:-${POSTGRES_USER:-postgres}}"`,
+	"mysql":    `MYSQL_PWD="$MYSQL_ROOT_PASSWORD" mysqldump -uroot --all-databases --single-transaction`,
+}
+
+// BackupCommand returns the command writing a backup of the dependency to its standard output,
+// or "" when the dependency isn't backed up.
+func (d *Dependency) BackupCommand() string {
+	if d.Backup != nil && d.Backup.Disabled {
+		return ""
+	}
+	if d.Backup != nil && d.Backup.Command != "" {
+		return d.Backup.Command
+	}
+	return defaultBackupCommands[imageRepositoryName(d.Image)]
+}
+
+// BackupRetention returns the number of backups of the dependency kept on the server.
+func (d *Dependency) BackupRetention() int {
+	if d.Backup == nil || d.Backup.Retention == 0 {
+		return DefaultBackupRetention
+	}
+	return d.Backup.Retention
+}
+
+// imageRepositoryName returns the last path element of the repository of image, without the
+// registry, tag or digest: "postgres" for "docker.io/library/postgres:16".
+func imageRepositoryName(image string) string {
+	name, _, _ := strings.Cut(image, "@")
+	name = name[strings.LastIndex(name, "/")+1:]
+	name, _, _ = strings.Cut(name, ":")
+	return name
+}
+
+// ExposeMode returns the port exposure mode of the dependency, defaulting to ExposeTunnel.
+func (d *Dependency) ExposeMode() string {
+	if d.Expose == "" {
+		return ExposeTunnel
+	}
+	return d.Expose
+}
+
+// TunnelLocalPort returns the local port `ftl tunnels` binds for the given dependency port.
+// Ports without a "local:remote" entry in TunnelPorts are bound to the same local port.
+func (d *Dependency) TunnelLocalPort(remote int) int {
+	for _, mapping := range d.TunnelPorts {
+		local, r, err := ParsePortMapping(mapping)
+		if err == nil && r == remote {
+			return local
+		}
+	}
+	return remote
+}
+
+// ParsePortMapping parses a "local:remote" port pair.
+func ParsePortMapping(mapping string) (int, int, error) {
+	localStr, remoteStr, ok := strings.Cut(mapping, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid port mapping %q: expected local:remote", mapping)
+	}
+
+	local, err := strconv.Atoi(localStr)
+	if err != nil || local < 1 || local > 65535 {
+		return 0, 0, fmt.Errorf("invalid local port in mapping %q", mapping)
+	}
+
+	remote, err := strconv.Atoi(remoteStr)
+	if err != nil || remote < 1 || remote > 65535 {
+		return 0, 0, fmt.Errorf("invalid remote port in mapping %q", mapping)
+	}
+
+	return local, remote, nil
+}
+
+// Notification is a webhook that receives a JSON summary of deployments.
+type Notification struct {
+	URL string `yaml:"url" validate:"required,http_url"`
+	// Format is json, the default, for the summary itself, or slack for a message with the
+	// summary as its text, which Slack incoming webhooks and compatible chat services accept.
+	Format string `yaml:"format" validate:"omitempty,oneof=json slack"`
+	// Events limits the notifications to these deployment events. All are sent by default.
+	Events []string `yaml:"events" validate:"dive,oneof=started succeeded failed"`
+}
+
+// Notification formats.
+const (
+	NotificationJSON  = "json"
+	NotificationSlack = "slack"
+)
+
+// Deployment events notifications are sent for.
+const (
+	NotifyStarted   = "started"
+	NotifySucceeded = "succeeded"
+	NotifyFailed    = "failed"
+)
+
+// Sends reports whether the webhook is notified of event.
+func (n Notification) Sends(event string) bool {
+	return len(n.Events) == 0 || slices.Contains(n.Events, event)
 }
 
 // Hooks now supports either a simple remote command string
-// or a map with local/remote commands.
+// or a map with local/remote commands. Project hooks run once per deployment: pre before
+// the dependencies are deployed, post once the proxy is up.
 type Hooks struct {
 	Pre  *HookItem `yaml:"pre"`
 	Post *HookItem `yaml:"post"`
 }
 
+// Hook failure policies.
+const (
+	// HookFailureAbort fails the deployment when the hook fails. It is the default.
+	HookFailureAbort = "abort"
+	// HookFailureContinue reports a failed hook and carries on with the deployment.
+	HookFailureContinue = "continue"
+)
+
 // HookItem is used for either a single remote command (as a string),
 // or a map of { remote, local } commands.
 type HookItem struct {
 	Remote string `yaml:"remote,omitempty"`
 	Local  string `yaml:"local,omitempty"`
+	// Timeout stops each command of the hook that runs longer. Zero means no limit.
+	Timeout   Duration `yaml:"timeout,omitempty"`
+	OnFailure string   `yaml:"on_failure,omitempty" validate:"omitempty,oneof=abort continue"`
 }
 
+type hookAlias HookItem
+
 // UnmarshalYAML is a custom Unmarshaler to allow HookItem to be specified as a string or map.
 func (h *HookItem) UnmarshalYAML(node *yaml.Node) error {
 	switch node.Tag {
@@ -132,7 +1290,6 @@ func (h *HookItem) UnmarshalYAML(node *yaml.Node) error {
 		// pre:
 		//   remote: "echo 'Running remote'"
 		//   local: "echo 'Running local'"
-		type hookAlias HookItem
 		var temp hookAlias
 		if err := node.Decode(&temp); err != nil {
 			return err
@@ -153,6 +1310,10 @@ func getDefaultConfig(baseName, version string) (*Dependency, bool) {
 	if !found {
 		return nil, false
 	}
+	// The env entries are expanded in place, so the defaults must not share them.
+	dep.Env = slices.Clone(dep.Env)
+	dep.Volumes = slices.Clone(dep.Volumes)
+	dep.Ports = slices.Clone(dep.Ports)
 	parts := strings.Split(dep.Image, ":")
 	if len(parts) == 2 {
 		dep.Image = parts[0] + ":" + version
@@ -162,8 +1323,24 @@ func getDefaultConfig(baseName, version string) (*Dependency, bool) {
 	return &dep, true
 }
 
+// expandEnv expands the env placeholders of a default configuration. The error names the
+// dependency, as the variables it reads don't appear in ftl.yaml.
+func (d *Dependency) expandEnv() error {
+	for i, envLine := range d.Env {
+		expanded, err := interpolate(envLine)
+		if err != nil {
+			return fmt.Errorf("dependency %q: %w", d.Name, err)
+		}
+		d.Env[i] = expanded
+	}
+	return nil
+}
+
+type dependencyAlias Dependency
+
 // UnmarshalYAML is a custom unmarshaler that handles both string-based
-// dependencies (like "mysql:8") and map-based dependencies, plus expands env vars.
+// dependencies (like "mysql:8") and map-based dependencies, plus expands the env vars
+// of default configurations.
 func (d *Dependency) UnmarshalYAML(node *yaml.Node) error {
 	switch node.Tag {
 
@@ -176,16 +1353,8 @@ func (d *Dependency) UnmarshalYAML(node *yaml.Node) error {
 			// We have a base name + version
 			base, version := parts[0], parts[1]
 			if defaultDep, ok := getDefaultConfig(base, version); ok {
-				// Expand env placeholders in the default config
-				for i, envLine := range defaultDep.Env {
-					expanded, err := expandWithEnvAndDefault(envLine)
-					if err != nil {
-						return fmt.Errorf(
-							"failed expanding env in default config for %q: %w",
-							base, err,
-						)
-					}
-					defaultDep.Env[i] = expanded
+				if err := defaultDep.expandEnv(); err != nil {
+					return err
 				}
 				*d = *defaultDep
 			} else {
@@ -197,16 +1366,8 @@ func (d *Dependency) UnmarshalYAML(node *yaml.Node) error {
 			// Only a base name (e.g., "redis")
 			base := parts[0]
 			if defaultDep, ok := getDefaultConfig(base, "latest"); ok {
-				// Expand env placeholders
-				for i, envLine := range defaultDep.Env {
-					expanded, err := expandWithEnvAndDefault(envLine)
-					if err != nil {
-						return fmt.Errorf(
-							"failed expanding env in default config for %q: %w",
-							base, err,
-						)
-					}
-					defaultDep.Env[i] = expanded
+				if err := defaultDep.expandEnv(); err != nil {
+					return err
 				}
 				*d = *defaultDep
 			} else {
@@ -219,22 +1380,12 @@ func (d *Dependency) UnmarshalYAML(node *yaml.Node) error {
 
 	case "!!map":
 		// If the node is a map, decode into the struct in the usual way.
-		type dependencyAlias Dependency
 		var tmp dependencyAlias
 		if err := node.Decode(&tmp); err != nil {
 			return fmt.Errorf("failed to decode dependency map: %w", err)
 		}
-		// Expand placeholders in tmp.Env
-		for i, envLine := range tmp.Env {
-			expanded, err := expandWithEnvAndDefault(envLine)
-			if err != nil {
-				return fmt.Errorf(
-					"failed to expand env for dependency %q: %w",
-					tmp.Name, err,
-				)
-			}
-			tmp.Env[i] = expanded
-		}
+		// The env was expanded with the rest of the file; expanding it again would turn an
+		// escaped $$ into a variable.
 		*d = Dependency(tmp)
 		return nil
 
@@ -244,78 +1395,97 @@ func (d *Dependency) UnmarshalYAML(node *yaml.Node) error {
 	}
 }
 
-type Volume struct {
-	Name string `yaml:"name" validate:"required"`
-	Path string `yaml:"path" validate:"required,unix_path"`
-}
+// Env holds environment variables as KEY=VALUE entries. In YAML it is either a list of
+// KEY=VALUE strings or a mapping of names to values, whose entries are sorted so they,
+// and the hashes of the containers using them, don't depend on the mapping order.
+type Env []string
 
-// expandWithEnvAndDefault expands environment variables within a single string.
-// It handles `${VAR:-default}` and `${VAR:?error message}` syntax. If a required
-// variable is missing, it returns an error. Otherwise, it returns the expanded
-// string and a nil error.
-func expandWithEnvAndDefault(input string) (string, error) {
-	var expansionErr error
-
-	expanded := os.Expand(input, func(key string) string {
-		val, err := expandOneVar(key)
-		if err != nil && expansionErr == nil {
-			// capture the first error
-			expansionErr = err
+// UnmarshalYAML accepts `env` either as a list of KEY=VALUE strings or as a mapping.
+func (e *Env) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var entries []string
+		if err := node.Decode(&entries); err != nil {
+			return err
 		}
-		return val
-	})
-
-	// if expansionErr != nil, expanded might be partially filled, but we return the error
-	return expanded, expansionErr
-}
-
-// expandOneVar handles a single ${...} expression inside os.Expand.
-func expandOneVar(key string) (string, error) {
-	// Check for ":-" = default fallback
-	if strings.Contains(key, ":-") {
-		parts := strings.SplitN(key, ":-", 2)
-		envKey := parts[0]
-		defaultVal := parts[1]
-		if val, ok := os.LookupEnv(envKey); ok {
-			return val, nil
+		*e = entries
+		return nil
+	case yaml.MappingNode:
+		var values map[string]string
+		if err := node.Decode(&values); err != nil {
+			return fmt.Errorf("line %d: env values must be strings, numbers or booleans: %w", node.Line, err)
 		}
-		return defaultVal, nil
-	}
-
-	// Check for ":?" = required variable
-	if strings.Contains(key, ":?") {
-		parts := strings.SplitN(key, ":?", 2)
-		envKey := parts[0]
-		errMsg := parts[1]
-		if val, ok := os.LookupEnv(envKey); ok {
-			return val, nil
+		entries := make(Env, 0, len(values))
+		for key, value := range values {
+			entries = append(entries, key+"="+value)
 		}
-		// variable not set => return an error
-		return "", fmt.Errorf("required environment variable %s not set: %s", envKey, errMsg)
+		sort.Strings(entries)
+		*e = entries
+		return nil
+	default:
+		return fmt.Errorf("line %d: env must be a list of KEY=VALUE entries or a mapping", node.Line)
 	}
+}
 
-	// Otherwise, it's just ${VAR} with no colon
-	if val, ok := os.LookupEnv(key); ok {
-		return val, nil
-	}
-	// Not found in environment => return empty string
-	return "", nil
+type Volume struct {
+	Name string `yaml:"name" validate:"required"`
+	Path string `yaml:"path" validate:"required,unix_path"`
 }
 
+// ParseOptions controls how ParseConfigWithOptions reads a configuration.
+type ParseOptions struct {
+	// Lenient ignores unknown fields instead of reporting them, e.g. to deploy a configuration
+	// written for a newer version of ftl.
+	Lenient bool
+	// Environment selects the overrides of the environments section to apply. The environment
+	// is appended to the project name, which names the network and containers on the server.
+	Environment string
+	// Dir is the directory of the configuration file, which included files and .env files are
+	// relative to. Defaults to the current directory.
+	Dir string
+}
+
+// ParseConfig parses and validates a configuration, rejecting unknown fields.
 func ParseConfig(data []byte) (*Config, error) {
-	// Load any .env file from the current directory
-	_ = godotenv.Load()
+	return ParseConfigWithOptions(data, ParseOptions{})
+}
 
-	// Process environment variables with default values
-	expandedData, err := expandWithEnvAndDefault(string(data))
-	if err != nil {
+// ParseConfigWithOptions parses and validates a configuration.
+func ParseConfigWithOptions(data []byte, opts ParseOptions) (*Config, error) {
+	// Load any .env file next to the configuration file
+	_ = godotenv.Load(filepath.Join(opts.Dir, ".env"))
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("error parsing YAML: %v", err)
+	}
+	if err := interpolateNode(&document); err != nil {
 		return nil, fmt.Errorf("error expanding environment variables: %v", err)
 	}
-
+	if err := applyEnvironment(&document, opts.Environment); err != nil {
+		return nil, err
+	}
 	var config Config
-	if err := yaml.Unmarshal([]byte(expandedData), &config); err != nil {
+	if err := document.Decode(&config); err != nil {
 		return nil, fmt.Errorf("error parsing YAML: %v", err)
 	}
+	if opts.Environment != "" {
+		config.Environment = opts.Environment
+		config.Project.Name += "-" + opts.Environment
+	}
+
+	// Collect every problem, so they can be fixed at once. Unknown fields are usually
+	// misspelled ones, which would otherwise be silently ignored.
+	var problems []string
+	if !opts.Lenient {
+		problems = unknownFields(&document, reflect.TypeOf(config))
+	}
+
+	includeProblems, err := includeFiles(&config, opts.Dir, opts.Lenient)
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, includeProblems...)
 
 	// Process .env files for services if they exist
 	for i := range config.Services {
@@ -324,6 +1494,9 @@ func ParseConfig(data []byte) (*Config, error) {
 		}
 
 		envPath := filepath.Join(config.Services[i].Path, ".env")
+		if !filepath.IsAbs(envPath) {
+			envPath = filepath.Join(opts.Dir, envPath)
+		}
 		if _, err := os.Stat(envPath); err == nil {
 			if err := godotenv.Load(envPath); err != nil {
 				return nil, fmt.Errorf("failed to read .env file: %w", err)
@@ -331,6 +1504,10 @@ func ParseConfig(data []byte) (*Config, error) {
 		}
 	}
 
+	if config.Server.PasswordEnv != "" {
+		config.Server.Passwd = os.Getenv(config.Server.PasswordEnv)
+	}
+
 	validate := validator.New()
 
 	// Register custom validations
@@ -345,8 +1522,107 @@ func ParseConfig(data []byte) (*Config, error) {
 		return strings.HasPrefix(value, "/")
 	})
 
+	_ = validate.RegisterValidation("header_name", func(fl validator.FieldLevel) bool {
+		return validHeaderName(fl.Field().String())
+	})
+
+	_ = validate.RegisterValidation("nginx_value", func(fl validator.FieldLevel) bool {
+		return validNginxValue(fl.Field().String())
+	})
+
+	_ = validate.RegisterValidation("nginx_path", func(fl validator.FieldLevel) bool {
+		return validNginxPath(fl.Field().String())
+	})
+
+	_ = validate.RegisterValidation("job_name", func(fl validator.FieldLevel) bool {
+		return validJobName(fl.Field().String())
+	})
+
+	_ = validate.RegisterValidation("cookie_name", func(fl validator.FieldLevel) bool {
+		return validCookieName(fl.Field().String())
+	})
+
+	_ = validate.RegisterValidation("job_command", func(fl validator.FieldLevel) bool {
+		args, err := SplitCommand(fl.Field().String())
+		return err == nil && len(args) > 0
+	})
+
+	_ = validate.RegisterValidation("cron_schedule", func(fl validator.FieldLevel) bool {
+		return validCronSchedule(fl.Field().String())
+	})
+
+	_ = validate.RegisterValidation("cache_control", func(fl validator.FieldLevel) bool {
+		return validCacheControl(fl.Field().String())
+	})
+
+	_ = validate.RegisterValidation("port_mapping", func(fl validator.FieldLevel) bool {
+		_, _, err := ParsePortMapping(fl.Field().String())
+		return err == nil
+	})
+
+	_ = validate.RegisterValidation("label_key", func(fl validator.FieldLevel) bool {
+		return ValidLabelKey(fl.Field().String())
+	})
+
+	_ = validate.RegisterValidation("extra_host", func(fl validator.FieldLevel) bool {
+		_, _, err := ParseExtraHost(fl.Field().String())
+		return err == nil
+	})
+
+	_ = validate.RegisterValidation("capability", func(fl validator.FieldLevel) bool {
+		return ValidCapability(fl.Field().String())
+	})
+
+	_ = validate.RegisterValidation("tmpfs_mount", func(fl validator.FieldLevel) bool {
+		path, _, _ := strings.Cut(fl.Field().String(), ":")
+		return strings.HasPrefix(path, "/")
+	})
+
+	_ = validate.RegisterValidation("gpus", func(fl validator.FieldLevel) bool {
+		return ValidGPUs(fl.Field().String())
+	})
+
+	_ = validate.RegisterValidation("platform", func(fl validator.FieldLevel) bool {
+		return ValidPlatform(fl.Field().String())
+	})
+
+	_ = validate.RegisterValidation("restart_policy", func(fl validator.FieldLevel) bool {
+		return ValidRestartPolicy(fl.Field().String())
+	})
+
+	_ = validate.RegisterValidation("image_digest", func(fl validator.FieldLevel) bool {
+		return validImageDigest(fl.Field().String())
+	})
+
+	_ = validate.RegisterValidation("firewall_rule", func(fl validator.FieldLevel) bool {
+		_, _, err := ParseFirewallRule(fl.Field().String())
+		return err == nil
+	})
+
 	if err := validate.Struct(config); err != nil {
-		return nil, fmt.Errorf("validation error: %v", err)
+		var fieldErrors validator.ValidationErrors
+		if errors.As(err, &fieldErrors) {
+			for _, fieldError := range fieldErrors {
+				problems = append(problems, fieldError.Error())
+			}
+		} else {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	for _, dep := range config.Dependencies {
+		if dep.ExposeMode() == ExposeHost && !dep.IKnowThisIsPublic {
+			problems = append(problems, fmt.Sprintf("dependency %q publishes its ports on all interfaces with expose: host; set i_know_this_is_public: true to confirm", dep.Name))
+		}
+	}
+
+	problems = append(problems, validateDomainRoutes(&config)...)
+	problems = append(problems, validateJobs(&config)...)
+	problems = append(problems, validateNginxSnippets(&config)...)
+	problems = append(problems, crossFieldProblems(&config)...)
+
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
 	}
 
 	// Collect all named volumes from config.Services and config.Dependencies,
@@ -387,6 +1663,16 @@ func ParseConfig(data []byte) (*Config, error) {
 	return &config, nil
 }
 
+// VolumeMount returns the docker run -v value of a volume mount of the project. Named volumes,
+// which start with a letter, are prefixed by the project as deploy creates them; host paths are
+// returned as they are.
+func VolumeMount(project, volume string) string {
+	if volume != "" && unicode.IsLetter(rune(volume[0])) {
+		return fmt.Sprintf("%s-%s", project, volume)
+	}
+	return volume
+}
+
 // extractNamedVolume checks if volRef is in the form "NAME:/some/path"
 // and if NAME starts with a letter. If so, it returns NAME; otherwise "".
 func extractNamedVolume(volRef string) string {
@@ -409,9 +1695,38 @@ func extractNamedVolume(volRef string) string {
 	return ""
 }
 
+// Hash returns a hash of the whole configuration, recorded with each deployment.
+func (c *Config) Hash() (string, error) {
+	// The hash is stored on the server, so it must not depend on the password.
+	cfg := *c
+	cfg.Server.Passwd = ""
+	bytes, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	hash := sha256.Sum256(bytes)
+	return hex.EncodeToString(hash[:]), nil
+}
+
 func (s *Service) Hash() (string, error) {
 	service := *s
 	service.ImageUpdated = false
+	service.ImageDigest = ""
+	service.Build = nil
+	service.DeployTimeout = 0
+	// The platform only changes the image, which is compared on its own.
+	service.Platform = ""
+	// Session affinity, the domains and the proxy options only change the proxy configuration.
+	service.Sticky, service.StickyCookie = "", ""
+	service.Domains = nil
+	service.ProxyOptions = ProxyOptions{}
+	if s.Routes != nil {
+		service.Routes = make([]Route, len(s.Routes))
+		for i, route := range s.Routes {
+			service.Routes[i] = Route{PathPrefix: route.PathPrefix, StripPrefix: route.StripPrefix}
+		}
+	}
 	sortedService := service.sortServiceFields()
 	bytes, err := json.Marshal(sortedService)
 	if err != nil {
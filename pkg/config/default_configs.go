@@ -9,7 +9,7 @@ var defaultConfigs = map[string]Dependency{
 		Ports:   []int{3306},
 		Volumes: []string{"mysql_data:/var/lib/mysql"},
 		Env: []string{
-			"MYSQL_ROOT_PASSWORD=${MYSQL_ROOT_PASSWORD}",
+			"MYSQL_ROOT_PASSWORD=${MYSQL_ROOT_PASSWORD:?MySQL needs a root password}",
 		},
 	},
 	"postgres": {
@@ -18,7 +18,7 @@ var defaultConfigs = map[string]Dependency{
 		Ports:   []int{5432},
 		Volumes: []string{"postgres_data:/var/lib/postgresql/data"},
 		Env: []string{
-			"POSTGRES_PASSWORD=${POSTGRES_PASSWORD}",
+			"POSTGRES_PASSWORD=${POSTGRES_PASSWORD:?PostgreSQL needs a superuser password}",
 		},
 	},
 	"postgresql": {
@@ -27,7 +27,7 @@ var defaultConfigs = map[string]Dependency{
 		Ports:   []int{5432},
 		Volumes: []string{"postgres_data:/var/lib/postgresql/data"},
 		Env: []string{
-			"POSTGRES_PASSWORD=${POSTGRES_PASSWORD}",
+			"POSTGRES_PASSWORD=${POSTGRES_PASSWORD:?PostgreSQL needs a superuser password}",
 		},
 	},
 	"elasticsearch": {
@@ -54,8 +54,8 @@ var defaultConfigs = map[string]Dependency{
 		Ports:   []int{27017},
 		Volumes: []string{"mongo_data:/data/db"},
 		Env: []string{
-			"MONGO_INITDB_ROOT_USERNAME=${MONGO_ROOT_USER:mongouser}",
-			"MONGO_INITDB_ROOT_PASSWORD=${MONGO_ROOT_PASSWORD}",
+			"MONGO_INITDB_ROOT_USERNAME=${MONGO_ROOT_USER:-mongouser}",
+			"MONGO_INITDB_ROOT_PASSWORD=${MONGO_ROOT_PASSWORD:?MongoDB needs a root password}",
 		},
 	},
 	"redis": {
@@ -84,4 +84,79 @@ var defaultConfigs = map[string]Dependency{
 			},
 		},
 	},
+	"kafka": {
+		Name:  "kafka",
+		Image: "apache/kafka:latest",
+		Ports: []int{9092, 9093}, // broker + KRaft controller
+		// The image owns /var/lib/kafka/data, so the volume is writable by its non-root user.
+		Volumes: []string{"kafka_data:/var/lib/kafka/data"},
+		Env: []string{
+			"KAFKA_NODE_ID=1",
+			"KAFKA_PROCESS_ROLES=broker,controller",
+			"KAFKA_LISTENERS=PLAINTEXT://:9092,CONTROLLER://:9093",
+			"KAFKA_ADVERTISED_LISTENERS=PLAINTEXT://${KAFKA_ADVERTISED_HOST:-kafka}:9092",
+			"KAFKA_CONTROLLER_LISTENER_NAMES=CONTROLLER",
+			"KAFKA_LISTENER_SECURITY_PROTOCOL_MAP=CONTROLLER:PLAINTEXT,PLAINTEXT:PLAINTEXT",
+			"KAFKA_CONTROLLER_QUORUM_VOTERS=1@localhost:9093",
+			"KAFKA_OFFSETS_TOPIC_REPLICATION_FACTOR=1",
+			"KAFKA_TRANSACTION_STATE_LOG_REPLICATION_FACTOR=1",
+			"KAFKA_TRANSACTION_STATE_LOG_MIN_ISR=1",
+			"KAFKA_LOG_DIRS=/var/lib/kafka/data",
+		},
+		Container: &Container{
+			ULimits: []ULimit{
+				{
+					Name: "nofile",
+					Soft: 65535,
+					Hard: 65535,
+				},
+			},
+		},
+	},
+	"nats": {
+		Name:  "nats",
+		Image: "nats:latest",
+		Ports: []int{4222, 8222}, // client + monitoring
+	},
+	"minio": {
+		Name:         "minio",
+		Image:        "minio/minio:latest",
+		Ports:        []int{9000, 9001}, // S3 API + console
+		Volumes:      []string{"minio_data:/data"},
+		CommandSlice: []string{"server", "/data", "--console-address", ":9001"},
+		Env: []string{
+			"MINIO_ROOT_USER=${MINIO_ROOT_USER:-minioadmin}",
+			"MINIO_ROOT_PASSWORD=${MINIO_ROOT_PASSWORD:?MinIO needs a root password of at least 8 characters}",
+		},
+	},
+	"clickhouse": {
+		Name:    "clickhouse",
+		Image:   "clickhouse/clickhouse-server:latest",
+		Ports:   []int{8123, 9000}, // HTTP + native protocol
+		Volumes: []string{"clickhouse_data:/var/lib/clickhouse"},
+		Env: []string{
+			"CLICKHOUSE_USER=${CLICKHOUSE_USER:-default}",
+			"CLICKHOUSE_PASSWORD=${CLICKHOUSE_PASSWORD:?ClickHouse needs a password for its user}",
+		},
+		Container: &Container{
+			ULimits: []ULimit{
+				{
+					Name: "nofile",
+					Soft: 262144,
+					Hard: 262144,
+				},
+			},
+		},
+	},
+	"mailpit": {
+		Name:    "mailpit",
+		Image:   "axllent/mailpit:latest",
+		Ports:   []int{1025, 8025}, // SMTP + web UI
+		Volumes: []string{"mailpit_data:/data"},
+		Env: []string{
+			"MP_DATABASE=/data/mailpit.db",
+			"MP_SMTP_AUTH_ACCEPT_ANY=1",
+			"MP_SMTP_AUTH_ALLOW_INSECURE=1",
+		},
+	},
 }
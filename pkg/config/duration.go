@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration that can be written in YAML either as a Go duration
+// string ("1m30s") or as a bare number of seconds (90).
+type Duration time.Duration
+
+// UnmarshalYAML parses the duration and rejects negative values.
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+
+	*d = parsed
+	return nil
+}
+
+// ParseDuration parses a Go duration string or a bare number of seconds.
+func ParseDuration(s string) (Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	var duration time.Duration
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if seconds > int64(math.MaxInt64/time.Second) {
+			return 0, fmt.Errorf("invalid duration %q: value is too large", s)
+		}
+		duration = time.Duration(seconds) * time.Second
+	} else {
+		duration, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: use a value like \"30s\", \"1m30s\" or a number of seconds", s)
+		}
+	}
+
+	if duration < 0 {
+		return 0, fmt.Errorf("invalid duration %q: must not be negative", s)
+	}
+
+	return Duration(duration), nil
+}
+
+// Duration returns the value as a time.Duration.
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+// String formats the duration in a form accepted by docker flags, e.g. "1m30s".
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{input: "1m30s", expected: 90 * time.Second},
+		{input: "90", expected: 90 * time.Second},
+		{input: "500ms", expected: 500 * time.Millisecond},
+		{input: "0", expected: 0},
+		{input: "", expected: 0},
+		{input: "-5s", wantErr: true},
+		{input: "-5", wantErr: true},
+		{input: "1.5", wantErr: true},
+		{input: "ten seconds", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			d, err := ParseDuration(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, d.Duration())
+		})
+	}
+}
+
+func TestDuration_UnmarshalYAML(t *testing.T) {
+	var hc ContainerHealthCheck
+	err := yaml.Unmarshal([]byte("interval: 1m30s\ntimeout: 90\n"), &hc)
+	assert.NoError(t, err)
+	assert.Equal(t, "1m30s", hc.Interval.String())
+	assert.Equal(t, "1m30s", hc.Timeout.String())
+
+	err = yaml.Unmarshal([]byte("interval: soon\n"), &hc)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid duration "soon"`)
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// environmentsKey is the top-level section holding the overrides of each environment.
+const environmentsKey = "environments"
+
+// applyEnvironment removes the environments section from the document and merges the
+// overrides of the named environment into it. No overrides are applied when name is empty.
+func applyEnvironment(document *yaml.Node, name string) error {
+	root := document
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		if name != "" {
+			return fmt.Errorf("unknown environment %q: the configuration has no environments section", name)
+		}
+		return nil
+	}
+
+	var environments *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == environmentsKey {
+			environments = resolveAlias(root.Content[i+1])
+			root.Content = slices.Delete(root.Content, i, i+2)
+			break
+		}
+	}
+
+	if environments != nil && environments.Kind != yaml.MappingNode && environments.Tag != "!!null" {
+		return fmt.Errorf("line %d: environments must map environment names to overrides", environments.Line)
+	}
+	if name == "" {
+		return nil
+	}
+	if environments == nil || len(environments.Content) == 0 {
+		return fmt.Errorf("unknown environment %q: the configuration has no environments section", name)
+	}
+
+	var names []string
+	for i := 0; i+1 < len(environments.Content); i += 2 {
+		key, overrides := environments.Content[i], resolveAlias(environments.Content[i+1])
+		if key.Value != name {
+			names = append(names, key.Value)
+			continue
+		}
+		if overrides.Kind != yaml.MappingNode {
+			return fmt.Errorf("line %d: the overrides of environment %q must be a mapping", overrides.Line, name)
+		}
+		mergeNode(root, overrides)
+		return nil
+	}
+
+	return fmt.Errorf("unknown environment %q, the configuration defines %s", name, strings.Join(names, ", "))
+}
+
+// mergeNode merges override into the mapping base: the values of mappings in both are merged
+// key by key, and any other value of override, including lists, replaces the one in base.
+func mergeNode(base, override *yaml.Node) {
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		key, value := override.Content[i], resolveAlias(override.Content[i+1])
+
+		found := false
+		for j := 0; j+1 < len(base.Content); j += 2 {
+			if base.Content[j].Value != key.Value {
+				continue
+			}
+			found = true
+			if current := resolveAlias(base.Content[j+1]); current.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+				// Copy the mapping, so an anchor shared with other parts of the file isn't changed.
+				merged := *current
+				merged.Content = slices.Clone(current.Content)
+				mergeNode(&merged, value)
+				base.Content[j+1] = &merged
+			} else {
+				base.Content[j+1] = value
+			}
+			break
+		}
+		if !found {
+			base.Content = append(base.Content, key, value)
+		}
+	}
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.AliasNode {
+		return node.Alias
+	}
+	return node
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const environmentsConfig = `
+project:
+  name: shop
+  domain: example.com
+  email: ops@example.com
+server:
+  host: prod.example.com
+  port: 22
+  user: deploy
+  ssh_key: id_ed25519
+services:
+  - name: web
+    image: web:latest
+    port: 3000
+    env:
+      - LOG_LEVEL=info
+    routes:
+      - path: /
+    labels:
+      team: shop
+environments:
+  staging:
+    project:
+      domain: staging.example.com
+    server:
+      host: staging.example.com
+    services:
+      - name: web
+        image: web:latest
+        port: 3000
+        env:
+          - LOG_LEVEL=debug
+        routes:
+          - path: /
+  production: {}
+`
+
+func TestParseConfigEnvironment(t *testing.T) {
+	cfg, err := ParseConfigWithOptions([]byte(environmentsConfig), ParseOptions{Environment: "staging"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "staging", cfg.Environment)
+	assert.Equal(t, "shop-staging", cfg.Project.Name)
+	// Mappings are merged.
+	assert.Equal(t, "staging.example.com", cfg.Project.Domain)
+	assert.Equal(t, "ops@example.com", cfg.Project.Email)
+	assert.Equal(t, "staging.example.com", cfg.Server.Host)
+	assert.Equal(t, "deploy", cfg.Server.User)
+	// Lists are replaced.
+	require.Len(t, cfg.Services, 1)
+	assert.Equal(t, Env{"LOG_LEVEL=debug"}, cfg.Services[0].Env)
+	assert.Empty(t, cfg.Services[0].Labels)
+
+	cfg, err = ParseConfig([]byte(environmentsConfig))
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Environment)
+	assert.Equal(t, "shop", cfg.Project.Name)
+	assert.Equal(t, "prod.example.com", cfg.Server.Host)
+	assert.Equal(t, Env{"LOG_LEVEL=info"}, cfg.Services[0].Env)
+
+	cfg, err = ParseConfigWithOptions([]byte(environmentsConfig), ParseOptions{Environment: "production"})
+	require.NoError(t, err)
+	assert.Equal(t, "shop-production", cfg.Project.Name)
+	assert.Equal(t, "prod.example.com", cfg.Server.Host)
+}
+
+func TestParseConfigEnvironmentErrors(t *testing.T) {
+	_, err := ParseConfigWithOptions([]byte(environmentsConfig), ParseOptions{Environment: "qa"})
+	assert.EqualError(t, err, `unknown environment "qa", the configuration defines staging, production`)
+
+	withoutEnvironments, _, _ := strings.Cut(environmentsConfig, "environments:")
+	_, err = ParseConfigWithOptions([]byte(withoutEnvironments), ParseOptions{Environment: "staging"})
+	assert.ErrorContains(t, err, `unknown environment "staging": the configuration has no environments section`)
+
+	// Overrides are checked for unknown fields like the rest of the file.
+	misspelled := environmentsConfig + "  qa:\n    server:\n      hots: qa.example.com\n"
+	_, err = ParseConfigWithOptions([]byte(misspelled), ParseOptions{Environment: "qa"})
+	assert.ErrorContains(t, err, "field hots not found in type config.Server")
+}
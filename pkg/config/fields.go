@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	nodeType        = reflect.TypeOf(yaml.Node{})
+)
+
+// mappingTypes maps the types with a custom unmarshaler that accept a mapping to the struct
+// the mapping is decoded into.
+var mappingTypes = map[reflect.Type]reflect.Type{
+	reflect.TypeOf(TLS{}):        reflect.TypeOf(tlsFiles{}),
+	reflect.TypeOf(Project{}):    reflect.TypeOf(projectFields{}),
+	reflect.TypeOf(HookItem{}):   reflect.TypeOf(hookAlias{}),
+	reflect.TypeOf(Dependency{}): reflect.TypeOf(dependencyAlias{}),
+}
+
+// yamlField is a mapping key a struct accepts in YAML.
+type yamlField struct {
+	name  string
+	field reflect.StructField
+}
+
+// yamlFields returns the mapping keys of struct type t in field order, including the fields
+// of inlined structs, named the way yaml.v3 names them. The index of an inlined field is its
+// index path from t.
+func yamlFields(t reflect.Type) []yamlField {
+	var fields []yamlField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") && field.Type.Kind() == reflect.Struct {
+			for _, inlined := range yamlFields(field.Type) {
+				inlined.field.Index = append([]int{i}, inlined.field.Index...)
+				fields = append(fields, inlined)
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields = append(fields, yamlField{name: name, field: field})
+	}
+	return fields
+}
+
+// unknownFields returns a problem for every mapping key in node that isn't a field of the
+// value of type t it is decoded into, like yaml.Decoder.KnownFields does. Unlike the decoder,
+// it also checks the mappings decoded by custom unmarshalers.
+func unknownFields(node *yaml.Node, t reflect.Type) []string {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return unknownFields(node.Content[0], t)
+	case yaml.AliasNode:
+		return unknownFields(node.Alias, t)
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	valueType := t
+	if mapping, ok := mappingTypes[t]; ok {
+		t = mapping
+	} else if t == nodeType || reflect.PointerTo(t).Implements(unmarshalerType) {
+		return nil
+	}
+
+	var problems []string
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := make(map[string]reflect.Type)
+		for _, field := range yamlFields(t) {
+			fields[field.name] = field.field.Type
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Tag == "!!merge" {
+				problems = append(problems, mergedUnknownFields(value, valueType)...)
+				continue
+			}
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("line %d: field %s not found in type %s", key.Line, key.Value, valueType))
+				continue
+			}
+			problems = append(problems, unknownFields(value, fieldType)...)
+		}
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && node.Kind == yaml.SequenceNode:
+		for _, item := range node.Content {
+			problems = append(problems, unknownFields(item, t.Elem())...)
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			problems = append(problems, unknownFields(node.Content[i], t.Elem())...)
+		}
+	}
+	return problems
+}
+
+// mergedUnknownFields checks the mappings merged into a mapping with a "<<" key.
+func mergedUnknownFields(node *yaml.Node, t reflect.Type) []string {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind != yaml.SequenceNode {
+		return unknownFields(node, t)
+	}
+
+	var problems []string
+	for _, item := range node.Content {
+		problems = append(problems, mergedUnknownFields(item, t)...)
+	}
+	return problems
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileName is the name of the configuration file.
+const FileName = "ftl.yaml"
+
+// FindFile looks for the file name in dir and its parent directories, like git looks for
+// the repository, and returns the path of the first one found.
+func FindFile(dir, name string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for current := dir; ; {
+		path := filepath.Join(current, name)
+		info, err := os.Stat(path)
+		if err == nil && !info.IsDir() {
+			return path, nil
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to look for %s: %w", name, err)
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return "", fmt.Errorf("no %s found in %s or any parent directory", name, dir)
+		}
+		current = parent
+	}
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindFile(t *testing.T) {
+	dir := t.TempDir()
+	writeIncluded(t, dir, map[string]string{
+		FileName:                 "project:\n  name: shop\n",
+		"api/cmd/server/main.go": "package main\n",
+		"api/ftl.yaml/.keep":     "",
+	})
+
+	for _, start := range []string{".", "api", "api/cmd/server"} {
+		path, err := FindFile(filepath.Join(dir, start), FileName)
+		require.NoError(t, err, start)
+		assert.Equal(t, filepath.Join(dir, FileName), path, start)
+	}
+
+	_, err := FindFile(dir, "missing.yaml")
+	assert.EqualError(t, err, "no missing.yaml found in "+dir+" or any parent directory")
+}
+
+func TestParseConfigDotEnvRelativeToDir(t *testing.T) {
+	dir := t.TempDir()
+	writeIncluded(t, dir, map[string]string{
+		".env":     "FTL_TEST_WEB_IMAGE=web:1.4\n",
+		"api/.env": "FTL_TEST_API_SECRET=s3cret\n",
+	})
+	t.Cleanup(func() {
+		os.Unsetenv("FTL_TEST_WEB_IMAGE")
+		os.Unsetenv("FTL_TEST_API_SECRET")
+	})
+
+	// The working directory is the package directory, not the directory of the configuration.
+	data := strings.Replace(includingConfig, "image: web:latest", "image: ${FTL_TEST_WEB_IMAGE}", 1)
+	data = strings.Replace(data, "include:\n  - services/*.yaml\n  - data.yaml\n", "", 1)
+	data += "  - name: api\n    image: api:latest\n    path: ./api\n    port: 4000\n    routes:\n      - path: /api\n"
+
+	cfg, err := ParseConfigWithOptions([]byte(data), ParseOptions{Dir: dir})
+	require.NoError(t, err)
+	assert.Equal(t, "web:1.4", cfg.Services[0].Image)
+	assert.Equal(t, "./api", cfg.Services[1].Path)
+	assert.Equal(t, "s3cret", os.Getenv("FTL_TEST_API_SECRET"))
+}
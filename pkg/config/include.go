@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// includedFile holds the entries a file listed in `include` contributes to the configuration.
+type includedFile struct {
+	Services     []Service    `yaml:"services"`
+	Dependencies []Dependency `yaml:"dependencies"`
+	Volumes      []string     `yaml:"volumes"`
+}
+
+// includeFiles adds the services, dependencies and volumes of the files matching the include
+// patterns of cfg, relative to dir. It returns the problems found in the files: unknown fields,
+// unless lenient, and services or dependencies already defined in another file.
+func includeFiles(cfg *Config, dir string, lenient bool) ([]string, error) {
+	files, err := includedPaths(cfg.Include, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// sources records the file defining each service and dependency name.
+	sources := make(map[string]string)
+	for _, svc := range cfg.Services {
+		sources[svc.Name] = "the main configuration file"
+	}
+	for _, dep := range cfg.Dependencies {
+		sources[dep.Name] = "the main configuration file"
+	}
+
+	var problems []string
+	for _, file := range files {
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			name = file
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read included file: %w", err)
+		}
+
+		var document yaml.Node
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("error parsing YAML in %s: %v", name, err)
+		}
+		if err := interpolateNode(&document); err != nil {
+			return nil, fmt.Errorf("error expanding environment variables in %s: %v", name, err)
+		}
+		var included includedFile
+		if err := document.Decode(&included); err != nil {
+			return nil, fmt.Errorf("error parsing YAML in %s: %v", name, err)
+		}
+		if !lenient {
+			for _, problem := range unknownFields(&document, reflect.TypeOf(included)) {
+				problems = append(problems, name+": "+problem)
+			}
+		}
+
+		for _, svc := range included.Services {
+			if source, ok := sources[svc.Name]; ok {
+				problems = append(problems, fmt.Sprintf("service %q in %s is already defined in %s", svc.Name, name, source))
+				continue
+			}
+			sources[svc.Name] = name
+			cfg.Services = append(cfg.Services, svc)
+		}
+		for _, dep := range included.Dependencies {
+			if source, ok := sources[dep.Name]; ok {
+				problems = append(problems, fmt.Sprintf("dependency %q in %s is already defined in %s", dep.Name, name, source))
+				continue
+			}
+			sources[dep.Name] = name
+			cfg.Dependencies = append(cfg.Dependencies, dep)
+		}
+		cfg.Volumes = append(cfg.Volumes, included.Volumes...)
+	}
+
+	return problems, nil
+}
+
+// includedPaths returns the files matching the patterns, in the order of the patterns and
+// without duplicates. Relative patterns are relative to dir.
+func includedPaths(patterns []string, dir string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		path := pattern
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("include pattern %q matches no files", pattern)
+		}
+
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const includingConfig = `
+include:
+  - services/*.yaml
+  - data.yaml
+project:
+  name: shop
+  domain: example.com
+  email: ops@example.com
+server:
+  host: example.com
+  port: 22
+  user: deploy
+  ssh_key: id_ed25519
+services:
+  - name: web
+    image: web:latest
+    port: 3000
+    routes:
+      - path: /
+`
+
+func writeIncluded(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestParseConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("API_IMAGE", "api:1.2")
+	t.Setenv("POSTGRES_PASSWORD", "secret")
+	writeIncluded(t, dir, map[string]string{
+		"services/api.yaml": "services:\n  - name: api\n    image: ${API_IMAGE}\n    port: 4000\n    routes:\n      - path: /api\n",
+		"services/admin.yaml": "services:\n  - name: admin\n    image: admin:latest\n    port: 5000\n" +
+			"    volumes:\n      - uploads:/uploads\n    routes:\n      - path: /admin\n",
+		"data.yaml": "dependencies:\n  - postgres:16\nvolumes:\n  - backups\n",
+	})
+
+	cfg, err := ParseConfigWithOptions([]byte(includingConfig), ParseOptions{Dir: dir})
+	require.NoError(t, err)
+
+	var names []string
+	for _, svc := range cfg.Services {
+		names = append(names, svc.Name)
+	}
+	// Files are added in the order of the patterns, and of their names for a glob.
+	assert.Equal(t, []string{"web", "admin", "api"}, names)
+	assert.Equal(t, "api:1.2", cfg.Services[2].Image)
+	assert.Equal(t, "./", cfg.Services[2].Path)
+	require.Len(t, cfg.Dependencies, 1)
+	assert.Equal(t, "postgres:16", cfg.Dependencies[0].Image)
+	assert.Equal(t, []string{"backups", "postgres_data", "uploads"}, cfg.Volumes)
+}
+
+func TestParseConfigIncludeProblems(t *testing.T) {
+	dir := t.TempDir()
+	writeIncluded(t, dir, map[string]string{
+		"services/web.yaml": "services:\n  - name: web\n    image: web:2\n    port: 3000\n    routes:\n      - path: /v2\n",
+		"services/api.yaml": "services:\n  - name: api\n    image: api:latest\n    port: 4000\n    routes:\n      - path: /api\n" +
+			"dependencies:\n  - name: web\n    image: redis:7\n",
+		"data.yaml": "project:\n  name: other\n",
+	})
+
+	_, err := ParseConfigWithOptions([]byte(includingConfig), ParseOptions{Dir: dir})
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []string{
+		`dependency "web" in services/api.yaml is already defined in the main configuration file`,
+		`service "web" in services/web.yaml is already defined in the main configuration file`,
+		"data.yaml: line 1: field project not found in type config.includedFile",
+	}, validationErr.Problems)
+
+	_, err = ParseConfigWithOptions([]byte(includingConfig), ParseOptions{Dir: t.TempDir()})
+	assert.EqualError(t, err, `include pattern "services/*.yaml" matches no files`)
+}
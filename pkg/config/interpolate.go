@@ -0,0 +1,226 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// literalTag marks a YAML scalar whose value is used as written, without expanding variables.
+const literalTag = "!literal"
+
+// interpolateNode expands the environment variables in the scalars of a parsed YAML document,
+// so the expanded values can't change its structure and its comments are left alone. Scalars
+// tagged !literal are kept as written. A plain scalar whose value changes gets the tag of its
+// new value, so that e.g. `port: ${PORT}` decodes into an int.
+func interpolateNode(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == literalTag {
+			node.Tag = "!!str"
+			return nil
+		}
+		value, err := interpolate(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if value != node.Value {
+			node.Value = value
+			if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+				node.Tag = ""
+				node.Tag = node.ShortTag()
+			}
+		}
+	case yaml.DocumentNode, yaml.SequenceNode, yaml.MappingNode:
+		// Aliases are skipped: their anchors are expanded where they are defined.
+		for _, child := range node.Content {
+			if err := interpolateNode(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// interpolate expands the environment variables in input the way docker compose does:
+//
+//	$VAR, ${VAR}     the value of VAR, empty when it is unset
+//	${VAR:-default}  default when VAR is unset or empty; ${VAR-default} only when unset
+//	${VAR:?message}  an error when VAR is unset or empty; ${VAR?message} only when unset
+//	${VAR:+alt}      alt when VAR is set and not empty; ${VAR+alt} whenever it is set
+//	$$               a literal $, like in "proxy_set_header Host $$host"
+//
+// Defaults, messages and alternatives may contain variables themselves. A $ that starts
+// none of these, like the one in "$5", is kept as it is. Syntax errors are reported even in
+// the defaults and alternatives that aren't used.
+func interpolate(input string) (string, error) {
+	if err := checkInterpolation(input); err != nil {
+		return "", err
+	}
+	return expand(input)
+}
+
+// expand expands the variables of input, whose syntax was checked by checkInterpolation.
+func expand(input string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(input); {
+		if input[i] != '$' || i+1 == len(input) {
+			out.WriteByte(input[i])
+			i++
+			continue
+		}
+
+		switch next := input[i+1]; {
+		case next == '$':
+			out.WriteByte('$')
+			i += 2
+		case next == '{':
+			end := closingBrace(input, i+2)
+			if end < 0 {
+				return "", fmt.Errorf("invalid variable %q: missing }", input[i:])
+			}
+			value, err := expandBraced(input[i+2 : end])
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(value)
+			i = end + 1
+		case isNameStart(next):
+			end := i + 1
+			for end < len(input) && isNameChar(input[end]) {
+				end++
+			}
+			out.WriteString(os.Getenv(input[i+1 : end]))
+			i = end
+		default:
+			out.WriteByte('$')
+			i++
+		}
+	}
+	return out.String(), nil
+}
+
+// closingBrace returns the index of the } closing the variable whose name starts at start,
+// skipping the variables nested in it, or -1.
+func closingBrace(input string, start int) int {
+	depth := 0
+	for i := start; i < len(input); i++ {
+		switch {
+		case input[i] == '$' && i+1 < len(input) && input[i+1] == '$':
+			i++
+		case input[i] == '$' && i+1 < len(input) && input[i+1] == '{':
+			depth++
+			i++
+		case input[i] == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// expandBraced expands the expression between the braces of ${...}.
+func expandBraced(expr string) (string, error) {
+	name, colon, operator, argument, err := parseBraced(expr)
+	if err != nil {
+		return "", err
+	}
+
+	value, set := os.LookupEnv(name)
+	if operator == 0 {
+		return value, nil
+	}
+	// With a colon, an empty value counts as unset.
+	present := set && (!colon || value != "")
+
+	switch operator {
+	case '-':
+		if present {
+			return value, nil
+		}
+		return expand(argument)
+	case '?':
+		if present {
+			return value, nil
+		}
+		message, err := expand(argument)
+		if err != nil {
+			return "", err
+		}
+		if message == "" {
+			return "", fmt.Errorf("required environment variable %s not set", name)
+		}
+		return "", fmt.Errorf("required environment variable %s not set: %s", name, message)
+	default:
+		if present {
+			return expand(argument)
+		}
+		return "", nil
+	}
+}
+
+// parseBraced splits the expression between the braces of ${...} into the variable name,
+// whether a colon follows it, the operator (-, ? or +, or 0 for none) and its argument.
+func parseBraced(expr string) (name string, colon bool, operator byte, argument string, err error) {
+	name = expr
+	for i := 0; i < len(expr); i++ {
+		if !isNameChar(expr[i]) || (i == 0 && !isNameStart(expr[i])) {
+			name = expr[:i]
+			break
+		}
+	}
+	if name == "" {
+		return "", false, 0, "", fmt.Errorf("invalid variable \"${%s}\": expected a name", expr)
+	}
+
+	operation := expr[len(name):]
+	if operation == "" {
+		return name, false, 0, "", nil
+	}
+	colon = strings.HasPrefix(operation, ":")
+	operation = strings.TrimPrefix(operation, ":")
+	if operation == "" || !strings.ContainsRune("-?+", rune(operation[0])) {
+		return "", false, 0, "", fmt.Errorf("invalid variable \"${%s}\": expected -, ? or + after the name", expr)
+	}
+	return name, colon, operation[0], operation[1:], nil
+}
+
+// checkInterpolation reports the first syntax error of the variables in input, including the
+// ones in defaults and alternatives that the current environment wouldn't expand.
+func checkInterpolation(input string) error {
+	for i := 0; i < len(input)-1; i++ {
+		if input[i] != '$' {
+			continue
+		}
+		switch input[i+1] {
+		case '$':
+			i++
+		case '{':
+			end := closingBrace(input, i+2)
+			if end < 0 {
+				return fmt.Errorf("invalid variable %q: missing }", input[i:])
+			}
+			_, _, _, argument, err := parseBraced(input[i+2 : end])
+			if err != nil {
+				return err
+			}
+			if err := checkInterpolation(argument); err != nil {
+				return err
+			}
+			i = end
+		}
+	}
+	return nil
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("FTL_SET", "value")
+	t.Setenv("FTL_EMPTY", "")
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"$FTL_SET and ${FTL_SET}", "value and value"},
+		{"${FTL_UNSET}|$FTL_UNSET|", "||"},
+		{"$FTL_SET-suffix ${FTL_SET}_suffix", "value-suffix value_suffix"},
+
+		{"${FTL_SET:-default} ${FTL_SET-default}", "value value"},
+		{"${FTL_EMPTY:-default} ${FTL_EMPTY-default}", "default "},
+		{"${FTL_UNSET:-default} ${FTL_UNSET-default}", "default default"},
+		{"${FTL_UNSET:-}", ""},
+		{"${FTL_UNSET:-with spaces and: colons}", "with spaces and: colons"},
+
+		{"${FTL_SET:+alt} ${FTL_SET+alt}", "alt alt"},
+		{"${FTL_EMPTY:+alt} ${FTL_EMPTY+alt}", " alt"},
+		{"${FTL_UNSET:+alt} ${FTL_UNSET+alt}", " "},
+
+		{"${FTL_EMPTY?message}", ""},
+		{"${FTL_SET:?message}", "value"},
+
+		// Defaults and alternatives can hold variables.
+		{"${FTL_UNSET:-${FTL_SET}}", "value"},
+		{"${FTL_UNSET:-${FTL_ALSO_UNSET:-nested}}", "nested"},
+		{"${FTL_SET:+${FTL_SET}-alt}", "value-alt"},
+		{"${FTL_UNSET:-a}}", "a}"},
+
+		// Escaped and lone dollars are kept.
+		{"$$FTL_SET $${FTL_SET}", "$FTL_SET ${FTL_SET}"},
+		{"${FTL_UNSET:-$$literal}", "$literal"},
+		{"price: $5, $ alone, trailing $", "price: $5, $ alone, trailing $"},
+
+		// nginx variables are written with $$.
+		{"proxy_set_header Host $$host; proxy_set_header X-Real-IP $$remote_addr;", "proxy_set_header Host $host; proxy_set_header X-Real-IP $remote_addr;"},
+		{"return 301 https://$$host$$request_uri;", "return 301 https://$host$request_uri;"},
+	}
+
+	for _, tt := range tests {
+		got, err := interpolate(tt.input)
+		require.NoError(t, err, tt.input)
+		assert.Equal(t, tt.want, got, tt.input)
+	}
+}
+
+func TestInterpolate_Errors(t *testing.T) {
+	t.Setenv("FTL_EMPTY", "")
+
+	tests := map[string]string{
+		"${FTL_UNSET:?set FTL_UNSET}":   "required environment variable FTL_UNSET not set: set FTL_UNSET",
+		"${FTL_EMPTY:?}":                "required environment variable FTL_EMPTY not set",
+		"${FTL_UNSET?}":                 "required environment variable FTL_UNSET not set",
+		"${FTL_UNSET:-${FTL_MISSING?}}": "required environment variable FTL_MISSING not set",
+		"${FTL_UNSET":                   `invalid variable "${FTL_UNSET": missing }`,
+		"${}":                           `invalid variable "${}": expected a name`,
+		"${1ABC}":                       `invalid variable "${1ABC}": expected a name`,
+		"${FTL_UNSET:}":                 `invalid variable "${FTL_UNSET:}": expected -, ? or + after the name`,
+		"${FTL_UNSET:default}":          `invalid variable "${FTL_UNSET:default}": expected -, ? or + after the name`,
+	}
+
+	for input, message := range tests {
+		_, err := interpolate(input)
+		assert.EqualError(t, err, message, input)
+	}
+}
+
+func TestInterpolateNode(t *testing.T) {
+	t.Setenv("FTL_PORT", "8080")
+	t.Setenv("FTL_IMAGE", "web: latest")
+
+	var document yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte(`
+# ${FTL_UNSET is not closed in this comment
+port: ${FTL_PORT}
+quoted: "${FTL_PORT}"
+image: ${FTL_IMAGE}
+hook: !literal sh -c "echo $HOSTNAME $$"
+shared: &shared $$host
+copy: *shared
+`), &document))
+	require.NoError(t, interpolateNode(&document))
+
+	var values struct {
+		Port   any    `yaml:"port"`
+		Quoted any    `yaml:"quoted"`
+		Image  string `yaml:"image"`
+		Hook   string `yaml:"hook"`
+		Shared string `yaml:"shared"`
+		Copy   string `yaml:"copy"`
+	}
+	require.NoError(t, document.Decode(&values))
+	assert.Equal(t, 8080, values.Port)
+	assert.Equal(t, "8080", values.Quoted)
+	// Expanded values can't change the structure of the document.
+	assert.Equal(t, "web: latest", values.Image)
+	assert.Equal(t, `sh -c "echo $HOSTNAME $$"`, values.Hook)
+	// Anchors are expanded once, so aliases don't unescape $$ twice.
+	assert.Equal(t, "$host", values.Shared)
+	assert.Equal(t, "$host", values.Copy)
+
+	require.NoError(t, yaml.Unmarshal([]byte("a: 1\nb: ${FTL_MISSING:?set it}\n"), &document))
+	assert.EqualError(t, interpolateNode(&document), "line 2: required environment variable FTL_MISSING not set: set it")
+}
+
+func TestCheckInterpolation(t *testing.T) {
+	for _, input := range []string{"plain", "$VAR ${VAR} $$ $5", "${A:-${B:+${C?}}}", "${A-$${literal}}"} {
+		assert.NoError(t, checkInterpolation(input), input)
+	}
+
+	tests := map[string]string{
+		"${MONGO_ROOT_USER:mongouser}": `invalid variable "${MONGO_ROOT_USER:mongouser}": expected -, ? or + after the name`,
+		"${SET:-${UNUSED:default}}":    `invalid variable "${UNUSED:default}": expected -, ? or + after the name`,
+		"${SET:+${}}":                  `invalid variable "${}": expected a name`,
+		"${SET":                        `invalid variable "${SET": missing }`,
+	}
+	for input, message := range tests {
+		assert.EqualError(t, checkInterpolation(input), message, input)
+	}
+
+	// Syntax errors in defaults are reported even when the variable is set.
+	t.Setenv("SET", "value")
+	_, err := interpolate("${SET:-${UNUSED:default}}")
+	assert.EqualError(t, err, `invalid variable "${UNUSED:default}": expected -, ? or + after the name`)
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maskedValue replaces secrets in rendered configurations.
+const maskedValue = "***"
+
+// secretEnvKey matches the names of environment variables holding secrets.
+var secretEnvKey = regexp.MustCompile(`(?i)password|secret|token|key`)
+
+// Render returns the configuration the way it was parsed, with environment variables expanded,
+// short notation dependencies filled in and the volumes derived from the mounts, as a YAML
+// document using the keys of ftl.yaml. Empty fields are left out. The values of environment
+// variables named like secrets, the passwords of registries and the webhook URLs of
+// notifications, which usually embed a token, are masked.
+//
+// With name set, only the service or dependency of that name is rendered.
+func Render(cfg *Config, name string) (*yaml.Node, error) {
+	masked := maskSecrets(cfg)
+	if name == "" {
+		return renderValue(reflect.ValueOf(masked).Elem()), nil
+	}
+
+	for i := range masked.Services {
+		if masked.Services[i].Name == name {
+			return renderValue(reflect.ValueOf(masked.Services[i])), nil
+		}
+	}
+	for i := range masked.Dependencies {
+		if masked.Dependencies[i].Name == name {
+			return renderValue(reflect.ValueOf(masked.Dependencies[i])), nil
+		}
+	}
+	return nil, fmt.Errorf("no service or dependency named %q in the configuration", name)
+}
+
+// maskSecrets returns a copy of cfg with the secrets masked.
+func maskSecrets(cfg *Config) *Config {
+	masked := *cfg
+	masked.Services = slices.Clone(cfg.Services)
+	for i := range masked.Services {
+		masked.Services[i].Env = maskEnv(masked.Services[i].Env)
+	}
+	masked.Dependencies = slices.Clone(cfg.Dependencies)
+	for i := range masked.Dependencies {
+		masked.Dependencies[i].Env = maskEnv(masked.Dependencies[i].Env)
+	}
+	masked.Jobs = slices.Clone(cfg.Jobs)
+	for i := range masked.Jobs {
+		masked.Jobs[i].Env = maskEnv(masked.Jobs[i].Env)
+	}
+	masked.Notifications = slices.Clone(cfg.Notifications)
+	for i := range masked.Notifications {
+		masked.Notifications[i].URL = maskedValue
+	}
+	masked.Registries = slices.Clone(cfg.Registries)
+	for i := range masked.Registries {
+		if masked.Registries[i].Password != "" {
+			masked.Registries[i].Password = maskedValue
+		}
+	}
+	return &masked
+}
+
+func maskEnv(env Env) Env {
+	if env == nil {
+		return nil
+	}
+	masked := make(Env, len(env))
+	for i, entry := range env {
+		if key, _, found := strings.Cut(entry, "="); found && secretEnvKey.MatchString(key) {
+			entry = key + "=" + maskedValue
+		}
+		masked[i] = entry
+	}
+	return masked
+}
+
+var (
+	durationType = reflect.TypeOf(Duration(0))
+	sizeType     = reflect.TypeOf(Size(0))
+	tlsType      = reflect.TypeOf(TLS{})
+	projectType  = reflect.TypeOf(Project{})
+)
+
+// renderValue returns the YAML node of v, writing the types with a custom YAML form the way
+// they are written in ftl.yaml.
+func renderValue(v reflect.Value) *yaml.Node {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	switch v.Type() {
+	case durationType:
+		return scalarNode(v.Interface().(Duration).String())
+	case sizeType:
+		return scalarNode(v.Interface().(Size).String())
+	case tlsType:
+		tls := v.Interface().(TLS)
+		if tls.SelfSigned {
+			return scalarNode(TLSSelfSigned)
+		}
+		if tls.Disabled {
+			return scalarNode(TLSDisabled)
+		}
+		return renderValue(reflect.ValueOf(tlsFiles{CertFile: tls.CertFile, KeyFile: tls.KeyFile}))
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, field := range yamlFields(v.Type()) {
+			value := v.FieldByIndex(field.field.Index)
+			if value.IsZero() {
+				continue
+			}
+			node.Content = append(node.Content, scalarNode(field.name), renderValue(value))
+			if v.Type() == projectType && field.name == "name" {
+				node.Content = append(node.Content, scalarNode("domain"), renderDomains(v.Interface().(Project).Domains))
+			}
+		}
+		return node
+	case reflect.Slice, reflect.Array:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for i := 0; i < v.Len(); i++ {
+			node.Content = append(node.Content, renderValue(v.Index(i)))
+		}
+		return node
+	case reflect.Map:
+		keys := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, key := range keys {
+			node.Content = append(node.Content, scalarNode(key), renderValue(v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))))
+		}
+		return node
+	default:
+		var node yaml.Node
+		if err := node.Encode(v.Interface()); err != nil {
+			return scalarNode(fmt.Sprint(v.Interface()))
+		}
+		return &node
+	}
+}
+
+// renderDomains writes the project domains like they are given in ftl.yaml: a single domain
+// as a string, more as a list.
+func renderDomains(domains []string) *yaml.Node {
+	if len(domains) == 1 {
+		return scalarNode(domains[0])
+	}
+	return renderValue(reflect.ValueOf(domains))
+}
+
+func scalarNode(value string) *yaml.Node {
+	var node yaml.Node
+	_ = node.Encode(value)
+	return &node
+}
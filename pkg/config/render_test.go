@@ -0,0 +1,121 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const renderConfig = `
+project:
+  name: shop
+  domain: shop.example.com
+  email: ops@example.com
+  tls: self_signed
+server:
+  host: example.com
+  port: 22
+  user: deploy
+  ssh_key: ~/.ssh/id_rsa
+registries:
+  - server: ghcr.io
+    username: bot
+    password: hunter2
+services:
+  - name: web
+    image: web:1
+    port: 80
+    deploy_timeout: 5m
+    max_body_size: 64M
+    routes:
+      - path: /
+    env:
+      API_KEY: ${RENDER_API_KEY}
+      DATABASE_PASSWORD: pw
+      LOG_LEVEL: ${RENDER_LOG_LEVEL:-info}
+    read_only: true
+dependencies:
+  - redis:7
+notifications:
+  - url: https://hooks.example.com/T0/secret
+    format: slack
+`
+
+func TestRender(t *testing.T) {
+	t.Setenv("RENDER_API_KEY", "abc123")
+	cfg, err := ParseConfig([]byte(renderConfig))
+	require.NoError(t, err)
+
+	node, err := Render(cfg, "")
+	require.NoError(t, err)
+	out, err := yaml.Marshal(node)
+	require.NoError(t, err)
+
+	assert.Equal(t, `project:
+    name: shop
+    domain: shop.example.com
+    email: ops@example.com
+    tls: self_signed
+server:
+    host: example.com
+    port: 22
+    user: deploy
+    ssh_key: ~/.ssh/id_rsa
+services:
+    - name: web
+      image: web:1
+      port: 80
+      path: ./
+      routes:
+        - path: /
+      env:
+        - API_KEY=***
+        - DATABASE_PASSWORD=***
+        - LOG_LEVEL=info
+      deploy_timeout: 5m0s
+      read_only: true
+      max_body_size: 64M
+dependencies:
+    - name: redis
+      image: redis:7
+      volumes:
+        - redis_data:/data
+      ports:
+        - 6379
+volumes:
+    - redis_data
+registries:
+    - server: ghcr.io
+      username: bot
+      password: '***'
+notifications:
+    - url: '***'
+      format: slack
+`, string(out))
+
+	// The parsed configuration keeps its secrets.
+	assert.Equal(t, "hunter2", cfg.Registries[0].Password)
+	assert.Equal(t, "https://hooks.example.com/T0/secret", cfg.Notifications[0].URL)
+	assert.Contains(t, cfg.Services[0].Env, "API_KEY=abc123")
+}
+
+func TestRender_Name(t *testing.T) {
+	cfg, err := ParseConfig([]byte(renderConfig))
+	require.NoError(t, err)
+
+	node, err := Render(cfg, "redis")
+	require.NoError(t, err)
+	var dependency map[string]any
+	require.NoError(t, node.Decode(&dependency))
+	assert.Equal(t, map[string]any{
+		"name":    "redis",
+		"image":   "redis:7",
+		"volumes": []any{"redis_data:/data"},
+		"ports":   []any{6379},
+	}, dependency)
+
+	_, err = Render(cfg, "api")
+	assert.EqualError(t, err, `no service or dependency named "api" in the configuration`)
+}
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// schemaVersion is the JSON Schema dialect of Schema.
+const schemaVersion = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaProvider is implemented by types with a custom YAML form, which can't be derived
+// from their fields.
+type schemaProvider interface {
+	jsonSchema(g *schemaGenerator) map[string]any
+}
+
+var schemaProviderType = reflect.TypeOf((*schemaProvider)(nil)).Elem()
+
+// Schema returns a JSON Schema of ftl.yaml. It is generated from the yaml and validate tags of
+// the configuration structs, so it can't get out of sync with them. Checks done by custom
+// validators, like volume references, are left to ParseConfig.
+func Schema() map[string]any {
+	g := &schemaGenerator{defs: make(map[string]any)}
+	schema := g.object(reflect.TypeOf(Config{}))
+	properties := schema["properties"].(map[string]any)
+
+	// Environments override any part of the configuration. Overridden mappings are merged, so
+	// they may leave out required fields; only the top-level keys are checked.
+	keys := slices.Sorted(maps.Keys(properties))
+	properties[environmentsKey] = map[string]any{
+		"type": "object",
+		"additionalProperties": map[string]any{
+			"type":          "object",
+			"propertyNames": map[string]any{"enum": keys},
+		},
+	}
+
+	schema["$schema"] = schemaVersion
+	schema["title"] = "ftl.yaml"
+	schema["$defs"] = g.defs
+	return schema
+}
+
+// schemaGenerator builds the schema of a type, collecting struct schemas as definitions.
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+// schema returns the schema of a value of type t.
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t.Kind() == reflect.Struct {
+		if _, ok := g.defs[t.Name()]; !ok {
+			// Reserve the name first, so recursive types refer to it.
+			g.defs[t.Name()] = nil
+			g.defs[t.Name()] = g.typeSchema(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	}
+	return g.typeSchema(t)
+}
+
+func (g *schemaGenerator) typeSchema(t reflect.Type) map[string]any {
+	if t.Implements(schemaProviderType) {
+		return reflect.Zero(t).Interface().(schemaProvider).jsonSchema(g)
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return g.object(t)
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	default:
+		return map[string]any{}
+	}
+}
+
+// object returns the schema of the mapping of struct type t. Unknown keys are rejected, like
+// ParseConfig does.
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	for _, field := range yamlFields(t) {
+		if field.field.Type == nodeType {
+			continue
+		}
+		property := g.schema(field.field.Type)
+		if applyValidateTag(property, field.field.Tag.Get("validate")) {
+			required = append(required, field.name)
+		}
+		properties[field.name] = property
+	}
+
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// applyValidateTag adds the constraints of a validate tag to the schema of a field and reports
+// whether the field is required. Rules after "dive" apply to the items of a list.
+func applyValidateTag(schema map[string]any, tag string) bool {
+	if tag == "" {
+		return false
+	}
+
+	rules, itemRules, dive := strings.Cut(tag, ",dive")
+	if strings.HasPrefix(tag, "dive") {
+		rules, itemRules, dive = "", strings.TrimPrefix(tag, "dive"), true
+	}
+	if items, ok := schema["items"].(map[string]any); dive && ok && !strings.Contains(itemRules, "keys") {
+		applyValidateTag(items, strings.TrimPrefix(itemRules, ","))
+	}
+
+	required := false
+	for _, rule := range strings.Split(rules, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = true
+		case "oneof":
+			schema["enum"] = strings.Fields(param)
+		case "email":
+			schema["format"] = "email"
+		case "http_url":
+			schema["format"] = "uri"
+		case "min", "max":
+			if n, err := strconv.Atoi(param); err == nil {
+				if keyword := limitKeyword(schema["type"], name); keyword != "" {
+					schema[keyword] = n
+				}
+			}
+		}
+	}
+	return required
+}
+
+// limitKeyword returns the keyword of a min or max rule for values of the schema type.
+func limitKeyword(schemaType any, rule string) string {
+	var minimum, maximum string
+	switch schemaType {
+	case "integer":
+		minimum, maximum = "minimum", "maximum"
+	case "string":
+		minimum, maximum = "minLength", "maxLength"
+	case "array":
+		minimum, maximum = "minItems", "maxItems"
+	default:
+		return ""
+	}
+	if rule == "min" {
+		return minimum
+	}
+	return maximum
+}
+
+// oneOf returns a schema matching exactly one of the schemas.
+func oneOf(schemas ...map[string]any) map[string]any {
+	return map[string]any{"oneOf": schemas}
+}
+
+func (Duration) jsonSchema(*schemaGenerator) map[string]any {
+	return oneOf(
+		map[string]any{"type": "string", "description": `A duration like "1m30s"`},
+		map[string]any{"type": "integer", "minimum": 0, "description": "A number of seconds"},
+	)
+}
+
+func (Size) jsonSchema(*schemaGenerator) map[string]any {
+	return oneOf(
+		map[string]any{"type": "string", "description": `A size like "512M" or "2G"`},
+		map[string]any{"type": "integer", "minimum": 0, "description": "A number of bytes"},
+	)
+}
+
+func (Project) jsonSchema(g *schemaGenerator) map[string]any {
+	schema := g.object(reflect.TypeOf(projectAlias{}))
+	schema["properties"].(map[string]any)["domain"] = oneOf(
+		map[string]any{"type": "string"},
+		map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "minItems": 1},
+	)
+	schema["required"] = append(schema["required"].([]string), "domain")
+	return schema
+}
+
+func (TLS) jsonSchema(*schemaGenerator) map[string]any {
+	return oneOf(
+		map[string]any{"const": TLSSelfSigned},
+		map[string]any{"const": TLSDisabled},
+		map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"cert_file": map[string]any{"type": "string"},
+				"key_file":  map[string]any{"type": "string"},
+			},
+			"required":             []string{"cert_file", "key_file"},
+			"additionalProperties": false,
+		},
+	)
+}
+
+func (Env) jsonSchema(*schemaGenerator) map[string]any {
+	return oneOf(
+		map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "KEY=VALUE entries"},
+		map[string]any{
+			"type":                 "object",
+			"additionalProperties": map[string]any{"type": []string{"string", "number", "boolean", "null"}},
+			"description":          "Values by variable name",
+		},
+	)
+}
+
+func (HookItem) jsonSchema(g *schemaGenerator) map[string]any {
+	return oneOf(
+		map[string]any{"type": "string", "description": "A command run on the server"},
+		g.object(reflect.TypeOf(hookAlias{})),
+	)
+}
+
+func (Dependency) jsonSchema(g *schemaGenerator) map[string]any {
+	return oneOf(
+		map[string]any{"type": "string", "description": `An image with default settings, like "postgres:16"`},
+		g.object(reflect.TypeOf(dependencyAlias{})),
+	)
+}
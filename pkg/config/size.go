@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Size is a number of bytes that can be written in YAML with a binary unit suffix
+// ("512M", "2G") or as a bare number of bytes.
+type Size int64
+
+var sizeUnits = map[string]int64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// UnmarshalYAML parses the size.
+func (s *Size) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := ParseSize(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+
+	*s = parsed
+	return nil
+}
+
+// ParseSize parses a size such as "2G", "512MB" or "1GiB". Units are powers of 1024.
+func ParseSize(s string) (Size, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	unit := strings.ToUpper(strings.TrimLeft(s, "0123456789"))
+	number := s[:len(s)-len(unit)]
+	unit = strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I")
+
+	multiplier, ok := sizeUnits[unit]
+	value, err := strconv.ParseInt(number, 10, 64)
+	if !ok || err != nil {
+		return 0, fmt.Errorf("invalid size %q: use a value like \"512M\" or \"2G\"", s)
+	}
+	if value > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid size %q: value is too large", s)
+	}
+
+	return Size(value * multiplier), nil
+}
+
+// Bytes returns the size in bytes.
+func (s Size) Bytes() int64 {
+	return int64(s)
+}
+
+// String formats the size with the largest unit that divides it exactly, e.g. "2G".
+func (s Size) String() string {
+	for _, unit := range []string{"T", "G", "M", "K"} {
+		multiplier := sizeUnits[unit]
+		if s != 0 && int64(s)%multiplier == 0 {
+			return fmt.Sprintf("%d%s", int64(s)/multiplier, unit)
+		}
+	}
+	return strconv.FormatInt(int64(s), 10)
+}
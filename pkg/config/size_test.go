@@ -0,0 +1,58 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{input: "2G", expected: 2 << 30},
+		{input: "512M", expected: 512 << 20},
+		{input: "512mb", expected: 512 << 20},
+		{input: "1GiB", expected: 1 << 30},
+		{input: "4096", expected: 4096},
+		{input: "", expected: 0},
+		{input: "-1G", wantErr: true},
+		{input: "1.5G", wantErr: true},
+		{input: "2X", wantErr: true},
+		{input: "G", wantErr: true},
+		{input: "99999999999T", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			s, err := ParseSize(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, s.Bytes())
+		})
+	}
+}
+
+func TestSize_String(t *testing.T) {
+	assert.Equal(t, "2G", Size(2<<30).String())
+	assert.Equal(t, "1536M", Size(1536<<20).String())
+	assert.Equal(t, "1000", Size(1000).String())
+	assert.Equal(t, "0", Size(0).String())
+}
+
+func TestSize_UnmarshalYAML(t *testing.T) {
+	var server Server
+	err := yaml.Unmarshal([]byte("swap: 2G\n"), &server)
+	assert.NoError(t, err)
+	assert.Equal(t, "2G", server.Swap.String())
+
+	err = yaml.Unmarshal([]byte("swap: lots\n"), &server)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid size "lots"`)
+}
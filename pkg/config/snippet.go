@@ -0,0 +1,72 @@
+package config
+
+import "fmt"
+
+// validateNginxSnippets reports the nginx snippets of the configuration that would break out
+// of the block they are inserted into.
+func validateNginxSnippets(cfg *Config) []string {
+	var problems []string
+	check := func(field, snippet string) {
+		if err := CheckNginxSnippet(snippet); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", field, err))
+		}
+	}
+
+	check("proxy.extra_http", cfg.Proxy.ExtraHTTP)
+	check("proxy.extra_server", cfg.Proxy.ExtraServer)
+	for i, svc := range cfg.Services {
+		for j, route := range svc.Routes {
+			check(fmt.Sprintf("services[%d].routes[%d].extra_location of %q", i, j, svc.Name), route.ExtraLocation)
+		}
+	}
+	return problems
+}
+
+// CheckNginxSnippet checks that the braces of an nginx snippet are balanced, so that it stays
+// within the block it is inserted into. Braces in quoted strings and comments are ignored.
+// It doesn't check the directives; nginx -t does before the proxy loads them.
+func CheckNginxSnippet(snippet string) error {
+	var open []int
+	var quote rune
+	quoteLine := 0
+	line := 1
+	comment := false
+	escaped := false
+
+	for _, r := range snippet {
+		if r == '\n' {
+			line++
+			comment = false
+		}
+		switch {
+		case comment:
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote, quoteLine = r, line
+		case r == '#':
+			comment = true
+		case r == '{':
+			open = append(open, line)
+		case r == '}':
+			if len(open) == 0 {
+				return fmt.Errorf("unbalanced braces: } on line %d closes a block the snippet didn't open", line)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+
+	if quote != 0 {
+		return fmt.Errorf("unterminated string: the quote %c on line %d is never closed", quote, quoteLine)
+	}
+	if len(open) > 0 {
+		return fmt.Errorf("unbalanced braces: { on line %d is never closed", open[len(open)-1])
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckNginxSnippet(t *testing.T) {
+	for _, snippet := range []string{
+		"",
+		"limit_req zone=api burst=20;",
+		"if ($http_user_agent ~* \"bot\") {\n    return 403;\n}",
+		"add_header X-Note \"a { in a string\";",
+		"add_header X-Note 'closing } too';",
+		"# a comment with a {\nsendfile on;",
+		`return 200 \{;`,
+	} {
+		assert.NoError(t, CheckNginxSnippet(snippet), snippet)
+	}
+
+	for snippet, message := range map[string]string{
+		"}\nserver {":                    "unbalanced braces: } on line 1 closes a block the snippet didn't open",
+		"location /a {\n    return 404;": "unbalanced braces: { on line 1 is never closed",
+		"add_header X-Note \"open;":      "unterminated string: the quote \" on line 1 is never closed",
+		"sendfile on;\nreturn 200 'x;":   "unterminated string: the quote ' on line 2 is never closed",
+	} {
+		assert.EqualError(t, CheckNginxSnippet(snippet), message, snippet)
+	}
+}
@@ -0,0 +1,253 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ValidationError reports every problem found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return "validation error: " + e.Problems[0]
+	}
+	return fmt.Sprintf("validation error: %d problems:\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// crossFieldProblems returns the problems that involve more than one entry of the
+// configuration: duplicate names, routes and host ports, data volumes of dependencies
+// mounted elsewhere, extra networks of services, the project network gateway and the platforms
+// of images that aren't pushed.
+func crossFieldProblems(cfg *Config) []string {
+	var problems []string
+	problems = append(problems, duplicateNames(cfg)...)
+	problems = append(problems, duplicateRoutes(cfg)...)
+	problems = append(problems, duplicateHostPorts(cfg)...)
+	problems = append(problems, sharedDependencyVolumes(cfg)...)
+	problems = append(problems, serviceNetworks(cfg)...)
+	problems = append(problems, projectNetwork(cfg)...)
+	problems = append(problems, hstsPreload(cfg)...)
+	problems = append(problems, localMultiPlatform(cfg)...)
+	return problems
+}
+
+// localMultiPlatform reports services built locally for several platforms, since the local
+// image store of Docker, which image sync reads, holds a single platform per image.
+func localMultiPlatform(cfg *Config) []string {
+	var problems []string
+	for _, svc := range cfg.Services {
+		if svc.Image == "" && len(cfg.ServicePlatforms(&svc)) > 1 {
+			problems = append(problems, fmt.Sprintf("service %q lists several platforms but has no image to push them to; set image or a single platform", svc.Name))
+		}
+	}
+	return problems
+}
+
+// hstsPreload reports an HSTS preload request the browser preload lists would refuse, as they
+// require subdomains to be included and a max-age of at least a year.
+func hstsPreload(cfg *Config) []string {
+	hsts := cfg.Proxy.HSTS
+	if hsts == nil || !hsts.Preload || cfg.Proxy.HSTSHeader() == "" {
+		return nil
+	}
+
+	var problems []string
+	if !hsts.IncludeSubdomains {
+		problems = append(problems, "proxy.hsts.preload requires include_subdomains: true")
+	}
+	if hsts.MaxAge != 0 && hsts.MaxAge < DefaultHSTSMaxAge {
+		problems = append(problems, fmt.Sprintf("proxy.hsts.preload requires a max_age of at least %d seconds", DefaultHSTSMaxAge))
+	}
+	return problems
+}
+
+// duplicateNames reports services, service aliases and dependencies sharing a name, which is
+// also their container name and network alias.
+func duplicateNames(cfg *Config) []string {
+	var problems []string
+	owners := make(map[string]string)
+	check := func(name, owner string) {
+		if name == "" {
+			return
+		}
+		if first, ok := owners[name]; ok {
+			problems = append(problems, fmt.Sprintf("%s and %s have the same name %q", first, owner, name))
+			return
+		}
+		owners[name] = owner
+	}
+
+	for i, svc := range cfg.Services {
+		check(svc.Name, fmt.Sprintf("services[%d]", i))
+	}
+	for i, svc := range cfg.Services {
+		for j, alias := range svc.Aliases {
+			check(alias, fmt.Sprintf("services[%d].aliases[%d]", i, j))
+		}
+	}
+	for i, dep := range cfg.Dependencies {
+		check(dep.Name, fmt.Sprintf("dependencies[%d]", i))
+	}
+	return problems
+}
+
+// duplicateRoutes reports routes with the same path on the same domain, which nginx rejects.
+func duplicateRoutes(cfg *Config) []string {
+	var problems []string
+	owners := make(map[string]string)
+	for i := range cfg.Services {
+		svc := &cfg.Services[i]
+		for j := range svc.Routes {
+			route := &svc.Routes[j]
+			owner := fmt.Sprintf("services[%d].routes[%d] of %q", i, j, svc.Name)
+			for _, domain := range cfg.RouteDomains(svc, route) {
+				key := domain + route.PathPrefix
+				if first, ok := owners[key]; ok {
+					problems = append(problems, fmt.Sprintf("%s and %s both route path %q on %s", first, owner, route.PathPrefix, domain))
+					continue
+				}
+				owners[key] = owner
+			}
+		}
+	}
+	return problems
+}
+
+// duplicateHostPorts reports host ports published more than once by service forwards and
+// dependency ports, or also used by the proxy.
+func duplicateHostPorts(cfg *Config) []string {
+	var problems []string
+	owners := make(map[string]string)
+	check := func(port int, protocol, owner string) {
+		key := fmt.Sprintf("%d/%s", port, protocol)
+		if first, ok := owners[key]; ok {
+			problems = append(problems, fmt.Sprintf("%s and %s both publish host port %s", first, owner, key))
+			return
+		}
+		owners[key] = owner
+	}
+
+	for _, port := range cfg.Project.ProxyPorts() {
+		check(port, "tcp", "the proxy")
+	}
+	for i, dep := range cfg.Dependencies {
+		if dep.ExposeMode() == ExposeNone {
+			continue
+		}
+		for j, port := range dep.Ports {
+			check(port, "tcp", fmt.Sprintf("dependencies[%d].ports[%d] of %q", i, j, dep.Name))
+		}
+	}
+	for i, svc := range cfg.Services {
+		for j, forward := range svc.Forwards {
+			owner := fmt.Sprintf("services[%d].forwards[%d] of %q", i, j, svc.Name)
+			parsed, err := ParseForward(forward)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", owner, err))
+				continue
+			}
+			check(parsed.HostPort, parsed.Protocol, owner)
+		}
+	}
+	return problems
+}
+
+// sharedDependencyVolumes reports named volumes of a dependency that are also mounted by
+// another service or dependency. Two containers writing the same database files corrupt
+// them, which is easy to miss with the volumes dependencies get by default.
+func sharedDependencyVolumes(cfg *Config) []string {
+	mounts := make(map[string][]string)
+	var names []string
+	add := func(volumes []string, owner string) {
+		for _, volume := range volumes {
+			name := extractNamedVolume(volume)
+			if name == "" {
+				continue
+			}
+			if _, ok := mounts[name]; !ok {
+				names = append(names, name)
+			}
+			mounts[name] = append(mounts[name], owner)
+		}
+	}
+
+	dependencyVolumes := make(map[string]string)
+	for _, dep := range cfg.Dependencies {
+		owner := fmt.Sprintf("dependency %q", dep.Name)
+		for _, volume := range dep.Volumes {
+			if name := extractNamedVolume(volume); name != "" {
+				if _, ok := dependencyVolumes[name]; !ok {
+					dependencyVolumes[name] = owner
+				}
+			}
+		}
+		add(dep.Volumes, owner)
+	}
+	for _, svc := range cfg.Services {
+		add(svc.Volumes, fmt.Sprintf("service %q", svc.Name))
+	}
+
+	var problems []string
+	for _, name := range names {
+		owner, ok := dependencyVolumes[name]
+		if !ok || len(mounts[name]) < 2 {
+			continue
+		}
+		var others []string
+		for _, other := range mounts[name] {
+			if other != owner {
+				others = append(others, other)
+			}
+		}
+		if len(others) == 0 {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("volume %q holds the data of %s and is also mounted by %s", name, owner, strings.Join(others, ", ")))
+	}
+	return problems
+}
+
+// serviceNetworks reports extra networks of services that ftl can't connect the container to:
+// the project network, which it is already on, and networks of run-once containers, which
+// exit before docker run returns.
+func serviceNetworks(cfg *Config) []string {
+	var problems []string
+	for i, svc := range cfg.Services {
+		for j, network := range svc.Networks {
+			owner := fmt.Sprintf("services[%d].networks[%d] of %q", i, j, svc.Name)
+			if network == cfg.Project.Name {
+				problems = append(problems, fmt.Sprintf("%s is the project network, which every container joins", owner))
+			}
+		}
+		if len(svc.Networks) > 0 && svc.Container != nil && svc.Container.RunOnce {
+			problems = append(problems, fmt.Sprintf("service %q runs once and can't join extra networks", svc.Name))
+		}
+	}
+	return problems
+}
+
+// projectNetwork reports a project network gateway given without a subnet or outside of it.
+func projectNetwork(cfg *Config) []string {
+	network := cfg.Project.Network
+	if network == nil || network.Gateway == "" {
+		return nil
+	}
+	if network.Subnet == "" {
+		return []string{"project.network.gateway requires project.network.subnet"}
+	}
+
+	_, subnet, err := net.ParseCIDR(network.Subnet)
+	gateway := net.ParseIP(network.Gateway)
+	if err != nil || gateway == nil {
+		// Reported by the field validation.
+		return nil
+	}
+	if !subnet.Contains(gateway) {
+		return []string{fmt.Sprintf("project.network.gateway %s is not in project.network.subnet %s", network.Gateway, network.Subnet)}
+	}
+	return nil
+}
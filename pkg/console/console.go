@@ -14,6 +14,7 @@ var (
 	colorRed    = "\033[91m" // Bright Red
 	colorGreen  = "\033[92m" // Bright Green
 	colorYellow = "\033[93m" // Bright Yellow
+	colorGray   = "\033[90m" // Bright Black
 )
 
 func init() {
@@ -22,6 +23,7 @@ func init() {
 		colorRed = ""
 		colorGreen = ""
 		colorYellow = ""
+		colorGray = ""
 	}
 }
 
@@ -49,6 +51,12 @@ func Error(a ...interface{}) {
 	fmt.Printf("%s✘%s %s\n", colorRed, colorReset, message)
 }
 
+// Muted prints secondary output, such as container logs, in gray.
+func Muted(a ...interface{}) {
+	message := fmt.Sprint(a...)
+	fmt.Printf("%s%s%s\n", colorGray, message, colorReset)
+}
+
 // Input prints an input prompt.
 func Input(a ...interface{}) {
 	message := fmt.Sprint(a...)
@@ -65,6 +73,11 @@ func ReadLine() (string, error) {
 	return strings.TrimSpace(line), nil
 }
 
+// IsInteractive reports whether standard input is a terminal that prompts can be read from.
+func IsInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
 // ReadPassword reads a password from standard input without echoing.
 func ReadPassword() (string, error) {
 	password, err := term.ReadPassword(int(os.Stdin.Fd()))
@@ -1,6 +1,9 @@
 package console
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"sync"
 
@@ -8,16 +11,53 @@ import (
 	"golang.org/x/term"
 )
 
+// output serializes the spinner frames and the lines printed above them with PrintAbove.
+var output struct {
+	sync.Mutex
+	// active counts the running spinner managers.
+	active int
+}
+
+// frameWriter writes spinner frames to stdout one whole frame at a time, so that lines printed
+// above the spinners never land in the middle of a frame.
+type frameWriter struct {
+	buf bytes.Buffer
+}
+
+// restoreCursor ends every frame, moving the cursor back to the first spinner line.
+var restoreCursor = []byte("\x1b8")
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	output.Lock()
+	defer output.Unlock()
+
+	w.buf.Write(p)
+	if bytes.HasSuffix(p, restoreCursor) {
+		w.flush()
+	}
+	return len(p), nil
+}
+
+// flush writes the buffered output. The output lock must be held.
+func (w *frameWriter) flush() {
+	_, _ = os.Stdout.Write(w.buf.Bytes())
+	w.buf.Reset()
+}
+
 // SpinnerManager handles multiple named spinners with concurrent access support.
 type SpinnerManager struct {
 	sm       ysmrr.SpinnerManager
+	writer   *frameWriter
 	spinners sync.Map
+	running  bool
 }
 
 // NewSpinnerManager creates a SpinnerManager with the provided writer.
 func NewSpinnerManager() *SpinnerManager {
+	writer := &frameWriter{}
 	return &SpinnerManager{
-		sm: ysmrr.NewSpinnerManager(),
+		sm:     ysmrr.NewSpinnerManager(ysmrr.WithWriter(writer)),
+		writer: writer,
 	}
 }
 
@@ -59,13 +99,41 @@ func (m *SpinnerManager) ErrorWithMessagef(name, format string, args ...interfac
 
 // Start begins the spinner animation for all spinners.
 func (m *SpinnerManager) Start() {
+	output.Lock()
+	if !m.running {
+		m.running = true
+		output.active++
+	}
+	output.Unlock()
+
 	m.sm.Start()
 }
 
 func (m *SpinnerManager) Stop() {
 	m.sm.Stop()
 
+	output.Lock()
+	m.writer.flush()
+	if m.running {
+		m.running = false
+		output.active--
+	}
+	output.Unlock()
+
 	if term.IsTerminal(int(os.Stdout.Fd())) {
 		print("\033[?25h")
 	}
 }
+
+// PrintAbove prints line to w. While spinners are running on the terminal, the line is printed
+// above them, and the spinners are redrawn below it with their next frame.
+func PrintAbove(w io.Writer, line string) {
+	output.Lock()
+	defer output.Unlock()
+
+	if output.active > 0 && term.IsTerminal(int(os.Stdout.Fd())) {
+		_, _ = fmt.Fprint(w, "\r\033[J"+line+"\n")
+		return
+	}
+	_, _ = fmt.Fprintln(w, line)
+}
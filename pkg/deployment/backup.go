@@ -0,0 +1,100 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+const (
+	// backupDirName is the folder of the project folder holding the dependency backups.
+	backupDirName = "backups"
+	// backupDone is printed once a backup was written.
+	backupDone = "backup-done"
+	// backupFilePattern matches the backup files of a dependency after its name and a dash.
+	backupFilePattern = "????????T??????Z.dump"
+)
+
+// Backup backs up the dependency in the background, reporting its progress as events like
+// Deploy. The backup is written to ~/projects/<project>/backups/<dependency>-<time>.dump on the
+// server, and the oldest backups beyond the retention of the dependency are removed.
+func (d *Deployment) Backup(ctx context.Context, project string, dependency *config.Dependency) <-chan Event {
+	return d.run(func() error { return d.backupDependency(ctx, project, dependency) })
+}
+
+// backupBeforeUpdate backs up the dependency when its running container is about to be
+// replaced. A failed backup fails the update, which leaves the running container alone.
+func (d *Deployment) backupBeforeUpdate(ctx context.Context, project string, dependency *config.Dependency, service *config.Service) error {
+	if dependency.BackupCommand() == "" {
+		return nil
+	}
+
+	status, err := d.getContainerStatus(ctx, project, dependency.Name)
+	if err != nil || status == ContainerStatusNotFound {
+		return err
+	}
+	if err := d.updateImage(ctx, project, service); err != nil {
+		return inPhase(PhasePull, err)
+	}
+	update, err := d.containerShouldBeUpdated(ctx, project, service)
+	if err != nil || !update {
+		return err
+	}
+
+	return d.backupRunningDependency(ctx, project, dependency, status)
+}
+
+// backupRunningDependency backs up the dependency before its container is replaced. A stopped
+// container can't run the backup command, so it is replaced without a backup.
+func (d *Deployment) backupRunningDependency(ctx context.Context, project string, dependency *config.Dependency, status ContainerStatusType) error {
+	if status != ContainerStatusRunning {
+		d.warn("backup/"+dependency.Name, dependency.Name, nil, "Dependency %s is not running, so it is updated without a backup", dependency.Name)
+		return nil
+	}
+	if err := d.backupDependency(ctx, project, dependency); err != nil {
+		return fmt.Errorf("%w; the dependency was not updated", err)
+	}
+	return nil
+}
+
+// backupDependency writes a backup of the dependency to the backup folder of the project by
+// running its backup command in the dependency container, and prunes the old backups.
+func (d *Deployment) backupDependency(ctx context.Context, project string, dependency *config.Dependency) error {
+	command := dependency.BackupCommand()
+	if command == "" {
+		return fmt.Errorf("dependency %s has no backup command", dependency.Name)
+	}
+
+	step := d.startStep("backup/"+dependency.Name, dependency.Name, "Backing up dependency %s", dependency.Name)
+
+	projectPath, err := d.projectFolder(project)
+	if err != nil {
+		step.fail(err)
+		return fmt.Errorf("failed to get project folder path: %w", err)
+	}
+	dir := filepath.Join(projectPath, backupDirName)
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.dump", dependency.Name, d.clock().UTC().Format(manifestIDFormat)))
+
+	// The backup is written to a temporary file, so a failed backup never looks complete. The
+	// umask and chmod keep the folder and the dumps, which hold all the data, private to the server
+	// user, also when the folder was created by an older version.
+	output, err := d.runCommand(ctx, "sh", "-c",
+		`umask 077 && mkdir -p "$1" && chmod 700 "$1" && docker exec "$2" sh -c "$3" > "$4.tmp" && mv -f "$4.tmp" "$4" && echo `+backupDone+` || rm -f "$4.tmp"`,
+		"sh", dir, containerName(project, dependency.Name, ""), command, path)
+	if err == nil && !strings.HasSuffix(output, backupDone) {
+		err = fmt.Errorf("backup command failed:\n%s", output)
+	}
+	if err != nil {
+		step.failf(err, "Failed to back up dependency %s", dependency.Name)
+		return fmt.Errorf("failed to back up dependency %s: %w", dependency.Name, err)
+	}
+	step.completef("Backed up dependency %s to %s", dependency.Name, path)
+
+	if err := d.pruneFiles(ctx, dir, dependency.Name+"-"+backupFilePattern, dependency.BackupRetention()); err != nil {
+		d.warn("backup/"+dependency.Name, dependency.Name, err, "Failed to remove old backups of dependency %s", dependency.Name)
+	}
+	return nil
+}
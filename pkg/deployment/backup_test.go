@@ -0,0 +1,130 @@
+package deployment
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+const testBackupDir = "/home/deploy/projects/shop/backups"
+
+// backupRunner simulates the running postgres container of dependencyRunner on a server whose
+// backup folder holds backups, answering the backup command with backupOutput.
+func backupRunner(configHash, backupOutput string, backups ...string) *fakeRunner {
+	docker := dependencyRunner(configHash, preUpdateDone).handler
+	return &fakeRunner{handler: func(command string, args []string) (string, error) {
+		switch {
+		case command == "sh" && args[1] == "echo $HOME":
+			return "/home/deploy", nil
+		case command == "sh" && strings.Contains(args[1], "docker exec"):
+			return backupOutput, nil
+		case command == "sh" && strings.Contains(args[1], "basename"):
+			return strings.Join(backups, "\n"), nil
+		}
+		return docker(command, args)
+	}}
+}
+
+func newBackupDeployment(runner *fakeRunner) *Deployment {
+	d := NewDeployment(runner, nil)
+	d.clock = (&fakeClock{now: time.Date(2024, 5, 1, 10, 0, 19, 0, time.UTC)}).Now
+	return d
+}
+
+func TestDeployDependencies_Backup(t *testing.T) {
+	postgres := config.Dependency{Name: "postgres", Image: "postgres:17", Volumes: []string{"pgdata:/var/lib/postgresql/data"}}
+	backupCommand := `sh -c umask 077 && mkdir -p "$1" && chmod 700 "$1" && docker exec "$2" sh -c "$3" > "$4.tmp" && mv -f "$4.tmp" "$4" && echo backup-done || rm -f "$4.tmp" sh ` +
+		testBackupDir + " shop-postgres " + postgres.BackupCommand() + " " + testBackupDir + "/postgres-20240501T100019Z.dump"
+
+	t.Run("backs up before stopping the container", func(t *testing.T) {
+		runner := backupRunner("outdated", backupDone)
+		d := newBackupDeployment(runner)
+		d.AllowDependencyRestarts(true)
+
+		require.NoError(t, d.deployDependencies(context.Background(), "shop", []config.Dependency{postgres}))
+
+		executed := runner.executed()
+		backup := indexOf(executed, backupCommand)
+		require.NotEqual(t, -1, backup)
+		assert.Less(t, backup, indexOf(executed, "docker stop c0ffee"))
+	})
+
+	t.Run("backs up before replacing a container without volumes", func(t *testing.T) {
+		dependency := postgres
+		dependency.Volumes = nil
+		runner := backupRunner("outdated", backupDone)
+		d := newBackupDeployment(runner)
+
+		require.NoError(t, d.deployDependencies(context.Background(), "shop", []config.Dependency{dependency}))
+
+		executed := runner.executed()
+		backup := indexOf(executed, backupCommand)
+		require.NotEqual(t, -1, backup)
+		assert.Less(t, backup, indexOf(executed, "docker run"))
+	})
+
+	t.Run("failed backup keeps the running container", func(t *testing.T) {
+		runner := backupRunner("outdated", "pg_dump: error: connection to server failed")
+		d := newBackupDeployment(runner)
+		d.AllowDependencyRestarts(true)
+
+		err := d.deployDependencies(context.Background(), "shop", []config.Dependency{postgres})
+		assert.ErrorContains(t, err, "pg_dump: error: connection to server failed")
+		assert.ErrorContains(t, err, "the dependency was not updated")
+		assert.Equal(t, -1, indexOf(runner.executed(), "docker stop"))
+		assert.Equal(t, -1, indexOf(runner.executed(), "docker run"))
+	})
+
+	t.Run("up to date dependency isn't backed up", func(t *testing.T) {
+		hash, err := dependencyService(&postgres).Hash()
+		require.NoError(t, err)
+		runner := backupRunner(hash, backupDone)
+		d := newBackupDeployment(runner)
+
+		require.NoError(t, d.deployDependencies(context.Background(), "shop", []config.Dependency{postgres}))
+		assert.Equal(t, -1, indexOf(runner.executed(), "sh -c mkdir"))
+	})
+
+	t.Run("dependency without backup command", func(t *testing.T) {
+		runner := backupRunner("outdated", backupDone)
+		d := newBackupDeployment(runner)
+		d.AllowDependencyRestarts(true)
+
+		redis := config.Dependency{Name: "redis", Image: "redis:7", Volumes: []string{"redis_data:/data"}}
+		require.NoError(t, d.deployDependencies(context.Background(), "shop", []config.Dependency{redis}))
+		assert.Equal(t, -1, indexOf(runner.executed(), "sh -c mkdir"))
+	})
+}
+
+func TestBackup(t *testing.T) {
+	dependency := &config.Dependency{
+		Name:   "postgres",
+		Image:  "postgres:17",
+		Backup: &config.Backup{Command: "pg_dumpall -U app", Retention: 2},
+	}
+	runner := backupRunner("", backupDone,
+		"postgres-20240429T100000Z.dump", "postgres-20240430T100000Z.dump", "postgres-20240501T100019Z.dump")
+	d := newBackupDeployment(runner)
+
+	var messages []string
+	for event := range d.Backup(context.Background(), "shop", dependency) {
+		require.NoError(t, event.Err)
+		messages = append(messages, event.Message)
+	}
+	assert.Contains(t, messages, "Backed up dependency postgres to "+testBackupDir+"/postgres-20240501T100019Z.dump")
+
+	executed := runner.executed()
+	// The dumps are only readable by the server user.
+	assert.NotEqual(t, -1, indexOf(executed, `sh -c umask 077 && mkdir -p "$1" && chmod 700 "$1" && docker exec "$2" sh -c "$3"`))
+	assert.Contains(t, executed, `sh -c for f in "$1"/$2; do [ -f "$f" ] && basename "$f"; done; true sh `+testBackupDir+" postgres-????????T??????Z.dump")
+	assert.Equal(t, "rm -f "+testBackupDir+"/postgres-20240429T100000Z.dump", executed[len(executed)-1])
+
+	err := Wait(d.Backup(context.Background(), "shop", &config.Dependency{Name: "redis", Image: "redis:7"}))
+	assert.EqualError(t, err, "dependency redis has no backup command")
+}
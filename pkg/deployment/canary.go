@@ -0,0 +1,306 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/proxy"
+)
+
+// canaryFileName is the marker in the project folder recording a canary deployment in progress.
+const canaryFileName = "canary.json"
+
+// canaryState is the content of the canary marker.
+type canaryState struct {
+	Percent int `json:"percent"`
+	// Services are the services whose new container runs next to the one it replaces.
+	Services []string  `json:"services"`
+	Owner    string    `json:"owner"`
+	Started  time.Time `json:"started"`
+}
+
+// CanaryInProgressError is returned when the project is deployed while a canary deployment
+// waits to be promoted or aborted.
+type CanaryInProgressError struct {
+	Services []string
+	Percent  int
+	Owner    string
+	Started  time.Time
+}
+
+func (e *CanaryInProgressError) Error() string {
+	return fmt.Sprintf("a canary deployment of %s sending %d%% of requests to the new containers was started by %s at %s; run ftl promote or ftl abort first",
+		strings.Join(e.Services, ", "), e.Percent, e.Owner, e.Started.Format(time.RFC3339))
+}
+
+// Canary makes Deploy a canary deployment: updated services with routes keep their running
+// container and their new container gets percent of the requests, until Promote or Abort.
+func (d *Deployment) Canary(percent int) {
+	d.canaryPercent = percent
+}
+
+// Promote sends all requests of the services of the canary deployment in progress to their new
+// containers and removes the containers they replace, in the background like Deploy.
+func (d *Deployment) Promote(ctx context.Context, project string, cfg *config.Config) <-chan Event {
+	return d.run(func() error { return d.promote(ctx, project, cfg) })
+}
+
+// Abort removes the new containers of the canary deployment in progress and sends all requests
+// to the running containers again, in the background like Deploy.
+func (d *Deployment) Abort(ctx context.Context, project string, cfg *config.Config) <-chan Event {
+	return d.run(func() error { return d.abort(ctx, project, cfg) })
+}
+
+// startCanary refuses to deploy while a canary deployment is in progress and starts a new one
+// when requested with Canary.
+func (d *Deployment) startCanary(ctx context.Context, project string) error {
+	state, err := d.readCanary(ctx, project)
+	if err != nil {
+		return err
+	}
+	if state != nil {
+		return &CanaryInProgressError{Services: state.Services, Percent: state.Percent, Owner: state.Owner, Started: state.Started}
+	}
+
+	if d.canaryPercent > 0 {
+		d.canary = &canaryState{Percent: d.canaryPercent, Owner: lockOwner(), Started: d.clock()}
+	}
+	return nil
+}
+
+// addCanaryService adds a service whose new container was started to the canary.
+func (d *Deployment) addCanaryService(service string) {
+	d.canaryMu.Lock()
+	defer d.canaryMu.Unlock()
+	d.canary.Services = append(d.canary.Services, service)
+}
+
+// saveCanary writes the canary marker once the services are deployed. A canary deployment
+// that updated no service with routes continues as a regular deployment.
+func (d *Deployment) saveCanary(ctx context.Context, project string) error {
+	if d.canary == nil {
+		return nil
+	}
+	if len(d.canary.Services) == 0 {
+		d.warn("canary", "", nil, "No service with routes was updated, deploying without a canary")
+		d.canary = nil
+		return nil
+	}
+	slices.Sort(d.canary.Services)
+
+	step := d.startStep("canary", "", "Sending %d%% of requests of %s to the new containers", d.canary.Percent, strings.Join(d.canary.Services, ", "))
+	if err := d.writeCanary(ctx, project, d.canary); err != nil {
+		step.fail(err)
+		return err
+	}
+	step.complete()
+	return nil
+}
+
+// canaries returns the request split of the canary in progress for the nginx configuration.
+func (d *Deployment) canaries() map[string]proxy.Canary {
+	if d.canary == nil {
+		return nil
+	}
+
+	canaries := make(map[string]proxy.Canary, len(d.canary.Services))
+	for _, service := range d.canary.Services {
+		canaries[service] = proxy.Canary{Alias: service + newContainerSuffix, Percent: d.canary.Percent}
+	}
+	return canaries
+}
+
+func (d *Deployment) promote(ctx context.Context, project string, cfg *config.Config) error {
+	state, release, err := d.lockCanary(ctx, project, cfg)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// Drain the replaced containers before their aliases move to the new ones.
+	d.canary = &canaryState{Percent: 100, Services: state.Services}
+	step := d.startStep("proxy", "", "Sending all requests to the new containers")
+	if err := d.updateProxyConfig(ctx, project, cfg); err != nil {
+		step.fail(err)
+		return fmt.Errorf("failed to update proxy: %w", err)
+	}
+	step.complete()
+
+	for _, name := range state.Services {
+		step := d.startStep("service/"+name, name, "Promoting service %s", name)
+		if err := d.promoteService(ctx, project, name, cfg); err != nil {
+			step.failf(err, "Failed to promote service %s", name)
+			return fmt.Errorf("failed to promote service %s: %w", name, err)
+		}
+		step.complete()
+	}
+
+	d.canary = nil
+	if err := d.finishCanary(ctx, project, cfg); err != nil {
+		return err
+	}
+
+	d.recordDeployment(ctx, project, cfg)
+	return nil
+}
+
+// promoteService moves the service alias to the new container and removes the replaced one.
+func (d *Deployment) promoteService(ctx context.Context, project, name string, cfg *config.Config) error {
+	service := &config.Service{Name: name}
+	for i := range cfg.Services {
+		if cfg.Services[i].Name == name {
+			service = &cfg.Services[i]
+			break
+		}
+	}
+
+	oldContID, err := d.switchTraffic(ctx, project, service)
+	if err != nil {
+		return fmt.Errorf("failed to switch traffic: %w", err)
+	}
+	if err := d.cleanup(ctx, project, oldContID, name); err != nil {
+		return fmt.Errorf("failed to clean up: %w", err)
+	}
+
+	return d.processPostHooks(ctx, service, containerName(project, name, ""))
+}
+
+func (d *Deployment) abort(ctx context.Context, project string, cfg *config.Config) error {
+	state, release, err := d.lockCanary(ctx, project, cfg)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	d.canary = nil
+	step := d.startStep("proxy", "", "Sending all requests to the running containers")
+	if err := d.updateProxyConfig(ctx, project, cfg); err != nil {
+		step.fail(err)
+		return fmt.Errorf("failed to update proxy: %w", err)
+	}
+	step.complete()
+
+	for _, name := range state.Services {
+		step := d.startStep("service/"+name, name, "Removing new container of service %s", name)
+		if err := d.removeContainer(containerName(project, name, newContainerSuffix)); err != nil {
+			step.fail(err)
+			return fmt.Errorf("failed to remove new container of service %s: %w", name, err)
+		}
+		step.complete()
+	}
+
+	return d.finishCanary(ctx, project, cfg)
+}
+
+// lockCanary takes the deployment lock and returns the canary in progress with a function
+// releasing the lock.
+func (d *Deployment) lockCanary(ctx context.Context, project string, cfg *config.Config) (*canaryState, func(), error) {
+	lock, err := d.acquireLock(ctx, project, cfg.Deploy.LockTimeout.Duration())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to acquire deployment lock: %w", err)
+	}
+	release := func() { _ = lock.release(context.Background()) }
+
+	state, err := d.readCanary(ctx, project)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	if state == nil {
+		release()
+		return nil, nil, fmt.Errorf("no canary deployment of %s is in progress", project)
+	}
+	return state, release, nil
+}
+
+// finishCanary removes the canary marker and the request split from the nginx configuration.
+func (d *Deployment) finishCanary(ctx context.Context, project string, cfg *config.Config) error {
+	step := d.startStep("canary", "", "Finishing canary deployment")
+	if err := d.removeCanary(ctx, project); err != nil {
+		step.fail(err)
+		return err
+	}
+	if err := d.updateProxyConfig(ctx, project, cfg); err != nil {
+		step.fail(err)
+		return fmt.Errorf("failed to update proxy: %w", err)
+	}
+	step.complete()
+	return nil
+}
+
+// updateProxyConfig writes the nginx configuration and, when it changed, tests and installs it
+// and reloads the proxy.
+func (d *Deployment) updateProxyConfig(ctx context.Context, project string, cfg *config.Config) error {
+	projectPath, err := d.prepareProjectFolder(project)
+	if err != nil {
+		return fmt.Errorf("failed to prepare project folder: %w", err)
+	}
+
+	configPath, changed, err := d.prepareNginxConfig(ctx, cfg, projectPath)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	service := proxyService(cfg, configPath, filepath.Join(projectPath, "html"))
+	if err := d.installNginxConfig(ctx, project, service, configPath); err != nil {
+		return err
+	}
+	return d.reloadProxy(ctx, project, configPath)
+}
+
+func (d *Deployment) canaryPath(project string) (string, error) {
+	projectPath, err := d.prepareProjectFolder(project)
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare project folder: %w", err)
+	}
+
+	return filepath.Join(projectPath, canaryFileName), nil
+}
+
+func (d *Deployment) readCanary(ctx context.Context, project string) (*canaryState, error) {
+	path, err := d.canaryPath(project)
+	if err != nil {
+		return nil, err
+	}
+
+	var state canaryState
+	found, err := d.readRemoteJSON(ctx, path, &state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read canary marker: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func (d *Deployment) writeCanary(ctx context.Context, project string, state *canaryState) error {
+	path, err := d.canaryPath(project)
+	if err != nil {
+		return err
+	}
+
+	if err := d.writeRemoteJSON(ctx, path, state); err != nil {
+		return fmt.Errorf("failed to write canary marker: %w", err)
+	}
+	return nil
+}
+
+func (d *Deployment) removeCanary(ctx context.Context, project string) error {
+	path, err := d.canaryPath(project)
+	if err != nil {
+		return err
+	}
+
+	if _, err := d.runCommand(ctx, "rm", "-f", path); err != nil {
+		return fmt.Errorf("failed to remove canary marker: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package deployment
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/proxy"
+)
+
+const testCanaryPath = "/home/test/projects/shop/" + canaryFileName
+
+// fakeCanaryServer emulates the files and containers a canary deployment touches.
+type fakeCanaryServer struct {
+	*fakeFileServer
+}
+
+func newFakeCanaryServer() *fakeCanaryServer {
+	return &fakeCanaryServer{fakeFileServer: newFakeFileServer()}
+}
+
+func (s *fakeCanaryServer) handle(command string, args []string) (string, error) {
+	joined := strings.Join(args, " ")
+	switch {
+	case command == "docker" && strings.HasPrefix(joined, "ps -aq"):
+		return "old123", nil
+	case command == "docker" && joined == "inspect old123":
+		return `[{"ID":"old123","NetworkSettings":{"Networks":{"shop":{"Aliases":["web"]}}}}]`, nil
+	case command == "docker" && args[0] == "exec":
+		return proxyReloaded, nil
+	case command == "docker" && strings.HasPrefix(joined, "run --rm"):
+		return proxyConfigValid, nil
+	}
+	return s.fakeFileServer.handle(command, args)
+}
+
+func canaryTestConfig() *config.Config {
+	return &config.Config{
+		Project:  config.Project{Name: "shop", Domain: "shop.example.com"},
+		Services: []config.Service{{Name: "web", Image: "shop/web:2", Port: 3000, Routes: []config.Route{{PathPrefix: "/"}}}},
+	}
+}
+
+func TestUpdateService_Canary(t *testing.T) {
+	runner := &fakeRunner{}
+	d := NewDeployment(runner, nil)
+	d.canary = &canaryState{Percent: 10}
+
+	cfg := canaryTestConfig()
+	require.NoError(t, d.updateService(context.Background(), "shop", &cfg.Services[0]))
+
+	assert.Equal(t, []string{"web"}, d.canary.Services)
+	for _, command := range runner.executed() {
+		assert.NotContains(t, command, "docker network")
+		assert.NotContains(t, command, "docker rm")
+	}
+	assert.Equal(t, map[string]proxy.Canary{"web": {Alias: "web_new", Percent: 10}}, d.canaries())
+}
+
+func TestSaveCanary(t *testing.T) {
+	server := newFakeCanaryServer()
+	d := NewDeployment(&fakeRunner{handler: server.handle}, nil)
+	d.Canary(25)
+
+	require.NoError(t, d.startCanary(context.Background(), "shop"))
+	d.addCanaryService("web")
+	d.addCanaryService("api")
+	require.NoError(t, d.saveCanary(context.Background(), "shop"))
+
+	var state canaryState
+	require.True(t, server.readJSON(t, testCanaryPath, &state))
+	assert.Equal(t, 25, state.Percent)
+	assert.Equal(t, []string{"api", "web"}, state.Services)
+
+	// A second deployment is refused until the canary is promoted or aborted.
+	err := NewDeployment(&fakeRunner{handler: server.handle}, nil).startCanary(context.Background(), "shop")
+	var inProgress *CanaryInProgressError
+	require.ErrorAs(t, err, &inProgress)
+	assert.Equal(t, []string{"api", "web"}, inProgress.Services)
+	assert.Contains(t, err.Error(), "run ftl promote or ftl abort first")
+
+	// Without updated services the deployment continues without a canary.
+	d = NewDeployment(&fakeRunner{}, nil)
+	d.canary = &canaryState{Percent: 25}
+	require.NoError(t, d.saveCanary(context.Background(), "shop"))
+	assert.Nil(t, d.canary)
+}
+
+func TestPromote(t *testing.T) {
+	server := newFakeCanaryServer()
+	server.writeJSON(t, testCanaryPath, canaryState{Percent: 10, Services: []string{"web"}, Started: time.Now()})
+	runner := &fakeRunner{handler: server.handle}
+	d := NewDeployment(runner, nil)
+
+	require.NoError(t, d.promote(context.Background(), "shop", canaryTestConfig()))
+
+	executed := runner.executed()
+	assert.Contains(t, executed, "docker network connect --alias web shop shop-web_new")
+	assert.Contains(t, executed, "docker network disconnect shop old123")
+	assert.Contains(t, executed, "docker rm old123")
+	assert.Contains(t, executed, "docker rename shop-web_new shop-web")
+	assert.NotContains(t, server.files, testCanaryPath)
+	assert.Nil(t, d.canary)
+
+	err := d.promote(context.Background(), "shop", canaryTestConfig())
+	assert.EqualError(t, err, "no canary deployment of shop is in progress")
+}
+
+func TestAbort(t *testing.T) {
+	server := newFakeCanaryServer()
+	server.writeJSON(t, testCanaryPath, canaryState{Percent: 10, Services: []string{"web"}, Started: time.Now()})
+	runner := &fakeRunner{handler: server.handle}
+	d := NewDeployment(runner, nil)
+
+	require.NoError(t, d.abort(context.Background(), "shop", canaryTestConfig()))
+
+	executed := runner.executed()
+	assert.Contains(t, executed, "docker rm -f shop-web_new")
+	for _, command := range executed {
+		assert.NotContains(t, command, "docker network")
+	}
+	assert.NotContains(t, server.files, testCanaryPath)
+}
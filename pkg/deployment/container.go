@@ -3,13 +3,20 @@ package deployment
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"path"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
-	"unicode"
+
+	dockercontainer "github.com/docker/docker/api/types/container"
+	dockernetwork "github.com/docker/docker/api/types/network"
 
 	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/runner/remote"
 )
 
 type containerInfo struct {
@@ -40,8 +47,8 @@ const (
 	ContainerStatusError
 )
 
-func (d *Deployment) getContainerStatus(project, service string) (ContainerStatusType, error) {
-	getContainerInfo, err := d.getContainerInfo(project, service)
+func (d *Deployment) getContainerStatus(ctx context.Context, project, service string) (ContainerStatusType, error) {
+	getContainerInfo, err := d.getContainerInfo(ctx, project, service)
 	if err != nil {
 		if strings.Contains(err.Error(), "no container found") {
 			return ContainerStatusNotFound, nil
@@ -57,8 +64,8 @@ func (d *Deployment) getContainerStatus(project, service string) (ContainerStatu
 	return ContainerStatusRunning, nil
 }
 
-func (d *Deployment) getContainerID(project, service string) (string, error) {
-	info, err := d.getContainerInfo(project, service)
+func (d *Deployment) getContainerID(ctx context.Context, project, service string) (string, error) {
+	info, err := d.getContainerInfo(ctx, project, service)
 	if err != nil {
 		return "", err
 	}
@@ -66,15 +73,19 @@ func (d *Deployment) getContainerID(project, service string) (string, error) {
 	return info.ID, err
 }
 
-func (d *Deployment) getContainerInfo(network, service string) (*containerInfo, error) {
-	output, err := d.runCommand(context.Background(), "docker", "ps", "-aq", "--filter", fmt.Sprintf("network=%s", network))
+func (d *Deployment) getContainerInfo(ctx context.Context, network, service string) (*containerInfo, error) {
+	if d.docker != nil {
+		return d.apiContainerInfo(ctx, network, service)
+	}
+
+	output, err := d.runCommand(ctx, "docker", "ps", "-aq", "--filter", fmt.Sprintf("network=%s", network))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get container IDs: %w", err)
 	}
 
 	containerIDs := strings.Fields(output)
 	for _, cid := range containerIDs {
-		inspectOutput, err := d.runCommand(context.Background(), "docker", "inspect", cid)
+		inspectOutput, err := d.runCommand(ctx, "docker", "inspect", cid)
 		if err != nil {
 			continue
 		}
@@ -96,17 +107,32 @@ func (d *Deployment) getContainerInfo(network, service string) (*containerInfo,
 	return nil, fmt.Errorf("no container found with alias %s in network %s", service, network)
 }
 
-func (d *Deployment) performHealthChecks(container string, healthCheck *config.ServiceHealthCheck) error {
+// performHealthChecks waits for the container of the service to become healthy. External
+// checks are probed by ftl over the project network, the others by Docker in the container.
+func (d *Deployment) performHealthChecks(ctx context.Context, project, container string, service *config.Service) error {
+	healthCheck := service.HealthCheck
 	if healthCheck == nil {
 		return nil
 	}
+	defer d.timePhase(service.Name, PhaseHealth)()
 
 	for i := 0; i < healthCheck.Retries; i++ {
-		output, err := d.runCommand(context.Background(), "docker", "inspect", "--format={{.State.Health.Status}}", container)
-		if err == nil && strings.TrimSpace(output) == "healthy" {
-			return nil
+		if healthCheck.CheckType() == config.HealthCheckExternal {
+			if d.probeExternal(ctx, project, container, service) {
+				return nil
+			}
+		} else {
+			output, err := d.runCommand(remote.Idempotent(ctx), "docker", "inspect", "--format={{.State.Health.Status}}", container)
+			if err == nil && strings.TrimSpace(output) == "healthy" {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(healthCheck.Interval.Duration()):
 		}
-		time.Sleep(healthCheck.Interval)
 	}
 
 	output, err := d.runCommand(context.Background(), "docker", "logs", container)
@@ -122,13 +148,72 @@ func (d *Deployment) performHealthChecks(container string, healthCheck *config.S
 
 	colorCodeRegex := regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
 	cleanedOutput := colorCodeRegex.ReplaceAllString(trimmedOutput, "")
-	grayOutput := "\x1b[90m" + cleanedOutput + "\x1b[0m"
 
-	return fmt.Errorf("container failed to become healthy\n\x1b[93mOutput from the container:\x1b[0m\n%s", grayOutput)
+	healthErr := &HealthCheckError{Container: container, Logs: strings.TrimSpace(cleanedOutput)}
+	if healthCheck.CheckType() != config.HealthCheckExternal {
+		healthLog, _ := d.runCommand(context.Background(), "docker", "inspect", "--format={{range .State.Health.Log}}{{.Output}}{{end}}", container)
+		healthErr.MissingBinary = missingBinary(healthLog)
+	}
+	return healthErr
+}
+
+// externalHealthCheckImage runs the requests of external health checks.
+const externalHealthCheckImage = "curlimages/curl:8.11.1"
+
+// probeExternal requests the health check path of the service from a temporary curl container
+// on the project network and reports whether it answered with a success or redirect status.
+func (d *Deployment) probeExternal(ctx context.Context, project, container string, service *config.Service) bool {
+	healthCheck := service.HealthCheck
+	port := healthCheck.Port
+	if port == 0 {
+		port = service.Port
+	}
+
+	args := []string{"run", "--rm", "--network", project, externalHealthCheckImage, "-s", "-o", "/dev/null", "-w", "%{http_code}"}
+	if timeout := healthCheck.Timeout.Duration(); timeout > 0 {
+		args = append(args, "--max-time", fmt.Sprintf("%d", int(timeout.Seconds())))
+	}
+	args = append(args, fmt.Sprintf("http://%s:%d%s", container, port, healthCheck.Path))
+
+	output, err := d.runCommand(ctx, "docker", args...)
+	if err != nil {
+		return false
+	}
+	// Pull progress may precede the status code.
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return false
+	}
+	status, err := strconv.Atoi(fields[len(fields)-1])
+	return err == nil && status >= 200 && status < 400
+}
+
+// missingBinaryPattern matches the errors of a health check command that doesn't exist in the
+// image: reported by the shell, as "/bin/sh: curl: not found", or by the container runtime
+// when the image has no shell, as `exec: "/bin/sh": stat /bin/sh: no such file or directory`.
+var missingBinaryPattern = regexp.MustCompile(`sh: (?:\d+: )?([\w.-]+): (?:command )?not found|exec: "([^"]+)": (?:stat|executable file not found)`)
+
+// missingBinary returns the program a failed health check couldn't run according to the
+// output of its attempts, or "" when the health check failed for another reason.
+func missingBinary(healthLog string) string {
+	match := missingBinaryPattern.FindStringSubmatch(healthLog)
+	switch {
+	case match == nil:
+		return ""
+	case match[1] != "":
+		return match[1]
+	default:
+		return path.Base(match[2])
+	}
 }
 
-func (d *Deployment) startContainer(container string) error {
-	_, err := d.runCommand(context.Background(), "docker", "start", container)
+func (d *Deployment) startContainer(ctx context.Context, container string) error {
+	var err error
+	if d.docker != nil {
+		err = d.docker.ContainerStart(ctx, container, dockercontainer.StartOptions{})
+	} else {
+		_, err = d.runCommand(ctx, "docker", "start", container)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to start container for %s: %v", container, err)
 	}
@@ -136,7 +221,111 @@ func (d *Deployment) startContainer(container string) error {
 	return nil
 }
 
-func (d *Deployment) createContainer(project string, service *config.Service, suffix string) error {
+func (d *Deployment) stopContainer(ctx context.Context, container string) error {
+	if d.docker != nil {
+		return d.docker.ContainerStop(ctx, container, dockercontainer.StopOptions{})
+	}
+	_, err := d.runCommand(ctx, "docker", "stop", container)
+	return err
+}
+
+// deleteContainer removes container, killing it first when force is set.
+func (d *Deployment) deleteContainer(ctx context.Context, container string, force bool) error {
+	if d.docker != nil {
+		return d.docker.ContainerRemove(ctx, container, dockercontainer.RemoveOptions{Force: force})
+	}
+	args := []string{"rm"}
+	if force {
+		args = append(args, "-f")
+	}
+	_, err := d.runCommand(ctx, "docker", append(args, container)...)
+	return err
+}
+
+func (d *Deployment) renameContainer(ctx context.Context, container, name string) error {
+	if d.docker != nil {
+		return d.docker.ContainerRename(ctx, container, name)
+	}
+	_, err := d.runCommand(ctx, "docker", "rename", container, name)
+	return err
+}
+
+func (d *Deployment) createContainer(ctx context.Context, project string, service *config.Service, suffix string) error {
+	defer d.timePhase(service.Name, PhaseCreate)()
+
+	if d.docker != nil {
+		return d.apiCreateContainer(ctx, project, service, suffix)
+	}
+
+	args, err := containerArgs(project, service, suffix)
+	if err != nil {
+		return err
+	}
+
+	if _, err := d.runCommand(ctx, "docker", args...); err != nil {
+		return err
+	}
+
+	// docker run joins a single network, the others are connected once the container exists.
+	container := containerName(project, service.Name, suffix)
+	for _, network := range service.Networks {
+		if err := d.connectNetwork(ctx, network, container, networkAliases(service, suffix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connectNetwork connects container to network with the given aliases.
+func (d *Deployment) connectNetwork(ctx context.Context, network, container string, aliases []string) error {
+	if d.docker != nil {
+		if err := d.docker.NetworkConnect(ctx, network, container, &dockernetwork.EndpointSettings{Aliases: aliases}); err != nil {
+			return fmt.Errorf("failed to connect %s to network %s: %w", container, network, err)
+		}
+		return nil
+	}
+
+	args := []string{"network", "connect"}
+	for _, alias := range aliases {
+		args = append(args, "--alias", alias)
+	}
+	args = append(args, network, container)
+
+	output, err := d.runCommand(ctx, "docker", args...)
+	if err != nil {
+		return fmt.Errorf("failed to connect %s to network %s: %w", container, network, err)
+	}
+	if output != "" {
+		return fmt.Errorf("failed to connect %s to network %s: %s", container, network, output)
+	}
+	return nil
+}
+
+func (d *Deployment) disconnectNetwork(ctx context.Context, network, container string) error {
+	var err error
+	if d.docker != nil {
+		err = d.docker.NetworkDisconnect(ctx, network, container, false)
+	} else {
+		_, err = d.runCommand(ctx, "docker", "network", "disconnect", network, container)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to disconnect %s from network %s: %v", container, network, err)
+	}
+	return nil
+}
+
+// networkAliases returns the aliases of the container of service on its networks. The new
+// container of a blue-green deployment is only known by the suffixed service name until the
+// traffic is switched to it.
+func networkAliases(service *config.Service, suffix string) []string {
+	if suffix != "" {
+		return []string{service.Name + suffix}
+	}
+	return append([]string{service.Name}, service.Aliases...)
+}
+
+// containerArgs returns the docker arguments that run the container of service.
+func containerArgs(project string, service *config.Service, suffix string) ([]string, error) {
 	container := containerName(project, service.Name, suffix)
 
 	args := []string{"run"}
@@ -147,64 +336,99 @@ func (d *Deployment) createContainer(project string, service *config.Service, su
 		args = append(args, "--detach")
 	}
 
-	args = append(args, []string{"--name", container, "--network", project, "--network-alias", service.Name + suffix, "--restart", "unless-stopped"}...)
+	args = append(args, "--name", container, "--network", project)
+	for _, alias := range networkAliases(service, suffix) {
+		args = append(args, "--network-alias", alias)
+	}
+	// Run-once containers are removed when they exit and never restarted.
+	if service.Container == nil || !service.Container.RunOnce {
+		args = append(args, "--restart", restartPolicy(service))
+	}
 
 	for _, value := range service.Env {
 		args = append(args, "-e", value)
 	}
 
-	for _, volume := range service.Volumes {
-		if unicode.IsLetter(rune(volume[0])) {
-			volume = fmt.Sprintf("%s-%s", project, volume)
+	labels := make([]string, 0, len(service.Labels))
+	for key := range service.Labels {
+		labels = append(labels, key)
+	}
+	sort.Strings(labels)
+	for _, key := range labels {
+		args = append(args, "--label", key+"="+service.Labels[key])
+	}
+
+	for _, host := range service.ExtraHosts {
+		args = append(args, "--add-host", host)
+	}
+
+	for _, server := range service.DNS {
+		args = append(args, "--dns", server)
+	}
+
+	args = append(args, securityArgs(&service.SecurityOptions)...)
+
+	if service.GPUs != "" {
+		// docker reads the value as CSV, so a device list must be quoted to keep its commas.
+		gpus := service.GPUs
+		if strings.Contains(gpus, ",") {
+			gpus = `"` + gpus + `"`
 		}
-		args = append(args, "-v", volume)
+		args = append(args, "--gpus", gpus)
 	}
 
+	args = append(args, volumeArgs(project, service.Volumes)...)
+
 	var healthCheckArgs []string
 
-	if service.HealthCheck != nil {
+	if service.HealthCheck != nil && service.HealthCheck.CheckType() != config.HealthCheckExternal {
 		healthCheckArgs = []string{
-			"--health-cmd", fmt.Sprintf("curl -sf http://localhost:%d%s || exit 1", service.Port, service.HealthCheck.Path),
-			"--health-interval", fmt.Sprintf("%ds", int(service.HealthCheck.Interval.Seconds())),
+			"--health-cmd", healthCommand(service),
+			"--health-interval", fmt.Sprintf("%ds", int(service.HealthCheck.Interval.Duration().Seconds())),
 			"--health-retries", fmt.Sprintf("%d", service.HealthCheck.Retries),
-			"--health-timeout", fmt.Sprintf("%ds", int(service.HealthCheck.Timeout.Seconds())),
+			"--health-timeout", fmt.Sprintf("%ds", int(service.HealthCheck.Timeout.Duration().Seconds())),
 		}
 	}
 
 	if service.Container != nil && service.Container.HealthCheck != nil {
 		healthCheckArgs = []string{
 			"--health-cmd", service.Container.HealthCheck.Cmd,
-			"--health-interval", service.Container.HealthCheck.Interval,
 			"--health-retries", fmt.Sprintf("%d", service.Container.HealthCheck.Retries),
 		}
-		if service.Container.HealthCheck.Timeout != "" {
-			healthCheckArgs = append(healthCheckArgs, "--health-timeout", service.Container.HealthCheck.Timeout)
+		if service.Container.HealthCheck.Interval > 0 {
+			healthCheckArgs = append(healthCheckArgs, "--health-interval", service.Container.HealthCheck.Interval.String())
+		}
+		if service.Container.HealthCheck.Timeout > 0 {
+			healthCheckArgs = append(healthCheckArgs, "--health-timeout", service.Container.HealthCheck.Timeout.String())
 		}
-		if service.Container.HealthCheck.StartPeriod != "" {
-			healthCheckArgs = append(healthCheckArgs, "--health-start-period", service.Container.HealthCheck.StartPeriod)
+		if service.Container.HealthCheck.StartPeriod > 0 {
+			healthCheckArgs = append(healthCheckArgs, "--health-start-period", service.Container.HealthCheck.StartPeriod.String())
 		}
-		if service.Container.HealthCheck.StartTimeout != "" {
-			healthCheckArgs = append(healthCheckArgs, "--health-start-timeout", service.Container.HealthCheck.StartTimeout)
+		if service.Container.HealthCheck.StartTimeout > 0 {
+			healthCheckArgs = append(healthCheckArgs, "--health-start-timeout", service.Container.HealthCheck.StartTimeout.String())
 		}
 	}
 
 	args = append(args, healthCheckArgs...)
 
-	for _, port := range service.LocalPorts {
-		args = append(args, "-p", fmt.Sprintf("127.0.0.1:%d:%d", port, port))
+	if service.Container != nil {
+		for _, ulimit := range service.Container.ULimits {
+			args = append(args, "--ulimit", fmt.Sprintf("%s=%d:%d", ulimit.Name, ulimit.Soft, ulimit.Hard))
+		}
 	}
 
-	if len(service.Forwards) > 0 {
-		for _, forward := range service.Forwards {
-			args = append(args, "-p", forward)
-		}
+	for _, spec := range portSpecs(service) {
+		args = append(args, "-p", spec)
 	}
 
 	hash, err := service.Hash()
 	if err != nil {
-		return fmt.Errorf("failed to generate config hash: %w", err)
+		return nil, fmt.Errorf("failed to generate config hash: %w", err)
 	}
 	args = append(args, "--label", fmt.Sprintf("ftl.config-hash=%s", hash))
+	if service.ImageDigest != "" {
+		args = append(args, "--label", "ftl.image-digest="+service.ImageDigest)
+	}
 
 	if len(service.Entrypoint) > 0 {
 		args = append(args, "--entrypoint", strings.Join(service.Entrypoint, " "))
@@ -223,27 +447,100 @@ func (d *Deployment) createContainer(project string, service *config.Service, su
 		args = append(args, service.CommandSlice...)
 	}
 
-	_, err = d.runCommand(context.Background(), "docker", args...)
-	return err
+	return args, nil
 }
 
-func (d *Deployment) containerShouldBeUpdated(project string, service *config.Service) (bool, error) {
-	containerInfo, err := d.getContainerInfo(project, service.Name)
-	if err != nil {
-		return false, fmt.Errorf("failed to get container info: %w", err)
+// restartPolicy returns the restart policy of the container of service.
+func restartPolicy(service *config.Service) string {
+	if service.Restart == "" {
+		return config.DefaultRestartPolicy
+	}
+	return service.Restart
+}
+
+// portSpecs returns the docker run -p values publishing the local ports and forwards of service.
+func portSpecs(service *config.Service) []string {
+	var specs []string
+	switch service.Expose {
+	case config.ExposeNone:
+	case config.ExposeHost:
+		for _, port := range service.LocalPorts {
+			specs = append(specs, fmt.Sprintf("0.0.0.0:%d:%d", port, port))
+		}
+	default:
+		for _, port := range service.LocalPorts {
+			specs = append(specs, fmt.Sprintf("127.0.0.1:%d:%d", port, port))
+		}
 	}
+	return append(specs, service.Forwards...)
+}
 
-	imageHash, err := d.getImageHash(service.Image)
+// healthCommand returns the docker health command of the service health check.
+func healthCommand(service *config.Service) string {
+	healthCheck := service.HealthCheck
+	port := healthCheck.Port
+	if port == 0 {
+		port = service.Port
+	}
+
+	switch healthCheck.CheckType() {
+	case config.HealthCheckCmd:
+		return healthCheck.Cmd
+	case config.HealthCheckTCP:
+		return fmt.Sprintf("bash -c '</dev/tcp/localhost/%d' || exit 1", port)
+	default:
+		return fmt.Sprintf("curl -sf http://localhost:%d%s || exit 1", port, healthCheck.Path)
+	}
+}
+
+// securityArgs returns the docker run arguments of the security options.
+func securityArgs(opts *config.SecurityOptions) []string {
+	var args []string
+	if opts.User != "" {
+		args = append(args, "--user", opts.User)
+	}
+	if opts.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	for _, capability := range opts.CapAdd {
+		args = append(args, "--cap-add", capability)
+	}
+	for _, capability := range opts.CapDrop {
+		args = append(args, "--cap-drop", capability)
+	}
+	for _, opt := range opts.SecurityOpt {
+		args = append(args, "--security-opt", opt)
+	}
+	for _, mount := range opts.Tmpfs {
+		args = append(args, "--tmpfs", mount)
+	}
+	return args
+}
+
+func (d *Deployment) containerShouldBeUpdated(ctx context.Context, project string, service *config.Service) (bool, error) {
+	containerInfo, err := d.getContainerInfo(ctx, project, service.Name)
 	if err != nil {
-		return false, fmt.Errorf("failed to get image hash: %w", err)
+		return false, fmt.Errorf("failed to get container info: %w", err)
 	}
 
 	if service.Image == "" && service.ImageUpdated {
 		return true, nil
 	}
 
-	if service.Image != "" && containerInfo.Image != imageHash {
-		return true, nil
+	if service.Image != "" {
+		imageHash, err := d.getImageHash(ctx, service.Image)
+		var notFound *ImageNotFoundError
+		if errors.As(err, &notFound) {
+			// The image is gone from the server, so the container is replaced, which pulls the
+			// image again or fails when it can't.
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to get image hash: %w", err)
+		}
+		if containerInfo.Image != imageHash {
+			return true, nil
+		}
 	}
 
 	hash, err := service.Hash()
@@ -257,3 +554,12 @@ func (d *Deployment) containerShouldBeUpdated(project string, service *config.Se
 func containerName(project, service, suffix string) string {
 	return fmt.Sprintf("%s-%s%s", project, service, suffix)
 }
+
+// volumeArgs returns the -v arguments mounting volumes, with named volumes prefixed by the project.
+func volumeArgs(project string, volumes []string) []string {
+	var args []string
+	for _, volume := range volumes {
+		args = append(args, "-v", config.VolumeMount(project, volume))
+	}
+	return args
+}
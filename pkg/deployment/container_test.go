@@ -0,0 +1,315 @@
+package deployment
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestContainerArgs(t *testing.T) {
+	service := &config.Service{
+		Name:         "web",
+		Image:        "shop/web:1",
+		CommandSlice: []string{"bin/server", "--port", "80"},
+		Container: &config.Container{
+			HealthCheck: &config.ContainerHealthCheck{Cmd: "curl -f localhost", Retries: 3},
+			ULimits:     []config.ULimit{{Name: "nofile", Soft: 1024, Hard: 65536}},
+		},
+	}
+
+	args, err := containerArgs("shop", service, newContainerSuffix)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"run", "--detach", "--name", "shop-web_new", "--network", "shop", "--network-alias", "web_new", "--restart", "unless-stopped"}, args[:10])
+	assert.Contains(t, args, "--ulimit")
+	assert.Contains(t, args, "nofile=1024:65536")
+	assert.NotContains(t, args, "--health-start-period")
+	assert.NotContains(t, strings.Join(args, " "), "ftl.image-digest")
+	assert.Equal(t, []string{"shop/web:1", "bin/server", "--port", "80"}, args[len(args)-4:])
+
+	service.ImageDigest = "shop/web@sha256:d1"
+	args, err = containerArgs("shop", service, newContainerSuffix)
+	require.NoError(t, err)
+	assert.Contains(t, args, "ftl.image-digest=shop/web@sha256:d1")
+}
+
+func TestContainerArgsRestartPolicy(t *testing.T) {
+	service := &config.Service{Name: "worker", Image: "shop/worker:1", Restart: "on-failure:5"}
+	args, err := containerArgs("shop", service, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--restart", "on-failure:5"}, args[8:10])
+
+	// Run-once containers ignore the policy.
+	service.Container = &config.Container{RunOnce: true}
+	args, err = containerArgs("shop", service, "run")
+	require.NoError(t, err)
+	assert.Contains(t, args, "--rm")
+	assert.NotContains(t, args, "--restart")
+
+	dependency := dependencyService(&config.Dependency{Name: "redis", Image: "redis:7", Restart: "always"})
+	args, err = containerArgs("shop", dependency, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--restart", "always"}, args[8:10])
+}
+
+func TestContainerArgsHealthCheck(t *testing.T) {
+	tests := []struct {
+		healthCheck config.ServiceHealthCheck
+		want        string
+	}{
+		{config.ServiceHealthCheck{Path: "/healthz"}, "curl -sf http://localhost:8080/healthz || exit 1"},
+		{config.ServiceHealthCheck{Path: "/healthz", Port: 9090}, "curl -sf http://localhost:9090/healthz || exit 1"},
+		{config.ServiceHealthCheck{Type: config.HealthCheckTCP}, "bash -c '</dev/tcp/localhost/8080' || exit 1"},
+		{config.ServiceHealthCheck{Type: config.HealthCheckTCP, Port: 9090}, "bash -c '</dev/tcp/localhost/9090' || exit 1"},
+		{config.ServiceHealthCheck{Type: config.HealthCheckCmd, Cmd: "wget -qO- localhost:9090/healthz"}, "wget -qO- localhost:9090/healthz"},
+	}
+
+	for _, tt := range tests {
+		service := &config.Service{Name: "web", Image: "shop/web:1", Port: 8080, HealthCheck: &tt.healthCheck}
+		args, err := containerArgs("shop", service, "")
+		require.NoError(t, err)
+
+		joined := strings.Join(args, " ")
+		assert.Contains(t, joined, "--health-cmd "+tt.want+" --health-interval", tt.want)
+	}
+
+	// External checks are run by ftl, not Docker.
+	service := &config.Service{Name: "web", Image: "shop/web:1", Port: 8080, HealthCheck: &config.ServiceHealthCheck{Type: config.HealthCheckExternal}}
+	args, err := containerArgs("shop", service, "")
+	require.NoError(t, err)
+	assert.NotContains(t, args, "--health-cmd")
+}
+
+func TestContainerShouldBeUpdated(t *testing.T) {
+	service := &config.Service{Name: "web", Image: "shop/web:1"}
+	hash, err := service.Hash()
+	require.NoError(t, err)
+
+	image := "sha256:web"
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		joined := strings.Join(args, " ")
+		switch {
+		case strings.HasPrefix(joined, "ps -aq"):
+			return "c1", nil
+		case joined == "inspect c1":
+			return `[{"ID":"c1","Image":"sha256:web","Config":{"Labels":{"ftl.config-hash":"` + hash + `"}},"NetworkSettings":{"Networks":{"shop":{"Aliases":["web"]}}}}]`, nil
+		case strings.HasPrefix(joined, "image inspect"):
+			return image, nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	update, err := d.containerShouldBeUpdated(context.Background(), "shop", service)
+	require.NoError(t, err)
+	assert.False(t, update)
+
+	image = "sha256:web2"
+	update, err = d.containerShouldBeUpdated(context.Background(), "shop", service)
+	require.NoError(t, err)
+	assert.True(t, update)
+
+	// A container whose image was removed from the server is replaced.
+	image = "Error response from daemon: No such image: shop/web:1"
+	update, err = d.containerShouldBeUpdated(context.Background(), "shop", service)
+	require.NoError(t, err)
+	assert.True(t, update)
+
+	image = "permission denied while trying to connect to the Docker daemon socket"
+	_, err = d.containerShouldBeUpdated(context.Background(), "shop", service)
+	assert.ErrorContains(t, err, "failed to get image hash: failed to inspect image shop/web:1: permission denied")
+}
+
+func TestServiceNetworks(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		joined := strings.Join(args, " ")
+		switch {
+		case strings.HasPrefix(joined, "ps -aq"):
+			return "old123", nil
+		case joined == "inspect old123":
+			return `[{"ID":"old123","NetworkSettings":{"Networks":{"shop":{"Aliases":["web"]}}}}]`, nil
+		case joined == "network connect --alias web_new missing shop-web_new":
+			return "Error response from daemon: network missing not found", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+	service := &config.Service{Name: "web", Image: "shop/web:1", Networks: []string{"monitoring"}, Aliases: []string{"legacy-web"}}
+
+	args, err := containerArgs("shop", service, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--network", "shop", "--network-alias", "web", "--network-alias", "legacy-web"}, args[4:10])
+
+	// The new container keeps the service aliases until the traffic is switched to it.
+	require.NoError(t, d.createContainer(context.Background(), "shop", service, newContainerSuffix))
+	_, err = d.switchTraffic(context.Background(), "shop", service)
+	require.NoError(t, err)
+
+	executed := runner.executed()
+	assert.Contains(t, executed[0], "--network shop --network-alias web_new --restart")
+	assert.Equal(t, []string{
+		"docker network connect --alias web_new monitoring shop-web_new",
+		"docker ps -aq --filter network=shop",
+		"docker inspect old123",
+		"docker network disconnect shop shop-web_new",
+		"docker network connect --alias web --alias legacy-web shop shop-web_new",
+		"docker network disconnect monitoring shop-web_new",
+		"docker network connect --alias web --alias legacy-web monitoring shop-web_new",
+		"docker network disconnect shop old123",
+		"docker network disconnect monitoring old123",
+	}, executed[1:])
+
+	service.Networks = []string{"missing"}
+	err = d.createContainer(context.Background(), "shop", service, newContainerSuffix)
+	assert.EqualError(t, err, "failed to connect shop-web_new to network missing: Error response from daemon: network missing not found")
+}
+
+func TestPerformHealthChecksExternal(t *testing.T) {
+	var probes []string
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if args[0] != "run" {
+			return "", nil
+		}
+		probes = append(probes, strings.Join(args, " "))
+		if len(probes) == 1 {
+			return "Unable to find image 'curlimages/curl:8.11.1' locally\n000", nil
+		}
+		return "204", nil
+	}}
+	d := NewDeployment(runner, nil)
+	service := &config.Service{Name: "web", Port: 8080, HealthCheck: &config.ServiceHealthCheck{
+		Type:     config.HealthCheckExternal,
+		Path:     "/healthz",
+		Port:     9090,
+		Timeout:  config.Duration(2 * time.Second),
+		Retries:  3,
+		Interval: config.Duration(time.Millisecond),
+	}}
+
+	require.NoError(t, d.performHealthChecks(context.Background(), "shop", "shop-web_new", service))
+	require.Len(t, probes, 2)
+	assert.Equal(t, "run --rm --network shop "+externalHealthCheckImage+" -s -o /dev/null -w %{http_code} --max-time 2 http://shop-web_new:9090/healthz", probes[1])
+
+	runner.handler = func(command string, args []string) (string, error) {
+		if args[0] == "run" {
+			return "503", nil
+		}
+		return "", nil
+	}
+	err := d.performHealthChecks(context.Background(), "shop", "shop-web_new", service)
+	var healthErr *HealthCheckError
+	require.ErrorAs(t, err, &healthErr)
+	assert.Empty(t, healthErr.MissingBinary)
+}
+
+func TestPerformHealthChecksMissingBinary(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		joined := strings.Join(args, " ")
+		switch {
+		case strings.HasPrefix(joined, "inspect --format={{.State.Health.Status}}"):
+			return "unhealthy", nil
+		case strings.HasPrefix(joined, "inspect --format={{range .State.Health.Log}}"):
+			return "/bin/sh: curl: not found\n/bin/sh: curl: not found", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+	service := &config.Service{Name: "web", Port: 8080, HealthCheck: &config.ServiceHealthCheck{Path: "/", Retries: 1}}
+
+	err := d.performHealthChecks(context.Background(), "shop", "shop-web", service)
+	var healthErr *HealthCheckError
+	require.ErrorAs(t, err, &healthErr)
+	assert.Equal(t, "curl", healthErr.MissingBinary)
+	assert.EqualError(t, err, "container shop-web failed to become healthy: the image has no curl to run the health check, use health_check type external")
+}
+
+func TestMissingBinary(t *testing.T) {
+	tests := []struct {
+		healthLog string
+		want      string
+	}{
+		{"/bin/sh: curl: not found", "curl"},
+		{"/bin/sh: 1: bash: not found", "bash"},
+		{"bash: line 1: wget: command not found\nbash: wget: command not found", "wget"},
+		{`OCI runtime exec failed: exec failed: unable to start container process: exec: "/bin/sh": stat /bin/sh: no such file or directory: unknown`, "sh"},
+		{`exec: "curl": executable file not found in $PATH`, "curl"},
+		{"curl: (7) Failed to connect to localhost port 8080: Connection refused", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, missingBinary(tt.healthLog), tt.healthLog)
+	}
+}
+
+func TestContainerArgsLabelsHostsAndDNS(t *testing.T) {
+	service := &config.Service{
+		Name:       "web",
+		Image:      "shop/web:1",
+		Labels:     map[string]string{"traefik.enable": "false", "com.example.team": "shop"},
+		ExtraHosts: []string{"host.docker.internal:host-gateway"},
+		DNS:        []string{"10.0.0.2", "10.0.0.3"},
+	}
+	args, err := containerArgs("shop", service, "")
+	require.NoError(t, err)
+
+	joined := strings.Join(args, " ")
+	assert.Contains(t, joined, "--label com.example.team=shop --label traefik.enable=false")
+	assert.Contains(t, joined, "--add-host host.docker.internal:host-gateway")
+	assert.Contains(t, joined, "--dns 10.0.0.2 --dns 10.0.0.3")
+}
+
+func TestContainerArgsSecurityOptions(t *testing.T) {
+	service := &config.Service{
+		Name:  "web",
+		Image: "shop/web:1",
+		SecurityOptions: config.SecurityOptions{
+			User:        "1000",
+			ReadOnly:    true,
+			CapAdd:      []string{"NET_BIND_SERVICE"},
+			CapDrop:     []string{"ALL"},
+			SecurityOpt: []string{"no-new-privileges"},
+			Tmpfs:       []string{"/tmp:size=64m"},
+		},
+	}
+	args, err := containerArgs("shop", service, "")
+	require.NoError(t, err)
+
+	assert.Contains(t, strings.Join(args, " "),
+		"--user 1000 --read-only --cap-add NET_BIND_SERVICE --cap-drop ALL --security-opt no-new-privileges --tmpfs /tmp:size=64m")
+
+	args, err = containerArgs("shop", &config.Service{Name: "web", Image: "shop/web:1"}, "")
+	require.NoError(t, err)
+	assert.NotContains(t, args, "--read-only")
+	assert.NotContains(t, args, "--user")
+}
+
+func TestContainerArgsDefaultDependency(t *testing.T) {
+	t.Setenv("MINIO_ROOT_PASSWORD", "minio-secret")
+	t.Setenv("CLICKHOUSE_PASSWORD", "clickhouse-secret")
+
+	var minio config.Dependency
+	require.NoError(t, yaml.Unmarshal([]byte(`"minio"`), &minio))
+	args, err := containerArgs("shop", dependencyService(&minio), "")
+	require.NoError(t, err)
+
+	joined := strings.Join(args, " ")
+	assert.Contains(t, joined, "-e MINIO_ROOT_PASSWORD=minio-secret")
+	assert.Contains(t, joined, "-v shop-minio_data:/data")
+	assert.Contains(t, joined, "-p 127.0.0.1:9000:9000 -p 127.0.0.1:9001:9001")
+	assert.Equal(t, []string{"minio/minio:latest", "server", "/data", "--console-address", ":9001"}, args[len(args)-5:])
+
+	var clickhouse config.Dependency
+	require.NoError(t, yaml.Unmarshal([]byte(`"clickhouse:24.8"`), &clickhouse))
+	args, err = containerArgs("shop", dependencyService(&clickhouse), "")
+	require.NoError(t, err)
+	assert.Contains(t, strings.Join(args, " "), "--ulimit nofile=262144:262144")
+	assert.Equal(t, "clickhouse/clickhouse-server:24.8", args[len(args)-1])
+}
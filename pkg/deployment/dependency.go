@@ -3,30 +3,79 @@ package deployment
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"unicode"
 
 	"github.com/yarlson/ftl/pkg/config"
 )
 
+// preUpdateDone is printed once a dependency pre_update command succeeded.
+const preUpdateDone = "pre-update-done"
+
+// DependencyRestartError is returned when dependencies have to be stopped to be updated and
+// dependency restarts weren't allowed.
+type DependencyRestartError struct {
+	Dependencies []string
+}
+
+func (e *DependencyRestartError) Error() string {
+	return fmt.Sprintf("updating %s requires stopping it, since its data volumes can't be attached to two containers at once; use --allow-dependency-restart to accept the downtime",
+		strings.Join(e.Dependencies, ", "))
+}
+
+// AllowDependencyRestarts lets Deploy stop dependencies with data volumes to update them.
+func (d *Deployment) AllowDependencyRestarts(allow bool) {
+	d.allowDependencyRestarts = allow
+}
+
+// deployDependencies deploys the dependencies. Updates of dependencies whose running container
+// holds their named volumes would attach the volumes to a second container, so those are
+// stopped before their new container starts instead, one at a time and only when allowed.
 func (d *Deployment) deployDependencies(ctx context.Context, project string, dependencies []config.Dependency) error {
-	hostname := d.runner.Host()
+	restarts, err := d.dependencyRestarts(ctx, project, dependencies)
+	if err != nil {
+		return err
+	}
+	if len(restarts) > 0 && !d.allowDependencyRestarts {
+		names := make([]string, 0, len(restarts))
+		for name := range restarts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return &DependencyRestartError{Dependencies: names}
+	}
+
 	var wg sync.WaitGroup
 	errChan := make(chan error, len(dependencies))
 
 	for _, dep := range dependencies {
+		if restarts[dep.Name] {
+			continue
+		}
+
 		wg.Add(1)
 		go func(dep config.Dependency) {
 			defer wg.Done()
 
-			spinner := d.sm.AddSpinner("dependency", fmt.Sprintf("[%s] Deploying dependency %s", hostname, dep.Name))
+			step := d.startStep("dependency/"+dep.Name, dep.Name, "Deploying dependency %s", dep.Name)
 
-			if err := d.startDependency(project, &dep); err != nil {
-				spinner.ErrorWithMessagef("Failed to deploy dependency %s: %v", dep.Name, err)
+			changed, err := d.startDependency(ctx, project, &dep)
+			step.phases = d.phaseDurations(dep.Name)
+			if err != nil {
+				step.failf(err, "Failed to deploy dependency %s", dep.Name)
 				errChan <- fmt.Errorf("failed to deploy dependency %s: %w", dep.Name, err)
 				return
 			}
+			step.changed = changed
 
-			spinner.Complete()
+			if dep.ExposeMode() == config.ExposeHost && len(dep.Ports) > 0 {
+				step.completef("Deployed dependency %s (WARNING: ports %v are published on all interfaces)", dep.Name, dep.Ports)
+				return
+			}
+
+			step.complete()
 		}(dep)
 	}
 
@@ -42,20 +91,145 @@ func (d *Deployment) deployDependencies(ctx context.Context, project string, dep
 		return fmt.Errorf("errors occurred during dependency deployment: %v", errs)
 	}
 
+	for _, dep := range dependencies {
+		if !restarts[dep.Name] {
+			continue
+		}
+
+		step := d.startStep("dependency/"+dep.Name, dep.Name, "Restarting dependency %s to update it", dep.Name)
+		err := d.restartDependency(ctx, project, &dep)
+		step.phases = d.phaseDurations(dep.Name)
+		if err != nil {
+			step.failf(err, "Failed to update dependency %s", dep.Name)
+			return fmt.Errorf("failed to update dependency %s: %w", dep.Name, err)
+		}
+		step.changed = true
+		step.complete()
+	}
+
 	return nil
 }
 
-func (d *Deployment) startDependency(project string, dependency *config.Dependency) error {
-	service := &config.Service{
-		Name:       dependency.Name,
-		Image:      dependency.Image,
-		Volumes:    dependency.Volumes,
-		Env:        dependency.Env,
-		LocalPorts: dependency.Ports,
+// dependencyRestarts returns the dependencies whose running container has to be replaced
+// and shares named volumes with the new one.
+func (d *Deployment) dependencyRestarts(ctx context.Context, project string, dependencies []config.Dependency) (map[string]bool, error) {
+	restarts := make(map[string]bool)
+
+	for _, dep := range dependencies {
+		volumes := namedVolumes(project, dep.Volumes)
+		if len(volumes) == 0 {
+			continue
+		}
+
+		info, err := d.getContainerInfo(ctx, project, dep.Name)
+		if err != nil {
+			if strings.Contains(err.Error(), "no container found") {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get container info for %s: %w", dep.Name, err)
+		}
+		if !mountsAny(info, volumes) {
+			continue
+		}
+
+		service := dependencyService(&dep)
+		if err := d.updateImage(ctx, project, service); err != nil {
+			return nil, err
+		}
+		update, err := d.containerShouldBeUpdated(ctx, project, service)
+		if err != nil {
+			return nil, err
+		}
+		if update {
+			restarts[dep.Name] = true
+		}
+	}
+
+	return restarts, nil
+}
+
+// restartDependency replaces the dependency container by stopping it before its new container
+// starts, after backing it up and running the pre_update command in it.
+func (d *Deployment) restartDependency(ctx context.Context, project string, dependency *config.Dependency) error {
+	if dependency.BackupCommand() != "" {
+		status, err := d.getContainerStatus(ctx, project, dependency.Name)
+		if err != nil {
+			return err
+		}
+		if err := d.backupRunningDependency(ctx, project, dependency, status); err != nil {
+			return err
+		}
 	}
-	if err := d.deployService(project, service); err != nil {
-		return fmt.Errorf("failed to start container for %s: %v", dependency.Image, err)
+
+	if dependency.PreUpdate != "" {
+		container := containerName(project, dependency.Name, "")
+		output, err := d.runCommand(ctx, "docker", "exec", container, "sh", "-c",
+			fmt.Sprintf("{ %s\n} && echo %s", dependency.PreUpdate, preUpdateDone))
+		if err != nil {
+			return fmt.Errorf("failed to run pre_update command: %w", err)
+		}
+		if !strings.Contains(output, preUpdateDone) {
+			return fmt.Errorf("pre_update command failed, the dependency was not updated:\n%s", output)
+		}
 	}
 
-	return nil
+	return d.recreateService(ctx, project, dependencyService(dependency))
+}
+
+// startDependency deploys the dependency and reports whether its container was created,
+// replaced or started.
+func (d *Deployment) startDependency(ctx context.Context, project string, dependency *config.Dependency) (bool, error) {
+	service := dependencyService(dependency)
+	if err := d.backupBeforeUpdate(ctx, project, dependency, service); err != nil {
+		return false, err
+	}
+
+	changed, err := d.ensureService(ctx, project, service)
+	if err != nil {
+		return false, fmt.Errorf("failed to start container for %s: %v", dependency.Image, err)
+	}
+
+	return changed, nil
+}
+
+func dependencyService(dependency *config.Dependency) *config.Service {
+	return &config.Service{
+		Name:            dependency.Name,
+		Image:           dependency.Image,
+		Volumes:         dependency.Volumes,
+		Env:             dependency.Env,
+		Restart:         dependency.Restart,
+		Labels:          dependency.Labels,
+		ExtraHosts:      dependency.ExtraHosts,
+		DNS:             dependency.DNS,
+		SecurityOptions: dependency.SecurityOptions,
+		LocalPorts:      dependency.Ports,
+		Expose:          dependency.ExposeMode(),
+		Container:       dependency.Container,
+		CommandSlice:    dependency.CommandSlice,
+	}
+}
+
+// namedVolumes returns the Docker volumes, as created for the project, of the volume mounts
+// that refer to named volumes rather than host paths.
+func namedVolumes(project string, volumes []string) map[string]bool {
+	named := make(map[string]bool)
+	for _, volume := range volumes {
+		if volume != "" && unicode.IsLetter(rune(volume[0])) {
+			name, _, _ := strings.Cut(volume, ":")
+			named[fmt.Sprintf("%s-%s", project, name)] = true
+		}
+	}
+	return named
+}
+
+// mountsAny reports whether the container has one of the volumes mounted.
+func mountsAny(info *containerInfo, volumes map[string]bool) bool {
+	for _, bind := range info.HostConfig.Binds {
+		source, _, _ := strings.Cut(bind, ":")
+		if volumes[source] {
+			return true
+		}
+	}
+	return false
 }
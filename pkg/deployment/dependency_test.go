@@ -0,0 +1,113 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+// dependencyRunner simulates a running postgres container of project shop with the given
+// config hash label, mounting the shop-pgdata volume.
+func dependencyRunner(configHash, execOutput string) *fakeRunner {
+	return &fakeRunner{handler: func(command string, args []string) (string, error) {
+		switch {
+		case command != "docker":
+			return "", nil
+		case args[0] == "ps":
+			return "c0ffee", nil
+		case args[0] == "inspect" && args[1] == "c0ffee":
+			return fmt.Sprintf(`[{"ID": "c0ffee", "Image": "sha256:postgres", "State": {"Status": "running"},
+				"Config": {"Labels": {"ftl.config-hash": %q}},
+				"HostConfig": {"Binds": ["shop-pgdata:/var/lib/postgresql/data"]},
+				"NetworkSettings": {"Networks": {"shop": {"Aliases": ["postgres"]}}}}]`, configHash), nil
+		case args[0] == "image" && args[1] == "inspect":
+			return "sha256:postgres", nil
+		case args[0] == "exec":
+			return execOutput, nil
+		}
+		return "", nil
+	}}
+}
+
+func indexOf(commands []string, prefix string) int {
+	for i, command := range commands {
+		if strings.HasPrefix(command, prefix) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestDeployDependencies_VolumeUpdate(t *testing.T) {
+	dependency := config.Dependency{
+		Name:      "postgres",
+		Image:     "postgres:17",
+		Volumes:   []string{"pgdata:/var/lib/postgresql/data"},
+		PreUpdate: "pg_dump -U app app > /var/lib/postgresql/data/backup.sql",
+		Backup:    &config.Backup{Disabled: true},
+	}
+
+	t.Run("requires permission", func(t *testing.T) {
+		runner := dependencyRunner("outdated", "")
+		d := NewDeployment(runner, nil)
+
+		err := d.deployDependencies(context.Background(), "shop", []config.Dependency{dependency})
+		var restartErr *DependencyRestartError
+		require.True(t, errors.As(err, &restartErr))
+		assert.Equal(t, []string{"postgres"}, restartErr.Dependencies)
+		assert.Contains(t, err.Error(), "--allow-dependency-restart")
+		assert.Equal(t, -1, indexOf(runner.executed(), "docker stop"))
+		assert.Equal(t, -1, indexOf(runner.executed(), "docker exec"))
+	})
+
+	t.Run("stops before starting the new container", func(t *testing.T) {
+		runner := dependencyRunner("outdated", preUpdateDone)
+		d := NewDeployment(runner, nil)
+		d.AllowDependencyRestarts(true)
+
+		require.NoError(t, d.deployDependencies(context.Background(), "shop", []config.Dependency{dependency}))
+
+		executed := runner.executed()
+		backup := indexOf(executed, "docker exec shop-postgres sh -c { pg_dump -U app app > /var/lib/postgresql/data/backup.sql\n} && echo pre-update-done")
+		stop := indexOf(executed, "docker stop c0ffee")
+		run := indexOf(executed, "docker run --detach --name shop-postgres ")
+		require.NotEqual(t, -1, backup)
+		assert.Less(t, backup, stop)
+		assert.Less(t, stop, run)
+		assert.Equal(t, -1, indexOf(executed, "docker run --detach --name shop-postgres_new"))
+	})
+
+	t.Run("failed pre_update keeps the running container", func(t *testing.T) {
+		runner := dependencyRunner("outdated", "pg_dump: error: connection failed")
+		d := NewDeployment(runner, nil)
+		d.AllowDependencyRestarts(true)
+
+		err := d.deployDependencies(context.Background(), "shop", []config.Dependency{dependency})
+		assert.ErrorContains(t, err, "pg_dump: error: connection failed")
+		assert.Equal(t, -1, indexOf(runner.executed(), "docker stop"))
+	})
+
+	t.Run("up to date dependency is left running", func(t *testing.T) {
+		hash, err := dependencyService(&dependency).Hash()
+		require.NoError(t, err)
+		runner := dependencyRunner(hash, "")
+		d := NewDeployment(runner, nil)
+
+		require.NoError(t, d.deployDependencies(context.Background(), "shop", []config.Dependency{dependency}))
+		assert.Equal(t, -1, indexOf(runner.executed(), "docker stop"))
+		assert.Equal(t, -1, indexOf(runner.executed(), "docker run"))
+	})
+}
+
+func TestNamedVolumes(t *testing.T) {
+	assert.Equal(t, map[string]bool{"shop-pgdata": true, "shop-uploads": true},
+		namedVolumes("shop", []string{"pgdata:/var/lib/postgresql/data", "/srv/config:/etc/app:ro", "uploads:/uploads"}))
+	assert.Empty(t, namedVolumes("shop", []string{"/srv/data:/data"}))
+}
@@ -6,11 +6,13 @@ import (
 	"io"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/yarlson/ftl/pkg/runner/local"
+	"github.com/yarlson/ftl/pkg/runner/remote"
 
 	"github.com/yarlson/ftl/pkg/config"
-	"github.com/yarlson/ftl/pkg/console"
 )
 
 const (
@@ -19,8 +21,11 @@ const (
 
 type Runner interface {
 	CopyFile(ctx context.Context, from, to string) error
+	CopyReader(ctx context.Context, src io.Reader, dst string, opts remote.CopyOptions) error
+	CopyDir(ctx context.Context, localDir, remoteDir string, opts remote.CopyDirOptions) error
 	Host() string
 	RunCommand(ctx context.Context, command string, args ...string) (io.ReadCloser, error)
+	RunCommandWithInput(ctx context.Context, stdin io.Reader, command string, args ...string) (io.ReadCloser, error)
 }
 
 type ImageSyncer interface {
@@ -29,26 +34,95 @@ type ImageSyncer interface {
 }
 
 type Deployment struct {
-	runner      Runner
-	localRunner *local.Runner
-	syncer      ImageSyncer
-	sm          *console.SpinnerManager
+	runner            Runner
+	localRunner       *local.Runner
+	syncer            ImageSyncer
+	docker            DockerAPI
+	events            chan Event
+	clock             func() time.Time
+	heartbeatInterval time.Duration
+	// allowDependencyRestarts permits updates that stop dependencies holding data volumes.
+	allowDependencyRestarts bool
+	// canaryPercent makes deploys leave updated services with routes running next to their
+	// new container, which gets this share of the requests. Zero switches traffic right away.
+	canaryPercent int
+	// canary is the canary in progress, whose split the generated nginx configuration carries.
+	canary   *canaryState
+	canaryMu sync.Mutex
+	// recreateNetwork permits recreating the project network when its subnet differs from the
+	// configured one.
+	recreateNetwork bool
+	// retryAttempts and retryDelay set how operations failing with a transient error are
+	// retried, see Retries.
+	retryAttempts int
+	retryDelay    time.Duration
+	// pullSlots caps the images pulled at once, and pulls holds the pull of every image of the
+	// running deployment, shared by the services and dependencies using the image.
+	pullSlots chan struct{}
+	pulls     map[string]*imagePull
+	pullsMu   sync.Mutex
+	// phases holds how long the phases of each service took, until its step reports them.
+	phases   map[string]map[Phase]time.Duration
+	phasesMu sync.Mutex
 }
 
-func NewDeployment(runner Runner, syncer ImageSyncer, sm *console.SpinnerManager) *Deployment {
-	return &Deployment{runner: runner, syncer: syncer, sm: sm, localRunner: local.NewRunner()}
+func NewDeployment(runner Runner, syncer ImageSyncer) *Deployment {
+	return &Deployment{
+		runner:            runner,
+		syncer:            syncer,
+		localRunner:       local.NewRunner(),
+		clock:             time.Now,
+		heartbeatInterval: defaultHeartbeatInterval,
+		retryAttempts:     defaultRetryAttempts,
+		retryDelay:        defaultRetryDelay,
+	}
+}
+
+// Deploy deploys the project in the background and returns its events. The channel is closed
+// after the EventFinished event, which carries the error of a failed deployment. The caller
+// must drain the channel, and a Deployment runs one deployment at a time.
+func (d *Deployment) Deploy(ctx context.Context, project string, cfg *config.Config) <-chan Event {
+	return d.run(func() error { return d.deploy(ctx, project, cfg) })
+}
+
+// run runs operation in the background, reporting its progress as events like Deploy.
+func (d *Deployment) run(operation func() error) <-chan Event {
+	events := make(chan Event, eventBuffer)
+	d.events = events
+
+	go func() {
+		defer close(events)
+		started := d.clock()
+		err := operation()
+		d.emit(Event{Type: EventFinished, Err: err, Started: started})
+	}()
+
+	return events
 }
 
-func (d *Deployment) Deploy(ctx context.Context, project string, cfg *config.Config) error {
-	hostname := d.runner.Host()
+func (d *Deployment) deploy(ctx context.Context, project string, cfg *config.Config) error {
+	lock, err := d.acquireLock(ctx, project, cfg.Deploy.LockTimeout.Duration())
+	if err != nil {
+		return fmt.Errorf("failed to acquire deployment lock: %w", err)
+	}
+	defer func() { _ = lock.release(context.Background()) }()
+
+	if err := d.startCanary(ctx, project); err != nil {
+		return err
+	}
+	d.startPulls(cfg.Deploy.MaxPulls)
+
+	if err := d.loginRegistries(ctx, cfg.Registries); err != nil {
+		return fmt.Errorf("failed to log into registries: %w", err)
+	}
 
 	// Create project network
-	spinner := d.sm.AddSpinner("network", fmt.Sprintf("[%s] Creating network...", hostname))
-	if err := d.createNetwork(project); err != nil {
-		spinner.Error()
+	step := d.startStep("network", "", "Creating network")
+	if err := d.createNetwork(ctx, project, cfg.Project.Network); err != nil {
+		step.fail(err)
 		return fmt.Errorf("failed to create network: %w", err)
 	}
-	spinner.Complete()
+	step.complete()
 
 	// Create volumes
 	cfg.Volumes = append(cfg.Volumes, "certs")
@@ -56,6 +130,23 @@ func (d *Deployment) Deploy(ctx context.Context, project string, cfg *config.Con
 		return fmt.Errorf("failed to create volumes: %w", err)
 	}
 
+	// Check host paths on the server and upload the ones relative to the project
+	if err := d.checkHostPaths(ctx, cfg); err != nil {
+		return err
+	}
+	if err := d.uploadVolumes(ctx, project, cfg); err != nil {
+		return fmt.Errorf("failed to upload volumes: %w", err)
+	}
+
+	// Run project pre-deploy hook
+	if err := d.runProjectHook(ctx, project, "pre-deploy", preDeployHook(cfg.Hooks)); err != nil {
+		return err
+	}
+
+	if err := d.checkGPUSupport(ctx, cfg.Services); err != nil {
+		return err
+	}
+
 	// Deploy dependencies
 	if err := d.deployDependencies(ctx, project, cfg.Dependencies); err != nil {
 		return fmt.Errorf("failed to deploy dependencies: %w", err)
@@ -71,9 +162,14 @@ func (d *Deployment) Deploy(ctx context.Context, project string, cfg *config.Con
 		}
 	}
 
-	// Deploy services
-	if err := d.deployServices(ctx, project, cfg.Services); err != nil {
-		return fmt.Errorf("failed to deploy services: %w", err)
+	// Deploy services. The canary is recorded even when some services failed, so the new
+	// containers it left running can be promoted or aborted.
+	servicesErr := d.deployServices(ctx, project, cfg.Services, cfg.Deploy.MaxParallel)
+	if err := d.saveCanary(ctx, project); err != nil {
+		return err
+	}
+	if servicesErr != nil {
+		return fmt.Errorf("failed to deploy services: %w", servicesErr)
 	}
 
 	tunnelCancel()
@@ -83,6 +179,24 @@ func (d *Deployment) Deploy(ctx context.Context, project string, cfg *config.Con
 		return fmt.Errorf("failed to start proxy: %w", err)
 	}
 
+	// Install scheduled jobs
+	step = d.startStep("jobs", "", "Installing scheduled jobs")
+	if err := d.installJobs(ctx, project, cfg); err != nil {
+		step.fail(err)
+		return fmt.Errorf("failed to install jobs: %w", err)
+	}
+	step.complete()
+
+	// Run project post-deploy hook
+	if err := d.runProjectHook(ctx, project, "post-deploy", postDeployHook(cfg.Hooks)); err != nil {
+		return err
+	}
+
+	// A canary is recorded once it is promoted.
+	if d.canary == nil {
+		d.recordDeployment(ctx, project, cfg)
+	}
+
 	return nil
 }
 
@@ -91,6 +205,7 @@ func (d *Deployment) runCommand(ctx context.Context, command string, args ...str
 	if err != nil {
 		return "", fmt.Errorf("failed to run command: %w", err)
 	}
+	defer output.Close()
 
 	outputBytes, readErr := io.ReadAll(output)
 	if readErr != nil {
@@ -101,10 +216,11 @@ func (d *Deployment) runCommand(ctx context.Context, command string, args ...str
 }
 
 func (d *Deployment) runLocalCommand(ctx context.Context, command string, args ...string) (string, error) {
-	output, err := d.runner.RunCommand(ctx, command, args...)
+	output, err := d.localRunner.RunCommand(ctx, command, args...)
 	if err != nil {
 		return "", fmt.Errorf("failed to run command: %w", err)
 	}
+	defer output.Close()
 
 	outputBytes, readErr := io.ReadAll(output)
 	if readErr != nil {
@@ -16,7 +16,6 @@ import (
 
 	"github.com/stretchr/testify/suite"
 	"github.com/yarlson/ftl/pkg/config"
-	"github.com/yarlson/ftl/pkg/console"
 	"github.com/yarlson/ftl/pkg/runner/remote"
 	"github.com/yarlson/ftl/pkg/ssh"
 	"github.com/yarlson/ftl/tests/dockercontainer"
@@ -28,7 +27,6 @@ type DeploymentTestSuite struct {
 	deployment *Deployment
 	network    string
 	tc         *dockercontainer.Container
-	sm         *console.SpinnerManager
 }
 
 func TestDeploymentSuite(t *testing.T) {
@@ -47,11 +45,10 @@ func (suite *DeploymentTestSuite) SetupTest() {
 	sshClient, err := ssh.NewSSHClientWithPassword("127.0.0.1", tc.SshPort.Port(), "root", "testpassword")
 	suite.Require().NoError(err)
 
-	suite.T().Log("Creating runner and spinner manager...")
+	suite.T().Log("Creating runner...")
 	runner := remote.NewRunner(sshClient)
 	suite.runner = runner
-	suite.sm = console.NewSpinnerManager()
-	suite.deployment = NewDeployment(runner, nil, suite.sm)
+	suite.deployment = NewDeployment(runner, nil)
 }
 
 func (suite *DeploymentTestSuite) TearDownTest() {
@@ -159,7 +156,7 @@ func (suite *DeploymentTestSuite) TestDeploy() {
 		defer cancel()
 
 		// Initial deployment
-		err := suite.deployment.Deploy(ctx, project, cfg)
+		err := Wait(suite.deployment.Deploy(ctx, project, cfg))
 		suite.Require().NoError(err, "Initial deployment should succeed")
 
 		time.Sleep(5 * time.Second)
@@ -206,7 +203,7 @@ func (suite *DeploymentTestSuite) TestDeploy() {
 		cfg.Services[0].Image = "nginx:1.20"
 		suite.T().Logf("Updating service image to nginx:1.20")
 
-		err = suite.deployment.Deploy(ctx, project, cfg)
+		err = Wait(suite.deployment.Deploy(ctx, project, cfg))
 		suite.Require().NoError(err, "Service update should succeed")
 
 		time.Sleep(2 * time.Second)
@@ -241,6 +238,64 @@ func (suite *DeploymentTestSuite) TestDeploy() {
 	})
 }
 
+func (suite *DeploymentTestSuite) TestDockerAPIOperations() {
+	docker, err := ConnectDockerAPI(context.Background(), suite.runner.DialDocker)
+	suite.Require().NoError(err)
+	defer docker.Close()
+
+	cli := NewDeployment(suite.runner, nil)
+	api := NewDeployment(suite.runner, nil)
+	api.UseDockerAPI(docker)
+
+	network := "ftl-api-test"
+	defer func() { _, _ = suite.runner.RunCommand(context.Background(), "docker", "network", "rm", network) }()
+	suite.Require().NoError(api.createNetwork(context.Background(), network, nil))
+	exists, err := cli.networkExists(context.Background(), network)
+	suite.Require().NoError(err)
+	suite.True(exists)
+
+	defer suite.removeVolume("api-test-data")
+	suite.Require().NoError(api.createVolume(context.Background(), "api-test", "data"))
+	suite.Require().NoError(api.createVolume(context.Background(), "api-test", "data"))
+
+	_, err = api.getImageHash(context.Background(), "ftl-missing-image:1")
+	var notFound *ImageNotFoundError
+	suite.ErrorAs(err, &notFound)
+	_, err = cli.getImageHash(context.Background(), "ftl-missing-image:1")
+	suite.ErrorAs(err, &notFound)
+
+	_, err = cli.pullImage(context.Background(), "nginx:1.19")
+	suite.Require().NoError(err)
+	cliHash, err := cli.getImageHash(context.Background(), "nginx:1.19")
+	suite.Require().NoError(err)
+	apiHash, err := api.getImageHash(context.Background(), "nginx:1.19")
+	suite.Require().NoError(err)
+	suite.Equal(cliHash, apiHash)
+
+	service := &config.Service{Name: "api-web", Image: "nginx:1.19", Volumes: []string{"data:/data"}, LocalPorts: []int{18080}}
+	defer suite.removeVolume(network + "-data")
+	defer suite.removeContainer(containerName(network, service.Name, ""))
+	suite.Require().NoError(api.createContainer(context.Background(), network, service, ""))
+
+	cliInfo, err := cli.getContainerInfo(context.Background(), network, "api-web")
+	suite.Require().NoError(err)
+	apiInfo, err := api.getContainerInfo(context.Background(), network, "api-web")
+	suite.Require().NoError(err)
+	suite.Equal(cliInfo, apiInfo)
+	suite.Equal("running", apiInfo.State.Status)
+	suite.Equal([]string{network + "-data:/data"}, apiInfo.HostConfig.Binds)
+
+	// The container created by the API is up to date for the CLI.
+	update, err := cli.containerShouldBeUpdated(context.Background(), network, service)
+	suite.Require().NoError(err)
+	suite.False(update)
+
+	suite.Require().NoError(api.removeContainer(containerName(network, service.Name, "")))
+	status, err := cli.getContainerStatus(context.Background(), network, service.Name)
+	suite.Require().NoError(err)
+	suite.Equal(ContainerStatusNotFound, status)
+}
+
 // Helper function to clean up deployment artifacts
 func (suite *DeploymentTestSuite) cleanupDeployment() {
 	containers := []string{"proxy", "web", "postgres", "mysql", "mongodb", "redis", "rabbitmq", "elasticsearch", "certrenewer"}
@@ -0,0 +1,346 @@
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+// DockerAPI is the part of the Docker Engine API client used by a deployment.
+type DockerAPI interface {
+	ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error)
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error)
+	ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error
+	ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.WaitResponse, <-chan error)
+	ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error
+	ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error
+	ContainerRename(ctx context.Context, containerID, newContainerName string) error
+	ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error)
+	NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error)
+	NetworkCreate(ctx context.Context, name string, options network.CreateOptions) (network.CreateResponse, error)
+	NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error)
+	NetworkConnect(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error
+	NetworkDisconnect(ctx context.Context, networkID, containerID string, force bool) error
+	VolumeInspect(ctx context.Context, volumeID string) (volume.Volume, error)
+	VolumeCreate(ctx context.Context, options volume.CreateOptions) (volume.Volume, error)
+}
+
+// ConnectDockerAPI returns a Docker Engine API client whose connections are opened by dial,
+// typically to the daemon socket through SSH, and checks that the daemon answers.
+func ConnectDockerAPI(ctx context.Context, dial func(ctx context.Context) (net.Conn, error)) (*client.Client, error) {
+	cli, err := client.NewClientWithOpts(
+		client.WithHost("http://docker"),
+		client.WithDialContext(func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dial(ctx)
+		}),
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker API client: %w", err)
+	}
+
+	if _, err := cli.Ping(ctx); err != nil {
+		cli.Close()
+		return nil, fmt.Errorf("failed to reach Docker API: %w", err)
+	}
+
+	return cli, nil
+}
+
+// UseDockerAPI makes the deployment inspect containers and images, create, start, replace and
+// remove service containers and manage networks and volumes through the Docker Engine API
+// instead of the docker CLI. Images are still pulled, and hooks, health checks and the proxy
+// commands still run, with the CLI.
+func (d *Deployment) UseDockerAPI(api DockerAPI) {
+	d.docker = api
+}
+
+func (d *Deployment) apiContainerInfo(ctx context.Context, networkName, service string) (*containerInfo, error) {
+	containers, err := d.docker.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("network", networkName)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container IDs: %w", err)
+	}
+
+	for _, c := range containers {
+		inspect, err := d.docker.ContainerInspect(ctx, c.ID)
+		if err != nil {
+			continue
+		}
+
+		if inspect.NetworkSettings == nil {
+			continue
+		}
+		endpoint, ok := inspect.NetworkSettings.Networks[networkName]
+		if !ok || endpoint == nil {
+			continue
+		}
+		for _, alias := range endpoint.Aliases {
+			if alias != service {
+				continue
+			}
+
+			// containerInfo mirrors the fields of the inspect JSON that deployments use.
+			data, err := json.Marshal(inspect)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode container info: %w", err)
+			}
+			var info containerInfo
+			if err := json.Unmarshal(data, &info); err != nil {
+				return nil, fmt.Errorf("failed to decode container info: %w", err)
+			}
+			return &info, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no container found with alias %s in network %s", service, networkName)
+}
+
+func (d *Deployment) apiImageHash(ctx context.Context, image string) (string, error) {
+	inspect, _, err := d.docker.ImageInspectWithRaw(ctx, image)
+	if client.IsErrNotFound(err) {
+		return "", &ImageNotFoundError{Image: image}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return inspect.ID, nil
+}
+
+func (d *Deployment) apiNetworkExists(ctx context.Context, name string) (bool, error) {
+	networks, err := d.docker.NetworkList(ctx, network.ListOptions{Filters: filters.NewArgs(filters.Arg("name", name))})
+	if err != nil {
+		return false, fmt.Errorf("failed to list Docker networks: %w", err)
+	}
+
+	// The name filter matches substrings.
+	for _, n := range networks {
+		if n.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (d *Deployment) apiCreateVolume(ctx context.Context, name string) error {
+	if _, err := d.docker.VolumeInspect(ctx, name); err == nil {
+		return nil
+	} else if !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to inspect volume: %w", err)
+	}
+
+	err := d.retry(ctx, "creating volume "+name, func() error {
+		_, err := d.docker.VolumeCreate(ctx, volume.CreateOptions{Name: name})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create volume: %w", err)
+	}
+	return nil
+}
+
+// apiCreateContainer creates and starts the container of service as docker run does with
+// containerArgs, and waits for a run-once container to exit.
+func (d *Deployment) apiCreateContainer(ctx context.Context, project string, service *config.Service, suffix string) error {
+	name := containerName(project, service.Name, suffix)
+	containerConfig, hostConfig, err := containerConfigs(project, service)
+	if err != nil {
+		return err
+	}
+
+	networkingConfig := &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{
+		project: {Aliases: networkAliases(service, suffix)},
+	}}
+	if _, err := d.docker.ContainerCreate(ctx, containerConfig, hostConfig, networkingConfig, nil, name); err != nil {
+		return fmt.Errorf("failed to create container %s: %w", name, err)
+	}
+
+	// Unlike with docker run, the other networks are connected before the container starts.
+	for _, network := range service.Networks {
+		if err := d.connectNetwork(ctx, network, name, networkAliases(service, suffix)); err != nil {
+			return err
+		}
+	}
+
+	if !hostConfig.AutoRemove {
+		return d.startContainer(ctx, name)
+	}
+
+	// The wait starts first, since a run-once container is removed as soon as it exits.
+	results, errs := d.docker.ContainerWait(ctx, name, container.WaitConditionNextExit)
+	if err := d.startContainer(ctx, name); err != nil {
+		return err
+	}
+	select {
+	case result := <-results:
+		if result.Error != nil {
+			return fmt.Errorf("failed to wait for container %s: %s", name, result.Error.Message)
+		}
+		if result.StatusCode != 0 {
+			return fmt.Errorf("container %s exited with status %d", name, result.StatusCode)
+		}
+		return nil
+	case err := <-errs:
+		return fmt.Errorf("failed to wait for container %s: %w", name, err)
+	}
+}
+
+// containerConfigs returns the container and host configuration that docker run makes of
+// containerArgs. The networks are left to the caller.
+func containerConfigs(project string, service *config.Service) (*container.Config, *container.HostConfig, error) {
+	hash, err := service.Hash()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate config hash: %w", err)
+	}
+	labels := map[string]string{"ftl.config-hash": hash}
+	for key, value := range service.Labels {
+		labels[key] = value
+	}
+	if service.ImageDigest != "" {
+		labels["ftl.image-digest"] = service.ImageDigest
+	}
+
+	image := service.Image
+	if image == "" {
+		image = fmt.Sprintf("%s-%s", project, service.Name)
+	}
+
+	containerConfig := &container.Config{
+		Image:  image,
+		Env:    service.Env,
+		Labels: labels,
+		User:   service.SecurityOptions.User,
+	}
+	if len(service.Entrypoint) > 0 {
+		// --entrypoint takes the joined entrypoint as a single argument.
+		containerConfig.Entrypoint = []string{strings.Join(service.Entrypoint, " ")}
+	}
+	if service.Command != "" {
+		containerConfig.Cmd = append(containerConfig.Cmd, service.Command)
+	}
+	containerConfig.Cmd = append(containerConfig.Cmd, service.CommandSlice...)
+
+	hostConfig := &container.HostConfig{
+		ExtraHosts:     service.ExtraHosts,
+		DNS:            service.DNS,
+		ReadonlyRootfs: service.SecurityOptions.ReadOnly,
+		CapAdd:         service.SecurityOptions.CapAdd,
+		CapDrop:        service.SecurityOptions.CapDrop,
+		SecurityOpt:    service.SecurityOptions.SecurityOpt,
+	}
+	if service.Container != nil && service.Container.RunOnce {
+		hostConfig.AutoRemove = true
+	} else {
+		policy, retries, _ := strings.Cut(restartPolicy(service), ":")
+		hostConfig.RestartPolicy.Name = container.RestartPolicyMode(policy)
+		if retries != "" {
+			count, err := strconv.Atoi(retries)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid restart policy %q", restartPolicy(service))
+			}
+			hostConfig.RestartPolicy.MaximumRetryCount = count
+		}
+	}
+
+	for _, mount := range service.SecurityOptions.Tmpfs {
+		if hostConfig.Tmpfs == nil {
+			hostConfig.Tmpfs = make(map[string]string)
+		}
+		path, options, _ := strings.Cut(mount, ":")
+		hostConfig.Tmpfs[path] = options
+	}
+
+	if service.GPUs != "" {
+		hostConfig.DeviceRequests = []container.DeviceRequest{gpuRequest(service.GPUs)}
+	}
+
+	for _, volume := range service.Volumes {
+		mount := config.VolumeMount(project, volume)
+		if !strings.Contains(mount, ":") {
+			// A path alone is an anonymous volume.
+			if containerConfig.Volumes == nil {
+				containerConfig.Volumes = make(map[string]struct{})
+			}
+			containerConfig.Volumes[mount] = struct{}{}
+			continue
+		}
+		hostConfig.Binds = append(hostConfig.Binds, mount)
+	}
+
+	if service.HealthCheck != nil && service.HealthCheck.CheckType() != config.HealthCheckExternal {
+		// docker run is given whole seconds.
+		seconds := func(duration config.Duration) time.Duration {
+			return duration.Duration().Truncate(time.Second)
+		}
+		containerConfig.Healthcheck = &container.HealthConfig{
+			Test:     []string{"CMD-SHELL", healthCommand(service)},
+			Interval: seconds(service.HealthCheck.Interval),
+			Retries:  service.HealthCheck.Retries,
+			Timeout:  seconds(service.HealthCheck.Timeout),
+		}
+	}
+	if service.Container != nil && service.Container.HealthCheck != nil {
+		healthCheck := service.Container.HealthCheck
+		containerConfig.Healthcheck = &container.HealthConfig{
+			Test:        []string{"CMD-SHELL", healthCheck.Cmd},
+			Retries:     healthCheck.Retries,
+			Interval:    healthCheck.Interval.Duration(),
+			Timeout:     healthCheck.Timeout.Duration(),
+			StartPeriod: healthCheck.StartPeriod.Duration(),
+		}
+	}
+
+	if service.Container != nil {
+		for _, ulimit := range service.Container.ULimits {
+			hostConfig.Ulimits = append(hostConfig.Ulimits, &container.Ulimit{
+				Name: ulimit.Name,
+				Soft: int64(ulimit.Soft),
+				Hard: int64(ulimit.Hard),
+			})
+		}
+	}
+
+	exposed, bindings, err := nat.ParsePortSpecs(portSpecs(service))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid port of service %s: %w", service.Name, err)
+	}
+	if len(exposed) > 0 {
+		containerConfig.ExposedPorts = exposed
+		hostConfig.PortBindings = bindings
+	}
+
+	return containerConfig, hostConfig, nil
+}
+
+// gpuRequest returns the device request docker run --gpus makes of gpus, a value accepted by
+// config.ValidGPUs.
+func gpuRequest(gpus string) container.DeviceRequest {
+	request := container.DeviceRequest{Capabilities: [][]string{{"gpu"}}}
+	if gpus == "all" {
+		request.Count = -1
+	} else if count, err := strconv.Atoi(gpus); err == nil {
+		request.Count = count
+	} else {
+		request.DeviceIDs = strings.Split(strings.TrimPrefix(gpus, "device="), ",")
+	}
+	return request
+}
@@ -0,0 +1,314 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+// fakeDockerAPI keeps containers, images, networks and volumes in memory.
+type fakeDockerAPI struct {
+	containers map[string]types.ContainerJSON
+	images     map[string]string
+	networks   []string
+	subnets    map[string]string
+	volumes    []string
+	created    map[string]*network.NetworkingConfig
+	calls      []string
+	exitCode   int64
+}
+
+func (f *fakeDockerAPI) ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error) {
+	var list []types.Container
+	for id := range f.containers {
+		list = append(list, types.Container{ID: id})
+	}
+	return list, nil
+}
+
+func (f *fakeDockerAPI) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	c, ok := f.containers[id]
+	if !ok {
+		return types.ContainerJSON{}, errdefs.NotFound(errors.New("no such container"))
+	}
+	return c, nil
+}
+
+func (f *fakeDockerAPI) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, name string) (container.CreateResponse, error) {
+	if f.created == nil {
+		f.created = make(map[string]*network.NetworkingConfig)
+	}
+	f.created[name] = networkingConfig
+	f.calls = append(f.calls, "create "+name)
+	return container.CreateResponse{ID: name}, nil
+}
+
+func (f *fakeDockerAPI) ContainerStart(ctx context.Context, id string, options container.StartOptions) error {
+	f.calls = append(f.calls, "start "+id)
+	return nil
+}
+
+func (f *fakeDockerAPI) ContainerWait(ctx context.Context, id string, condition container.WaitCondition) (<-chan container.WaitResponse, <-chan error) {
+	f.calls = append(f.calls, "wait "+id)
+	results := make(chan container.WaitResponse, 1)
+	results <- container.WaitResponse{StatusCode: f.exitCode}
+	return results, make(chan error)
+}
+
+func (f *fakeDockerAPI) ContainerStop(ctx context.Context, id string, options container.StopOptions) error {
+	f.calls = append(f.calls, "stop "+id)
+	return nil
+}
+
+func (f *fakeDockerAPI) ContainerRemove(ctx context.Context, id string, options container.RemoveOptions) error {
+	if options.Force {
+		f.calls = append(f.calls, "rm -f "+id)
+	} else {
+		f.calls = append(f.calls, "rm "+id)
+	}
+	return nil
+}
+
+func (f *fakeDockerAPI) ContainerRename(ctx context.Context, id, name string) error {
+	f.calls = append(f.calls, "rename "+id+" "+name)
+	return nil
+}
+
+func (f *fakeDockerAPI) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	id, ok := f.images[image]
+	if !ok {
+		return types.ImageInspect{}, nil, errdefs.NotFound(errors.New("no such image"))
+	}
+	return types.ImageInspect{ID: id}, nil, nil
+}
+
+func (f *fakeDockerAPI) NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+	var list []network.Summary
+	for _, name := range f.networks {
+		list = append(list, network.Summary{Name: name})
+	}
+	return list, nil
+}
+
+func (f *fakeDockerAPI) NetworkCreate(ctx context.Context, name string, options network.CreateOptions) (network.CreateResponse, error) {
+	f.networks = append(f.networks, name)
+	return network.CreateResponse{ID: name}, nil
+}
+
+func (f *fakeDockerAPI) NetworkInspect(ctx context.Context, name string, options network.InspectOptions) (network.Inspect, error) {
+	for _, n := range f.networks {
+		if n == name {
+			return network.Inspect{Name: name, IPAM: network.IPAM{Config: []network.IPAMConfig{{Subnet: f.subnets[name]}}}}, nil
+		}
+	}
+	return network.Inspect{}, errdefs.NotFound(errors.New("no such network"))
+}
+
+func (f *fakeDockerAPI) NetworkConnect(ctx context.Context, name, id string, settings *network.EndpointSettings) error {
+	f.calls = append(f.calls, fmt.Sprintf("connect %s %s %v", name, id, settings.Aliases))
+	return nil
+}
+
+func (f *fakeDockerAPI) NetworkDisconnect(ctx context.Context, name, id string, force bool) error {
+	f.calls = append(f.calls, "disconnect "+name+" "+id)
+	return nil
+}
+
+func (f *fakeDockerAPI) VolumeInspect(ctx context.Context, name string) (volume.Volume, error) {
+	for _, v := range f.volumes {
+		if v == name {
+			return volume.Volume{Name: name}, nil
+		}
+	}
+	return volume.Volume{}, errdefs.NotFound(errors.New("no such volume"))
+}
+
+func (f *fakeDockerAPI) VolumeCreate(ctx context.Context, options volume.CreateOptions) (volume.Volume, error) {
+	f.volumes = append(f.volumes, options.Name)
+	return volume.Volume{Name: options.Name}, nil
+}
+
+func TestDockerAPI(t *testing.T) {
+	api := &fakeDockerAPI{
+		containers: map[string]types.ContainerJSON{
+			"c1": {
+				ContainerJSONBase: &types.ContainerJSONBase{
+					ID:         "c1",
+					Image:      "sha256:web",
+					State:      &types.ContainerState{Status: "exited"},
+					HostConfig: &container.HostConfig{Binds: []string{"shop-uploads:/app/uploads"}},
+				},
+				Config: &container.Config{Labels: map[string]string{"ftl.config-hash": "abc"}},
+				NetworkSettings: &types.NetworkSettings{Networks: map[string]*network.EndpointSettings{
+					"shop": {Aliases: []string{"web"}},
+				}},
+			},
+		},
+		images:   map[string]string{"shop/web:1": "sha256:web"},
+		networks: []string{"shop-old"},
+	}
+	// Every operation goes through the API; the runner must not be used.
+	runner := &fakeRunner{}
+	d := NewDeployment(runner, nil)
+	d.UseDockerAPI(api)
+
+	info, err := d.getContainerInfo(context.Background(), "shop", "web")
+	require.NoError(t, err)
+	assert.Equal(t, "c1", info.ID)
+	assert.Equal(t, "sha256:web", info.Image)
+	assert.Equal(t, "abc", info.Config.Labels["ftl.config-hash"])
+	assert.Equal(t, []string{"shop-uploads:/app/uploads"}, info.HostConfig.Binds)
+
+	status, err := d.getContainerStatus(context.Background(), "shop", "web")
+	require.NoError(t, err)
+	assert.Equal(t, ContainerStatusStopped, status)
+
+	status, err = d.getContainerStatus(context.Background(), "shop", "worker")
+	require.NoError(t, err)
+	assert.Equal(t, ContainerStatusNotFound, status)
+
+	hash, err := d.getImageHash(context.Background(), "shop/web:1")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:web", hash)
+
+	_, err = d.getImageHash(context.Background(), "shop/web:2")
+	var notFound *ImageNotFoundError
+	require.ErrorAs(t, err, &notFound)
+	assert.Equal(t, "shop/web:2", notFound.Image)
+
+	require.NoError(t, d.createNetwork(context.Background(), "shop", nil))
+	require.NoError(t, d.createNetwork(context.Background(), "shop", nil))
+	assert.Equal(t, []string{"shop-old", "shop"}, api.networks)
+
+	require.NoError(t, d.createVolume(context.Background(), "shop", "uploads"))
+	require.NoError(t, d.createVolume(context.Background(), "shop", "uploads"))
+	assert.Equal(t, []string{"shop-uploads"}, api.volumes)
+
+	require.NoError(t, d.startContainer(context.Background(), "shop-web"))
+	assert.Equal(t, []string{"start shop-web"}, api.calls)
+
+	// A blue-green update creates the new container, switches the traffic and replaces the old one.
+	api.calls = nil
+	service := &config.Service{Name: "web", Image: "shop/web:1", Networks: []string{"monitoring"}}
+	require.NoError(t, d.createContainer(context.Background(), "shop", service, newContainerSuffix))
+	assert.Equal(t, []string{"web_new"}, api.created["shop-web_new"].EndpointsConfig["shop"].Aliases)
+	oldContID, err := d.switchTraffic(context.Background(), "shop", service)
+	require.NoError(t, err)
+	require.NoError(t, d.cleanup(context.Background(), "shop", oldContID, "web"))
+	require.NoError(t, d.removeContainer("shop-web_new"))
+	assert.Equal(t, []string{
+		"create shop-web_new",
+		"connect monitoring shop-web_new [web_new]",
+		"start shop-web_new",
+		"disconnect shop shop-web_new",
+		"connect shop shop-web_new [web]",
+		"disconnect monitoring shop-web_new",
+		"connect monitoring shop-web_new [web]",
+		"disconnect shop c1",
+		"disconnect monitoring c1",
+		"stop c1",
+		"rm c1",
+		"rename shop-web_new shop-web",
+		"rm -f shop-web_new",
+	}, api.calls)
+
+	// A run-once container is waited for, and fails when it exits with an error.
+	api.calls = nil
+	api.exitCode = 1
+	service = &config.Service{Name: "migrate", Image: "shop/web:1", Container: &config.Container{RunOnce: true}}
+	err = d.createContainer(context.Background(), "shop", service, "")
+	assert.EqualError(t, err, "container shop-migrate exited with status 1")
+	assert.Equal(t, []string{"create shop-migrate", "wait shop-migrate", "start shop-migrate"}, api.calls)
+
+	assert.Empty(t, runner.executed())
+}
+
+func TestContainerConfigs(t *testing.T) {
+	service := &config.Service{
+		Name:       "web",
+		Image:      "shop/web:1",
+		Env:        []string{"MODE=prod"},
+		Labels:     map[string]string{"team": "shop"},
+		ExtraHosts: []string{"db:10.0.0.5"},
+		DNS:        []string{"1.1.1.1"},
+		SecurityOptions: config.SecurityOptions{
+			User:     "1000",
+			ReadOnly: true,
+			CapDrop:  []string{"ALL"},
+			Tmpfs:    []string{"/tmp:size=64m", "/run"},
+		},
+		GPUs:       "device=0,1",
+		Volumes:    []string{"uploads:/app/uploads", "/srv/shop:/srv"},
+		LocalPorts: []int{9000},
+		Forwards:   []string{"2222:22"},
+		Restart:    "on-failure:3",
+		HealthCheck: &config.ServiceHealthCheck{
+			Type:     config.HealthCheckCmd,
+			Cmd:      "pg_isready",
+			Interval: config.Duration(5500 * time.Millisecond),
+			Timeout:  config.Duration(2 * time.Second),
+			Retries:  3,
+		},
+		Container:    &config.Container{ULimits: []config.ULimit{{Name: "nofile", Soft: 1024, Hard: 2048}}},
+		Entrypoint:   []string{"/bin/sh", "-c"},
+		CommandSlice: []string{"serve"},
+	}
+
+	containerConfig, hostConfig, err := containerConfigs("shop", service)
+	require.NoError(t, err)
+
+	hash, err := service.Hash()
+	require.NoError(t, err)
+	assert.Equal(t, "shop/web:1", containerConfig.Image)
+	assert.Equal(t, []string{"MODE=prod"}, containerConfig.Env)
+	assert.Equal(t, map[string]string{"team": "shop", "ftl.config-hash": hash}, containerConfig.Labels)
+	assert.Equal(t, "1000", containerConfig.User)
+	assert.Equal(t, []string{"/bin/sh -c"}, []string(containerConfig.Entrypoint))
+	assert.Equal(t, []string{"serve"}, []string(containerConfig.Cmd))
+	assert.Equal(t, &container.HealthConfig{
+		Test:     []string{"CMD-SHELL", "pg_isready"},
+		Interval: 5 * time.Second,
+		Timeout:  2 * time.Second,
+		Retries:  3,
+	}, containerConfig.Healthcheck)
+	assert.Equal(t, nat.PortSet{"9000/tcp": {}, "22/tcp": {}}, containerConfig.ExposedPorts)
+
+	assert.Equal(t, container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}, hostConfig.RestartPolicy)
+	assert.False(t, hostConfig.AutoRemove)
+	assert.Equal(t, []string{"db:10.0.0.5"}, hostConfig.ExtraHosts)
+	assert.Equal(t, []string{"1.1.1.1"}, hostConfig.DNS)
+	assert.True(t, hostConfig.ReadonlyRootfs)
+	assert.Equal(t, []string{"ALL"}, []string(hostConfig.CapDrop))
+	assert.Equal(t, map[string]string{"/tmp": "size=64m", "/run": ""}, hostConfig.Tmpfs)
+	assert.Equal(t, []container.DeviceRequest{{DeviceIDs: []string{"0", "1"}, Capabilities: [][]string{{"gpu"}}}}, hostConfig.DeviceRequests)
+	assert.Equal(t, []string{"shop-uploads:/app/uploads", "/srv/shop:/srv"}, hostConfig.Binds)
+	assert.Equal(t, []*container.Ulimit{{Name: "nofile", Soft: 1024, Hard: 2048}}, hostConfig.Ulimits)
+	assert.Equal(t, nat.PortMap{
+		"9000/tcp": {{HostIP: "127.0.0.1", HostPort: "9000"}},
+		"22/tcp":   {{HostPort: "2222"}},
+	}, hostConfig.PortBindings)
+
+	// Run-once containers are removed when they exit instead of being restarted.
+	service.Container.RunOnce = true
+	_, hostConfig, err = containerConfigs("shop", service)
+	require.NoError(t, err)
+	assert.True(t, hostConfig.AutoRemove)
+	assert.Empty(t, hostConfig.RestartPolicy.Name)
+
+	assert.Equal(t, container.DeviceRequest{Count: -1, Capabilities: [][]string{{"gpu"}}}, gpuRequest("all"))
+	assert.Equal(t, container.DeviceRequest{Count: 2, Capabilities: [][]string{{"gpu"}}}, gpuRequest("2"))
+}
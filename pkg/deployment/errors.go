@@ -0,0 +1,117 @@
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Phase is the part of a service deployment in which it failed.
+type Phase string
+
+const (
+	// PhasePull is pulling or syncing the image of the service.
+	PhasePull Phase = "pull"
+	// PhaseCreate is creating, starting or replacing the container.
+	PhaseCreate Phase = "create"
+	// PhaseHealth is waiting for the new container to become healthy.
+	PhaseHealth Phase = "health"
+	// PhaseHooks is running the pre- and post-hooks of the service.
+	PhaseHooks Phase = "hooks"
+	// PhaseTraffic is moving the service alias to the new container and removing the old one.
+	PhaseTraffic Phase = "traffic"
+	// PhaseDeploy is any other part of the deployment, such as inspecting the running container.
+	PhaseDeploy Phase = "deploy"
+)
+
+// phaseError tags an error with the phase of the service deployment it happened in.
+type phaseError struct {
+	phase Phase
+	err   error
+}
+
+func (e *phaseError) Error() string { return e.err.Error() }
+
+func (e *phaseError) Unwrap() error { return e.err }
+
+func inPhase(phase Phase, err error) error {
+	return &phaseError{phase: phase, err: err}
+}
+
+// ServiceError is the failure of the deployment of one service.
+type ServiceError struct {
+	Service string
+	Phase   Phase
+	Err     error
+}
+
+// newServiceError returns the failure of service, in the innermost phase err is tagged with.
+func newServiceError(service string, err error) *ServiceError {
+	serviceErr := &ServiceError{Service: service, Phase: PhaseDeploy, Err: err}
+	var tagged *phaseError
+	if errors.As(err, &tagged) {
+		serviceErr.Phase = tagged.phase
+	}
+	return serviceErr
+}
+
+func (e *ServiceError) Error() string {
+	return fmt.Sprintf("service %s failed (%s): %v", e.Service, e.Phase, e.Err)
+}
+
+func (e *ServiceError) Unwrap() error { return e.Err }
+
+// DeployError reports the outcome of every service of a deployment in which services failed
+// or were skipped because the deployment was cancelled before they started.
+type DeployError struct {
+	// Failed holds the failures ordered by service name.
+	Failed    []*ServiceError
+	Succeeded []string
+	Skipped   []string
+}
+
+func (e *DeployError) Error() string {
+	errs := e.Unwrap()
+	if len(e.Skipped) > 0 {
+		errs = append(errs, fmt.Errorf("skipped: %s", strings.Join(e.Skipped, ", ")))
+	}
+	if len(e.Succeeded) > 0 {
+		errs = append(errs, fmt.Errorf("succeeded: %s", strings.Join(e.Succeeded, ", ")))
+	}
+	return errors.Join(errs...).Error()
+}
+
+// Unwrap returns the failures of the services, for errors.Is and errors.As.
+func (e *DeployError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, failure := range e.Failed {
+		errs = append(errs, failure)
+	}
+	return errs
+}
+
+// HealthCheckError is returned when a container doesn't become healthy.
+type HealthCheckError struct {
+	Container string
+	// Logs holds the last lines the container logged, without terminal color codes.
+	Logs string
+	// MissingBinary is the program the health check command needs but the image lacks, such as curl.
+	MissingBinary string
+}
+
+func (e *HealthCheckError) Error() string {
+	if e.MissingBinary != "" {
+		return fmt.Sprintf("container %s failed to become healthy: the image has no %s to run the health check, use health_check type external", e.Container, e.MissingBinary)
+	}
+	return fmt.Sprintf("container %s failed to become healthy", e.Container)
+}
+
+// ImageNotFoundError is returned when an image is not on the server, for example because it
+// was removed there.
+type ImageNotFoundError struct {
+	Image string
+}
+
+func (e *ImageNotFoundError) Error() string {
+	return fmt.Sprintf("image %s not found on the server", e.Image)
+}
@@ -0,0 +1,196 @@
+package deployment
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType identifies what a deployment Event reports.
+type EventType string
+
+const (
+	// EventStarted reports that a step began.
+	EventStarted EventType = "started"
+	// EventCompleted reports that a step succeeded.
+	EventCompleted EventType = "completed"
+	// EventFailed reports that a step failed. Err holds the cause.
+	EventFailed EventType = "failed"
+	// EventWarning reports a problem that doesn't stop the deployment.
+	EventWarning EventType = "warning"
+	// EventFinished is the last event of a deployment. Err is set when it failed.
+	EventFinished EventType = "finished"
+)
+
+// eventBuffer is the number of events a deployment may run ahead of its consumer.
+const eventBuffer = 64
+
+// Event reports the progress of a deployment.
+type Event struct {
+	Type EventType
+	// Step identifies the step an event belongs to; the events of one step share it.
+	Step string
+	// Service is the service or dependency the step deploys, if any.
+	Service string
+	Message string
+	Err     error
+	// Changed is set on the completion of a service or dependency step that created or
+	// replaced its container.
+	Changed bool
+	// Phases holds how long the phases of a service or dependency step took, set on its
+	// completion or failure. Phases the step didn't go through are left out.
+	Phases map[Phase]time.Duration
+	// Started is when the step began, set on every event of a step.
+	Started time.Time
+	Time    time.Time
+}
+
+// step reports the progress of one deployment step as events.
+type step struct {
+	d       *Deployment
+	name    string
+	service string
+	message string
+	started time.Time
+	// changed is reported with the completion of the step.
+	changed bool
+	// phases is reported with the completion or failure of the step.
+	phases map[Phase]time.Duration
+}
+
+// startStep emits the start of a step named name and returns it for reporting its outcome.
+func (d *Deployment) startStep(name, service, format string, args ...interface{}) *step {
+	s := &step{d: d, name: name, service: service, message: fmt.Sprintf(format, args...), started: d.clock()}
+	s.emit(EventStarted, s.message, nil)
+	return s
+}
+
+// complete emits the success of the step with its start message.
+func (s *step) complete() {
+	s.emit(EventCompleted, s.message, nil)
+}
+
+// completef emits the success of the step with a new message.
+func (s *step) completef(format string, args ...interface{}) {
+	s.emit(EventCompleted, fmt.Sprintf(format, args...), nil)
+}
+
+// fail emits the failure of the step with its start message.
+func (s *step) fail(err error) {
+	s.emit(EventFailed, s.message, err)
+}
+
+// failf emits the failure of the step with a new message.
+func (s *step) failf(err error, format string, args ...interface{}) {
+	s.emit(EventFailed, fmt.Sprintf(format, args...), err)
+}
+
+func (s *step) emit(eventType EventType, message string, err error) {
+	event := Event{Type: eventType, Step: s.name, Service: s.service, Message: message, Err: err, Started: s.started}
+	if eventType == EventCompleted || eventType == EventFailed {
+		event.Changed = s.changed && eventType == EventCompleted
+		event.Phases = s.phases
+	}
+	s.d.emit(event)
+}
+
+// timePhase starts timing a phase of the deployment of service and returns the function that
+// stops it. The time of a phase run more than once, like the hooks, adds up.
+func (d *Deployment) timePhase(service string, phase Phase) func() {
+	started := d.clock()
+	return func() {
+		elapsed := d.clock().Sub(started)
+
+		d.phasesMu.Lock()
+		defer d.phasesMu.Unlock()
+		if d.phases == nil {
+			d.phases = make(map[string]map[Phase]time.Duration)
+		}
+		if d.phases[service] == nil {
+			d.phases[service] = make(map[Phase]time.Duration)
+		}
+		d.phases[service][phase] += elapsed
+	}
+}
+
+// phaseDurations returns the phases timed for service since the last call and forgets them.
+func (d *Deployment) phaseDurations(service string) map[Phase]time.Duration {
+	d.phasesMu.Lock()
+	defer d.phasesMu.Unlock()
+	phases := d.phases[service]
+	delete(d.phases, service)
+	return phases
+}
+
+// warn emits a warning that isn't tied to a step.
+func (d *Deployment) warn(name, service string, err error, format string, args ...interface{}) {
+	now := d.clock()
+	d.emit(Event{Type: EventWarning, Step: name, Service: service, Message: fmt.Sprintf(format, args...), Err: err, Started: now, Time: now})
+}
+
+// emit sends event to the consumer of the running deployment. Events outside of Deploy,
+// such as those of ForceUnlock, are dropped.
+func (d *Deployment) emit(event Event) {
+	if d.events == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = d.clock()
+	}
+	d.events <- event
+}
+
+// Wait drains the events of a deployment and returns its error.
+func Wait(events <-chan Event) error {
+	var err error
+	for event := range events {
+		if event.Type == EventFinished {
+			err = event.Err
+		}
+	}
+	return err
+}
+
+// Reporter receives the events of steps run outside of a Deployment, like connecting to the
+// server or setting it up, so they can be shown the same way as those of a deployment.
+type Reporter func(Event)
+
+// LocalStep reports the progress of a step run outside of a Deployment.
+type LocalStep struct {
+	report  Reporter
+	name    string
+	message string
+	started time.Time
+}
+
+// StartLocalStep reports the start of a step named name to report and returns it for reporting
+// its outcome.
+func StartLocalStep(report Reporter, name, message string) *LocalStep {
+	s := &LocalStep{report: report, name: name, message: message, started: time.Now()}
+	s.emit(EventStarted, message, nil)
+	return s
+}
+
+// Complete reports the success of the step with its start message.
+func (s *LocalStep) Complete() {
+	s.emit(EventCompleted, s.message, nil)
+}
+
+// CompleteWithMessage reports the success of the step with a new message.
+func (s *LocalStep) CompleteWithMessage(message string) {
+	s.emit(EventCompleted, message, nil)
+}
+
+// Fail reports the failure of the step with message.
+func (s *LocalStep) Fail(message string, err error) {
+	s.emit(EventFailed, message, err)
+}
+
+func (s *LocalStep) emit(eventType EventType, message string, err error) {
+	s.report(Event{Type: eventType, Step: s.name, Message: message, Err: err, Started: s.started, Time: time.Now()})
+}
+
+// Warn reports a problem of a step run outside of a Deployment that doesn't stop it.
+func Warn(report Reporter, name, message string, err error) {
+	now := time.Now()
+	report(Event{Type: EventWarning, Step: name, Message: message, Err: err, Started: now, Time: now})
+}
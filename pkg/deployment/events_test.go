@@ -0,0 +1,104 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestStepEvents(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+	d := NewDeployment(&fakeRunner{}, nil)
+	d.clock = clock.Now
+	d.events = make(chan Event, eventBuffer)
+
+	started := clock.Now()
+	step := d.startStep("service/web", "web", "Deploying service %s", "web")
+	clock.Advance(3 * time.Second)
+	step.changed = true
+	step.complete()
+
+	failure := errors.New("container is unhealthy")
+	step = d.startStep("service/api", "api", "Deploying service %s", "api")
+	step.changed = true
+	step.failf(failure, "Failed to deploy service %s", "api")
+	close(d.events)
+
+	var events []Event
+	for event := range d.events {
+		events = append(events, event)
+	}
+
+	require.Len(t, events, 4)
+	assert.Equal(t, Event{Type: EventStarted, Step: "service/web", Service: "web", Message: "Deploying service web", Started: started, Time: started}, events[0])
+	assert.Equal(t, Event{Type: EventCompleted, Step: "service/web", Service: "web", Message: "Deploying service web", Changed: true, Started: started, Time: started.Add(3 * time.Second)}, events[1])
+	assert.Equal(t, EventFailed, events[3].Type)
+	assert.Equal(t, "Failed to deploy service api", events[3].Message)
+	assert.Equal(t, failure, events[3].Err)
+	assert.False(t, events[3].Changed, "only completions report a changed container")
+}
+
+func TestPhaseDurations(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+	d := NewDeployment(&fakeRunner{}, nil)
+	d.clock = clock.Now
+	d.events = make(chan Event, eventBuffer)
+
+	done := d.timePhase("web", PhasePull)
+	clock.Advance(4 * time.Second)
+	done()
+	for i := 0; i < 2; i++ {
+		done = d.timePhase("web", PhaseHooks)
+		clock.Advance(time.Second)
+		done()
+	}
+
+	step := d.startStep("service/web", "web", "Deploying service %s", "web")
+	step.phases = d.phaseDurations("web")
+	step.complete()
+	close(d.events)
+
+	var events []Event
+	for event := range d.events {
+		events = append(events, event)
+	}
+	require.Len(t, events, 2)
+	assert.Nil(t, events[0].Phases)
+	assert.Equal(t, map[Phase]time.Duration{PhasePull: 4 * time.Second, PhaseHooks: 2 * time.Second}, events[1].Phases)
+	assert.Nil(t, d.phaseDurations("web"), "reported phases are forgotten")
+}
+
+func TestStepEventsWithoutConsumer(t *testing.T) {
+	d := NewDeployment(&fakeRunner{}, nil)
+
+	assert.NotPanics(t, func() {
+		d.startStep("network", "", "Creating network").complete()
+	})
+}
+
+func TestDeployEvents(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		return "", errors.New("connection lost")
+	}}
+	d := NewDeployment(runner, nil)
+
+	events := d.Deploy(context.Background(), "shop", &config.Config{})
+
+	var last Event
+	for event := range events {
+		last = event
+	}
+	assert.Equal(t, EventFinished, last.Type)
+	require.Error(t, last.Err)
+	assert.Contains(t, last.Err.Error(), "connection lost")
+
+	err := Wait(NewDeployment(runner, nil).Deploy(context.Background(), "shop", &config.Config{}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to acquire deployment lock")
+}
@@ -0,0 +1,188 @@
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/runner/remote"
+)
+
+// fakeRunner records every command and answers it with the configured handler.
+type fakeRunner struct {
+	mu       sync.Mutex
+	commands [][]string
+	inputs   []string
+	copied   []string
+	modes    map[string]os.FileMode
+	handler  func(command string, args []string) (string, error)
+}
+
+func (r *fakeRunner) RunCommand(ctx context.Context, command string, args ...string) (io.ReadCloser, error) {
+	return r.RunCommandWithInput(ctx, nil, command, args...)
+}
+
+func (r *fakeRunner) RunCommandWithInput(ctx context.Context, stdin io.Reader, command string, args ...string) (io.ReadCloser, error) {
+	var input string
+	if stdin != nil {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, err
+		}
+		input = string(data)
+	}
+
+	r.mu.Lock()
+	r.commands = append(r.commands, append([]string{command}, args...))
+	r.inputs = append(r.inputs, input)
+	handler := r.handler
+	r.mu.Unlock()
+
+	// Like the SSH runner, a cancelled command doesn't start.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if handler == nil {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	output, err := handler(command, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(strings.NewReader(output)), nil
+}
+
+func (r *fakeRunner) CopyFile(ctx context.Context, from, to string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.copied = append(r.copied, to)
+	return nil
+}
+
+func (r *fakeRunner) CopyDir(ctx context.Context, localDir, remoteDir string, opts remote.CopyDirOptions) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.copied = append(r.copied, remoteDir)
+	return nil
+}
+
+func (r *fakeRunner) CopyReader(ctx context.Context, src io.Reader, dst string, opts remote.CopyOptions) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.copied = append(r.copied, dst)
+	if r.modes == nil {
+		r.modes = make(map[string]os.FileMode)
+	}
+	r.modes[dst] = opts.Mode
+	return nil
+}
+
+func (r *fakeRunner) Host() string {
+	return "fake-host"
+}
+
+// executed returns the commands run so far, joined with spaces.
+func (r *fakeRunner) executed() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var commands []string
+	for _, cmd := range r.commands {
+		commands = append(commands, strings.Join(cmd, " "))
+	}
+	return commands
+}
+
+// fakeFileServer emulates the files the deployment reads, writes, lists and removes on the
+// server with shell commands. The home directory of the SSH user is /home/test.
+type fakeFileServer struct {
+	mu    sync.Mutex
+	files map[string]string
+	// failMove makes writes fail like a rename into a read-only directory.
+	failMove bool
+}
+
+func newFakeFileServer() *fakeFileServer {
+	return &fakeFileServer{files: map[string]string{}}
+}
+
+func (s *fakeFileServer) handle(command string, args []string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case command == "sh" && args[1] == "echo $HOME":
+		return "/home/test", nil
+	case command == "sh" && args[1] == readJSONScript:
+		return s.files[args[3]], nil
+	case command == "sh" && args[1] == writeJSONScript:
+		if s.failMove {
+			return "mv: cannot move: Permission denied", nil
+		}
+		s.files[args[6]] = args[4]
+		return "written", nil
+	case command == "sh" && strings.Contains(args[1], "basename"):
+		var names []string
+		for file := range s.files {
+			if path.Dir(file) == args[3] {
+				names = append(names, path.Base(file))
+			}
+		}
+		return strings.Join(names, "\n"), nil
+	case command == "rm":
+		for _, file := range args {
+			delete(s.files, file)
+		}
+	}
+	return "", nil
+}
+
+// readJSON decodes the file at path into v, reporting false when it doesn't exist.
+func (s *fakeFileServer) readJSON(t *testing.T, path string, v any) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.files[path]
+	if !ok {
+		return false
+	}
+	require.NoError(t, json.Unmarshal([]byte(data), v))
+	return true
+}
+
+func (s *fakeFileServer) writeJSON(t *testing.T, path string, v any) {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path] = string(data)
+}
+
+// fakeClock is a manually advanced clock for time dependent logic.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,34 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+// checkGPUSupport fails if a service needs GPUs and docker on the server lacks the nvidia
+// runtime, which docker run --gpus relies on.
+func (d *Deployment) checkGPUSupport(ctx context.Context, services []config.Service) error {
+	var gpuServices []string
+	for _, service := range services {
+		if service.GPUs != "" {
+			gpuServices = append(gpuServices, service.Name)
+		}
+	}
+	if len(gpuServices) == 0 {
+		return nil
+	}
+
+	runtimes, err := d.runCommand(ctx, "docker", "info", "--format", "{{json .Runtimes}}")
+	if err != nil {
+		return fmt.Errorf("failed to check GPU support: %w", err)
+	}
+	if !strings.Contains(runtimes, `"nvidia"`) {
+		return fmt.Errorf("services %s need GPUs, but docker on the server has no nvidia runtime; install the NVIDIA driver, set server.gpu: true and run ftl setup --step gpu",
+			strings.Join(gpuServices, ", "))
+	}
+
+	return nil
+}
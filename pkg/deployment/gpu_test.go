@@ -0,0 +1,41 @@
+package deployment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestCheckGPUSupport(t *testing.T) {
+	runtimes := `{"io.containerd.runc.v2":{"path":"runc"},"runc":{"path":"runc"}}`
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		return runtimes, nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	require.NoError(t, d.checkGPUSupport(context.Background(), []config.Service{{Name: "web"}}))
+	assert.Empty(t, runner.executed())
+
+	services := []config.Service{{Name: "web"}, {Name: "inference", GPUs: "all"}}
+	err := d.checkGPUSupport(context.Background(), services)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "services inference need GPUs")
+	assert.Contains(t, err.Error(), "ftl setup --step gpu")
+
+	runtimes = `{"nvidia":{"path":"nvidia-container-runtime"},"runc":{"path":"runc"}}`
+	require.NoError(t, d.checkGPUSupport(context.Background(), services))
+}
+
+func TestContainerArgsGPUs(t *testing.T) {
+	args, err := containerArgs("shop", &config.Service{Name: "inference", Image: "shop/inference:1", GPUs: "device=0,1"}, "")
+	require.NoError(t, err)
+	assert.Contains(t, args, `"device=0,1"`)
+
+	args, err = containerArgs("shop", &config.Service{Name: "inference", Image: "shop/inference:1", GPUs: "all"}, "")
+	require.NoError(t, err)
+	assert.Contains(t, args, "all")
+}
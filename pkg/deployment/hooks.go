@@ -0,0 +1,146 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+const (
+	// hookExitMarker precedes the exit status printed after a remote hook command.
+	hookExitMarker = "ftl-hook-exit:"
+	// hookPIDFile holds the process ID of a post-hook inside the service container.
+	hookPIDFile = "/tmp/ftl-hook.pid"
+	// hookKillTimeout bounds the command that stops a hook after a timeout or cancellation.
+	hookKillTimeout = 30 * time.Second
+)
+
+// runHook runs one command of a hook within the hook timeout and applies its failure policy.
+// The failure of a hook with on_failure: continue is shown and otherwise ignored, unless the
+// deployment itself was cancelled.
+func (d *Deployment) runHook(ctx context.Context, name string, hook *config.HookItem, run func(ctx context.Context) error) error {
+	hookCtx := ctx
+	if timeout := hook.Timeout.Duration(); timeout > 0 {
+		var cancel context.CancelFunc
+		hookCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := run(hookCtx)
+	if err == nil {
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s was cancelled: %w", name, ctx.Err())
+	}
+	if errors.Is(hookCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("%s timed out after %s", name, hook.Timeout.Duration())
+	} else {
+		err = fmt.Errorf("%s failed: %w", name, err)
+	}
+
+	if hook.OnFailure == config.HookFailureContinue {
+		d.warn(name, "", err, "Continuing the deployment")
+		return nil
+	}
+
+	return err
+}
+
+// runRemoteHook runs a hook command on the server and fails when it exits with a non-zero status.
+// When ctx ends first, kill is run on the server to stop the hook.
+func (d *Deployment) runRemoteHook(ctx context.Context, kill []string, command string, args ...string) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killCtx, cancel := context.WithTimeout(context.Background(), hookKillTimeout)
+			defer cancel()
+			_, _ = d.runCommand(killCtx, kill[0], kill[1:]...)
+		case <-done:
+		}
+	}()
+
+	script := fmt.Sprintf(`"$@" 2>&1; echo "%s$?"`, hookExitMarker)
+	output, err := d.runCommand(ctx, "sh", append([]string{"-c", script, "sh", command}, args...)...)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	i := strings.LastIndex(output, hookExitMarker)
+	if i < 0 {
+		return fmt.Errorf("hook stopped without an exit status: %s", output)
+	}
+	if status := strings.TrimSpace(output[i+len(hookExitMarker):]); status != "0" {
+		return fmt.Errorf("exit status %s: %s", status, strings.TrimSpace(output[:i]))
+	}
+
+	return nil
+}
+
+// runProjectHook runs a project hook of stage, "pre-deploy" or "post-deploy". Local commands run
+// on this machine and remote ones in a shell of the deploy user on the server.
+func (d *Deployment) runProjectHook(ctx context.Context, project, stage string, hook *config.HookItem) error {
+	if hook == nil {
+		return nil
+	}
+
+	step := d.startStep(stage+"-hook", "", "Running %s hook", stage)
+
+	if hook.Local != "" {
+		err := d.runHook(ctx, "local "+stage+" hook", hook, func(ctx context.Context) error {
+			_, err := d.runLocalCommand(ctx, "sh", "-c", hook.Local)
+			return err
+		})
+		if err != nil {
+			step.fail(err)
+			return err
+		}
+	}
+
+	if hook.Remote != "" {
+		pidFile := fmt.Sprintf("/tmp/ftl-%s-hook.pid", project)
+		kill := []string{"sh", "-c", fmt.Sprintf(`kill -9 "$(cat %s)"`, pidFile)}
+		err := d.runHook(ctx, "remote "+stage+" hook", hook, func(ctx context.Context) error {
+			return d.runRemoteHook(ctx, kill, "sh", "-c", fmt.Sprintf(`echo $$ > %s; exec sh -c "$1"`, pidFile), "sh", hook.Remote)
+		})
+		if err != nil {
+			step.fail(err)
+			return err
+		}
+	}
+
+	step.complete()
+	return nil
+}
+
+// postDeployHook returns the project post-deploy hook. Its failure is only reported unless the
+// hook sets on_failure: abort.
+func postDeployHook(hooks *config.Hooks) *config.HookItem {
+	if hooks == nil || hooks.Post == nil {
+		return nil
+	}
+
+	hook := *hooks.Post
+	if hook.OnFailure == "" {
+		hook.OnFailure = config.HookFailureContinue
+	}
+	return &hook
+}
+
+// preDeployHook returns the project pre-deploy hook.
+func preDeployHook(hooks *config.Hooks) *config.HookItem {
+	if hooks == nil {
+		return nil
+	}
+	return hooks.Pre
+}
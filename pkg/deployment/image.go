@@ -3,51 +3,189 @@ package deployment
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/runner/remote"
 )
 
-func (d *Deployment) updateImage(project string, service *config.Service) error {
+func (d *Deployment) updateImage(ctx context.Context, project string, service *config.Service) error {
+	defer d.timePhase(service.Name, PhasePull)()
+
 	if service.Image == "" {
-		updated, err := d.syncer.Sync(context.Background(), fmt.Sprintf("%s-%s", project, service.Name))
+		updated, err := d.syncer.Sync(ctx, fmt.Sprintf("%s-%s", project, service.Name))
 		if err != nil {
 			return err
 		}
 		service.ImageUpdated = updated
 	}
 
-	_, err := d.pullImage(service.Image)
+	// An image ID, pinned by a rollback, refers to an image already on the server.
+	if service.Image != "" && !strings.HasPrefix(service.Image, "sha256:") {
+		if _, err := d.pullImage(ctx, service.Image); err != nil {
+			return err
+		}
+	}
+
+	return d.verifyImage(ctx, project, service)
+}
+
+// verifyImage records the image on the server that the service is deployed with, checking
+// that an image pinned to a digest was pulled with that digest.
+func (d *Deployment) verifyImage(ctx context.Context, project string, service *config.Service) error {
+	image, err := d.deployedImage(ctx, project, service)
 	if err != nil {
 		return err
 	}
 
+	if _, digest, pinned := strings.Cut(service.Image, "@"); pinned {
+		_, deployed, _ := strings.Cut(image.Digest, "@")
+		if deployed != digest {
+			return fmt.Errorf("image %s of service %s doesn't have the pinned digest on the server, it is %s", service.Image, service.Name, image.Reference())
+		}
+	}
+
+	service.ImageDigest = image.Reference()
 	return nil
 }
 
-func (d *Deployment) pullImage(imageName string) (string, error) {
-	_, err := d.runCommand(context.Background(), "docker", "pull", imageName)
+// imagePull is the pull of an image, whose hash and err are set once done is closed.
+type imagePull struct {
+	done chan struct{}
+	hash string
+	err  error
+}
+
+// startPulls lets up to max images, or config.DefaultMaxPulls when max is zero, be pulled at
+// once and forgets the images pulled by a previous deployment.
+func (d *Deployment) startPulls(max int) {
+	if max == 0 {
+		max = config.DefaultMaxPulls
+	}
+
+	d.pullsMu.Lock()
+	defer d.pullsMu.Unlock()
+	d.pullSlots = make(chan struct{}, max)
+	d.pulls = make(map[string]*imagePull)
+}
+
+// pullImage pulls the image and returns its ID. Services and dependencies using the same image
+// share one pull, and pulls wait for a free slot once the maximum number of pulls is running.
+func (d *Deployment) pullImage(ctx context.Context, imageName string) (string, error) {
+	d.pullsMu.Lock()
+	pull, shared := d.pulls[imageName]
+	if !shared {
+		pull = &imagePull{done: make(chan struct{})}
+		if d.pulls != nil {
+			d.pulls[imageName] = pull
+		}
+	}
+	slots := d.pullSlots
+	d.pullsMu.Unlock()
+
+	if shared {
+		select {
+		case <-pull.done:
+			return pull.hash, pull.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	if slots != nil {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		case <-ctx.Done():
+			pull.err = ctx.Err()
+			d.finishPull(imageName, pull)
+			return "", pull.err
+		}
+	}
+
+	step := d.startStep("pull/"+imageName, "", "Pulling image %s", imageName)
+	pull.hash, pull.err = d.dockerPull(ctx, imageName)
+	if pull.err != nil {
+		step.failf(pull.err, "Failed to pull image %s", imageName)
+	} else {
+		step.completef("Pulled image %s", imageName)
+	}
+	d.finishPull(imageName, pull)
+	return pull.hash, pull.err
+}
+
+// finishPull hands the outcome of pull to the services waiting for it. A failed pull is
+// forgotten, so that the image is pulled again when needed.
+func (d *Deployment) finishPull(imageName string, pull *imagePull) {
+	if pull.err != nil {
+		d.pullsMu.Lock()
+		if d.pulls[imageName] == pull {
+			delete(d.pulls, imageName)
+		}
+		d.pullsMu.Unlock()
+	}
+	close(pull.done)
+}
+
+// pullRateLimited matches the error of a registry refusing a pull because its rate limit, such
+// as the one of Docker Hub for anonymous pulls, was reached.
+var pullRateLimited = regexp.MustCompile(`(?i)toomanyrequests|pull rate limit`)
+
+// dockerPull runs docker pull and returns the ID of the pulled image.
+func (d *Deployment) dockerPull(ctx context.Context, imageName string) (string, error) {
+	// A pull can take long enough for the connection to drop, and is safe to run again.
+	output, err := d.retryCommand(remote.Idempotent(ctx), "pulling image "+imageName, "docker", "pull", imageName)
+	if err == nil && pullRateLimited.MatchString(output) {
+		err = fmt.Errorf("%s\nlog into the registry to raise its rate limit: add it to registries in ftl.yaml, or set %s and %s for Docker Hub",
+			output, envDockerUsername, envDockerPassword)
+	}
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("failed to pull image %s: %w", imageName, err)
 	}
 
-	output, err := d.runCommand(context.Background(), "docker", "images", "--no-trunc", "--format={{.ID}}", imageName)
+	hash, err := d.runCommand(ctx, "docker", "images", "--no-trunc", "--format={{.ID}}", imageName)
 	if err != nil {
 		return "", err
 	}
 
-	return strings.TrimSpace(output), nil
+	return strings.TrimSpace(hash), nil
 }
 
-func (d *Deployment) getImageHash(imageName string) (string, error) {
-	output, err := d.runCommand(context.Background(), "docker", "inspect", "--format={{.Id}}", imageName)
+// noSuchImage matches the docker CLI error for an image that doesn't exist, which reads
+// "Error: No such image: ..." or "Error response from daemon: No such image: ..." depending on
+// the Docker version.
+var noSuchImage = regexp.MustCompile(`(?i)\bno such (image|object)\b`)
+
+// getImageHash returns the ID of the image on the server, or an *ImageNotFoundError when the
+// server doesn't have it.
+func (d *Deployment) getImageHash(ctx context.Context, imageName string) (string, error) {
+	if d.docker != nil {
+		return d.apiImageHash(ctx, imageName)
+	}
+
+	output, err := d.runCommand(ctx, "docker", "image", "inspect", "--format={{.Id}}", imageName)
 	if err != nil {
 		return "", err
 	}
 
-	if strings.Contains(output, "Error: No such ") {
-		return "", nil
+	// The output holds the error message instead of the ID when the command fails.
+	switch {
+	case strings.HasPrefix(output, "sha256:"):
+		return output, nil
+	case noSuchImage.MatchString(output):
+		return "", &ImageNotFoundError{Image: imageName}
+	default:
+		return "", fmt.Errorf("failed to inspect image %s: %s", imageName, output)
 	}
+}
 
-	return strings.TrimSpace(output), nil
+// shortImageDigest shortens the sha256 digest of an image reference to 12 hex digits, as
+// docker shows image IDs.
+func shortImageDigest(reference string) string {
+	name, digest, found := strings.Cut(reference, "sha256:")
+	if !found || len(digest) <= 12 {
+		return reference
+	}
+	return name + "sha256:" + digest[:12]
 }
@@ -0,0 +1,165 @@
+package deployment
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestGetImageHash(t *testing.T) {
+	tests := []struct {
+		output   string
+		want     string
+		notFound bool
+		err      string
+	}{
+		{output: "sha256:abc", want: "sha256:abc"},
+		{output: "Error: No such image: shop/web:1", notFound: true},
+		{output: "Error response from daemon: No such image: shop/web:1", notFound: true},
+		{output: "Error: No such object: shop/web:1", notFound: true},
+		{output: "Cannot connect to the Docker daemon at unix:///var/run/docker.sock", err: "failed to inspect image shop/web:1: Cannot connect to the Docker daemon at unix:///var/run/docker.sock"},
+	}
+
+	for _, tt := range tests {
+		d := NewDeployment(&fakeRunner{handler: func(command string, args []string) (string, error) {
+			return tt.output, nil
+		}}, nil)
+
+		hash, err := d.getImageHash(context.Background(), "shop/web:1")
+		var notFound *ImageNotFoundError
+		switch {
+		case tt.notFound:
+			require.ErrorAs(t, err, &notFound, tt.output)
+			assert.Equal(t, "shop/web:1", notFound.Image)
+		case tt.err != "":
+			assert.EqualError(t, err, tt.err)
+		default:
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, hash)
+		}
+	}
+}
+
+func TestVerifyImage(t *testing.T) {
+	const digest = "sha256:d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1"
+	inspect := "sha256:aaa nginx@" + digest
+	d := NewDeployment(&fakeRunner{handler: func(command string, args []string) (string, error) {
+		if strings.Join(args[:2], " ") == "image inspect" {
+			return inspect, nil
+		}
+		return "", nil
+	}}, nil)
+
+	service := &config.Service{Name: "web", Image: "nginx@" + digest}
+	require.NoError(t, d.verifyImage(context.Background(), "shop", service))
+	assert.Equal(t, "nginx@"+digest, service.ImageDigest)
+
+	// A built image has no repository digest and is recorded by its ID.
+	service = &config.Service{Name: "api"}
+	require.NoError(t, d.verifyImage(context.Background(), "shop", service))
+	assert.Equal(t, "sha256:aaa", service.ImageDigest)
+
+	inspect = "sha256:bbb nginx@sha256:e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2"
+	service = &config.Service{Name: "web", Image: "nginx@" + digest}
+	err := d.verifyImage(context.Background(), "shop", service)
+	assert.EqualError(t, err, "image nginx@"+digest+" of service web doesn't have the pinned digest on the server, it is nginx@sha256:e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2e2")
+	assert.Empty(t, service.ImageDigest)
+
+	inspect = ""
+	err = d.verifyImage(context.Background(), "shop", &config.Service{Name: "web", Image: "nginx:1.27"})
+	assert.EqualError(t, err, "image nginx:1.27 of service web not found on the server")
+}
+
+func TestShortImageDigest(t *testing.T) {
+	assert.Equal(t, "nginx@sha256:d1d1d1d1d1d1", shortImageDigest("nginx@sha256:d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1d1"))
+	assert.Equal(t, "sha256:aaaaaaaaaaaa", shortImageDigest("sha256:aaaaaaaaaaaaaaaaaaaa"))
+	assert.Equal(t, "nginx:1.27", shortImageDigest("nginx:1.27"))
+}
+
+func TestPullImage_SharedAndCapped(t *testing.T) {
+	var mu sync.Mutex
+	pulls := make(map[string]int)
+	running, maxRunning := 0, 0
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if args[0] != "pull" {
+			return "sha256:" + args[len(args)-1], nil
+		}
+
+		mu.Lock()
+		pulls[args[1]]++
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return "Status: Downloaded newer image for " + args[1], nil
+	}}
+	d := NewDeployment(runner, nil)
+	d.startPulls(0)
+
+	images := []string{"postgres:16", "redis:7", "nginx:1.27", "redis:7", "redis:7"}
+	hashes := make([]string, len(images))
+	var wg sync.WaitGroup
+	for i, image := range images {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hash, err := d.pullImage(context.Background(), image)
+			assert.NoError(t, err)
+			hashes[i] = hash
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, map[string]int{"postgres:16": 1, "redis:7": 1, "nginx:1.27": 1}, pulls)
+	assert.Equal(t, config.DefaultMaxPulls, maxRunning)
+	assert.Equal(t, []string{"sha256:postgres:16", "sha256:redis:7", "sha256:nginx:1.27", "sha256:redis:7", "sha256:redis:7"}, hashes)
+
+	// A new deployment pulls the images again.
+	d.startPulls(1)
+	_, err := d.pullImage(context.Background(), "redis:7")
+	require.NoError(t, err)
+	assert.Equal(t, 2, pulls["redis:7"])
+}
+
+func TestPullImage_RateLimited(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		return "Error response from daemon: toomanyrequests: You have reached your unauthenticated pull rate limit.", nil
+	}}
+	d := NewDeployment(runner, nil)
+	d.startPulls(0)
+	d.events = make(chan Event, eventBuffer)
+
+	_, err := d.pullImage(context.Background(), "redis:7")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to pull image redis:7: Error response from daemon: toomanyrequests")
+	assert.Contains(t, err.Error(), "set FTL_DOCKER_USERNAME and FTL_DOCKER_PASSWORD for Docker Hub")
+	assert.Len(t, runner.executed(), 1, "rate limits are not retried")
+
+	close(d.events)
+	var events []Event
+	for event := range d.events {
+		events = append(events, event)
+	}
+	require.Len(t, events, 2)
+	assert.Equal(t, "pull/redis:7", events[1].Step)
+	assert.Equal(t, EventFailed, events[1].Type)
+	assert.Equal(t, "Failed to pull image redis:7", events[1].Message)
+
+	// The failed pull isn't shared with later pulls of the image.
+	d.events = nil
+	_, err = d.pullImage(context.Background(), "redis:7")
+	require.Error(t, err)
+	assert.Len(t, runner.executed(), 2)
+}
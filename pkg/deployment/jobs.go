@@ -0,0 +1,109 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/jobs"
+)
+
+// installJobs writes the job scripts and env files and replaces the project block of the server
+// user's crontab, removing entries, scripts and env files of jobs that are no longer configured.
+// The jobs directory and its files are only accessible to the server user, since the env files
+// hold the secrets of the services.
+func (d *Deployment) installJobs(ctx context.Context, project string, cfg *config.Config) error {
+	projectPath, err := d.projectFolder(project)
+	if err != nil {
+		return fmt.Errorf("failed to get project folder path: %w", err)
+	}
+	dir := jobs.Dir(projectPath)
+
+	if len(cfg.Jobs) > 0 {
+		if _, err := d.runCommand(ctx, "sh", "-c", `mkdir -p "$1" && chmod 700 "$1"`, "sh", dir); err != nil {
+			return fmt.Errorf("failed to create jobs directory: %w", err)
+		}
+	}
+
+	configured := make(map[string]bool)
+	for _, job := range cfg.Jobs {
+		script, err := jobs.Script(project, projectPath, job, cfg.Services)
+		if err != nil {
+			return fmt.Errorf("failed to render script for job %s: %w", job.Name, err)
+		}
+		env, err := jobs.EnvFile(job, cfg.Services)
+		if err != nil {
+			return fmt.Errorf("failed to render env file for job %s: %w", job.Name, err)
+		}
+		if env != "" {
+			if err := d.copyContentWithMode(ctx, []byte(env), jobs.EnvPath(projectPath, job.Name), 0600); err != nil {
+				return fmt.Errorf("failed to copy env file for job %s: %w", job.Name, err)
+			}
+		}
+		if err := d.copyContentWithMode(ctx, []byte(script), jobs.ScriptPath(projectPath, job.Name), 0700); err != nil {
+			return fmt.Errorf("failed to copy script for job %s: %w", job.Name, err)
+		}
+		configured[job.Name] = true
+		if env != "" {
+			configured[job.Name+".env"] = true
+		}
+	}
+
+	current, err := d.runCommand(ctx, "sh", "-c", "crontab -l 2>/dev/null || true")
+	if err != nil {
+		return fmt.Errorf("failed to read crontab: %w", err)
+	}
+	updated := jobs.Crontab(current, project, projectPath, cfg.Jobs)
+	if strings.TrimSpace(updated) != strings.TrimSpace(current) {
+		if err := d.writeCrontab(ctx, updated); err != nil {
+			return err
+		}
+	}
+
+	scripts, err := d.runCommand(ctx, "sh", "-c", `ls "$1" 2>/dev/null || true`, "sh", dir)
+	if err != nil {
+		return fmt.Errorf("failed to list job scripts: %w", err)
+	}
+	for _, file := range strings.Fields(scripts) {
+		if name, ok := strings.CutSuffix(file, ".sh"); ok && !configured[name] {
+			if _, err := d.runCommand(ctx, "rm", "-f", filepath.Join(dir, file)); err != nil {
+				return fmt.Errorf("failed to remove script of job %s: %w", name, err)
+			}
+		}
+		if name, ok := strings.CutSuffix(file, ".env"); ok && !configured[file] {
+			if _, err := d.runCommand(ctx, "rm", "-f", filepath.Join(dir, file)); err != nil {
+				return fmt.Errorf("failed to remove env file of job %s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *Deployment) writeCrontab(ctx context.Context, crontab string) error {
+	path, err := d.runCommand(ctx, "sh", "-c", "command -v crontab || true")
+	if err != nil {
+		return fmt.Errorf("failed to look up crontab: %w", err)
+	}
+	if path == "" {
+		return fmt.Errorf("crontab is not installed on the server, install cron to run scheduled jobs")
+	}
+
+	output, err := d.runner.RunCommandWithInput(ctx, strings.NewReader(crontab), "sh", "-c", "crontab - && echo crontab-installed")
+	if err != nil {
+		return fmt.Errorf("failed to install crontab: %w", err)
+	}
+	defer output.Close()
+
+	data, err := io.ReadAll(output)
+	if err != nil {
+		return fmt.Errorf("failed to read crontab output: %w", err)
+	}
+	if !strings.Contains(string(data), "crontab-installed") {
+		return fmt.Errorf("failed to install crontab: %s", strings.TrimSpace(string(data)))
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package deployment
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestInstallJobs(t *testing.T) {
+	cfg := &config.Config{
+		Services: []config.Service{{Name: "web", Env: []string{"DATABASE_URL=postgres://app:secret@db/shop"}}},
+		Jobs: []config.Job{
+			{Name: "cleanup", Service: "web", Command: "bin/cleanup", Schedule: "0 3 * * *"},
+			{Name: "report", Image: "alpine", Command: "true", Schedule: "@daily"},
+		},
+	}
+
+	crontab := "MAILTO=ops@example.com\n# BEGIN ftl jobs shop\n*/5 * * * * sh '/home/deploy/projects/shop/jobs/report.sh' >/dev/null 2>&1\n# END ftl jobs shop\n"
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		script := strings.Join(args, " ")
+		switch {
+		case strings.Contains(script, "echo $HOME"):
+			return "/home/deploy\n", nil
+		case strings.Contains(script, "crontab -l"):
+			return crontab, nil
+		case strings.Contains(script, "command -v crontab"):
+			return "/usr/bin/crontab\n", nil
+		case strings.Contains(script, "crontab -"):
+			return "crontab-installed\n", nil
+		case strings.HasPrefix(script, "-c ls"):
+			return "cleanup.sh\ncleanup.env\ncleanup.status\nreport.sh\nreport.env\nreport.log\nold.sh\nold.env\n", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	require.NoError(t, d.installJobs(context.Background(), "shop", cfg))
+
+	assert.Equal(t, []string{
+		"/home/deploy/projects/shop/jobs/cleanup.env",
+		"/home/deploy/projects/shop/jobs/cleanup.sh",
+		"/home/deploy/projects/shop/jobs/report.sh",
+	}, runner.copied)
+	assert.Equal(t, os.FileMode(0600), runner.modes["/home/deploy/projects/shop/jobs/cleanup.env"])
+	assert.Equal(t, os.FileMode(0700), runner.modes["/home/deploy/projects/shop/jobs/cleanup.sh"])
+	assert.Contains(t, runner.executed(), `sh -c mkdir -p "$1" && chmod 700 "$1" sh /home/deploy/projects/shop/jobs`)
+	assert.Contains(t, runner.inputs, "MAILTO=ops@example.com\n# BEGIN ftl jobs shop\n0 3 * * * sh '/home/deploy/projects/shop/jobs/cleanup.sh' >/dev/null 2>&1\n@daily sh '/home/deploy/projects/shop/jobs/report.sh' >/dev/null 2>&1\n# END ftl jobs shop\n")
+
+	executed := runner.executed()
+	assert.Contains(t, executed, "rm -f /home/deploy/projects/shop/jobs/old.sh")
+	assert.Contains(t, executed, "rm -f /home/deploy/projects/shop/jobs/old.env")
+	assert.Contains(t, executed, "rm -f /home/deploy/projects/shop/jobs/report.env", "the env file of a job without environment is stale")
+	assert.NotContains(t, executed, "rm -f /home/deploy/projects/shop/jobs/cleanup.sh")
+	assert.NotContains(t, executed, "rm -f /home/deploy/projects/shop/jobs/cleanup.env")
+}
+
+func TestInstallJobsWithoutJobs(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if strings.Contains(strings.Join(args, " "), "echo $HOME") {
+			return "/home/deploy\n", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	require.NoError(t, d.installJobs(context.Background(), "shop", &config.Config{}))
+
+	assert.Empty(t, runner.copied)
+	for _, command := range runner.executed() {
+		assert.NotContains(t, command, "crontab - &&")
+	}
+}
+
+func TestInstallJobsWithoutCron(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if strings.Contains(strings.Join(args, " "), "echo $HOME") {
+			return "/home/deploy\n", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	cfg := &config.Config{Jobs: []config.Job{{Name: "report", Image: "alpine", Command: "true", Schedule: "@daily"}}}
+	err := d.installJobs(context.Background(), "shop", cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "crontab is not installed")
+}
@@ -0,0 +1,229 @@
+package deployment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	lockFileName             = ".deploy.lock"
+	defaultLockTimeout       = 2 * time.Minute
+	defaultHeartbeatInterval = 15 * time.Second
+)
+
+// lockInfo is the content of the deployment lock file stored in the project folder.
+type lockInfo struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	Started   time.Time `json:"started"`
+	Heartbeat time.Time `json:"heartbeat"`
+}
+
+// LockedError is returned when another deployment holds a live lock on the project.
+type LockedError struct {
+	Owner     string
+	Started   time.Time
+	Heartbeat time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("deployment is locked by %s since %s (last heartbeat %s); use --force-unlock to override",
+		e.Owner, e.Started.Format(time.RFC3339), e.Heartbeat.Format(time.RFC3339))
+}
+
+// deployLock is a held deployment lock whose heartbeat is refreshed in the background.
+type deployLock struct {
+	d      *Deployment
+	path   string
+	info   lockInfo
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// acquireLock takes the deployment lock for the project. A lock whose heartbeat is older
+// than timeout is considered abandoned and is taken over.
+func (d *Deployment) acquireLock(ctx context.Context, project string, timeout time.Duration) (*deployLock, error) {
+	if timeout <= 0 {
+		timeout = defaultLockTimeout
+	}
+
+	path, err := d.lockPath(project)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := d.readLock(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	now := d.clock()
+	if existing != nil {
+		if now.Sub(existing.Heartbeat) <= timeout {
+			return nil, &LockedError{Owner: existing.Owner, Started: existing.Started, Heartbeat: existing.Heartbeat}
+		}
+
+		d.startStep("lock", "", "Taking over deployment lock abandoned by %s (last heartbeat %s)",
+			existing.Owner, existing.Heartbeat.Format(time.RFC3339)).complete()
+	}
+
+	id, err := newLockID()
+	if err != nil {
+		return nil, err
+	}
+
+	info := lockInfo{
+		ID:        id,
+		Owner:     lockOwner(),
+		Started:   now,
+		Heartbeat: now,
+	}
+	if err := d.writeLock(ctx, path, info); err != nil {
+		return nil, err
+	}
+
+	// Another deployment may have raced us between the read and the write; the last writer wins.
+	current, err := d.readLock(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || current.ID != info.ID {
+		if current == nil {
+			return nil, fmt.Errorf("failed to write deployment lock %s", path)
+		}
+		return nil, &LockedError{Owner: current.Owner, Started: current.Started, Heartbeat: current.Heartbeat}
+	}
+
+	heartbeatCtx, cancel := context.WithCancel(ctx)
+	lock := &deployLock{d: d, path: path, info: info, cancel: cancel}
+	lock.wg.Add(1)
+	go lock.heartbeat(heartbeatCtx)
+
+	return lock, nil
+}
+
+// ForceUnlock removes the deployment lock of the project regardless of its owner.
+func (d *Deployment) ForceUnlock(ctx context.Context, project string) error {
+	path, err := d.lockPath(project)
+	if err != nil {
+		return err
+	}
+
+	if _, err := d.runCommand(ctx, "rm", "-f", path); err != nil {
+		return fmt.Errorf("failed to remove deployment lock: %w", err)
+	}
+
+	return nil
+}
+
+// heartbeat refreshes the lock timestamp until the lock is released or taken over.
+func (l *deployLock) heartbeat(ctx context.Context) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.d.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current, err := l.d.readLock(ctx, l.path)
+			if err != nil {
+				continue
+			}
+			if current == nil || current.ID != l.info.ID {
+				// The lock was removed or taken over; stop refreshing it.
+				return
+			}
+
+			l.info.Heartbeat = l.d.clock()
+			_ = l.d.writeLock(ctx, l.path, l.info)
+		}
+	}
+}
+
+// release stops the heartbeat and removes the lock file if it is still owned by this lock.
+func (l *deployLock) release(ctx context.Context) error {
+	l.cancel()
+	l.wg.Wait()
+
+	current, err := l.d.readLock(ctx, l.path)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.ID != l.info.ID {
+		return nil
+	}
+
+	if _, err := l.d.runCommand(ctx, "rm", "-f", l.path); err != nil {
+		return fmt.Errorf("failed to remove deployment lock: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Deployment) lockPath(project string) (string, error) {
+	projectPath, err := d.prepareProjectFolder(project)
+	if err != nil {
+		return "", fmt.Errorf("failed to prepare project folder: %w", err)
+	}
+
+	return filepath.Join(projectPath, lockFileName), nil
+}
+
+func (d *Deployment) readLock(ctx context.Context, path string) (*lockInfo, error) {
+	var info lockInfo
+	found, err := d.readRemoteJSON(ctx, path, &info)
+	if errors.Is(err, errMalformedJSON) {
+		// An unreadable lock can't be refreshed by anyone, so treat it as abandoned.
+		return &lockInfo{Owner: "unknown"}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deployment lock: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+
+	return &info, nil
+}
+
+func (d *Deployment) writeLock(ctx context.Context, path string, info lockInfo) error {
+	if err := d.writeRemoteJSON(ctx, path, info); err != nil {
+		return fmt.Errorf("failed to write deployment lock: %w", err)
+	}
+
+	return nil
+}
+
+func newLockID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate lock ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// lockOwner describes the local user and machine running the deployment.
+func lockOwner() string {
+	username := "unknown"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return fmt.Sprintf("%s@%s", username, hostname)
+}
@@ -0,0 +1,108 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testLockPath = "/home/test/projects/test-project/" + lockFileName
+
+// testLock returns the deployment lock stored on the server, or nil.
+func testLock(t *testing.T, server *fakeFileServer) *lockInfo {
+	var info lockInfo
+	if !server.readJSON(t, testLockPath, &info) {
+		return nil
+	}
+	return &info
+}
+
+func newLockTestDeployment(server *fakeFileServer, clock *fakeClock) *Deployment {
+	d := NewDeployment(&fakeRunner{handler: server.handle}, nil)
+	d.clock = clock.Now
+	d.heartbeatInterval = 10 * time.Millisecond
+	return d
+}
+
+func TestAcquireLock_TakesOverAbandonedLock(t *testing.T) {
+	server := newFakeFileServer()
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+
+	server.writeJSON(t, testLockPath, lockInfo{
+		ID:        "abandoned",
+		Owner:     "alice@laptop",
+		Started:   clock.Now().Add(-10 * time.Minute),
+		Heartbeat: clock.Now().Add(-3 * time.Minute),
+	})
+
+	d := newLockTestDeployment(server, clock)
+	lock, err := d.acquireLock(context.Background(), "test-project", 2*time.Minute)
+	require.NoError(t, err)
+
+	current := testLock(t, server)
+	require.NotNil(t, current)
+	assert.NotEqual(t, "abandoned", current.ID)
+	assert.Equal(t, clock.Now(), current.Heartbeat)
+
+	require.NoError(t, lock.release(context.Background()))
+	assert.Nil(t, testLock(t, server))
+}
+
+func TestAcquireLock_RefusesConcurrentlyRefreshedLock(t *testing.T) {
+	server := newFakeFileServer()
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+
+	holder := newLockTestDeployment(server, clock)
+	lock, err := holder.acquireLock(context.Background(), "test-project", 2*time.Minute)
+	require.NoError(t, err)
+	defer func() { _ = lock.release(context.Background()) }()
+
+	// Move well past the timeout; the holder's heartbeat must keep the lock alive.
+	clock.Advance(5 * time.Minute)
+	require.Eventually(t, func() bool {
+		current := testLock(t, server)
+		return current != nil && current.Heartbeat.Equal(clock.Now())
+	}, time.Second, 5*time.Millisecond)
+
+	contender := newLockTestDeployment(server, clock)
+	_, err = contender.acquireLock(context.Background(), "test-project", 2*time.Minute)
+	require.Error(t, err)
+
+	var lockedErr *LockedError
+	require.True(t, errors.As(err, &lockedErr))
+	assert.Equal(t, lockOwner(), lockedErr.Owner)
+}
+
+func TestAcquireLock_UsesDefaultTimeout(t *testing.T) {
+	server := newFakeFileServer()
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+
+	server.writeJSON(t, testLockPath, lockInfo{
+		ID:        "recent",
+		Owner:     "bob@ci",
+		Started:   clock.Now().Add(-time.Minute),
+		Heartbeat: clock.Now().Add(-time.Minute),
+	})
+
+	d := newLockTestDeployment(server, clock)
+	_, err := d.acquireLock(context.Background(), "test-project", 0)
+
+	var lockedErr *LockedError
+	require.True(t, errors.As(err, &lockedErr))
+	assert.Equal(t, "bob@ci", lockedErr.Owner)
+}
+
+func TestForceUnlock(t *testing.T) {
+	server := newFakeFileServer()
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)}
+
+	server.writeJSON(t, testLockPath, lockInfo{ID: "held", Owner: "bob@ci", Heartbeat: clock.Now()})
+
+	d := newLockTestDeployment(server, clock)
+	require.NoError(t, d.ForceUnlock(context.Background(), "test-project"))
+	assert.Nil(t, testLock(t, server))
+}
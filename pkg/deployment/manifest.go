@@ -0,0 +1,272 @@
+package deployment
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+const (
+	// manifestDirName is the folder of the project folder holding the deployment manifests.
+	manifestDirName = "deployments"
+	// manifestIDFormat formats the deployment time into the manifest ID and file name.
+	manifestIDFormat = "20060102T150405Z"
+)
+
+// Manifest records what a successful deployment deployed. It is stored on the server as
+// ~/projects/<project>/deployments/<id>.json.
+type Manifest struct {
+	// ID is the UTC deployment time, such as 20240501T100019Z.
+	ID         string    `json:"id"`
+	Time       time.Time `json:"time"`
+	ConfigHash string    `json:"config_hash"`
+	// GitSHA is the commit checked out where the deployment ran, if it ran in a git repository.
+	GitSHA string `json:"git_sha,omitempty"`
+	// DeployedBy is the local user and machine that ran the deployment, as user@host.
+	DeployedBy string `json:"deployed_by"`
+	// Services holds the deployed image of each service by service name.
+	Services map[string]ManifestImage `json:"services"`
+}
+
+// ManifestImage is the image a service was deployed with.
+type ManifestImage struct {
+	// Image is the image of the service configuration, empty for images built and synced by ftl.
+	Image string `json:"image,omitempty"`
+	// ID is the ID of the deployed image.
+	ID string `json:"id"`
+	// Digest is the repository digest of a registry image, such as nginx@sha256:....
+	Digest string `json:"digest,omitempty"`
+}
+
+// Reference returns the image reference that deploys exactly this image again: the registry
+// digest when there is one, so the image can be pulled again, or the image ID.
+func (i ManifestImage) Reference() string {
+	if i.Digest != "" {
+		return i.Digest
+	}
+	return i.ID
+}
+
+// Pin sets the image of every service in cfg that m records to the image it was deployed with.
+// It returns the services of m that cfg no longer defines.
+func (m *Manifest) Pin(cfg *config.Config) []string {
+	pinned := make(map[string]bool)
+	for i := range cfg.Services {
+		if image, ok := m.Services[cfg.Services[i].Name]; ok {
+			cfg.Services[i].Image = image.Reference()
+			pinned[cfg.Services[i].Name] = true
+		}
+	}
+
+	var missing []string
+	for name := range m.Services {
+		if !pinned[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// recordDeployment writes the manifest of the finished deployment and removes the oldest
+// manifests beyond the history limit. Failures are reported as warnings, as the deployment
+// itself succeeded.
+func (d *Deployment) recordDeployment(ctx context.Context, project string, cfg *config.Config) {
+	manifest, err := d.buildManifest(ctx, project, cfg)
+	if err != nil {
+		d.warn("manifest", "", err, "Failed to record the deployment")
+		return
+	}
+
+	projectPath, err := d.projectFolder(project)
+	if err != nil {
+		d.warn("manifest", "", err, "Failed to record the deployment")
+		return
+	}
+	dir := filepath.Join(projectPath, manifestDirName)
+
+	if err := d.writeManifest(ctx, dir, manifest); err != nil {
+		d.warn("manifest", "", err, "Failed to record the deployment")
+		return
+	}
+
+	limit := cfg.Deploy.HistoryLimit
+	if limit == 0 {
+		limit = config.DefaultHistoryLimit
+	}
+	if err := d.pruneManifests(ctx, dir, limit); err != nil {
+		d.warn("manifest", "", err, "Failed to remove old deployment manifests")
+	}
+}
+
+func (d *Deployment) buildManifest(ctx context.Context, project string, cfg *config.Config) (*Manifest, error) {
+	now := d.clock().UTC()
+	configHash, err := cfg.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		ID:         now.Format(manifestIDFormat),
+		Time:       now,
+		ConfigHash: configHash,
+		GitSHA:     d.gitSHA(ctx),
+		DeployedBy: lockOwner(),
+		Services:   make(map[string]ManifestImage),
+	}
+
+	for _, service := range cfg.Services {
+		image, err := d.deployedImage(ctx, project, &service)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Services[service.Name] = image
+	}
+
+	return manifest, nil
+}
+
+// deployedImage returns the ID and repository digest of the image service was deployed with.
+func (d *Deployment) deployedImage(ctx context.Context, project string, service *config.Service) (ManifestImage, error) {
+	image := service.Image
+	if image == "" {
+		image = fmt.Sprintf("%s-%s", project, service.Name)
+	}
+
+	output, err := d.runCommand(ctx, "docker", "image", "inspect", "--format", "{{.Id}}{{range .RepoDigests}} {{.}}{{end}}", image)
+	if err != nil {
+		return ManifestImage{}, fmt.Errorf("failed to inspect image of service %s: %w", service.Name, err)
+	}
+
+	fields := strings.Fields(output)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "sha256:") {
+		return ManifestImage{}, fmt.Errorf("image %s of service %s not found on the server", image, service.Name)
+	}
+
+	deployed := ManifestImage{Image: service.Image, ID: fields[0]}
+	if service.Image != "" {
+		deployed.Digest = repositoryDigest(service.Image, fields[1:])
+	}
+	return deployed, nil
+}
+
+// repositoryDigest picks the digest of the repository of image out of the repository digests
+// of the image, falling back to the first one.
+func repositoryDigest(image string, digests []string) string {
+	repository := image
+	if name, _, found := strings.Cut(repository, "@"); found {
+		repository = name
+	}
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+
+	for _, digest := range digests {
+		if strings.HasPrefix(digest, repository+"@") {
+			return digest
+		}
+	}
+	if len(digests) > 0 {
+		return digests[0]
+	}
+	return ""
+}
+
+// gitSHA returns the commit checked out in the working directory, or "" outside a git repository.
+func (d *Deployment) gitSHA(ctx context.Context) string {
+	output, err := d.runLocalCommand(ctx, "git", "rev-parse", "HEAD")
+	if err != nil || len(output) != 40 {
+		return ""
+	}
+	return output
+}
+
+// writeManifest writes the manifest into dir.
+func (d *Deployment) writeManifest(ctx context.Context, dir string, manifest *Manifest) error {
+	if err := d.writeRemoteJSON(ctx, filepath.Join(dir, manifest.ID+".json"), manifest); err != nil {
+		return fmt.Errorf("failed to write deployment manifest: %w", err)
+	}
+
+	return nil
+}
+
+// pruneManifests removes the oldest manifests in dir, keeping the newest limit.
+func (d *Deployment) pruneManifests(ctx context.Context, dir string, limit int) error {
+	return d.pruneFiles(ctx, dir, "*.json", limit)
+}
+
+// pruneFiles removes the oldest files in dir matching the glob pattern, keeping the newest
+// limit. The names of the files must sort from oldest to newest.
+func (d *Deployment) pruneFiles(ctx context.Context, dir, pattern string, limit int) error {
+	output, err := d.runCommand(ctx, "sh", "-c", `for f in "$1"/$2; do [ -f "$f" ] && basename "$f"; done; true`, "sh", dir, pattern)
+	if err != nil {
+		return err
+	}
+
+	files := strings.Fields(output)
+	if len(files) <= limit {
+		return nil
+	}
+	sort.Strings(files)
+
+	args := []string{"-f"}
+	for _, file := range files[:len(files)-limit] {
+		args = append(args, filepath.Join(dir, file))
+	}
+	_, err = d.runCommand(ctx, "rm", args...)
+	return err
+}
+
+// Manifests returns the manifests of the recorded deployments of the project, newest first.
+// Unreadable manifests are skipped.
+func Manifests(ctx context.Context, runner Runner, project string) ([]Manifest, error) {
+	reader, err := runner.RunCommand(ctx, "sh", "-c",
+		`for f in "$HOME/projects/$1/`+manifestDirName+`"/*.json; do [ -f "$f" ] && { cat "$f"; echo; }; done; true`, "sh", project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deployment manifests: %w", err)
+	}
+	defer reader.Close()
+
+	var manifests []Manifest
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var manifest Manifest
+		if err := json.Unmarshal([]byte(line), &manifest); err != nil || manifest.ID == "" {
+			continue
+		}
+		manifests = append(manifests, manifest)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read deployment manifests: %w", err)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].ID > manifests[j].ID })
+	return manifests, nil
+}
+
+// FindManifest returns the manifest of the deployment with the given ID.
+func FindManifest(ctx context.Context, runner Runner, project, id string) (*Manifest, error) {
+	manifests, err := Manifests(ctx, runner, project)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range manifests {
+		if manifests[i].ID == id {
+			return &manifests[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no deployment %s of project %s is recorded on the server, see ftl history", id, project)
+}
@@ -0,0 +1,159 @@
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+const testManifestDir = "/home/test/projects/test-project/" + manifestDirName
+
+// fakeManifestServer emulates the images and manifest files of a server.
+type fakeManifestServer struct {
+	*fakeFileServer
+	images map[string]string
+}
+
+func (s *fakeManifestServer) handle(command string, args []string) (string, error) {
+	switch {
+	case command == "docker" && args[0] == "image" && args[1] == "inspect":
+		return s.images[args[len(args)-1]], nil
+	case command == "sh" && strings.Contains(args[1], "/*.json"):
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		var contents []string
+		for _, content := range s.files {
+			contents = append(contents, content)
+		}
+		return strings.Join(contents, "\n"), nil
+	}
+	return s.fakeFileServer.handle(command, args)
+}
+
+func manifestTestConfig() *config.Config {
+	return &config.Config{
+		Project: config.Project{Name: "test-project"},
+		Services: []config.Service{
+			{Name: "web", Image: "ghcr.io/acme/web:1.4"},
+			{Name: "api"},
+		},
+	}
+}
+
+func TestRecordDeployment(t *testing.T) {
+	server := &fakeManifestServer{
+		fakeFileServer: newFakeFileServer(),
+		images: map[string]string{
+			"ghcr.io/acme/web:1.4": "sha256:aaa ghcr.io/acme/web@sha256:d1",
+			"test-project-api":     "sha256:bbb",
+		},
+	}
+	for day := 1; day <= config.DefaultHistoryLimit; day++ {
+		id := fmt.Sprintf("202404%02dT090000Z", day)
+		server.files[testManifestDir+"/"+id+".json"] = fmt.Sprintf(`{"id":%q}`, id)
+	}
+
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 10, 0, 19, 0, time.UTC)}
+	d := NewDeployment(&fakeRunner{handler: server.handle}, nil)
+	d.clock = clock.Now
+	d.recordDeployment(context.Background(), "test-project", manifestTestConfig())
+
+	assert.Len(t, server.files, config.DefaultHistoryLimit)
+	assert.NotContains(t, server.files, testManifestDir+"/20240401T090000Z.json")
+	require.Contains(t, server.files, testManifestDir+"/20240501T100019Z.json")
+
+	var manifest Manifest
+	require.NoError(t, json.Unmarshal([]byte(server.files[testManifestDir+"/20240501T100019Z.json"]), &manifest))
+	assert.Equal(t, "20240501T100019Z", manifest.ID)
+	assert.Equal(t, clock.Now(), manifest.Time)
+	assert.Len(t, manifest.ConfigHash, 64)
+	assert.NotEmpty(t, manifest.DeployedBy)
+	assert.Equal(t, map[string]ManifestImage{
+		"web": {Image: "ghcr.io/acme/web:1.4", ID: "sha256:aaa", Digest: "ghcr.io/acme/web@sha256:d1"},
+		"api": {ID: "sha256:bbb"},
+	}, manifest.Services)
+
+	manifests, err := Manifests(context.Background(), &fakeRunner{handler: server.handle}, "test-project")
+	require.NoError(t, err)
+	require.Len(t, manifests, config.DefaultHistoryLimit)
+	assert.Equal(t, "20240501T100019Z", manifests[0].ID)
+	assert.Equal(t, "20240402T090000Z", manifests[len(manifests)-1].ID)
+}
+
+func TestRecordDeployment_WarnsOnFailure(t *testing.T) {
+	server := &fakeManifestServer{
+		fakeFileServer: newFakeFileServer(),
+		images:         map[string]string{"ghcr.io/acme/web:1.4": "sha256:aaa", "test-project-api": "sha256:bbb"},
+	}
+	server.failMove = true
+
+	d := NewDeployment(&fakeRunner{handler: server.handle}, nil)
+	d.events = make(chan Event, eventBuffer)
+	d.recordDeployment(context.Background(), "test-project", manifestTestConfig())
+	close(d.events)
+
+	var warnings []Event
+	for event := range d.events {
+		warnings = append(warnings, event)
+	}
+	require.Len(t, warnings, 1)
+	assert.Equal(t, EventWarning, warnings[0].Type)
+	assert.Equal(t, "Failed to record the deployment", warnings[0].Message)
+	assert.ErrorContains(t, warnings[0].Err, "Permission denied")
+	assert.Empty(t, server.files)
+
+	// An image missing on the server leaves the deployment unrecorded.
+	server.failMove = false
+	delete(server.images, "test-project-api")
+	d.events = make(chan Event, eventBuffer)
+	d.recordDeployment(context.Background(), "test-project", manifestTestConfig())
+	close(d.events)
+
+	event := <-d.events
+	assert.ErrorContains(t, event.Err, "image test-project-api of service api not found on the server")
+	assert.Empty(t, server.files)
+}
+
+func TestManifestPin(t *testing.T) {
+	manifest := Manifest{Services: map[string]ManifestImage{
+		"web":    {Image: "ghcr.io/acme/web:1.4", ID: "sha256:aaa", Digest: "ghcr.io/acme/web@sha256:d1"},
+		"api":    {ID: "sha256:bbb"},
+		"worker": {ID: "sha256:ccc"},
+	}}
+	cfg := manifestTestConfig()
+	cfg.Services = append(cfg.Services, config.Service{Name: "admin", Image: "admin:latest"})
+
+	missing := manifest.Pin(cfg)
+
+	assert.Equal(t, []string{"worker"}, missing)
+	assert.Equal(t, "ghcr.io/acme/web@sha256:d1", cfg.Services[0].Image)
+	assert.Equal(t, "sha256:bbb", cfg.Services[1].Image)
+	assert.Equal(t, "admin:latest", cfg.Services[2].Image)
+}
+
+func TestRepositoryDigest(t *testing.T) {
+	tests := []struct {
+		image   string
+		digests []string
+		want    string
+	}{
+		{"nginx:1.27", []string{"nginx@sha256:d1"}, "nginx@sha256:d1"},
+		{"localhost:5000/web", []string{"other@sha256:d0", "localhost:5000/web@sha256:d1"}, "localhost:5000/web@sha256:d1"},
+		{"web@sha256:d1", []string{"web@sha256:d1"}, "web@sha256:d1"},
+		{"docker.io/library/nginx", []string{"nginx@sha256:d1"}, "nginx@sha256:d1"},
+		{"nginx", nil, ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, repositoryDigest(tt.image, tt.digests), tt.image)
+	}
+}
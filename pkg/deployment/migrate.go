@@ -0,0 +1,124 @@
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// MigrationState records the volumes a migration copied to the target server, so that an
+// interrupted migration continues with the next volume instead of copying everything again.
+type MigrationState struct {
+	path string
+	// Volumes lists the Docker volumes copied to the target server.
+	Volumes []string `json:"volumes"`
+	// Copying is the Docker volume whose copy was started but didn't finish. It may be
+	// replaced on the target server when the migration continues.
+	Copying string `json:"copying,omitempty"`
+}
+
+// migrationStatePath returns the cache location of the state of migrating the project to target.
+func migrationStatePath(project, target string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "ftl", "migrations", project+"-"+target+".json"), nil
+}
+
+// LoadMigrationState returns the state of migrating the project to the target server, which is
+// empty when no migration to that server was started yet.
+func LoadMigrationState(project, target string) (*MigrationState, error) {
+	path, err := migrationStatePath(project, target)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &MigrationState{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse migration state %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// Copied reports whether the Docker volume was copied to the target server.
+func (s *MigrationState) Copied(volume string) bool {
+	return slices.Contains(s.Volumes, volume)
+}
+
+// MarkCopying records that the copy of the Docker volume to the target server started.
+func (s *MigrationState) MarkCopying(volume string) error {
+	s.Copying = volume
+	return s.save()
+}
+
+// MarkCopied records that the Docker volume was copied to the target server.
+func (s *MigrationState) MarkCopied(volume string) error {
+	if !s.Copied(volume) {
+		s.Volumes = append(s.Volumes, volume)
+	}
+	s.Copying = ""
+	return s.save()
+}
+
+// Reset forgets the copied volumes, so they are all copied again.
+func (s *MigrationState) Reset() error {
+	s.Volumes = nil
+	s.Copying = ""
+	return s.save()
+}
+
+func (s *MigrationState) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create migration state directory: %w", err)
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal migration state: %w", err)
+	}
+	if err := os.WriteFile(s.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write migration state: %w", err)
+	}
+	return nil
+}
+
+// CopyVolume copies the files of the Docker volume from the server of source to the server of
+// target and returns the size of the archive. The archive is downloaded into a temporary file
+// in dir, or in the default temporary directory when dir is empty, so that a broken download
+// never reaches the target. Any files of the volume on the target are replaced.
+func CopyVolume(ctx context.Context, source, target Runner, volume, dir string) (int64, error) {
+	file, err := os.CreateTemp(dir, volume+"-*.tar.gz")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create the archive of volume %s: %w", volume, err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := BackupVolume(ctx, source, volume, file); err != nil {
+		return 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the archive of volume %s: %w", volume, err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to read the archive of volume %s: %w", volume, err)
+	}
+	if err := RestoreVolume(ctx, target, volume, file); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
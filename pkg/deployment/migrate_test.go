@@ -0,0 +1,80 @@
+package deployment
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationState(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	state, err := LoadMigrationState("shop", "new.example.com")
+	require.NoError(t, err)
+	assert.False(t, state.Copied("shop-uploads"))
+
+	require.NoError(t, state.MarkCopying("shop-uploads"))
+	state, err = LoadMigrationState("shop", "new.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "shop-uploads", state.Copying)
+	assert.False(t, state.Copied("shop-uploads"))
+
+	require.NoError(t, state.MarkCopied("shop-uploads"))
+	require.NoError(t, state.MarkCopied("shop-uploads"))
+
+	state, err = LoadMigrationState("shop", "new.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shop-uploads"}, state.Volumes)
+	assert.Empty(t, state.Copying)
+
+	other, err := LoadMigrationState("shop", "other.example.com")
+	require.NoError(t, err)
+	assert.Empty(t, other.Volumes)
+
+	require.NoError(t, state.Reset())
+	state, err = LoadMigrationState("shop", "new.example.com")
+	require.NoError(t, err)
+	assert.False(t, state.Copied("shop-uploads"))
+}
+
+func TestCopyVolume(t *testing.T) {
+	archive := testVolumeArchive(t, map[string]string{"./avatar.png": "png"})
+	source := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if command == "docker" {
+			return "shop-uploads", nil
+		}
+		return string(archive), nil
+	}}
+	target := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if command == "sh" {
+			return volumeRestored, nil
+		}
+		return "", nil
+	}}
+	dir := t.TempDir()
+
+	size, err := CopyVolume(context.Background(), source, target, "shop-uploads", dir)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(archive)), size)
+	assert.Equal(t, string(archive), target.inputs[1])
+
+	// A broken download never reaches the target, and no archive is left behind.
+	source.handler = func(command string, args []string) (string, error) {
+		if command == "docker" {
+			return "shop-uploads", nil
+		}
+		return string(archive[:len(archive)/2]), nil
+	}
+	target = &fakeRunner{}
+	_, err = CopyVolume(context.Background(), source, target, "shop-uploads", dir)
+	assert.ErrorContains(t, err, "the archive is incomplete or corrupt")
+	assert.Empty(t, target.executed())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
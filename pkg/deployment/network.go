@@ -2,12 +2,30 @@ package deployment
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"sort"
 	"strings"
+
+	dockernetwork "github.com/docker/docker/api/types/network"
+
+	"github.com/yarlson/ftl/pkg/config"
 )
 
-func (d *Deployment) networkExists(network string) (bool, error) {
-	output, err := d.runCommand(context.Background(), "docker", "network", "ls", "--format", "{{.Name}}")
+// RecreateNetwork lets Deploy recreate the project network when its subnet differs from the
+// configured one. The containers on the network are disconnected and reconnected one at a time.
+func (d *Deployment) RecreateNetwork(recreate bool) {
+	d.recreateNetwork = recreate
+}
+
+func (d *Deployment) networkExists(ctx context.Context, network string) (bool, error) {
+	if d.docker != nil {
+		return d.apiNetworkExists(ctx, network)
+	}
+
+	output, err := d.runCommand(ctx, "docker", "network", "ls", "--format", "{{.Name}}")
 	if err != nil {
 		return false, fmt.Errorf("failed to list Docker networks: %w", err)
 	}
@@ -21,20 +39,223 @@ func (d *Deployment) networkExists(network string) (bool, error) {
 	return false, nil
 }
 
-func (d *Deployment) createNetwork(network string) error {
-	exists, err := d.networkExists(network)
+// createNetwork creates the network with options unless it exists. The subnet of an existing
+// network is compared with the configured one, and the network is recreated when they differ
+// and RecreateNetwork allows it.
+func (d *Deployment) createNetwork(ctx context.Context, network string, options *config.Network) error {
+	exists, err := d.networkExists(ctx, network)
 	if err != nil {
 		return fmt.Errorf("failed to check if network exists: %w", err)
 	}
 
 	if exists {
-		return nil
+		return d.checkNetworkSubnet(ctx, network, options)
 	}
 
-	_, err = d.runCommand(context.Background(), "docker", "network", "create", network)
+	return d.newNetwork(ctx, network, options)
+}
+
+func (d *Deployment) newNetwork(ctx context.Context, network string, options *config.Network) error {
+	var err error
+	if d.docker != nil {
+		err = d.retry(ctx, "creating network "+network, func() error {
+			_, err := d.docker.NetworkCreate(ctx, network, networkCreateOptions(options))
+			return err
+		})
+	} else {
+		_, err = d.retryCommand(ctx, "creating network "+network, "docker", networkCreateArgs(network, options)...)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create network: %w", err)
 	}
 
 	return nil
 }
+
+// networkCreateArgs returns the docker arguments creating network with options.
+func networkCreateArgs(network string, options *config.Network) []string {
+	args := []string{"network", "create"}
+	if options != nil {
+		if options.Subnet != "" {
+			args = append(args, "--subnet", options.Subnet)
+		}
+		if options.Gateway != "" {
+			args = append(args, "--gateway", options.Gateway)
+		}
+		if options.Attachable {
+			args = append(args, "--attachable")
+		}
+		keys := make([]string, 0, len(options.DriverOpts))
+		for key := range options.DriverOpts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			args = append(args, "--opt", key+"="+options.DriverOpts[key])
+		}
+	}
+	return append(args, network)
+}
+
+// networkCreateOptions returns the Docker API options creating a network with options.
+func networkCreateOptions(options *config.Network) dockernetwork.CreateOptions {
+	if options == nil {
+		return dockernetwork.CreateOptions{}
+	}
+
+	create := dockernetwork.CreateOptions{Attachable: options.Attachable, Options: options.DriverOpts}
+	if options.Subnet != "" {
+		create.IPAM = &dockernetwork.IPAM{Config: []dockernetwork.IPAMConfig{{Subnet: options.Subnet, Gateway: options.Gateway}}}
+	}
+	return create
+}
+
+// checkNetworkSubnet compares the subnets of an existing network with the configured subnet.
+// A mismatch is reported as a warning, or fixed by recreating the network when
+// RecreateNetwork allows it.
+func (d *Deployment) checkNetworkSubnet(ctx context.Context, network string, options *config.Network) error {
+	if options == nil || options.Subnet == "" {
+		return nil
+	}
+
+	subnets, err := d.networkSubnets(ctx, network)
+	if err != nil {
+		return err
+	}
+	for _, subnet := range subnets {
+		if sameSubnet(subnet, options.Subnet) {
+			return nil
+		}
+	}
+
+	if !d.recreateNetwork {
+		d.warn("network", "", nil, "Network %s has subnet %s instead of %s; deploy with --recreate-network to recreate it",
+			network, strings.Join(subnets, ", "), options.Subnet)
+		return nil
+	}
+	return d.recreateProjectNetwork(ctx, network, options)
+}
+
+// sameSubnet reports whether two CIDR ranges are equal, ignoring host bits and notation.
+func sameSubnet(a, b string) bool {
+	_, aNet, aErr := net.ParseCIDR(a)
+	_, bNet, bErr := net.ParseCIDR(b)
+	if aErr != nil || bErr != nil {
+		return a == b
+	}
+	return aNet.String() == bNet.String()
+}
+
+func (d *Deployment) networkSubnets(ctx context.Context, network string) ([]string, error) {
+	if d.docker != nil {
+		inspect, err := d.docker.NetworkInspect(ctx, network, dockernetwork.InspectOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect network %s: %w", network, err)
+		}
+		var subnets []string
+		for _, ipam := range inspect.IPAM.Config {
+			subnets = append(subnets, ipam.Subnet)
+		}
+		return subnets, nil
+	}
+
+	output, err := d.runCommand(ctx, "docker", "network", "inspect", "--format", "{{range .IPAM.Config}}{{.Subnet}} {{end}}", network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect network %s: %w", network, err)
+	}
+	if strings.Contains(output, "Error") {
+		return nil, fmt.Errorf("failed to inspect network %s: %s", network, output)
+	}
+	return strings.Fields(output), nil
+}
+
+// networkContainer is a container on the network being recreated, with its aliases there.
+type networkContainer struct {
+	name    string
+	aliases []string
+}
+
+// recreateProjectNetwork disconnects the containers from the network one at a time, recreates
+// the network with options and reconnects them with their aliases, again one at a time.
+func (d *Deployment) recreateProjectNetwork(ctx context.Context, network string, options *config.Network) error {
+	containers, err := d.networkContainers(ctx, network)
+	if err != nil {
+		return err
+	}
+
+	for i, c := range containers {
+		if err := d.networkCommand(ctx, "disconnect", network, c.name); err != nil {
+			return errors.Join(err, d.reconnectContainers(ctx, network, containers[:i]))
+		}
+	}
+
+	if err := d.networkCommand(ctx, "rm", network); err != nil {
+		return errors.Join(err, d.reconnectContainers(ctx, network, containers))
+	}
+	if err := d.newNetwork(ctx, network, options); err != nil {
+		return fmt.Errorf("%w; containers %s are disconnected from the removed network %s", err, containerNames(containers), network)
+	}
+
+	return d.reconnectContainers(ctx, network, containers)
+}
+
+// networkContainers returns the containers on the network with their aliases there.
+func (d *Deployment) networkContainers(ctx context.Context, network string) ([]networkContainer, error) {
+	output, err := d.runCommand(ctx, "docker", "network", "inspect", "--format", "{{range .Containers}}{{.Name}} {{end}}", network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers of network %s: %w", network, err)
+	}
+	if strings.Contains(output, "Error") {
+		return nil, fmt.Errorf("failed to list containers of network %s: %s", network, output)
+	}
+
+	var containers []networkContainer
+	for _, name := range strings.Fields(output) {
+		output, err := d.runCommand(ctx, "docker", "inspect", "--format",
+			fmt.Sprintf("{{json (index .NetworkSettings.Networks %q).Aliases}}", network), name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect container %s: %w", name, err)
+		}
+		var aliases []string
+		if err := json.Unmarshal([]byte(output), &aliases); err != nil {
+			return nil, fmt.Errorf("failed to read aliases of container %s: %s", name, output)
+		}
+		containers = append(containers, networkContainer{name: name, aliases: aliases})
+	}
+	return containers, nil
+}
+
+// reconnectContainers connects the containers to the network with their aliases, one at a time.
+func (d *Deployment) reconnectContainers(ctx context.Context, network string, containers []networkContainer) error {
+	var errs []error
+	for _, c := range containers {
+		var args []string
+		for _, alias := range c.aliases {
+			args = append(args, "--alias", alias)
+		}
+		if err := d.networkCommand(ctx, "connect", append(args, network, c.name)...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// networkCommand runs docker network with the subcommand and arguments.
+func (d *Deployment) networkCommand(ctx context.Context, subcommand string, args ...string) error {
+	output, err := d.runCommand(ctx, "docker", append([]string{"network", subcommand}, args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to run docker network %s: %w", subcommand, err)
+	}
+	if strings.Contains(output, "Error") {
+		return fmt.Errorf("failed to run docker network %s %s: %s", subcommand, strings.Join(args, " "), output)
+	}
+	return nil
+}
+
+func containerNames(containers []networkContainer) string {
+	names := make([]string, len(containers))
+	for i, c := range containers {
+		names[i] = c.name
+	}
+	return strings.Join(names, ", ")
+}
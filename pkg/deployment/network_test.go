@@ -0,0 +1,123 @@
+package deployment
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestCreateNetwork_Options(t *testing.T) {
+	options := &config.Network{
+		Subnet:     "172.28.0.0/16",
+		Gateway:    "172.28.0.1",
+		Attachable: true,
+		DriverOpts: map[string]string{"com.docker.network.driver.mtu": "1400", "com.docker.network.bridge.name": "br-shop"},
+	}
+
+	runner := &fakeRunner{}
+	d := NewDeployment(runner, nil)
+	require.NoError(t, d.createNetwork(context.Background(), "shop", options))
+	assert.Equal(t, []string{
+		"docker network ls --format {{.Name}}",
+		"docker network create --subnet 172.28.0.0/16 --gateway 172.28.0.1 --attachable " +
+			"--opt com.docker.network.bridge.name=br-shop --opt com.docker.network.driver.mtu=1400 shop",
+	}, runner.executed())
+
+	assert.Equal(t, network.CreateOptions{
+		Attachable: true,
+		Options:    options.DriverOpts,
+		IPAM:       &network.IPAM{Config: []network.IPAMConfig{{Subnet: "172.28.0.0/16", Gateway: "172.28.0.1"}}},
+	}, networkCreateOptions(options))
+	assert.Equal(t, network.CreateOptions{}, networkCreateOptions(nil))
+}
+
+func TestCreateNetwork_SubnetMismatch(t *testing.T) {
+	api := &fakeDockerAPI{networks: []string{"shop"}, subnets: map[string]string{"shop": "172.18.0.0/16"}}
+	d := NewDeployment(&fakeRunner{}, nil)
+	d.UseDockerAPI(api)
+	d.events = make(chan Event, eventBuffer)
+
+	require.NoError(t, d.createNetwork(context.Background(), "shop", &config.Network{Subnet: "172.18.0.0/16"}))
+	require.NoError(t, d.createNetwork(context.Background(), "shop", &config.Network{Subnet: "172.28.0.0/16"}))
+	close(d.events)
+
+	var warnings []string
+	for event := range d.events {
+		warnings = append(warnings, event.Message)
+	}
+	assert.Equal(t, []string{"Network shop has subnet 172.18.0.0/16 instead of 172.28.0.0/16; deploy with --recreate-network to recreate it"}, warnings)
+	assert.Equal(t, []string{"shop"}, api.networks)
+}
+
+func TestCreateNetwork_Recreate(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		switch {
+		case args[0] == "network" && args[1] == "ls":
+			return "bridge\nshop", nil
+		case args[0] == "network" && args[1] == "inspect" && strings.Contains(args[3], "IPAM"):
+			return "172.18.0.0/16 ", nil
+		case args[0] == "network" && args[1] == "inspect":
+			return "shop-web shop-postgres ", nil
+		case args[0] == "inspect" && args[len(args)-1] == "shop-web":
+			return `["web","admin","3f2a1b"]`, nil
+		case args[0] == "inspect":
+			return `["postgres"]`, nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+	d.RecreateNetwork(true)
+
+	require.NoError(t, d.createNetwork(context.Background(), "shop", &config.Network{Subnet: "172.28.0.0/16"}))
+	assert.Equal(t, []string{
+		"docker network ls --format {{.Name}}",
+		"docker network inspect --format {{range .IPAM.Config}}{{.Subnet}} {{end}} shop",
+		"docker network inspect --format {{range .Containers}}{{.Name}} {{end}} shop",
+		`docker inspect --format {{json (index .NetworkSettings.Networks "shop").Aliases}} shop-web`,
+		`docker inspect --format {{json (index .NetworkSettings.Networks "shop").Aliases}} shop-postgres`,
+		"docker network disconnect shop shop-web",
+		"docker network disconnect shop shop-postgres",
+		"docker network rm shop",
+		"docker network create --subnet 172.28.0.0/16 shop",
+		"docker network connect --alias web --alias admin --alias 3f2a1b shop shop-web",
+		"docker network connect --alias postgres shop shop-postgres",
+	}, runner.executed())
+}
+
+func TestCreateNetwork_RecreateFailsToRemove(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		switch {
+		case args[0] == "network" && args[1] == "ls":
+			return "shop", nil
+		case args[0] == "network" && args[1] == "inspect" && strings.Contains(args[3], "IPAM"):
+			return "172.18.0.0/16", nil
+		case args[0] == "network" && args[1] == "inspect":
+			return "shop-web", nil
+		case args[0] == "inspect":
+			return `["web"]`, nil
+		case args[0] == "network" && args[1] == "rm":
+			return "Error response from daemon: error while removing network: network shop has active endpoints", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+	d.RecreateNetwork(true)
+
+	err := d.createNetwork(context.Background(), "shop", &config.Network{Subnet: "172.28.0.0/16"})
+	assert.ErrorContains(t, err, "has active endpoints")
+	executed := runner.executed()
+	assert.Equal(t, "docker network connect --alias web shop shop-web", executed[len(executed)-1])
+}
+
+func TestSameSubnet(t *testing.T) {
+	assert.True(t, sameSubnet("172.28.0.0/16", "172.28.0.0/16"))
+	assert.True(t, sameSubnet("172.28.5.0/16", "172.28.0.0/16"))
+	assert.True(t, sameSubnet("fd00:0::/64", "fd00::/64"))
+	assert.False(t, sameSubnet("172.28.0.0/16", "172.28.0.0/24"))
+}
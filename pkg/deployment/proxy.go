@@ -1,17 +1,47 @@
 package deployment
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/yarlson/ftl/pkg/config"
 	"github.com/yarlson/ftl/pkg/proxy"
+	"github.com/yarlson/ftl/pkg/runner/remote"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// placeholderCertValidity is short so the certificate manager renews placeholders right away.
+const placeholderCertValidity = 24 * time.Hour
+
+// selfSignedCertValidity is the lifetime of certificates created for `tls: self_signed`.
+const selfSignedCertValidity = 365 * 24 * time.Hour
+
+// nginxConfigFile is the generated configuration in the nginx config directory.
+const nginxConfigFile = "default.conf"
+
+// nginxCandidateFile is a new configuration waiting to be tested. Its name doesn't match the
+// *.conf files nginx loads, so the running proxy never picks it up.
+const nginxCandidateFile = nginxConfigFile + ".new"
+
+// candidateConfigPath is where the nginx config directory is mounted while a candidate
+// configuration is tested.
+const candidateConfigPath = "/candidate"
+
+// proxyReloaded is printed once nginx has accepted and loaded a new configuration.
+const proxyReloaded = "proxy-reloaded"
+
+// proxyConfigValid is printed once nginx has accepted a new configuration.
+const proxyConfigValid = "proxy-config-valid"
+
 func (d *Deployment) startProxy(ctx context.Context, project string, cfg *config.Config) error {
-	hostname := d.runner.Host()
+	if warning := cfg.PlainHTTPWarning(); warning != "" {
+		d.warn("tls", "", nil, "%s", warning)
+	}
 
 	// Prepare project folder
 	projectPath, err := d.prepareProjectFolder(project)
@@ -20,48 +50,114 @@ func (d *Deployment) startProxy(ctx context.Context, project string, cfg *config
 	}
 
 	// Prepare nginx config
-	spinner := d.sm.AddSpinner("config", fmt.Sprintf("[%s] Preparing Nginx configuration", hostname))
-	configPath, err := d.prepareNginxConfig(cfg, projectPath)
+	step := d.startStep("config", "", "Preparing Nginx configuration")
+	configPath, configChanged, err := d.prepareNginxConfig(ctx, cfg, projectPath)
 	if err != nil {
-		spinner.Error()
+		step.fail(err)
 		return fmt.Errorf("failed to prepare nginx config: %w", err)
 	}
-	spinner.Complete()
+	authChanged, err := d.prepareAuthFiles(ctx, cfg, configPath, os.Getenv)
+	if err != nil {
+		step.fail(err)
+		return fmt.Errorf("failed to prepare basic authentication: %w", err)
+	}
+	htmlPath, err := d.prepareMaintenancePage(ctx, cfg, projectPath)
+	if err != nil {
+		step.fail(err)
+		return fmt.Errorf("failed to prepare maintenance page: %w", err)
+	}
+	step.complete()
 
-	spinner = d.sm.AddSpinner("zero", fmt.Sprintf("[%s] Deploying Zero certificate manager", hostname))
-	if err := d.deployZero(project, cfg); err != nil {
-		spinner.Error()
-		return fmt.Errorf("failed to deploy Zero certificate manager: %w", err)
+	certsChanged := false
+	if !cfg.Project.PlainHTTP() {
+		step = d.startStep("certs", "", "Preparing certificates")
+		if cfg.Project.TLS != nil {
+			certsChanged, err = d.installCertificates(ctx, project, projectPath, cfg.Project.TLS, cfg.CertificateDomains())
+		} else {
+			err = d.ensureCertificates(ctx, project, projectPath, cfg.CertificateDomains())
+		}
+		if err != nil {
+			step.fail(err)
+			return fmt.Errorf("failed to prepare certificates: %w", err)
+		}
+		step.complete()
+	}
+
+	if cfg.Project.TLS == nil {
+		step = d.startStep("zero", "", "Deploying Zero certificate manager")
+		if err := d.deployZero(ctx, project, cfg); err != nil {
+			step.fail(err)
+			return fmt.Errorf("failed to deploy Zero certificate manager: %w", err)
+		}
+		step.complete()
+	} else if err := d.removeZero(ctx, project); err != nil {
+		return fmt.Errorf("failed to remove Zero certificate manager: %w", err)
+	}
+
+	step = d.startStep("proxy", "", "Deploying proxy service")
+	service := proxyService(cfg, configPath, htmlPath)
+
+	if configChanged {
+		if err := d.installNginxConfig(ctx, project, service, configPath); err != nil {
+			step.fail(err)
+			return err
+		}
+	}
+	if err := d.deployProxy(ctx, project, service, configPath, configChanged || authChanged || certsChanged); err != nil {
+		step.fail(err)
+		return err
+	}
+	step.complete()
+
+	return nil
+}
+
+// proxyService returns the nginx service of the project, which publishes the proxy ports and
+// checks its health over HTTPS, or over HTTP with `tls: disabled`.
+func proxyService(cfg *config.Config, configPath, htmlPath string) *config.Service {
+	healthCheck := "curl -k https://localhost/"
+	if cfg.Project.PlainHTTP() {
+		healthCheck = "curl http://localhost/"
 	}
-	spinner.Complete()
 
-	spinner = d.sm.AddSpinner("proxy", fmt.Sprintf("[%s] Deploying proxy service", hostname))
 	service := &config.Service{
 		Name:  "proxy",
 		Image: "nginx:alpine",
 		Volumes: []string{
 			"certs:/etc/nginx/certs:ro",
-			configPath + ":/etc/nginx/conf.d:ro",
-		},
-		Forwards: []string{
-			"443:443",
+			configPath + ":" + proxy.ConfigPath + ":ro",
+			htmlPath + ":" + proxy.HTMLPath + ":ro",
 		},
 		Container: &config.Container{
 			HealthCheck: &config.ContainerHealthCheck{
-				Cmd:      "curl -k https://localhost/",
-				Interval: "10s",
+				Cmd:      healthCheck,
+				Interval: config.Duration(10 * time.Second),
 				Retries:  3,
-				Timeout:  "5s",
+				Timeout:  config.Duration(5 * time.Second),
 			},
 		},
 		Recreate: true,
 	}
+	for _, port := range cfg.Project.ProxyPorts() {
+		service.Forwards = append(service.Forwards, fmt.Sprintf("%d:%d", port, port))
+	}
+	return service
+}
 
-	if err := d.deployService(project, service); err != nil {
-		spinner.Error()
+// deployProxy creates or replaces the proxy container when its definition changed. A proxy
+// that keeps running still serves its previous configuration, so it is reloaded when
+// configChanged; unlike recreating the container, a reload keeps open connections.
+func (d *Deployment) deployProxy(ctx context.Context, project string, service *config.Service, configPath string, configChanged bool) error {
+	restarted, err := d.ensureService(ctx, project, service)
+	if err != nil {
 		return fmt.Errorf("failed to deploy proxy service: %w", err)
 	}
-	spinner.Complete()
+
+	if !restarted && configChanged {
+		if err := d.reloadProxy(ctx, project, configPath); err != nil {
+			return fmt.Errorf("failed to reload proxy: %w", err)
+		}
+	}
 
 	return nil
 }
@@ -74,34 +170,295 @@ func (d *Deployment) prepareProjectFolder(project string) (string, error) {
 	return d.projectFolder(project)
 }
 
-func (d *Deployment) prepareNginxConfig(cfg *config.Config, projectPath string) (string, error) {
-	nginxConfig, err := proxy.GenerateNginxConfig(cfg)
+// prepareNginxConfig writes the generated nginx config into the project's nginx directory as
+// default.conf.new when it differs from the one already on the server, comparing their hashes,
+// and reports whether it does. installNginxConfig puts it in place once nginx accepted it.
+func (d *Deployment) prepareNginxConfig(ctx context.Context, cfg *config.Config, projectPath string) (string, bool, error) {
+	nginxConfig, err := proxy.GenerateNginxConfigWithCanaries(cfg, d.canaries())
 	if err != nil {
-		return "", fmt.Errorf("failed to generate nginx config: %w", err)
+		return "", false, fmt.Errorf("failed to generate nginx config: %w", err)
 	}
 
 	nginxConfig = strings.TrimSpace(nginxConfig)
 
 	configPath := filepath.Join(projectPath, "nginx")
-	_, err = d.runCommand(context.Background(), "mkdir", "-p", configPath)
+	_, err = d.runCommand(ctx, "mkdir", "-p", configPath)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create nginx config directory: %w", err)
+	}
+
+	configFile := filepath.Join(configPath, nginxConfigFile)
+	output, err := d.runCommand(ctx, "sh", "-c", fmt.Sprintf("sha256sum %s 2>/dev/null || true", configFile))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to hash nginx config: %w", err)
+	}
+	if fields := strings.Fields(output); len(fields) > 0 && fields[0] == configHash(nginxConfig) {
+		return configPath, false, nil
+	}
+
+	return configPath, true, d.copyContent(ctx, []byte(nginxConfig), filepath.Join(configPath, nginxCandidateFile))
+}
+
+// installNginxConfig tests the candidate configuration written by prepareNginxConfig and
+// replaces the live one with it. The live configuration is kept as default.conf.bak for
+// reloadProxy to roll back to. A candidate nginx rejects is removed and the live configuration
+// is left untouched, so the running proxy keeps serving it.
+func (d *Deployment) installNginxConfig(ctx context.Context, project string, service *config.Service, configPath string) error {
+	configFile := filepath.Join(configPath, nginxConfigFile)
+	candidateFile := filepath.Join(configPath, nginxCandidateFile)
+
+	output, err := d.testProxyConfig(ctx, project, service, configPath)
+	if err != nil {
+		return fmt.Errorf("failed to test the proxy configuration: %w", err)
+	}
+	if !strings.Contains(output, proxyConfigValid) {
+		if _, err := d.runCommand(ctx, "rm", "-f", candidateFile); err != nil {
+			return fmt.Errorf("failed to remove the rejected nginx configuration: %w", err)
+		}
+		return fmt.Errorf("nginx rejected the new configuration, keeping the previous one:\n%s", strings.TrimSpace(output))
+	}
+
+	if _, err := d.runCommand(ctx, "sh", "-c", fmt.Sprintf("{ [ ! -f %[1]s ] || cp %[1]s %[1]s.bak; } && mv %[2]s %[1]s", configFile, candidateFile)); err != nil {
+		return fmt.Errorf("failed to install nginx config: %w", err)
+	}
+	return nil
+}
+
+// configHash returns the hex SHA-256 of content, as printed by sha256sum.
+func configHash(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+// reloadProxy makes the running proxy load its new configuration without dropping connections.
+// When nginx rejects the configuration, the previous one is put back and nginx keeps serving it.
+func (d *Deployment) reloadProxy(ctx context.Context, project, configPath string) error {
+	output, err := d.runCommand(ctx, "docker", "exec", containerName(project, "proxy", ""),
+		"sh", "-c", "nginx -t 2>&1 && nginx -s reload 2>&1 && echo "+proxyReloaded)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(output, proxyReloaded) {
+		return nil
+	}
+	return d.rejectProxyConfig(ctx, configPath, output)
+}
+
+// testProxyConfig runs nginx -t on the candidate configuration in a temporary container that
+// mounts the volumes of the proxy and joins the project network, where the upstream servers are
+// resolved. The nginx config directory is mounted read-only elsewhere and copied into place
+// with the candidate as default.conf. It returns the output of nginx, which ends with
+// proxyConfigValid when the candidate was accepted.
+func (d *Deployment) testProxyConfig(ctx context.Context, project string, service *config.Service, configPath string) (string, error) {
+	volumes := make([]string, 0, len(service.Volumes))
+	for _, volume := range service.Volumes {
+		if strings.HasPrefix(volume, configPath+":") {
+			volume = configPath + ":" + candidateConfigPath + ":ro"
+		}
+		volumes = append(volumes, volume)
+	}
+
+	script := fmt.Sprintf("cp -r %[1]s/. %[2]s/ && mv %[2]s/%[3]s %[2]s/%[4]s && nginx -t 2>&1 && echo %[5]s",
+		candidateConfigPath, proxy.ConfigPath, nginxCandidateFile, nginxConfigFile, proxyConfigValid)
+	args := append([]string{"run", "--rm", "--network", project}, volumeArgs(project, volumes)...)
+	args = append(args, service.Image, "sh", "-c", script)
+	return d.runCommand(ctx, "docker", args...)
+}
+
+// rejectProxyConfig puts the previous configuration back after nginx rejected the new one with
+// output. Without a previous configuration the rejected one is removed, so that the next deploy
+// tests its configuration again.
+func (d *Deployment) rejectProxyConfig(ctx context.Context, configPath, output string) error {
+	configFile := filepath.Join(configPath, nginxConfigFile)
+	if _, err := d.runCommand(ctx, "sh", "-c", fmt.Sprintf("if [ -f %[1]s.bak ]; then mv %[1]s.bak %[1]s; else rm -f %[1]s; fi", configFile)); err != nil {
+		return fmt.Errorf("nginx rejected the new configuration and restoring the previous one failed: %w", err)
+	}
+	return fmt.Errorf("nginx rejected the new configuration, keeping the previous one:\n%s", strings.TrimSpace(output))
+}
+
+// prepareAuthFiles writes the htpasswd file of every route with basic authentication into the
+// nginx config directory and reports whether any of them changed. Passwords are read with getenv
+// and only their bcrypt hashes are written.
+func (d *Deployment) prepareAuthFiles(ctx context.Context, cfg *config.Config, configPath string, getenv func(string) string) (bool, error) {
+	changed := false
+	for _, service := range cfg.Services {
+		for i, route := range service.Routes {
+			if route.Auth == nil {
+				continue
+			}
+
+			password := getenv(route.Auth.PasswordEnv)
+			if password == "" {
+				return false, fmt.Errorf("environment variable %s with the password for route %s of service %s is not set",
+					route.Auth.PasswordEnv, route.PathPrefix, service.Name)
+			}
+
+			authFile := filepath.Join(configPath, proxy.HtpasswdFile(service.Name, i))
+			existing, err := d.runCommand(ctx, "sh", "-c", fmt.Sprintf("cat %s 2>/dev/null || true", authFile))
+			if err != nil {
+				return false, fmt.Errorf("failed to read %s: %w", authFile, err)
+			}
+
+			htpasswd, err := proxy.Htpasswd(route.Auth.Username, password, []byte(existing))
+			if err != nil {
+				return false, err
+			}
+			if strings.TrimSpace(string(htpasswd)) == existing {
+				continue
+			}
+			if err := d.copyContent(ctx, htpasswd, authFile); err != nil {
+				return false, err
+			}
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
+
+// prepareMaintenancePage writes the page the proxy serves while a service is unavailable
+// and returns the directory holding it.
+func (d *Deployment) prepareMaintenancePage(ctx context.Context, cfg *config.Config, projectPath string) (string, error) {
+	page, err := proxy.MaintenancePage(cfg.Proxy.MaintenancePage)
 	if err != nil {
-		return "", fmt.Errorf("failed to create nginx config directory: %w", err)
+		return "", err
 	}
 
-	tmpFile, err := os.CreateTemp("", "nginx-config-*.conf")
+	htmlPath := filepath.Join(projectPath, "html")
+	if _, err := d.runCommand(ctx, "mkdir", "-p", htmlPath); err != nil {
+		return "", fmt.Errorf("failed to create html directory: %w", err)
+	}
+
+	return htmlPath, d.copyContent(ctx, page, filepath.Join(htmlPath, proxy.MaintenancePageFile))
+}
+
+// ensureCertificates puts a short-lived self-signed certificate into the certs volume for every
+// domain that has none yet, so nginx can start before the certificate manager has obtained the
+// real ones. The certificate manager replaces them and reloads the proxy.
+func (d *Deployment) ensureCertificates(ctx context.Context, project, projectPath string, domains []string) error {
+	files, err := d.placeholderCertificates(ctx, project, domains, placeholderCertValidity)
 	if err != nil {
-		return "", fmt.Errorf("failed to create temporary file: %w", err)
+		return err
 	}
-	defer os.Remove(tmpFile.Name())
 
-	if _, err := tmpFile.WriteString(nginxConfig); err != nil {
-		return "", fmt.Errorf("failed to write nginx config to temporary file: %w", err)
+	_, err = d.copyCertificates(ctx, project, projectPath, files, false)
+	return err
+}
+
+// installCertificates puts the certificates configured with project.tls into the certs volume
+// and reports whether the volume changed. Provided certificates are used for every domain and
+// replace the ones in the volume; self-signed certificates are only created for domains without one.
+func (d *Deployment) installCertificates(ctx context.Context, project, projectPath string, tlsConfig *config.TLS, domains []string) (bool, error) {
+	if tlsConfig.SelfSigned {
+		files, err := d.placeholderCertificates(ctx, project, domains, selfSignedCertValidity)
+		if err != nil {
+			return false, err
+		}
+		return d.copyCertificates(ctx, project, projectPath, files, false)
+	}
+
+	certPEM, keyPEM, err := proxy.LoadCertificate(tlsConfig.CertFile, tlsConfig.KeyFile)
+	if err != nil {
+		return false, err
+	}
+
+	var files []certificateFile
+	for _, domain := range domains {
+		files = append(files,
+			certificateFile{name: domain + ".crt", data: certPEM},
+			certificateFile{name: domain + ".key", data: keyPEM},
+		)
 	}
+	return d.copyCertificates(ctx, project, projectPath, files, true)
+}
 
-	return configPath, d.runner.CopyFile(context.Background(), tmpFile.Name(), filepath.Join(configPath, "default.conf"))
+// certificateFile is a file in the certs volume.
+type certificateFile struct {
+	name string
+	data []byte
 }
 
-func (d *Deployment) deployZero(project string, cfg *config.Config) error {
+// placeholderCertificates returns self-signed certificates for the domains that have no
+// certificate in the certs volume yet.
+func (d *Deployment) placeholderCertificates(ctx context.Context, project string, domains []string, validFor time.Duration) ([]certificateFile, error) {
+	existing, err := d.runCommand(ctx, "docker", "run", "--rm", "-v", certsVolume(project), "nginx:alpine", "ls", "/certs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list certificates: %w", err)
+	}
+	present := make(map[string]bool)
+	for _, name := range strings.Fields(existing) {
+		present[name] = true
+	}
+
+	var files []certificateFile
+	for _, domain := range domains {
+		if present[domain+".crt"] && present[domain+".key"] {
+			continue
+		}
+
+		certPEM, keyPEM, err := proxy.SelfSignedCertificate(domain, validFor)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files,
+			certificateFile{name: domain + ".crt", data: certPEM},
+			certificateFile{name: domain + ".key", data: keyPEM},
+		)
+	}
+	return files, nil
+}
+
+// copyCertificates copies files into the certs volume through a temporary directory in the
+// project folder and reports whether any file was written. Existing files are only replaced
+// when replace is set and their content differs.
+func (d *Deployment) copyCertificates(ctx context.Context, project, projectPath string, files []certificateFile, replace bool) (bool, error) {
+	if len(files) == 0 {
+		return false, nil
+	}
+
+	bootstrapPath := filepath.Join(projectPath, "certs-bootstrap")
+	if _, err := d.runCommand(ctx, "mkdir", "-p", bootstrapPath); err != nil {
+		return false, fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+	defer func() { _, _ = d.runCommand(context.Background(), "rm", "-rf", bootstrapPath) }()
+
+	for _, file := range files {
+		if err := d.copyContent(ctx, file.data, filepath.Join(bootstrapPath, file.name)); err != nil {
+			return false, err
+		}
+	}
+
+	condition := `[ -e "/certs/${f##*/}" ]`
+	if replace {
+		condition = `cmp -s "$f" "/certs/${f##*/}"`
+	}
+	output, err := d.runCommand(ctx, "docker", "run", "--rm", "-v", certsVolume(project), "-v", bootstrapPath+":/bootstrap:ro", "nginx:alpine",
+		"sh", "-c", fmt.Sprintf(`for f in /bootstrap/*; do %s || { cp "$f" /certs/ && echo copied; }; done`, condition))
+	if err != nil {
+		return false, fmt.Errorf("failed to copy certificates: %w", err)
+	}
+	return strings.Contains(output, "copied"), nil
+}
+
+// certsVolume returns the mount of the project's certs volume used to access certificates.
+func certsVolume(project string) string {
+	return fmt.Sprintf("%s-certs:/certs", project)
+}
+
+// copyContent writes data to a file on the server, retrying on transient errors.
+func (d *Deployment) copyContent(ctx context.Context, data []byte, remotePath string) error {
+	return d.copyContentWithMode(ctx, data, remotePath, 0)
+}
+
+// copyContentWithMode copies data to remotePath with the permissions mode, or the default ones
+// of the runner when mode is zero.
+func (d *Deployment) copyContentWithMode(ctx context.Context, data []byte, remotePath string, mode os.FileMode) error {
+	return d.retry(ctx, "copying "+remotePath, func() error {
+		return d.runner.CopyReader(ctx, bytes.NewReader(data), remotePath, remote.CopyOptions{Mode: mode, Size: int64(len(data))})
+	})
+}
+
+func (d *Deployment) deployZero(ctx context.Context, project string, cfg *config.Config) error {
 	service := &config.Service{
 		Name:  "zero",
 		Image: "yarlson/zero:1",
@@ -109,27 +466,40 @@ func (d *Deployment) deployZero(project string, cfg *config.Config) error {
 			"certs:/certs",
 			"/var/run/docker.sock:/var/run/docker.sock",
 		},
-		Forwards: []string{
-			"80:80",
-		},
-		CommandSlice: []string{
-			"-d",
-			cfg.Project.Domain,
-			"-e",
-			cfg.Project.Email,
-			"-c",
-			"/certs",
-			"--hook",
-			"nginx -s reload",
-			"--hook-container",
-			"proxy",
-		},
-		Recreate: true,
+		CommandSlice: zeroCommand(cfg),
+		Recreate:     true,
 	}
 
-	if err := d.deployService(project, service); err != nil {
+	if err := d.deployService(ctx, project, service); err != nil {
 		return fmt.Errorf("failed to deploy certrenewer service: %w", err)
 	}
 
 	return nil
 }
+
+// removeZero removes the certificate manager left from deploys that used ACME certificates,
+// so it doesn't replace the certificates provided with project.tls.
+func (d *Deployment) removeZero(ctx context.Context, project string) error {
+	_, err := d.runCommand(ctx, "docker", "rm", "-f", containerName(project, "zero", ""))
+	return err
+}
+
+// zeroCommand returns the arguments of the certificate manager, requesting a certificate
+// for every domain served by the proxy.
+func zeroCommand(cfg *config.Config) []string {
+	var args []string
+	for _, domain := range cfg.CertificateDomains() {
+		args = append(args, "-d", domain)
+	}
+
+	return append(args,
+		"-e",
+		cfg.Project.Email,
+		"-c",
+		"/certs",
+		"--hook",
+		"nginx -s reload",
+		"--hook-container",
+		"proxy",
+	)
+}
@@ -0,0 +1,399 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/proxy"
+)
+
+func TestZeroCommand(t *testing.T) {
+	cfg := &config.Config{
+		Project: config.Project{
+			Domain:  "app.example.com",
+			Domains: []string{"app.example.com", "api.example.com"},
+			Email:   "ops@example.com",
+		},
+		Services: []config.Service{
+			{Name: "web", Routes: []config.Route{{PathPrefix: "/"}}},
+			{Name: "docs", Routes: []config.Route{{PathPrefix: "/", Host: "docs.example.com"}}},
+		},
+	}
+
+	assert.Equal(t, []string{
+		"-d", "app.example.com",
+		"-d", "api.example.com",
+		"-d", "docs.example.com",
+		"-e", "ops@example.com",
+		"-c", "/certs",
+		"--hook", "nginx -s reload",
+		"--hook-container", "proxy",
+	}, zeroCommand(cfg))
+}
+
+func TestEnsureCertificates(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if command == "docker" && args[len(args)-2] == "ls" {
+			return "example.com.crt\nexample.com.key\n", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	err := d.ensureCertificates(context.Background(), "shop", "/home/deploy/projects/shop", []string{"example.com", "www.example.com"})
+	require.NoError(t, err)
+
+	executed := runner.executed()
+	require.Len(t, executed, 4)
+	assert.Equal(t, "docker run --rm -v shop-certs:/certs nginx:alpine ls /certs", executed[0])
+	assert.Equal(t, "mkdir -p /home/deploy/projects/shop/certs-bootstrap", executed[1])
+	assert.Contains(t, executed[2], "-v /home/deploy/projects/shop/certs-bootstrap:/bootstrap:ro")
+	assert.Equal(t, "rm -rf /home/deploy/projects/shop/certs-bootstrap", executed[3])
+}
+
+func TestEnsureCertificates_AllPresent(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		return "example.com.crt\nexample.com.key\n", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	require.NoError(t, d.ensureCertificates(context.Background(), "shop", "/home/deploy/projects/shop", []string{"example.com"}))
+	assert.Len(t, runner.executed(), 1)
+}
+
+func TestInstallCertificates_Files(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, keyPEM, err := proxy.SelfSignedCertificate("staging.example.com", time.Hour)
+	require.NoError(t, err)
+	certFile := filepath.Join(dir, "staging.crt")
+	keyFile := filepath.Join(dir, "staging.key")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o644))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if command == "docker" && args[len(args)-3] == "sh" {
+			return "copied\ncopied\n", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	changed, err := d.installCertificates(context.Background(), "shop", "/home/deploy/projects/shop",
+		&config.TLS{CertFile: certFile, KeyFile: keyFile}, []string{"staging.example.com", "api.staging.example.com"})
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	assert.ElementsMatch(t, []string{
+		"/home/deploy/projects/shop/certs-bootstrap/staging.example.com.crt",
+		"/home/deploy/projects/shop/certs-bootstrap/staging.example.com.key",
+		"/home/deploy/projects/shop/certs-bootstrap/api.staging.example.com.crt",
+		"/home/deploy/projects/shop/certs-bootstrap/api.staging.example.com.key",
+	}, runner.copied)
+
+	executed := runner.executed()
+	require.Len(t, executed, 3)
+	assert.Equal(t, "mkdir -p /home/deploy/projects/shop/certs-bootstrap", executed[0])
+	assert.Contains(t, executed[1], `cmp -s "$f" "/certs/${f##*/}"`)
+	assert.Equal(t, "rm -rf /home/deploy/projects/shop/certs-bootstrap", executed[2])
+
+	_, err = d.installCertificates(context.Background(), "shop", "/home/deploy/projects/shop",
+		&config.TLS{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.key")}, []string{"staging.example.com"})
+	assert.ErrorContains(t, err, "failed to read private key")
+}
+
+func TestInstallCertificates_SelfSigned(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if command == "docker" && args[len(args)-2] == "ls" {
+			return "staging.example.com.crt\nstaging.example.com.key\n", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	changed, err := d.installCertificates(context.Background(), "shop", "/home/deploy/projects/shop",
+		&config.TLS{SelfSigned: true}, []string{"staging.example.com", "api.staging.example.com"})
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.ElementsMatch(t, []string{
+		"/home/deploy/projects/shop/certs-bootstrap/api.staging.example.com.crt",
+		"/home/deploy/projects/shop/certs-bootstrap/api.staging.example.com.key",
+	}, runner.copied)
+	assert.Contains(t, runner.executed()[2], `[ -e "/certs/${f##*/}" ]`)
+}
+
+func TestPrepareMaintenancePage(t *testing.T) {
+	runner := &fakeRunner{}
+	d := NewDeployment(runner, nil)
+
+	htmlPath, err := d.prepareMaintenancePage(context.Background(), &config.Config{}, "/home/deploy/projects/shop")
+	require.NoError(t, err)
+	assert.Equal(t, "/home/deploy/projects/shop/html", htmlPath)
+	assert.Equal(t, []string{"mkdir -p /home/deploy/projects/shop/html"}, runner.executed())
+
+	_, err = d.prepareMaintenancePage(context.Background(), &config.Config{Proxy: config.Proxy{MaintenancePage: "/does/not/exist.html"}}, "/home/deploy/projects/shop")
+	assert.Error(t, err)
+}
+
+func TestPrepareAuthFiles(t *testing.T) {
+	cfg := &config.Config{
+		Services: []config.Service{
+			{
+				Name: "web",
+				Routes: []config.Route{
+					{PathPrefix: "/"},
+					{PathPrefix: "/admin", Auth: &config.RouteAuth{Username: "admin", PasswordEnv: "ADMIN_PASSWORD"}},
+				},
+			},
+		},
+	}
+	getenv := func(key string) string {
+		if key == "ADMIN_PASSWORD" {
+			return "s3cret"
+		}
+		return ""
+	}
+
+	runner := &fakeRunner{}
+	d := NewDeployment(runner, nil)
+
+	changed, err := d.prepareAuthFiles(context.Background(), cfg, "/home/deploy/projects/shop/nginx", getenv)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"sh -c cat /home/deploy/projects/shop/nginx/web-1.htpasswd 2>/dev/null || true"}, runner.executed())
+	assert.Equal(t, []string{"/home/deploy/projects/shop/nginx/web-1.htpasswd"}, runner.copied)
+
+	existing, err := proxy.Htpasswd("admin", "s3cret", nil)
+	require.NoError(t, err)
+	runner = &fakeRunner{handler: func(command string, args []string) (string, error) {
+		return string(existing), nil
+	}}
+	d = NewDeployment(runner, nil)
+
+	changed, err = d.prepareAuthFiles(context.Background(), cfg, "/home/deploy/projects/shop/nginx", getenv)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Empty(t, runner.copied)
+
+	_, err = d.prepareAuthFiles(context.Background(), cfg, "/home/deploy/projects/shop/nginx", func(string) string { return "" })
+	assert.ErrorContains(t, err, "ADMIN_PASSWORD")
+}
+
+func TestPrepareNginxConfig(t *testing.T) {
+	cfg := &config.Config{
+		Project:  config.Project{Name: "shop", Domain: "example.com"},
+		Services: []config.Service{{Name: "web", Port: 3000, Routes: []config.Route{{PathPrefix: "/"}}}},
+	}
+	nginxConfig, err := proxy.GenerateNginxConfig(cfg)
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		remoteHash string
+		changed    bool
+	}{
+		"unchanged": {remoteHash: configHash(strings.TrimSpace(nginxConfig)), changed: false},
+		"changed":   {remoteHash: configHash("server {}"), changed: true},
+		"first":     {remoteHash: "", changed: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+				if command == "sh" && strings.HasPrefix(args[1], "sha256sum") && tc.remoteHash != "" {
+					return tc.remoteHash + "  /home/deploy/projects/shop/nginx/default.conf\n", nil
+				}
+				return "", nil
+			}}
+			d := NewDeployment(runner, nil)
+
+			configPath, changed, err := d.prepareNginxConfig(context.Background(), cfg, "/home/deploy/projects/shop")
+			require.NoError(t, err)
+			assert.Equal(t, "/home/deploy/projects/shop/nginx", configPath)
+			assert.Equal(t, tc.changed, changed)
+
+			// The live config is only replaced once the candidate was tested.
+			assert.Len(t, runner.executed(), 2)
+			if tc.changed {
+				assert.Equal(t, []string{"/home/deploy/projects/shop/nginx/default.conf.new"}, runner.copied)
+			} else {
+				assert.Empty(t, runner.copied)
+			}
+		})
+	}
+}
+
+func TestReloadProxy(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		return "nginx: configuration file /etc/nginx/nginx.conf test is successful\nproxy-reloaded", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	require.NoError(t, d.reloadProxy(context.Background(), "shop", "/home/deploy/projects/shop/nginx"))
+	assert.Equal(t, []string{"docker exec shop-proxy sh -c nginx -t 2>&1 && nginx -s reload 2>&1 && echo proxy-reloaded"}, runner.executed())
+
+	runner = &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if command == "docker" {
+			return `nginx: [emerg] unknown directive "bogus" in /etc/nginx/conf.d/default.conf:3`, nil
+		}
+		return "", nil
+	}}
+	d = NewDeployment(runner, nil)
+
+	err := d.reloadProxy(context.Background(), "shop", "/home/deploy/projects/shop/nginx")
+	assert.ErrorContains(t, err, `unknown directive "bogus"`)
+	executed := runner.executed()
+	require.Len(t, executed, 2)
+	assert.Equal(t, "sh -c if [ -f /home/deploy/projects/shop/nginx/default.conf.bak ]; then "+
+		"mv /home/deploy/projects/shop/nginx/default.conf.bak /home/deploy/projects/shop/nginx/default.conf; "+
+		"else rm -f /home/deploy/projects/shop/nginx/default.conf; fi", executed[1])
+}
+
+func TestInstallNginxConfig(t *testing.T) {
+	service := proxyService(&config.Config{Project: config.Project{Name: "shop"}}, "/home/deploy/projects/shop/nginx", "/home/deploy/projects/shop/html")
+
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if command == "docker" {
+			return "nginx: configuration file /etc/nginx/nginx.conf test is successful\nproxy-config-valid", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	require.NoError(t, d.installNginxConfig(context.Background(), "shop", service, "/home/deploy/projects/shop/nginx"))
+	assert.Equal(t, []string{
+		"docker run --rm --network shop -v shop-certs:/etc/nginx/certs:ro -v /home/deploy/projects/shop/nginx:/candidate:ro " +
+			"-v /home/deploy/projects/shop/html:/usr/share/nginx/ftl:ro nginx:alpine sh -c " +
+			"cp -r /candidate/. /etc/nginx/conf.d/ && mv /etc/nginx/conf.d/default.conf.new /etc/nginx/conf.d/default.conf && " +
+			"nginx -t 2>&1 && echo proxy-config-valid",
+		"sh -c { [ ! -f /home/deploy/projects/shop/nginx/default.conf ] || " +
+			"cp /home/deploy/projects/shop/nginx/default.conf /home/deploy/projects/shop/nginx/default.conf.bak; } && " +
+			"mv /home/deploy/projects/shop/nginx/default.conf.new /home/deploy/projects/shop/nginx/default.conf",
+	}, runner.executed())
+
+	// A config with an invalid directive is removed, and the live one stays in place.
+	runner = &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if command == "docker" {
+			return `nginx: [emerg] unknown directive "limit_reqq" in /etc/nginx/conf.d/default.conf:42` +
+				"\nnginx: configuration file /etc/nginx/nginx.conf test failed", nil
+		}
+		return "", nil
+	}}
+	d = NewDeployment(runner, nil)
+
+	err := d.installNginxConfig(context.Background(), "shop", service, "/home/deploy/projects/shop/nginx")
+	assert.ErrorContains(t, err, `nginx rejected the new configuration, keeping the previous one:
+nginx: [emerg] unknown directive "limit_reqq" in /etc/nginx/conf.d/default.conf:42`)
+	executed := runner.executed()
+	require.Len(t, executed, 2)
+	assert.Equal(t, "rm -f /home/deploy/projects/shop/nginx/default.conf.new", executed[1])
+}
+
+func TestProxyService(t *testing.T) {
+	cfg := &config.Config{Project: config.Project{Name: "shop"}}
+	service := proxyService(cfg, "/home/deploy/projects/shop/nginx", "/home/deploy/projects/shop/html")
+	assert.Equal(t, []string{"80:80", "443:443"}, service.Forwards)
+	assert.Equal(t, "curl -k https://localhost/", service.Container.HealthCheck.Cmd)
+
+	cfg.Project.TLS = &config.TLS{Disabled: true}
+	service = proxyService(cfg, "/home/deploy/projects/shop/nginx", "/home/deploy/projects/shop/html")
+	assert.Equal(t, []string{"80:80"}, service.Forwards)
+	assert.Equal(t, "curl http://localhost/", service.Container.HealthCheck.Cmd)
+}
+
+func TestStartProxy_WarnsPlainHTTP(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		return "", errors.New("connection lost")
+	}}
+	d := NewDeployment(runner, nil)
+	d.events = make(chan Event, eventBuffer)
+	cfg := &config.Config{Project: config.Project{Name: "shop", Domains: []string{"shop.example.com"}, TLS: &config.TLS{Disabled: true}}}
+	assert.Error(t, d.startProxy(context.Background(), "shop", cfg))
+	close(d.events)
+
+	var warnings []Event
+	for event := range d.events {
+		warnings = append(warnings, event)
+	}
+	require.Len(t, warnings, 1)
+	assert.Equal(t, EventWarning, warnings[0].Type)
+	assert.Equal(t, "tls", warnings[0].Step)
+	assert.Contains(t, warnings[0].Message, "TLS is disabled: requests to shop.example.com,")
+}
+
+func TestDeployProxy(t *testing.T) {
+	service := &config.Service{
+		Name:     "proxy",
+		Image:    "nginx:alpine",
+		Volumes:  []string{"/home/deploy/projects/shop/nginx:/etc/nginx/conf.d:ro"},
+		Forwards: []string{"80:80", "443:443"},
+		Recreate: true,
+	}
+	hash, err := service.Hash()
+	require.NoError(t, err)
+
+	// proxyRunner simulates a running proxy container labelled with the given config hash.
+	proxyRunner := func(containerHash string) *fakeRunner {
+		return &fakeRunner{handler: func(command string, args []string) (string, error) {
+			switch {
+			case command != "docker":
+				return "", nil
+			case args[0] == "ps":
+				return "c0ffee", nil
+			case args[0] == "inspect" && args[1] == "c0ffee":
+				return fmt.Sprintf(`[{"ID": "c0ffee", "Image": "sha256:nginx", "State": {"Status": "running"},
+					"Config": {"Labels": {"ftl.config-hash": %q}},
+					"NetworkSettings": {"Networks": {"shop": {"Aliases": ["proxy"]}}}}]`, containerHash), nil
+			case args[0] == "image" && args[1] == "inspect":
+				return "sha256:nginx", nil
+			case args[0] == "exec":
+				return "proxy-reloaded", nil
+			}
+			return "", nil
+		}}
+	}
+
+	hasCommand := func(executed []string, prefix string) bool {
+		for _, command := range executed {
+			if strings.HasPrefix(command, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Run("config change reloads the running proxy", func(t *testing.T) {
+		runner := proxyRunner(hash)
+		d := NewDeployment(runner, nil)
+
+		require.NoError(t, d.deployProxy(context.Background(), "shop", service, "/home/deploy/projects/shop/nginx", true))
+		assert.True(t, hasCommand(runner.executed(), "docker exec shop-proxy"))
+		assert.False(t, hasCommand(runner.executed(), "docker stop"))
+		assert.False(t, hasCommand(runner.executed(), "docker run"))
+	})
+
+	t.Run("unchanged config leaves the proxy alone", func(t *testing.T) {
+		runner := proxyRunner(hash)
+		d := NewDeployment(runner, nil)
+
+		require.NoError(t, d.deployProxy(context.Background(), "shop", service, "/home/deploy/projects/shop/nginx", false))
+		assert.False(t, hasCommand(runner.executed(), "docker exec"))
+		assert.False(t, hasCommand(runner.executed(), "docker run"))
+	})
+
+	t.Run("definition change recreates the proxy", func(t *testing.T) {
+		runner := proxyRunner("outdated")
+		d := NewDeployment(runner, nil)
+
+		require.NoError(t, d.deployProxy(context.Background(), "shop", service, "/home/deploy/projects/shop/nginx", true))
+		assert.True(t, hasCommand(runner.executed(), "docker stop c0ffee"))
+		assert.True(t, hasCommand(runner.executed(), "docker run --detach --name shop-proxy"))
+		assert.False(t, hasCommand(runner.executed(), "docker exec"))
+	})
+}
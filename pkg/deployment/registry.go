@@ -0,0 +1,145 @@
+package deployment
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+const (
+	// dockerHubAuthKey is the key docker uses for Docker Hub in ~/.docker/config.json.
+	dockerHubAuthKey = "https://index.docker.io/v1/"
+
+	envDockerUsername = "FTL_DOCKER_USERNAME"
+	envDockerPassword = "FTL_DOCKER_PASSWORD"
+)
+
+// registryCredentials returns the registries to log into: the configured registries plus Docker Hub
+// when FTL_DOCKER_USERNAME and FTL_DOCKER_PASSWORD are set, which take precedence over a configured
+// Docker Hub entry.
+func registryCredentials(registries []config.Registry, getenv func(string) string) []config.Registry {
+	username, password := getenv(envDockerUsername), getenv(envDockerPassword)
+	fromEnv := username != "" && password != ""
+
+	var result []config.Registry
+	for _, registry := range registries {
+		if fromEnv && registryAuthKey(registry.Server) == dockerHubAuthKey {
+			continue
+		}
+		result = append(result, registry)
+	}
+
+	if fromEnv {
+		result = append(result, config.Registry{Username: username, Password: password})
+	}
+
+	return result
+}
+
+// registryAuthKey returns the key docker stores the credentials of server under.
+func registryAuthKey(server string) string {
+	switch server {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io", dockerHubAuthKey:
+		return dockerHubAuthKey
+	default:
+		return server
+	}
+}
+
+type dockerAuth struct {
+	Auth string `json:"auth"`
+}
+
+// parseDockerAuths returns the auth entries of a docker config.json. A missing or invalid file has no entries.
+func parseDockerAuths(data string) map[string]dockerAuth {
+	var dockerConfig struct {
+		Auths map[string]dockerAuth `json:"auths"`
+	}
+	if err := json.Unmarshal([]byte(data), &dockerConfig); err != nil {
+		return nil
+	}
+	return dockerConfig.Auths
+}
+
+// authUpToDate reports whether auths already hold the credentials of registry. Entries kept in a
+// credential helper can't be compared and are trusted as they are.
+func authUpToDate(auths map[string]dockerAuth, registry config.Registry) bool {
+	entry, ok := auths[registryAuthKey(registry.Server)]
+	if !ok {
+		return false
+	}
+	if entry.Auth == "" {
+		return true
+	}
+	return entry.Auth == base64.StdEncoding.EncodeToString([]byte(registry.Username+":"+registry.Password))
+}
+
+// loginRegistries logs the deploy user into the registries whose credentials the server doesn't have yet.
+func (d *Deployment) loginRegistries(ctx context.Context, registries []config.Registry) error {
+	registries = registryCredentials(registries, os.Getenv)
+	if len(registries) == 0 {
+		return nil
+	}
+
+	step := d.startStep("registry", "", "Logging into registries")
+
+	dockerConfig, err := d.runCommand(ctx, "sh", "-c", "cat ~/.docker/config.json 2>/dev/null || true")
+	if err != nil {
+		step.failf(err, "Failed to read docker config")
+		return fmt.Errorf("failed to read docker config: %w", err)
+	}
+	auths := parseDockerAuths(dockerConfig)
+
+	var loggedIn []string
+	for _, registry := range registries {
+		if authUpToDate(auths, registry) {
+			continue
+		}
+
+		name := registry.Server
+		if name == "" {
+			name = "Docker Hub"
+		}
+		if err := d.dockerLogin(ctx, registry); err != nil {
+			step.failf(err, "Failed to log into %s", name)
+			return fmt.Errorf("failed to log into %s: %w", name, err)
+		}
+		loggedIn = append(loggedIn, name)
+	}
+
+	if len(loggedIn) == 0 {
+		step.completef("Registry credentials are up to date")
+		return nil
+	}
+	step.completef("Logged into %s", strings.Join(loggedIn, ", "))
+	return nil
+}
+
+// dockerLogin runs docker login with the password on stdin, keeping it out of the process list.
+func (d *Deployment) dockerLogin(ctx context.Context, registry config.Registry) error {
+	args := []string{"login", "-u", registry.Username, "--password-stdin"}
+	if registry.Server != "" {
+		args = append(args, registry.Server)
+	}
+
+	output, err := d.runner.RunCommandWithInput(ctx, strings.NewReader(registry.Password), "docker", args...)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	data, err := io.ReadAll(output)
+	if err != nil {
+		return fmt.Errorf("failed to read docker login output: %w", err)
+	}
+	if !strings.Contains(string(data), "Login Succeeded") {
+		return fmt.Errorf("docker login failed: %s", strings.TrimSpace(string(data)))
+	}
+	return nil
+}
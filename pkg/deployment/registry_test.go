@@ -0,0 +1,88 @@
+package deployment
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestRegistryCredentials(t *testing.T) {
+	registries := []config.Registry{
+		{Username: "config-user", Password: "config-pass"},
+		{Server: "ghcr.io", Username: "octocat", Password: "token"},
+	}
+
+	noEnv := func(string) string { return "" }
+	assert.Equal(t, registries, registryCredentials(registries, noEnv))
+
+	env := map[string]string{envDockerUsername: "env-user", envDockerPassword: "env-pass"}
+	assert.Equal(t, []config.Registry{
+		{Server: "ghcr.io", Username: "octocat", Password: "token"},
+		{Username: "env-user", Password: "env-pass"},
+	}, registryCredentials(registries, func(key string) string { return env[key] }))
+}
+
+func TestAuthUpToDate(t *testing.T) {
+	auth := base64.StdEncoding.EncodeToString([]byte("octocat:token"))
+	auths := parseDockerAuths(`{"auths": {"ghcr.io": {"auth": "` + auth + `"}, "https://index.docker.io/v1/": {}}}`)
+
+	assert.True(t, authUpToDate(auths, config.Registry{Server: "ghcr.io", Username: "octocat", Password: "token"}))
+	assert.False(t, authUpToDate(auths, config.Registry{Server: "ghcr.io", Username: "octocat", Password: "rotated"}))
+	assert.True(t, authUpToDate(auths, config.Registry{Server: "docker.io", Username: "user", Password: "pass"}))
+	assert.False(t, authUpToDate(auths, config.Registry{Server: "registry.example.com", Username: "user", Password: "pass"}))
+	assert.Nil(t, parseDockerAuths(""))
+}
+
+func TestLoginRegistries(t *testing.T) {
+	t.Setenv(envDockerUsername, "")
+	t.Setenv(envDockerPassword, "")
+
+	upToDate := base64.StdEncoding.EncodeToString([]byte("octocat:token"))
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		switch command {
+		case "sh":
+			return `{"auths": {"ghcr.io": {"auth": "` + upToDate + `"}}}`, nil
+		case "docker":
+			return "Login Succeeded", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	err := d.loginRegistries(context.Background(), []config.Registry{
+		{Server: "ghcr.io", Username: "octocat", Password: "token"},
+		{Server: "registry.example.com", Username: "deploy", Password: "hunter2"},
+	})
+	require.NoError(t, err)
+
+	executed := runner.executed()
+	require.Len(t, executed, 2)
+	assert.Equal(t, "docker login -u deploy --password-stdin registry.example.com", executed[1])
+	assert.Equal(t, "hunter2", runner.inputs[1])
+	for _, command := range executed {
+		assert.False(t, strings.Contains(command, "hunter2"))
+	}
+}
+
+func TestLoginRegistries_Failure(t *testing.T) {
+	t.Setenv(envDockerUsername, "")
+	t.Setenv(envDockerPassword, "")
+
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if command == "docker" {
+			return "Error response from daemon: unauthorized", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	err := d.loginRegistries(context.Background(), []config.Registry{{Username: "user", Password: "wrong"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unauthorized")
+}
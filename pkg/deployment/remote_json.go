@@ -0,0 +1,55 @@
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	readJSONScript  = `cat "$1" 2>/dev/null || true`
+	writeJSONScript = `mkdir -p "$1" && printf '%s\n' "$2" > "$3" && mv -f "$3" "$4" && echo written`
+)
+
+// errMalformedJSON is returned by readRemoteJSON for a file that isn't valid JSON.
+var errMalformedJSON = errors.New("malformed JSON")
+
+// readRemoteJSON decodes the JSON file at path on the server into v. It reports false when the
+// file doesn't exist or is empty.
+func (d *Deployment) readRemoteJSON(ctx context.Context, path string, v any) (bool, error) {
+	output, err := d.runCommand(ctx, "sh", "-c", readJSONScript, "sh", path)
+	if err != nil {
+		return false, err
+	}
+	if output == "" {
+		return false, nil
+	}
+
+	if err := json.Unmarshal([]byte(output), v); err != nil {
+		return false, fmt.Errorf("%w in %s: %v", errMalformedJSON, path, err)
+	}
+	return true, nil
+}
+
+// writeRemoteJSON writes v as JSON to path on the server. The file is written next to path
+// and renamed into place, so it is never read half written.
+func (d *Deployment) writeRemoteJSON(ctx context.Context, path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	dir, name := filepath.Split(path)
+	tmp := filepath.Join(dir, "."+strings.TrimPrefix(name, ".")+".tmp")
+	output, err := d.runCommand(ctx, "sh", "-c", writeJSONScript, "sh", filepath.Clean(dir), string(data), tmp, path)
+	if err != nil {
+		return err
+	}
+	if output != "written" {
+		return fmt.Errorf("failed to write %s: %s", path, output)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package deployment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteJSON(t *testing.T) {
+	server := newFakeFileServer()
+	runner := &fakeRunner{handler: server.handle}
+	d := NewDeployment(runner, nil)
+
+	require.NoError(t, d.writeRemoteJSON(context.Background(), testLockPath, lockInfo{ID: "abc"}))
+	write := runner.commands[len(runner.commands)-1]
+	assert.Equal(t, []string{"/home/test/projects/test-project", `{"id":"abc","owner":"","started":"0001-01-01T00:00:00Z","heartbeat":"0001-01-01T00:00:00Z"}`,
+		"/home/test/projects/test-project/.deploy.lock.tmp", testLockPath}, write[4:])
+
+	var info lockInfo
+	found, err := d.readRemoteJSON(context.Background(), testLockPath, &info)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "abc", info.ID)
+
+	found, err = d.readRemoteJSON(context.Background(), testCanaryPath, &info)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	server.files[testLockPath] = "{"
+	_, err = d.readRemoteJSON(context.Background(), testLockPath, &info)
+	assert.ErrorIs(t, err, errMalformedJSON)
+
+	lock, err := d.readLock(context.Background(), testLockPath)
+	require.NoError(t, err)
+	assert.Equal(t, "unknown", lock.Owner)
+
+	server.failMove = true
+	assert.ErrorContains(t, d.writeRemoteJSON(context.Background(), testLockPath, info), "Permission denied")
+}
@@ -0,0 +1,68 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+)
+
+const (
+	// defaultRetryAttempts is how many times operations failing with a transient error are tried.
+	defaultRetryAttempts = 3
+	// defaultRetryDelay is the pause before the first retry, doubled before every further one.
+	defaultRetryDelay = 2 * time.Second
+)
+
+// transientError matches the errors, and the docker CLI output, of failures that are likely to
+// go away when the operation is tried again: network timeouts, dropped connections, temporary
+// DNS failures and server errors of the registry.
+var transientError = regexp.MustCompile(`(?i)` +
+	`tls handshake timeout|i/o timeout|request canceled while waiting for connection|` +
+	`connection reset by peer|unexpected eof|` +
+	`temporary failure in name resolution|server misbehaving|` +
+	`unexpected http status: 5\d\d|\b5\d\d (internal server error|bad gateway|service unavailable|gateway timeout)\b`)
+
+// Retries makes Deploy try pulls, network and volume creation and file copies up to attempts
+// times while they fail with a transient error, pausing for delay before the first retry and
+// twice as long before every further one.
+func (d *Deployment) Retries(attempts int, delay time.Duration) {
+	d.retryAttempts = attempts
+	d.retryDelay = delay
+}
+
+// retry runs operation until it succeeds, fails with an error that isn't transient, or was
+// tried d.retryAttempts times. Each retry is reported as a warning naming what is retried.
+func (d *Deployment) retry(ctx context.Context, what string, operation func() error) error {
+	delay := d.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil || attempt >= d.retryAttempts || !transientError.MatchString(err.Error()) {
+			return err
+		}
+
+		d.warn("retry", "", err, "Retrying %s in %s (attempt %d of %d)", what, delay, attempt+1, d.retryAttempts)
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// retryCommand runs a command like runCommand, retrying it while it fails with a transient
+// error. As the exit status of remote commands is lost, output reporting a transient error
+// counts as a failure too.
+func (d *Deployment) retryCommand(ctx context.Context, what, command string, args ...string) (string, error) {
+	var output string
+	err := d.retry(ctx, what, func() error {
+		var err error
+		output, err = d.runCommand(ctx, command, args...)
+		if err == nil && transientError.MatchString(output) {
+			return errors.New(output)
+		}
+		return err
+	})
+	return output, err
+}
@@ -0,0 +1,118 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPullImage_RetriesTransientErrors(t *testing.T) {
+	pulls := 0
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if args[0] != "pull" {
+			return "sha256:web", nil
+		}
+		pulls++
+		if pulls < 3 {
+			return "Error response from daemon: Get \"https://registry-1.docker.io/v2/\": net/http: TLS handshake timeout", nil
+		}
+		return "Status: Downloaded newer image for nginx:1.27", nil
+	}}
+	d := NewDeployment(runner, nil)
+	d.Retries(3, time.Millisecond)
+	d.events = make(chan Event, eventBuffer)
+
+	hash, err := d.pullImage(context.Background(), "nginx:1.27")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:web", hash)
+	assert.Equal(t, 3, pulls)
+
+	close(d.events)
+	var warnings []string
+	for event := range d.events {
+		if event.Type == EventWarning {
+			warnings = append(warnings, event.Message)
+		}
+	}
+	assert.Equal(t, []string{
+		"Retrying pulling image nginx:1.27 in 1ms (attempt 2 of 3)",
+		"Retrying pulling image nginx:1.27 in 2ms (attempt 3 of 3)",
+	}, warnings)
+}
+
+func TestPullImage_GivesUp(t *testing.T) {
+	pulls := 0
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		pulls++
+		return "Error response from daemon: received unexpected HTTP status: 503 Service Unavailable", nil
+	}}
+	d := NewDeployment(runner, nil)
+	d.Retries(2, time.Millisecond)
+
+	_, err := d.pullImage(context.Background(), "nginx:1.27")
+	assert.EqualError(t, err, "failed to pull image nginx:1.27: Error response from daemon: received unexpected HTTP status: 503 Service Unavailable")
+	assert.Equal(t, 2, pulls)
+}
+
+func TestRetry(t *testing.T) {
+	d := NewDeployment(&fakeRunner{}, nil)
+	d.Retries(3, time.Millisecond)
+
+	// Errors that aren't transient are returned right away.
+	attempts := 0
+	err := d.retry(context.Background(), "creating network shop", func() error {
+		attempts++
+		return errors.New("network with name shop already exists")
+	})
+	assert.EqualError(t, err, "network with name shop already exists")
+	assert.Equal(t, 1, attempts)
+
+	attempts = 0
+	err = d.retry(context.Background(), "copying nginx.conf", func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("dial tcp: lookup example.com: Temporary failure in name resolution")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+
+	// A cancelled context stops the retries.
+	ctx, cancel := context.WithCancel(context.Background())
+	d.Retries(3, time.Hour)
+	attempts = 0
+	err = d.retry(ctx, "creating volume shop-data", func() error {
+		attempts++
+		cancel()
+		return errors.New("read: connection reset by peer")
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestCreateNetwork_Retries(t *testing.T) {
+	creates := 0
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if args[1] == "create" {
+			creates++
+			if creates == 1 {
+				return "Error response from daemon: i/o timeout", nil
+			}
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+	d.Retries(3, time.Millisecond)
+
+	require.NoError(t, d.createNetwork(context.Background(), "shop", nil))
+	assert.Equal(t, []string{
+		"docker network ls --format {{.Name}}",
+		"docker network create shop",
+		"docker network create shop",
+	}, runner.executed())
+}
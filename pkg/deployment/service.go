@@ -2,302 +2,395 @@ package deployment
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/yarlson/ftl/pkg/config"
 )
 
-func (d *Deployment) deployServices(ctx context.Context, project string, services []config.Service) error {
-	hostname := d.runner.Host()
+// deployServices deploys the services concurrently, at most maxParallel at a time. A service
+// that takes longer than its deploy timeout is cancelled and fails while the others continue.
+func (d *Deployment) deployServices(ctx context.Context, project string, services []config.Service, maxParallel int) error {
+	if maxParallel <= 0 {
+		maxParallel = config.DefaultMaxParallel
+	}
+
 	var wg sync.WaitGroup
-	errChan := make(chan error, len(services))
+	var mu sync.Mutex
+	slots := make(chan struct{}, maxParallel)
+	result := &DeployError{}
 
 	for _, service := range services {
 		wg.Add(1)
 		go func(service config.Service) {
 			defer wg.Done()
 
-			spinner := d.sm.AddSpinner(service.Name, fmt.Sprintf("[%s] Deploying service %s", hostname, service.Name))
-
-			if err := d.deployService(project, &service); err != nil {
-				spinner.ErrorWithMessagef("Failed to deploy service %s: %v", service.Name, err)
-				errChan <- fmt.Errorf("failed to deploy service %s: %w", service.Name, err)
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				mu.Lock()
+				result.Skipped = append(result.Skipped, service.Name)
+				mu.Unlock()
 				return
 			}
 
-			spinner.Complete()
+			for _, forward := range service.PublicRouteForwards() {
+				d.warn("forward/"+service.Name, service.Name, nil, "Forward %s of service %s publishes the port of its routes on all interfaces, where requests bypass the proxy; bind it to 127.0.0.1 to keep it private", forward, service.Name)
+			}
+
+			step := d.startStep("service/"+service.Name, service.Name, "Deploying service %s", service.Name)
+
+			changed, err := d.deployServiceWithTimeout(ctx, project, &service)
+			step.changed = changed
+			step.phases = d.phaseDurations(service.Name)
+			switch {
+			case err != nil:
+				step.failf(err, "Failed to deploy service %s", service.Name)
+			case service.ImageDigest != "":
+				step.completef("Deployed service %s (%s)", service.Name, shortImageDigest(service.ImageDigest))
+			default:
+				step.complete()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Failed = append(result.Failed, newServiceError(service.Name, err))
+			} else {
+				result.Succeeded = append(result.Succeeded, service.Name)
+			}
 		}(service)
 	}
 
 	wg.Wait()
-	close(errChan)
 
-	var errs []error
-	for err := range errChan {
-		errs = append(errs, err)
+	if len(result.Failed) == 0 && len(result.Skipped) == 0 {
+		return nil
 	}
+	sort.Slice(result.Failed, func(i, j int) bool { return result.Failed[i].Service < result.Failed[j].Service })
+	sort.Strings(result.Succeeded)
+	sort.Strings(result.Skipped)
+	return result
+}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("errors occurred during service deployment: %v", errs)
+// deployServiceWithTimeout deploys the service, cancelling it after its deploy timeout. It
+// reports whether the container of the service was created, replaced or started.
+func (d *Deployment) deployServiceWithTimeout(ctx context.Context, project string, service *config.Service) (bool, error) {
+	timeout := service.DeployTimeout.Duration()
+	if timeout <= 0 {
+		return d.ensureService(ctx, project, service)
 	}
 
-	return nil
+	serviceCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	changed, err := d.ensureService(serviceCtx, project, service)
+	if err != nil && ctx.Err() == nil && errors.Is(serviceCtx.Err(), context.DeadlineExceeded) {
+		return changed, fmt.Errorf("timed out after %s: %w", timeout, err)
+	}
+	return changed, err
 }
 
-func (d *Deployment) deployService(project string, service *config.Service) error {
-	err := d.updateImage(project, service)
+func (d *Deployment) deployService(ctx context.Context, project string, service *config.Service) error {
+	_, err := d.ensureService(ctx, project, service)
+	return err
+}
+
+// ensureService deploys the service and reports whether its container was created, replaced
+// or started, and so has read its configuration files anew.
+func (d *Deployment) ensureService(ctx context.Context, project string, service *config.Service) (bool, error) {
+	err := d.updateImage(ctx, project, service)
 	if err != nil {
-		return err
+		return false, inPhase(PhasePull, err)
 	}
 
-	containerStatus, err := d.getContainerStatus(project, service.Name)
+	containerStatus, err := d.getContainerStatus(ctx, project, service.Name)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	if containerStatus == ContainerStatusNotFound {
-		if err := d.installService(project, service); err != nil {
-			return fmt.Errorf("failed to install service %s: %w", service.Name, err)
+		if err := d.installService(ctx, project, service); err != nil {
+			return false, fmt.Errorf("failed to install service %s: %w", service.Name, err)
 		}
-		return nil
+		return true, nil
 	}
 
-	containerShouldBeUpdated, err := d.containerShouldBeUpdated(project, service)
+	containerShouldBeUpdated, err := d.containerShouldBeUpdated(ctx, project, service)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	if containerShouldBeUpdated {
-		if err := d.updateService(project, service); err != nil {
-			return fmt.Errorf("failed to update service %s due to image change: %w", service.Name, err)
+		if err := d.updateService(ctx, project, service); err != nil {
+			return false, fmt.Errorf("failed to update service %s due to image change: %w", service.Name, err)
 		}
-		return nil
+		return true, nil
 	}
 
 	if containerStatus == ContainerStatusStopped {
 		container := containerName(project, service.Name, "")
-		if err := d.startContainer(container); err != nil {
-			return fmt.Errorf("failed to start container %s: %w", service.Name, err)
+		if err := d.startContainer(ctx, container); err != nil {
+			return false, inPhase(PhaseCreate, fmt.Errorf("failed to start container %s: %w", service.Name, err))
 		}
-		return nil
+		return true, nil
 	}
 
-	return nil
+	return false, nil
 }
 
-func (d *Deployment) installService(project string, service *config.Service) error {
-	if err := d.createContainer(project, service, ""); err != nil {
-		return fmt.Errorf("failed to start container for %s: %v", service.Image, err)
+func (d *Deployment) installService(ctx context.Context, project string, service *config.Service) error {
+	if err := d.createContainer(ctx, project, service, ""); err != nil {
+		return inPhase(PhaseCreate, fmt.Errorf("failed to start container for %s: %w", service.Image, err))
 	}
 
 	container := containerName(project, service.Name, "")
 
-	if err := d.performHealthChecks(container, service.HealthCheck); err != nil {
-		return fmt.Errorf("install failed for %s: container is unhealthy: %w", container, err)
+	if err := d.performHealthChecks(ctx, project, container, service); err != nil {
+		return inPhase(PhaseHealth, fmt.Errorf("install failed for %s: container is unhealthy: %w", container, err))
 	}
 
-	err := d.processPreHooks(project, service)
+	err := d.processPreHooks(ctx, project, service)
 	if err != nil {
-		return err
+		return inPhase(PhaseHooks, err)
 	}
 
-	err = d.processPostHooks(service, container)
+	err = d.processPostHooks(ctx, service, container)
 	if err != nil {
-		return err
+		return inPhase(PhaseHooks, err)
 	}
 
 	return nil
 }
 
-func (d *Deployment) updateService(project string, service *config.Service) error {
+func (d *Deployment) updateService(ctx context.Context, project string, service *config.Service) error {
 	container := containerName(project, service.Name, "")
 
 	if service.Recreate {
-		if err := d.recreateService(project, service); err != nil {
+		if err := d.recreateService(ctx, project, service); err != nil {
 			return fmt.Errorf("failed to recreate service %s: %w", service.Name, err)
 		}
 		return nil
 	}
 
-	if err := d.createContainer(project, service, newContainerSuffix); err != nil {
-		return fmt.Errorf("failed to start new container for %s: %v", container, err)
+	// Until traffic is switched, a failed or cancelled update removes the new container and
+	// leaves the old one serving.
+	newContainer := container + newContainerSuffix
+
+	if err := d.createContainer(ctx, project, service, newContainerSuffix); err != nil {
+		d.removeContainer(newContainer)
+		return inPhase(PhaseCreate, fmt.Errorf("failed to start new container for %s: %w", container, err))
 	}
 
-	if err := d.performHealthChecks(container+newContainerSuffix, service.HealthCheck); err != nil {
-		if _, err := d.runCommand(context.Background(), "docker", "rm", "-f", container+newContainerSuffix); err != nil {
-			return fmt.Errorf("update failed for %s: new container is unhealthy and cleanup failed: %v", container, err)
+	if err := d.performHealthChecks(ctx, project, newContainer, service); err != nil {
+		if rmErr := d.removeContainer(newContainer); rmErr != nil {
+			return inPhase(PhaseHealth, fmt.Errorf("update failed for %s: new container is unhealthy and cleanup failed: %v (original error: %w)", container, rmErr, err))
+		}
+		if ctx.Err() != nil {
+			return inPhase(PhaseHealth, fmt.Errorf("update of %s was cancelled: %w", container, err))
 		}
-		return fmt.Errorf("update failed for %s: new container is unhealthy: %w", container, err)
+		return inPhase(PhaseHealth, fmt.Errorf("update failed for %s: new container is unhealthy: %w", container, err))
 	}
 
-	err := d.processPreHooks(project, service)
+	err := d.processPreHooks(ctx, project, service)
 	if err != nil {
-		return err
+		d.removeContainer(newContainer)
+		return inPhase(PhaseHooks, err)
 	}
 
-	oldContID, err := d.switchTraffic(project, service.Name)
+	if err := ctx.Err(); err != nil {
+		d.removeContainer(newContainer)
+		return fmt.Errorf("update of %s was cancelled: %w", container, err)
+	}
+
+	// A canary keeps both containers running until it is promoted or aborted.
+	if d.canary != nil && len(service.Routes) > 0 {
+		d.addCanaryService(service.Name)
+		return nil
+	}
+
+	oldContID, err := d.switchTraffic(ctx, project, service)
 	if err != nil {
-		return fmt.Errorf("failed to switch traffic for %s: %v", container, err)
+		return inPhase(PhaseTraffic, fmt.Errorf("failed to switch traffic for %s: %w", container, err))
 	}
 
-	if err := d.cleanup(project, oldContID, service.Name); err != nil {
-		return fmt.Errorf("failed to cleanup for %s: %v", container, err)
+	if err := d.cleanup(ctx, project, oldContID, service.Name); err != nil {
+		return inPhase(PhaseTraffic, fmt.Errorf("failed to cleanup for %s: %w", container, err))
 	}
 
-	err = d.processPostHooks(service, container)
+	err = d.processPostHooks(ctx, service, container)
 	if err != nil {
-		return err
+		return inPhase(PhaseHooks, err)
 	}
 
 	return nil
 }
 
-func (d *Deployment) processPreHooks(project string, service *config.Service) error {
+func (d *Deployment) processPreHooks(ctx context.Context, project string, service *config.Service) error {
 	if service.Hooks == nil || service.Hooks.Pre == nil {
 		return nil
 	}
+	defer d.timePhase(service.Name, PhaseHooks)()
+	hook := service.Hooks.Pre
 
-	if service.Hooks.Pre.Local != "" {
-		if _, err := d.runLocalCommand(context.Background(), service.Hooks.Pre.Local); err != nil {
-			return fmt.Errorf("local pre-hook failed: %w", err)
+	if hook.Local != "" {
+		err := d.runHook(ctx, "local pre-hook of service "+service.Name, hook, func(ctx context.Context) error {
+			_, err := d.runLocalCommand(ctx, "sh", "-c", hook.Local)
+			return err
+		})
+		if err != nil {
+			return err
 		}
 	}
 
-	if service.Hooks.Pre.Remote != "" {
+	if hook.Remote != "" {
 		runService := &config.Service{
 			Name:       service.Name,
 			Image:      service.Image,
 			Volumes:    service.Volumes,
 			Env:        service.Env,
 			Entrypoint: service.Entrypoint,
-			Command:    service.Hooks.Pre.Remote,
+			Command:    hook.Remote,
 			Container:  &config.Container{RunOnce: true},
 		}
-		err := d.createContainer(project, runService, "run")
+		args, err := containerArgs(project, runService, "run")
 		if err != nil {
 			return err
 		}
 
+		// A hook container left behind by an earlier deployment would block the name, and one
+		// that --rm didn't remove would block the next deployment.
 		container := containerName(project, service.Name, "run")
-		if err := d.runRemoteHook(context.Background(), container, service.Hooks.Pre.Remote); err != nil {
-			return fmt.Errorf("remote pre-hook failed: %w", err)
+		_ = d.removeContainer(container)
+
+		err = d.runHook(ctx, "remote pre-hook of service "+service.Name, hook, func(ctx context.Context) error {
+			return d.runRemoteHook(ctx, []string{"docker", "rm", "-f", container}, "docker", args...)
+		})
+		_ = d.removeContainer(container)
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (d *Deployment) processPostHooks(service *config.Service, container string) error {
+func (d *Deployment) processPostHooks(ctx context.Context, service *config.Service, container string) error {
 	if service.Hooks == nil || service.Hooks.Post == nil {
 		return nil
 	}
+	defer d.timePhase(service.Name, PhaseHooks)()
+	hook := service.Hooks.Post
 
-	if service.Hooks.Post.Local != "" {
-		if _, err := d.runLocalCommand(context.Background(), service.Hooks.Post.Local); err != nil {
-			return fmt.Errorf("local post-hook failed: %w", err)
+	if hook.Local != "" {
+		err := d.runHook(ctx, "local post-hook of service "+service.Name, hook, func(ctx context.Context) error {
+			_, err := d.runLocalCommand(ctx, "sh", "-c", hook.Local)
+			return err
+		})
+		if err != nil {
+			return err
 		}
 	}
-	if service.Hooks.Post.Remote != "" {
-		if err := d.runRemoteHook(context.Background(), container, service.Hooks.Post.Remote); err != nil {
-			return fmt.Errorf("remote pre-hook failed: %w", err)
+
+	if hook.Remote != "" {
+		err := d.runHook(ctx, "remote post-hook of service "+service.Name, hook, func(ctx context.Context) error {
+			kill := []string{"docker", "exec", container, "sh", "-c", fmt.Sprintf(`kill -9 "$(cat %s)"`, hookPIDFile)}
+			return d.runRemoteHook(ctx, kill, "docker", "exec", container, "sh", "-c",
+				fmt.Sprintf(`echo $$ > %s 2>/dev/null; exec sh -c "$1"`, hookPIDFile), "sh", hook.Remote)
+		})
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (d *Deployment) recreateService(project string, service *config.Service) error {
-	oldContID, err := d.getContainerID(project, service.Name)
+// removeContainer removes container if it exists, even when the deployment was cancelled.
+func (d *Deployment) removeContainer(container string) error {
+	return d.deleteContainer(context.Background(), container, true)
+}
+
+func (d *Deployment) recreateService(ctx context.Context, project string, service *config.Service) error {
+	oldContID, err := d.getContainerID(ctx, project, service.Name)
 	if err != nil {
 		return fmt.Errorf("failed to get container ID for %s: %v", service.Name, err)
 	}
 
-	if _, err := d.runCommand(context.Background(), "docker", "stop", oldContID); err != nil {
-		return fmt.Errorf("failed to stop old container for %s: %v", service.Name, err)
+	if err := d.stopContainer(ctx, oldContID); err != nil {
+		return inPhase(PhaseCreate, fmt.Errorf("failed to stop old container for %s: %w", service.Name, err))
 	}
 
-	if _, err := d.runCommand(context.Background(), "docker", "rm", oldContID); err != nil {
-		return fmt.Errorf("failed to remove old container for %s: %v", service.Name, err)
+	if err := d.deleteContainer(ctx, oldContID, false); err != nil {
+		return inPhase(PhaseCreate, fmt.Errorf("failed to remove old container for %s: %w", service.Name, err))
 	}
 
-	if err := d.createContainer(project, service, ""); err != nil {
-		return fmt.Errorf("failed to start new container for %s: %v", service.Name, err)
+	if err := d.createContainer(ctx, project, service, ""); err != nil {
+		return inPhase(PhaseCreate, fmt.Errorf("failed to start new container for %s: %w", service.Name, err))
 	}
 
-	if err := d.performHealthChecks(service.Name, service.HealthCheck); err != nil {
-		if _, rmErr := d.runCommand(context.Background(), "docker", "rm", "-f", service.Name); rmErr != nil {
-			return fmt.Errorf("recreation failed for %s: new container is unhealthy and cleanup failed: %v (original error: %w)", service.Name, rmErr, err)
+	if err := d.performHealthChecks(ctx, project, service.Name, service); err != nil {
+		if rmErr := d.removeContainer(containerName(project, service.Name, "")); rmErr != nil {
+			return inPhase(PhaseHealth, fmt.Errorf("recreation failed for %s: new container is unhealthy and cleanup failed: %v (original error: %w)", service.Name, rmErr, err))
 		}
-		return fmt.Errorf("recreation failed for %s: new container is unhealthy: %w", service.Name, err)
+		return inPhase(PhaseHealth, fmt.Errorf("recreation failed for %s: new container is unhealthy: %w", service.Name, err))
 	}
 
 	return nil
 }
 
-func (d *Deployment) switchTraffic(project, service string) (string, error) {
-	newContainer := containerName(project, service, newContainerSuffix)
-	oldContainer, err := d.getContainerID(project, service)
+// switchTraffic moves the aliases of service to its new container on the project network and
+// its extra networks, and disconnects the old container from them.
+func (d *Deployment) switchTraffic(ctx context.Context, project string, service *config.Service) (string, error) {
+	defer d.timePhase(service.Name, PhaseTraffic)()
+
+	newContainer := containerName(project, service.Name, newContainerSuffix)
+	oldContainer, err := d.getContainerID(ctx, project, service.Name)
 	if err != nil {
 		return "", fmt.Errorf("failed to get old container ID: %v", err)
 	}
 
-	cmds := [][]string{
-		{"docker", "network", "disconnect", project, newContainer},
-		{"docker", "network", "connect", "--alias", service, project, newContainer},
-	}
-
-	for _, cmd := range cmds {
-		if _, err := d.runCommand(context.Background(), cmd[0], cmd[1:]...); err != nil {
-			return "", fmt.Errorf("failed to execute command '%s': %v", strings.Join(cmd, " "), err)
+	networks := append([]string{project}, service.Networks...)
+	for _, network := range networks {
+		if err := d.disconnectNetwork(ctx, network, newContainer); err != nil {
+			return "", err
+		}
+		if err := d.connectNetwork(ctx, network, newContainer, networkAliases(service, "")); err != nil {
+			return "", err
 		}
 	}
 
 	time.Sleep(1 * time.Second)
 
-	cmds = [][]string{
-		{"docker", "network", "disconnect", project, oldContainer},
-	}
-
-	for _, cmd := range cmds {
-		if _, err := d.runCommand(context.Background(), cmd[0], cmd[1:]...); err != nil {
-			return "", fmt.Errorf("failed to execute command '%s': %v", strings.Join(cmd, " "), err)
+	for _, network := range networks {
+		if err := d.disconnectNetwork(ctx, network, oldContainer); err != nil {
+			return "", err
 		}
 	}
 
 	return oldContainer, nil
 }
 
-func (d *Deployment) cleanup(project, oldContID, service string) error {
+func (d *Deployment) cleanup(ctx context.Context, project, oldContID, service string) error {
+	defer d.timePhase(service, PhaseTraffic)()
+
 	oldContainer := containerName(project, service, newContainerSuffix)
 	newContainer := containerName(project, service, "")
-	cmds := [][]string{
-		{"docker", "stop", oldContID},
-		{"docker", "rm", oldContID},
-		{"docker", "rename", oldContainer, newContainer},
-	}
 
-	for _, cmd := range cmds {
-		if _, err := d.runCommand(context.Background(), cmd[0], cmd[1:]...); err != nil {
-			return fmt.Errorf("failed to execute command '%s': %v", strings.Join(cmd, " "), err)
-		}
+	if err := d.stopContainer(ctx, oldContID); err != nil {
+		return fmt.Errorf("failed to stop old container %s: %v", oldContID, err)
 	}
-
-	return nil
-}
-
-// runRemoteHook executes the given command inside the specified container
-func (d *Deployment) runRemoteHook(ctx context.Context, containerName, command string) error {
-	if command == "" {
-		return nil
+	if err := d.deleteContainer(ctx, oldContID, false); err != nil {
+		return fmt.Errorf("failed to remove old container %s: %v", oldContID, err)
 	}
-
-	dockerCmd := fmt.Sprintf("docker exec %s sh -c \"%s\"", containerName, command)
-
-	if _, err := d.runCommand(ctx, dockerCmd); err != nil {
-		return fmt.Errorf("failed to run remote hook in container %s: %w", containerName, err)
+	if err := d.renameContainer(ctx, oldContainer, newContainer); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %v", oldContainer, newContainer, err)
 	}
 
 	return nil
@@ -0,0 +1,322 @@
+package deployment
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestRunRemoteHook(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if strings.Contains(strings.Join(args, " "), "migrate") {
+			return "migrated\nftl-hook-exit:0\n", nil
+		}
+		return "relation missing\nftl-hook-exit:3\n", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	kill := []string{"docker", "rm", "-f", "shop-webrun"}
+	require.NoError(t, d.runRemoteHook(context.Background(), kill, "docker", "exec", "shop-web", "migrate"))
+	assert.Equal(t, []string{`sh -c "$@" 2>&1; echo "ftl-hook-exit:$?" sh docker exec shop-web migrate`}, runner.executed())
+
+	err := d.runRemoteHook(context.Background(), kill, "docker", "exec", "shop-web", "seed")
+	require.Error(t, err)
+	assert.Equal(t, "exit status 3: relation missing", err.Error())
+}
+
+func TestPreHookTimeout(t *testing.T) {
+	var running atomic.Bool
+	var kill sync.Once
+	killed := make(chan struct{})
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if command == "docker" && len(args) > 0 && args[0] == "rm" {
+			if running.Load() {
+				kill.Do(func() { close(killed) })
+			}
+			return "", nil
+		}
+		// The hook container runs until it is removed.
+		running.Store(true)
+		<-killed
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	service := &config.Service{
+		Name:  "web",
+		Image: "shop/web:1",
+		Hooks: &config.Hooks{Pre: &config.HookItem{Remote: "bin/migrate", Timeout: config.Duration(50 * time.Millisecond)}},
+	}
+
+	err := d.processPreHooks(context.Background(), "shop", service)
+	require.Error(t, err)
+	assert.Equal(t, "remote pre-hook of service web timed out after 50ms", err.Error())
+	assert.Contains(t, runner.executed(), "docker rm -f shop-webrun")
+
+	run := runner.executed()[1]
+	assert.Contains(t, run, "docker run --rm --name shop-webrun --network shop")
+	assert.Contains(t, run, "shop/web:1 bin/migrate")
+	assert.NotContains(t, run, "--restart")
+}
+
+func TestHookFailurePolicy(t *testing.T) {
+	d := NewDeployment(&fakeRunner{}, nil)
+	service := &config.Service{
+		Name:  "web",
+		Hooks: &config.Hooks{Post: &config.HookItem{Local: "exit 1"}},
+	}
+
+	err := d.processPostHooks(context.Background(), service, "shop-web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "local post-hook of service web failed")
+
+	service.Hooks.Post.OnFailure = config.HookFailureContinue
+	assert.NoError(t, d.processPostHooks(context.Background(), service, "shop-web"))
+
+	service.Hooks.Post = &config.HookItem{Local: "sleep 5", Timeout: config.Duration(50 * time.Millisecond), OnFailure: config.HookFailureContinue}
+	start := time.Now()
+	assert.NoError(t, d.processPostHooks(context.Background(), service, "shop-web"))
+	assert.Less(t, time.Since(start), 3*time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = d.processPostHooks(ctx, service, "shop-web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "was cancelled")
+}
+
+func TestRunProjectHook(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if strings.Contains(strings.Join(args, " "), "smoke-test") {
+			return "smoke test failed\nftl-hook-exit:1\n", nil
+		}
+		return "ftl-hook-exit:0\n", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	hooks := &config.Hooks{
+		Pre:  &config.HookItem{Remote: "bin/maintenance on"},
+		Post: &config.HookItem{Remote: "bin/smoke-test"},
+	}
+
+	require.NoError(t, d.runProjectHook(context.Background(), "shop", "pre-deploy", preDeployHook(hooks)))
+	assert.Equal(t, []string{`sh -c "$@" 2>&1; echo "ftl-hook-exit:$?" sh sh -c echo $$ > /tmp/ftl-shop-hook.pid; exec sh -c "$1" sh bin/maintenance on`}, runner.executed())
+
+	assert.NoError(t, d.runProjectHook(context.Background(), "shop", "post-deploy", postDeployHook(hooks)))
+
+	hooks.Post.OnFailure = config.HookFailureAbort
+	err := d.runProjectHook(context.Background(), "shop", "post-deploy", postDeployHook(hooks))
+	require.Error(t, err)
+	assert.Equal(t, "remote post-deploy hook failed: exit status 1: smoke test failed", err.Error())
+
+	hooks.Pre.Remote = "bin/smoke-test"
+	assert.Error(t, d.runProjectHook(context.Background(), "shop", "pre-deploy", preDeployHook(hooks)))
+
+	assert.NoError(t, d.runProjectHook(context.Background(), "shop", "post-deploy", postDeployHook(nil)))
+}
+
+func TestUpdateServiceCancelled(t *testing.T) {
+	tests := []struct {
+		name   string
+		health string
+	}{
+		{name: "during health checks", health: "starting"},
+		{name: "before switching traffic", health: "healthy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+				if len(args) > 0 && args[0] == "inspect" {
+					// The interrupt arrives while the new container is checked.
+					cancel()
+					return tt.health, nil
+				}
+				return "", nil
+			}}
+			d := NewDeployment(runner, nil)
+
+			service := &config.Service{
+				Name:        "web",
+				Image:       "shop/web:2",
+				HealthCheck: &config.ServiceHealthCheck{Interval: config.Duration(time.Minute), Retries: 3},
+			}
+
+			err := d.updateService(ctx, "shop", service)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, context.Canceled)
+
+			executed := runner.executed()
+			assert.Equal(t, "docker rm -f shop-web_new", executed[len(executed)-1])
+			for _, command := range executed {
+				assert.NotContains(t, command, "network connect")
+			}
+		})
+	}
+}
+
+func TestPreHookFailureRemovesContainer(t *testing.T) {
+	// The fake daemon keeps the container of a failed hook, as if --rm didn't remove it, and
+	// refuses to start a second container with the same name.
+	var mu sync.Mutex
+	containers := make(map[string]bool)
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if command == "docker" && len(args) == 3 && args[0] == "rm" {
+			delete(containers, args[2])
+			return "", nil
+		}
+
+		run := strings.Join(args, " ")
+		if strings.Contains(run, "docker run") {
+			if containers["shop-webrun"] {
+				return `docker: Error response from daemon: Conflict. The container name "/shop-webrun" is already in use.` + "\nftl-hook-exit:125\n", nil
+			}
+			containers["shop-webrun"] = true
+			return "relation missing\nftl-hook-exit:1\n", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	service := &config.Service{
+		Name:  "web",
+		Image: "shop/web:1",
+		Hooks: &config.Hooks{Pre: &config.HookItem{Remote: "bin/migrate"}},
+	}
+
+	for i := 0; i < 2; i++ {
+		err := d.processPreHooks(context.Background(), "shop", service)
+		require.Error(t, err)
+		assert.Equal(t, "remote pre-hook of service web failed: exit status 1: relation missing", err.Error())
+	}
+	assert.Empty(t, containers)
+	executed := runner.executed()
+	assert.Equal(t, "docker rm -f shop-webrun", executed[len(executed)-1])
+}
+
+func TestDeployServices_MaxParallel(t *testing.T) {
+	var active, peak atomic.Int32
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if command == "docker" && args[0] == "pull" {
+			n := active.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			active.Add(-1)
+		}
+		if command == "docker" && args[0] == "image" {
+			return "sha256:image", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	var services []config.Service
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		services = append(services, config.Service{Name: name, Image: "shop/" + name + ":1"})
+	}
+
+	require.NoError(t, d.deployServices(context.Background(), "shop", services, 2))
+	assert.Equal(t, int32(2), peak.Load())
+}
+
+func TestDeployServices_Timeout(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if command == "docker" && strings.Join(args, " ") == "pull shop/slow:1" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		if command == "docker" && args[0] == "image" {
+			return "sha256:image", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	services := []config.Service{
+		{Name: "slow", Image: "shop/slow:1", DeployTimeout: config.Duration(50 * time.Millisecond)},
+		{Name: "fast", Image: "shop/fast:1", DeployTimeout: config.Duration(time.Minute)},
+		{Name: "worker", Image: "shop/worker:1"},
+	}
+
+	err := d.deployServices(context.Background(), "shop", services, 0)
+	var deployErr *DeployError
+	require.ErrorAs(t, err, &deployErr)
+	assert.Equal(t, []string{"fast", "worker"}, deployErr.Succeeded)
+	assert.Empty(t, deployErr.Skipped)
+	require.Len(t, deployErr.Failed, 1)
+	assert.Equal(t, "slow", deployErr.Failed[0].Service)
+	assert.ErrorContains(t, deployErr.Failed[0], "timed out after 50ms")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, strings.HasPrefix(err.Error(), "service slow failed (pull): timed out after 50ms: "))
+	assert.True(t, strings.HasSuffix(err.Error(), "\nsucceeded: fast, worker"))
+}
+
+func TestDeployServices_FailurePhases(t *testing.T) {
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		joined := strings.Join(args, " ")
+		switch {
+		case strings.HasPrefix(joined, "run") && strings.Contains(joined, "shop/broken:1"):
+			return "", errors.New("no space left on device")
+		case strings.HasPrefix(joined, "inspect --format={{.State.Health.Status}}"):
+			return "unhealthy", nil
+		case strings.HasPrefix(joined, "image inspect"):
+			return "sha256:image", nil
+		case joined == "logs shop-sick":
+			return "booting\n\x1b[31mpanic: missing DATABASE_URL\x1b[0m\n", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	services := []config.Service{
+		{Name: "broken", Image: "shop/broken:1"},
+		{Name: "sick", Image: "shop/sick:1", HealthCheck: &config.ServiceHealthCheck{Retries: 1, Interval: config.Duration(time.Millisecond)}},
+		{Name: "web", Image: "shop/web:1"},
+	}
+
+	err := d.deployServices(context.Background(), "shop", services, 0)
+	var deployErr *DeployError
+	require.ErrorAs(t, err, &deployErr)
+	require.Len(t, deployErr.Failed, 2)
+	assert.Equal(t, []string{"web"}, deployErr.Succeeded)
+
+	assert.Equal(t, "broken", deployErr.Failed[0].Service)
+	assert.Equal(t, PhaseCreate, deployErr.Failed[0].Phase)
+	assert.ErrorContains(t, deployErr.Failed[0], "no space left on device")
+
+	assert.Equal(t, "sick", deployErr.Failed[1].Service)
+	assert.Equal(t, PhaseHealth, deployErr.Failed[1].Phase)
+	var healthErr *HealthCheckError
+	require.ErrorAs(t, deployErr.Failed[1], &healthErr)
+	assert.Equal(t, "shop-sick", healthErr.Container)
+	assert.Equal(t, "booting\npanic: missing DATABASE_URL", healthErr.Logs)
+	assert.NotContains(t, err.Error(), "DATABASE_URL")
+}
+
+func TestDeployServices_Cancelled(t *testing.T) {
+	d := NewDeployment(&fakeRunner{}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := d.deployServices(ctx, "shop", []config.Service{{Name: "web"}, {Name: "api"}}, 1)
+	assert.EqualError(t, err, "skipped: api, web")
+}
@@ -3,20 +3,25 @@ package deployment
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/runner/remote"
 )
 
 func (d *Deployment) createVolumes(ctx context.Context, project string, volumes []string) error {
-	hostname := d.runner.Host()
 
 	for _, volume := range volumes {
-		spinner := d.sm.AddSpinner("volume", fmt.Sprintf("[%s] Creating volume %s", hostname, volume))
+		step := d.startStep("volume/"+volume, "", "Creating volume %s", volume)
 
 		if err := d.createVolume(ctx, project, volume); err != nil {
-			spinner.ErrorWithMessagef("Failed to create volume %s: %v", volume, err)
+			step.failf(err, "Failed to create volume %s", volume)
 			return fmt.Errorf("failed to create volume %s: %w", volume, err)
 		}
 
-		spinner.Complete()
+		step.complete()
 	}
 
 	return nil
@@ -24,14 +29,139 @@ func (d *Deployment) createVolumes(ctx context.Context, project string, volumes
 
 func (d *Deployment) createVolume(ctx context.Context, project, volume string) error {
 	volumeName := fmt.Sprintf("%s-%s", project, volume)
-	if _, err := d.runCommand(context.Background(), "docker", "volume", "inspect", volumeName); err == nil {
+	if d.docker != nil {
+		return d.apiCreateVolume(ctx, volumeName)
+	}
+
+	if _, err := d.runCommand(ctx, "docker", "volume", "inspect", volumeName); err == nil {
 		return nil
 	}
 
-	_, err := d.runCommand(ctx, "docker", "volume", "create", volumeName)
+	_, err := d.retryCommand(ctx, "creating volume "+volumeName, "docker", "volume", "create", volumeName)
 	if err != nil {
 		return fmt.Errorf("failed to create volume: %w", err)
 	}
 
 	return nil
 }
+
+// mountsDir is the directory of the project folder that holds uploaded host paths.
+const mountsDir = "mounts"
+
+// uploadVolumes uploads the files and directories of volumes whose host path is relative to the
+// current directory, like ./public:/usr/share/nginx/html, into the project folder and points
+// the volumes at the uploaded copies. Files that didn't change since the last upload are skipped.
+func (d *Deployment) uploadVolumes(ctx context.Context, project string, cfg *config.Config) error {
+	var projectPath string
+	uploaded := make(map[string]string)
+
+	upload := func(volumes []string) error {
+		for i, volume := range volumes {
+			source, target, ok := strings.Cut(volume, ":")
+			if !ok || !strings.HasPrefix(source, ".") {
+				continue
+			}
+
+			remotePath, ok := uploaded[source]
+			if !ok {
+				if projectPath == "" {
+					var err error
+					if projectPath, err = d.projectFolder(project); err != nil {
+						return fmt.Errorf("failed to get project folder path: %w", err)
+					}
+				}
+
+				var err error
+				if remotePath, err = d.uploadVolume(ctx, projectPath, source); err != nil {
+					return err
+				}
+				uploaded[source] = remotePath
+			}
+
+			volumes[i] = remotePath + ":" + target
+		}
+		return nil
+	}
+
+	for i := range cfg.Dependencies {
+		if err := upload(cfg.Dependencies[i].Volumes); err != nil {
+			return err
+		}
+	}
+	for i := range cfg.Services {
+		if err := upload(cfg.Services[i].Volumes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// uploadVolume uploads the local file or directory source into the project folder and returns
+// its path on the server.
+func (d *Deployment) uploadVolume(ctx context.Context, projectPath, source string) (string, error) {
+	rel := filepath.Clean(source)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("volume path %s is outside the project directory", source)
+	}
+	remotePath := filepath.Join(projectPath, mountsDir, filepath.ToSlash(rel))
+
+	step := d.startStep("upload/"+rel, "", "Uploading %s", source)
+
+	info, err := os.Stat(source)
+	if err != nil {
+		step.failf(err, "Failed to upload %s", source)
+		return "", fmt.Errorf("failed to upload volume path %s: %w", source, err)
+	}
+
+	if info.IsDir() {
+		err = d.runner.CopyDir(ctx, source, remotePath, remote.CopyDirOptions{Compare: remote.CompareSizeTime})
+	} else {
+		err = d.runner.CopyFile(ctx, source, remotePath)
+	}
+	if err != nil {
+		step.failf(err, "Failed to upload %s", source)
+		return "", fmt.Errorf("failed to upload volume path %s: %w", source, err)
+	}
+
+	step.complete()
+	return remotePath, nil
+}
+
+// checkHostPaths fails if a volume mounts an absolute host path that doesn't exist on the
+// server, which Docker would mount as an empty directory.
+func (d *Deployment) checkHostPaths(ctx context.Context, cfg *config.Config) error {
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(volumes []string) {
+		for _, volume := range volumes {
+			source, _, ok := strings.Cut(volume, ":")
+			if ok && strings.HasPrefix(source, "/") && !seen[source] {
+				seen[source] = true
+				paths = append(paths, source)
+			}
+		}
+	}
+	for _, dependency := range cfg.Dependencies {
+		add(dependency.Volumes)
+	}
+	for _, service := range cfg.Services {
+		add(service.Volumes)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	script := `for p; do [ -e "$p" ] || echo "$p"; done`
+	output, err := d.runCommand(ctx, "sh", append([]string{"-c", script, "sh"}, paths...)...)
+	if err != nil {
+		return fmt.Errorf("failed to check volume paths: %w", err)
+	}
+	if output != "" {
+		missing := strings.Fields(output)
+		return fmt.Errorf("volume paths %s don't exist on the server; absolute host paths must exist on the server, use a path starting with ./ to upload a local file or directory",
+			strings.Join(missing, ", "))
+	}
+
+	return nil
+}
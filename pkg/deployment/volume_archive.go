@@ -0,0 +1,204 @@
+package deployment
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+const (
+	// volumeArchiveImage is the image of the temporary containers archiving and restoring volumes.
+	volumeArchiveImage = "alpine:3"
+	// volumeRestored is printed once a volume was restored.
+	volumeRestored = "volume-restored"
+)
+
+// ProjectVolume returns the Docker volume of a volume of the project, given either by its name
+// in ftl.yaml, like uploads, or by its Docker name, like shop-uploads.
+func ProjectVolume(project string, volumes []string, name string) (string, error) {
+	if slices.Contains(volumes, name) {
+		return project + "-" + name, nil
+	}
+	if unprefixed, ok := strings.CutPrefix(name, project+"-"); ok && slices.Contains(volumes, unprefixed) {
+		return name, nil
+	}
+	return "", fmt.Errorf("volume %s is not a volume of project %s; its volumes are %s", name, project, strings.Join(volumes, ", "))
+}
+
+// BackupVolume writes a gzipped tar archive of the files of the Docker volume to w. The archive
+// is made on the server by a temporary container mounting the volume read-only and streamed
+// over the connection of runner. As the exit status of remote commands is lost, the archive is
+// read while it is written, and an incomplete one is reported as an error.
+func BackupVolume(ctx context.Context, runner Runner, volume string, w io.Writer) error {
+	if err := checkVolumeExists(ctx, runner, volume); err != nil {
+		return err
+	}
+
+	// The docker messages on stderr, like those of pulling the image, would end up in the archive.
+	output, err := runner.RunCommand(ctx, "sh", "-c",
+		`docker run --rm -v "$1":/volume:ro "$2" tar czf - -C /volume . 2>/dev/null`, "sh", volume, volumeArchiveImage)
+	if err != nil {
+		return fmt.Errorf("failed to archive volume %s: %w", volume, err)
+	}
+	defer output.Close()
+
+	archive := io.TeeReader(output, w)
+	if _, err := CheckVolumeArchive(archive); err != nil {
+		return fmt.Errorf("failed to archive volume %s: %w", volume, err)
+	}
+	// Copy what follows the end of the archive, like the padding of the last block.
+	if _, err := io.Copy(io.Discard, archive); err != nil {
+		return fmt.Errorf("failed to archive volume %s: %w", volume, err)
+	}
+	return nil
+}
+
+// CheckVolumeArchive reads a gzipped tar archive to its end and returns the number of entries.
+func CheckVolumeArchive(r io.Reader) (int, error) {
+	compressed, err := gzip.NewReader(r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, errors.New("the archive is empty")
+		}
+		return 0, fmt.Errorf("the archive is not gzipped: %w", err)
+	}
+
+	archive := tar.NewReader(compressed)
+	entries := 0
+	for {
+		_, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return entries, fmt.Errorf("the archive is incomplete or corrupt: %w", err)
+		}
+		if _, err := io.Copy(io.Discard, archive); err != nil {
+			return entries, fmt.Errorf("the archive is incomplete or corrupt: %w", err)
+		}
+		entries++
+	}
+	if _, err := io.Copy(io.Discard, compressed); err != nil {
+		return entries, fmt.Errorf("the archive is incomplete or corrupt: %w", err)
+	}
+	return entries, nil
+}
+
+// VolumeContainers returns the names of the running containers mounting the Docker volume.
+func VolumeContainers(ctx context.Context, runner Runner, volume string) ([]string, error) {
+	output, err := runOutput(ctx, runner, "docker", "ps", "--filter", "volume="+volume, "--format", "{{.Names}}")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the containers of volume %s: %w", volume, err)
+	}
+	if strings.Contains(output, "Error") {
+		return nil, fmt.Errorf("failed to list the containers of volume %s: %s", volume, output)
+	}
+	return strings.Fields(output), nil
+}
+
+// RestoreVolume replaces the files of the Docker volume with those of the gzipped tar archive,
+// which is checked before anything is removed. The running containers mounting the volume are
+// stopped during the restore and started again afterwards. The volume is created when it
+// doesn't exist, e.g. on a new server.
+func RestoreVolume(ctx context.Context, runner Runner, volume string, archive io.ReadSeeker) error {
+	if _, err := CheckVolumeArchive(archive); err != nil {
+		return err
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read the archive: %w", err)
+	}
+
+	containers, err := VolumeContainers(ctx, runner, volume)
+	if err != nil {
+		return err
+	}
+	if err := StopContainers(ctx, runner, containers); err != nil {
+		return fmt.Errorf("failed to stop the containers of volume %s: %w", volume, err)
+	}
+
+	err = restoreVolumeFiles(ctx, runner, volume, archive)
+	if len(containers) > 0 {
+		output, startErr := runOutput(ctx, runner, "docker", append([]string{"start"}, containers...)...)
+		if startErr == nil && strings.Contains(output, "Error") {
+			startErr = errors.New(output)
+		}
+		if startErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to start containers %s again: %w", strings.Join(containers, ", "), startErr))
+		}
+	}
+	return err
+}
+
+// StopContainers stops the containers on the server of runner.
+func StopContainers(ctx context.Context, runner Runner, containers []string) error {
+	if len(containers) == 0 {
+		return nil
+	}
+	output, err := runOutput(ctx, runner, "docker", append([]string{"stop"}, containers...)...)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(output, "Error") {
+		return errors.New(output)
+	}
+	return nil
+}
+
+func restoreVolumeFiles(ctx context.Context, runner Runner, volume string, archive io.Reader) error {
+	output, err := runner.RunCommandWithInput(ctx, archive, "sh", "-c",
+		`docker run --rm -i -v "$1":/volume "$2" sh -c 'find /volume -mindepth 1 -delete && tar xzf - -C /volume' && echo `+volumeRestored,
+		"sh", volume, volumeArchiveImage)
+	if err != nil {
+		return fmt.Errorf("failed to restore volume %s: %w", volume, err)
+	}
+	defer output.Close()
+
+	data, err := io.ReadAll(output)
+	if err != nil {
+		return fmt.Errorf("failed to restore volume %s: %w", volume, err)
+	}
+	if !strings.Contains(string(data), volumeRestored) {
+		return fmt.Errorf("failed to restore volume %s: %s", volume, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+func checkVolumeExists(ctx context.Context, runner Runner, volume string) error {
+	exists, err := VolumeExists(ctx, runner, volume)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("volume %s doesn't exist on the server", volume)
+	}
+	return nil
+}
+
+// VolumeExists reports whether the Docker volume exists on the server of runner.
+func VolumeExists(ctx context.Context, runner Runner, volume string) (bool, error) {
+	output, err := runOutput(ctx, runner, "docker", "volume", "inspect", "--format", "{{.Name}}", volume)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect volume %s: %w", volume, err)
+	}
+	return output == volume, nil
+}
+
+// runOutput runs a command with runner and returns its trimmed output.
+func runOutput(ctx context.Context, runner Runner, command string, args ...string) (string, error) {
+	output, err := runner.RunCommand(ctx, command, args...)
+	if err != nil {
+		return "", err
+	}
+	defer output.Close()
+
+	data, err := io.ReadAll(output)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
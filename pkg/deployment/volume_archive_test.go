@@ -0,0 +1,119 @@
+package deployment
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testVolumeArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	compressed := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(compressed)
+	for name, content := range files {
+		require.NoError(t, archive.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}))
+		_, err := archive.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+	require.NoError(t, compressed.Close())
+	return buf.Bytes()
+}
+
+func TestProjectVolume(t *testing.T) {
+	volumes := []string{"postgres_data", "uploads"}
+
+	volume, err := ProjectVolume("shop", volumes, "uploads")
+	require.NoError(t, err)
+	assert.Equal(t, "shop-uploads", volume)
+
+	volume, err = ProjectVolume("shop", volumes, "shop-postgres_data")
+	require.NoError(t, err)
+	assert.Equal(t, "shop-postgres_data", volume)
+
+	_, err = ProjectVolume("shop", volumes, "shop-logs")
+	assert.EqualError(t, err, "volume shop-logs is not a volume of project shop; its volumes are postgres_data, uploads")
+}
+
+func TestBackupVolume(t *testing.T) {
+	archive := testVolumeArchive(t, map[string]string{"./avatar.png": "png"})
+	serve := func(sent []byte) *fakeRunner {
+		return &fakeRunner{handler: func(command string, args []string) (string, error) {
+			if command == "docker" {
+				return "shop-uploads", nil
+			}
+			return string(sent), nil
+		}}
+	}
+
+	var out bytes.Buffer
+	runner := serve(archive)
+	require.NoError(t, BackupVolume(context.Background(), runner, "shop-uploads", &out))
+	assert.Equal(t, archive, out.Bytes())
+	assert.Equal(t, []string{
+		"docker volume inspect --format {{.Name}} shop-uploads",
+		`sh -c docker run --rm -v "$1":/volume:ro "$2" tar czf - -C /volume . 2>/dev/null sh shop-uploads alpine:3`,
+	}, runner.executed())
+
+	err := BackupVolume(context.Background(), serve(archive[:len(archive)/2]), "shop-uploads", &out)
+	assert.ErrorContains(t, err, "failed to archive volume shop-uploads: the archive is incomplete or corrupt")
+
+	err = BackupVolume(context.Background(), serve(nil), "shop-uploads", &out)
+	assert.EqualError(t, err, "failed to archive volume shop-uploads: the archive is empty")
+
+	missing := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		return "Error response from daemon: get shop-logs: no such volume", nil
+	}}
+	err = BackupVolume(context.Background(), missing, "shop-logs", &out)
+	assert.EqualError(t, err, "volume shop-logs doesn't exist on the server")
+}
+
+func TestRestoreVolume(t *testing.T) {
+	archive := testVolumeArchive(t, map[string]string{"./avatar.png": "png"})
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		switch {
+		case command == "docker" && args[0] == "ps":
+			return "shop-web\nshop-worker", nil
+		case command == "sh":
+			return volumeRestored, nil
+		}
+		return "", nil
+	}}
+
+	require.NoError(t, RestoreVolume(context.Background(), runner, "shop-uploads", bytes.NewReader(archive)))
+	executed := runner.executed()
+	assert.Equal(t, []string{
+		"docker ps --filter volume=shop-uploads --format {{.Names}}",
+		"docker stop shop-web shop-worker",
+		`sh -c docker run --rm -i -v "$1":/volume "$2" sh -c 'find /volume -mindepth 1 -delete && tar xzf - -C /volume' && echo volume-restored sh shop-uploads alpine:3`,
+		"docker start shop-web shop-worker",
+	}, executed)
+	assert.Equal(t, string(archive), runner.inputs[2])
+
+	// A corrupt archive is rejected before the volume is touched.
+	runner = &fakeRunner{}
+	err := RestoreVolume(context.Background(), runner, "shop-uploads", bytes.NewReader(archive[:len(archive)-20]))
+	assert.ErrorContains(t, err, "the archive is incomplete or corrupt")
+	assert.Empty(t, runner.executed())
+
+	// The containers are started again when the restore fails.
+	runner = &fakeRunner{handler: func(command string, args []string) (string, error) {
+		switch {
+		case command == "docker" && args[0] == "ps":
+			return "shop-web", nil
+		case command == "sh":
+			return "tar: short read", nil
+		}
+		return "", nil
+	}}
+	err = RestoreVolume(context.Background(), runner, "shop-uploads", bytes.NewReader(archive))
+	assert.EqualError(t, err, "failed to restore volume shop-uploads: tar: short read")
+	executed = runner.executed()
+	assert.True(t, strings.HasPrefix(executed[len(executed)-1], "docker start shop-web"))
+}
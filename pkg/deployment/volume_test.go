@@ -0,0 +1,88 @@
+package deployment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestUploadVolumes(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "public", "css"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "public", "index.html"), []byte("<h1>shop</h1>"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nginx.conf"), []byte("worker_processes 1;"), 0644))
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	cfg := &config.Config{
+		Services: []config.Service{
+			{Name: "web", Volumes: []string{"./public:/usr/share/nginx/html", "./nginx.conf:/etc/nginx/nginx.conf", "uploads:/uploads"}},
+			{Name: "assets", Volumes: []string{"public:/srv"}},
+		},
+		Dependencies: []config.Dependency{
+			{Name: "cdn", Volumes: []string{"./public:/var/www", "/srv/cache:/cache"}},
+		},
+	}
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		if strings.Contains(strings.Join(args, " "), "echo $HOME") {
+			return "/home/deploy\n", nil
+		}
+		return "", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	require.NoError(t, d.uploadVolumes(context.Background(), "shop", cfg))
+
+	assert.Equal(t, []string{
+		"/home/deploy/projects/shop/mounts/public:/usr/share/nginx/html",
+		"/home/deploy/projects/shop/mounts/nginx.conf:/etc/nginx/nginx.conf",
+		"uploads:/uploads",
+	}, cfg.Services[0].Volumes)
+	assert.Equal(t, []string{"public:/srv"}, cfg.Services[1].Volumes)
+	assert.Equal(t, []string{"/home/deploy/projects/shop/mounts/public:/var/www", "/srv/cache:/cache"}, cfg.Dependencies[0].Volumes)
+	// Every path is uploaded once.
+	assert.Equal(t, []string{
+		"/home/deploy/projects/shop/mounts/public",
+		"/home/deploy/projects/shop/mounts/nginx.conf",
+	}, runner.copied)
+
+	cfg.Services[0].Volumes = []string{"../secrets:/secrets"}
+	err = d.uploadVolumes(context.Background(), "shop", cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "outside the project directory")
+}
+
+func TestCheckHostPaths(t *testing.T) {
+	cfg := &config.Config{
+		Services: []config.Service{
+			{Name: "web", Volumes: []string{"/srv/config:/app/config", "uploads:/uploads", "./public:/public"}},
+		},
+		Dependencies: []config.Dependency{
+			{Name: "cdn", Volumes: []string{"/srv/config:/config", "/srv/cache:/cache"}},
+		},
+	}
+	var checked []string
+	runner := &fakeRunner{handler: func(command string, args []string) (string, error) {
+		checked = args[3:]
+		return "/srv/cache\n", nil
+	}}
+	d := NewDeployment(runner, nil)
+
+	err := d.checkHostPaths(context.Background(), cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "volume paths /srv/cache don't exist on the server")
+	assert.Equal(t, []string{"/srv/config", "/srv/cache"}, checked)
+
+	runner.handler = nil
+	require.NoError(t, d.checkHostPaths(context.Background(), cfg))
+}
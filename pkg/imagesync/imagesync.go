@@ -2,6 +2,8 @@ package imagesync
 
 import (
 	"archive/tar"
+	"bufio"
+	"bytes"
 	"compress/gzip"
 	"context"
 	"encoding/json"
@@ -11,9 +13,12 @@ import (
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/yarlson/ftl/pkg/runner/remote"
 )
@@ -23,12 +28,41 @@ type Config struct {
 	LocalStore  string
 	RemoteStore string
 	MaxParallel int
+	// Architecture is the architecture of the server, like arm64. Images built for another
+	// one are refused. Empty accepts any image.
+	Architecture string
+}
+
+// ArchitectureError is returned for an image built for another architecture than the server's.
+type ArchitectureError struct {
+	Image        string
+	Architecture string
+	Server       string
+}
+
+func (e *ArchitectureError) Error() string {
+	return fmt.Sprintf("image %s is built for %s but the server runs %s; set platform: linux/%s for the service or the project in ftl.yaml and run ftl build again",
+		e.Image, e.Architecture, e.Server, e.Server)
 }
 
 // ImageSync handles Docker image synchronization operations.
 type ImageSync struct {
 	cfg    Config
 	runner *remote.Runner
+
+	// transfers holds the blob transfers in progress, so concurrent syncs of images sharing
+	// layers transfer each blob once.
+	mu        sync.Mutex
+	transfers map[string]*blobTransfer
+
+	// transferred is the number of bytes of the blobs copied to the server.
+	transferred atomic.Int64
+}
+
+// blobTransfer is a blob being copied to the server, whose err is set once done is closed.
+type blobTransfer struct {
+	done chan struct{}
+	err  error
 }
 
 // NewImageSync creates a new ImageSync instance with the provided configuration and SSH runner.
@@ -37,17 +71,28 @@ func NewImageSync(cfg Config, runner *remote.Runner) *ImageSync {
 		cfg.MaxParallel = 4
 	}
 	if cfg.LocalStore == "" {
-		cfg.LocalStore = filepath.Join(os.Getenv("HOME"), "docker-images")
+		cfg.LocalStore = DefaultLocalStore()
 	}
 
 	return &ImageSync{
-		cfg:    cfg,
-		runner: runner,
+		cfg:       cfg,
+		runner:    runner,
+		transfers: make(map[string]*blobTransfer),
 	}
 }
 
+// Transferred returns the number of bytes of the image layers and configurations copied to the
+// server so far. Blobs already on the server aren't counted.
+func (s *ImageSync) Transferred() int64 {
+	return s.transferred.Load()
+}
+
 // Sync performs the Docker image synchronization process.
 func (s *ImageSync) Sync(ctx context.Context, image string) (bool, error) {
+	if err := s.CheckArchitecture(image); err != nil {
+		return false, err
+	}
+
 	needsSync, err := s.CompareImages(ctx, image)
 	if err != nil {
 		return false, fmt.Errorf("failed to compare images: %w", err)
@@ -57,11 +102,12 @@ func (s *ImageSync) Sync(ctx context.Context, image string) (bool, error) {
 		return false, nil // Images are identical
 	}
 
-	if err := s.prepareDirectories(ctx); err != nil {
+	if err := s.prepareDirectories(ctx, image); err != nil {
 		return false, fmt.Errorf("failed to prepare directories: %w", err)
 	}
 
-	if err := s.exportAndExtractImage(ctx, image); err != nil {
+	blobs, err := s.exportAndExtractImage(ctx, image)
+	if err != nil {
 		return false, fmt.Errorf("failed to export and extract image: %w", err)
 	}
 
@@ -69,17 +115,79 @@ func (s *ImageSync) Sync(ctx context.Context, image string) (bool, error) {
 		return false, fmt.Errorf("failed to transfer metadata: %w", err)
 	}
 
-	if err := s.syncBlobs(ctx, image); err != nil {
+	if err := s.syncBlobs(ctx, blobs); err != nil {
 		return false, fmt.Errorf("failed to sync blobs: %w", err)
 	}
 
-	if err := s.loadRemoteImage(ctx, image); err != nil {
+	if err := s.loadRemoteImage(ctx, image, blobs); err != nil {
 		return false, fmt.Errorf("failed to load remote image: %w", err)
 	}
 
+	// docker load doesn't report failures through the runner, so the loaded image is compared
+	// again to make sure the server runs the local image and not a stale one.
+	needsSync, err = s.CompareImages(ctx, image)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify remote image: %w", err)
+	}
+	if needsSync {
+		return false, fmt.Errorf("image %s on the server doesn't match the local image after syncing", image)
+	}
+
 	return true, nil
 }
 
+// PendingSize returns the total size of the local images that differ from the images on the
+// server, which is about what syncing them writes to the remote store and to Docker.
+func (s *ImageSync) PendingSize(ctx context.Context, images []string) (int64, error) {
+	var total int64
+	for _, image := range images {
+		needsSync, err := s.CompareImages(ctx, image)
+		if err != nil {
+			return 0, fmt.Errorf("failed to compare images: %w", err)
+		}
+		if !needsSync {
+			continue
+		}
+
+		output, err := exec.Command("docker", "image", "inspect", "--format", "{{.Size}}", image).Output()
+		if err != nil {
+			return 0, fmt.Errorf("failed to inspect local image %s: %w", image, err)
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse size of local image %s: %w", image, err)
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// RemoteStoreDir returns the directory of the remote store, relative to the home directory of
+// the SSH user unless it is absolute.
+func (s *ImageSync) RemoteStoreDir() string {
+	if s.cfg.RemoteStore == "" {
+		return "."
+	}
+	return s.cfg.RemoteStore
+}
+
+// CheckArchitecture returns an *ArchitectureError when the local image is built for another
+// architecture than the server's, which would run under emulation, if at all.
+func (s *ImageSync) CheckArchitecture(image string) error {
+	if s.cfg.Architecture == "" {
+		return nil
+	}
+
+	local, err := s.inspectLocalImage(image)
+	if err != nil {
+		return fmt.Errorf("failed to inspect local image: %w", err)
+	}
+	if local.Architecture != s.cfg.Architecture {
+		return &ArchitectureError{Image: image, Architecture: local.Architecture, Server: s.cfg.Architecture}
+	}
+	return nil
+}
+
 // CompareImages checks if the image needs to be synced by comparing local and remote versions.
 func (s *ImageSync) CompareImages(ctx context.Context, image string) (bool, error) {
 	var localInspect, remoteInspect *ImageData
@@ -114,24 +222,27 @@ func (s *ImageSync) CompareImages(ctx context.Context, image string) (bool, erro
 
 // ImageData represents Docker image metadata.
 type ImageData struct {
+	// ID is the digest of the image configuration, which covers its layers and settings.
+	ID     string `json:"Id"`
 	Config struct {
-		Hostname     string   `json:"Hostname"`
-		Domainname   string   `json:"Domainname"`
-		User         string   `json:"User"`
-		AttachStdin  bool     `json:"AttachStdin"`
-		AttachStdout bool     `json:"AttachStdout"`
-		AttachStderr bool     `json:"AttachStderr"`
-		ExposedPorts struct{} `json:"ExposedPorts"`
-		Tty          bool     `json:"Tty"`
-		OpenStdin    bool     `json:"OpenStdin"`
-		StdinOnce    bool     `json:"StdinOnce"`
-		Env          []string `json:"Env"`
-		Cmd          []string `json:"Cmd"`
-		Image        string   `json:"Image"`
-		Volumes      struct{} `json:"Volumes"`
-		WorkingDir   string   `json:"WorkingDir"`
-		Entrypoint   []string `json:"Entrypoint"`
-		OnBuild      []string `json:"OnBuild"`
+		Hostname     string            `json:"Hostname"`
+		Domainname   string            `json:"Domainname"`
+		User         string            `json:"User"`
+		AttachStdin  bool              `json:"AttachStdin"`
+		AttachStdout bool              `json:"AttachStdout"`
+		AttachStderr bool              `json:"AttachStderr"`
+		ExposedPorts struct{}          `json:"ExposedPorts"`
+		Tty          bool              `json:"Tty"`
+		OpenStdin    bool              `json:"OpenStdin"`
+		StdinOnce    bool              `json:"StdinOnce"`
+		Env          []string          `json:"Env"`
+		Cmd          []string          `json:"Cmd"`
+		Image        string            `json:"Image"`
+		Volumes      struct{}          `json:"Volumes"`
+		WorkingDir   string            `json:"WorkingDir"`
+		Entrypoint   []string          `json:"Entrypoint"`
+		OnBuild      []string          `json:"OnBuild"`
+		Labels       map[string]string `json:"Labels"`
 	} `json:"Config"`
 	RootFS struct {
 		Type    string   `json:"Type"`
@@ -185,93 +296,167 @@ func (s *ImageSync) inspectRemoteImage(ctx context.Context, image string) (*Imag
 	return &data[0], nil
 }
 
-func (s *ImageSync) prepareDirectories(ctx context.Context) error {
-	if err := os.MkdirAll(s.cfg.LocalStore, 0755); err != nil {
+// prepareDirectories creates the local store and the blob pools of both stores.
+func (s *ImageSync) prepareDirectories(ctx context.Context, image string) error {
+	if err := os.MkdirAll(blobPool(s.cfg.LocalStore), 0755); err != nil {
 		return fmt.Errorf("failed to create local store: %w", err)
 	}
+	if err := markStore(s.cfg.LocalStore); err != nil {
+		return fmt.Errorf("failed to mark local store: %w", err)
+	}
 
-	if _, err := s.runner.RunCommand(ctx, fmt.Sprintf("mkdir -p %s", s.cfg.RemoteStore)); err != nil {
+	// Stores written before the blob pool kept the blobs of every image in its own directory.
+	legacyBlobs := filepath.Join(s.cfg.RemoteStore, normalizeImageName(image), "blobs")
+	if _, err := s.run(ctx, fmt.Sprintf("mkdir -p %s && rm -rf %s", blobPool(s.cfg.RemoteStore), legacyBlobs)); err != nil {
 		return fmt.Errorf("failed to create remote store: %w", err)
 	}
 
 	return nil
 }
 
-func (s *ImageSync) exportAndExtractImage(ctx context.Context, image string) error {
-	imageDir := normalizeImageName(image)
-	localPath := filepath.Join(s.cfg.LocalStore, imageDir)
+// exportAndExtractImage saves the image with docker save and extracts it into the local store:
+// its metadata into the directory of the image and its blobs into the blob pool, skipping the
+// blobs the pool already has. It returns the blobs of the image, which are also listed in the
+// image directory.
+func (s *ImageSync) exportAndExtractImage(ctx context.Context, image string) ([]string, error) {
+	localPath := filepath.Join(s.cfg.LocalStore, normalizeImageName(image))
 
+	// Removing the previous extraction also drops the blobs it kept before the blob pool.
+	if err := os.RemoveAll(localPath); err != nil {
+		return nil, fmt.Errorf("failed to clear image directory: %w", err)
+	}
 	if err := os.MkdirAll(localPath, 0755); err != nil {
-		return fmt.Errorf("failed to create image directory: %w", err)
+		return nil, fmt.Errorf("failed to create image directory: %w", err)
 	}
 
-	tarPath := filepath.Join(localPath, "image.tar")
-	cmd := exec.Command("docker", "save", image, "-o", tarPath)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to save image: %w", err)
-	}
+	// The archive is extracted as docker save writes it, so it never takes disk space itself.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	if err := extractTar(ctx, tarPath, localPath); err != nil {
-		return fmt.Errorf("failed to extract tar: %w", err)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", "save", image)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to save image: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to save image: %w", err)
 	}
 
-	return os.Remove(tarPath)
-}
+	blobs, extractErr := extractTar(ctx, stdout, localPath, blobPool(s.cfg.LocalStore))
+	if extractErr != nil {
+		cancel()
+	}
+	// Wait expects the output to be read to the end.
+	_, _ = io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil && extractErr == nil {
+		return nil, fmt.Errorf("failed to save image: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if extractErr != nil {
+		return nil, fmt.Errorf("failed to extract tar: %w", extractErr)
+	}
 
-func extractTar(ctx context.Context, tarPath, destPath string) error {
-	file, err := os.Open(tarPath)
-	if err != nil {
-		return fmt.Errorf("failed to open tar file: %w", err)
+	if err := writeBlobList(localPath, blobs); err != nil {
+		return nil, fmt.Errorf("failed to list image blobs: %w", err)
 	}
-	defer file.Close()
+	return blobs, nil
+}
 
+// extractTar extracts the image archive read from r: blobs into pool, unless pool already has
+// them, and everything else into destPath. It returns the blobs of the archive.
+func extractTar(ctx context.Context, r io.Reader, destPath, pool string) ([]string, error) {
 	var tr *tar.Reader
 
-	// Check if the file is gzipped
-	gzr, err := gzip.NewReader(file)
-	if err == nil {
+	// Check if the stream is gzipped
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip stream: %w", err)
+		}
 		defer gzr.Close()
 		tr = tar.NewReader(gzr)
 	} else {
-		// If not gzipped, reset the file pointer and read as a regular tar
-		if _, err := file.Seek(0, 0); err != nil {
-			return fmt.Errorf("failed to reset file pointer: %w", err)
-		}
-		tr = tar.NewReader(file)
+		tr = tar.NewReader(br)
 	}
 
+	var blobs []string
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		default:
 		}
 
 		header, err := tr.Next()
 		if err == io.EOF {
-			break // End of archive
+			return blobs, nil
 		}
 		if err != nil {
-			return fmt.Errorf("tar reading error: %w", err)
+			return nil, fmt.Errorf("tar reading error: %w", err)
 		}
 
-		target := filepath.Join(destPath, header.Name)
+		// Entries must stay inside destPath.
+		if !filepath.IsLocal(header.Name) {
+			return nil, fmt.Errorf("invalid path %s in image archive", header.Name)
+		}
+		name := filepath.ToSlash(filepath.Clean(header.Name))
+
+		if name == "blobs" || strings.HasPrefix(name, "blobs/") {
+			if header.Typeflag == tar.TypeDir {
+				continue
+			}
+			blob, ok := strings.CutPrefix(name, "blobs/sha256/")
+			if !ok || strings.Contains(blob, "/") || header.Typeflag != tar.TypeReg {
+				return nil, fmt.Errorf("unsupported blob %s in image archive", header.Name)
+			}
+			if err := extractBlob(tr, pool, blob); err != nil {
+				return nil, err
+			}
+			blobs = append(blobs, blob)
+			continue
+		}
 
+		target := filepath.Join(destPath, header.Name)
 		switch header.Typeflag {
 		case tar.TypeDir:
 			if err := os.MkdirAll(target, 0755); err != nil {
-				return fmt.Errorf("failed to create directory %s: %w", target, err)
+				return nil, fmt.Errorf("failed to create directory %s: %w", target, err)
 			}
 		case tar.TypeReg:
 			if err := extractFile(tr, target); err != nil {
-				return err
+				return nil, err
 			}
 		default:
-			return fmt.Errorf("unsupported file type %b in %s", header.Typeflag, header.Name)
+			return nil, fmt.Errorf("unsupported file type %b in %s", header.Typeflag, header.Name)
 		}
 	}
+}
 
-	return nil
+// extractBlob writes the blob read from r into pool, unless pool already has it. The blob is
+// written to a temporary file and renamed into place, so pool never holds a partial blob.
+func extractBlob(r io.Reader, pool, blob string) error {
+	target := filepath.Join(pool, blob)
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+
+	f, err := os.CreateTemp(pool, blob+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create blob %s: %w", blob, err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write blob %s: %w", blob, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write blob %s: %w", blob, err)
+	}
+	return os.Rename(f.Name(), target)
 }
 
 func extractFile(tr *tar.Reader, target string) error {
@@ -287,50 +472,26 @@ func extractFile(tr *tar.Reader, target string) error {
 	return nil
 }
 
-func (s *ImageSync) syncBlobs(ctx context.Context, image string) error {
-	var localBlobs, remoteBlobs []string
-	var localErr, remoteErr error
-	var wg sync.WaitGroup
-
-	wg.Add(2)
-
-	go func() {
-		defer wg.Done()
-		localBlobs, localErr = s.listLocalBlobs(image)
-	}()
-
-	go func() {
-		defer wg.Done()
-		remoteBlobs, remoteErr = s.listRemoteBlobs(ctx, image)
-	}()
-
-	wg.Wait()
-
-	if localErr != nil {
-		return fmt.Errorf("failed to list local blobs: %w", localErr)
-	}
-	if remoteErr != nil {
-		return fmt.Errorf("failed to list remote blobs: %w", remoteErr)
-	}
-
-	remoteBlobs, err := s.listRemoteBlobs(ctx, image)
+// syncBlobs transfers the blobs missing from the blob pool of the server.
+func (s *ImageSync) syncBlobs(ctx context.Context, blobs []string) error {
+	remoteBlobs, err := s.listRemoteBlobs(ctx)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to list remote blobs: %w", err)
 	}
 
 	// Determine blobs to transfer
 	var blobsToTransfer []string
-	for _, blob := range localBlobs {
+	for _, blob := range blobs {
 		if !contains(remoteBlobs, blob) {
 			blobsToTransfer = append(blobsToTransfer, blob)
 		}
 	}
 
 	// Transfer blobs in parallel batches
-	return s.transferBlobs(ctx, image, blobsToTransfer)
+	return s.transferBlobs(ctx, blobsToTransfer)
 }
 
-func (s *ImageSync) transferBlobs(ctx context.Context, image string, blobs []string) error {
+func (s *ImageSync) transferBlobs(ctx context.Context, blobs []string) error {
 	if len(blobs) == 0 {
 		return nil
 	}
@@ -346,7 +507,7 @@ func (s *ImageSync) transferBlobs(ctx context.Context, image string, blobs []str
 			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
 
-			if err := s.transferBlob(ctx, image, blob); err != nil {
+			if err := s.transferBlob(ctx, blob); err != nil {
 				errChan <- fmt.Errorf("failed to transfer blob %s: %w", blob, err)
 			}
 		}(blob)
@@ -366,28 +527,46 @@ func (s *ImageSync) transferBlobs(ctx context.Context, image string, blobs []str
 	return nil
 }
 
-func (s *ImageSync) loadRemoteImage(ctx context.Context, image string) error {
-	cmd := fmt.Sprintf("cd %s && tar -cf - . | docker load",
-		filepath.Join(s.cfg.RemoteStore, normalizeImageName(image)))
+// loadRemoteImage loads the image on the server from its metadata and its blobs in the blob
+// pool.
+func (s *ImageSync) loadRemoteImage(ctx context.Context, image string, blobs []string) error {
+	paths := make([]string, 0, len(blobs))
+	for _, blob := range blobs {
+		paths = append(paths, "blobs/sha256/"+blob)
+	}
+	// The image directory is in the store, so the blob paths are relative to its parent.
+	cmd := fmt.Sprintf("cd %s && tar -cf - index.json manifest.json oci-layout -C .. %s | docker load",
+		filepath.Join(s.cfg.RemoteStore, normalizeImageName(image)), strings.Join(paths, " "))
 
-	outputReader, err := s.runner.RunCommand(ctx, cmd)
-	if err != nil {
+	if _, err := s.run(ctx, cmd); err != nil {
 		return fmt.Errorf("failed to load remote image: %w", err)
 	}
-	defer outputReader.Close()
+	return nil
+}
 
-	_, err = io.ReadAll(outputReader)
+// run runs a command on the server and returns its output.
+func (s *ImageSync) run(ctx context.Context, command string, args ...string) (string, error) {
+	outputReader, err := s.runner.RunCommand(ctx, command, args...)
 	if err != nil {
-		return fmt.Errorf("failed to read output of command '%s': %w", cmd, err)
+		return "", err
 	}
+	defer outputReader.Close()
 
-	return err
+	output, err := io.ReadAll(outputReader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read output of command '%s': %w", command, err)
+	}
+	return strings.TrimSpace(string(output)), nil
 }
 
 // Helper functions
 
-// compareImageData compares two ImageData structures by sorting slice fields
-// and performing a deep equality check.
+// compareImageData reports whether the local and remote images are the same. The image ID is
+// the digest of the image configuration, which covers the layers, labels, environment and
+// command, so equal IDs make the images the same and different layers make them differ.
+// Different IDs with the same layers are left to a comparison of the configurations, since
+// Docker with the containerd image store identifies images by the digest of their manifest
+// instead.
 func compareImageData(local, remote *ImageData) bool {
 	if local == nil && remote == nil {
 		return true
@@ -396,30 +575,45 @@ func compareImageData(local, remote *ImageData) bool {
 		return false
 	}
 
-	// Make shallow copies so that we don't mutate the original data.
-	l := *local
-	r := *remote
+	if local.ID != "" && local.ID == remote.ID {
+		return true
+	}
+	// docker image inspect lists the diff IDs of the layers, the digests of their content, in
+	// RootFS.Layers.
+	if len(local.RootFS.Layers) > 0 && len(remote.RootFS.Layers) > 0 && !slices.Equal(local.RootFS.Layers, remote.RootFS.Layers) {
+		return false
+	}
+	return compareImageConfig(local, remote)
+}
 
-	// Sort slices within Config
-	sort.Strings(l.Config.Env)
-	sort.Strings(r.Config.Env)
+// compareImageConfig compares the configurations and layers of two images, ignoring the
+// differences between the inspect output of docker versions: the parent image ID in
+// Config.Image and empty values that are null or empty.
+func compareImageConfig(local, remote *ImageData) bool {
+	l, r := normalizeImageData(*local), normalizeImageData(*remote)
+	return reflect.DeepEqual(l, r)
+}
 
-	// While `Cmd` and `Entrypoint` typically preserve order, you can still
-	// sort them if you want to ensure that differences in ordering
-	// don’t cause a mismatch:
-	sort.Strings(l.Config.Cmd)
-	sort.Strings(r.Config.Cmd)
-	sort.Strings(l.Config.Entrypoint)
-	sort.Strings(r.Config.Entrypoint)
+// normalizeImageData returns a copy of data with its slices sorted, empty values set to nil
+// and the fields that don't describe the content of the image cleared.
+func normalizeImageData(data ImageData) ImageData {
+	data.ID = ""
+	data.Config.Image = ""
 
-	// Sort slices within RootFS
-	sort.Strings(l.RootFS.Layers)
-	sort.Strings(r.RootFS.Layers)
-	sort.Strings(l.RootFS.DiffIDs)
-	sort.Strings(r.RootFS.DiffIDs)
+	for _, values := range []*[]string{&data.Config.Env, &data.Config.Cmd, &data.Config.Entrypoint, &data.Config.OnBuild, &data.RootFS.Layers, &data.RootFS.DiffIDs} {
+		if len(*values) == 0 {
+			*values = nil
+			continue
+		}
+		*values = slices.Clone(*values)
+	}
+	if len(data.Config.Labels) == 0 {
+		data.Config.Labels = nil
+	}
 
-	// Now compare the structs directly.
-	return reflect.DeepEqual(l, r)
+	// Env is a set of variables, while the order of the command and the layers matters.
+	sort.Strings(data.Config.Env)
+	return data
 }
 
 func contains(slice []string, item string) bool {
@@ -431,58 +625,63 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
-// listLocalBlobs returns a list of blob hashes from the local blob directory.
-func (s *ImageSync) listLocalBlobs(image string) ([]string, error) {
-	imageDir := normalizeImageName(image)
-	blobPath := filepath.Join(s.cfg.LocalStore, imageDir, "blobs", "sha256")
-
-	entries, err := os.ReadDir(blobPath)
+// listRemoteBlobs returns the blobs in the blob pool of the server.
+func (s *ImageSync) listRemoteBlobs(ctx context.Context) ([]string, error) {
+	output, err := s.run(ctx, "ls", blobPool(s.cfg.RemoteStore))
 	if err != nil {
-		return nil, err
-	}
-
-	var blobs []string
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			blobs = append(blobs, entry.Name())
-		}
+		return nil, nil
 	}
-
-	return blobs, nil
+	return strings.Fields(output), nil
 }
 
-// listRemoteBlobs returns a list of blob hashes from the remote blob directory.
-func (s *ImageSync) listRemoteBlobs(ctx context.Context, image string) ([]string, error) {
-	imageDir := normalizeImageName(image)
-	blobPath := filepath.Join(s.cfg.RemoteStore, imageDir, "blobs", "sha256")
-
-	outputReader, err := s.runner.RunCommand(ctx, "ls", blobPath)
-	if err != nil {
-		return nil, nil
+// transferBlob copies a single blob to the blob pool of the server. A blob already being
+// copied by a concurrent sync is waited for instead of copied again.
+func (s *ImageSync) transferBlob(ctx context.Context, blob string) error {
+	s.mu.Lock()
+	if transfer, ok := s.transfers[blob]; ok {
+		s.mu.Unlock()
+		select {
+		case <-transfer.done:
+			return transfer.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
-	defer outputReader.Close()
+	transfer := &blobTransfer{done: make(chan struct{})}
+	s.transfers[blob] = transfer
+	s.mu.Unlock()
 
-	data, err := io.ReadAll(outputReader)
-	if err != nil {
-		return nil, err
-	}
+	transfer.err = s.copyBlob(ctx, blob)
+	close(transfer.done)
 
-	blobs := strings.Fields(string(data))
-	return blobs, nil
+	s.mu.Lock()
+	delete(s.transfers, blob)
+	s.mu.Unlock()
+	return transfer.err
 }
 
-// transferBlob copies a single blob to the remote host.
-func (s *ImageSync) transferBlob(ctx context.Context, image string, blob string) error {
-	imageDir := normalizeImageName(image)
-	localPath := filepath.Join(s.cfg.LocalStore, imageDir, "blobs", "sha256", blob)
-	remotePath := filepath.Join(s.cfg.RemoteStore, imageDir, "blobs", "sha256", blob)
+// copyBlob copies a blob to a temporary file next to its place in the blob pool of the server
+// and renames it into place, so the pool never holds a partial blob.
+func (s *ImageSync) copyBlob(ctx context.Context, blob string) error {
+	localPath := filepath.Join(blobPool(s.cfg.LocalStore), blob)
+	remotePath := filepath.Join(blobPool(s.cfg.RemoteStore), blob)
+	tmpPath := remotePath + ".tmp"
 
-	_, err := s.runner.RunCommand(ctx, "mkdir", "-p", filepath.Dir(remotePath))
+	if err := s.runner.CopyFile(ctx, localPath, tmpPath); err != nil {
+		return err
+	}
+	output, err := s.run(ctx, "mv", "-f", tmpPath, remotePath)
 	if err != nil {
 		return err
 	}
+	if output != "" {
+		return fmt.Errorf("failed to move blob into place: %s", output)
+	}
 
-	return s.runner.CopyFile(ctx, localPath, remotePath)
+	if info, err := os.Stat(localPath); err == nil {
+		s.transferred.Add(info.Size())
+	}
+	return nil
 }
 
 // transferMetadata copies the image metadata files to the remote host.
@@ -503,12 +702,6 @@ func (s *ImageSync) transferMetadata(ctx context.Context, image string) error {
 			localPath := filepath.Join(localDir, file)
 			remotePath := filepath.Join(remoteDir, file)
 
-			_, err := s.runner.RunCommand(ctx, "mkdir", "-p", filepath.Dir(remotePath))
-			if err != nil {
-				errChan <- err
-				return
-			}
-
 			if err := s.runner.CopyFile(ctx, localPath, remotePath); err != nil {
 				errChan <- err
 			}
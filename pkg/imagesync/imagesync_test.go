@@ -1,13 +1,20 @@
 package imagesync
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/yarlson/ftl/pkg/runner/remote"
@@ -102,8 +109,222 @@ func TestImageSync(t *testing.T) {
 	require.NoError(t, err)
 	require.False(t, needsSync, "Images should be identical after sync")
 
+	transferred := sync.Transferred()
+	require.Positive(t, transferred)
+
 	// Test re-sync with no changes
 	t.Log("Re-syncing...")
 	_, err = sync.Sync(ctx, testImage)
 	require.NoError(t, err)
+	require.Equal(t, transferred, sync.Transferred(), "Nothing should be copied again")
+}
+
+// imageArchive returns a docker save archive holding files, by path.
+func imageArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "blobs/", Typeflag: tar.TypeDir, Mode: 0755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "blobs/sha256/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// loadImageData reads the output of docker image inspect from testdata.
+func loadImageData(t *testing.T, name string) *ImageData {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+
+	var images []ImageData
+	require.NoError(t, json.Unmarshal(data, &images))
+	require.Len(t, images, 1)
+	return &images[0]
+}
+
+func TestCompareImageData(t *testing.T) {
+	tests := []struct {
+		name   string
+		local  string
+		remote string
+		modify func(local, remote *ImageData)
+		same   bool
+	}{
+		{
+			name:   "same image inspected by docker 26 and 24",
+			local:  "inspect-docker26.json",
+			remote: "inspect-docker24.json",
+			same:   true,
+		},
+		{
+			name:   "changed label",
+			local:  "inspect-docker26.json",
+			remote: "inspect-docker24.json",
+			modify: func(local, remote *ImageData) {
+				local.ID = "sha256:0c1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d"
+				local.Config.Labels["org.opencontainers.image.revision"] = "4f2a9c1"
+			},
+		},
+		{
+			name:   "changed layer",
+			local:  "inspect-docker26.json",
+			remote: "inspect-docker24.json",
+			modify: func(local, remote *ImageData) {
+				local.ID = "sha256:0c1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d"
+				local.RootFS.Layers[2] = "sha256:1f2e3d4c5b6a79880f1e2d3c4b5a69780f1e2d3c4b5a69780f1e2d3c4b5a6978"
+			},
+		},
+		{
+			name:   "reordered layers",
+			local:  "inspect-docker24.json",
+			remote: "inspect-docker24.json",
+			modify: func(local, remote *ImageData) {
+				local.ID = ""
+				local.RootFS.Layers[1], local.RootFS.Layers[2] = local.RootFS.Layers[2], local.RootFS.Layers[1]
+			},
+		},
+		{
+			// The containerd image store identifies images by the digest of their manifest.
+			name:   "manifest digest as ID",
+			local:  "inspect-docker26.json",
+			remote: "inspect-docker24.json",
+			modify: func(local, remote *ImageData) {
+				local.ID = "sha256:8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b"
+			},
+			same: true,
+		},
+		{
+			name:   "manifest digest as ID and changed command",
+			local:  "inspect-docker26.json",
+			remote: "inspect-docker24.json",
+			modify: func(local, remote *ImageData) {
+				local.ID = "sha256:8a7b6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b"
+				local.Config.Cmd = []string{"server.js", "node"}
+			},
+		},
+		{
+			name:   "no IDs",
+			local:  "inspect-docker26.json",
+			remote: "inspect-docker24.json",
+			modify: func(local, remote *ImageData) {
+				local.ID, remote.ID = "", ""
+			},
+			same: true,
+		},
+		{
+			name:   "no IDs and changed label",
+			local:  "inspect-docker26.json",
+			remote: "inspect-docker24.json",
+			modify: func(local, remote *ImageData) {
+				local.ID, remote.ID = "", ""
+				local.Config.Labels = nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := loadImageData(t, tt.local), loadImageData(t, tt.remote)
+			if tt.modify != nil {
+				tt.modify(local, remote)
+			}
+			assert.Equal(t, tt.same, compareImageData(local, remote))
+			assert.Equal(t, tt.same, compareImageData(remote, local))
+		})
+	}
+
+	assert.False(t, compareImageData(loadImageData(t, "inspect-docker24.json"), nil))
+}
+
+func TestExtractTar(t *testing.T) {
+	store := t.TempDir()
+	pool := blobPool(store)
+	require.NoError(t, os.MkdirAll(pool, 0755))
+
+	webDir := filepath.Join(store, "shop-web")
+	require.NoError(t, os.MkdirAll(webDir, 0755))
+	blobs, err := extractTar(context.Background(), bytes.NewReader(imageArchive(t, map[string]string{
+		"index.json":        "{}",
+		"manifest.json":     "[]",
+		"blobs/sha256/base": "base layer",
+		"blobs/sha256/web":  "web layer",
+	})), webDir, pool)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"base", "web"}, blobs)
+	assert.FileExists(t, filepath.Join(webDir, "manifest.json"))
+	assert.NoDirExists(t, filepath.Join(webDir, "blobs"))
+
+	// Blobs already in the pool are not written again.
+	require.NoError(t, os.WriteFile(filepath.Join(pool, "base"), []byte("pooled"), 0644))
+	workerDir := filepath.Join(store, "shop-worker")
+	require.NoError(t, os.MkdirAll(workerDir, 0755))
+	blobs, err = extractTar(context.Background(), bytes.NewReader(imageArchive(t, map[string]string{
+		"manifest.json":       "[]",
+		"blobs/sha256/base":   "base layer",
+		"blobs/sha256/worker": "worker layer",
+	})), workerDir, pool)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"base", "worker"}, blobs)
+
+	data, err := os.ReadFile(filepath.Join(pool, "base"))
+	require.NoError(t, err)
+	assert.Equal(t, "pooled", string(data))
+	entries, err := os.ReadDir(pool)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	_, err = extractTar(context.Background(), bytes.NewReader(imageArchive(t, map[string]string{"blobs/other/x": "x"})), workerDir, pool)
+	assert.ErrorContains(t, err, "unsupported blob blobs/other/x")
+}
+
+func TestExtractTar_Gzip(t *testing.T) {
+	var compressed bytes.Buffer
+	gzw := gzip.NewWriter(&compressed)
+	_, err := gzw.Write(imageArchive(t, map[string]string{"manifest.json": "[]", "blobs/sha256/base": "base layer"}))
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+
+	store := t.TempDir()
+	require.NoError(t, os.MkdirAll(blobPool(store), 0755))
+
+	blobs, err := extractTar(context.Background(), &compressed, store, blobPool(store))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"base"}, blobs)
+	assert.FileExists(t, filepath.Join(store, "manifest.json"))
+}
+
+func TestExportAndExtractImage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	dockerClient, err := client.NewClientWithOpts(client.FromEnv)
+	require.NoError(t, err)
+	defer dockerClient.Close()
+	require.NoError(t, setupTestImage(t, dockerClient))
+
+	store := t.TempDir()
+	require.NoError(t, os.MkdirAll(blobPool(store), 0755))
+	sync := NewImageSync(Config{LocalStore: store}, nil)
+
+	blobs, err := sync.exportAndExtractImage(context.Background(), testImage)
+	require.NoError(t, err)
+	require.NotEmpty(t, blobs)
+	assert.FileExists(t, filepath.Join(store, normalizeImageName(testImage), imageManifest))
+	for _, blob := range blobs {
+		assert.FileExists(t, filepath.Join(blobPool(store), blob))
+	}
+
+	// The archive is never written to the store.
+	err = filepath.WalkDir(store, func(path string, entry fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		assert.NotEqual(t, ".tar", filepath.Ext(path), path)
+		return nil
+	})
+	require.NoError(t, err)
 }
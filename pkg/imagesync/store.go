@@ -0,0 +1,311 @@
+package imagesync
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// storeMarker is the file that marks a directory as a local image store.
+const storeMarker = ".ftl-image-store"
+
+// TempStorePrefix prefixes the temporary local stores of deployments.
+const TempStorePrefix = "dockersync-local"
+
+// imageManifest is written by docker save into every extracted image.
+const imageManifest = "manifest.json"
+
+// imageBlobList lists the blobs of an extracted image in the blob pool, one per line.
+const imageBlobList = "blobs.list"
+
+// StoredImage is an extracted image in a local store.
+type StoredImage struct {
+	Name string
+	Path string
+	// Size includes the blobs the image shares with other images of the store.
+	Size     int64
+	Modified time.Time
+	// blobs holds the size of each blob of the image in the blob pool.
+	blobs map[string]int64
+}
+
+// PrunePolicy selects the images Prune removes. Zero values disable a limit.
+type PrunePolicy struct {
+	// MaxAge removes images that weren't synced for longer than this.
+	MaxAge time.Duration
+	// MaxSize removes the least recently synced images until the store fits.
+	MaxSize int64
+	// DryRun reports the images that would be removed without removing them.
+	DryRun bool
+}
+
+// DefaultLocalStore returns the local store used when Config.LocalStore is empty.
+func DefaultLocalStore() string {
+	return filepath.Join(os.Getenv("HOME"), "docker-images")
+}
+
+// blobPool returns the directory of a store holding the blobs of all its images, named by their
+// sha256 digest, so images sharing layers store them once.
+func blobPool(store string) string {
+	return filepath.Join(store, "blobs", "sha256")
+}
+
+// writeBlobList records the blobs of the image extracted into imageDir.
+func writeBlobList(imageDir string, blobs []string) error {
+	return os.WriteFile(filepath.Join(imageDir, imageBlobList), []byte(strings.Join(blobs, "\n")+"\n"), 0644)
+}
+
+// readBlobList returns the blobs of the image extracted into imageDir. Images extracted before
+// the blob pool existed keep their blobs in imageDir and have none.
+func readBlobList(imageDir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(imageDir, imageBlobList))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// markStore marks dir as a local image store.
+func markStore(dir string) error {
+	return os.WriteFile(filepath.Join(dir, storeMarker), nil, 0644)
+}
+
+// IsStore reports whether dir is a local image store: it carries the store marker, or it was
+// created before the marker existed and holds nothing but extracted images.
+func IsStore(dir string) (bool, error) {
+	if _, err := os.Stat(filepath.Join(dir, storeMarker)); err == nil {
+		return true, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	if len(entries) == 0 {
+		return false, nil
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !isImageDir(filepath.Join(dir, entry.Name())) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func isImageDir(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, imageManifest))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// RemoveStore removes the local image store dir, or dir if it is empty. It refuses to remove
+// anything else.
+func RemoveStore(dir string) error {
+	ok, err := IsStore(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check image store %s: %w", dir, err)
+	}
+	if !ok {
+		if os.Remove(dir) == nil {
+			return nil
+		}
+		return fmt.Errorf("refusing to remove %s: not an ftl image store", dir)
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// ListStore returns the extracted images in the store dir, least recently synced first.
+func ListStore(dir string) ([]StoredImage, error) {
+	ok, err := IsStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ftl image store", dir)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []StoredImage
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() || !isImageDir(path) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		size, err := dirSize(path)
+		if err != nil {
+			return nil, err
+		}
+		image := StoredImage{Name: entry.Name(), Path: path, Size: size, Modified: info.ModTime(), blobs: make(map[string]int64)}
+
+		blobs, err := readBlobList(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range blobs {
+			if info, err := os.Stat(filepath.Join(blobPool(dir), blob)); err == nil {
+				image.blobs[blob] = info.Size()
+				image.Size += info.Size()
+			}
+		}
+		images = append(images, image)
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Modified.Before(images[j].Modified)
+	})
+	return images, nil
+}
+
+// Prune removes the images of the store dir selected by policy and returns them, along with
+// the blobs no remaining image uses.
+func Prune(dir string, policy PrunePolicy, now time.Time) ([]StoredImage, error) {
+	images, err := ListStore(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]int)
+	for _, image := range images {
+		for blob := range image.blobs {
+			refs[blob]++
+		}
+	}
+
+	// Blobs of no image are left by interrupted syncs.
+	if !policy.DryRun {
+		if err := removeUnusedBlobs(dir, refs); err != nil {
+			return nil, err
+		}
+	}
+
+	total, err := dirSize(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []StoredImage
+	for _, image := range images {
+		expired := policy.MaxAge > 0 && now.Sub(image.Modified) > policy.MaxAge
+		oversized := policy.MaxSize > 0 && total > policy.MaxSize
+		if !expired && !oversized {
+			continue
+		}
+
+		if !policy.DryRun {
+			if err := os.RemoveAll(image.Path); err != nil {
+				return pruned, fmt.Errorf("failed to remove %s: %w", image.Path, err)
+			}
+		}
+
+		// Blobs shared with the remaining images stay.
+		freed := image.Size
+		for blob, size := range image.blobs {
+			refs[blob]--
+			if refs[blob] > 0 {
+				freed -= size
+				continue
+			}
+			if !policy.DryRun {
+				if err := os.Remove(filepath.Join(blobPool(dir), blob)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return pruned, fmt.Errorf("failed to remove blob %s: %w", blob, err)
+				}
+			}
+		}
+		total -= freed
+		pruned = append(pruned, image)
+	}
+
+	return pruned, nil
+}
+
+// removeUnusedBlobs removes the files of the blob pool of the store dir that refs doesn't count.
+func removeUnusedBlobs(dir string, refs map[string]int) error {
+	entries, err := os.ReadDir(blobPool(dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if refs[entry.Name()] > 0 {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(blobPool(dir), entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove blob %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// TempStores returns the temporary local stores of deployments left in the temp directory.
+// Directories with the same prefix that aren't stores are left out.
+func TempStores() ([]StoredImage, error) {
+	paths, err := filepath.Glob(filepath.Join(os.TempDir(), TempStorePrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var stores []StoredImage
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		if ok, err := IsStore(path); err != nil || !ok && !isEmptyDir(path) {
+			continue
+		}
+		size, err := dirSize(path)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, StoredImage{Name: filepath.Base(path), Path: path, Size: size, Modified: info.ModTime()})
+	}
+	return stores, nil
+}
+
+func isEmptyDir(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	return err == nil && len(entries) == 0
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,135 @@
+package imagesync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeImage extracts a fake image of size bytes into store, last synced at modified.
+func writeImage(t *testing.T, store, name string, size int, modified time.Time) {
+	t.Helper()
+	dir := filepath.Join(store, name)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, imageManifest), []byte("[]"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "blobs", "sha256", "layer"), []byte(strings.Repeat("x", size)), 0644))
+	require.NoError(t, os.Chtimes(dir, modified, modified))
+}
+
+func TestIsStore(t *testing.T) {
+	now := time.Now()
+
+	marked := t.TempDir()
+	require.NoError(t, markStore(marked))
+	ok, err := IsStore(marked)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	legacy := t.TempDir()
+	writeImage(t, legacy, "shop-web", 10, now)
+	ok, err = IsStore(legacy)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	home := t.TempDir()
+	writeImage(t, home, "shop-web", 10, now)
+	require.NoError(t, os.WriteFile(filepath.Join(home, "notes.txt"), []byte("keep me"), 0644))
+	ok, err = IsStore(home)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	err = RemoveStore(home)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not an ftl image store")
+	assert.FileExists(t, filepath.Join(home, "notes.txt"))
+
+	require.NoError(t, RemoveStore(legacy))
+	assert.NoDirExists(t, legacy)
+
+	empty := t.TempDir()
+	require.NoError(t, RemoveStore(empty))
+	assert.NoDirExists(t, empty)
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := t.TempDir()
+	require.NoError(t, markStore(store))
+	writeImage(t, store, "shop-old", 100, now.Add(-30*24*time.Hour))
+	writeImage(t, store, "shop-web", 300, now.Add(-2*time.Hour))
+	writeImage(t, store, "shop-worker", 300, now.Add(-time.Hour))
+	writeImage(t, store, "shop-api", 300, now)
+
+	pruned, err := Prune(store, PrunePolicy{MaxAge: 7 * 24 * time.Hour, MaxSize: 700, DryRun: true}, now)
+	require.NoError(t, err)
+	require.Len(t, pruned, 2)
+	assert.DirExists(t, filepath.Join(store, "shop-old"))
+
+	pruned, err = Prune(store, PrunePolicy{MaxAge: 7 * 24 * time.Hour, MaxSize: 700}, now)
+	require.NoError(t, err)
+	require.Len(t, pruned, 2)
+	assert.Equal(t, "shop-old", pruned[0].Name)
+	assert.Equal(t, "shop-web", pruned[1].Name)
+
+	images, err := ListStore(store)
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	assert.Equal(t, "shop-worker", images[0].Name)
+	assert.Equal(t, "shop-api", images[1].Name)
+
+	_, err = Prune(t.TempDir(), PrunePolicy{MaxAge: time.Hour}, now)
+	assert.Error(t, err)
+}
+
+// writePooledImage extracts a fake image whose blobs of size bytes are in the blob pool of
+// store, last synced at modified.
+func writePooledImage(t *testing.T, store, name string, blobs map[string]int, modified time.Time) {
+	t.Helper()
+	dir := filepath.Join(store, name)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.MkdirAll(blobPool(store), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, imageManifest), []byte("[]"), 0644))
+
+	var names []string
+	for blob, size := range blobs {
+		require.NoError(t, os.WriteFile(filepath.Join(blobPool(store), blob), []byte(strings.Repeat("x", size)), 0644))
+		names = append(names, blob)
+	}
+	require.NoError(t, writeBlobList(dir, names))
+	require.NoError(t, os.Chtimes(dir, modified, modified))
+}
+
+func TestPrune_SharedBlobs(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := t.TempDir()
+	require.NoError(t, markStore(store))
+	writePooledImage(t, store, "shop-old", map[string]int{"base": 500, "old": 100}, now.Add(-30*24*time.Hour))
+	writePooledImage(t, store, "shop-web", map[string]int{"base": 500, "web": 100}, now)
+	require.NoError(t, os.WriteFile(filepath.Join(blobPool(store), "partial.1234.tmp"), []byte("x"), 0644))
+
+	images, err := ListStore(store)
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	assert.Greater(t, images[1].Size, int64(600))
+
+	pruned, err := Prune(store, PrunePolicy{MaxAge: 7 * 24 * time.Hour}, now)
+	require.NoError(t, err)
+	require.Len(t, pruned, 1)
+	assert.Equal(t, "shop-old", pruned[0].Name)
+
+	assert.NoDirExists(t, filepath.Join(store, "shop-old"))
+	assert.FileExists(t, filepath.Join(blobPool(store), "base"))
+	assert.FileExists(t, filepath.Join(blobPool(store), "web"))
+	assert.NoFileExists(t, filepath.Join(blobPool(store), "old"))
+	assert.NoFileExists(t, filepath.Join(blobPool(store), "partial.1234.tmp"))
+
+	// The shared blob counts once towards the size of the store.
+	pruned, err = Prune(store, PrunePolicy{MaxSize: 700}, now)
+	require.NoError(t, err)
+	assert.Empty(t, pruned)
+}
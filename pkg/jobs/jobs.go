@@ -0,0 +1,259 @@
+// Package jobs renders the scripts and crontab entries that run scheduled jobs on a server
+// and reads back the outcome of their last runs.
+package jobs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/shell"
+)
+
+// logLines is the number of output lines kept in a job log.
+const logLines = 1000
+
+// Runner runs commands on the server.
+type Runner interface {
+	RunCommand(ctx context.Context, command string, args ...string) (io.ReadCloser, error)
+}
+
+// Status is the outcome of the last run of a job.
+type Status struct {
+	Started  time.Time
+	Finished time.Time
+	ExitCode int
+}
+
+// Dir returns the directory holding job scripts and state under the project path.
+func Dir(projectPath string) string {
+	return filepath.Join(projectPath, "jobs")
+}
+
+// ScriptPath returns the path of the script that runs the job.
+func ScriptPath(projectPath, name string) string {
+	return filepath.Join(Dir(projectPath), name+".sh")
+}
+
+// EnvPath returns the path of the env file holding the environment of the job.
+func EnvPath(projectPath, name string) string {
+	return filepath.Join(Dir(projectPath), name+".env")
+}
+
+// EnvFile returns the content of the env file of the job, passed to docker run with --env-file
+// so the values aren't written into the script: the environment of the service the job runs
+// in, followed by the job's own. It is empty when the job has no environment.
+func EnvFile(job config.Job, services []config.Service) (string, error) {
+	var env []string
+	if job.Service != "" {
+		service := findService(services, job.Service)
+		if service == nil {
+			return "", fmt.Errorf("job %s refers to unknown service %s", job.Name, job.Service)
+		}
+		env = append(env, service.Env...)
+	}
+	env = append(env, job.Env...)
+
+	var b strings.Builder
+	for _, value := range env {
+		if strings.ContainsAny(value, "\r\n") {
+			name, _, _ := strings.Cut(value, "=")
+			return "", fmt.Errorf("environment variable %s of job %s spans several lines, which an env file can't hold", name, job.Name)
+		}
+		b.WriteString(value + "\n")
+	}
+	return b.String(), nil
+}
+
+// Script returns the shell script that runs job in a one-off container on the project network,
+// appends its output to <name>.log and records the outcome in <name>.status. The environment
+// of the job is read from the file at EnvPath when the job has one.
+func Script(project, projectPath string, job config.Job, services []config.Service) (string, error) {
+	command, err := config.SplitCommand(job.Command)
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"docker", "run", "--rm", "--name", fmt.Sprintf("%s-job-%s", project, job.Name), "--network", project}
+
+	image := job.Image
+	if job.Service != "" {
+		service := findService(services, job.Service)
+		if service == nil {
+			return "", fmt.Errorf("job %s refers to unknown service %s", job.Name, job.Service)
+		}
+		image = service.Image
+		if image == "" {
+			image = fmt.Sprintf("%s-%s", project, service.Name)
+		}
+		for _, volume := range service.Volumes {
+			args = append(args, "-v", config.VolumeMount(project, volume))
+		}
+	}
+	env, err := EnvFile(job, services)
+	if err != nil {
+		return "", err
+	}
+	if env != "" {
+		args = append(args, "--env-file", EnvPath(projectPath, job.Name))
+	}
+	args = append(args, image)
+	args = append(args, command...)
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shell.Quote(arg)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!/bin/sh\n")
+	fmt.Fprintf(&b, "# Runs job %s of project %s. Written by ftl deploy, local changes are overwritten.\n", job.Name, project)
+	fmt.Fprintf(&b, "dir=%s\n", shell.Quote(Dir(projectPath)))
+	fmt.Fprintf(&b, "started=$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ)\n")
+	fmt.Fprintf(&b, "{ %s 2>&1; echo $? > \"$dir/%s.exit\"; } | tee -a \"$dir/%s.log\"\n", strings.Join(quoted, " "), job.Name, job.Name)
+	fmt.Fprintf(&b, "status=$(cat \"$dir/%s.exit\")\n", job.Name)
+	fmt.Fprintf(&b, "echo \"$started $(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ) $status\" > \"$dir/%s.status\"\n", job.Name)
+	fmt.Fprintf(&b, "tail -n %d \"$dir/%s.log\" > \"$dir/%s.log.tmp\" && mv \"$dir/%s.log.tmp\" \"$dir/%s.log\"\n", logLines, job.Name, job.Name, job.Name, job.Name)
+	fmt.Fprintf(&b, "exit \"$status\"\n")
+	return b.String(), nil
+}
+
+// Crontab returns crontab with the block of project jobs replaced by entries for jobs.
+// Lines outside the block are kept as they are. Without jobs the block is removed.
+func Crontab(crontab, project, projectPath string, jobs []config.Job) string {
+	begin, end := blockMarkers(project)
+
+	var lines []string
+	inBlock := false
+	for _, line := range strings.Split(strings.TrimRight(crontab, "\n"), "\n") {
+		switch {
+		case line == begin:
+			inBlock = true
+		case line == end:
+			inBlock = false
+		case !inBlock && line != "":
+			lines = append(lines, line)
+		}
+	}
+
+	if len(jobs) > 0 {
+		lines = append(lines, begin)
+		for _, job := range jobs {
+			lines = append(lines, fmt.Sprintf("%s sh %s >/dev/null 2>&1", job.Schedule, shell.Quote(ScriptPath(projectPath, job.Name))))
+		}
+		lines = append(lines, end)
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Run runs the job on the server right away, copying its output to w, and returns its outcome.
+func Run(ctx context.Context, runner Runner, project, name string, w io.Writer) (*Status, error) {
+	script := ScriptPath(remoteProjectPath(project), name)
+
+	output, err := runCommand(ctx, runner, "sh", "-c", fmt.Sprintf("[ -f %s ] && echo present || true", script))
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(output) != "present" {
+		return nil, fmt.Errorf("job %s is not installed on the server, deploy the project first", name)
+	}
+
+	reader, err := runner.RunCommand(ctx, "sh", "-c", "sh "+script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run job %s: %w", name, err)
+	}
+	_, err = io.Copy(w, reader)
+	reader.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read output of job %s: %w", name, err)
+	}
+
+	statuses, err := Statuses(ctx, runner, project)
+	if err != nil {
+		return nil, err
+	}
+	status, ok := statuses[name]
+	if !ok {
+		return nil, fmt.Errorf("job %s did not record its status", name)
+	}
+	return &status, nil
+}
+
+// Statuses returns the outcome of the last run of every job of the project that has run.
+func Statuses(ctx context.Context, runner Runner, project string) (map[string]Status, error) {
+	dir := Dir(remoteProjectPath(project))
+	output, err := runCommand(ctx, runner, "sh", "-c", fmt.Sprintf(`for f in %s/*.status; do [ -f "$f" ] && echo "$(basename "$f" .status) $(cat "$f")"; done; true`, dir))
+	if err != nil {
+		return nil, err
+	}
+	return ParseStatuses(output), nil
+}
+
+// ParseStatuses parses lines of "<name> <started> <finished> <exit code>" into statuses by job name.
+// Malformed lines are skipped.
+func ParseStatuses(output string) map[string]Status {
+	statuses := make(map[string]Status)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 {
+			continue
+		}
+		started, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			continue
+		}
+		finished, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			continue
+		}
+		exitCode, err := strconv.Atoi(fields[3])
+		if err != nil {
+			continue
+		}
+		statuses[fields[0]] = Status{Started: started, Finished: finished, ExitCode: exitCode}
+	}
+	return statuses
+}
+
+// remoteProjectPath is the project path on the server, left for the server shell to expand.
+func remoteProjectPath(project string) string {
+	return fmt.Sprintf("$HOME/projects/%s", project)
+}
+
+func runCommand(ctx context.Context, runner Runner, command string, args ...string) (string, error) {
+	reader, err := runner.RunCommand(ctx, command, args...)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func blockMarkers(project string) (string, string) {
+	return "# BEGIN ftl jobs " + project, "# END ftl jobs " + project
+}
+
+func findService(services []config.Service, name string) *config.Service {
+	for i := range services {
+		if services[i].Name == name {
+			return &services[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestScript(t *testing.T) {
+	services := []config.Service{
+		{Name: "web", Env: []string{"DATABASE_URL=postgres://db/shop"}, Volumes: []string{"uploads:/app/uploads"}},
+	}
+	job := config.Job{Name: "cleanup", Service: "web", Command: `bin/cleanup --older-than "30 days"; rm -rf /`, Schedule: "@daily", Env: []string{"DRY_RUN=0"}}
+
+	script, err := Script("shop", "/home/deploy/projects/shop", job, services)
+	require.NoError(t, err)
+
+	assert.Contains(t, script, "dir='/home/deploy/projects/shop/jobs'\n")
+	assert.Contains(t, script, "{ 'docker' 'run' '--rm' '--name' 'shop-job-cleanup' '--network' 'shop' "+
+		"'-v' 'shop-uploads:/app/uploads' '--env-file' '/home/deploy/projects/shop/jobs/cleanup.env' "+
+		"'shop-web' 'bin/cleanup' '--older-than' '30 days;' 'rm' '-rf' '/' 2>&1; echo $? > \"$dir/cleanup.exit\"; } | tee -a \"$dir/cleanup.log\"\n")
+	assert.Contains(t, script, "> \"$dir/cleanup.status\"\n")
+	assert.Contains(t, script, "exit \"$status\"\n")
+	assert.NotContains(t, script, "postgres://db/shop")
+
+	env, err := EnvFile(job, services)
+	require.NoError(t, err)
+	assert.Equal(t, "DATABASE_URL=postgres://db/shop\nDRY_RUN=0\n", env)
+
+	job.Env = []string{"KEY=-----BEGIN KEY-----\nabc"}
+	_, err = EnvFile(job, services)
+	assert.ErrorContains(t, err, "environment variable KEY of job cleanup spans several lines")
+}
+
+func TestScriptWithImage(t *testing.T) {
+	job := config.Job{Name: "report", Image: "alpine:3", Command: "echo it's done", Schedule: "0 * * * *"}
+
+	_, err := Script("shop", "/home/deploy/projects/shop", job, nil)
+	require.Error(t, err)
+
+	job.Command = `echo "it's done"`
+	script, err := Script("shop", "/home/deploy/projects/shop", job, nil)
+	require.NoError(t, err)
+	assert.Contains(t, script, `'--network' 'shop' 'alpine:3' 'echo' 'it'\''s done' 2>&1`)
+}
+
+func TestCrontab(t *testing.T) {
+	jobs := []config.Job{
+		{Name: "cleanup", Schedule: "0 3 * * *"},
+		{Name: "report", Schedule: "@hourly"},
+	}
+
+	current := "MAILTO=ops@example.com\n" +
+		"# BEGIN ftl jobs shop\n*/5 * * * * sh '/home/deploy/projects/shop/jobs/old.sh' >/dev/null 2>&1\n# END ftl jobs shop\n" +
+		"# BEGIN ftl jobs blog\n@daily sh '/home/deploy/projects/blog/jobs/backup.sh' >/dev/null 2>&1\n# END ftl jobs blog\n"
+
+	assert.Equal(t, "MAILTO=ops@example.com\n"+
+		"# BEGIN ftl jobs blog\n@daily sh '/home/deploy/projects/blog/jobs/backup.sh' >/dev/null 2>&1\n# END ftl jobs blog\n"+
+		"# BEGIN ftl jobs shop\n"+
+		"0 3 * * * sh '/home/deploy/projects/shop/jobs/cleanup.sh' >/dev/null 2>&1\n"+
+		"@hourly sh '/home/deploy/projects/shop/jobs/report.sh' >/dev/null 2>&1\n"+
+		"# END ftl jobs shop\n", Crontab(current, "shop", "/home/deploy/projects/shop", jobs))
+
+	assert.Equal(t, "MAILTO=ops@example.com\n"+
+		"# BEGIN ftl jobs blog\n@daily sh '/home/deploy/projects/blog/jobs/backup.sh' >/dev/null 2>&1\n# END ftl jobs blog\n",
+		Crontab(current, "shop", "/home/deploy/projects/shop", nil))
+
+	assert.Equal(t, "", Crontab("", "shop", "/home/deploy/projects/shop", nil))
+}
+
+func TestParseStatuses(t *testing.T) {
+	statuses := ParseStatuses("cleanup 2024-05-01T03:00:00Z 2024-05-01T03:00:42Z 0\n" +
+		"report 2024-05-01T04:00:00Z 2024-05-01T04:00:01Z 1\n" +
+		"broken garbage\n")
+
+	require.Len(t, statuses, 2)
+	assert.Equal(t, Status{
+		Started:  time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC),
+		Finished: time.Date(2024, 5, 1, 3, 0, 42, 0, time.UTC),
+		ExitCode: 0,
+	}, statuses["cleanup"])
+	assert.Equal(t, 1, statuses["report"].ExitCode)
+}
@@ -0,0 +1,62 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yarlson/ftl/pkg/proxy"
+)
+
+// ProxyService is the name the proxy is fetched by, as in ftl logs proxy.
+const ProxyService = "proxy"
+
+// AccessRequest is a request parsed from a line of the access log of the proxy.
+type AccessRequest struct {
+	Method string
+	Path   string
+	Status int
+	// Duration is the time nginx took to serve the request. It is -1 for lines in the combined
+	// format, which doesn't record it.
+	Duration time.Duration
+}
+
+// combinedLine matches a line of the combined log format, optionally followed by more fields
+// like those of the main format of the nginx image.
+var combinedLine = regexp.MustCompile(`^\S+ - \S+ \[[^\]]+\] "(\S+) (\S+)[^"]*" (\d{3}) `)
+
+// ParseAccessLine parses a line of the access log of the proxy in the JSON or the combined
+// format. It reports false for other lines, like those of the error log.
+func ParseAccessLine(line string) (AccessRequest, bool) {
+	if strings.HasPrefix(line, "{") {
+		var entry proxy.AccessLogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil || entry.Method == "" {
+			return AccessRequest{}, false
+		}
+		return AccessRequest{
+			Method:   entry.Method,
+			Path:     entry.Path,
+			Status:   entry.Status,
+			Duration: time.Duration(entry.Duration * float64(time.Second)).Round(time.Millisecond),
+		}, true
+	}
+
+	match := combinedLine.FindStringSubmatch(line)
+	if match == nil {
+		return AccessRequest{}, false
+	}
+	status, _ := strconv.Atoi(match[3])
+	return AccessRequest{Method: match[1], Path: match[2], Status: status, Duration: -1}, true
+}
+
+// String formats the request as its method, path, status and duration.
+func (r AccessRequest) String() string {
+	duration := "-"
+	if r.Duration >= 0 {
+		duration = r.Duration.String()
+	}
+	return fmt.Sprintf("%s %s %d %s", r.Method, r.Path, r.Status, duration)
+}
@@ -0,0 +1,64 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAccessLine(t *testing.T) {
+	request, ok := ParseAccessLine(`{"time":"2024-05-01T10:00:00+00:00","remote_addr":"203.0.113.7","host":"shop.example.com",` +
+		`"method":"GET","path":"/api/orders?page=2","protocol":"HTTP/2.0","status":200,"bytes":512,"duration":0.042,` +
+		`"upstream":"172.18.0.5:3000","referer":"","user_agent":"curl/8.5.0"}`)
+	require.True(t, ok)
+	assert.Equal(t, AccessRequest{Method: "GET", Path: "/api/orders?page=2", Status: 200, Duration: 42 * time.Millisecond}, request)
+	assert.Equal(t, "GET /api/orders?page=2 200 42ms", request.String())
+
+	request, ok = ParseAccessLine(`203.0.113.7 - - [01/May/2024:10:00:00 +0000] "POST /login HTTP/1.1" 302 0 "-" "Mozilla/5.0" "-"`)
+	require.True(t, ok)
+	assert.Equal(t, AccessRequest{Method: "POST", Path: "/login", Status: 302, Duration: -1}, request)
+	assert.Equal(t, "POST /login 302 -", request.String())
+
+	for _, line := range []string{
+		`2024/05/01 10:00:00 [error] 29#29: *1 connect() failed (111: Connection refused) while connecting to upstream`,
+		`/docker-entrypoint.sh: Configuration complete; ready for start up`,
+		`{"level":"info"}`,
+	} {
+		_, ok := ParseAccessLine(line)
+		assert.False(t, ok, line)
+	}
+}
+
+func TestFetchLogs_AccessLog(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+
+	runner := &fakeRunner{handler: func(args []string) string {
+		switch args[0] {
+		case "ps":
+			return "my-project-proxy\n"
+		case "inspect":
+			return `[{"Name": "/my-project-proxy", "NetworkSettings": {"Networks": {"my-project": {"Aliases": ["proxy"]}}}}]`
+		}
+		return "2024-05-01T10:00:00.000000000Z 2024/05/01 10:00:00 [notice] 1#1: start worker processes\n" +
+			`2024-05-01T10:00:01.000000000Z {"method":"GET","path":"/","status":200,"duration":0.003}` + "\n" +
+			`2024-05-01T10:00:02.000000000Z {"method":"POST","path":"/checkout","status":502,"duration":1.5}` + "\n"
+	}}
+
+	var buf bytes.Buffer
+	logger := NewLogger(runner)
+	logger.out = &buf
+
+	err := logger.FetchLogs(context.Background(), "my-project", []string{ProxyService}, FetchOptions{Tail: -1, AccessLog: true})
+	require.NoError(t, err)
+	assert.Equal(t, "[proxy] GET / 200 3ms\n[proxy] POST /checkout 502 1.5s\n", buf.String())
+
+	buf.Reset()
+	err = logger.FetchLogs(context.Background(), "my-project", []string{ProxyService}, FetchOptions{Tail: -1, AccessLog: true, Grep: "checkout", Output: OutputJSON})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"timestamp":"2024-05-01T10:00:02Z","service":"proxy","message":"{\"method\":\"POST\",\"path\":\"/checkout\",\"status\":502,\"duration\":1.5}",`+
+		`"method":"POST","path":"/checkout","status":502,"duration":1.5}`, buf.String())
+}
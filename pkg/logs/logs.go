@@ -2,10 +2,12 @@ package logs
 
 import (
 	"bufio"
-	"container/heap"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"os"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -35,14 +37,21 @@ var serviceColors = []string{
 	colorLightRed,
 }
 
+// Output formats supported by the Logger.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
 // Logger provides methods to fetch logs from remote services.
 type Logger struct {
 	runner deployment.Runner
+	out    io.Writer
 }
 
 // NewLogger creates a new Logger instance.
 func NewLogger(runner deployment.Runner) *Logger {
-	return &Logger{runner: runner}
+	return &Logger{runner: runner, out: os.Stdout}
 }
 
 // LogEntry represents a single log line with its timestamp and service info.
@@ -51,6 +60,8 @@ type LogEntry struct {
 	Line      string
 	Service   string
 	Color     string
+	// Request is the request of an access log line, set when FetchOptions.AccessLog is.
+	Request *AccessRequest
 }
 
 // LogEntryHeap is a min-heap of LogEntry based on Timestamp
@@ -74,17 +85,175 @@ func (h *LogEntryHeap) Pop() interface{} {
 	return x
 }
 
+// FetchOptions controls which log lines are fetched.
+type FetchOptions struct {
+	// Follow streams new log lines as they are written.
+	Follow bool
+	// Tail limits the output to the last N lines of each service; negative means all lines.
+	Tail int
+	// Since and Until limit the output to a time range. Both accept a relative duration
+	// such as "1h" or an RFC 3339 timestamp.
+	Since string
+	Until string
+	// Output is the output format, OutputText (default) or OutputJSON.
+	Output string
+	// Grep is a regular expression; only lines whose message matches it are shown.
+	Grep string
+	// AccessLog shows only the lines of the proxy access log, as their method, path, status
+	// and duration.
+	AccessLog bool
+	// MaxDelay is how long a followed entry may be held back waiting for other services
+	// to catch up so that output stays in timestamp order. Defaults to 500ms.
+	MaxDelay time.Duration
+
+	grep *regexp.Regexp
+}
+
+// Validate checks the time filters so that bad values are reported before connecting to the server.
+func (o FetchOptions) Validate() error {
+	var since, until time.Time
+	var err error
+
+	if o.Since != "" {
+		if since, err = parseTimeFilter(o.Since); err != nil {
+			return fmt.Errorf("invalid --since value: %w", err)
+		}
+	}
+	if o.Until != "" {
+		if until, err = parseTimeFilter(o.Until); err != nil {
+			return fmt.Errorf("invalid --until value: %w", err)
+		}
+	}
+	if !since.IsZero() && !until.IsZero() && !since.Before(until) {
+		return fmt.Errorf("--since %s is not before --until %s", o.Since, o.Until)
+	}
+	if o.Grep != "" {
+		if _, err := regexp.Compile(o.Grep); err != nil {
+			return fmt.Errorf("invalid --grep pattern: %w", err)
+		}
+	}
+	if o.Output != "" && o.Output != OutputText && o.Output != OutputJSON {
+		return fmt.Errorf("invalid --output value %q: expected text or json", o.Output)
+	}
+
+	return nil
+}
+
+// parseTimeFilter parses a relative duration ("90m") or a timestamp into an absolute time.
+func parseTimeFilter(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("%q: duration must not be negative", value)
+		}
+		return time.Now().Add(-d), nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%q: expected a duration like 1h or a timestamp like 2024-05-01T10:00:00Z", value)
+}
+
+// dockerLogsArgs builds the docker logs arguments for the container.
+func dockerLogsArgs(container string, opts FetchOptions) []string {
+	args := []string{"logs", "--timestamps"}
+	if opts.Tail >= 0 {
+		args = append(args, fmt.Sprintf("--tail=%d", opts.Tail))
+	}
+	if opts.Since != "" {
+		args = append(args, "--since", opts.Since)
+	}
+	if opts.Until != "" {
+		args = append(args, "--until", opts.Until)
+	}
+	if opts.Follow {
+		args = append(args, "-f")
+	}
+	return append(args, container)
+}
+
+// remoteGrepScript runs docker logs and keeps the lines containing the pattern. Lines that
+// don't start with a timestamp are docker's own messages, like the error of a failed command,
+// and are kept too. The script exits with the status of docker rather than grep's, which is
+// recorded in a file as POSIX sh has no pipefail. The pattern has no regular expression
+// metacharacters, so it matches as a plain string.
+const remoteGrepScript = `pattern="$1"; shift
+status=$(mktemp) || exit 1
+trap 'rm -f "$status"' EXIT
+{ docker "$@" 2>&1; echo "$?" >"$status"; } | grep --line-buffered -e "$pattern" -e '^[^0-9]'
+exit "$(cat "$status")"`
+
+// runLogsCommand starts docker logs for the container. Plain string patterns are filtered
+// on the server with grep so that non-matching lines aren't transferred over SSH.
+func (l *Logger) runLogsCommand(ctx context.Context, container string, opts FetchOptions) (io.ReadCloser, error) {
+	args := dockerLogsArgs(container, opts)
+	if opts.Grep == "" || regexp.QuoteMeta(opts.Grep) != opts.Grep {
+		return l.runner.RunCommand(ctx, "docker", args...)
+	}
+
+	return l.runner.RunCommand(ctx, "sh", append([]string{"-c", remoteGrepScript, "sh", opts.Grep}, args...)...)
+}
+
+// filter reports whether the entry passes the --grep and --access-log filters and returns it
+// with the parsed request of an access log line.
+func (o FetchOptions) filter(entry LogEntry) (LogEntry, bool) {
+	if o.grep != nil && !o.grep.MatchString(entry.Line) {
+		return entry, false
+	}
+	if o.AccessLog {
+		request, ok := ParseAccessLine(entry.Line)
+		if !ok {
+			return entry, false
+		}
+		entry.Request = &request
+	}
+	return entry, true
+}
+
 // FetchLogs fetches and optionally streams logs from the specified services.
-func (l *Logger) FetchLogs(ctx context.Context, project string, services []string, follow bool, tail int) error {
-	if follow {
-		return l.streamLogs(ctx, project, services, tail)
+// Services are looked up by their alias on the project network, falling back to the
+// "<project>-<service>" container name.
+func (l *Logger) FetchLogs(ctx context.Context, project string, services []string, opts FetchOptions) error {
+	if opts.Grep != "" {
+		grep, err := regexp.Compile(opts.Grep)
+		if err != nil {
+			return fmt.Errorf("invalid --grep pattern: %w", err)
+		}
+		opts.grep = grep
+	}
+
+	containers, err := l.resolveContainers(ctx, project)
+	if err != nil {
+		return err
+	}
+
+	for _, svc := range services {
+		if _, ok := containers[svc]; ok {
+			continue
+		}
+
+		name := fmt.Sprintf("%s-%s", project, svc)
+		exists, err := l.containerExists(ctx, name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			containers[svc] = name
+		}
+	}
+
+	if opts.Follow {
+		return l.streamLogs(ctx, containers, services, opts)
 	} else {
-		return l.fetchAndSortLogs(ctx, project, services, tail)
+		return l.fetchAndSortLogs(ctx, containers, services, opts)
 	}
 }
 
 // fetchAndSortLogs fetches logs from services, sorts them by timestamp, and prints them.
-func (l *Logger) fetchAndSortLogs(ctx context.Context, project string, services []string, tail int) error {
+func (l *Logger) fetchAndSortLogs(ctx context.Context, containers map[string]string, services []string, opts FetchOptions) error {
 	var wg sync.WaitGroup
 	logEntries := make([]LogEntry, 0)
 	var mu sync.Mutex
@@ -96,30 +265,16 @@ func (l *Logger) fetchAndSortLogs(ctx context.Context, project string, services
 		go func(svc string) {
 			defer wg.Done()
 
-			containerName := svc
-
-			// Check if the container exists
-			exists, err := l.containerExists(ctx, containerName)
-			if err != nil {
-				console.Error(fmt.Sprintf("Failed to check if service %s exists: %v", svc, err))
+			containerName, ok := containers[svc]
+			if !ok {
+				notice(opts, console.Warning, fmt.Sprintf("Service %s is not running on the server", svc))
 				return
 			}
-			if !exists {
-				console.Warning(fmt.Sprintf("Service %s is not running on the server", svc))
-				return
-			}
-
-			// Prepare the command arguments
-			cmdArgs := []string{"logs", "--timestamps"}
-			if tail >= 0 {
-				cmdArgs = append(cmdArgs, fmt.Sprintf("--tail=%d", tail))
-			}
-			cmdArgs = append(cmdArgs, containerName)
 
 			// Run the docker logs command
-			reader, err := l.runner.RunCommand(ctx, "docker", cmdArgs...)
+			reader, err := l.runLogsCommand(ctx, containerName, opts)
 			if err != nil {
-				console.Error(fmt.Sprintf("Failed to fetch logs for service %s: %v", svc, err))
+				notice(opts, console.Error, fmt.Sprintf("Failed to fetch logs for service %s: %v", svc, err))
 				return
 			}
 			defer reader.Close()
@@ -131,7 +286,11 @@ func (l *Logger) fetchAndSortLogs(ctx context.Context, project string, services
 				line := scanner.Text()
 				entry, err := parseLogLine(line, svc, color)
 				if err != nil {
-					// Ignore lines that cannot be parsed
+					dockerMessage(opts, svc, line)
+					continue
+				}
+				entry, ok := opts.filter(entry)
+				if !ok {
 					continue
 				}
 				mu.Lock()
@@ -139,7 +298,7 @@ func (l *Logger) fetchAndSortLogs(ctx context.Context, project string, services
 				mu.Unlock()
 			}
 			if err := scanner.Err(); err != nil && err != io.EOF {
-				console.Error(fmt.Sprintf("Error reading logs for service %s: %v", svc, err))
+				notice(opts, console.Error, fmt.Sprintf("Error reading logs for service %s: %v", svc, err))
 			}
 		}(service)
 	}
@@ -153,59 +312,39 @@ func (l *Logger) fetchAndSortLogs(ctx context.Context, project string, services
 
 	// Print the sorted log entries
 	for _, entry := range logEntries {
-		formattedLine := fmt.Sprintf("%s[%s]%s %s", entry.Color, entry.Service, colorReset, entry.Line)
-		console.Print(formattedLine)
+		l.printEntry(entry, opts)
 	}
 
 	return nil
 }
 
 // streamLogs streams logs from services, merging them in real-time by timestamp.
-func (l *Logger) streamLogs(ctx context.Context, project string, services []string, tail int) error {
+func (l *Logger) streamLogs(ctx context.Context, containers map[string]string, services []string, opts FetchOptions) error {
 	serviceColorMap := assignColorsToServices(services)
 
-	type logStream struct {
-		entries chan LogEntry
-		done    chan struct{}
-	}
-
-	streams := make(map[string]*logStream)
+	streams := make([]<-chan LogEntry, 0, len(services))
 	var wg sync.WaitGroup
 
 	// Start a goroutine for each service to read logs
 	for _, service := range services {
 		entries := make(chan LogEntry, 100)
-		done := make(chan struct{})
-		streams[service] = &logStream{entries: entries, done: done}
+		streams = append(streams, entries)
 
 		wg.Add(1)
 		go func(svc string) {
 			defer wg.Done()
 			defer close(entries)
-			defer close(done)
 
-			// Check if the container exists
-			exists, err := l.containerExists(ctx, svc)
-			if err != nil {
-				console.Error(fmt.Sprintf("Failed to check if service %s exists: %v", svc, err))
-				return
-			}
-			if !exists {
-				console.Warning(fmt.Sprintf("Service %s is not running on the server", svc))
+			containerName, ok := containers[svc]
+			if !ok {
+				notice(opts, console.Warning, fmt.Sprintf("Service %s is not running on the server", svc))
 				return
 			}
 
-			// Prepare the command arguments
-			cmdArgs := []string{"logs", "--timestamps"}
-			if tail >= 0 {
-				cmdArgs = append(cmdArgs, fmt.Sprintf("--tail=%d", tail))
-			}
-			cmdArgs = append(cmdArgs, "-f", svc)
-
 			// Run the docker logs command
-			reader, err := l.runner.RunCommand(ctx, "docker", cmdArgs...)
+			reader, err := l.runLogsCommand(ctx, containerName, opts)
 			if err != nil {
-				console.Error(fmt.Sprintf("Failed to fetch logs for service %s: %v", svc, err))
+				notice(opts, console.Error, fmt.Sprintf("Failed to fetch logs for service %s: %v", svc, err))
 				return
 			}
 			defer reader.Close()
@@ -217,7 +356,11 @@ func (l *Logger) streamLogs(ctx context.Context, project string, services []stri
 				line := scanner.Text()
 				entry, err := parseLogLine(line, svc, color)
 				if err != nil {
-					// Ignore lines that cannot be parsed
+					dockerMessage(opts, svc, line)
+					continue
+				}
+				entry, ok := opts.filter(entry)
+				if !ok {
 					continue
 				}
 				select {
@@ -227,52 +370,30 @@ func (l *Logger) streamLogs(ctx context.Context, project string, services []stri
 				}
 			}
 			if err := scanner.Err(); err != nil && err != io.EOF {
-				console.Error(fmt.Sprintf("Error reading logs for service %s: %v", svc, err))
+				notice(opts, console.Error, fmt.Sprintf("Error reading logs for service %s: %v", svc, err))
 			}
 		}(service)
 	}
 
-	// Merge logs from all services
-	h := &LogEntryHeap{}
-	heap.Init(h)
-
-	for {
-		// Fill the heap with at least one log entry from each service
-		for svc, stream := range streams {
-			select {
-			case entry, ok := <-stream.entries:
-				if ok {
-					heap.Push(h, entry)
-				} else {
-					// The service has no more logs
-					delete(streams, svc)
-				}
-			default:
-				// No new entries at the moment
-			}
-		}
-
-		if h.Len() == 0 {
-			if len(streams) == 0 {
-				// All streams are done
-				break
-			}
-			// Sleep briefly to wait for new log entries
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
-
-		// Pop the earliest log entry and print it
-		entry := heap.Pop(h).(LogEntry)
-		formattedLine := fmt.Sprintf("%s[%s]%s %s", entry.Color, entry.Service, colorReset, entry.Line)
-		console.Print(formattedLine)
-	}
+	// Merge logs from all services, holding entries back until every stream has caught up
+	mergeStreams(ctx, streams, opts.MaxDelay, func(entry LogEntry) {
+		l.printEntry(entry, opts)
+	})
 
 	// Wait for all goroutines to finish
 	wg.Wait()
 	return nil
 }
 
+// dockerMessage shows a line of docker logs without a timestamp. Every line of the container
+// has one, so the line is a message of docker itself, like the reason docker logs failed.
+func dockerMessage(opts FetchOptions, service, line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	notice(opts, console.Error, fmt.Sprintf("Failed to fetch logs for service %s: %s", service, line))
+}
+
 // parseLogLine parses a log line with a timestamp
 func parseLogLine(line, service string, color string) (LogEntry, error) {
 	// Expected format: "<timestamp> <log message>"
@@ -296,9 +417,66 @@ func parseLogLine(line, service string, color string) (LogEntry, error) {
 	}, nil
 }
 
-// assignColorsToServices assigns colors to services
+// printEntry writes a log entry in the requested output format.
+func (l *Logger) printEntry(entry LogEntry, opts FetchOptions) {
+	if opts.Output == OutputJSON {
+		data, err := json.Marshal(jsonEntry(entry))
+		if err != nil {
+			return
+		}
+		_, _ = fmt.Fprintln(l.out, string(data))
+		return
+	}
+
+	message := entry.Line
+	if entry.Request != nil {
+		message = entry.Request.String()
+	}
+	if entry.Color == "" {
+		_, _ = fmt.Fprintf(l.out, "[%s] %s\n", entry.Service, message)
+		return
+	}
+	_, _ = fmt.Fprintf(l.out, "%s[%s]%s %s\n", entry.Color, entry.Service, colorReset, message)
+}
+
+// jsonEntry returns the JSON output of a log entry. The request fields are only set for access
+// log lines; duration is in seconds and left out when the log format doesn't record it.
+func jsonEntry(entry LogEntry) any {
+	type output struct {
+		Timestamp time.Time `json:"timestamp"`
+		Service   string    `json:"service"`
+		Message   string    `json:"message"`
+		Method    string    `json:"method,omitempty"`
+		Path      string    `json:"path,omitempty"`
+		Status    int       `json:"status,omitempty"`
+		Duration  *float64  `json:"duration,omitempty"`
+	}
+	out := output{Timestamp: entry.Timestamp, Service: entry.Service, Message: entry.Line}
+	if request := entry.Request; request != nil {
+		out.Method, out.Path, out.Status = request.Method, request.Path, request.Status
+		if request.Duration >= 0 {
+			seconds := request.Duration.Seconds()
+			out.Duration = &seconds
+		}
+	}
+	return out
+}
+
+// notice prints a warning or error. In JSON mode it goes to stderr so that stdout only carries log entries.
+func notice(opts FetchOptions, show func(a ...interface{}), message string) {
+	if opts.Output == OutputJSON {
+		_, _ = fmt.Fprintln(os.Stderr, message)
+		return
+	}
+	show(message)
+}
+
+// assignColorsToServices assigns colors to services. No colors are assigned when NO_COLOR is set.
 func assignColorsToServices(services []string) map[string]string {
 	serviceColorMap := make(map[string]string)
+	if _, noColor := os.LookupEnv("NO_COLOR"); noColor {
+		return serviceColorMap
+	}
 	for i, service := range services {
 		color := serviceColors[i%len(serviceColors)]
 		serviceColorMap[service] = color
@@ -306,6 +484,52 @@ func assignColorsToServices(services []string) map[string]string {
 	return serviceColorMap
 }
 
+// resolveContainers maps the aliases of the containers on the project network to container names.
+func (l *Logger) resolveContainers(ctx context.Context, project string) (map[string]string, error) {
+	outputReader, err := l.runner.RunCommand(ctx, "docker", "ps", "-a", "--filter", fmt.Sprintf("network=%s", project), "--format", "{{.Names}}")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	names := parseOutput(outputReader)
+	outputReader.Close()
+
+	containers := make(map[string]string)
+	if len(names) == 0 {
+		return containers, nil
+	}
+
+	inspectReader, err := l.runner.RunCommand(ctx, "docker", append([]string{"inspect"}, names...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect containers: %w", err)
+	}
+	defer inspectReader.Close()
+
+	var infos []struct {
+		Name            string
+		NetworkSettings struct {
+			Networks map[string]struct {
+				Aliases []string
+			}
+		}
+	}
+	if err := json.NewDecoder(inspectReader).Decode(&infos); err != nil {
+		return nil, fmt.Errorf("failed to parse container details: %w", err)
+	}
+
+	for _, info := range infos {
+		name := strings.TrimPrefix(info.Name, "/")
+		network, ok := info.NetworkSettings.Networks[project]
+		if !ok {
+			continue
+		}
+		for _, alias := range network.Aliases {
+			containers[alias] = name
+		}
+	}
+
+	return containers, nil
+}
+
 // containerExists checks if the container with the given name exists.
 func (l *Logger) containerExists(ctx context.Context, containerName string) (bool, error) {
 	outputReader, err := l.runner.RunCommand(ctx, "docker", "ps", "-a", "--format", "{{.Names}}")
@@ -0,0 +1,260 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/runner/remote"
+)
+
+func TestFetchOptions_Validate(t *testing.T) {
+	valid := []FetchOptions{
+		{},
+		{Since: "1h"},
+		{Since: "2024-05-01T10:00:00Z"},
+		{Since: "2024-05-01"},
+		{Since: "2h", Until: "30m"},
+		{Since: "2024-05-01T10:00:00Z", Until: "2024-05-01T11:00:00Z"},
+	}
+	for _, opts := range valid {
+		assert.NoError(t, opts.Validate(), "%+v", opts)
+	}
+
+	invalid := []FetchOptions{
+		{Since: "yesterday"},
+		{Until: "-5m"},
+		{Since: "30m", Until: "2h"},
+		{Since: "2024-05-01T11:00:00Z", Until: "2024-05-01T10:00:00Z"},
+	}
+	for _, opts := range invalid {
+		assert.Error(t, opts.Validate(), "%+v", opts)
+	}
+}
+
+func TestDockerLogsArgs(t *testing.T) {
+	args := dockerLogsArgs("app", FetchOptions{Tail: 50, Since: "1h", Until: "10m", Follow: true})
+	assert.Equal(t, []string{"logs", "--timestamps", "--tail=50", "--since", "1h", "--until", "10m", "-f", "app"}, args)
+
+	args = dockerLogsArgs("app", FetchOptions{Tail: -1})
+	assert.Equal(t, []string{"logs", "--timestamps", "app"}, args)
+}
+
+// fakeRunner answers docker commands from canned output and records what was run.
+type fakeRunner struct {
+	mu       sync.Mutex
+	commands []string
+	handler  func(args []string) string
+}
+
+func (r *fakeRunner) RunCommand(ctx context.Context, command string, args ...string) (io.ReadCloser, error) {
+	r.mu.Lock()
+	r.commands = append(r.commands, strings.Join(append([]string{command}, args...), " "))
+	r.mu.Unlock()
+
+	return io.NopCloser(strings.NewReader(r.handler(args))), nil
+}
+
+func (r *fakeRunner) RunCommandWithInput(ctx context.Context, stdin io.Reader, command string, args ...string) (io.ReadCloser, error) {
+	return r.RunCommand(ctx, command, args...)
+}
+
+func (r *fakeRunner) CopyFile(ctx context.Context, from, to string) error {
+	return nil
+}
+
+func (r *fakeRunner) CopyDir(ctx context.Context, localDir, remoteDir string, opts remote.CopyDirOptions) error {
+	return nil
+}
+
+func (r *fakeRunner) CopyReader(ctx context.Context, src io.Reader, dst string, opts remote.CopyOptions) error {
+	return nil
+}
+
+func (r *fakeRunner) Host() string {
+	return "fake-host"
+}
+
+func (r *fakeRunner) logsCommands() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var commands []string
+	for _, cmd := range r.commands {
+		if strings.HasPrefix(cmd, "docker logs") {
+			commands = append(commands, cmd)
+		}
+	}
+	sort.Strings(commands)
+	return commands
+}
+
+const inspectOutput = `[
+  {"Name": "/my-project-web", "NetworkSettings": {"Networks": {"my-project": {"Aliases": ["web", "1a2b3c"]}}}},
+  {"Name": "/my-project-postgres", "NetworkSettings": {"Networks": {"my-project": {"Aliases": ["postgres"]}}}}
+]`
+
+func TestFetchLogs_ResolvesPrefixedContainers(t *testing.T) {
+	runner := &fakeRunner{handler: func(args []string) string {
+		switch {
+		case args[0] == "ps" && args[2] == "--filter":
+			return "my-project-web\nmy-project-postgres\n"
+		case args[0] == "ps":
+			return "my-project-web\nmy-project-postgres\nmy-project-worker\nother-container\n"
+		case args[0] == "inspect":
+			return inspectOutput
+		case args[0] == "logs":
+			return "2024-05-01T10:00:00.000000000Z hello\n"
+		}
+		return ""
+	}}
+
+	logger := NewLogger(runner)
+	err := logger.FetchLogs(context.Background(), "my-project", []string{"web", "postgres", "worker", "missing"}, FetchOptions{Tail: -1})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"docker logs --timestamps my-project-postgres",
+		"docker logs --timestamps my-project-web",
+		"docker logs --timestamps my-project-worker",
+	}, runner.logsCommands())
+}
+
+func TestFetchLogs_StreamUsesResolvedNames(t *testing.T) {
+	runner := &fakeRunner{handler: func(args []string) string {
+		switch args[0] {
+		case "ps":
+			return "my-project-web\n"
+		case "inspect":
+			return inspectOutput
+		case "logs":
+			return "2024-05-01T10:00:00.000000000Z hello\n"
+		}
+		return ""
+	}}
+
+	logger := NewLogger(runner)
+	err := logger.FetchLogs(context.Background(), "my-project", []string{"web"}, FetchOptions{Tail: 10, Follow: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"docker logs --timestamps --tail=10 -f my-project-web"}, runner.logsCommands())
+}
+
+func TestPrintEntry(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &Logger{out: &buf}
+	entry := LogEntry{
+		Timestamp: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		Line:      "GET / 200",
+		Service:   "web",
+		Color:     colorLightBlue,
+	}
+
+	logger.printEntry(entry, FetchOptions{Output: OutputJSON})
+	assert.JSONEq(t, `{"timestamp":"2024-05-01T10:00:00Z","service":"web","message":"GET / 200"}`, buf.String())
+
+	buf.Reset()
+	logger.printEntry(entry, FetchOptions{})
+	assert.Equal(t, colorLightBlue+"[web]"+colorReset+" GET / 200\n", buf.String())
+}
+
+func TestAssignColorsToServices_NoColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+
+	colors := assignColorsToServices([]string{"web", "postgres"})
+	assert.Empty(t, colors["web"])
+
+	var buf bytes.Buffer
+	logger := &Logger{out: &buf}
+	logger.printEntry(LogEntry{Service: "web", Line: "ready", Color: colors["web"]}, FetchOptions{})
+	assert.Equal(t, "[web] ready\n", buf.String())
+}
+
+func TestFetchOptions_ValidateOutput(t *testing.T) {
+	assert.NoError(t, FetchOptions{Output: OutputJSON}.Validate())
+	assert.Error(t, FetchOptions{Output: "yaml"}.Validate())
+}
+
+func TestFetchLogs_Grep(t *testing.T) {
+	logOutput := "2024-05-01T10:00:00.000000000Z GET /health 200\n" +
+		"2024-05-01T10:00:01.000000000Z POST /login 500\n" +
+		"2024-05-01T10:00:02.000000000Z GET /login 200\n"
+
+	tests := []struct {
+		name     string
+		pattern  string
+		remote   bool
+		expected string
+	}{
+		{name: "plain string is pushed to the server", pattern: "login", remote: true, expected: "[web] POST /login 500\n[web] GET /login 200\n"},
+		{name: "regex is filtered locally", pattern: `^GET .* 200$`, remote: false, expected: "[web] GET /health 200\n[web] GET /login 200\n"},
+		{name: "timestamp is not matched", pattern: "2024", remote: true, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NO_COLOR", "1")
+
+			runner := &fakeRunner{handler: func(args []string) string {
+				switch args[0] {
+				case "ps":
+					return "my-project-web\n"
+				case "inspect":
+					return inspectOutput
+				}
+				// docker logs, either directly or wrapped in sh -c
+				return logOutput
+			}}
+
+			var buf bytes.Buffer
+			logger := NewLogger(runner)
+			logger.out = &buf
+
+			err := logger.FetchLogs(context.Background(), "my-project", []string{"web"}, FetchOptions{Tail: -1, Grep: tt.pattern})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, buf.String())
+
+			last := runner.commands[len(runner.commands)-1]
+			if tt.remote {
+				assert.True(t, strings.HasPrefix(last, "sh -c "), last)
+				assert.Contains(t, last, "grep --line-buffered")
+			} else {
+				assert.Equal(t, "docker logs --timestamps my-project-web", last)
+			}
+		})
+	}
+}
+
+func TestRemoteGrepScript(t *testing.T) {
+	bin := t.TempDir()
+	docker := "#!/bin/sh\n" +
+		"echo '2024-05-01T10:00:00.000000000Z GET /login 200'\n" +
+		"echo '2024-05-01T10:00:01.000000000Z GET /health 200'\n" +
+		"echo 'Error response from daemon: container is gone' >&2\n" +
+		"exit 3\n"
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "docker"), []byte(docker), 0o755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	output, err := exec.Command("sh", "-c", remoteGrepScript, "sh", "login", "logs", "--timestamps", "web").Output()
+
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, exitErr.ExitCode())
+	assert.Equal(t, "2024-05-01T10:00:00.000000000Z GET /login 200\nError response from daemon: container is gone\n", string(output))
+}
+
+func TestFetchOptions_ValidateGrep(t *testing.T) {
+	assert.NoError(t, FetchOptions{Grep: "error|warn"}.Validate())
+	assert.Error(t, FetchOptions{Grep: "("}.Validate())
+}
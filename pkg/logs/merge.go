@@ -0,0 +1,113 @@
+package logs
+
+import (
+	"container/heap"
+	"context"
+	"time"
+)
+
+// defaultMaxDelay is how long an entry may be held back while waiting for a silent stream.
+const defaultMaxDelay = 500 * time.Millisecond
+
+type streamEvent struct {
+	stream int
+	entry  LogEntry
+	closed bool
+}
+
+type streamState struct {
+	open         bool
+	watermark    time.Time
+	lastActivity time.Time
+}
+
+// mergeStreams merges log streams that are each ordered by timestamp and calls emit for every
+// entry in global timestamp order. An entry is emitted once every open stream has produced an
+// entry at least as new, or once the streams that haven't have been silent for maxDelay.
+func mergeStreams(ctx context.Context, streams []<-chan LogEntry, maxDelay time.Duration, emit func(LogEntry)) {
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxDelay
+	}
+
+	events := make(chan streamEvent)
+	for i, stream := range streams {
+		go func(i int, stream <-chan LogEntry) {
+			for entry := range stream {
+				select {
+				case events <- streamEvent{stream: i, entry: entry}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case events <- streamEvent{stream: i, closed: true}:
+			case <-ctx.Done():
+			}
+		}(i, stream)
+	}
+
+	now := time.Now()
+	states := make([]streamState, len(streams))
+	for i := range states {
+		states[i] = streamState{open: true, lastActivity: now}
+	}
+	open := len(streams)
+
+	h := &LogEntryHeap{}
+	heap.Init(h)
+
+	// blocking returns how long the earliest pending entry still has to wait, or false if it can be emitted.
+	blocking := func(now time.Time) (time.Duration, bool) {
+		top := (*h)[0]
+		var wait time.Duration
+		blocked := false
+		for _, s := range states {
+			if !s.open || !s.watermark.Before(top.Timestamp) {
+				continue
+			}
+			remaining := s.lastActivity.Add(maxDelay).Sub(now)
+			if remaining <= 0 {
+				continue
+			}
+			if !blocked || remaining < wait {
+				wait = remaining
+			}
+			blocked = true
+		}
+		return wait, blocked
+	}
+
+	for {
+		var timer <-chan time.Time
+		for h.Len() > 0 {
+			wait, blocked := blocking(time.Now())
+			if blocked {
+				timer = time.After(wait)
+				break
+			}
+			emit(heap.Pop(h).(LogEntry))
+		}
+
+		if open == 0 && h.Len() == 0 {
+			return
+		}
+
+		select {
+		case ev := <-events:
+			s := &states[ev.stream]
+			s.lastActivity = time.Now()
+			if ev.closed {
+				s.open = false
+				open--
+				continue
+			}
+			if ev.entry.Timestamp.After(s.watermark) {
+				s.watermark = ev.entry.Timestamp
+			}
+			heap.Push(h, ev.entry)
+		case <-timer:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
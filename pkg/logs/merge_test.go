@@ -0,0 +1,70 @@
+package logs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func entryAt(service string, second int) LogEntry {
+	return LogEntry{
+		Timestamp: time.Date(2024, 5, 1, 10, 0, second, 0, time.UTC),
+		Service:   service,
+	}
+}
+
+func collect(streams []<-chan LogEntry, maxDelay time.Duration) []string {
+	var got []string
+	mergeStreams(context.Background(), streams, maxDelay, func(entry LogEntry) {
+		got = append(got, entry.Timestamp.Format("05")+entry.Service)
+	})
+	return got
+}
+
+func TestMergeStreams_InterleavedTimestamps(t *testing.T) {
+	web := make(chan LogEntry, 10)
+	worker := make(chan LogEntry, 10)
+
+	// web produces its entries immediately, worker lags behind with older lines.
+	web <- entryAt("web", 1)
+	web <- entryAt("web", 3)
+	web <- entryAt("web", 5)
+	close(web)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		worker <- entryAt("worker", 2)
+		time.Sleep(50 * time.Millisecond)
+		worker <- entryAt("worker", 4)
+		close(worker)
+	}()
+
+	got := collect([]<-chan LogEntry{web, worker}, time.Second)
+
+	assert.Equal(t, []string{"01web", "02worker", "03web", "04worker", "05web"}, got)
+}
+
+func TestMergeStreams_SilentStreamDoesNotBlockForever(t *testing.T) {
+	web := make(chan LogEntry, 10)
+	idle := make(chan LogEntry)
+	defer close(idle)
+
+	web <- entryAt("web", 1)
+
+	emitted := make(chan LogEntry, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mergeStreams(ctx, []<-chan LogEntry{web, idle}, 50*time.Millisecond, func(entry LogEntry) {
+		emitted <- entry
+	})
+
+	select {
+	case entry := <-emitted:
+		assert.Equal(t, "web", entry.Service)
+	case <-time.After(time.Second):
+		t.Fatal("entry was not emitted after max delay")
+	}
+}
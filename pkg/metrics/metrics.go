@@ -0,0 +1,231 @@
+// Package metrics records the durations and outcome of a deployment from its events and exports
+// them in the Prometheus exposition format, to a textfile or a Pushgateway.
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/deployment"
+)
+
+// pushTimeout stops a push to the Pushgateway that hasn't been answered.
+const pushTimeout = 10 * time.Second
+
+// phaseKey identifies a phase of the deployment of a service.
+type phaseKey struct {
+	service string
+	phase   deployment.Phase
+}
+
+// Recorder follows the events of a deployment and keeps what its metrics report.
+type Recorder struct {
+	project     string
+	environment string
+	clock       func() time.Time
+
+	started  time.Time
+	finished time.Time
+	success  bool
+	steps    map[string]time.Duration
+	phases   map[phaseKey]time.Duration
+	updated  int
+	synced   int64
+}
+
+// New returns a recorder of a deployment of project, in environment if it isn't empty.
+func New(project, environment string) *Recorder {
+	return &Recorder{
+		project:     project,
+		environment: environment,
+		clock:       time.Now,
+		steps:       make(map[string]time.Duration),
+		phases:      make(map[phaseKey]time.Duration),
+	}
+}
+
+// Start records the start of the deployment.
+func (r *Recorder) Start() {
+	r.started = r.clock()
+}
+
+// Observe records the duration of the step an event completes or fails, and the phases of the
+// service or dependency it deployed.
+func (r *Recorder) Observe(event deployment.Event) {
+	if event.Type != deployment.EventCompleted && event.Type != deployment.EventFailed {
+		return
+	}
+
+	r.steps[event.Step] += event.Time.Sub(event.Started)
+	for phase, duration := range event.Phases {
+		r.phases[phaseKey{service: event.Service, phase: phase}] += duration
+	}
+	if event.Changed {
+		r.updated++
+	}
+}
+
+// AddSynced records n bytes of image layers copied to the server.
+func (r *Recorder) AddSynced(n int64) {
+	r.synced += n
+}
+
+// Finish records the end of the deployment, which failed when err is set.
+func (r *Recorder) Finish(err error) {
+	r.finished = r.clock()
+	r.success = err == nil
+}
+
+// Format returns the metrics in the Prometheus text exposition format.
+func (r *Recorder) Format() []byte {
+	var b bytes.Buffer
+	labels := r.labels()
+
+	success := 0
+	if r.success {
+		success = 1
+	}
+	writeMetric(&b, "ftl_deploy_success", "Whether the last deployment succeeded.")
+	writeSample(&b, "ftl_deploy_success", labels, float64(success))
+	writeMetric(&b, "ftl_deploy_timestamp_seconds", "When the last deployment finished, as a Unix timestamp.")
+	writeSample(&b, "ftl_deploy_timestamp_seconds", labels, float64(r.finished.Unix()))
+	writeMetric(&b, "ftl_deploy_duration_seconds", "How long the last deployment took.")
+	writeSample(&b, "ftl_deploy_duration_seconds", labels, r.finished.Sub(r.started).Seconds())
+	writeMetric(&b, "ftl_deploy_services_updated", "Services and dependencies whose container the last deployment created or replaced.")
+	writeSample(&b, "ftl_deploy_services_updated", labels, float64(r.updated))
+	writeMetric(&b, "ftl_deploy_image_sync_bytes", "Bytes of image layers the last deployment copied to the server.")
+	writeSample(&b, "ftl_deploy_image_sync_bytes", labels, float64(r.synced))
+
+	steps := make([]string, 0, len(r.steps))
+	for step := range r.steps {
+		steps = append(steps, step)
+	}
+	sort.Strings(steps)
+	writeMetric(&b, "ftl_deploy_step_duration_seconds", "How long each step of the last deployment took.")
+	for _, step := range steps {
+		writeSample(&b, "ftl_deploy_step_duration_seconds", append(r.labels(), "step", step), r.steps[step].Seconds())
+	}
+
+	phases := make([]phaseKey, 0, len(r.phases))
+	for key := range r.phases {
+		phases = append(phases, key)
+	}
+	sort.Slice(phases, func(i, j int) bool {
+		if phases[i].service != phases[j].service {
+			return phases[i].service < phases[j].service
+		}
+		return phases[i].phase < phases[j].phase
+	})
+	writeMetric(&b, "ftl_deploy_phase_duration_seconds", "How long each phase of the services and dependencies of the last deployment took.")
+	for _, key := range phases {
+		writeSample(&b, "ftl_deploy_phase_duration_seconds", append(r.labels(), "service", key.service, "phase", string(key.phase)), r.phases[key].Seconds())
+	}
+
+	return b.Bytes()
+}
+
+// labels returns the labels shared by all metrics, as pairs of names and values.
+func (r *Recorder) labels() []string {
+	labels := []string{"project", r.project}
+	if r.environment != "" {
+		labels = append(labels, "environment", r.environment)
+	}
+	return labels
+}
+
+func writeMetric(b *bytes.Buffer, name, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+func writeSample(b *bytes.Buffer, name string, labels []string, value float64) {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	fmt.Fprintf(b, "%s{%s} %s\n", name, strings.Join(pairs, ","), strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// labelEscaper escapes label values the way the exposition format expects.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Export writes the metrics to the textfile and pushes them to the Pushgateway of cfg, when set.
+func (r *Recorder) Export(cfg config.Metrics) error {
+	data := r.Format()
+
+	var errs []error
+	if cfg.Textfile != "" {
+		if err := writeTextfile(cfg.Textfile, data); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write metrics to %s: %w", cfg.Textfile, err))
+		}
+	}
+	if cfg.Pushgateway != "" {
+		if err := r.push(cfg.Pushgateway, data); err != nil {
+			errs = append(errs, fmt.Errorf("failed to push metrics: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writeTextfile writes data to a temporary file next to path and renames it into place, so a
+// collector never reads a file half written.
+func writeTextfile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// push replaces the metrics of the project on the Pushgateway, grouped by job, project and
+// environment.
+func (r *Recorder) push(gateway string, data []byte) error {
+	target := strings.TrimSuffix(gateway, "/") + "/metrics/job/ftl/project/" + url.PathEscape(r.project)
+	if r.environment != "" {
+		target += "/environment/" + url.PathEscape(r.environment)
+	}
+
+	// The outcome of a cancelled deployment is pushed too, so the context of the deployment
+	// isn't used.
+	ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pushgateway answered %s", resp.Status)
+	}
+	return nil
+}
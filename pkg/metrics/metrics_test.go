@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/deployment"
+)
+
+// newTestRecorder returns a recorder of a deployment that takes 45 seconds.
+func newTestRecorder(environment string) *Recorder {
+	r := New("shop", environment)
+	now := time.Unix(1714557600, 0)
+	r.clock = func() time.Time {
+		now = now.Add(45 * time.Second)
+		return now
+	}
+	return r
+}
+
+func TestRecorder(t *testing.T) {
+	r := newTestRecorder("")
+	started := time.Unix(1714557600, 0)
+
+	r.Start()
+	r.Observe(deployment.Event{Type: deployment.EventStarted, Step: "service/web", Service: "web", Started: started, Time: started})
+	r.Observe(deployment.Event{Type: deployment.EventCompleted, Step: "service/web", Service: "web", Changed: true, Started: started, Time: started.Add(20 * time.Second),
+		Phases: map[deployment.Phase]time.Duration{deployment.PhasePull: 12 * time.Second, deployment.PhaseHealth: 5500 * time.Millisecond}})
+	r.Observe(deployment.Event{Type: deployment.EventCompleted, Step: "dependency/redis", Service: "redis", Started: started, Time: started.Add(time.Second)})
+	r.Observe(deployment.Event{Type: deployment.EventCompleted, Step: "proxy", Started: started, Time: started.Add(2 * time.Second)})
+	r.Observe(deployment.Event{Type: deployment.EventCompleted, Step: "proxy", Started: started, Time: started.Add(time.Second)})
+	r.AddSynced(1048576)
+	r.Finish(nil)
+
+	assert.Equal(t, `# HELP ftl_deploy_success Whether the last deployment succeeded.
+# TYPE ftl_deploy_success gauge
+ftl_deploy_success{project="shop"} 1
+# HELP ftl_deploy_timestamp_seconds When the last deployment finished, as a Unix timestamp.
+# TYPE ftl_deploy_timestamp_seconds gauge
+ftl_deploy_timestamp_seconds{project="shop"} 1714557690
+# HELP ftl_deploy_duration_seconds How long the last deployment took.
+# TYPE ftl_deploy_duration_seconds gauge
+ftl_deploy_duration_seconds{project="shop"} 45
+# HELP ftl_deploy_services_updated Services and dependencies whose container the last deployment created or replaced.
+# TYPE ftl_deploy_services_updated gauge
+ftl_deploy_services_updated{project="shop"} 1
+# HELP ftl_deploy_image_sync_bytes Bytes of image layers the last deployment copied to the server.
+# TYPE ftl_deploy_image_sync_bytes gauge
+ftl_deploy_image_sync_bytes{project="shop"} 1048576
+# HELP ftl_deploy_step_duration_seconds How long each step of the last deployment took.
+# TYPE ftl_deploy_step_duration_seconds gauge
+ftl_deploy_step_duration_seconds{project="shop",step="dependency/redis"} 1
+ftl_deploy_step_duration_seconds{project="shop",step="proxy"} 3
+ftl_deploy_step_duration_seconds{project="shop",step="service/web"} 20
+# HELP ftl_deploy_phase_duration_seconds How long each phase of the services and dependencies of the last deployment took.
+# TYPE ftl_deploy_phase_duration_seconds gauge
+ftl_deploy_phase_duration_seconds{project="shop",service="web",phase="health"} 5.5
+ftl_deploy_phase_duration_seconds{project="shop",service="web",phase="pull"} 12
+`, string(r.Format()))
+}
+
+func TestRecorder_Failed(t *testing.T) {
+	r := newTestRecorder("staging")
+	r.Start()
+	r.Finish(errors.New("service web failed"))
+
+	assert.Contains(t, string(r.Format()), `ftl_deploy_success{project="shop",environment="staging"} 0`)
+}
+
+func TestExport_Textfile(t *testing.T) {
+	r := newTestRecorder("")
+	r.Start()
+	r.Finish(nil)
+
+	path := filepath.Join(t.TempDir(), "ftl.prom")
+	require.NoError(t, os.WriteFile(path, []byte("stale"), 0644))
+	require.NoError(t, r.Export(config.Metrics{Textfile: path}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, r.Format(), data)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the temporary file is renamed into place")
+
+	err = r.Export(config.Metrics{Textfile: filepath.Join(t.TempDir(), "missing", "ftl.prom")})
+	assert.ErrorContains(t, err, "failed to write metrics")
+}
+
+func TestExport_Pushgateway(t *testing.T) {
+	var method, path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(data)
+	}))
+	defer server.Close()
+
+	r := newTestRecorder("staging")
+	r.Start()
+	r.Finish(nil)
+	require.NoError(t, r.Export(config.Metrics{Pushgateway: server.URL + "/"}))
+
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, "/metrics/job/ftl/project/shop/environment/staging", path)
+	assert.Equal(t, string(r.Format()), body)
+}
+
+func TestExport_PushgatewayError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad metrics", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	r := newTestRecorder("")
+	r.Start()
+	r.Finish(nil)
+	assert.ErrorContains(t, r.Export(config.Metrics{Pushgateway: server.URL}), "failed to push metrics: pushgateway answered 400 Bad Request")
+}
+
+func TestWriteSample_EscapesLabels(t *testing.T) {
+	r := New("shop", "")
+	r.Observe(deployment.Event{Type: deployment.EventFailed, Step: "upload/\"a\\b\"\n"})
+
+	assert.Contains(t, string(r.Format()), `ftl_deploy_step_duration_seconds{project="shop",step="upload/\"a\\b\"\n"} 0`)
+}
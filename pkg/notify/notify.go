@@ -0,0 +1,259 @@
+// Package notify sends summaries of deployments to the webhooks of the notifications section
+// of ftl.yaml.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/deployment"
+)
+
+const (
+	// attemptTimeout stops a request to a webhook that hasn't answered.
+	attemptTimeout = 5 * time.Second
+	// maxAttempts is the number of requests made to a webhook before giving up.
+	maxAttempts = 3
+	// retryBackoff is the wait before the second request, doubled before each further one.
+	retryBackoff = time.Second
+)
+
+// Summary is the JSON body sent to webhooks.
+type Summary struct {
+	// Event is started, succeeded or failed.
+	Event       string    `json:"event"`
+	Project     string    `json:"project"`
+	Environment string    `json:"environment,omitempty"`
+	Host        string    `json:"host"`
+	GitSHA      string    `json:"git_sha,omitempty"`
+	Started     time.Time `json:"started"`
+	// Duration is the time the deployment took in seconds, set once it finished.
+	Duration float64 `json:"duration,omitempty"`
+	// Services lists the services and dependencies whose containers were replaced, and those
+	// that failed.
+	Services []Service `json:"services,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Service is a service or dependency changed by a deployment.
+type Service struct {
+	Name string `json:"name"`
+	// Duration is the time deploying the service took in seconds.
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Notifier follows the events of a deployment and sends its summary to webhooks. Requests are
+// retried and time out, and their failures are only reported by Finish, so a webhook that is
+// down neither holds up nor fails the deployment.
+type Notifier struct {
+	webhooks []config.Notification
+	client   *http.Client
+	summary  Summary
+
+	mu       sync.Mutex
+	services []Service
+	pending  sync.WaitGroup
+	errs     []error
+
+	timeout  time.Duration
+	attempts int
+	backoff  time.Duration
+	clock    func() time.Time
+}
+
+// New returns a notifier sending to webhooks. The project, environment, host and git SHA of
+// the summaries are taken from deploy.
+func New(webhooks []config.Notification, deploy Summary) *Notifier {
+	return &Notifier{
+		webhooks: webhooks,
+		client:   &http.Client{},
+		summary:  Summary{Project: deploy.Project, Environment: deploy.Environment, Host: deploy.Host, GitSHA: deploy.GitSHA},
+		timeout:  attemptTimeout,
+		attempts: maxAttempts,
+		backoff:  retryBackoff,
+		clock:    time.Now,
+	}
+}
+
+// Start records the start of the deployment and sends the started notification in the
+// background.
+func (n *Notifier) Start() {
+	n.summary.Started = n.clock()
+
+	summary := n.summary
+	summary.Event = config.NotifyStarted
+	n.send(summary)
+}
+
+// Observe records the services and dependencies a deployment event reports as replaced or
+// failed.
+func (n *Notifier) Observe(event deployment.Event) {
+	if event.Service == "" || !(event.Type == deployment.EventFailed || event.Type == deployment.EventCompleted && event.Changed) {
+		return
+	}
+
+	service := Service{Name: event.Service, Duration: event.Time.Sub(event.Started).Seconds()}
+	if event.Err != nil {
+		service.Error = event.Err.Error()
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.services = append(n.services, service)
+}
+
+// Finish sends the succeeded or failed notification, depending on err, and waits for all
+// notifications to be delivered or given up on. It returns the errors of the webhooks that
+// couldn't be notified.
+func (n *Notifier) Finish(err error) error {
+	summary := n.summary
+	summary.Event = config.NotifySucceeded
+	if err != nil {
+		summary.Event = config.NotifyFailed
+		summary.Error = err.Error()
+	}
+	summary.Duration = n.clock().Sub(summary.Started).Round(time.Millisecond).Seconds()
+
+	n.mu.Lock()
+	summary.Services = append([]Service(nil), n.services...)
+	n.mu.Unlock()
+
+	// The started notification goes out first, so webhooks receive the events in order.
+	n.pending.Wait()
+	n.send(summary)
+	n.pending.Wait()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return errors.Join(n.errs...)
+}
+
+// send delivers summary to the webhooks subscribed to its event in the background.
+func (n *Notifier) send(summary Summary) {
+	for _, webhook := range n.webhooks {
+		if !webhook.Sends(summary.Event) {
+			continue
+		}
+
+		n.pending.Add(1)
+		go func(webhook config.Notification) {
+			defer n.pending.Done()
+			if err := n.deliver(webhook, summary); err != nil {
+				n.mu.Lock()
+				n.errs = append(n.errs, fmt.Errorf("failed to notify %s of deployment %s: %w", host(webhook.URL), summary.Event, err))
+				n.mu.Unlock()
+			}
+		}(webhook)
+	}
+}
+
+// deliver posts summary to webhook, retrying failed requests and server errors.
+func (n *Notifier) deliver(webhook config.Notification, summary Summary) error {
+	var body any = summary
+	if webhook.Format == config.NotificationSlack {
+		body = map[string]string{"text": summary.Text()}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	backoff := n.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := n.post(webhook.URL, data)
+		if err == nil || !retry || attempt == n.attempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post makes one request to the webhook and reports whether a failed one is worth retrying. It
+// doesn't use the context of the deployment, so the outcome of a cancelled deployment is
+// still sent.
+func (n *Notifier) post(webhookURL string, data []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ftl")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The error of the client repeats the URL, token included.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return false, nil
+}
+
+// Text formats the summary as a chat message.
+func (s Summary) Text() string {
+	target := s.Project
+	if s.Environment != "" {
+		target += " (" + s.Environment + ")"
+	}
+
+	var b strings.Builder
+	switch s.Event {
+	case config.NotifyStarted:
+		fmt.Fprintf(&b, "Deploying %s to %s", target, s.Host)
+	case config.NotifySucceeded:
+		fmt.Fprintf(&b, "Deployed %s to %s in %s", target, s.Host, seconds(s.Duration))
+	default:
+		fmt.Fprintf(&b, "Deployment of %s to %s failed after %s", target, s.Host, seconds(s.Duration))
+	}
+	if s.GitSHA != "" {
+		fmt.Fprintf(&b, " at %.7s", s.GitSHA)
+	}
+	if s.Error != "" {
+		fmt.Fprintf(&b, ": %s", s.Error)
+	}
+
+	for _, service := range s.Services {
+		fmt.Fprintf(&b, "\n• %s (%s)", service.Name, seconds(service.Duration))
+		if service.Error != "" {
+			fmt.Fprintf(&b, ": %s", service.Error)
+		}
+	}
+	return b.String()
+}
+
+// seconds formats a duration in seconds.
+func seconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Second).String()
+}
+
+// host returns the host of a webhook URL, which identifies the webhook in errors without
+// revealing the token its path usually holds.
+func host(webhookURL string) string {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return "webhook"
+	}
+	return u.Host
+}
@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/deployment"
+)
+
+// webhook records the bodies posted to it and answers with the statuses of answer, the last
+// one repeated.
+type webhook struct {
+	mu     sync.Mutex
+	bodies []string
+	answer []int
+	server *httptest.Server
+}
+
+func newWebhook(t *testing.T, answer ...int) *webhook {
+	w := &webhook{answer: answer}
+	w.server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		w.mu.Lock()
+		w.bodies = append(w.bodies, string(body))
+		status := http.StatusOK
+		if len(w.answer) > 0 {
+			status = w.answer[0]
+			if len(w.answer) > 1 {
+				w.answer = w.answer[1:]
+			}
+		}
+		w.mu.Unlock()
+
+		rw.WriteHeader(status)
+	}))
+	t.Cleanup(w.server.Close)
+	return w
+}
+
+func (w *webhook) received() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.bodies...)
+}
+
+func newTestNotifier(webhooks ...config.Notification) *Notifier {
+	n := New(webhooks, Summary{Project: "shop", Host: "example.com", GitSHA: "0123456789abcdef0123456789abcdef01234567"})
+	n.backoff = time.Millisecond
+	n.timeout = 200 * time.Millisecond
+
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	n.clock = func() time.Time {
+		now = now.Add(30 * time.Second)
+		return now
+	}
+	return n
+}
+
+func TestNotifier(t *testing.T) {
+	hook := newWebhook(t)
+	n := newTestNotifier(config.Notification{URL: hook.server.URL})
+
+	started := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	n.Start()
+	n.Observe(deployment.Event{Type: deployment.EventCompleted, Step: "service/web", Service: "web", Changed: true, Started: started, Time: started.Add(12 * time.Second)})
+	n.Observe(deployment.Event{Type: deployment.EventCompleted, Step: "service/worker", Service: "worker", Started: started, Time: started.Add(time.Second)})
+	n.Observe(deployment.Event{Type: deployment.EventCompleted, Step: "proxy", Changed: true, Started: started, Time: started})
+	require.NoError(t, n.Finish(nil))
+
+	bodies := hook.received()
+	require.Len(t, bodies, 2)
+	assert.JSONEq(t, `{"event":"started","project":"shop","host":"example.com","git_sha":"0123456789abcdef0123456789abcdef01234567","started":"2026-10-15T12:00:30Z"}`, bodies[0])
+	assert.JSONEq(t, `{"event":"succeeded","project":"shop","host":"example.com","git_sha":"0123456789abcdef0123456789abcdef01234567","started":"2026-10-15T12:00:30Z","duration":30,"services":[{"name":"web","duration":12}]}`, bodies[1])
+}
+
+func TestNotifier_Failed(t *testing.T) {
+	hook := newWebhook(t)
+	n := newTestNotifier(config.Notification{URL: hook.server.URL, Events: []string{config.NotifyFailed}})
+
+	started := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	n.Start()
+	n.Observe(deployment.Event{Type: deployment.EventFailed, Step: "service/web", Service: "web", Err: errors.New("unhealthy"), Started: started, Time: started.Add(2 * time.Second)})
+	require.NoError(t, n.Finish(errors.New("service web failed")))
+
+	bodies := hook.received()
+	require.Len(t, bodies, 1, "only the subscribed events are sent")
+	assert.Contains(t, bodies[0], `"event":"failed"`)
+	assert.Contains(t, bodies[0], `"error":"service web failed"`)
+	assert.Contains(t, bodies[0], `"services":[{"name":"web","duration":2,"error":"unhealthy"}]`)
+}
+
+func TestNotifier_Slack(t *testing.T) {
+	hook := newWebhook(t)
+	n := newTestNotifier(config.Notification{URL: hook.server.URL, Format: config.NotificationSlack, Events: []string{config.NotifySucceeded}})
+
+	started := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	n.Start()
+	n.Observe(deployment.Event{Type: deployment.EventCompleted, Service: "web", Changed: true, Started: started, Time: started.Add(12 * time.Second)})
+	require.NoError(t, n.Finish(nil))
+
+	bodies := hook.received()
+	require.Len(t, bodies, 1)
+	assert.JSONEq(t, `{"text":"Deployed shop to example.com in 30s at 0123456\n• web (12s)"}`, bodies[0])
+}
+
+func TestNotifier_Retries(t *testing.T) {
+	hook := newWebhook(t, http.StatusBadGateway, http.StatusOK)
+	n := newTestNotifier(config.Notification{URL: hook.server.URL, Events: []string{config.NotifySucceeded}})
+
+	n.Start()
+	require.NoError(t, n.Finish(nil))
+	assert.Len(t, hook.received(), 2)
+}
+
+func TestNotifier_GivesUp(t *testing.T) {
+	hook := newWebhook(t, http.StatusServiceUnavailable)
+	rejecting := newWebhook(t, http.StatusNotFound)
+	n := newTestNotifier(
+		config.Notification{URL: hook.server.URL + "/T0/secret", Events: []string{config.NotifySucceeded}},
+		config.Notification{URL: rejecting.server.URL, Events: []string{config.NotifySucceeded}},
+	)
+
+	n.Start()
+	err := n.Finish(nil)
+	require.Error(t, err)
+	assert.Len(t, hook.received(), maxAttempts)
+	assert.Len(t, rejecting.received(), 1, "client errors aren't retried")
+	assert.Contains(t, err.Error(), "503 Service Unavailable")
+	assert.NotContains(t, err.Error(), "secret")
+}
+
+func TestNotifier_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	n := newTestNotifier(config.Notification{URL: server.URL + "/T0/secret"})
+	n.timeout = 10 * time.Millisecond
+
+	n.Start()
+	err := n.Finish(nil)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotContains(t, err.Error(), "secret")
+}
+
+func TestNotifier_NoWebhooks(t *testing.T) {
+	n := New(nil, Summary{Project: "shop"})
+	n.Start()
+	assert.NoError(t, n.Finish(errors.New("failed")))
+}
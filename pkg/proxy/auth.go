@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ConfigPath is where the proxy container mounts its configuration directory.
+const ConfigPath = "/etc/nginx/conf.d"
+
+// HtpasswdFile returns the name of the password file of a service route in the proxy
+// configuration directory.
+func HtpasswdFile(service string, route int) string {
+	return fmt.Sprintf("%s-%d.htpasswd", service, route)
+}
+
+// Htpasswd returns an htpasswd file with a bcrypt hash of the user's password. The hash found
+// in existing is kept while it still matches, so the file only changes with the credentials.
+func Htpasswd(username, password string, existing []byte) ([]byte, error) {
+	for _, line := range bytes.Split(existing, []byte("\n")) {
+		user, hash, ok := bytes.Cut(bytes.TrimSpace(line), []byte(":"))
+		if ok && string(user) == username && bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil {
+			return []byte(fmt.Sprintf("%s:%s\n", user, hash)), nil
+		}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password of %s: %w", username, err)
+	}
+	return []byte(fmt.Sprintf("%s:%s\n", username, hash)), nil
+}
@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHtpasswd(t *testing.T) {
+	htpasswd, err := Htpasswd("admin", "s3cret", nil)
+	require.NoError(t, err)
+	assert.NotContains(t, string(htpasswd), "s3cret")
+
+	user, hash, ok := strings.Cut(strings.TrimSpace(string(htpasswd)), ":")
+	require.True(t, ok)
+	assert.Equal(t, "admin", user)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(hash), []byte("s3cret")))
+
+	unchanged, err := Htpasswd("admin", "s3cret", htpasswd)
+	require.NoError(t, err)
+	assert.Equal(t, htpasswd, unchanged)
+
+	changed, err := Htpasswd("admin", "new-secret", htpasswd)
+	require.NoError(t, err)
+	assert.NotEqual(t, htpasswd, changed)
+
+	renamed, err := Htpasswd("ops", "s3cret", htpasswd)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(renamed), "ops:"))
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfSignedCertificate(t *testing.T) {
+	certPEM, keyPEM, err := SelfSignedCertificate("app.example.com", time.Hour)
+	require.NoError(t, err)
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app.example.com"}, cert.DNSNames)
+	assert.NoError(t, cert.VerifyHostname("app.example.com"))
+	assert.WithinDuration(t, time.Now().Add(time.Hour), cert.NotAfter, time.Minute)
+}
+
+func TestLoadCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, keyPEM, err := SelfSignedCertificate("staging.example.com", time.Hour)
+	require.NoError(t, err)
+	_, otherKeyPEM, err := SelfSignedCertificate("other.example.com", time.Hour)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "staging.crt")
+	keyFile := filepath.Join(dir, "staging.key")
+	otherKeyFile := filepath.Join(dir, "other.key")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o644))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+	require.NoError(t, os.WriteFile(otherKeyFile, otherKeyPEM, 0o600))
+
+	loadedCert, loadedKey, err := LoadCertificate(certFile, keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, certPEM, loadedCert)
+	assert.Equal(t, keyPEM, loadedKey)
+
+	_, _, err = LoadCertificate(certFile, otherKeyFile)
+	assert.ErrorContains(t, err, "invalid certificate")
+
+	_, _, err = LoadCertificate(filepath.Join(dir, "missing.crt"), keyFile)
+	assert.ErrorContains(t, err, "failed to read certificate")
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MaintenancePageFile is the file name of the maintenance page in the proxy html directory.
+const MaintenancePageFile = "__ftl_maintenance.html"
+
+// HTMLPath is where the proxy container finds the maintenance page.
+const HTMLPath = "/usr/share/nginx/ftl"
+
+const defaultMaintenancePage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="10">
+<title>Back in a moment</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; color: #333; display: flex; align-items: center; justify-content: center; min-height: 100vh; margin: 0; }
+main { text-align: center; padding: 2rem; }
+</style>
+</head>
+<body>
+<main>
+<h1>Back in a moment</h1>
+<p>We're updating this site. This page will reload automatically.</p>
+</main>
+</body>
+</html>
+`
+
+// MaintenancePage returns the page served while a service is unavailable. page is either inline
+// HTML or the path of an HTML file; the built-in page is used when it is empty.
+func MaintenancePage(page string) ([]byte, error) {
+	switch {
+	case page == "":
+		return []byte(defaultMaintenancePage), nil
+	case strings.Contains(page, "<"):
+		return []byte(page), nil
+	}
+
+	data, err := os.ReadFile(page)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read maintenance page: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenancePage(t *testing.T) {
+	page, err := MaintenancePage("")
+	require.NoError(t, err)
+	assert.Contains(t, string(page), "Back in a moment")
+
+	page, err = MaintenancePage("<h1>Down for maintenance</h1>")
+	require.NoError(t, err)
+	assert.Equal(t, "<h1>Down for maintenance</h1>", string(page))
+
+	path := filepath.Join(t.TempDir(), "maintenance.html")
+	require.NoError(t, os.WriteFile(path, []byte("<p>custom</p>"), 0o644))
+	page, err = MaintenancePage(path)
+	require.NoError(t, err)
+	assert.Equal(t, "<p>custom</p>", string(page))
+
+	_, err = MaintenancePage(filepath.Join(t.TempDir(), "missing.html"))
+	assert.Error(t, err)
+}
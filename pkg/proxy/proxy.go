@@ -2,70 +2,549 @@ package proxy
 
 import (
 	"bytes"
-	"html/template"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/yarlson/ftl/pkg/config"
 )
 
-// GenerateNginxConfig generates an Nginx configuration based on the provided config.
-func GenerateNginxConfig(cfg *config.Config) (string, error) {
-	if cfg.Project.Domain == "" {
-		cfg.Project.Domain = "localhost"
-	}
+// serverBlock is an nginx server block serving one domain.
+type serverBlock struct {
+	Domain    string
+	Locations []location
+}
+
+// location routes a path prefix of a domain to a service.
+type location struct {
+	Service      string
+	PathPrefix   string
+	StripPrefix  bool
+	MaxBodySize  string
+	ReadTimeout  string
+	SendTimeout  string
+	Buffering    string
+	CacheControl string
+	Headers      []header
+	AllowIPs     []string
+	AuthFile     string
+	Extra        string
+}
+
+type header struct {
+	Name  string
+	Value string
+}
+
+// upstream is the server group of a service. Balance is the directive choosing the server of
+// a request, empty for round-robin.
+type upstream struct {
+	Name    string
+	Balance string
+	Servers []upstreamServer
+}
+
+// upstreamServer is a container in the server group of a service. Weight is 0 when the
+// group has a single server.
+type upstreamServer struct {
+	Address string
+	Weight  int
+}
+
+// Canary splits the requests of a service between its running container and a new one.
+type Canary struct {
+	// Alias is the network alias of the new container.
+	Alias string
+	// Percent is the share of requests sent to the new container. At 100, the running
+	// container gets none.
+	Percent int
+}
+
+// disabledTimeout is used for timeouts set to 0, since nginx has no way to turn them off.
+const disabledTimeout = 24 * time.Hour
+
+type templateData struct {
+	Upstreams       []upstream
+	Servers         []serverBlock
+	Redirects       []config.Redirect
+	Compression     bool
+	ACME            bool
+	PlainHTTP       bool
+	MaintenancePage string
+	HTMLPath        string
+	ExtraHTTP       string
+	ExtraServer     string
+	AccessLog       string
+	HSTS            string
+	SSLCiphers      string
+	Stapling        bool
+}
+
+// modernCiphers are the TLS 1.2 ciphers of the Mozilla intermediate configuration, all with
+// forward secrecy and authenticated encryption. TLS 1.3 ciphers aren't configurable this way.
+const modernCiphers = "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:" +
+	"ECDHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:" +
+	"DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:DHE-RSA-CHACHA20-POLY1305"
 
-	tmpl := template.Must(template.New("nginx").Parse(`
-{{- range .Services}}
+// accessLogJSON is the nginx log format of the JSON access log. Its fields are those of
+// AccessLogEntry.
+const accessLogJSON = "ftl_json"
+
+// AccessLogEntry is a line of the JSON access log of the proxy.
+type AccessLogEntry struct {
+	Time       string  `json:"time"`
+	RemoteAddr string  `json:"remote_addr"`
+	Host       string  `json:"host"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Protocol   string  `json:"protocol"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	Duration   float64 `json:"duration"`
+	Upstream   string  `json:"upstream"`
+	Referer    string  `json:"referer"`
+	UserAgent  string  `json:"user_agent"`
+}
+
+var nginxTemplate = template.Must(template.New("nginx").Funcs(template.FuncMap{"quote": nginxQuote, "regex": regexp.QuoteMeta, "indent": indent}).Parse(`
+{{- range .Upstreams}}
 	upstream {{.Name}} {
-		server {{.Name}}:{{.Port}};
+	{{- if .Balance}}
+		{{.Balance}};
+	{{- end}}
+	{{- range .Servers}}
+		server {{.Address}}{{if .Weight}} weight={{.Weight}}{{end}};
+	{{- end}}
+	}
+{{- end}}
+
+	map $http_upgrade $connection_upgrade {
+		default upgrade;
+		'' '';
+	}
+
+	geo $ftl_dollar {
+		default "$";
+	}
+{{- if eq .AccessLog "ftl_json"}}
+
+	log_format ftl_json escape=json '{"time":"$time_iso8601","remote_addr":"$remote_addr","host":"$host",'
+		'"method":"$request_method","path":"$request_uri","protocol":"$server_protocol","status":$status,'
+		'"bytes":$body_bytes_sent,"duration":$request_time,"upstream":"$upstream_addr",'
+		'"referer":"$http_referer","user_agent":"$http_user_agent"}';
+{{- end}}
+{{- if not .PlainHTTP}}
+
+	ssl_ciphers {{.SSLCiphers}};
+	ssl_session_cache shared:SSL:10m;
+	ssl_session_timeout 1d;
+	ssl_session_tickets off;
+{{- if .Stapling}}
+
+	ssl_stapling on;
+	ssl_stapling_verify on;
+	ssl_trusted_certificate /etc/ssl/certs/ca-certificates.crt;
+	resolver 127.0.0.11 valid=300s;
+{{- end}}
+{{- end}}
+{{- if .Compression}}
+
+	gzip on;
+	gzip_vary on;
+	gzip_proxied any;
+	gzip_comp_level 5;
+	gzip_min_length 1024;
+	gzip_types application/javascript application/json application/manifest+json application/rss+xml
+		application/wasm application/xml font/otf font/ttf image/svg+xml text/css text/javascript text/plain text/xml;
+{{- end}}
+{{- if .ExtraHTTP}}
+
+{{indent 1 .ExtraHTTP}}
+{{- end}}
+{{- if not .PlainHTTP}}
+
+	server {
+		listen 80 default_server;
+		server_name _;
+
+		access_log /var/log/nginx/access.log {{.AccessLog}};
+	{{- if .ACME}}
+
+		location /.well-known/acme-challenge/ {
+			resolver 127.0.0.11 valid=1s;
+			set $zero zero;
+			proxy_pass http://$zero;
+			proxy_set_header Host $host;
+		}
+	{{- end}}
+
+		location / {
+			return 301 https://$host$request_uri;
+		}
+	}
+{{- end}}
+{{- range .Redirects}}
+
+	server {
+	{{- if $.PlainHTTP}}
+		listen 80;
+		server_name {{.From}};
+	{{- else}}
+		listen 443 ssl;
+		http2 on;
+		server_name {{.From}};
+
+		ssl_certificate /etc/nginx/certs/{{.From}}.crt;
+		ssl_certificate_key /etc/nginx/certs/{{.From}}.key;
+		ssl_protocols TLSv1.2 TLSv1.3;
+		ssl_prefer_server_ciphers on;
+		{{- if $.HSTS}}
+
+		add_header Strict-Transport-Security {{quote $.HSTS}} always;
+		{{- end}}
+	{{- end}}
+
+		access_log /var/log/nginx/access.log {{$.AccessLog}};
+
+		return 301 {{if $.PlainHTTP}}http{{else}}https{{end}}://{{.To}}$request_uri;
 	}
 {{- end}}
+{{- range $i, $server := .Servers}}
 
 	server {
+	{{- if $.PlainHTTP}}
+		listen 80{{if eq $i 0}} default_server{{end}};
+		server_name {{.Domain}};
+	{{- else}}
 		listen 443 ssl;
 		http2 on;
-		server_name {{.Project.Domain}};
+		server_name {{.Domain}};
 
-		ssl_certificate /etc/nginx/certs/{{.Project.Domain}}.crt;
-		ssl_certificate_key /etc/nginx/certs/{{.Project.Domain}}.key;
+		ssl_certificate /etc/nginx/certs/{{.Domain}}.crt;
+		ssl_certificate_key /etc/nginx/certs/{{.Domain}}.key;
 		ssl_protocols TLSv1.2 TLSv1.3;
 		ssl_prefer_server_ciphers on;
+		{{- if $.HSTS}}
+
+		add_header Strict-Transport-Security {{quote $.HSTS}} always;
+		{{- end}}
+	{{- end}}
+
+		access_log /var/log/nginx/access.log {{$.AccessLog}};
 
-        client_body_buffer_size 10M;
-        client_max_body_size 10M;
+		client_body_buffer_size 10M;
+		client_max_body_size 10M;
 
-        proxy_request_buffering off;
+		proxy_request_buffering off;
+
+		proxy_connect_timeout 300s;
+		proxy_send_timeout 300s;
+		proxy_read_timeout 300s;
+
+		proxy_next_upstream error timeout http_502 http_503 http_504;
+		proxy_next_upstream_tries 3;
+		proxy_next_upstream_timeout 10s;
+
+		error_page 502 503 504 /{{$.MaintenancePage}};
+
+		location = /{{$.MaintenancePage}} {
+			root {{$.HTMLPath}};
+			internal;
+		}
+	{{- if $.ExtraServer}}
+
+{{indent 2 $.ExtraServer}}
+	{{- end}}
+	{{- range .Locations}}
 
-        proxy_connect_timeout 300s;
-        proxy_send_timeout 300s;
-        proxy_read_timeout 300s;
-{{- range .Services}}
-	{{- $serviceName := .Name }}
-	{{- range .Routes}}
 		location {{.PathPrefix}} {
+		{{- range .AllowIPs}}
+			allow {{.}};
+		{{- end}}
+		{{- if .AllowIPs}}
+			deny all;
+		{{- end}}
+		{{- if .AuthFile}}
+			auth_basic "Restricted";
+			auth_basic_user_file {{.AuthFile}};
+		{{- end}}
 		{{- if .StripPrefix}}
-			rewrite ^{{.PathPrefix}}(.*)$ /$1 break;
+			rewrite ^{{regex .PathPrefix}}(.*)$ /$1 break;
+		{{- end}}
+		{{- if .MaxBodySize}}
+			client_max_body_size {{.MaxBodySize}};
+		{{- end}}
+		{{- if .ReadTimeout}}
+			proxy_read_timeout {{.ReadTimeout}};
+		{{- end}}
+		{{- if .SendTimeout}}
+			proxy_send_timeout {{.SendTimeout}};
+		{{- end}}
+		{{- if .Buffering}}
+			proxy_buffering {{.Buffering}};
+		{{- end}}
+		{{- if .CacheControl}}
+			proxy_hide_header Cache-Control;
+			add_header Cache-Control {{quote .CacheControl}} always;
+		{{- end}}
+		{{- range .Headers}}
+			add_header {{.Name}} {{quote .Value}} always;
 		{{- end}}
 			resolver 127.0.0.11 valid=1s;
-			set $service {{$serviceName}};
-			proxy_pass http://$service;            proxy_http_version 1.1;
-            proxy_set_header Upgrade $http_upgrade;
-            proxy_set_header Connection "upgrade";
-            proxy_set_header Host $host;
-            proxy_set_header X-Real-IP $remote_addr;
-            proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
-            proxy_set_header X-Forwarded-Proto $scheme;
+			set $service {{.Service}};
+			proxy_pass http://$service;
+			proxy_http_version 1.1;
+			proxy_set_header Upgrade $http_upgrade;
+			proxy_set_header Connection $connection_upgrade;
+			proxy_set_header Host $host;
+			proxy_set_header X-Real-IP $remote_addr;
+			proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+			proxy_set_header X-Forwarded-Proto $scheme;
+		{{- if .Extra}}
+{{indent 3 .Extra}}
+		{{- end}}
 		}
 	{{- end}}
-{{- end}}
 	}
+{{- end}}
 `))
 
+// GenerateNginxConfig generates an Nginx configuration based on the provided config.
+// Plain HTTP is redirected to HTTPS except for ACME challenges, which are passed to the
+// certificate manager unless the project provides its own certificates. Each domain gets its own server block with the routes served on it.
+// With `tls: disabled`, the server blocks serve plain HTTP on port 80 and the first one is the
+// default for requests to other hosts.
+func GenerateNginxConfig(cfg *config.Config) (string, error) {
+	return GenerateNginxConfigWithCanaries(cfg, nil)
+}
+
+// GenerateNginxConfigWithCanaries generates the Nginx configuration like GenerateNginxConfig,
+// splitting the requests of the services in canaries between their running and new container
+// with weighted upstream servers.
+func GenerateNginxConfigWithCanaries(cfg *config.Config, canaries map[string]Canary) (string, error) {
+	if len(cfg.Project.AllDomains()) == 0 {
+		cfg.Project.Domain = "localhost"
+	}
+
 	var buffer bytes.Buffer
-	err := tmpl.Execute(&buffer, cfg)
-	if err != nil {
+	if err := nginxTemplate.Execute(&buffer, templateData{
+		Upstreams:       upstreams(cfg.Services, canaries),
+		Servers:         serverBlocks(cfg),
+		Redirects:       cfg.WWWRedirects(),
+		Compression:     cfg.Project.Compression,
+		ACME:            cfg.Project.TLS == nil,
+		PlainHTTP:       cfg.Project.PlainHTTP(),
+		MaintenancePage: MaintenancePageFile,
+		HTMLPath:        HTMLPath,
+		ExtraHTTP:       cfg.Proxy.ExtraHTTP,
+		ExtraServer:     cfg.Proxy.ExtraServer,
+		AccessLog:       accessLogFormat(cfg.Proxy.AccessLogFormat()),
+		HSTS:            hstsHeader(cfg),
+		SSLCiphers:      sslCiphers(cfg.Proxy.SSLCiphers),
+		Stapling:        cfg.Proxy.Stapling(),
+	}); err != nil {
 		return "", err
 	}
 
 	return strings.ReplaceAll(buffer.String(), "\t", "    "), nil
 }
+
+// hstsHeader returns the value of the Strict-Transport-Security header, which is only sent
+// over HTTPS.
+func hstsHeader(cfg *config.Config) string {
+	if cfg.Project.PlainHTTP() {
+		return ""
+	}
+	return cfg.Proxy.HSTSHeader()
+}
+
+// sslCiphers returns the TLS 1.2 ciphers of the proxy, the modern ones unless the configuration
+// lists its own.
+func sslCiphers(ciphers string) string {
+	if ciphers == "" {
+		return modernCiphers
+	}
+	return ciphers
+}
+
+// accessLogFormat returns the nginx log format of an access log format of the configuration.
+func accessLogFormat(format string) string {
+	if format == config.LogFormatCombined {
+		return "combined"
+	}
+	return accessLogJSON
+}
+
+// upstreams returns the server group of every service.
+func upstreams(services []config.Service, canaries map[string]Canary) []upstream {
+	groups := make([]upstream, 0, len(services))
+	for _, service := range services {
+		current := fmt.Sprintf("%s:%d", service.Name, service.Port)
+		canary, ok := canaries[service.Name]
+		switch {
+		case !ok:
+			groups = append(groups, upstream{Name: service.Name, Servers: []upstreamServer{{Address: current}}})
+		case canary.Percent >= 100:
+			groups = append(groups, upstream{Name: service.Name, Servers: []upstreamServer{
+				{Address: fmt.Sprintf("%s:%d", canary.Alias, service.Port)},
+			}})
+		default:
+			groups = append(groups, upstream{Name: service.Name, Servers: []upstreamServer{
+				{Address: current, Weight: 100 - canary.Percent},
+				{Address: fmt.Sprintf("%s:%d", canary.Alias, service.Port), Weight: canary.Percent},
+			}})
+		}
+		groups[len(groups)-1].Balance = balance(&service)
+	}
+	return groups
+}
+
+// balance returns the upstream directive keeping the requests of a client on the same server
+// with session affinity, hashing the session cookie or the client address.
+func balance(service *config.Service) string {
+	switch service.Sticky {
+	case config.StickyByCookie:
+		return fmt.Sprintf("hash $cookie_%s consistent", service.SessionCookie())
+	case config.StickyByIP:
+		return "ip_hash"
+	}
+	return ""
+}
+
+// serverBlocks groups the service routes by the domains they are served on.
+func serverBlocks(cfg *config.Config) []serverBlock {
+	domains := cfg.Domains()
+	blocks := make([]serverBlock, len(domains))
+	index := make(map[string]int, len(domains))
+	for i, domain := range domains {
+		blocks[i].Domain = domain
+		index[domain] = i
+	}
+
+	for i := range cfg.Services {
+		service := &cfg.Services[i]
+		for j := range service.Routes {
+			route := &service.Routes[j]
+			for _, domain := range cfg.RouteDomains(service, route) {
+				block := &blocks[index[domain]]
+				block.Locations = append(block.Locations, newLocation(service, j))
+			}
+		}
+	}
+
+	// A location with headers of its own doesn't inherit those of the server block, so they
+	// repeat the Strict-Transport-Security header unless they set it themselves.
+	if hsts := hstsHeader(cfg); hsts != "" {
+		for i := range blocks {
+			for j := range blocks[i].Locations {
+				blocks[i].Locations[j].addHeader("Strict-Transport-Security", hsts)
+			}
+		}
+	}
+
+	return blocks
+}
+
+// addHeader adds a header to a location that sends headers of its own, unless it sends the
+// header already.
+func (l *location) addHeader(name, value string) {
+	if len(l.Headers) == 0 && l.CacheControl == "" {
+		return
+	}
+	for _, h := range l.Headers {
+		if strings.EqualFold(h.Name, name) {
+			return
+		}
+	}
+	l.Headers = append(l.Headers, header{Name: name, Value: value})
+	sort.Slice(l.Headers, func(i, j int) bool { return l.Headers[i].Name < l.Headers[j].Name })
+}
+
+func newLocation(service *config.Service, routeIndex int) location {
+	route := &service.Routes[routeIndex]
+	loc := location{
+		Service:      service.Name,
+		PathPrefix:   route.PathPrefix,
+		StripPrefix:  route.StripPrefix,
+		CacheControl: route.CacheControl,
+		AllowIPs:     route.AllowIPs,
+		Extra:        route.ExtraLocation,
+	}
+	if route.Auth != nil {
+		loc.AuthFile = path.Join(ConfigPath, HtpasswdFile(service.Name, routeIndex))
+	}
+
+	opts := service.ProxyOptions.Merge(route.ProxyOptions)
+	if opts.MaxBodySize != nil {
+		loc.MaxBodySize = nginxSize(*opts.MaxBodySize)
+	}
+	if opts.ProxyReadTimeout != nil {
+		loc.ReadTimeout = nginxTimeout(*opts.ProxyReadTimeout)
+	}
+	if opts.ProxySendTimeout != nil {
+		loc.SendTimeout = nginxTimeout(*opts.ProxySendTimeout)
+	}
+	if opts.Buffering != nil {
+		loc.Buffering = "off"
+		if *opts.Buffering {
+			loc.Buffering = "on"
+		}
+	}
+	for name, value := range opts.ExtraHeaders {
+		if loc.CacheControl != "" && strings.EqualFold(name, "Cache-Control") {
+			continue
+		}
+		loc.Headers = append(loc.Headers, header{Name: name, Value: value})
+	}
+	sort.Slice(loc.Headers, func(i, j int) bool { return loc.Headers[i].Name < loc.Headers[j].Name })
+
+	return loc
+}
+
+// indent indents every non-empty line of a snippet by the given number of tabs, so it lines
+// up with the block it is inserted into.
+func indent(tabs int, snippet string) string {
+	lines := strings.Split(strings.TrimRight(snippet, "\n"), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[i] = strings.Repeat("\t", tabs) + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// nginxQuote returns s as a double-quoted nginx string. nginx has no escape for the $ of
+// variables, so a literal $ is written as the $ftl_dollar variable, which holds one.
+func nginxQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `${ftl_dollar}`).Replace(s) + `"`
+}
+
+// nginxSize formats a size with the units nginx understands (k, m and g).
+func nginxSize(size config.Size) string {
+	bytes := size.Bytes()
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{{"g", 1 << 30}, {"m", 1 << 20}, {"k", 1 << 10}} {
+		if bytes != 0 && bytes%unit.multiplier == 0 {
+			return fmt.Sprintf("%d%s", bytes/unit.multiplier, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%d", bytes)
+}
+
+// nginxTimeout formats a duration as nginx seconds or milliseconds. 0 is replaced by disabledTimeout.
+func nginxTimeout(d config.Duration) string {
+	duration := d.Duration()
+	if duration == 0 {
+		duration = disabledTimeout
+	}
+	if duration%time.Second == 0 {
+		return fmt.Sprintf("%ds", duration/time.Second)
+	}
+	return fmt.Sprintf("%dms", duration.Milliseconds())
+}
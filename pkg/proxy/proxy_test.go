@@ -1,7 +1,9 @@
 package proxy
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -88,3 +90,539 @@ func (suite *ProxyTestSuite) TestGenerateNginxConfig_EmptyConfig() {
 
 	assert.NoError(suite.T(), err)
 }
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_MultipleDomains() {
+	cfg := &config.Config{
+		Project: config.Project{
+			Name:    "test-project",
+			Domain:  "app.example.com",
+			Domains: []string{"app.example.com", "api.example.com"},
+			Email:   "test@example.com",
+		},
+		Services: []config.Service{
+			{
+				Name:   "web",
+				Port:   80,
+				Routes: []config.Route{{PathPrefix: "/", Host: "app.example.com"}},
+			},
+			{
+				Name:    "api",
+				Port:    8080,
+				Domains: []string{"api.example.com"},
+				Routes:  []config.Route{{PathPrefix: "/"}},
+			},
+			{
+				Name:   "status",
+				Port:   9000,
+				Routes: []config.Route{{PathPrefix: "/status"}},
+			},
+		},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+
+	blocks := strings.Split(nginxConfig, "server_name ")
+	assert.Len(suite.T(), blocks, 4)
+
+	app, api := blocks[2], blocks[3]
+	assert.True(suite.T(), strings.HasPrefix(app, "app.example.com;"))
+	assert.Contains(suite.T(), app, "ssl_certificate /etc/nginx/certs/app.example.com.crt;")
+	assert.Contains(suite.T(), app, "set $service web;")
+	assert.Contains(suite.T(), app, "set $service status;")
+	assert.NotContains(suite.T(), app, "set $service api;")
+
+	assert.True(suite.T(), strings.HasPrefix(api, "api.example.com;"))
+	assert.Contains(suite.T(), api, "ssl_certificate_key /etc/nginx/certs/api.example.com.key;")
+	assert.Contains(suite.T(), api, "set $service api;")
+	assert.Contains(suite.T(), api, "set $service status;")
+	assert.NotContains(suite.T(), api, "set $service web;")
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_Redirects() {
+	cfg := &config.Config{
+		Project: config.Project{
+			Name:        "test-project",
+			Domain:      "example.com",
+			Email:       "test@example.com",
+			RedirectWWW: true,
+		},
+		Services: []config.Service{
+			{
+				Name:   "web",
+				Port:   80,
+				Routes: []config.Route{{PathPrefix: "/"}},
+			},
+		},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+
+	httpBlock := strings.Index(nginxConfig, "listen 80 default_server;")
+	challenge := strings.Index(nginxConfig, "location /.well-known/acme-challenge/ {")
+	httpsRedirect := strings.Index(nginxConfig, "return 301 https://$host$request_uri;")
+	wwwBlock := strings.Index(nginxConfig, "server_name www.example.com;")
+	wwwRedirect := strings.Index(nginxConfig, "return 301 https://example.com$request_uri;")
+	apexBlock := strings.Index(nginxConfig, "server_name example.com;")
+
+	assert.NotEqual(suite.T(), -1, httpBlock)
+	assert.Less(suite.T(), httpBlock, challenge)
+	assert.Less(suite.T(), challenge, httpsRedirect)
+	assert.Less(suite.T(), httpsRedirect, wwwBlock)
+	assert.Less(suite.T(), wwwBlock, wwwRedirect)
+	assert.Less(suite.T(), wwwRedirect, apexBlock)
+	assert.Contains(suite.T(), nginxConfig, "ssl_certificate /etc/nginx/certs/www.example.com.crt;")
+	assert.Equal(suite.T(), 1, strings.Count(nginxConfig, "listen 80"))
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_NoWWWRedirectByDefault() {
+	cfg := &config.Config{
+		Project: config.Project{Name: "test-project", Domain: "example.com"},
+		Services: []config.Service{
+			{Name: "web", Port: 80, Routes: []config.Route{{PathPrefix: "/"}}},
+		},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	assert.Contains(suite.T(), nginxConfig, "listen 80 default_server;")
+	assert.NotContains(suite.T(), nginxConfig, "www.example.com")
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_ProxyOptions() {
+	size := config.Size(2 << 30)
+	sendTimeout := config.Duration(90 * time.Second)
+	readTimeout := config.Duration(0)
+	buffering := false
+
+	cfg := &config.Config{
+		Project: config.Project{Name: "test-project", Domain: "example.com"},
+		Services: []config.Service{
+			{
+				Name: "files",
+				Port: 8080,
+				ProxyOptions: config.ProxyOptions{
+					MaxBodySize:      &size,
+					ProxySendTimeout: &sendTimeout,
+					ExtraHeaders:     map[string]string{"X-Frame-Options": "DENY", "X-Service": "files"},
+				},
+				Routes: []config.Route{
+					{PathPrefix: "/upload"},
+					{
+						PathPrefix: "/events",
+						ProxyOptions: config.ProxyOptions{
+							ProxyReadTimeout: &readTimeout,
+							Buffering:        &buffering,
+							ExtraHeaders:     map[string]string{"X-Service": "events"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+
+	upload := nginxConfig[strings.Index(nginxConfig, "location /upload {"):strings.Index(nginxConfig, "location /events {")]
+	assert.Contains(suite.T(), upload, "client_max_body_size 2g;")
+	assert.Contains(suite.T(), upload, "proxy_send_timeout 90s;")
+	assert.Contains(suite.T(), upload, `add_header X-Frame-Options "DENY" always;`)
+	assert.Contains(suite.T(), upload, `add_header X-Service "files" always;`)
+	assert.NotContains(suite.T(), upload, "proxy_buffering")
+
+	events := nginxConfig[strings.Index(nginxConfig, "location /events {"):]
+	assert.Contains(suite.T(), events, "client_max_body_size 2g;")
+	assert.Contains(suite.T(), events, "proxy_read_timeout 86400s;")
+	assert.Contains(suite.T(), events, "proxy_buffering off;")
+	assert.Contains(suite.T(), events, `add_header X-Service "events" always;`)
+	assert.Less(suite.T(), strings.Index(events, "X-Frame-Options"), strings.Index(events, "X-Service"))
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_Compression() {
+	cfg := &config.Config{
+		Project:  config.Project{Name: "test-project", Domain: "example.com"},
+		Services: []config.Service{{Name: "api", Port: 8080, Routes: []config.Route{{PathPrefix: "/"}}}},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	assert.NotContains(suite.T(), nginxConfig, "gzip")
+
+	cfg.Project.Compression = true
+	nginxConfig, err = GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	assert.Contains(suite.T(), nginxConfig, "gzip on;")
+	assert.Contains(suite.T(), nginxConfig, "gzip_vary on;")
+	assert.Contains(suite.T(), nginxConfig, "gzip_min_length 1024;")
+	assert.Regexp(suite.T(), `gzip_types [a-z/+\s-]*application/json[a-z/+\s-]*image/svg\+xml[a-z/+\s-]*;`, nginxConfig)
+	assert.Less(suite.T(), strings.Index(nginxConfig, "gzip on;"), strings.Index(nginxConfig, "server {"))
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_CacheControl() {
+	cfg := &config.Config{
+		Project: config.Project{Name: "test-project", Domain: "example.com"},
+		Services: []config.Service{
+			{
+				Name: "web",
+				Port: 3000,
+				ProxyOptions: config.ProxyOptions{
+					ExtraHeaders: map[string]string{"cache-control": "no-store", "X-Service": "web"},
+				},
+				Routes: []config.Route{
+					{PathPrefix: "/assets", CacheControl: "public, max-age=31536000, immutable"},
+					{PathPrefix: "/"},
+				},
+			},
+		},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+
+	assets := nginxConfig[strings.Index(nginxConfig, "location /assets {"):strings.LastIndex(nginxConfig, "location / {")]
+	assert.Contains(suite.T(), assets, "proxy_hide_header Cache-Control;")
+	assert.Contains(suite.T(), assets, `add_header Cache-Control "public, max-age=31536000, immutable" always;`)
+	assert.NotContains(suite.T(), assets, "no-store")
+	assert.Contains(suite.T(), assets, `add_header X-Service "web" always;`)
+
+	root := nginxConfig[strings.LastIndex(nginxConfig, "location / {"):]
+	assert.NotContains(suite.T(), root, "proxy_hide_header")
+	assert.Contains(suite.T(), root, `add_header cache-control "no-store" always;`)
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_AuthAndAllowIPs() {
+	cfg := &config.Config{
+		Project: config.Project{Name: "test-project", Domain: "example.com"},
+		Services: []config.Service{
+			{
+				Name: "web",
+				Port: 3000,
+				Routes: []config.Route{
+					{PathPrefix: "/admin", Auth: &config.RouteAuth{Username: "admin", PasswordEnv: "ADMIN_PASSWORD"}},
+					{PathPrefix: "/metrics", AllowIPs: []string{"203.0.113.7", "2001:db8::/32"}},
+					{PathPrefix: "/"},
+				},
+			},
+		},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+
+	admin := nginxConfig[strings.Index(nginxConfig, "location /admin {"):strings.Index(nginxConfig, "location /metrics {")]
+	assert.Contains(suite.T(), admin, `auth_basic "Restricted";`)
+	assert.Contains(suite.T(), admin, "auth_basic_user_file /etc/nginx/conf.d/web-0.htpasswd;")
+	assert.NotContains(suite.T(), admin, "deny all;")
+
+	metrics := nginxConfig[strings.Index(nginxConfig, "location /metrics {"):strings.LastIndex(nginxConfig, "location / {")]
+	assert.Contains(suite.T(), metrics, "allow 203.0.113.7;\n            allow 2001:db8::/32;\n            deny all;")
+	assert.NotContains(suite.T(), metrics, "auth_basic")
+
+	root := nginxConfig[strings.LastIndex(nginxConfig, "location / {"):]
+	assert.NotContains(suite.T(), root, "auth_basic")
+	assert.NotContains(suite.T(), root, "deny all;")
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_SpecialCharacters() {
+	cfg := &config.Config{
+		Project: config.Project{Name: "test-project", Domain: "example.com"},
+		Services: []config.Service{
+			{
+				Name: "web",
+				Port: 3000,
+				ProxyOptions: config.ProxyOptions{
+					ExtraHeaders: map[string]string{
+						"Content-Security-Policy": `default-src 'self' "https://cdn.example.com" & more\`,
+						"X-Price":                 "$5",
+					},
+				},
+				Routes: []config.Route{{PathPrefix: "/a&b", StripPrefix: true}, {PathPrefix: "/v1.0+(beta)", StripPrefix: true}},
+			},
+		},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	assert.Contains(suite.T(), nginxConfig, "location /a&b {")
+	assert.Contains(suite.T(), nginxConfig, "rewrite ^/a&b(.*)$ /$1 break;")
+	// The path is matched literally, not as a regular expression.
+	assert.Contains(suite.T(), nginxConfig, "location /v1.0+(beta) {")
+	assert.Contains(suite.T(), nginxConfig, `rewrite ^/v1\.0\+\(beta\)(.*)$ /$1 break;`)
+	// A literal $ doesn't start a variable.
+	assert.Contains(suite.T(), nginxConfig, `add_header X-Price "${ftl_dollar}5" always;`)
+	assert.Contains(suite.T(), nginxConfig, "geo $ftl_dollar {\n        default \"$\";\n    }")
+	assert.Contains(suite.T(), nginxConfig, `add_header Content-Security-Policy "default-src 'self' \"https://cdn.example.com\" & more\\" always;`)
+	assert.NotContains(suite.T(), nginxConfig, "&amp;")
+	assert.NotContains(suite.T(), nginxConfig, "&#")
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_CustomTLS() {
+	cfg := &config.Config{
+		Project:  config.Project{Name: "test-project", Domain: "staging.example.com"},
+		Services: []config.Service{{Name: "web", Port: 3000, Routes: []config.Route{{PathPrefix: "/"}}}},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	assert.Contains(suite.T(), nginxConfig, "location /.well-known/acme-challenge/ {")
+
+	cfg.Project.TLS = &config.TLS{SelfSigned: true}
+	nginxConfig, err = GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	assert.NotContains(suite.T(), nginxConfig, "acme-challenge")
+	assert.NotContains(suite.T(), nginxConfig, "$zero")
+	assert.Contains(suite.T(), nginxConfig, "return 301 https://$host$request_uri;")
+	assert.Contains(suite.T(), nginxConfig, "ssl_certificate /etc/nginx/certs/staging.example.com.crt;")
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_PlainHTTP() {
+	cfg := &config.Config{
+		Project: config.Project{
+			Name:        "wiki",
+			Domains:     []string{"wiki.lan", "docs.lan"},
+			RedirectWWW: true,
+			TLS:         &config.TLS{Disabled: true},
+		},
+		Services: []config.Service{{Name: "web", Port: 3000, Routes: []config.Route{{PathPrefix: "/"}}}},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	assert.NotContains(suite.T(), nginxConfig, "443")
+	assert.NotContains(suite.T(), nginxConfig, "ssl")
+	assert.NotContains(suite.T(), nginxConfig, "https://")
+	assert.NotContains(suite.T(), nginxConfig, "acme-challenge")
+	assert.Contains(suite.T(), nginxConfig, `    server {
+        listen 80 default_server;
+        server_name wiki.lan;
+
+        access_log /var/log/nginx/access.log ftl_json;
+
+        client_body_buffer_size 10M;`)
+	assert.Contains(suite.T(), nginxConfig, `    server {
+        listen 80;
+        server_name docs.lan;
+`)
+	assert.Contains(suite.T(), nginxConfig, `    server {
+        listen 80;
+        server_name www.wiki.lan;
+
+        access_log /var/log/nginx/access.log ftl_json;
+
+        return 301 http://wiki.lan$request_uri;
+    }`)
+	assert.Equal(suite.T(), 1, strings.Count(nginxConfig, "default_server"))
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_ConnectionUpgrade() {
+	cfg := &config.Config{
+		Project:  config.Project{Name: "shop", Domain: "shop.example.com"},
+		Services: []config.Service{{Name: "web", Port: 3000, Routes: []config.Route{{PathPrefix: "/"}}}},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	// Only requests asking for an upgrade, like WebSocket handshakes, send Connection: upgrade;
+	// the others send no Connection header and keep the upstream connection alive.
+	assert.Contains(suite.T(), nginxConfig, `
+    map $http_upgrade $connection_upgrade {
+        default upgrade;
+        '' '';
+    }
+`)
+	assert.Contains(suite.T(), nginxConfig, `            proxy_set_header Upgrade $http_upgrade;
+            proxy_set_header Connection $connection_upgrade;
+`)
+	assert.NotContains(suite.T(), nginxConfig, `Connection "upgrade"`)
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_AccessLog() {
+	cfg := &config.Config{
+		Project:  config.Project{Name: "shop", Domain: "shop.example.com", RedirectWWW: true},
+		Services: []config.Service{{Name: "web", Port: 3000, Routes: []config.Route{{PathPrefix: "/"}}}},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	assert.Contains(suite.T(), nginxConfig, `    log_format ftl_json escape=json '{"time":"$time_iso8601",`)
+	// Every server block, including the redirects, logs in the JSON format.
+	assert.Equal(suite.T(), 3, strings.Count(nginxConfig, "access_log /var/log/nginx/access.log ftl_json;"))
+
+	cfg.Proxy.LogFormat = config.LogFormatCombined
+	nginxConfig, err = GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	assert.NotContains(suite.T(), nginxConfig, "log_format")
+	assert.Equal(suite.T(), 3, strings.Count(nginxConfig, "access_log /var/log/nginx/access.log combined;"))
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_TLSHardening() {
+	cfg := &config.Config{
+		Project: config.Project{Name: "shop", Domain: "shop.example.com", RedirectWWW: true},
+		Services: []config.Service{{Name: "web", Port: 3000, Routes: []config.Route{
+			{PathPrefix: "/"},
+			{PathPrefix: "/assets", CacheControl: "public, max-age=3600"},
+		}}},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	assert.Contains(suite.T(), nginxConfig, `
+    ssl_ciphers ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:`+
+		`ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:DHE-RSA-CHACHA20-POLY1305;
+    ssl_session_cache shared:SSL:10m;
+    ssl_session_timeout 1d;
+    ssl_session_tickets off;
+
+    ssl_stapling on;
+    ssl_stapling_verify on;
+    ssl_trusted_certificate /etc/ssl/certs/ca-certificates.crt;
+    resolver 127.0.0.11 valid=300s;
+`)
+	assert.Contains(suite.T(), nginxConfig, `        ssl_prefer_server_ciphers on;
+
+        add_header Strict-Transport-Security "max-age=31536000" always;
+`)
+	// The redirect and the domain send the header, and so does the location with headers of
+	// its own, which doesn't inherit it.
+	assert.Equal(suite.T(), 3, strings.Count(nginxConfig, `add_header Strict-Transport-Security "max-age=31536000" always;`))
+	assert.Contains(suite.T(), nginxConfig, `            add_header Cache-Control "public, max-age=3600" always;
+            add_header Strict-Transport-Security "max-age=31536000" always;
+`)
+
+	disabled := false
+	cfg.Proxy = config.Proxy{
+		HSTS:         &config.HSTS{MaxAge: 63072000, IncludeSubdomains: true, Preload: true},
+		SSLCiphers:   "HIGH:!aNULL:!MD5",
+		OCSPStapling: &disabled,
+	}
+	nginxConfig, err = GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	assert.Contains(suite.T(), nginxConfig, `add_header Strict-Transport-Security "max-age=63072000; includeSubDomains; preload" always;`)
+	assert.Contains(suite.T(), nginxConfig, "    ssl_ciphers HIGH:!aNULL:!MD5;\n")
+	assert.NotContains(suite.T(), nginxConfig, "ssl_stapling")
+
+	cfg.Proxy.HSTS.Enabled = &disabled
+	nginxConfig, err = GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	assert.NotContains(suite.T(), nginxConfig, "Strict-Transport-Security")
+
+	cfg.Proxy = config.Proxy{}
+	cfg.Project.TLS = &config.TLS{Disabled: true}
+	nginxConfig, err = GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	assert.NotContains(suite.T(), nginxConfig, "Strict-Transport-Security")
+	assert.NotContains(suite.T(), nginxConfig, "ssl_")
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_Snippets() {
+	cfg := &config.Config{
+		Project: config.Project{Name: "shop", Domains: []string{"shop.example.com", "api.example.com"}},
+		Proxy: config.Proxy{
+			ExtraHTTP:   "limit_req_zone $binary_remote_addr zone=api:10m rate=10r/s;\n",
+			ExtraServer: "location = /robots.txt {\n    return 200 \"User-agent: *\\nDisallow: /\\n\";\n}\n",
+		},
+		Services: []config.Service{{Name: "web", Port: 3000, Routes: []config.Route{
+			{PathPrefix: "/api", ExtraLocation: "limit_req zone=api burst=20;\nlimit_req_status 429;"},
+			{PathPrefix: "/"},
+		}}},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+
+	assert.Contains(suite.T(), nginxConfig, `
+    limit_req_zone $binary_remote_addr zone=api:10m rate=10r/s;
+
+    server {
+        listen 80 default_server;`)
+	assert.Equal(suite.T(), 2, strings.Count(nginxConfig, `
+            internal;
+        }
+
+        location = /robots.txt {
+            return 200 "User-agent: *\nDisallow: /\n";
+        }
+`))
+	assert.Equal(suite.T(), 2, strings.Count(nginxConfig, `
+            proxy_set_header X-Forwarded-Proto $scheme;
+            limit_req zone=api burst=20;
+            limit_req_status 429;
+        }`))
+	assert.Equal(suite.T(), 2, strings.Count(nginxConfig, "proxy_set_header X-Forwarded-Proto $scheme;\n        }"))
+}
+
+func (suite *ProxyTestSuite) TestNginxFormatting() {
+	assert.Equal(suite.T(), "512m", nginxSize(config.Size(512<<20)))
+	assert.Equal(suite.T(), "1024g", nginxSize(config.Size(1<<40)))
+	assert.Equal(suite.T(), "1000", nginxSize(config.Size(1000)))
+	assert.Equal(suite.T(), "0", nginxSize(config.Size(0)))
+	assert.Equal(suite.T(), "1500ms", nginxTimeout(config.Duration(1500*time.Millisecond)))
+	assert.Equal(suite.T(), "300s", nginxTimeout(config.Duration(5*time.Minute)))
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_MaintenancePage() {
+	cfg := &config.Config{
+		Project: config.Project{Name: "test-project", Domain: "example.com"},
+		Services: []config.Service{
+			{Name: "web", Port: 80, Routes: []config.Route{{PathPrefix: "/"}}},
+		},
+	}
+
+	nginxConfig, err := GenerateNginxConfig(cfg)
+	assert.NoError(suite.T(), err)
+	assert.Contains(suite.T(), nginxConfig, "proxy_next_upstream error timeout http_502 http_503 http_504;")
+	assert.Contains(suite.T(), nginxConfig, "error_page 502 503 504 /__ftl_maintenance.html;")
+	assert.Contains(suite.T(), nginxConfig, "location = /__ftl_maintenance.html {\n            root /usr/share/nginx/ftl;\n            internal;")
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_Canaries() {
+	cfg := &config.Config{
+		Project: config.Project{Name: "test-project", Domain: "example.com"},
+		Services: []config.Service{
+			{Name: "web", Port: 3000, Routes: []config.Route{{PathPrefix: "/"}}},
+			{Name: "api", Port: 8080, Routes: []config.Route{{PathPrefix: "/api"}}},
+		},
+	}
+
+	nginxConfig, err := GenerateNginxConfigWithCanaries(cfg, map[string]Canary{"web": {Alias: "web_new", Percent: 10}})
+	assert.NoError(suite.T(), err)
+	assert.Contains(suite.T(), nginxConfig, "upstream web {\n        server web:3000 weight=90;\n        server web_new:3000 weight=10;\n    }")
+	assert.Contains(suite.T(), nginxConfig, "upstream api {\n        server api:8080;\n    }")
+
+	nginxConfig, err = GenerateNginxConfigWithCanaries(cfg, map[string]Canary{"web": {Alias: "web_new", Percent: 100}})
+	assert.NoError(suite.T(), err)
+	assert.Contains(suite.T(), nginxConfig, "upstream web {\n        server web_new:3000;\n    }")
+}
+
+func (suite *ProxyTestSuite) TestGenerateNginxConfig_Sticky() {
+	cfg := &config.Config{
+		Project: config.Project{Name: "test-project", Domain: "example.com"},
+		Services: []config.Service{
+			{Name: "web", Port: 3000, Sticky: config.StickyByCookie, Routes: []config.Route{{PathPrefix: "/"}}},
+			{Name: "legacy", Port: 8080, Sticky: config.StickyByCookie, StickyCookie: "JSESSIONID", Routes: []config.Route{{PathPrefix: "/legacy"}}},
+			{Name: "api", Port: 8081, Sticky: config.StickyByIP, Routes: []config.Route{{PathPrefix: "/api"}}},
+			{Name: "static", Port: 8082, Routes: []config.Route{{PathPrefix: "/static"}}},
+		},
+	}
+
+	nginxConfig, err := GenerateNginxConfigWithCanaries(cfg, map[string]Canary{"web": {Alias: "web_new", Percent: 10}})
+	assert.NoError(suite.T(), err)
+	assert.Contains(suite.T(), nginxConfig, `    upstream web {
+        hash $cookie_session_id consistent;
+        server web:3000 weight=90;
+        server web_new:3000 weight=10;
+    }`)
+	assert.Contains(suite.T(), nginxConfig, `    upstream legacy {
+        hash $cookie_JSESSIONID consistent;
+        server legacy:8080;
+    }`)
+	assert.Contains(suite.T(), nginxConfig, `    upstream api {
+        ip_hash;
+        server api:8081;
+    }`)
+	assert.Contains(suite.T(), nginxConfig, `    upstream static {
+        server static:8082;
+    }`)
+}
@@ -0,0 +1,57 @@
+package cmdlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandLine(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+		want    string
+	}{
+		{"docker", []string{"ps", "-a"}, "docker ps -a"},
+		{"sh", []string{"-c", "echo $HOME", ""}, `sh -c 'echo $HOME' ''`},
+		{"sh", []string{"-c", "echo 'hi'"}, `sh -c 'echo '\''hi'\'''`},
+		{"docker", []string{"run", "-e", "DB_PASSWORD=hunter2", "-e", "PORT=80"}, "docker run -e 'DB_PASSWORD=***' -e PORT=80"},
+		{"docker", []string{"run", "-e", "GITHUB_TOKEN=abc", "-e", "api_key=abc"}, "docker run -e 'GITHUB_TOKEN=***' -e 'api_key=***'"},
+		{"docker", []string{"run", "-e", "DATABASE_URL=postgres://app:hunter2@db/app"}, "docker run -e 'DATABASE_URL=postgres://app:***@db/app'"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, commandLine(tt.command, tt.args))
+	}
+}
+
+func TestStatus(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	require.Error(t, exitErr)
+
+	assert.Equal(t, "exit 0", status(nil))
+	assert.Equal(t, "exit 3", status(fmt.Errorf("running: %w", exitErr)))
+	assert.Equal(t, "cancelled", status(context.Canceled))
+	assert.Equal(t, "error: connection lost", status(errors.New("connection lost")))
+}
+
+func TestStartFinish(t *testing.T) {
+	var lines []string
+	Enable(func(line string) { lines = append(lines, line) })
+	t.Cleanup(func() { Enable(nil) })
+
+	Start("example.com", "docker", []string{"ps"}).Finish(nil)
+	require.Len(t, lines, 1)
+	assert.Regexp(t, `^\d\d:\d\d:\d\d\.\d{3} \[example\.com\] docker ps \([\w.]+, exit 0\)$`, lines[0])
+
+	Enable(nil)
+	command := Start("local", "docker", []string{"ps"})
+	assert.Nil(t, command)
+	command.Finish(nil)
+	assert.Len(t, lines, 1)
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package local
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroup makes a cancelled command kill its child processes along with it.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
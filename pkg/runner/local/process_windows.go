@@ -0,0 +1,8 @@
+//go:build windows
+
+package local
+
+import "os/exec"
+
+// killProcessGroup is a no-op on Windows, where a cancelled command is killed on its own.
+func killProcessGroup(cmd *exec.Cmd) {}
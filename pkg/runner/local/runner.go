@@ -5,10 +5,17 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"strings"
+	"time"
+
+	"github.com/yarlson/ftl/pkg/runner/cmdlog"
 )
 
+// waitDelay is how long a cancelled command's output is still read after the command is killed.
+const waitDelay = 5 * time.Second
+
 type Runner struct{}
 
 func NewRunner() *Runner {
@@ -16,8 +23,21 @@ func NewRunner() *Runner {
 }
 
 func (e *Runner) RunCommand(ctx context.Context, command string, args ...string) (io.ReadCloser, error) {
+	return e.RunCommandWithEnv(ctx, nil, command, args...)
+}
+
+// RunCommandWithEnv runs a command with the given KEY=VALUE pairs added to the current environment.
+func (e *Runner) RunCommandWithEnv(ctx context.Context, env []string, command string, args ...string) (io.ReadCloser, error) {
 	cmd := exec.CommandContext(ctx, command, args...)
+	killProcessGroup(cmd)
+	// Don't wait for the output of child processes that outlive a cancelled command.
+	cmd.WaitDelay = waitDelay
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	logged := cmdlog.Start("local", command, args)
 	output, err := cmd.CombinedOutput()
+	logged.Finish(err)
 	if err != nil {
 		return nil, fmt.Errorf("command execution failed: %w", err)
 	}
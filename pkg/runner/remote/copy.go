@@ -0,0 +1,181 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/bramvdbogaerde/go-scp"
+	"github.com/pkg/sftp"
+)
+
+// defaultFileMode is the mode of copied files when CopyOptions.Mode is zero.
+const defaultFileMode os.FileMode = 0644
+
+// CopyOptions controls how a file is written on the remote host.
+type CopyOptions struct {
+	// Mode is the permission of the file. Defaults to 0644.
+	Mode os.FileMode
+	// Owner sets the numeric owner and group of the file when not nil.
+	Owner *Owner
+	// Size is the number of bytes to copy, reported to Progress. Zero if unknown.
+	Size int64
+	// Progress is called as the file is written with the number of bytes written so far.
+	Progress func(written, size int64)
+}
+
+// Owner is a numeric file owner and group.
+type Owner struct {
+	UID int
+	GID int
+}
+
+// CopyFile copies a file from src on the local machine to dst on the remote host, creating the
+// parent directories of dst. The file keeps the permissions of src.
+func (r *Runner) CopyFile(ctx context.Context, src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("opening source file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("reading source file: %w", err)
+	}
+
+	return r.CopyReader(ctx, f, dst, CopyOptions{Mode: info.Mode().Perm(), Size: info.Size()})
+}
+
+// CopyReader writes the content of src to dst on the remote host over SFTP, creating the parent
+// directories of dst. Servers without the SFTP subsystem get the file over SCP instead.
+func (r *Runner) CopyReader(ctx context.Context, src io.Reader, dst string, opts CopyOptions) error {
+	if r.currentClient() == nil {
+		return ErrNoClient
+	}
+	if opts.Mode == 0 {
+		opts.Mode = defaultFileMode
+	}
+	if opts.Progress != nil {
+		src = &progressReader{reader: src, size: opts.Size, progress: opts.Progress}
+	}
+
+	release, err := r.acquireSession(ctx)
+	if err != nil {
+		return err
+	}
+	client, err := sftp.NewClient(r.currentClient())
+	if err != nil {
+		release()
+		return r.copySCP(ctx, src, dst, opts)
+	}
+	defer release()
+	defer client.Close()
+
+	// Closing the client of a cancelled copy unblocks its pending writes.
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	if err := client.MkdirAll(path.Dir(dst)); err != nil {
+		return fmt.Errorf("creating directory of %s: %w", dst, err)
+	}
+
+	f, err := client.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", dst, err)
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		f.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("copying file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("copying file: %w", err)
+	}
+
+	if err := client.Chmod(dst, opts.Mode); err != nil {
+		return fmt.Errorf("setting mode of %s: %w", dst, err)
+	}
+	if opts.Owner != nil {
+		if err := client.Chown(dst, opts.Owner.UID, opts.Owner.GID); err != nil {
+			return fmt.Errorf("setting owner of %s: %w", dst, err)
+		}
+	}
+
+	return nil
+}
+
+// copySCP writes the content of src to dst over SCP, which needs the size of the content up
+// front and can't create directories or change owners by itself.
+func (r *Runner) copySCP(ctx context.Context, src io.Reader, dst string, opts CopyOptions) error {
+	size := opts.Size
+	if size <= 0 {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return fmt.Errorf("reading source: %w", err)
+		}
+		src, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	if err := r.runSilently(ctx, "mkdir", "-p", path.Dir(dst)); err != nil {
+		return fmt.Errorf("creating directory of %s: %w", dst, err)
+	}
+
+	release, err := r.acquireSession(ctx)
+	if err != nil {
+		return err
+	}
+	client, err := scp.NewClientBySSH(r.currentClient())
+	if err != nil {
+		release()
+		return fmt.Errorf("creating SCP client: %w", err)
+	}
+	err = client.Copy(ctx, src, dst, fmt.Sprintf("%04o", opts.Mode.Perm()), size)
+	release()
+	if err != nil {
+		return fmt.Errorf("copying file: %w", err)
+	}
+
+	if opts.Owner != nil {
+		owner := fmt.Sprintf("%d:%d", opts.Owner.UID, opts.Owner.GID)
+		if err := r.runSilently(ctx, "chown", owner, dst); err != nil {
+			return fmt.Errorf("setting owner of %s: %w", dst, err)
+		}
+	}
+
+	return nil
+}
+
+// runSilently runs a command and discards its output.
+func (r *Runner) runSilently(ctx context.Context, command string, args ...string) error {
+	output, err := r.RunCommand(ctx, command, args...)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	_, err = io.Copy(io.Discard, output)
+	return err
+}
+
+// progressReader reports the bytes read from reader.
+type progressReader struct {
+	reader   io.Reader
+	size     int64
+	read     int64
+	progress func(written, size int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	if n > 0 {
+		p.read += int64(n)
+		p.progress(p.read, p.size)
+	}
+	return n, err
+}
@@ -0,0 +1,305 @@
+package remote
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Compare selects how CopyDir detects files that are unchanged on the remote host.
+type Compare string
+
+const (
+	// CompareNone copies every file.
+	CompareNone Compare = ""
+	// CompareSizeTime skips files with the same size and modification time.
+	CompareSizeTime Compare = "size-time"
+	// CompareChecksum skips files with the same SHA-256 checksum.
+	CompareChecksum Compare = "checksum"
+)
+
+// CopyDirOptions controls which files CopyDir uploads.
+type CopyDirOptions struct {
+	// Include limits the upload to files matching one of these patterns. Empty includes all files.
+	Include []string
+	// Exclude leaves out files and directories matching one of these patterns.
+	Exclude []string
+	// Compare skips files that are unchanged on the remote host.
+	Compare Compare
+}
+
+// dirEntry is a file or directory of the tree CopyDir uploads.
+type dirEntry struct {
+	// name is the slash-separated path relative to the uploaded directory.
+	name string
+	path string
+	info fs.FileInfo
+}
+
+// CopyDir uploads the directory tree localDir to remoteDir, creating remoteDir if needed. The
+// files are streamed as a tar archive and extracted on the remote host, keeping their modes and
+// modification times. Patterns are matched with path.Match against the slash-separated path
+// relative to localDir and against the base name. Files removed from localDir are left in
+// remoteDir, and entries other than regular files and directories are skipped.
+func (r *Runner) CopyDir(ctx context.Context, localDir, remoteDir string, opts CopyDirOptions) error {
+	if r.currentClient() == nil {
+		return ErrNoClient
+	}
+
+	entries, err := walkDir(localDir, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.Compare != CompareNone {
+		remote, err := r.remoteFiles(ctx, remoteDir, opts.Compare)
+		if err != nil {
+			return err
+		}
+		entries, err = changedEntries(entries, remote, opts.Compare)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := r.runSilently(ctx, "mkdir", "-p", remoteDir); err != nil {
+		return fmt.Errorf("creating directory %s: %w", remoteDir, err)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	archive, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTar(writer, entries))
+	}()
+	defer archive.Close()
+
+	output, err := r.run(ctx, archive, "tar", "-x", "-f", "-", "-C", remoteDir, "--no-same-owner")
+	if err != nil {
+		return fmt.Errorf("extracting files into %s: %w: %s", remoteDir, err, output)
+	}
+
+	return nil
+}
+
+// walkDir returns the directories and regular files of localDir selected by opts.
+func walkDir(localDir string, opts CopyDirOptions) ([]dirEntry, error) {
+	var entries []dirEntry
+	err := filepath.WalkDir(localDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(localDir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		if !filepath.IsLocal(rel) {
+			return fmt.Errorf("invalid path %s in %s", name, localDir)
+		}
+
+		if matchAny(opts.Exclude, name) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		if !d.IsDir() && len(opts.Include) > 0 && !matchAny(opts.Include, name) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, dirEntry{name: name, path: p, info: info})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", localDir, err)
+	}
+
+	return entries, nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteFiles returns the size and modification time, or the checksum, of every file in
+// remoteDir by its slash-separated relative path, as fileKey formats them. A missing remoteDir
+// has no files.
+func (r *Runner) remoteFiles(ctx context.Context, remoteDir string, compare Compare) (map[string]string, error) {
+	list := `find . -type f -printf '%s %T@ %P\n'`
+	if compare == CompareChecksum {
+		list = `find . -type f -exec sha256sum {} +`
+	}
+
+	output, err := r.run(ctx, nil, "sh", "-c", `cd "$1" 2>/dev/null || exit 0; `+list, "sh", remoteDir)
+	if err != nil {
+		return nil, fmt.Errorf("listing files in %s: %w: %s", remoteDir, err, output)
+	}
+
+	files := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if compare == CompareChecksum {
+			// sha256sum prints "<checksum>  ./<path>".
+			sum, name, ok := strings.Cut(line, "  ")
+			if ok {
+				files[strings.TrimPrefix(name, "./")] = sum
+			}
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		// find prints fractional seconds; tar keeps whole seconds.
+		seconds, _, _ := strings.Cut(fields[1], ".")
+		files[fields[2]] = fields[0] + " " + seconds
+	}
+
+	return files, nil
+}
+
+// changedEntries returns the directories and the files that differ from the remote files.
+func changedEntries(entries []dirEntry, remote map[string]string, compare Compare) ([]dirEntry, error) {
+	var changed []dirEntry
+	for _, entry := range entries {
+		if entry.info.IsDir() {
+			changed = append(changed, entry)
+			continue
+		}
+
+		value, err := fileKey(entry, compare)
+		if err != nil {
+			return nil, err
+		}
+		if remote[entry.name] != value {
+			changed = append(changed, entry)
+		}
+	}
+
+	// Directories are only needed to create the parents of changed files.
+	if !hasFiles(changed) {
+		return nil, nil
+	}
+
+	return changed, nil
+}
+
+func hasFiles(entries []dirEntry) bool {
+	for _, entry := range entries {
+		if !entry.info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// fileKey returns the value remoteFiles reports for an unchanged copy of the entry.
+func fileKey(entry dirEntry, compare Compare) (string, error) {
+	if compare == CompareSizeTime {
+		return fmt.Sprintf("%d %d", entry.info.Size(), entry.info.ModTime().Unix()), nil
+	}
+
+	f, err := os.Open(entry.path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("reading %s: %w", entry.path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeTar writes the entries to w as a tar archive.
+func writeTar(w io.Writer, entries []dirEntry) error {
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		header, err := tar.FileInfoHeader(entry.info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = entry.name
+		if entry.info.IsDir() {
+			header.Name += "/"
+		}
+		header.ModTime = entry.info.ModTime().Truncate(time.Second)
+		header.Uname, header.Gname = "", ""
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if entry.info.IsDir() {
+			continue
+		}
+
+		if err := copyFileTo(tw, entry.path); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func copyFileTo(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// run runs a command, feeding it stdin when not nil, and returns its output. Unlike the output
+// of RunCommand, it reports a non-zero exit status as an error.
+func (r *Runner) run(ctx context.Context, stdin io.Reader, command string, args ...string) (string, error) {
+	rc, err := r.RunCommandWithInput(ctx, stdin, command, args...)
+	if err != nil {
+		return "", err
+	}
+	output := rc.(*commandOutput)
+	defer output.Close()
+
+	data, err := io.ReadAll(output)
+	if err != nil {
+		return "", err
+	}
+
+	<-output.done
+	return strings.TrimSpace(string(data)), output.err
+}
@@ -1,24 +1,58 @@
 package remote
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"net"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/bramvdbogaerde/go-scp"
 	"golang.org/x/crypto/ssh"
+
+	"github.com/yarlson/ftl/pkg/runner/cmdlog"
+	"github.com/yarlson/ftl/pkg/shell"
 )
 
 // ErrNoClient is returned when attempting operations on a closed Runner.
 var ErrNoClient = errors.New("ssh client is nil")
 
+// dockerSocket is the Docker daemon socket on the remote host.
+const dockerSocket = "/var/run/docker.sock"
+
+// DefaultMaxSessions is the default number of SSH sessions a Runner keeps open at once. It stays
+// below the MaxSessions default of OpenSSH, 10.
+const DefaultMaxSessions = 8
+
+// sessionRetryDelay is the pause before retrying a session the server refused to open.
+var sessionRetryDelay = 200 * time.Millisecond
+
+// Keepalive defaults, like ServerAliveInterval and ServerAliveCountMax of OpenSSH.
+const (
+	DefaultKeepAliveInterval = 30 * time.Second
+	DefaultKeepAliveCountMax = 3
+)
+
+// ErrConnectionLost is returned when the SSH connection dropped and couldn't be replaced.
+var ErrConnectionLost = errors.New("ssh connection lost")
+
 // Runner executes commands and transfers files on a remote host via SSH.
 // Once closed, a Runner cannot be reused.
 type Runner struct {
+	// mu guards client, which is replaced when the Runner reconnects.
+	mu     sync.RWMutex
 	client *ssh.Client // client is unexported as it's an implementation detail
+	// sessions holds a token for every open session; commands beyond its capacity wait.
+	sessions chan struct{}
+	// dial opens a new connection when the current one is lost. Without it, the Runner
+	// doesn't reconnect.
+	dial func() (*ssh.Client, error)
+	// stopKeepAlive stops the keepalive loop. It is nil when no keepalives are sent.
+	stopKeepAlive chan struct{}
 }
 
 // NewRunner creates a new Runner instance using the provided SSH client.
@@ -27,15 +61,193 @@ func NewRunner(client *ssh.Client) *Runner {
 	if client == nil {
 		return nil
 	}
-	return &Runner{client: client}
+	return &Runner{client: client, sessions: make(chan struct{}, DefaultMaxSessions)}
+}
+
+// SetMaxSessions sets the number of SSH sessions the Runner keeps open at once. Commands
+// started beyond the limit wait for a running one to finish. It must be called before the
+// Runner is used.
+func (r *Runner) SetMaxSessions(n int) {
+	if n > 0 {
+		r.sessions = make(chan struct{}, n)
+	}
+}
+
+// SetReconnect sets the function that opens a new connection to the host when the current one
+// is lost. Commands that couldn't start on the lost connection are started on the new one, and
+// commands run with an Idempotent context are run again. It must be called before the Runner
+// is used.
+func (r *Runner) SetReconnect(dial func() (*ssh.Client, error)) {
+	r.dial = dial
+}
+
+// SetKeepAlive makes the Runner send a keepalive request every interval, so that the network
+// doesn't drop a connection that is idle while a long command, like a docker pull, runs. When
+// countMax requests in a row go unanswered, the connection is considered lost: it is closed, so
+// that commands fail instead of hanging, and replaced when a reconnect function is set. Zero
+// values use the defaults. It must be called before the Runner is used.
+func (r *Runner) SetKeepAlive(interval time.Duration, countMax int) {
+	if interval <= 0 {
+		interval = DefaultKeepAliveInterval
+	}
+	if countMax <= 0 {
+		countMax = DefaultKeepAliveCountMax
+	}
+
+	r.stopKeepAlive = make(chan struct{})
+	go r.keepAlive(interval, countMax, r.stopKeepAlive)
+}
+
+func (r *Runner) keepAlive(interval time.Duration, countMax int, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	missed := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		client := r.currentClient()
+		if client == nil {
+			return
+		}
+		if sendKeepAlive(client, interval) {
+			missed = 0
+			continue
+		}
+
+		missed++
+		if missed >= countMax {
+			missed = 0
+			_, _ = r.reconnect(client)
+		}
+	}
+}
+
+// sendKeepAlive sends a keepalive request and reports whether the server answered in time.
+func sendKeepAlive(client *ssh.Client, timeout time.Duration) bool {
+	answered := make(chan bool, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		answered <- err == nil
+	}()
+
+	select {
+	case ok := <-answered:
+		return ok
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// currentClient returns the connection commands are run on, nil once the Runner is closed.
+func (r *Runner) currentClient() *ssh.Client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.client
+}
+
+// reconnect replaces the lost connection with a new one, unless that was done already, and
+// returns the connection to use.
+func (r *Runner) reconnect(lost *ssh.Client) (*ssh.Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.client == nil {
+		return nil, ErrNoClient
+	}
+	if r.client != lost {
+		return r.client, nil
+	}
+
+	_ = lost.Close()
+	if r.dial == nil {
+		return nil, ErrConnectionLost
+	}
+	client, err := r.dial()
+	if err != nil {
+		return nil, fmt.Errorf("%w: reconnecting failed: %v", ErrConnectionLost, err)
+	}
+	r.client = client
+	return client, nil
+}
+
+// connectionLost reports whether err means the connection dropped, rather than the server
+// refusing a session or the command failing.
+func connectionLost(err error) bool {
+	var exitMissing *ssh.ExitMissingError
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) || errors.As(err, &exitMissing)
+}
+
+// newSession opens a session once one of the session slots is free and returns it with the
+// connection it was opened on and the function that frees its slot. A session the server
+// refused to open is retried once, and a session that couldn't be opened because the
+// connection dropped is opened on a new connection.
+func (r *Runner) newSession(ctx context.Context) (*ssh.Session, *ssh.Client, func(), error) {
+	release, err := r.acquireSession(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	client := r.currentClient()
+	if client == nil {
+		release()
+		return nil, nil, nil, ErrNoClient
+	}
+
+	session, err := client.NewSession()
+	if err != nil && strings.Contains(err.Error(), "open failed") {
+		select {
+		case <-ctx.Done():
+			release()
+			return nil, nil, nil, ctx.Err()
+		case <-time.After(sessionRetryDelay):
+		}
+		session, err = client.NewSession()
+	}
+	// Nothing ran yet, so any command can be started on a new connection.
+	if err != nil && connectionLost(err) {
+		if client, err = r.reconnect(client); err == nil {
+			session, err = client.NewSession()
+		}
+	}
+	if err != nil {
+		release()
+		return nil, nil, nil, err
+	}
+
+	return session, client, release, nil
+}
+
+// acquireSession waits for a free session slot and returns the function that frees it. The
+// function may be called more than once.
+func (r *Runner) acquireSession(ctx context.Context) (func(), error) {
+	select {
+	case r.sessions <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() { once.Do(func() { <-r.sessions }) }, nil
 }
 
 // Close releases all resources associated with the Runner.
 // After Close, the Runner cannot be reused.
 func (r *Runner) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	if r.client == nil {
 		return nil
 	}
+	if r.stopKeepAlive != nil {
+		close(r.stopKeepAlive)
+	}
 	err := r.client.Close()
 	r.client = nil
 	return err
@@ -44,7 +256,7 @@ func (r *Runner) Close() error {
 // RunCommands executes multiple commands sequentially on the remote host.
 // It stops at the first command that fails.
 func (r *Runner) RunCommands(ctx context.Context, commands []string) error {
-	if r.client == nil {
+	if r.currentClient() == nil {
 		return ErrNoClient
 	}
 
@@ -71,13 +283,15 @@ func (r *Runner) RunCommands(ctx context.Context, commands []string) error {
 // RunCommand executes a single command with optional arguments on the remote host.
 // The caller must close the returned ReadCloser when done.
 func (r *Runner) RunCommand(ctx context.Context, command string, args ...string) (io.ReadCloser, error) {
-	if r.client == nil {
-		return nil, ErrNoClient
-	}
+	return r.RunCommandWithInput(ctx, nil, command, args...)
+}
 
-	session, err := r.client.NewSession()
-	if err != nil {
-		return nil, fmt.Errorf("creating session: %w", err)
+// RunCommandWithInput executes a command like RunCommand and feeds stdin to its standard input.
+// Use it to pass secrets, which would otherwise show up in the remote process list and shell history.
+// The caller must close the returned ReadCloser when done.
+func (r *Runner) RunCommandWithInput(ctx context.Context, stdin io.Reader, command string, args ...string) (io.ReadCloser, error) {
+	if r.currentClient() == nil {
+		return nil, ErrNoClient
 	}
 
 	// Build the full command with properly escaped arguments
@@ -85,76 +299,147 @@ func (r *Runner) RunCommand(ctx context.Context, command string, args ...string)
 	if len(args) > 0 {
 		escapedArgs := make([]string, len(args))
 		for i, arg := range args {
-			escapedArgs[i] = escapeArg(arg)
+			escapedArgs[i] = shell.Quote(arg)
 		}
 		fullCmd += " " + strings.Join(escapedArgs, " ")
 	}
 
+	logged := cmdlog.Start(r.Host(), command, args)
+	if stdin == nil && ctx.Value(idempotentKey{}) != nil {
+		return r.runIdempotent(ctx, fullCmd, logged)
+	}
+	return r.start(ctx, stdin, fullCmd, logged)
+}
+
+type idempotentKey struct{}
+
+// Idempotent marks the commands run with the returned context as safe to run twice. When the
+// connection drops while such a command runs, the Runner reconnects and runs it again, so the
+// command output is only returned once the command finished.
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// runIdempotent runs a command to completion and runs it again on a new connection when the
+// connection dropped while it ran.
+func (r *Runner) runIdempotent(ctx context.Context, fullCmd string, logged *cmdlog.Command) (io.ReadCloser, error) {
+	var data []byte
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var output *commandOutput
+		output, err = r.start(ctx, nil, fullCmd, logged)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = io.ReadAll(output)
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil || ctx.Err() != nil || !connectionLost(err) {
+			break
+		}
+		if _, reconnectErr := r.reconnect(output.client); reconnectErr != nil {
+			return nil, fmt.Errorf("running %q: %w", fullCmd, reconnectErr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// start starts a command and returns its output. logged is finished when the command exits.
+func (r *Runner) start(ctx context.Context, stdin io.Reader, fullCmd string, logged *cmdlog.Command) (*commandOutput, error) {
+	session, client, release, err := r.newSession(ctx)
+	if err != nil {
+		logged.Finish(err)
+		return nil, fmt.Errorf("creating session: %w", err)
+	}
+
 	// Set up command I/O
 	stdout, err := session.StdoutPipe()
 	if err != nil {
 		session.Close()
+		release()
 		return nil, fmt.Errorf("creating stdout pipe: %w", err)
 	}
 
 	stderr, err := session.StderrPipe()
 	if err != nil {
 		session.Close()
+		release()
 		return nil, fmt.Errorf("creating stderr pipe: %w", err)
 	}
 
+	if stdin != nil {
+		session.Stdin = stdin
+	}
+
 	if err := session.Start(fullCmd); err != nil {
 		session.Close()
+		release()
+		logged.Finish(err)
 		return nil, fmt.Errorf("starting command: %w", err)
 	}
 
-	return &commandOutput{
+	// The slot is freed when the command exits, even if the caller never closes the output.
+	output := &commandOutput{
 		reader:  io.MultiReader(stdout, stderr),
 		session: session,
+		client:  client,
 		ctx:     ctx,
-	}, nil
+		done:    make(chan struct{}),
+	}
+	go func() {
+		output.err = session.Wait()
+		logged.Finish(output.err)
+		release()
+		close(output.done)
+	}()
+
+	// Closing the session of a cancelled command unblocks its pending reads.
+	output.stop = context.AfterFunc(ctx, func() {
+		_ = session.Signal(ssh.SIGTERM)
+		session.Close()
+	})
+
+	return output, nil
+}
+
+// DialDocker connects to the Docker daemon socket of the remote host through the SSH connection.
+func (r *Runner) DialDocker(ctx context.Context) (net.Conn, error) {
+	client := r.currentClient()
+	if client == nil {
+		return nil, ErrNoClient
+	}
+	return client.DialContext(ctx, "unix", dockerSocket)
 }
 
 // Host returns the hostname of the remote server.
 func (r *Runner) Host() string {
-	if r.client == nil {
+	client := r.currentClient()
+	if client == nil {
 		return ""
 	}
-	addr := r.client.RemoteAddr().String()
+	addr := client.RemoteAddr().String()
 	host, _, _ := strings.Cut(addr, ":")
 	return host
 }
 
-// CopyFile copies a file from src on the local machine to dst on the remote host.
-// The destination file will have permissions 0644.
-func (r *Runner) CopyFile(ctx context.Context, src, dst string) error {
-	if r.client == nil {
-		return ErrNoClient
-	}
-
-	client, err := scp.NewClientBySSH(r.client)
-	if err != nil {
-		return fmt.Errorf("creating SCP client: %w", err)
-	}
-
-	f, err := os.Open(src)
-	if err != nil {
-		return fmt.Errorf("opening source file: %w", err)
-	}
-	defer f.Close()
-
-	if err := client.CopyFile(ctx, f, dst, "0644"); err != nil {
-		return fmt.Errorf("copying file: %w", err)
-	}
-	return nil
-}
-
 // commandOutput combines the stdout and stderr of a remote command
 // and handles proper cleanup of the underlying SSH session.
 type commandOutput struct {
 	reader  io.Reader
 	session *ssh.Session
-	ctx     context.Context
+	// client is the connection the command runs on.
+	client *ssh.Client
+	ctx    context.Context
+	stop   func() bool
+	// done is closed when the command exited, with the result of waiting for it in err.
+	done chan struct{}
+	err  error
 }
 
 func (c *commandOutput) Read(p []byte) (int, error) {
@@ -168,20 +453,29 @@ func (c *commandOutput) Read(p []byte) (int, error) {
 }
 
 func (c *commandOutput) Close() error {
+	if !c.stop() {
+		// The context ended and the session was already closed.
+		return nil
+	}
+
 	// Send SIGTERM first for graceful shutdown
-	_ = c.session.Signal(ssh.SIGTERM)
+	select {
+	case <-c.done:
+	default:
+		_ = c.session.Signal(ssh.SIGTERM)
+	}
+	<-c.done
 
 	var exitErr *ssh.ExitError
-	err := c.session.Wait()
+	err := c.err
 	if err != nil && !errors.As(err, &exitErr) {
 		c.session.Close()
 		return fmt.Errorf("waiting for command completion: %w", err)
 	}
 
-	return c.session.Close()
-}
-
-// escapeArg escapes a command-line argument for safe use in SSH commands.
-func escapeArg(arg string) string {
-	return "'" + strings.ReplaceAll(arg, "'", "'\\''") + "'"
+	// The server closes the session once the command exited, which is not an error.
+	if err := c.session.Close(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
 }
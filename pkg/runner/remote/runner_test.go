@@ -0,0 +1,244 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/yarlson/ftl/pkg/ssh"
+	"github.com/yarlson/ftl/tests/dockercontainer"
+)
+
+func TestRunCommandConcurrentSessions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	tc, err := dockercontainer.NewContainer(t)
+	require.NoError(t, err)
+	defer func() { _ = tc.Container.Terminate(context.Background()) }()
+
+	client, err := ssh.NewSSHClientWithPassword("127.0.0.1", tc.SshPort.Port(), "root", "testpassword")
+	require.NoError(t, err)
+	runner := NewRunner(client)
+	defer runner.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	// OpenSSH allows 10 sessions per connection by default.
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			output, err := runner.RunCommand(ctx, "sh", "-c", fmt.Sprintf("sleep 0.2; echo %d", i))
+			if err != nil {
+				errs <- err
+				return
+			}
+			data, err := io.ReadAll(output)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if got := strings.TrimSpace(string(data)); got != fmt.Sprint(i) {
+				errs <- fmt.Errorf("command %d printed %q", i, got)
+			}
+			// Every other caller leaves the output open; its session slot is freed anyway.
+			if i%2 == 0 {
+				_ = output.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+func TestRunCommandReconnects(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	tc, err := dockercontainer.NewContainer(t)
+	require.NoError(t, err)
+	defer func() { _ = tc.Container.Terminate(context.Background()) }()
+
+	dial := func() (*gossh.Client, error) {
+		return ssh.NewSSHClientWithPassword("127.0.0.1", tc.SshPort.Port(), "root", "testpassword")
+	}
+	client, err := dial()
+	require.NoError(t, err)
+	runner := NewRunner(client)
+	runner.SetReconnect(dial)
+	defer runner.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// A command that can't start on the dropped connection starts on a new one.
+	require.NoError(t, client.Close())
+	output, err := runner.RunCommand(ctx, "echo", "hello")
+	require.NoError(t, err)
+	data, err := io.ReadAll(output)
+	require.NoError(t, err)
+	require.NoError(t, output.Close())
+	assert.Equal(t, "hello", strings.TrimSpace(string(data)))
+
+	// An idempotent command is run again when the connection drops while it runs.
+	dropped := runner.currentClient()
+	time.AfterFunc(300*time.Millisecond, func() { _ = dropped.Close() })
+	output, err = runner.RunCommand(Idempotent(ctx), "sh", "-c", "sleep 1; echo done")
+	require.NoError(t, err)
+	data, err = io.ReadAll(output)
+	require.NoError(t, err)
+	assert.Equal(t, "done", strings.TrimSpace(string(data)))
+	assert.NotSame(t, dropped, runner.currentClient())
+
+	// Unanswered keepalives replace the connection before the next command.
+	runner.SetKeepAlive(50*time.Millisecond, 1)
+	dropped = runner.currentClient()
+	require.NoError(t, dropped.Close())
+	assert.Eventually(t, func() bool { return runner.currentClient() != dropped }, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestConnectionLost(t *testing.T) {
+	assert.True(t, connectionLost(io.EOF))
+	assert.True(t, connectionLost(fmt.Errorf("waiting for command completion: %w", &gossh.ExitMissingError{})))
+	assert.True(t, connectionLost(fmt.Errorf("write: %w", net.ErrClosed)))
+	assert.False(t, connectionLost(&gossh.ExitError{}))
+	assert.False(t, connectionLost(errors.New("ssh: rejected: administratively prohibited (open failed)")))
+	assert.False(t, connectionLost(context.Canceled))
+}
+
+func TestCopyReader(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	tc, err := dockercontainer.NewContainer(t)
+	require.NoError(t, err)
+	defer func() { _ = tc.Container.Terminate(context.Background()) }()
+
+	client, err := ssh.NewSSHClientWithPassword("127.0.0.1", tc.SshPort.Port(), "root", "testpassword")
+	require.NoError(t, err)
+	runner := NewRunner(client)
+	defer runner.Close()
+
+	ctx := context.Background()
+	data := strings.Repeat("ftl", 100000)
+
+	var written, size int64
+	err = runner.CopyReader(ctx, strings.NewReader(data), "/tmp/ftl/nested/data.txt", CopyOptions{
+		Mode:  0600,
+		Owner: &Owner{UID: 1000, GID: 1000},
+		Size:  int64(len(data)),
+		Progress: func(w, s int64) {
+			written, size = w, s
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), written)
+	assert.Equal(t, int64(len(data)), size)
+
+	output, err := runner.RunCommand(ctx, "stat", "-c", "%a %u:%g %s", "/tmp/ftl/nested/data.txt")
+	require.NoError(t, err)
+	stat, err := io.ReadAll(output)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("600 1000:1000 %d", len(data)), strings.TrimSpace(string(stat)))
+}
+
+func TestWalkDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"index.html":              "<h1>shop</h1>",
+		"css/site.css":            "body {}",
+		"css/site.css.map":        "{}",
+		"node_modules/x/index.js": "",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	entries, err := walkDir(dir, CopyDirOptions{Include: []string{"*.html", "*.css"}, Exclude: []string{"node_modules"}})
+	require.NoError(t, err)
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.name)
+	}
+	assert.Equal(t, []string{"css", "css/site.css", "index.html"}, names)
+
+	unchanged := map[string]string{}
+	for _, entry := range entries {
+		if !entry.info.IsDir() {
+			unchanged[entry.name], err = fileKey(entry, CompareChecksum)
+			require.NoError(t, err)
+		}
+	}
+	changed, err := changedEntries(entries, unchanged, CompareChecksum)
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+
+	unchanged["index.html"] = "stale"
+	changed, err = changedEntries(entries, unchanged, CompareChecksum)
+	require.NoError(t, err)
+	require.Len(t, changed, 2)
+	assert.Equal(t, "index.html", changed[1].name)
+}
+
+func TestCopyDir(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	tc, err := dockercontainer.NewContainer(t)
+	require.NoError(t, err)
+	defer func() { _ = tc.Container.Terminate(context.Background()) }()
+
+	client, err := ssh.NewSSHClientWithPassword("127.0.0.1", tc.SshPort.Port(), "root", "testpassword")
+	require.NoError(t, err)
+	runner := NewRunner(client)
+	defer runner.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "css"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>shop</h1>"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "css", "site.css"), []byte("body {}"), 0600))
+
+	ctx := context.Background()
+	opts := CopyDirOptions{Compare: CompareSizeTime}
+	require.NoError(t, runner.CopyDir(ctx, dir, "/srv/shop/public", opts))
+
+	files, err := runner.remoteFiles(ctx, "/srv/shop/public", CompareSizeTime)
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	entries, err := walkDir(dir, opts)
+	require.NoError(t, err)
+	changed, err := changedEntries(entries, files, CompareSizeTime)
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+
+	output, err := runner.run(ctx, nil, "stat", "-c", "%a", "/srv/shop/public/css/site.css")
+	require.NoError(t, err)
+	assert.Equal(t, "600", output)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/yarlson/ftl/pkg/build"
+	"github.com/yarlson/ftl/pkg/runner/remote"
+	"github.com/yarlson/ftl/pkg/shell"
+)
+
+// Disk is the file system holding a directory of the server.
+type Disk struct {
+	Dir   string
+	Mount string
+	// Size and Available are in bytes.
+	Size      int64
+	Available int64
+}
+
+// DockerRoot returns the data directory of Docker on the server, which holds the images and
+// containers, or its default when docker info can't tell.
+func DockerRoot(ctx context.Context, runner *remote.Runner) string {
+	output, err := commandOutput(ctx, runner, "docker info --format '{{.DockerRootDir}}' 2>/dev/null")
+	if err != nil || !strings.HasPrefix(output, "/") || strings.ContainsAny(output, " \n") {
+		return defaultDockerRoot
+	}
+	return output
+}
+
+// Disks returns the file systems holding dirs on the server, in the order of dirs. Relative
+// dirs are resolved against the home directory of the SSH user.
+func Disks(ctx context.Context, runner *remote.Runner, dirs ...string) ([]Disk, error) {
+	quoted := make([]string, len(dirs))
+	for i, dir := range dirs {
+		quoted[i] = shell.Quote(dir)
+	}
+	output, err := commandOutput(ctx, runner, fmt.Sprintf("df -Pk %s 2>&1", strings.Join(quoted, " ")))
+	if err != nil {
+		return nil, err
+	}
+
+	disks, err := parseDisks(output)
+	if err != nil {
+		return nil, err
+	}
+	if len(disks) != len(dirs) {
+		return nil, fmt.Errorf("unexpected output of df: %s", output)
+	}
+	for i := range disks {
+		disks[i].Dir = dirs[i]
+	}
+	return disks, nil
+}
+
+// parseDisks parses the output of df -Pk, one file system per line after the header.
+func parseDisks(output string) ([]Disk, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], "Filesystem") {
+		return nil, fmt.Errorf("unexpected output of df: %s", output)
+	}
+
+	disks := make([]Disk, 0, len(lines)-1)
+	for _, line := range lines[1:] {
+		// The file system name may contain spaces, so the columns are counted from the end:
+		// 1024-blocks, Used, Available, Capacity and Mounted on.
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("unexpected output of df: %s", output)
+		}
+		total, err := strconv.ParseInt(fields[len(fields)-5], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected output of df: %s", output)
+		}
+		available, err := strconv.ParseInt(fields[len(fields)-3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected output of df: %s", output)
+		}
+		disks = append(disks, Disk{Mount: fields[len(fields)-1], Size: total * 1024, Available: available * 1024})
+	}
+	return disks, nil
+}
+
+// CheckSpace returns an error when the disks don't have room for size bytes written to each
+// of their directories. Directories on the same file system need room for all of them.
+func CheckSpace(disks []Disk, size int64) error {
+	var mounts []string
+	dirs := make(map[string][]string)
+	available := make(map[string]int64)
+	for _, disk := range disks {
+		if _, ok := dirs[disk.Mount]; !ok {
+			mounts = append(mounts, disk.Mount)
+		}
+		dirs[disk.Mount] = append(dirs[disk.Mount], disk.Dir)
+		available[disk.Mount] = disk.Available
+	}
+
+	var errs []error
+	for _, mount := range mounts {
+		needed := size * int64(len(dirs[mount]))
+		if available[mount] < needed {
+			errs = append(errs, fmt.Errorf("%s needs %s free for %s, but has %s",
+				mount, build.FormatBytes(needed), strings.Join(dirs[mount], " and "), build.FormatBytes(available[mount])))
+		}
+	}
+	return errors.Join(errs...)
+}
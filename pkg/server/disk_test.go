@@ -0,0 +1,40 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDisks(t *testing.T) {
+	disks, err := parseDisks(`Filesystem     1024-blocks    Used Available Capacity Mounted on
+/dev/sda1         41152736 9876544  29163324      26% /
+/dev/sdb1        104857600 1048576 103809024       1% /var/lib/docker`)
+	require.NoError(t, err)
+	assert.Equal(t, []Disk{
+		{Mount: "/", Size: 41152736 * 1024, Available: 29163324 * 1024},
+		{Mount: "/var/lib/docker", Size: 104857600 * 1024, Available: 103809024 * 1024},
+	}, disks)
+
+	_, err = parseDisks("df: /srv/store: No such file or directory")
+	assert.Error(t, err)
+}
+
+func TestCheckSpace(t *testing.T) {
+	const gib = 1 << 30
+
+	separate := []Disk{
+		{Dir: ".", Mount: "/", Available: 3 * gib},
+		{Dir: "/var/lib/docker", Mount: "/var/lib/docker", Available: 50 * gib},
+	}
+	assert.NoError(t, CheckSpace(separate, 2*gib))
+	assert.EqualError(t, CheckSpace(separate, 4*gib), "/ needs 4.0 GiB free for ., but has 3.0 GiB")
+
+	shared := []Disk{
+		{Dir: ".", Mount: "/", Available: 3 * gib},
+		{Dir: "/var/lib/docker", Mount: "/", Available: 3 * gib},
+	}
+	assert.NoError(t, CheckSpace(shared, gib))
+	assert.EqualError(t, CheckSpace(shared, 2*gib), "/ needs 4.0 GiB free for . and /var/lib/docker, but has 3.0 GiB")
+}
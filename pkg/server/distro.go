@@ -0,0 +1,317 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/yarlson/ftl/pkg/runner/remote"
+	"github.com/yarlson/ftl/pkg/shell"
+)
+
+// Distribution families supported by setup.
+const (
+	familyDebian = "debian"
+	familyFedora = "fedora"
+	familyAlpine = "alpine"
+)
+
+// distro describes the Linux distribution running on the server.
+type distro struct {
+	ID        string
+	IDLike    []string
+	Name      string
+	VersionID string
+	Family    string
+}
+
+// detectDistro reads /etc/os-release on the server and determines the distribution family.
+func detectDistro(ctx context.Context, runner *remote.Runner) (*distro, error) {
+	output, err := runner.RunCommand(ctx, "cat", "/etc/os-release")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /etc/os-release: %w", err)
+	}
+	defer output.Close()
+
+	d, err := parseOSRelease(output)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Family == "" {
+		name := d.Name
+		if name == "" {
+			name = "unknown"
+		}
+		return nil, fmt.Errorf("unsupported distribution %s (ID=%q, ID_LIKE=%q); supported are Ubuntu, Debian, Fedora and RHEL-compatible distributions, and Alpine",
+			name, d.ID, strings.Join(d.IDLike, " "))
+	}
+
+	return d, nil
+}
+
+// parseOSRelease parses the os-release format and classifies the distribution.
+func parseOSRelease(r io.Reader) (*distro, error) {
+	d := &distro{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+
+		switch key {
+		case "ID":
+			d.ID = strings.ToLower(value)
+		case "ID_LIKE":
+			d.IDLike = strings.Fields(strings.ToLower(value))
+		case "PRETTY_NAME":
+			d.Name = value
+		case "VERSION_ID":
+			d.VersionID = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse /etc/os-release: %w", err)
+	}
+
+	if d.Name == "" {
+		d.Name = d.ID
+	}
+
+	for _, id := range append([]string{d.ID}, d.IDLike...) {
+		switch id {
+		case "debian", "ubuntu":
+			d.Family = familyDebian
+		case "fedora", "rhel", "centos":
+			d.Family = familyFedora
+		case "alpine":
+			d.Family = familyAlpine
+		}
+		if d.Family != "" {
+			break
+		}
+	}
+
+	return d, nil
+}
+
+// installCommands returns the commands that install Docker and the tools setup relies on.
+func (d *distro) installCommands() []string {
+	switch d.Family {
+	case familyFedora:
+		return []string{
+			"dnf install -y ca-certificates curl wget git cronie",
+			"curl -fsSL https://get.docker.com | sh",
+			"systemctl enable --now docker",
+			"systemctl enable --now crond",
+		}
+	case familyAlpine:
+		return []string{
+			"apk update",
+			"apk add ca-certificates curl wget git docker docker-cli-compose",
+			"rc-update add docker boot",
+			"service docker start",
+			"rc-update add crond default",
+			"service crond start",
+		}
+	default:
+		return []string{
+			"apt-get update",
+			"apt-get install -y ca-certificates curl wget git cron",
+			"curl -fsSL https://get.docker.com | sh",
+		}
+	}
+}
+
+// firewallCommands returns the commands that allow the given rules and block other incoming traffic.
+// Debian based systems use ufw, Fedora based systems firewalld and Alpine nftables.
+func (d *distro) firewallCommands(rules []firewallRule) []string {
+	switch d.Family {
+	case familyFedora:
+		commands := []string{
+			"dnf install -y firewalld",
+			"systemctl enable --now firewalld",
+		}
+		for _, rule := range rules {
+			commands = append(commands, fmt.Sprintf("firewall-cmd --permanent --add-port=%s", rule))
+		}
+		return append(commands, "firewall-cmd --reload")
+	case familyAlpine:
+		return []string{
+			"apk add nftables",
+			"mkdir -p /etc/nftables.d",
+			fmt.Sprintf("printf '%%s' %s > /etc/nftables.d/ftl.nft", shell.Quote(nftRuleset(rules))),
+			"rc-update add nftables boot",
+			"nft delete table inet ftl 2>/dev/null; nft -f /etc/nftables.d/ftl.nft",
+		}
+	default:
+		commands := []string{
+			"apt-get install -y ufw",
+			"ufw default deny incoming",
+			"ufw default allow outgoing",
+		}
+		for _, rule := range rules {
+			commands = append(commands, fmt.Sprintf("ufw allow %s", rule))
+		}
+		return append(commands, "ufw --force enable")
+	}
+}
+
+// nftRuleset renders the nftables table used on Alpine.
+func nftRuleset(rules []firewallRule) string {
+	var b strings.Builder
+	b.WriteString("table inet ftl {\n")
+	b.WriteString("\tchain input {\n")
+	b.WriteString("\t\ttype filter hook input priority 0; policy drop;\n")
+	b.WriteString("\t\tct state established,related accept\n")
+	b.WriteString("\t\tiif lo accept\n")
+	b.WriteString("\t\tmeta l4proto { icmp, ipv6-icmp } accept\n")
+	for _, protocol := range []string{"tcp", "udp"} {
+		var ports []string
+		for _, rule := range rules {
+			if rule.Protocol == protocol {
+				ports = append(ports, strconv.Itoa(rule.Port))
+			}
+		}
+		if len(ports) > 0 {
+			fmt.Fprintf(&b, "\t\t%s dport { %s } accept\n", protocol, strings.Join(ports, ", "))
+		}
+	}
+	b.WriteString("\t}\n")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// firewallConfigured reports whether the firewall is active and already allows the given rules.
+func (d *distro) firewallConfigured(ctx context.Context, runner *remote.Runner, rules []firewallRule) (bool, error) {
+	var command string
+
+	switch d.Family {
+	case familyFedora:
+		command = `[ "$(firewall-cmd --state 2>/dev/null)" = running ] && echo firewall-active; firewall-cmd --list-ports 2>/dev/null || true`
+	case familyAlpine:
+		command = "nft list table inet ftl >/dev/null 2>&1 && echo firewall-active; cat /etc/nftables.d/ftl.nft 2>/dev/null || true"
+	default:
+		command = "ufw status 2>/dev/null || true"
+	}
+
+	output, err := runner.RunCommand(ctx, "sh", "-c", command)
+	if err != nil {
+		return false, fmt.Errorf("failed to check firewall status: %w", err)
+	}
+	defer output.Close()
+
+	data, err := io.ReadAll(output)
+	if err != nil {
+		return false, fmt.Errorf("failed to read firewall status: %w", err)
+	}
+
+	return d.firewallSatisfied(string(data), rules), nil
+}
+
+// firewallSatisfied reports whether the firewall status output shows an active firewall
+// that allows every rule.
+func (d *distro) firewallSatisfied(status string, rules []firewallRule) bool {
+	switch d.Family {
+	case familyFedora:
+		if !strings.Contains(status, "firewall-active") {
+			return false
+		}
+		ports := make(map[string]bool)
+		for _, field := range strings.Fields(status) {
+			ports[field] = true
+		}
+		for _, rule := range rules {
+			if !ports[rule.String()] {
+				return false
+			}
+		}
+		return true
+	case familyAlpine:
+		return strings.Contains(status, "firewall-active") && strings.Contains(status, nftRuleset(rules))
+	default:
+		if !strings.Contains(status, "Status: active") {
+			return false
+		}
+		allowed := make(map[string]bool)
+		for _, line := range strings.Split(status, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[1] == "ALLOW" {
+				allowed[fields[0]] = true
+			}
+		}
+		for _, rule := range rules {
+			if !allowed[rule.String()] {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// restartSSHCommand returns the command that restarts the SSH server.
+func (d *distro) restartSSHCommand() string {
+	switch d.Family {
+	case familyFedora:
+		return "systemctl restart sshd"
+	case familyAlpine:
+		return "rc-service sshd restart"
+	default:
+		return "systemctl restart ssh || systemctl restart sshd"
+	}
+}
+
+// fail2banCommands returns the commands that install fail2ban with an sshd jail.
+func (d *distro) fail2banCommands(sshPort int) []string {
+	jail := fmt.Sprintf("mkdir -p /etc/fail2ban/jail.d && printf '%%s' %s > %s", shell.Quote(fail2banJail(sshPort)), fail2banJailPath)
+
+	switch d.Family {
+	case familyFedora:
+		return []string{
+			"dnf install -y fail2ban || (dnf install -y epel-release && dnf install -y fail2ban)",
+			jail,
+			"systemctl enable fail2ban",
+			"systemctl restart fail2ban",
+		}
+	case familyAlpine:
+		return []string{
+			"apk add fail2ban",
+			jail,
+			"rc-update add fail2ban default",
+			"rc-service fail2ban restart",
+		}
+	default:
+		return []string{
+			"apt-get install -y fail2ban",
+			jail,
+			"systemctl enable fail2ban",
+			"systemctl restart fail2ban",
+		}
+	}
+}
+
+// addUserCommand returns the command that creates a user without a password.
+func (d *distro) addUserCommand(user string) string {
+	switch d.Family {
+	case familyFedora:
+		return fmt.Sprintf("useradd -m -s /bin/bash %s", user)
+	case familyAlpine:
+		return fmt.Sprintf("adduser -D -s /bin/sh %s", user)
+	default:
+		return fmt.Sprintf("adduser --gecos '' --disabled-password %s", user)
+	}
+}
+
+// addToDockerGroupCommand returns the command that allows the user to run docker.
+func (d *distro) addToDockerGroupCommand(user string) string {
+	if d.Family == familyAlpine {
+		return fmt.Sprintf("addgroup %s docker", user)
+	}
+	return fmt.Sprintf("usermod -aG docker %s", user)
+}
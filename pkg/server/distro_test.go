@@ -0,0 +1,116 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOSRelease(t *testing.T) {
+	tests := []struct {
+		name      string
+		osRelease string
+		family    string
+		pretty    string
+	}{
+		{
+			name:      "ubuntu",
+			osRelease: "NAME=\"Ubuntu\"\nID=ubuntu\nID_LIKE=debian\nPRETTY_NAME=\"Ubuntu 22.04.4 LTS\"\nVERSION_ID=\"22.04\"\n",
+			family:    familyDebian,
+			pretty:    "Ubuntu 22.04.4 LTS",
+		},
+		{
+			name:      "debian",
+			osRelease: "PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\nID=debian\nVERSION_ID=\"12\"\n",
+			family:    familyDebian,
+			pretty:    "Debian GNU/Linux 12 (bookworm)",
+		},
+		{
+			name:      "fedora",
+			osRelease: "NAME=\"Fedora Linux\"\nID=fedora\nVERSION_ID=40\nPRETTY_NAME=\"Fedora Linux 40 (Server Edition)\"\n",
+			family:    familyFedora,
+			pretty:    "Fedora Linux 40 (Server Edition)",
+		},
+		{
+			name:      "rocky",
+			osRelease: "ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\nPRETTY_NAME=\"Rocky Linux 9.3 (Blue Onyx)\"\n",
+			family:    familyFedora,
+			pretty:    "Rocky Linux 9.3 (Blue Onyx)",
+		},
+		{
+			name:      "alpine",
+			osRelease: "NAME=\"Alpine Linux\"\nID=alpine\nVERSION_ID=3.20.0\nPRETTY_NAME=\"Alpine Linux v3.20\"\n",
+			family:    familyAlpine,
+			pretty:    "Alpine Linux v3.20",
+		},
+		{
+			name:      "unknown",
+			osRelease: "ID=arch\nPRETTY_NAME=\"Arch Linux\"\n",
+			family:    "",
+			pretty:    "Arch Linux",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := parseOSRelease(strings.NewReader(tt.osRelease))
+			require.NoError(t, err)
+			assert.Equal(t, tt.family, d.Family)
+			assert.Equal(t, tt.pretty, d.Name)
+		})
+	}
+}
+
+func TestDistroCommands(t *testing.T) {
+	rules := []firewallRule{{Port: 22, Protocol: "tcp"}, {Port: 443, Protocol: "tcp"}}
+
+	fedora := &distro{Family: familyFedora}
+	assert.Contains(t, fedora.firewallCommands(rules), "firewall-cmd --permanent --add-port=443/tcp")
+	assert.Contains(t, fedora.firewallCommands(rules), "firewall-cmd --reload")
+	assert.Equal(t, "useradd -m -s /bin/bash deploy", fedora.addUserCommand("deploy"))
+
+	debian := &distro{Family: familyDebian}
+	assert.Contains(t, debian.firewallCommands(rules), "ufw allow 443/tcp")
+	assert.Equal(t, "usermod -aG docker deploy", debian.addToDockerGroupCommand("deploy"))
+
+	alpine := &distro{Family: familyAlpine}
+	assert.Equal(t, "addgroup deploy docker", alpine.addToDockerGroupCommand("deploy"))
+}
+
+func TestNftRuleset(t *testing.T) {
+	ruleset := nftRuleset([]firewallRule{
+		{Port: 2222, Protocol: "tcp"},
+		{Port: 80, Protocol: "tcp"},
+		{Port: 27015, Protocol: "udp"},
+	})
+	assert.Contains(t, ruleset, "tcp dport { 2222, 80 } accept")
+	assert.Contains(t, ruleset, "udp dport { 27015 } accept")
+}
+
+func TestFirewallSatisfied(t *testing.T) {
+	rules := []firewallRule{{Port: 22, Protocol: "tcp"}, {Port: 27015, Protocol: "udp"}}
+
+	debian := &distro{Family: familyDebian}
+	ufwStatus := `Status: active
+
+To                         Action      From
+--                         ------      ----
+22/tcp                     ALLOW       Anywhere
+27015/udp                  ALLOW       Anywhere
+22/tcp (v6)                ALLOW       Anywhere (v6)
+`
+	assert.True(t, debian.firewallSatisfied(ufwStatus, rules))
+	assert.False(t, debian.firewallSatisfied(strings.Replace(ufwStatus, "27015/udp", "27016/udp", 1), rules))
+	assert.False(t, debian.firewallSatisfied("Status: inactive\n", rules))
+	assert.False(t, debian.firewallSatisfied("Status: active\n2222/tcp ALLOW Anywhere\n", rules[:1]))
+
+	fedora := &distro{Family: familyFedora}
+	assert.True(t, fedora.firewallSatisfied("firewall-active\n22/tcp 80/tcp 27015/udp\n", rules))
+	assert.False(t, fedora.firewallSatisfied("22/tcp 27015/udp\n", rules))
+
+	alpine := &distro{Family: familyAlpine}
+	assert.True(t, alpine.firewallSatisfied("firewall-active\n"+nftRuleset(rules), rules))
+	assert.False(t, alpine.firewallSatisfied("firewall-active\n"+nftRuleset(rules[:1]), rules))
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+// firewallRule allows incoming traffic on a single port.
+type firewallRule struct {
+	Port     int
+	Protocol string
+}
+
+func (r firewallRule) String() string {
+	return fmt.Sprintf("%d/%s", r.Port, r.Protocol)
+}
+
+// firewallRules returns the rules setup opens: the SSH port, HTTP, HTTPS, the server
+// firewall_allow list and any extra rules, without duplicates.
+func firewallRules(cfg config.Server, extra []string) ([]firewallRule, error) {
+	sshPort := cfg.Port
+	if sshPort == 0 {
+		sshPort = 22
+	}
+
+	rules := []firewallRule{
+		{Port: sshPort, Protocol: "tcp"},
+		{Port: 80, Protocol: "tcp"},
+		{Port: 443, Protocol: "tcp"},
+	}
+	seen := make(map[firewallRule]bool)
+	for _, rule := range rules {
+		seen[rule] = true
+	}
+
+	for _, spec := range append(append([]string{}, cfg.FirewallAllow...), extra...) {
+		port, protocol, err := config.ParseFirewallRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rule := firewallRule{Port: port, Protocol: protocol}
+		if seen[rule] {
+			continue
+		}
+		seen[rule] = true
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// ForwardPorts returns the host ports published by service forwards as "port/protocol" rules.
+// Forwards without a fixed host port, port ranges and forwards bound to loopback are left out,
+// since they can't or needn't be opened in the firewall.
+func ForwardPorts(services []config.Service) []string {
+	var ports []string
+	seen := make(map[string]bool)
+
+	for _, service := range services {
+		for _, forward := range service.Forwards {
+			rule, ok := forwardRule(forward)
+			if !ok || seen[rule] {
+				continue
+			}
+			seen[rule] = true
+			ports = append(ports, rule)
+		}
+	}
+
+	return ports
+}
+
+// forwardRule returns the firewall rule of a docker publish spec ([ip:]hostPort:containerPort[/protocol]).
+func forwardRule(spec string) (string, bool) {
+	forward, err := config.ParseForward(spec)
+	if err != nil {
+		return "", false
+	}
+	if ip := net.ParseIP(forward.IP); ip != nil && ip.IsLoopback() {
+		return "", false
+	}
+	return fmt.Sprintf("%d/%s", forward.HostPort, forward.Protocol), true
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestFirewallRules(t *testing.T) {
+	rules, err := firewallRules(config.Server{
+		Port:          2222,
+		FirewallAllow: []string{"8443/tcp", "27015/udp", "80"},
+	}, []string{"9000/tcp", "8443/tcp"})
+	require.NoError(t, err)
+
+	var specs []string
+	for _, rule := range rules {
+		specs = append(specs, rule.String())
+	}
+	assert.Equal(t, []string{"2222/tcp", "80/tcp", "443/tcp", "8443/tcp", "27015/udp", "9000/tcp"}, specs)
+
+	_, err = firewallRules(config.Server{Port: 22}, []string{"bogus"})
+	assert.Error(t, err)
+}
+
+func TestForwardPorts(t *testing.T) {
+	services := []config.Service{
+		{Name: "game", Forwards: []string{"27015:27015/udp", "8080:80", "127.0.0.1:9000:9000", "[::1]:9001:9001", "3000"}},
+		{Name: "admin", Forwards: []string{"0.0.0.0:8443:443", "8080:8080", "7000-7010:7000-7010"}},
+	}
+
+	assert.Equal(t, []string{"27015/udp", "8080/tcp", "8443/tcp"}, ForwardPorts(services))
+}
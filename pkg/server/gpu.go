@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// nvidiaRepository is where the NVIDIA container toolkit packages are published.
+const nvidiaRepository = "https://nvidia.github.io/libnvidia-container"
+
+func installGPUSupport(ctx context.Context, s *setupState) (string, error) {
+	if !s.server.GPU {
+		return "not enabled, set server.gpu", nil
+	}
+
+	configured, err := nvidiaRuntimeConfigured(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	if configured {
+		return "the NVIDIA container runtime is already configured", nil
+	}
+
+	commands, err := s.distro.nvidiaToolkitCommands()
+	if err != nil {
+		return "", err
+	}
+	if err := s.runner.RunCommands(ctx, commands); err != nil {
+		return "", err
+	}
+
+	configured, err = nvidiaRuntimeConfigured(ctx, s)
+	if err != nil {
+		return "", err
+	}
+	if !configured {
+		return "", fmt.Errorf("docker doesn't list the nvidia runtime after installing the NVIDIA container toolkit")
+	}
+
+	s.record("installed the NVIDIA container toolkit and configured the nvidia runtime for docker")
+	return "", nil
+}
+
+// nvidiaRuntimeConfigured reports whether docker on the server has the nvidia runtime.
+func nvidiaRuntimeConfigured(ctx context.Context, s *setupState) (bool, error) {
+	runtimes, err := commandOutput(ctx, s.runner, "docker info --format '{{json .Runtimes}}' 2>/dev/null || true")
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(runtimes, `"nvidia"`), nil
+}
+
+// nvidiaToolkitCommands returns the commands that install the NVIDIA container toolkit and
+// register its runtime with docker. The GPU driver itself must already be installed.
+func (d *distro) nvidiaToolkitCommands() ([]string, error) {
+	configure := []string{
+		"nvidia-ctk runtime configure --runtime=docker",
+		"systemctl restart docker",
+	}
+
+	switch d.Family {
+	case familyFedora:
+		return append([]string{
+			fmt.Sprintf("curl -fsSL %s/stable/rpm/nvidia-container-toolkit.repo -o /etc/yum.repos.d/nvidia-container-toolkit.repo", nvidiaRepository),
+			"dnf install -y nvidia-container-toolkit",
+		}, configure...), nil
+	case familyAlpine:
+		return nil, fmt.Errorf("the NVIDIA container toolkit is not available for %s", d.Name)
+	default:
+		keyring := "/usr/share/keyrings/nvidia-container-toolkit-keyring.gpg"
+		return append([]string{
+			"apt-get install -y gnupg",
+			fmt.Sprintf("curl -fsSL %s/gpgkey | gpg --dearmor --yes -o %s", nvidiaRepository, keyring),
+			fmt.Sprintf("curl -fsSL %s/stable/deb/nvidia-container-toolkit.list | sed 's#deb https://#deb [signed-by=%s] https://#g' > /etc/apt/sources.list.d/nvidia-container-toolkit.list",
+				nvidiaRepository, keyring),
+			"apt-get update",
+			"apt-get install -y nvidia-container-toolkit",
+		}, configure...), nil
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/yarlson/ftl/pkg/runner/remote"
+	"github.com/yarlson/ftl/pkg/shell"
+	"github.com/yarlson/ftl/pkg/ssh"
+)
+
+const (
+	sshdConfigPath   = "/etc/ssh/sshd_config"
+	sshdConfigBackup = "/etc/ssh/sshd_config.ftl.bak"
+	fail2banJailPath = "/etc/fail2ban/jail.d/ftl-sshd.conf"
+)
+
+// sshdHardening holds the sshd_config directives applied by the harden-ssh step. Root keeps
+// logging in with its key, since setup connects as root.
+var sshdHardening = []string{
+	"PasswordAuthentication no",
+	"PermitRootLogin prohibit-password",
+}
+
+// sshdAliases maps the values sshd -T prints to the ones written by the harden-ssh step.
+var sshdAliases = map[string]string{
+	"without-password": "prohibit-password",
+}
+
+func hardenSSH(ctx context.Context, s *setupState) (string, error) {
+	if !s.opts.HardenSSH && !s.server.HardenSSH {
+		return "not enabled, use --harden-ssh or server.harden_ssh", nil
+	}
+
+	effective, err := commandOutput(ctx, s.runner, "sshd -T 2>/dev/null || true")
+	if err != nil {
+		return "", err
+	}
+	jail, err := commandOutput(ctx, s.runner, fmt.Sprintf("[ -f %s ] && command -v fail2ban-server >/dev/null && echo present || true", fail2banJailPath))
+	if err != nil {
+		return "", err
+	}
+	if sshdHardened(effective) && jail == "present" {
+		return "SSH is already hardened", nil
+	}
+
+	// Never lock ourselves out: make sure the deploy user, and root for later setup runs, can
+	// log in with the key first.
+	for _, user := range []string{s.server.User, "root"} {
+		if err := verifyUserLogin(ctx, s.server.Host, s.server.Port, user, s.server.SSHKey); err != nil {
+			return "", fmt.Errorf("refusing to disable password login: %w", err)
+		}
+	}
+
+	if err := s.runner.RunCommands(ctx, sshdConfigCommands()); err != nil {
+		return "", err
+	}
+
+	check, err := commandOutput(ctx, s.runner, "sshd -t 2>&1 && echo sshd-config-ok")
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(check, "sshd-config-ok") {
+		if err := restoreSSHDConfig(ctx, s.runner); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("sshd rejected the hardened configuration, original restored: %s", check)
+	}
+
+	if err := s.runner.RunCommands(ctx, []string{s.distro.restartSSHCommand()}); err != nil {
+		return "", err
+	}
+
+	if err := verifyUserLogin(ctx, s.server.Host, s.server.Port, s.server.User, s.server.SSHKey); err != nil {
+		if restoreErr := restoreSSHDConfig(ctx, s.runner); restoreErr != nil {
+			return "", fmt.Errorf("%w; restoring sshd_config failed: %v", err, restoreErr)
+		}
+		_ = s.runner.RunCommands(ctx, []string{s.distro.restartSSHCommand()})
+		return "", fmt.Errorf("login failed after hardening, original sshd_config restored: %w", err)
+	}
+
+	return "", s.runner.RunCommands(ctx, s.distro.fail2banCommands(s.server.Port))
+}
+
+// verifyUserLogin connects as user with the configured key and runs a command.
+func verifyUserLogin(ctx context.Context, host string, port int, user, keyPath string) error {
+	client, _, err := ssh.FindKeyAndConnectWithUser(host, port, user, keyPath)
+	if err != nil {
+		return fmt.Errorf("cannot log in as %s with %s: %w", user, keyPath, err)
+	}
+	defer client.Close()
+
+	output, err := commandOutput(ctx, remote.NewRunner(client), "echo login-ok")
+	if err != nil {
+		return fmt.Errorf("cannot run commands as %s: %w", user, err)
+	}
+	if output != "login-ok" {
+		return fmt.Errorf("unexpected output running commands as %s: %q", user, output)
+	}
+	return nil
+}
+
+// sshdHardened reports whether the effective sshd configuration (sshd -T output) already
+// disables password login, for root too.
+func sshdHardened(effective string) bool {
+	settings := make(map[string]string)
+	for _, line := range strings.Split(effective, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if ok {
+			value = strings.TrimSpace(value)
+			if alias, ok := sshdAliases[value]; ok {
+				value = alias
+			}
+			settings[strings.ToLower(key)] = value
+		}
+	}
+
+	for _, directive := range sshdHardening {
+		key, value, _ := strings.Cut(directive, " ")
+		if settings[strings.ToLower(key)] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// sshdConfigCommands backs up sshd_config once, comments out the directives it overrides and
+// puts the hardening block at the top of the file, ahead of any Include or Match block.
+func sshdConfigCommands() []string {
+	var keys []string
+	for _, directive := range sshdHardening {
+		key, _, _ := strings.Cut(directive, " ")
+		keys = append(keys, key)
+	}
+
+	block := "# BEGIN ftl\n" + strings.Join(sshdHardening, "\n") + "\n# END ftl\n"
+
+	return []string{
+		fmt.Sprintf("[ -f %[2]s ] || cp %[1]s %[2]s", sshdConfigPath, sshdConfigBackup),
+		fmt.Sprintf("sed -i -E -e '/^# BEGIN ftl$/,/^# END ftl$/d' -e 's/^(%s)[[:space:]]/# &/' %s", strings.Join(keys, "|"), sshdConfigPath),
+		fmt.Sprintf("{ printf '%%s' %s; cat %[2]s; } > %[2]s.ftl && cat %[2]s.ftl > %[2]s && rm -f %[2]s.ftl", shell.Quote(block), sshdConfigPath),
+	}
+}
+
+func restoreSSHDConfig(ctx context.Context, runner *remote.Runner) error {
+	return runner.RunCommands(ctx, []string{fmt.Sprintf("cp %s %s", sshdConfigBackup, sshdConfigPath)})
+}
+
+// fail2banJail returns a basic fail2ban jail protecting sshd on the given port.
+func fail2banJail(port int) string {
+	if port == 0 {
+		port = 22
+	}
+	return fmt.Sprintf(`[sshd]
+enabled = true
+port = %d
+maxretry = 5
+findtime = 10m
+bantime = 1h
+`, port)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/deployment"
+	"github.com/yarlson/ftl/pkg/runner/remote"
+	"github.com/yarlson/ftl/pkg/ssh"
+	"github.com/yarlson/ftl/tests/servercontainer"
+)
+
+func TestSSHDHardened(t *testing.T) {
+	hardened := "port 22\npermitrootlogin prohibit-password\npasswordauthentication no\npubkeyauthentication yes\n"
+	assert.True(t, sshdHardened(hardened))
+
+	// sshd -T prints prohibit-password by its older name.
+	assert.True(t, sshdHardened("permitrootlogin without-password\npasswordauthentication no\n"))
+	assert.False(t, sshdHardened("permitrootlogin yes\npasswordauthentication no\n"))
+	assert.False(t, sshdHardened("permitrootlogin prohibit-password\npasswordauthentication yes\n"))
+	assert.False(t, sshdHardened(""))
+}
+
+func TestSSHDConfigCommands(t *testing.T) {
+	commands := sshdConfigCommands()
+
+	assert.Equal(t, "[ -f /etc/ssh/sshd_config.ftl.bak ] || cp /etc/ssh/sshd_config /etc/ssh/sshd_config.ftl.bak", commands[0])
+	assert.Contains(t, commands[1], "s/^(PasswordAuthentication|PermitRootLogin)[[:space:]]/# &/")
+	assert.Contains(t, commands[2], "# BEGIN ftl\nPasswordAuthentication no\nPermitRootLogin prohibit-password\n# END ftl\n")
+}
+
+func TestFail2banCommands(t *testing.T) {
+	for _, family := range []string{familyDebian, familyFedora, familyAlpine} {
+		d := &distro{Family: family}
+		commands := strings.Join(d.fail2banCommands(2222), "\n")
+		assert.Contains(t, commands, "port = 2222", family)
+		assert.Contains(t, commands, fail2banJailPath, family)
+	}
+}
+
+func TestSetupAfterHardening(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	tc, err := servercontainer.NewContainer(t)
+	require.NoError(t, err)
+	defer func() { _ = tc.Container.Terminate(context.Background()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, ssh.GenerateKey(keyPath))
+	keyData, err := readSSHKey(keyPath)
+	require.NoError(t, err)
+	publicKey, err := parsePublicKey(keyData)
+	require.NoError(t, err)
+
+	// Harden the server the way the harden-ssh step does after the sshkey step authorized the key.
+	client, err := ssh.NewSSHClientWithPassword("127.0.0.1", tc.SshPort.Port(), "root", "testpassword")
+	require.NoError(t, err)
+	runner := remote.NewRunner(client)
+	_, err = inputCommandOutput(ctx, runner, appendAuthorizedKeyCommand("/root/.ssh/authorized_keys", strings.TrimSpace(publicKey)))
+	require.NoError(t, err)
+	require.NoError(t, runner.RunCommands(ctx, sshdConfigCommands()))
+	effective, err := commandOutput(ctx, runner, "sshd -T")
+	require.NoError(t, err)
+	assert.True(t, sshdHardened(effective))
+	// sshd runs as the entrypoint of the container and re-executes itself with the new
+	// configuration on SIGHUP.
+	require.NoError(t, runner.RunCommands(ctx, []string{"kill -HUP 1"}))
+	runner.Close()
+
+	port := tc.SshPort.Int()
+	require.Eventually(t, func() bool {
+		client, _, err := ssh.FindKeyAndConnectWithUser("127.0.0.1", port, "root", keyPath)
+		if err != nil {
+			return false
+		}
+		client.Close()
+		return true
+	}, 30*time.Second, 200*time.Millisecond)
+
+	_, err = ssh.NewSSHClientWithPassword("127.0.0.1", tc.SshPort.Port(), "root", "testpassword")
+	assert.Error(t, err, "password login must be disabled")
+
+	cfg := &config.Config{Server: config.Server{Host: "127.0.0.1", Port: port, User: "root", SSHKey: keyPath}}
+	results, err := Setup(ctx, cfg, Options{Step: "docker-login"}, func(deployment.Event) {})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, StepSkipped, results[0].Status)
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yarlson/ftl/pkg/build"
+	"github.com/yarlson/ftl/pkg/runner/remote"
+)
+
+const (
+	// minFreeDisk is the free disk space below which deployments are warned about, since pulled
+	// and synced images need room for their layers.
+	minFreeDisk = 1 << 30
+	// minAvailableMemory is the available memory below which new containers are likely to be
+	// killed by the kernel.
+	minAvailableMemory = 256 << 20
+	// defaultDockerRoot is the data directory of Docker when docker info can't tell.
+	defaultDockerRoot = "/var/lib/docker"
+)
+
+// Fact is an item of the summary of a server shown by ftl server info.
+type Fact struct {
+	Name  string
+	Value string
+	// Warning is set when the value is likely to get in the way of deployments.
+	Warning string
+	// Err is set when the fact couldn't be gathered.
+	Err error
+}
+
+// factCheck gathers a fact from the output of a shell command run on the server.
+type factCheck struct {
+	name    string
+	command string
+	parse   func(output string) (value, warning string, err error)
+}
+
+// Info gathers the facts about the server that matter before deploying project to it, with
+// its proxy on ports. The facts are gathered in parallel, and one that can't be gathered has
+// its Err set without affecting the others.
+func Info(ctx context.Context, runner *remote.Runner, project string, ports []int) []Fact {
+	checks := []factCheck{
+		{name: "Distribution", command: "cat /etc/os-release", parse: parseDistribution},
+		{name: "Kernel", command: "uname -sr", parse: parseKernel},
+		{name: "Architecture", command: "uname -m", parse: parseArchitecture},
+		{name: "Docker", command: "docker version --format '{{.Server.Version}}' 2>&1", parse: parseDockerValue},
+		{name: "Storage driver", command: "docker info --format '{{.Driver}}' 2>&1", parse: parseStorageDriver},
+		{name: "Disk /", command: "df -Pk / 2>&1", parse: parseDiskFree},
+		dockerDiskCheck,
+		{name: "Memory", command: "cat /proc/meminfo", parse: parseMemInfo},
+		{name: "ftl containers", command: "docker ps --filter label=ftl.config-hash --format '{{.Names}}' 2>&1", parse: containerCounter(project)},
+	}
+	for _, port := range ports {
+		checks = append(checks, portCheck(project, port))
+	}
+	return gatherFacts(ctx, runner, checks)
+}
+
+// Preflight returns the problems of the server that deployments should be warned about, like
+// too little free disk space for the images. Facts that can't be gathered are left out.
+func Preflight(ctx context.Context, runner *remote.Runner) []string {
+	var warnings []string
+	for _, fact := range gatherFacts(ctx, runner, []factCheck{dockerDiskCheck}) {
+		if fact.Err == nil && fact.Warning != "" {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", fact.Name, fact.Warning))
+		}
+	}
+	return warnings
+}
+
+// dockerDiskCheck gathers the free disk space of the data directory of Docker, which holds
+// the images and containers.
+var dockerDiskCheck = factCheck{
+	name: "Disk of Docker data",
+	command: fmt.Sprintf(`dir=$(docker info --format '{{.DockerRootDir}}' 2>/dev/null); dir=${dir:-%s}; echo "$dir"; df -Pk "$dir" 2>&1`,
+		defaultDockerRoot),
+	parse: func(output string) (string, string, error) {
+		dir, df, _ := strings.Cut(output, "\n")
+		value, warning, err := parseDiskFree(df)
+		if err != nil {
+			return "", "", err
+		}
+		return dir + ": " + value, warning, nil
+	},
+}
+
+func gatherFacts(ctx context.Context, runner *remote.Runner, checks []factCheck) []Fact {
+	facts := make([]Fact, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check factCheck) {
+			defer wg.Done()
+			facts[i] = Fact{Name: check.name}
+			output, err := commandOutput(ctx, runner, check.command)
+			if err != nil {
+				facts[i].Err = err
+				return
+			}
+			facts[i].Value, facts[i].Warning, facts[i].Err = check.parse(output)
+		}(i, check)
+	}
+	wg.Wait()
+	return facts
+}
+
+func parseDistribution(output string) (string, string, error) {
+	d, err := parseOSRelease(strings.NewReader(output))
+	if err != nil {
+		return "", "", err
+	}
+	if d.Name == "" {
+		return "", "", errors.New("/etc/os-release doesn't name the distribution")
+	}
+	if d.Family == "" {
+		return d.Name, "not supported by ftl setup", nil
+	}
+	return d.Name, "", nil
+}
+
+func parseKernel(output string) (string, string, error) {
+	if output == "" || strings.Contains(output, "\n") {
+		return "", "", fmt.Errorf("unexpected output of uname: %s", output)
+	}
+	return output, "", nil
+}
+
+// Architecture returns the architecture of the server as Docker names it, like amd64 or arm64.
+func Architecture(ctx context.Context, runner *remote.Runner) (string, error) {
+	output, err := commandOutput(ctx, runner, "uname -m")
+	if err != nil {
+		return "", err
+	}
+	arch, _, err := parseArchitecture(output)
+	return arch, err
+}
+
+// machineArchitectures maps the machine names printed by uname -m to the architectures of
+// Docker images.
+var machineArchitectures = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv7l":  "arm",
+	"armv6l":  "arm",
+	"i686":    "386",
+	"i386":    "386",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+func parseArchitecture(output string) (string, string, error) {
+	arch, ok := machineArchitectures[output]
+	if !ok {
+		return "", "", fmt.Errorf("unknown machine architecture: %s", output)
+	}
+	return arch, "", nil
+}
+
+// parseDockerValue parses the output of a docker command printing a single word, which is an
+// error message when the daemon can't be reached.
+func parseDockerValue(output string) (string, string, error) {
+	if output == "" || strings.ContainsAny(output, " \n") {
+		message := "docker is not installed or not running, run ftl setup"
+		if output != "" {
+			message += ": " + output
+		}
+		return "", "", errors.New(message)
+	}
+	return output, "", nil
+}
+
+func parseStorageDriver(output string) (string, string, error) {
+	driver, _, err := parseDockerValue(output)
+	if err != nil {
+		return "", "", err
+	}
+	if driver == "vfs" {
+		return driver, "vfs copies every image layer in full, use overlay2", nil
+	}
+	return driver, "", nil
+}
+
+// parseDiskFree parses the output of df -Pk for a single file system.
+func parseDiskFree(output string) (string, string, error) {
+	disks, err := parseDisks(output)
+	if err != nil {
+		return "", "", err
+	}
+	if len(disks) != 1 {
+		return "", "", fmt.Errorf("unexpected output of df: %s", output)
+	}
+
+	disk := disks[0]
+	value := fmt.Sprintf("%s free of %s", build.FormatBytes(disk.Available), build.FormatBytes(disk.Size))
+	if disk.Available < minFreeDisk {
+		return value, fmt.Sprintf("less than %s free, images may not fit", build.FormatBytes(minFreeDisk)), nil
+	}
+	return value, "", nil
+}
+
+// parseMemInfo parses /proc/meminfo.
+func parseMemInfo(output string) (string, string, error) {
+	values := make(map[string]int64)
+	for _, line := range strings.Split(output, "\n") {
+		key, rest, ok := strings.Cut(line, ":")
+		fields := strings.Fields(rest)
+		if !ok || len(fields) == 0 {
+			continue
+		}
+		if kb, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			values[key] = kb * 1024
+		}
+	}
+
+	total, ok := values["MemTotal"]
+	available, hasAvailable := values["MemAvailable"]
+	if !ok || !hasAvailable {
+		return "", "", errors.New("/proc/meminfo lacks MemTotal or MemAvailable")
+	}
+
+	value := fmt.Sprintf("%s available of %s", build.FormatBytes(available), build.FormatBytes(total))
+	if available < minAvailableMemory {
+		return value, fmt.Sprintf("less than %s available, new containers may be killed", build.FormatBytes(minAvailableMemory)), nil
+	}
+	return value, "", nil
+}
+
+// containerCounter returns the parser of the names of the running containers deployed by ftl,
+// counting those of project.
+func containerCounter(project string) func(string) (string, string, error) {
+	return func(output string) (string, string, error) {
+		var names []string
+		if output != "" {
+			names = strings.Split(output, "\n")
+		}
+		ofProject := 0
+		for _, name := range names {
+			if strings.Contains(name, " ") {
+				return parseDockerValue(output)
+			}
+			if strings.HasPrefix(name, project+"-") {
+				ofProject++
+			}
+		}
+		return fmt.Sprintf("%d running, %d of %s", len(names), ofProject, project), "", nil
+	}
+}
+
+// portUnknown is printed by the command of a port check on servers without ss.
+const portUnknown = "unknown"
+
+// portCheck gathers whether a port of the proxy is free, used by the proxy of project or in
+// use by another process or container.
+func portCheck(project string, port int) factCheck {
+	return factCheck{
+		name: fmt.Sprintf("Port %d", port),
+		command: fmt.Sprintf(`if command -v ss >/dev/null; then ss -ltn 'sport = :%[1]d' | tail -n +2 | grep -c .; else echo %[2]s; fi; docker ps --filter publish=%[1]d --format '{{.Names}}' 2>/dev/null`,
+			port, portUnknown),
+		parse: func(output string) (string, string, error) {
+			return parsePortUse(project, output)
+		},
+	}
+}
+
+// parsePortUse parses the output of a port check: the number of listening sockets, or
+// portUnknown, followed by the names of the containers publishing the port.
+func parsePortUse(project, output string) (string, string, error) {
+	lines := strings.Split(output, "\n")
+	listeners := strings.TrimSpace(lines[0])
+	containers := lines[1:]
+
+	proxy := project + "-proxy"
+	for _, container := range containers {
+		if container == proxy {
+			return "used by the proxy of " + project, "", nil
+		}
+	}
+	if len(containers) > 0 {
+		return "published by " + strings.Join(containers, ", "), "the proxy of " + project + " can't bind it", nil
+	}
+
+	switch listeners {
+	case "0":
+		return "free", "", nil
+	case portUnknown:
+		return "unknown, ss is not installed", "", nil
+	}
+	if _, err := strconv.Atoi(listeners); err != nil {
+		return "", "", fmt.Errorf("unexpected output of ss: %s", output)
+	}
+	return "in use by another process", "the proxy of " + project + " can't bind it", nil
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDiskFree(t *testing.T) {
+	value, warning, err := parseDiskFree(`Filesystem     1024-blocks    Used Available Capacity Mounted on
+/dev/sda1         41152736 9876544  29163324      26% /`)
+	require.NoError(t, err)
+	assert.Equal(t, "27.8 GiB free of 39.2 GiB", value)
+	assert.Empty(t, warning)
+
+	_, warning, err = parseDiskFree(`Filesystem     1024-blocks    Used Available Capacity Mounted on
+my disk           41152736 40452736   700000      99% /var/lib/docker`)
+	require.NoError(t, err)
+	assert.Equal(t, "less than 1.0 GiB free, images may not fit", warning)
+
+	_, _, err = parseDiskFree("df: /var/lib/docker: No such file or directory")
+	assert.Error(t, err)
+}
+
+func TestParseMemInfo(t *testing.T) {
+	value, warning, err := parseMemInfo(`MemTotal:        2014464 kB
+MemFree:          120000 kB
+MemAvailable:    1048576 kB`)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0 GiB available of 1.9 GiB", value)
+	assert.Empty(t, warning)
+
+	_, warning, err = parseMemInfo("MemTotal: 524288 kB\nMemAvailable: 102400 kB")
+	require.NoError(t, err)
+	assert.Contains(t, warning, "new containers may be killed")
+
+	_, _, err = parseMemInfo("MemTotal: 524288 kB")
+	assert.Error(t, err)
+}
+
+func TestParseArchitecture(t *testing.T) {
+	arch, _, err := parseArchitecture("aarch64")
+	require.NoError(t, err)
+	assert.Equal(t, "arm64", arch)
+
+	arch, _, err = parseArchitecture("x86_64")
+	require.NoError(t, err)
+	assert.Equal(t, "amd64", arch)
+
+	_, _, err = parseArchitecture("sh: uname: not found")
+	assert.Error(t, err)
+}
+
+func TestParseDockerValues(t *testing.T) {
+	value, warning, err := parseStorageDriver("overlay2")
+	require.NoError(t, err)
+	assert.Equal(t, "overlay2", value)
+	assert.Empty(t, warning)
+
+	_, warning, err = parseStorageDriver("vfs")
+	require.NoError(t, err)
+	assert.NotEmpty(t, warning)
+
+	_, _, err = parseDockerValue("Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?")
+	assert.ErrorContains(t, err, "not installed or not running")
+}
+
+func TestContainerCounter(t *testing.T) {
+	count := containerCounter("shop")
+
+	value, _, err := count("shop-web\nshop-proxy\nblog-web")
+	require.NoError(t, err)
+	assert.Equal(t, "3 running, 2 of shop", value)
+
+	value, _, err = count("")
+	require.NoError(t, err)
+	assert.Equal(t, "0 running, 0 of shop", value)
+
+	_, _, err = count("permission denied while trying to connect to the Docker daemon socket")
+	assert.Error(t, err)
+}
+
+func TestParsePortUse(t *testing.T) {
+	tests := []struct {
+		output  string
+		value   string
+		warning bool
+	}{
+		{output: "0", value: "free"},
+		{output: "1\nshop-proxy", value: "used by the proxy of shop"},
+		{output: "2\nblog-proxy", value: "published by blog-proxy", warning: true},
+		{output: "1", value: "in use by another process", warning: true},
+		{output: "unknown", value: "unknown, ss is not installed"},
+	}
+
+	for _, tt := range tests {
+		value, warning, err := parsePortUse("shop", tt.output)
+		require.NoError(t, err, tt.output)
+		assert.Equal(t, tt.value, value, tt.output)
+		assert.Equal(t, tt.warning, warning != "", tt.output)
+	}
+
+	_, _, err := parsePortUse("shop", "sh: ss: Permission denied")
+	assert.Error(t, err)
+}
@@ -2,7 +2,9 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,8 +12,9 @@ import (
 	gossh "golang.org/x/crypto/ssh"
 
 	"github.com/yarlson/ftl/pkg/config"
-	"github.com/yarlson/ftl/pkg/console"
+	"github.com/yarlson/ftl/pkg/deployment"
 	"github.com/yarlson/ftl/pkg/runner/remote"
+	"github.com/yarlson/ftl/pkg/shell"
 	"github.com/yarlson/ftl/pkg/ssh"
 )
 
@@ -21,152 +24,385 @@ type DockerCredentials struct {
 	Password string
 }
 
-// Setup performs the server setup
-func Setup(ctx context.Context, cfg *config.Config, dockerCreds DockerCredentials, newUserPassword string, sm *console.SpinnerManager) error {
-	spinner := sm.AddSpinner("setup", fmt.Sprintf("[%s] Setting up server", cfg.Server.Host))
-	if err := setupServer(ctx, cfg.Server, dockerCreds, newUserPassword, sm); err != nil {
-		spinner.ErrorWithMessagef("Setup failed: %v", err)
-		return fmt.Errorf("[%s] Setup failed: %w", cfg.Server.Host, err)
+// Options controls what Setup does.
+type Options struct {
+	DockerCredentials DockerCredentials
+	NewUserPassword   string
+	// FirewallAllow lists extra "port/protocol" rules to open in addition to server.firewall_allow.
+	FirewallAllow []string
+	// HardenSSH enables the harden-ssh step in addition to server.harden_ssh.
+	HardenSSH bool
+	// Step limits setup to the named step. All steps run when it is empty.
+	Step string
+}
+
+// Step statuses reported by Setup.
+const (
+	StepApplied = "applied"
+	StepSkipped = "skipped"
+)
+
+// StepResult reports whether a setup step changed the server.
+type StepResult struct {
+	Name   string
+	Status string
+	Reason string
+	// Changes describes what an applied step changed, when the step records it.
+	Changes []string
+}
+
+// setupState is shared by the setup steps.
+type setupState struct {
+	runner       *remote.Runner
+	distro       *distro
+	server       config.Server
+	dependencies []config.Dependency
+	opts         Options
+	changes      []string
+	// passwordLogin is set when setup logged in as root with the server password.
+	passwordLogin bool
+}
+
+// record notes a change made by the current step.
+func (s *setupState) record(format string, args ...interface{}) {
+	s.changes = append(s.changes, fmt.Sprintf(format, args...))
+}
+
+// step is a single idempotent unit of server setup. run reports a non-empty reason when
+// the server was already in the desired state and nothing was changed.
+type step struct {
+	name  string
+	title string
+	run   func(ctx context.Context, s *setupState) (skipReason string, err error)
+}
+
+var steps = []step{
+	{name: "software", title: "Installing software", run: installSoftware},
+	{name: "system", title: "Configuring swap and kernel settings", run: configureSystem},
+	{name: "gpu", title: "Installing GPU support", run: installGPUSupport},
+	{name: "firewall", title: "Configuring firewall", run: configureFirewall},
+	{name: "user", title: "Creating user", run: createUser},
+	{name: "sshkey", title: "Setting up SSH key", run: setupSSHKey},
+	{name: "docker-login", title: "Logging into Docker Hub", run: dockerLogin},
+	// harden-ssh runs last since it disables the password login setup may have used.
+	{name: "harden-ssh", title: "Hardening SSH", run: hardenSSH},
+}
+
+// StepNames returns the names of the setup steps in the order they run.
+func StepNames() []string {
+	names := make([]string, len(steps))
+	for i, st := range steps {
+		names[i] = st.name
 	}
-	spinner.Complete()
-	return nil
+	return names
 }
 
-func setupServer(ctx context.Context, cfg config.Server, dockerCreds DockerCredentials, newUserPassword string, sm *console.SpinnerManager) error {
-	spinner := sm.AddSpinner("connecting", fmt.Sprintf("[%s] Connecting to server", cfg.Host))
+// ValidateStep checks that name refers to a setup step.
+func ValidateStep(name string) error {
+	for _, st := range steps {
+		if st.name == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown setup step %q; valid steps are: %s", name, strings.Join(StepNames(), ", "))
+}
 
-	sshClient, rootKey, err := ssh.FindKeyAndConnectWithUser(cfg.Host, cfg.Port, "root", cfg.SSHKey)
+// Setup performs the server setup and reports the progress of its steps to report. Steps that
+// find the server already configured are skipped, so setup can be re-run safely after a
+// partial failure.
+func Setup(ctx context.Context, cfg *config.Config, opts Options, report deployment.Reporter) ([]StepResult, error) {
+	if opts.Step != "" {
+		if err := ValidateStep(opts.Step); err != nil {
+			return nil, err
+		}
+	}
+
+	progress := deployment.StartLocalStep(report, "setup", "Setting up server")
+	results, err := setupServer(ctx, cfg.Server, cfg.Dependencies, opts, report)
 	if err != nil {
-		spinner.ErrorWithMessagef("Failed to connect via SSH: %v", err)
-		return fmt.Errorf("failed to connect via SSH: %w", err)
+		progress.Fail("Setup failed", err)
+		return results, fmt.Errorf("[%s] Setup failed: %w", cfg.Server.Host, err)
+	}
+	progress.Complete()
+	return results, nil
+}
+
+func setupServer(ctx context.Context, cfg config.Server, dependencies []config.Dependency, opts Options, report deployment.Reporter) ([]StepResult, error) {
+	// A server that only accepts its password yet is set up to accept a new key instead.
+	if cfg.Passwd != "" {
+		progress := deployment.StartLocalStep(report, "keygen", fmt.Sprintf("Checking SSH key %s", cfg.SSHKey))
+		generated, err := generateMissingKey(cfg.SSHKey)
+		if err != nil {
+			progress.Fail("Failed to generate SSH key", err)
+			return nil, fmt.Errorf("failed to generate SSH key: %w", err)
+		}
+		if generated {
+			progress.CompleteWithMessage(fmt.Sprintf("Generated SSH key %s", cfg.SSHKey))
+		} else {
+			progress.Complete()
+		}
 	}
-	defer sshClient.Close()
 
-	spinner.Complete()
+	progress := deployment.StartLocalStep(report, "connecting", "Connecting to server")
+
+	sshClient, rootKey, err := ssh.ConnectWithKeyOrPassword(cfg.Host, cfg.Port, "root", cfg.SSHKey, cfg.Passwd)
+	if err != nil {
+		progress.Fail("Failed to connect via SSH", err)
+		return nil, fmt.Errorf("failed to connect via SSH: %w", err)
+	}
+
+	progress.Complete()
 
 	runner := remote.NewRunner(sshClient)
+	runner.SetMaxSessions(cfg.MaxSessions)
+	runner.SetReconnect(func() (*gossh.Client, error) {
+		client, _, err := ssh.ConnectWithKeyOrPassword(cfg.Host, cfg.Port, "root", cfg.SSHKey, cfg.Passwd)
+		return client, err
+	})
+	runner.SetKeepAlive(cfg.KeepAliveInterval.Duration(), cfg.KeepAliveCountMax)
+	defer runner.Close()
 	cfg.RootSSHKey = string(rootKey)
 
-	spinner = sm.AddSpinner("software", fmt.Sprintf("[%s] Installing software", cfg.Host))
-	if err := installSoftware(ctx, runner); err != nil {
-		spinner.ErrorWithMessagef("Failed to install software: %v", err)
-		return fmt.Errorf("installing software: %w", err)
+	progress = deployment.StartLocalStep(report, "distro", "Detecting distribution")
+	d, err := detectDistro(ctx, runner)
+	if err != nil {
+		progress.Fail("Failed to detect distribution", err)
+		return nil, fmt.Errorf("detecting distribution: %w", err)
 	}
-	spinner.Complete()
+	progress.CompleteWithMessage(fmt.Sprintf("Detected %s", d.Name))
+
+	state := &setupState{runner: runner, distro: d, server: cfg, dependencies: dependencies, opts: opts, passwordLogin: rootKey == nil}
+
+	var results []StepResult
+	for _, st := range steps {
+		if opts.Step != "" && opts.Step != st.name {
+			continue
+		}
+
+		progress = deployment.StartLocalStep(report, st.name, st.title)
+		state.changes = nil
+		reason, err := st.run(ctx, state)
+		if err != nil {
+			progress.Fail(fmt.Sprintf("%s failed", st.title), err)
+			return results, fmt.Errorf("%s: %w", st.name, err)
+		}
+
+		if reason != "" {
+			progress.CompleteWithMessage(fmt.Sprintf("%s: skipped, %s", st.title, reason))
+			results = append(results, StepResult{Name: st.name, Status: StepSkipped, Reason: reason})
+			continue
+		}
 
-	spinner = sm.AddSpinner("firewall", fmt.Sprintf("[%s] Configuring firewall", cfg.Host))
-	if err := configureFirewall(ctx, runner); err != nil {
-		spinner.ErrorWithMessagef("Failed to configure firewall: %v", err)
-		return fmt.Errorf("configuring firewall: %w", err)
+		progress.Complete()
+		results = append(results, StepResult{Name: st.name, Status: StepApplied, Changes: state.changes})
 	}
-	spinner.Complete()
 
-	spinner = sm.AddSpinner("user", fmt.Sprintf("[%s] Creating user %s", cfg.Host, cfg.User))
-	if err := createUser(ctx, runner, cfg.User, newUserPassword); err != nil {
-		spinner.ErrorWithMessagef("Failed to create user: %v", err)
-		return fmt.Errorf("creating user: %w", err)
+	return results, nil
+}
+
+// commandOutput runs a shell command and returns its trimmed combined output.
+func commandOutput(ctx context.Context, runner *remote.Runner, command string) (string, error) {
+	return inputCommandOutput(ctx, runner, inputCommand{command: command})
+}
+
+// inputCommand is a shell command that receives sensitive data on stdin, keeping it out of
+// the remote process list and shell history.
+type inputCommand struct {
+	command string
+	input   string
+}
+
+// inputCommandOutput runs c with its input on stdin and returns its trimmed combined output.
+func inputCommandOutput(ctx context.Context, runner *remote.Runner, c inputCommand) (string, error) {
+	var stdin io.Reader
+	if c.input != "" {
+		stdin = strings.NewReader(c.input)
 	}
-	spinner.Complete()
 
-	spinner = sm.AddSpinner("sshkey", fmt.Sprintf("[%s] Setting up SSH key", cfg.Host))
-	if err := setupSSHKey(ctx, runner, cfg); err != nil {
-		spinner.ErrorWithMessagef("Failed to setup SSH key: %v", err)
-		return fmt.Errorf("setting up SSH key: %w", err)
+	output, err := runner.RunCommandWithInput(ctx, stdin, c.command)
+	if err != nil {
+		return "", err
 	}
-	spinner.Complete()
+	defer output.Close()
 
-	if dockerCreds.Username != "" && dockerCreds.Password != "" {
-		spinner = sm.AddSpinner("docker", fmt.Sprintf("[%s] Logging into Docker Hub", cfg.Host))
-		if err := dockerLogin(ctx, runner, dockerCreds); err != nil {
-			spinner.ErrorWithMessagef("Failed to login to Docker: %v", err)
-			return fmt.Errorf("docker login: %w", err)
-		}
-		spinner.Complete()
+	data, err := io.ReadAll(output)
+	if err != nil {
+		return "", fmt.Errorf("reading output of %q: %w", c.command, err)
 	}
+	return strings.TrimSpace(string(data)), nil
+}
 
-	return nil
+func chpasswdCommand(user, password string) inputCommand {
+	return inputCommand{command: "chpasswd", input: fmt.Sprintf("%s:%s\n", user, password)}
 }
 
-func installSoftware(ctx context.Context, runner *remote.Runner) error {
-	commands := []string{
-		"apt-get update",
-		"apt-get install -y apt-transport-https ca-certificates curl wget git software-properties-common",
-		"curl -fsSL https://download.docker.com/linux/ubuntu/gpg | apt-key add -",
-		`add-apt-repository "deb [arch=amd64] https://download.docker.com/linux/ubuntu $(lsb_release -cs) stable" -y`,
-		"apt-get update",
-		"apt-get install -y docker-ce docker-ce-cli containerd.io docker-compose-plugin",
+func dockerLoginCommand(creds DockerCredentials) inputCommand {
+	return inputCommand{
+		command: fmt.Sprintf("docker login -u %s --password-stdin", shell.Quote(creds.Username)),
+		input:   creds.Password,
 	}
-	return runner.RunCommands(ctx, commands)
 }
 
-func configureFirewall(ctx context.Context, runner *remote.Runner) error {
-	commands := []string{
-		"apt-get install -y ufw",
-		"ufw default deny incoming",
-		"ufw default allow outgoing",
-		"ufw allow 22/tcp",
-		"ufw allow 80/tcp",
-		"ufw allow 443/tcp",
-		`echo "y" | ufw enable`,
+func authorizedKeyCheckCommand(authKeysFile, publicKey string) inputCommand {
+	return inputCommand{
+		command: fmt.Sprintf("grep -qxFf - %s 2>/dev/null && echo present || true", authKeysFile),
+		input:   publicKey + "\n",
 	}
-	return runner.RunCommands(ctx, commands)
 }
 
-func createUser(ctx context.Context, runner *remote.Runner, user, password string) error {
-	checkUserCmd := fmt.Sprintf("id -u %s", user)
-	if _, err := runner.RunCommand(ctx, checkUserCmd); err == nil {
-		// User already exists
-		return nil
+func appendAuthorizedKeyCommand(authKeysFile, publicKey string) inputCommand {
+	return inputCommand{
+		command: fmt.Sprintf("tee -a %s > /dev/null", authKeysFile),
+		input:   publicKey + "\n",
 	}
+}
 
-	commands := []string{
-		fmt.Sprintf("adduser --gecos '' --disabled-password %s", user),
-		fmt.Sprintf("echo '%s:%s' | chpasswd", user, password),
-		fmt.Sprintf("usermod -aG docker %s", user),
+func installSoftware(ctx context.Context, s *setupState) (string, error) {
+	docker, err := commandOutput(ctx, s.runner, "command -v docker || true")
+	if err != nil {
+		return "", err
 	}
-	return runner.RunCommands(ctx, commands)
+	if docker != "" {
+		return "Docker is already installed", nil
+	}
+
+	return "", s.runner.RunCommands(ctx, s.distro.installCommands())
 }
 
-func setupSSHKey(ctx context.Context, runner *remote.Runner, server config.Server) error {
-	keyData, err := readSSHKey(server.SSHKey)
+func configureFirewall(ctx context.Context, s *setupState) (string, error) {
+	rules, err := firewallRules(s.server, s.opts.FirewallAllow)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	configured, err := s.distro.firewallConfigured(ctx, s.runner, rules)
+	if err != nil {
+		return "", err
+	}
+	if configured {
+		return "firewall rules are already in place", nil
+	}
+
+	return "", s.runner.RunCommands(ctx, s.distro.firewallCommands(rules))
+}
+
+func createUser(ctx context.Context, s *setupState) (string, error) {
+	user := s.server.User
+
+	uid, err := commandOutput(ctx, s.runner, fmt.Sprintf("id -u %s 2>/dev/null || true", shell.Quote(user)))
+	if err != nil {
+		return "", err
+	}
+
+	if uid == "" {
+		if err := s.runner.RunCommands(ctx, []string{s.distro.addUserCommand(user)}); err != nil {
+			return "", err
+		}
+		if _, err := inputCommandOutput(ctx, s.runner, chpasswdCommand(user, s.opts.NewUserPassword)); err != nil {
+			return "", err
+		}
+		return "", s.runner.RunCommands(ctx, []string{s.distro.addToDockerGroupCommand(user)})
+	}
+
+	// The user exists; keep its password and only make sure it can run docker.
+	groups, err := commandOutput(ctx, s.runner, fmt.Sprintf("id -nG %s", shell.Quote(user)))
+	if err != nil {
+		return "", err
+	}
+	for _, group := range strings.Fields(groups) {
+		if group == "docker" {
+			return fmt.Sprintf("user %s already exists", user), nil
+		}
+	}
+
+	return "", s.runner.RunCommands(ctx, []string{s.distro.addToDockerGroupCommand(user)})
+}
+
+func setupSSHKey(ctx context.Context, s *setupState) (string, error) {
+	keyData, err := readSSHKey(s.server.SSHKey)
+	if err != nil {
+		return "", err
 	}
 
 	publicKey, err := parsePublicKey(keyData)
 	if err != nil {
-		return err
+		return "", err
 	}
+	publicKey = strings.TrimSpace(publicKey)
 
-	user := server.User
-	sshDir := fmt.Sprintf("/home/%s/.ssh", user)
+	user := s.server.User
+	if err := authorizeKey(ctx, s, user, fmt.Sprintf("/home/%s/.ssh", user), publicKey); err != nil {
+		return "", err
+	}
+	// Setup logged in as root with the password, so the next run logs in with the key instead.
+	if s.passwordLogin {
+		if err := authorizeKey(ctx, s, "root", "/root/.ssh", publicKey); err != nil {
+			return "", err
+		}
+	}
+
+	if len(s.changes) == 0 {
+		return "key is already authorized", nil
+	}
+	return "", nil
+}
+
+// authorizeKey adds publicKey to the authorized keys of user in sshDir unless it is there already.
+func authorizeKey(ctx context.Context, s *setupState, user, sshDir, publicKey string) error {
 	authKeysFile := filepath.Join(sshDir, "authorized_keys")
 
+	present, err := inputCommandOutput(ctx, s.runner, authorizedKeyCheckCommand(authKeysFile, publicKey))
+	if err != nil {
+		return err
+	}
+	if present == "present" {
+		return nil
+	}
+
+	if err := s.runner.RunCommands(ctx, []string{fmt.Sprintf("mkdir -p %s", sshDir)}); err != nil {
+		return err
+	}
+	if _, err := inputCommandOutput(ctx, s.runner, appendAuthorizedKeyCommand(authKeysFile, publicKey)); err != nil {
+		return err
+	}
+
 	commands := []string{
-		fmt.Sprintf("mkdir -p %s", sshDir),
-		fmt.Sprintf("echo '%s' | tee -a %s", publicKey, authKeysFile),
 		fmt.Sprintf("chown -R %s:%s %s", user, user, sshDir),
 		fmt.Sprintf("chmod 700 %s", sshDir),
 		fmt.Sprintf("chmod 600 %s", authKeysFile),
 	}
-	return runner.RunCommands(ctx, commands)
+	if err := s.runner.RunCommands(ctx, commands); err != nil {
+		return err
+	}
+	s.record("authorized the key for %s", user)
+	return nil
 }
 
-func dockerLogin(ctx context.Context, runner *remote.Runner, creds DockerCredentials) error {
-	command := fmt.Sprintf("echo '%s' | docker login -u %s --password-stdin", creds.Password, creds.Username)
-	_, err := runner.RunCommand(ctx, command)
-	return err
+func dockerLogin(ctx context.Context, s *setupState) (string, error) {
+	creds := s.opts.DockerCredentials
+	if creds.Username == "" || creds.Password == "" {
+		return "no Docker Hub credentials needed", nil
+	}
+
+	_, err := inputCommandOutput(ctx, s.runner, dockerLoginCommand(creds))
+	return "", err
 }
 
 func readSSHKey(keyPath string) ([]byte, error) {
-	if strings.HasPrefix(keyPath, "~") {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get home directory: %w", err)
-		}
-		keyPath = filepath.Join(homeDir, keyPath[1:])
+	return ssh.FindSSHKey(keyPath)
+}
+
+// generateMissingKey generates the SSH key pair of the server when the key file doesn't exist
+// yet and reports whether it did.
+func generateMissingKey(keyPath string) (bool, error) {
+	keyPath, err := ssh.KeyPath(keyPath)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(keyPath); !errors.Is(err, os.ErrNotExist) {
+		return false, err
 	}
-	return os.ReadFile(keyPath)
+	return true, ssh.GenerateKey(keyPath)
 }
 
 func parsePublicKey(keyData []byte) (string, error) {
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateStep(t *testing.T) {
+	for _, name := range StepNames() {
+		assert.NoError(t, ValidateStep(name))
+	}
+
+	err := ValidateStep("docker")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "software, system, gpu, firewall, user, sshkey, docker-login, harden-ssh")
+}
+
+func TestSecretsStayOutOfCommandLines(t *testing.T) {
+	const password = "s3cr3t-'pa$$word"
+	const publicKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIDummyKey user@host"
+
+	commands := []inputCommand{
+		chpasswdCommand("deploy", password),
+		dockerLoginCommand(DockerCredentials{Username: "deployer", Password: password}),
+		authorizedKeyCheckCommand("/home/deploy/.ssh/authorized_keys", publicKey),
+		appendAuthorizedKeyCommand("/home/deploy/.ssh/authorized_keys", publicKey),
+	}
+
+	for _, c := range commands {
+		assert.NotContains(t, c.command, password)
+		assert.NotContains(t, c.command, "s3cr3t")
+		assert.NotContains(t, c.command, "AAAAC3NzaC1lZDI1NTE5")
+	}
+
+	assert.Equal(t, "deploy:"+password+"\n", commands[0].input)
+	assert.Equal(t, password, commands[1].input)
+	assert.Equal(t, "docker login -u 'deployer' --password-stdin", commands[1].command)
+	assert.Equal(t, publicKey+"\n", commands[3].input)
+}
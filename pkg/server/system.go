@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+const (
+	swapfilePath = "/swapfile"
+	sysctlPath   = "/etc/sysctl.d/99-ftl.conf"
+
+	// swapSwappiness keeps swap as a last resort on servers setup adds a swapfile to.
+	swapSwappiness = 10
+	// searchMaxMapCount is the minimum vm.max_map_count Elasticsearch and OpenSearch start with.
+	searchMaxMapCount = 262144
+)
+
+// sysctl is a kernel setting applied by the system step. With minimum set, larger current
+// values are left alone.
+type sysctl struct {
+	Key     string
+	Value   int
+	Minimum bool
+}
+
+func configureSystem(ctx context.Context, s *setupState) (string, error) {
+	settings := systemSettings(s.server.Swap, s.dependencies)
+	if s.server.Swap == 0 && len(settings) == 0 {
+		return "no swap configured and no dependency needs kernel settings", nil
+	}
+
+	if s.server.Swap > 0 {
+		if err := ensureSwap(ctx, s); err != nil {
+			return "", err
+		}
+	}
+
+	if err := ensureSysctls(ctx, s, settings); err != nil {
+		return "", err
+	}
+
+	if len(s.changes) == 0 {
+		return "swap and kernel settings are already in place", nil
+	}
+	return "", nil
+}
+
+// systemSettings returns the kernel settings the server needs for the configured swap and dependencies.
+func systemSettings(swap config.Size, dependencies []config.Dependency) []sysctl {
+	var settings []sysctl
+	if swap > 0 {
+		settings = append(settings, sysctl{Key: "vm.swappiness", Value: swapSwappiness})
+	}
+	for _, dep := range dependencies {
+		if isSearchEngine(dep) {
+			settings = append(settings, sysctl{Key: "vm.max_map_count", Value: searchMaxMapCount, Minimum: true})
+			break
+		}
+	}
+	return settings
+}
+
+// isSearchEngine reports whether the dependency runs Elasticsearch or OpenSearch, which
+// refuse to start with the default vm.max_map_count.
+func isSearchEngine(dep config.Dependency) bool {
+	image := dep.Image
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	for _, name := range []string{dep.Name, path.Base(image)} {
+		if name == "elasticsearch" || name == "opensearch" {
+			return true
+		}
+	}
+	return false
+}
+
+func ensureSwap(ctx context.Context, s *setupState) error {
+	swaps, err := commandOutput(ctx, s.runner, "cat /proc/swaps")
+	if err != nil {
+		return err
+	}
+
+	total, swapfileActive := parseSwaps(swaps)
+	if total >= s.server.Swap.Bytes() {
+		return nil
+	}
+
+	if err := s.runner.RunCommands(ctx, s.distro.swapfileCommands(s.server.Swap, swapfileActive)); err != nil {
+		return err
+	}
+
+	swaps, err = commandOutput(ctx, s.runner, "cat /proc/swaps")
+	if err != nil {
+		return err
+	}
+	if _, active := parseSwaps(swaps); !active {
+		return fmt.Errorf("swapfile %s was not activated", swapfilePath)
+	}
+
+	s.record("created %s swapfile at %s", s.server.Swap, swapfilePath)
+	return nil
+}
+
+// parseSwaps returns the total swap in bytes and whether the setup swapfile is active
+// from the contents of /proc/swaps.
+func parseSwaps(swaps string) (int64, bool) {
+	var total int64
+	active := false
+
+	for _, line := range strings.Split(swaps, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] == "Filename" {
+			continue
+		}
+		kib, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		total += kib * 1024
+		if fields[0] == swapfilePath {
+			active = true
+		}
+	}
+
+	return total, active
+}
+
+// swapfileCommands returns the commands that (re)create and enable the swapfile persistently.
+func (d *distro) swapfileCommands(size config.Size, replace bool) []string {
+	var commands []string
+	if replace {
+		commands = append(commands, fmt.Sprintf("swapoff %s", swapfilePath))
+	}
+
+	commands = append(commands,
+		fmt.Sprintf("rm -f %s", swapfilePath),
+		fmt.Sprintf("fallocate -l %d %[2]s 2>/dev/null || dd if=/dev/zero of=%[2]s bs=1M count=%d", size.Bytes(), swapfilePath, (size.Bytes()+(1<<20)-1)>>20),
+		fmt.Sprintf("chmod 600 %s", swapfilePath),
+		fmt.Sprintf("mkswap %s", swapfilePath),
+		fmt.Sprintf("swapon %s", swapfilePath),
+		fmt.Sprintf("grep -q '^%[1]s ' /etc/fstab || echo '%[1]s none swap sw 0 0' >> /etc/fstab", swapfilePath),
+	)
+	if d.Family == familyAlpine {
+		commands = append(commands, "rc-update add swap boot")
+	}
+	return commands
+}
+
+func ensureSysctls(ctx context.Context, s *setupState, settings []sysctl) error {
+	for _, setting := range settings {
+		output, err := commandOutput(ctx, s.runner, fmt.Sprintf("sysctl -n %s 2>/dev/null || true", setting.Key))
+		if err != nil {
+			return err
+		}
+
+		current, err := strconv.Atoi(output)
+		if err == nil && (current == setting.Value || (setting.Minimum && current > setting.Value)) {
+			continue
+		}
+
+		if err := s.runner.RunCommands(ctx, sysctlCommands(setting)); err != nil {
+			return err
+		}
+
+		if output == "" {
+			output = "unset"
+		}
+		s.record("%s %s -> %d", setting.Key, output, setting.Value)
+	}
+	return nil
+}
+
+// sysctlCommands applies a setting and persists it in the setup sysctl file.
+func sysctlCommands(setting sysctl) []string {
+	return []string{
+		fmt.Sprintf("sysctl -w %s=%d", setting.Key, setting.Value),
+		fmt.Sprintf("touch %[1]s && sed -i '/^%[2]s[[:space:]]*=/d' %[1]s && echo '%[2]s = %[3]d' >> %[1]s", sysctlPath, setting.Key, setting.Value),
+	}
+}
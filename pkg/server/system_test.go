@@ -0,0 +1,75 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestParseSwaps(t *testing.T) {
+	swaps := `Filename				Type		Size		Used		Priority
+/dev/vda2                               partition	1048572		0		-2
+/swapfile                               file		1048572		0		-3
+`
+	total, active := parseSwaps(swaps)
+	assert.Equal(t, int64(2*1048572*1024), total)
+	assert.True(t, active)
+
+	total, active = parseSwaps("Filename\t\t\t\tType\t\tSize\t\tUsed\t\tPriority\n")
+	assert.Zero(t, total)
+	assert.False(t, active)
+}
+
+func TestSystemSettings(t *testing.T) {
+	assert.Empty(t, systemSettings(0, []config.Dependency{{Name: "postgres", Image: "postgres:16"}}))
+
+	settings := systemSettings(config.Size(2<<30), []config.Dependency{
+		{Name: "search", Image: "docker.elastic.co/elasticsearch/elasticsearch:8.13.0"},
+	})
+	assert.Equal(t, []sysctl{
+		{Key: "vm.swappiness", Value: 10},
+		{Key: "vm.max_map_count", Value: 262144, Minimum: true},
+	}, settings)
+
+	assert.Len(t, systemSettings(0, []config.Dependency{{Name: "elasticsearch", Image: "elasticsearch:latest"}}), 1)
+	assert.Len(t, systemSettings(0, []config.Dependency{{Name: "search", Image: "localhost:5000/opensearch"}}), 1)
+}
+
+func TestSwapfileCommands(t *testing.T) {
+	debian := &distro{Family: familyDebian}
+	commands := strings.Join(debian.swapfileCommands(config.Size(2<<30), false), "\n")
+	assert.Contains(t, commands, "fallocate -l 2147483648 /swapfile 2>/dev/null || dd if=/dev/zero of=/swapfile bs=1M count=2048")
+	assert.Contains(t, commands, "swapon /swapfile")
+	assert.NotContains(t, commands, "swapoff")
+
+	alpine := &distro{Family: familyAlpine}
+	commands = strings.Join(alpine.swapfileCommands(config.Size(1<<30), true), "\n")
+	assert.True(t, strings.HasPrefix(commands, "swapoff /swapfile"))
+	assert.Contains(t, commands, "rc-update add swap boot")
+}
+
+func TestSysctlCommands(t *testing.T) {
+	assert.Equal(t, []string{
+		"sysctl -w vm.max_map_count=262144",
+		"touch /etc/sysctl.d/99-ftl.conf && sed -i '/^vm.max_map_count[[:space:]]*=/d' /etc/sysctl.d/99-ftl.conf && echo 'vm.max_map_count = 262144' >> /etc/sysctl.d/99-ftl.conf",
+	}, sysctlCommands(sysctl{Key: "vm.max_map_count", Value: 262144}))
+}
+
+func TestNvidiaToolkitCommands(t *testing.T) {
+	debian := &distro{Family: familyDebian}
+	commands, err := debian.nvidiaToolkitCommands()
+	assert.NoError(t, err)
+	assert.Contains(t, commands, "apt-get install -y nvidia-container-toolkit")
+	assert.Equal(t, "nvidia-ctk runtime configure --runtime=docker", commands[len(commands)-2])
+
+	fedora := &distro{Family: familyFedora}
+	commands, err = fedora.nvidiaToolkitCommands()
+	assert.NoError(t, err)
+	assert.Contains(t, commands, "dnf install -y nvidia-container-toolkit")
+
+	_, err = (&distro{Family: familyAlpine, Name: "Alpine Linux v3.19"}).nvidiaToolkitCommands()
+	assert.Error(t, err)
+}
@@ -0,0 +1,9 @@
+// Package shell quotes the words of POSIX shell command lines run on the server.
+package shell
+
+import "strings"
+
+// Quote quotes s for use as a single shell word.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
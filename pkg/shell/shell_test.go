@@ -0,0 +1,19 @@
+package shell
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuote(t *testing.T) {
+	assert.Equal(t, `'plain'`, Quote("plain"))
+	assert.Equal(t, `'it'\''s $HOME'`, Quote("it's $HOME"))
+	assert.Equal(t, `''`, Quote(""))
+
+	output, err := exec.Command("sh", "-c", "printf '%s|' "+Quote("it's $HOME")+" "+Quote("")+" "+Quote("a\nb")).Output()
+	require.NoError(t, err)
+	assert.Equal(t, "it's $HOME||a\nb|", string(output))
+}
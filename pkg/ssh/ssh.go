@@ -2,11 +2,15 @@ package ssh
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -28,7 +32,7 @@ func NewSSHClientWithKey(host string, port int, user string, key []byte) (*ssh.C
 		Timeout:         10 * time.Second,
 	}
 
-	addr := fmt.Sprintf("%s:%d", host, port)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
 
 	conn, err := net.DialTimeout("tcp", addr, config.Timeout)
 	if err != nil {
@@ -58,7 +62,7 @@ func NewSSHClientWithPassword(host string, port string, user string, password st
 		Timeout:         10 * time.Second,
 	}
 
-	addr := fmt.Sprintf("%s:%s", host, port)
+	addr := net.JoinHostPort(host, port)
 
 	client, err := ssh.Dial("tcp", addr, config)
 	if err != nil {
@@ -73,33 +77,48 @@ var sshKeyPath string
 
 // FindSSHKey looks for an SSH key in the given path or in default locations
 func FindSSHKey(keyPath string) ([]byte, error) {
-	if keyPath != "" {
-		if strings.HasPrefix(keyPath, "~") {
-			home, err := os.UserHomeDir()
-			if err != nil {
-				return nil, fmt.Errorf("failed to get home directory: %w", err)
-			}
-			keyPath = filepath.Join(home, keyPath[1:])
+	path, err := KeyPath(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(path)
+}
+
+// KeyPath returns the path of the SSH private key configured as keyPath: keyPath itself, with a
+// leading ~ expanded to the home directory, or the first default key in ~/.ssh when it is empty.
+// Relative paths are relative to the working directory, except that a bare file name missing
+// there, like id_ed25519, names a key in ~/.ssh.
+func KeyPath(keyPath string) (string, error) {
+	if keyPath == "~" || strings.HasPrefix(keyPath, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
 		}
+		return filepath.Join(home, keyPath[1:]), nil
+	}
 
-		return os.ReadFile(keyPath)
+	if keyPath != "" && (filepath.Base(keyPath) != keyPath || fileExists(keyPath)) {
+		return keyPath, nil
 	}
 
 	sshDir, err := getSSHDir()
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	if keyPath != "" {
+		return filepath.Join(sshDir, keyPath), nil
 	}
 
 	keyNames := []string{"id_rsa", "id_ecdsa", "id_ed25519"}
 	for _, name := range keyNames {
 		path := filepath.Join(sshDir, name)
-		key, err := os.ReadFile(path)
-		if err == nil {
-			return key, nil
+		if fileExists(path) {
+			return path, nil
 		}
 	}
 
-	return nil, fmt.Errorf("no suitable SSH key found in %s", sshDir)
+	return "", fmt.Errorf("no suitable SSH key found in %s", sshDir)
 }
 
 // FindKeyAndConnectWithUser finds an SSH key and establishes a connection
@@ -117,6 +136,65 @@ func FindKeyAndConnectWithUser(host string, port int, user, keyPath string) (*ss
 	return client, key, nil
 }
 
+// ConnectWithKeyOrPassword connects like FindKeyAndConnectWithUser and falls back to password
+// authentication when that fails and a password is given. The returned key is nil when the
+// password was used.
+func ConnectWithKeyOrPassword(host string, port int, user, keyPath, password string) (*ssh.Client, []byte, error) {
+	client, key, err := FindKeyAndConnectWithUser(host, port, user, keyPath)
+	if err == nil || password == "" {
+		return client, key, err
+	}
+
+	client, passwordErr := NewSSHClientWithPassword(host, strconv.Itoa(port), user, password)
+	if passwordErr != nil {
+		return nil, nil, fmt.Errorf("%w; password authentication failed: %v", err, passwordErr)
+	}
+	return client, nil, nil
+}
+
+// GenerateKey writes a new ed25519 private key to path and its public key to path.pub,
+// creating the directory if needed. It fails if path already exists.
+func GenerateKey(path string) error {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(privateKey, "ftl")
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal public key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	if err := pem.Encode(file, block); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+
+	if err := os.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(sshPublicKey), 0644); err != nil {
+		return fmt.Errorf("failed to write public key: %w", err)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
 // getSSHDir returns the SSH directory path
 func getSSHDir() (string, error) {
 	if sshKeyPath != "" {
@@ -189,6 +267,60 @@ func CreateSSHTunnel(ctx context.Context, host string, port int, user, keyPath,
 	}
 }
 
+// CreateReverseTunnel establishes an SSH tunnel from a port on the remote host back to a local address.
+// It listens on remotePort on the server's loopback interface and forwards connections to localAddr.
+// The remote listener is closed when ctx is canceled so the port isn't left bound on the server.
+func CreateReverseTunnel(ctx context.Context, host string, port int, user, keyPath string, remotePort int, localAddr string) error {
+	client, _, err := FindKeyAndConnectWithUser(host, port, user, keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to establish SSH connection: %v", err)
+	}
+	defer client.Close()
+
+	remoteListener, err := client.ListenTCP(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: remotePort})
+	if err != nil {
+		return fmt.Errorf("failed to listen on remote port %d: %v", remotePort, err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+				if err != nil {
+					fmt.Printf("Failed to send keep-alive packet: %v\n", err)
+					return
+				}
+			case <-ctx.Done():
+				// Closing the listener cancels the remote port forwarding and unblocks Accept.
+				_ = remoteListener.Close()
+				return
+			}
+		}
+	}()
+
+	for {
+		remoteConn, err := remoteListener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept remote connection: %v", err)
+		}
+
+		localConn, err := net.Dial("tcp", localAddr)
+		if err != nil {
+			fmt.Printf("Failed to dial local address %s: %v\n", localAddr, err)
+			remoteConn.Close()
+			continue
+		}
+
+		go handleConnection(localConn, remoteConn)
+	}
+}
+
 // handleConnection copies data between local and remote connections
 func handleConnection(localConn, remoteConn net.Conn) {
 	defer localConn.Close()
@@ -6,6 +6,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestFindSSHKey(t *testing.T) {
@@ -49,3 +51,65 @@ func TestFindSSHKey(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, key)
 }
+
+func TestKeyPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	sshDir := filepath.Join(home, ".ssh")
+	require.NoError(t, os.MkdirAll(sshDir, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(sshDir, "id_ed25519"), []byte("test-key"), 0600))
+
+	originalDir := sshKeyPath
+	sshKeyPath = sshDir
+	t.Cleanup(func() { sshKeyPath = originalDir })
+
+	tests := []struct {
+		keyPath string
+		want    string
+	}{
+		{"/etc/secrets/key", "/etc/secrets/key"},
+		{"./deploy_keys/ci_key", "./deploy_keys/ci_key"},
+		{"deploy_keys/ci_key", "deploy_keys/ci_key"},
+		{"~/.ssh/id_ftl", filepath.Join(home, ".ssh", "id_ftl")},
+		{"~/keys/id_rsa", filepath.Join(home, "keys", "id_rsa")},
+		// A bare name missing from the working directory is a key in ~/.ssh.
+		{"id_ftl", filepath.Join(sshDir, "id_ftl")},
+		{"ssh_test.go", "ssh_test.go"},
+		{"", filepath.Join(sshDir, "id_ed25519")},
+	}
+
+	for _, tt := range tests {
+		path, err := KeyPath(tt.keyPath)
+		require.NoError(t, err, tt.keyPath)
+		assert.Equal(t, tt.want, path, tt.keyPath)
+	}
+
+	require.NoError(t, os.Remove(filepath.Join(sshDir, "id_ed25519")))
+	_, err := KeyPath("")
+	assert.EqualError(t, err, "no suitable SSH key found in "+sshDir)
+}
+
+func TestGenerateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".ssh", "id_ftl")
+
+	require.NoError(t, GenerateKey(path))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	privateKey, err := os.ReadFile(path)
+	require.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	require.NoError(t, err)
+
+	publicKey, err := os.ReadFile(path + ".pub")
+	require.NoError(t, err)
+	assert.Equal(t, string(ssh.MarshalAuthorizedKey(signer.PublicKey())), string(publicKey))
+
+	// An existing key is never overwritten.
+	assert.Error(t, GenerateKey(path))
+	unchanged, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, privateKey, unchanged)
+}
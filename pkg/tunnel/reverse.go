@@ -0,0 +1,107 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yarlson/ftl/pkg/config"
+	"github.com/yarlson/ftl/pkg/ssh"
+)
+
+// ReverseConfig describes a remote port that is forwarded back to a local port.
+type ReverseConfig struct {
+	LocalPort  int `json:"local_port"`
+	RemotePort int `json:"remote_port"`
+}
+
+// CollectReverseTunnels returns the reverse tunnels configured in the dev section.
+func CollectReverseTunnels(cfg *config.Config) []ReverseConfig {
+	var tunnels []ReverseConfig
+	for _, rt := range cfg.Dev.ReverseTunnels {
+		tunnels = append(tunnels, ReverseConfig{LocalPort: rt.Local, RemotePort: rt.Remote})
+	}
+	return tunnels
+}
+
+// ParseReverseSpec parses a "local=3000,remote=8081" reverse tunnel specification.
+func ParseReverseSpec(spec string) (ReverseConfig, error) {
+	var rc ReverseConfig
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return ReverseConfig{}, fmt.Errorf("invalid reverse tunnel %q: expected local=PORT,remote=PORT", spec)
+		}
+
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return ReverseConfig{}, fmt.Errorf("invalid reverse tunnel %q: invalid port %q", spec, value)
+		}
+
+		switch key {
+		case "local":
+			rc.LocalPort = port
+		case "remote":
+			rc.RemotePort = port
+		default:
+			return ReverseConfig{}, fmt.Errorf("invalid reverse tunnel %q: unknown key %q", spec, key)
+		}
+	}
+
+	if rc.LocalPort == 0 || rc.RemotePort == 0 {
+		return ReverseConfig{}, fmt.Errorf("invalid reverse tunnel %q: both local and remote ports are required", spec)
+	}
+
+	return rc, nil
+}
+
+// StartReverseTunnels spawns one goroutine per reverse tunnel, each calling ssh.CreateReverseTunnel.
+// The returned channel is closed once every tunnel has stopped and released its remote port.
+func StartReverseTunnels(
+	ctx context.Context,
+	host string,
+	port int,
+	user, sshKey string,
+	tunnels []ReverseConfig,
+) (<-chan struct{}, error) {
+	if len(tunnels) == 0 {
+		return nil, fmt.Errorf("no reverse tunnels to establish")
+	}
+
+	var wg sync.WaitGroup
+	errorChan := make(chan error, len(tunnels))
+	done := make(chan struct{})
+
+	for _, t := range tunnels {
+		wg.Add(1)
+		go func(tun ReverseConfig) {
+			defer wg.Done()
+
+			localAddr := net.JoinHostPort("localhost", strconv.Itoa(tun.LocalPort))
+			err := ssh.CreateReverseTunnel(ctx, host, port, user, sshKey, tun.RemotePort, localAddr)
+			if err != nil {
+				errorChan <- fmt.Errorf("reverse tunnel %d -> %s failed: %v", tun.RemotePort, localAddr, err)
+			}
+		}(t)
+	}
+
+	go func() {
+		wg.Wait()
+		close(errorChan)
+		close(done)
+	}()
+
+	select {
+	case err := <-errorChan:
+		if err != nil {
+			return done, err
+		}
+	case <-time.After(2 * time.Second):
+	}
+
+	return done, nil
+}
@@ -0,0 +1,34 @@
+package tunnel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestParseReverseSpec(t *testing.T) {
+	rc, err := ParseReverseSpec("local=3000,remote=8081")
+	assert.NoError(t, err)
+	assert.Equal(t, ReverseConfig{LocalPort: 3000, RemotePort: 8081}, rc)
+
+	rc, err = ParseReverseSpec("remote=8081, local=3000")
+	assert.NoError(t, err)
+	assert.Equal(t, ReverseConfig{LocalPort: 3000, RemotePort: 8081}, rc)
+
+	for _, spec := range []string{"", "local=3000", "local=3000,remote=http", "local=3000,remote=70000", "local=3000,port=8081"} {
+		_, err := ParseReverseSpec(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestCollectReverseTunnels(t *testing.T) {
+	cfg := &config.Config{
+		Dev: config.Dev{
+			ReverseTunnels: []config.ReverseTunnel{{Local: 3000, Remote: 8081}},
+		},
+	}
+
+	assert.Equal(t, []ReverseConfig{{LocalPort: 3000, RemotePort: 8081}}, CollectReverseTunnels(cfg))
+}
@@ -0,0 +1,255 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/yarlson/ftl/pkg/ssh"
+)
+
+const (
+	socksVersion = 0x05
+
+	socksMethodNoAuth       = 0x00
+	socksMethodNoAcceptable = 0xff
+
+	socksCmdConnect = 0x01
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksReplySucceeded           = 0x00
+	socksReplyNotAllowed          = 0x02
+	socksReplyHostUnreachable     = 0x04
+	socksReplyCommandNotSupported = 0x07
+	socksReplyAddrNotSupported    = 0x08
+)
+
+// Dialer opens TCP connections, typically through an SSH client.
+type Dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+// SOCKSServer is a minimal SOCKS5 server supporting the CONNECT command without authentication.
+type SOCKSServer struct {
+	dialer  Dialer
+	allowed []*net.IPNet
+}
+
+// NewSOCKSServer creates a SOCKS5 server that dials targets through dialer.
+// If allowed is not empty, only IP targets inside one of the networks are permitted.
+func NewSOCKSServer(dialer Dialer, allowed []*net.IPNet) *SOCKSServer {
+	return &SOCKSServer{dialer: dialer, allowed: allowed}
+}
+
+// Serve accepts connections on listener until ctx is canceled.
+func (s *SOCKSServer) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept SOCKS connection: %w", err)
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *SOCKSServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	if err := s.negotiate(conn); err != nil {
+		return
+	}
+
+	target, err := s.readRequest(conn)
+	if err != nil {
+		return
+	}
+
+	remoteConn, err := s.dialer.Dial("tcp", target)
+	if err != nil {
+		_ = writeSOCKSReply(conn, socksReplyHostUnreachable)
+		return
+	}
+
+	if err := writeSOCKSReply(conn, socksReplySucceeded); err != nil {
+		remoteConn.Close()
+		return
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	pipe(conn, remoteConn)
+}
+
+// negotiate reads the client greeting and selects the "no authentication" method.
+func (s *SOCKSServer) negotiate(conn net.Conn) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[0] != socksVersion {
+		return fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return err
+	}
+
+	for _, method := range methods {
+		if method == socksMethodNoAuth {
+			_, err := conn.Write([]byte{socksVersion, socksMethodNoAuth})
+			return err
+		}
+	}
+
+	_, _ = conn.Write([]byte{socksVersion, socksMethodNoAcceptable})
+	return errors.New("no acceptable authentication method")
+}
+
+// readRequest reads a CONNECT request and returns the target address.
+func (s *SOCKSServer) readRequest(conn net.Conn) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	if header[1] != socksCmdConnect {
+		_ = writeSOCKSReply(conn, socksReplyCommandNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS command %d", header[1])
+	}
+
+	var host string
+	var ip net.IP
+	switch header[3] {
+	case socksAddrIPv4:
+		ip = make(net.IP, net.IPv4len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAddrIPv6:
+		ip = make(net.IP, net.IPv6len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+		ip = net.ParseIP(host)
+	default:
+		_ = writeSOCKSReply(conn, socksReplyAddrNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS address type %d", header[3])
+	}
+
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(conn, portBytes); err != nil {
+		return "", err
+	}
+	port := binary.BigEndian.Uint16(portBytes)
+
+	if !s.permitted(ip) {
+		_ = writeSOCKSReply(conn, socksReplyNotAllowed)
+		return "", fmt.Errorf("target %s is not allowed", host)
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+// permitted reports whether the target may be dialed. When the server is restricted to
+// networks, domain names are rejected because they are resolved on the remote side.
+func (s *SOCKSServer) permitted(ip net.IP) bool {
+	if len(s.allowed) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, network := range s.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSOCKSReply sends a reply with an unspecified bound address.
+func writeSOCKSReply(conn net.Conn, reply byte) error {
+	_, err := conn.Write([]byte{socksVersion, reply, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// pipe copies data in both directions until either side is closed.
+func pipe(a, b net.Conn) {
+	defer a.Close()
+	defer b.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// StartSOCKSProxy connects to the server and serves a SOCKS5 proxy on localhost:localPort
+// that routes connections through the SSH connection until ctx is canceled.
+func StartSOCKSProxy(
+	ctx context.Context,
+	host string,
+	port int,
+	user, sshKey string,
+	localPort int,
+	allowed []*net.IPNet,
+) error {
+	client, _, err := ssh.FindKeyAndConnectWithUser(host, port, user, sshKey)
+	if err != nil {
+		return fmt.Errorf("failed to establish SSH connection: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(localPort)))
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("failed to listen on local port %d: %w", localPort, err)
+	}
+
+	go func() {
+		defer client.Close()
+		if err := NewSOCKSServer(client, allowed).Serve(ctx, listener); err != nil {
+			fmt.Printf("SOCKS proxy stopped: %v\n", err)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startEchoServer(t *testing.T) *net.TCPAddr {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr)
+}
+
+func startSOCKSServer(t *testing.T, allowed []*net.IPNet) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go func() { _ = NewSOCKSServer(&net.Dialer{}, allowed).Serve(ctx, listener) }()
+
+	return listener.Addr().String()
+}
+
+// socksConnect performs a SOCKS5 handshake and CONNECT to target, returning the reply code.
+func socksConnect(t *testing.T, proxy string, target *net.TCPAddr) (net.Conn, byte) {
+	conn, err := net.Dial("tcp", proxy)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	_, err = conn.Write([]byte{socksVersion, 1, socksMethodNoAuth})
+	require.NoError(t, err)
+
+	method := make([]byte, 2)
+	_, err = io.ReadFull(conn, method)
+	require.NoError(t, err)
+	require.Equal(t, []byte{socksVersion, socksMethodNoAuth}, method)
+
+	request := []byte{socksVersion, socksCmdConnect, 0x00, socksAddrIPv4}
+	request = append(request, target.IP.To4()...)
+	request = binary.BigEndian.AppendUint16(request, uint16(target.Port))
+	_, err = conn.Write(request)
+	require.NoError(t, err)
+
+	reply := make([]byte, 10)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+
+	return conn, reply[1]
+}
+
+func TestSOCKSServer_Connect(t *testing.T) {
+	target := startEchoServer(t)
+	proxy := startSOCKSServer(t, nil)
+
+	conn, reply := socksConnect(t, proxy, target)
+	require.Equal(t, byte(socksReplySucceeded), reply)
+
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestSOCKSServer_RestrictedToNetworks(t *testing.T) {
+	target := startEchoServer(t)
+
+	_, docker, err := net.ParseCIDR("172.18.0.0/16")
+	require.NoError(t, err)
+	proxy := startSOCKSServer(t, []*net.IPNet{docker})
+
+	_, reply := socksConnect(t, proxy, target)
+	assert.Equal(t, byte(socksReplyNotAllowed), reply)
+
+	_, loopback, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+	proxy = startSOCKSServer(t, []*net.IPNet{loopback})
+
+	_, reply = socksConnect(t, proxy, target)
+	assert.Equal(t, byte(socksReplySucceeded), reply)
+}
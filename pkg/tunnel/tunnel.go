@@ -2,7 +2,15 @@ package tunnel
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,11 +21,48 @@ import (
 
 // Config describes which local port should forward to which remote address.
 type Config struct {
-	LocalPort  string
-	RemoteAddr string
+	Dependency string `json:"dependency,omitempty"`
+	LocalPort  string `json:"local_port"`
+	RemoteAddr string `json:"remote_addr"`
+}
+
+// registry keeps track of the tunnels started by this process.
+type registry struct {
+	mu      sync.Mutex
+	tunnels map[string]Config
+}
+
+var active = &registry{tunnels: make(map[string]Config)}
+
+func (r *registry) add(tun Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tunnels[tun.LocalPort] = tun
+}
+
+func (r *registry) remove(tun Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tunnels, tun.LocalPort)
+}
+
+// Active returns the tunnels started by StartTunnels that are still running, ordered by local port.
+func Active() []Config {
+	active.mu.Lock()
+	defer active.mu.Unlock()
+
+	tunnels := make([]Config, 0, len(active.tunnels))
+	for _, tun := range active.tunnels {
+		tunnels = append(tunnels, tun)
+	}
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].LocalPort < tunnels[j].LocalPort
+	})
+	return tunnels
 }
 
 // StartTunnels spawns one goroutine per tunnel, each calling ssh.CreateSSHTunnel.
+// Running tunnels are registered and can be listed with Active.
 func StartTunnels(
 	ctx context.Context,
 	host string,
@@ -37,6 +82,9 @@ func StartTunnels(
 		go func(tun Config) {
 			defer wg.Done()
 
+			active.add(tun)
+			defer active.remove(tun)
+
 			err := ssh.CreateSSHTunnel(ctx, host, port, user, sshKey, tun.LocalPort, tun.RemoteAddr)
 			if err != nil {
 				errorChan <- fmt.Errorf("tunnel %s -> %s failed: %v",
@@ -62,15 +110,183 @@ func StartTunnels(
 	return nil
 }
 
+// CollectDependencyTunnels returns one tunnel per dependency port, bound locally to the
+// port configured in the dependency's tunnel_ports or to the same port number otherwise.
 func CollectDependencyTunnels(cfg *config.Config) []Config {
 	var tunnels []Config
 	for _, dep := range cfg.Dependencies {
+		if dep.ExposeMode() == config.ExposeNone {
+			continue
+		}
 		for _, port := range dep.Ports {
 			tunnels = append(tunnels, Config{
-				LocalPort:  fmt.Sprintf("%d", port),
+				Dependency: dep.Name,
+				LocalPort:  fmt.Sprintf("%d", dep.TunnelLocalPort(port)),
 				RemoteAddr: fmt.Sprintf("localhost:%d", port),
 			})
 		}
 	}
 	return tunnels
 }
+
+// ApplyPortOverrides rebinds tunnels according to "dependency=local:remote" overrides.
+func ApplyPortOverrides(tunnels []Config, overrides []string) ([]Config, error) {
+	result := make([]Config, len(tunnels))
+	copy(result, tunnels)
+
+	for _, override := range overrides {
+		dep, mapping, ok := strings.Cut(override, "=")
+		if !ok || dep == "" {
+			return nil, fmt.Errorf("invalid port override %q: expected dependency=local:remote", override)
+		}
+
+		local, remote, err := config.ParsePortMapping(mapping)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port override %q: %w", override, err)
+		}
+
+		found := false
+		for i, tun := range result {
+			if tun.Dependency == dep && tun.RemoteAddr == fmt.Sprintf("localhost:%d", remote) {
+				result[i].LocalPort = strconv.Itoa(local)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid port override %q: dependency %s does not expose port %d", override, dep, remote)
+		}
+	}
+
+	return result, nil
+}
+
+// AssignFreePorts moves tunnels whose local port is already taken to the next free port.
+// It returns the adjusted tunnels and a description of every port that was moved.
+func AssignFreePorts(tunnels []Config) ([]Config, []string, error) {
+	result := make([]Config, len(tunnels))
+	copy(result, tunnels)
+
+	used := make(map[int]bool)
+	var moved []string
+
+	for i, tun := range result {
+		port, err := strconv.Atoi(tun.LocalPort)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid local port %q: %w", tun.LocalPort, err)
+		}
+
+		free := port
+		for used[free] || !portAvailable(free) {
+			free++
+			if free > 65535 || free-port > 100 {
+				return nil, nil, fmt.Errorf("no free local port found for %s near %d", tun.RemoteAddr, port)
+			}
+		}
+		used[free] = true
+
+		if free != port {
+			result[i].LocalPort = strconv.Itoa(free)
+			moved = append(moved, fmt.Sprintf("%s: local port %d is in use, using %d -> %s", tun.Dependency, port, free, tun.RemoteAddr))
+		}
+	}
+
+	return result, moved, nil
+}
+
+func portAvailable(port int) bool {
+	listener, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	_ = listener.Close()
+	return true
+}
+
+// State describes the tunnels opened by a running `ftl tunnels` process.
+type State struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+	Tunnels []Config  `json:"tunnels"`
+}
+
+// StatePath returns the location of the tunnel state file for the project.
+func StatePath(project string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache directory: %w", err)
+	}
+
+	return filepath.Join(cacheDir, "ftl", "tunnels", project+".json"), nil
+}
+
+// SaveState records the tunnels opened for the project so other commands can query them.
+func SaveState(project string, state State) error {
+	path, err := StatePath(project)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create tunnel state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal tunnel state: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write tunnel state: %w", err)
+	}
+
+	return nil
+}
+
+// LoadState reads the recorded tunnel state for the project.
+// It returns nil if no tunnels were recorded.
+func LoadState(project string) (*State, error) {
+	path, err := StatePath(project)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tunnel state: %w", err)
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse tunnel state: %w", err)
+	}
+
+	return &state, nil
+}
+
+// RemoveState deletes the recorded tunnel state for the project.
+func RemoveState(project string) error {
+	path, err := StatePath(project)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove tunnel state: %w", err)
+	}
+
+	return nil
+}
+
+// IsListening reports whether something accepts connections on the given local port.
+func IsListening(localPort string) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("localhost", localPort), 500*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
@@ -0,0 +1,77 @@
+package tunnel
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yarlson/ftl/pkg/config"
+)
+
+func TestCollectDependencyTunnels_TunnelPorts(t *testing.T) {
+	cfg := &config.Config{
+		Dependencies: []config.Dependency{
+			{Name: "postgres", Ports: []int{5432}, TunnelPorts: []string{"15432:5432"}},
+			{Name: "redis", Ports: []int{6379}},
+		},
+	}
+
+	tunnels := CollectDependencyTunnels(cfg)
+
+	assert.Equal(t, []Config{
+		{Dependency: "postgres", LocalPort: "15432", RemoteAddr: "localhost:5432"},
+		{Dependency: "redis", LocalPort: "6379", RemoteAddr: "localhost:6379"},
+	}, tunnels)
+}
+
+func TestCollectDependencyTunnels_SkipsUnexposed(t *testing.T) {
+	cfg := &config.Config{
+		Dependencies: []config.Dependency{
+			{Name: "postgres", Ports: []int{5432}, Expose: config.ExposeNone},
+			{Name: "redis", Ports: []int{6379}, Expose: config.ExposeTunnel},
+		},
+	}
+
+	tunnels := CollectDependencyTunnels(cfg)
+
+	assert.Equal(t, []Config{
+		{Dependency: "redis", LocalPort: "6379", RemoteAddr: "localhost:6379"},
+	}, tunnels)
+}
+
+func TestApplyPortOverrides(t *testing.T) {
+	tunnels := []Config{
+		{Dependency: "postgres", LocalPort: "5432", RemoteAddr: "localhost:5432"},
+	}
+
+	result, err := ApplyPortOverrides(tunnels, []string{"postgres=25432:5432"})
+	require.NoError(t, err)
+	assert.Equal(t, "25432", result[0].LocalPort)
+	assert.Equal(t, "5432", tunnels[0].LocalPort)
+
+	_, err = ApplyPortOverrides(tunnels, []string{"postgres=25432:3306"})
+	assert.ErrorContains(t, err, "does not expose port 3306")
+
+	_, err = ApplyPortOverrides(tunnels, []string{"postgres:5432"})
+	assert.ErrorContains(t, err, "expected dependency=local:remote")
+}
+
+func TestAssignFreePorts(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	busy := listener.Addr().(*net.TCPAddr).Port
+	tunnels := []Config{
+		{Dependency: "postgres", LocalPort: strconv.Itoa(busy), RemoteAddr: "localhost:5432"},
+	}
+
+	result, moved, err := AssignFreePorts(tunnels)
+	require.NoError(t, err)
+	require.Len(t, moved, 1)
+	assert.NotEqual(t, strconv.Itoa(busy), result[0].LocalPort)
+	assert.Contains(t, moved[0], "is in use")
+}